@@ -1,5 +1,5 @@
 /*
-Copyright 2018 The Kubernetes Authors.
+Copyright 2021 The OpenEBS Authors.
 
 Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
@@ -14,19 +14,18 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package log
+package main
 
 import (
-	"github.com/go-logr/logr"
-
-	"sigs.k8s.io/controller-runtime/pkg/log"
+	mlogger "github.com/openebs/maya/pkg/logs"
+	"github.com/openebs/upgrade/cmd/upgrade/executor"
 )
 
-var (
-	// RuntimeLog is a base parent logger for use inside controller-runtime.
-	RuntimeLog logr.Logger
-)
+func main() {
+	// Init logging
+	mlogger.InitLogs()
+	defer mlogger.FlushLogs()
 
-func init() {
-	RuntimeLog = log.Log.WithName("controller-runtime")
+	err := executor.NewPlugin().Execute()
+	executor.CheckError(err)
 }
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	cleanupCmdHelpText = `
+This command deletes the successful UpgradeTasks which completed
+more than --older-than ago. The age can be a number of days like 7d
+or a duration like 12h.
+
+Usage: upgrade cleanup [--older-than=7d]
+`
+)
+
+// NewCleanupJob deletes the successful UpgradeTasks
+func NewCleanupJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "cleanup",
+		Short:   "Delete successful UpgradeTasks",
+		Long:    cleanupCmdHelpText,
+		Example: `upgrade cleanup --older-than=7d`,
+		// the cleanup needs no upgrade permissions, so the
+		// self test of the upgrade is not run
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			CheckError(initFromEnv(cmd))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			olderThan, err := parseAge(options.olderThan)
+			if err != nil {
+				CheckError(err)
+			}
+			CheckError(options.RunCleanupJob(olderThan))
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.olderThan,
+		"older-than", "",
+		options.olderThan,
+		"[optional] minimum age of the successful upgradetasks to delete, e.g. 7d or 12h.")
+
+	return cmd
+}
+
+// RunCleanupJob deletes the successful upgradetasks older than olderThan.
+func (u *UpgradeOptions) RunCleanupJob(olderThan time.Duration) error {
+	deleted, err := upgrade.Cleanup(u.openebsNamespace, olderThan, u.clientOptions()...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to cleanup upgradetasks")
+	}
+	klog.Infof("Deleted %d upgradetasks", deleted)
+	return nil
+}
+
+// RunCleanup deletes all the successful upgradetasks at the end of
+// the upgrade if --cleanup is set. Any failure is only logged as the
+// upgrade itself was successful.
+func (u *UpgradeOptions) RunCleanup() {
+	if !u.cleanup || u.isDryRun() {
+		return
+	}
+	deleted, err := upgrade.Cleanup(u.openebsNamespace, 0, u.clientOptions()...)
+	if err != nil {
+		klog.Warningf("Failed to cleanup upgradetasks: %v", err)
+		return
+	}
+	klog.Infof("Deleted %d successful upgradetasks", deleted)
+}
+
+// parseAge parses a number of days like 7d or a go duration like 12h
+func parseAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil || days < 0 {
+			return 0, errors.Errorf("invalid age %s", age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil || d < 0 {
+		return 0, errors.Errorf("invalid age %s", age)
+	}
+	return d, nil
+}
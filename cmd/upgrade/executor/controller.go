@@ -0,0 +1,354 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	openebsclientset "github.com/openebs/api/v3/pkg/client/clientset/versioned"
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	errors "github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+)
+
+var (
+	controllerCmdHelpText = `
+This command runs the upgrade as a controller which upgrades the
+resources of the pending UpgradeTasks in the openebs namespace.
+
+A failed UpgradeTask is requeued with an exponential backoff starting
+at 30s, doubled on every retry up to 30m, with a random jitter of 20%.
+These retries happen inside the controller, unlike the backoffLimit of
+the upgrade Job which restarts the whole Job. After --max-retries
+failures the UpgradeTask is marked as failed.
+
+With --upgrade-plans the controller also creates the UpgradeTasks of
+the UpgradePlans in the openebs namespace, and aggregates their phases
+in the status of the UpgradePlans.
+
+The queued upgrades are paused while more than
+--pause-on-pressure-threshold percent of the nodes are under memory
+pressure, and resumed once it drops below --resume-on-pressure-threshold
+percent. An upgrade in progress is not interrupted.
+
+With --leader-elect the replicas of the controller elect a leader using
+the lease --leader-elect-lease-name, which defaults to the openebs
+namespace. Only the leader upgrades the resources, the other replicas
+wait on standby and take over once the leader stops renewing the lease.
+
+Usage: upgrade controller [--selector=<label>] [--resync-period=1m] [--max-retries=0] [--upgrade-plans]
+                          [--pause-on-pressure-threshold=85] [--resume-on-pressure-threshold=70]
+                          [--leader-elect] [--leader-elect-lease-name=openebs-upgrade-controller]
+`
+)
+
+// ControllerOptions stores the information required to run the controller
+type ControllerOptions struct {
+	selector     string
+	resyncPeriod time.Duration
+	maxRetries   int
+	upgradePlans bool
+	// pauseThreshold and resumeThreshold are the percentages of the
+	// nodes under memory pressure to pause and resume the upgrades at
+	pauseThreshold  int
+	resumeThreshold int
+	// leaderElect runs the controller only while
+	// it holds the lease of the leaderElection
+	leaderElect    bool
+	leaderElection upgrader.LeaderElectionConfig
+}
+
+var controllerOptions = &ControllerOptions{
+	resyncPeriod:    time.Minute,
+	pauseThreshold:  upgrader.DefaultPauseOnPressureThreshold,
+	resumeThreshold: upgrader.DefaultResumeOnPressureThreshold,
+	leaderElection: upgrader.LeaderElectionConfig{
+		LeaseName:     upgrader.DefaultLeaseName,
+		LeaseDuration: upgrader.DefaultLeaseDuration,
+		RenewDeadline: upgrader.DefaultRenewDeadline,
+		RetryPeriod:   upgrader.DefaultRetryPeriod,
+	},
+}
+
+// NewControllerJob runs the upgrade of the pending UpgradeTasks as a controller
+func NewControllerJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "controller",
+		Short:   "Upgrade the resources of the pending UpgradeTasks as a controller",
+		Long:    controllerCmdHelpText,
+		Example: `upgrade controller --max-retries=10`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-sigCh
+				klog.Info("Stopping the upgrade controller")
+				cancel()
+			}()
+			CheckError(options.RunController(ctx, cmd, controllerOptions))
+		},
+	}
+
+	cmd.Flags().StringVarP(&controllerOptions.selector,
+		"selector", "",
+		controllerOptions.selector,
+		"[optional] label selector of the upgradetasks handled by the controller.")
+
+	cmd.Flags().DurationVarP(&controllerOptions.resyncPeriod,
+		"resync-period", "",
+		controllerOptions.resyncPeriod,
+		"[optional] interval to list the pending upgradetasks.")
+
+	cmd.Flags().IntVarP(&controllerOptions.maxRetries,
+		"max-retries", "",
+		controllerOptions.maxRetries,
+		"[optional] number of retries after which an upgradetask is marked as failed, 0 retries forever.")
+
+	cmd.Flags().BoolVarP(&controllerOptions.upgradePlans,
+		"upgrade-plans", "",
+		controllerOptions.upgradePlans,
+		"[optional] create and orchestrate the upgradetasks of the upgradeplans in the openebs namespace.")
+
+	cmd.Flags().IntVarP(&controllerOptions.pauseThreshold,
+		"pause-on-pressure-threshold", "",
+		controllerOptions.pauseThreshold,
+		"[optional] percentage of the nodes under memory pressure above which the queued upgrades are paused.")
+
+	cmd.Flags().IntVarP(&controllerOptions.resumeThreshold,
+		"resume-on-pressure-threshold", "",
+		controllerOptions.resumeThreshold,
+		"[optional] percentage of the nodes under memory pressure below which the paused upgrades are resumed.")
+
+	cmd.Flags().BoolVarP(&controllerOptions.leaderElect,
+		"leader-elect", "",
+		controllerOptions.leaderElect,
+		"[optional] elect a leader among the replicas of the controller, only the leader upgrades the resources.")
+
+	cmd.Flags().StringVarP(&controllerOptions.leaderElection.LeaseName,
+		"leader-elect-lease-name", "",
+		controllerOptions.leaderElection.LeaseName,
+		"[optional] name of the lease the leader is elected with.")
+
+	cmd.Flags().StringVarP(&controllerOptions.leaderElection.LeaseNamespace,
+		"leader-elect-lease-namespace", "",
+		controllerOptions.leaderElection.LeaseNamespace,
+		"[optional] namespace of the lease the leader is elected with, defaults to the openebs namespace.")
+
+	cmd.Flags().DurationVarP(&controllerOptions.leaderElection.LeaseDuration,
+		"leader-elect-lease-duration", "",
+		controllerOptions.leaderElection.LeaseDuration,
+		"[optional] time the standby replicas wait before taking over the lease of a leader which stopped renewing it.")
+
+	cmd.Flags().DurationVarP(&controllerOptions.leaderElection.RenewDeadline,
+		"leader-elect-renew-deadline", "",
+		controllerOptions.leaderElection.RenewDeadline,
+		"[optional] time the leader retries renewing the lease for before it stops leading.")
+
+	cmd.Flags().DurationVarP(&controllerOptions.leaderElection.RetryPeriod,
+		"leader-elect-retry-period", "",
+		controllerOptions.leaderElection.RetryPeriod,
+		"[optional] interval the lease is acquired or renewed at.")
+
+	return cmd
+}
+
+// upgradeTaskController upgrades the resources of the queued upgradetasks
+type upgradeTaskController struct {
+	*ControllerOptions
+	upgradeOptions *UpgradeOptions
+	cmd            *cobra.Command
+	client         openebsclientset.Interface
+	queue          workqueue.RateLimitingInterface
+	monitor        *upgrader.ClusterHealthMonitor
+}
+
+// RunController runs the upgradetask controller until the context is done
+func (u *UpgradeOptions) RunController(ctx context.Context, cmd *cobra.Command,
+	opts *ControllerOptions) error {
+	client, err := initClient(u)
+	if err != nil {
+		return &upgrader.Error{Kind: upgrader.ErrAPI, Cause: err}
+	}
+	kubeClient, err := initKubeClient(u)
+	if err != nil {
+		return &upgrader.Error{Kind: upgrader.ErrAPI, Cause: err}
+	}
+	monitor, err := upgrader.NewClusterHealthMonitor(kubeClient,
+		opts.pauseThreshold, opts.resumeThreshold)
+	if err != nil {
+		return err
+	}
+	c := &upgradeTaskController{
+		ControllerOptions: opts,
+		upgradeOptions:    u,
+		cmd:               cmd,
+		client:            client,
+		queue:             workqueue.NewRateLimitingQueue(upgrader.NewUpgradeTaskRateLimiter()),
+		monitor:           monitor,
+	}
+	defer c.queue.ShutDown()
+	if !opts.leaderElect {
+		c.run(ctx)
+		return nil
+	}
+	cfg := opts.leaderElection
+	if cfg.LeaseNamespace == "" {
+		cfg.LeaseNamespace = u.openebsNamespace
+	}
+	return upgrader.RunAsLeader(ctx, kubeClient, cfg, c.run)
+}
+
+// run runs the controller until the context is done
+func (c *upgradeTaskController) run(ctx context.Context) {
+	go c.monitor.Run(ctx)
+	go wait.Until(c.enqueuePending, c.resyncPeriod, ctx.Done())
+	// the upgrades are run one at a time
+	go wait.Until(func() { c.runWorker(ctx) }, time.Second, ctx.Done())
+	klog.Infof("Started the upgrade controller in namespace %s", c.upgradeOptions.openebsNamespace)
+	<-ctx.Done()
+}
+
+// enqueuePending adds the upgradetasks which are neither successful nor
+// failed to the queue. The upgradetasks waiting for a retry are skipped so
+// that the resync does not bypass their backoff. The upgradeplans are
+// reconciled first, so that the upgradetasks they create are added.
+func (c *upgradeTaskController) enqueuePending() {
+	if c.upgradePlans {
+		err := upgrade.ReconcileUpgradePlans(c.upgradeOptions.openebsNamespace,
+			c.upgradeOptions.clientOptions()...)
+		if err != nil {
+			klog.Errorf("failed to reconcile upgradeplans: %v", err)
+		}
+	}
+	utaskList, err := c.client.OpenebsV1alpha1().UpgradeTasks(c.upgradeOptions.openebsNamespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: c.selector})
+	if err != nil {
+		klog.Errorf("failed to list upgradetasks: %v", err)
+		return
+	}
+	for _, utaskObj := range utaskList.Items {
+		if utaskObj.Status.Phase == v1Alpha1API.UpgradeSuccess ||
+			utaskObj.Status.Phase == v1Alpha1API.UpgradeError {
+			continue
+		}
+		if c.queue.NumRequeues(utaskObj.Name) > 0 {
+			continue
+		}
+		c.queue.Add(utaskObj.Name)
+	}
+}
+
+// runWorker processes the queued upgradetasks, waiting before each
+// of them while the upgrades are paused by the health monitor
+func (c *upgradeTaskController) runWorker(ctx context.Context) {
+	for c.monitor.Wait(ctx) == nil && c.processNextItem() {
+	}
+}
+
+func (c *upgradeTaskController) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+	name := key.(string)
+	err := c.upgrade(name)
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+	retries := c.queue.NumRequeues(key) + 1
+	if c.maxRetries > 0 && retries > c.maxRetries {
+		klog.Errorf("upgradetask %s failed after %d retries: %v", name, c.maxRetries, err)
+		c.queue.Forget(key)
+		c.updatePhase(name, v1Alpha1API.UpgradeError, false)
+		return true
+	}
+	klog.Errorf("upgradetask %s failed, requeued for retry %d: %v", name, retries, err)
+	c.updatePhase(name, "", true)
+	c.queue.AddRateLimited(key)
+	return true
+}
+
+// upgrade runs the upgrade of the resource of the upgradetask
+func (c *upgradeTaskController) upgrade(name string) error {
+	utaskObj, err := c.client.OpenebsV1alpha1().UpgradeTasks(c.upgradeOptions.openebsNamespace).
+		Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get upgradetask %s", name)
+	}
+	if utaskObj.Status.Phase == v1Alpha1API.UpgradeSuccess {
+		return nil
+	}
+	// each task starts from the options passed as flags
+	o := *c.upgradeOptions
+	o.resourceKind = ""
+	o.name = ""
+	err = o.InitializeFromUpgradeTaskResource(*utaskObj)
+	if err == nil {
+		err = o.RunPreFlightChecks(c.cmd)
+	}
+	if err == nil {
+		err = o.RunResourceUpgradeChecks(c.cmd)
+	}
+	if err == nil {
+		err = o.InitializeDefaults(c.cmd)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "invalid upgradetask %s", name)
+	}
+	err = o.RunResourceUpgrade(c.cmd)
+	if err != nil {
+		return err
+	}
+	c.updatePhase(name, v1Alpha1API.UpgradeSuccess, false)
+	return nil
+}
+
+// updatePhase sets the phase of the upgradetask, and increments
+// the retries if retry is set, on a best effort basis
+func (c *upgradeTaskController) updatePhase(name string, phase v1Alpha1API.UpgradePhase, retry bool) {
+	utaskClient := c.client.OpenebsV1alpha1().UpgradeTasks(c.upgradeOptions.openebsNamespace)
+	utaskObj, err := utaskClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("failed to get upgradetask %s: %v", name, err)
+		return
+	}
+	if retry {
+		utaskObj.Status.Retries++
+	}
+	if phase != "" {
+		utaskObj.Status.Phase = phase
+		utaskObj.Status.CompletedTime = metav1.Now()
+	}
+	_, err = utaskClient.Update(context.TODO(), utaskObj, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("failed to update upgradetask %s: %v", name, err)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"testing"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newTestUpgradeTask(name string, spec v1Alpha1API.ResourceSpec, phase v1Alpha1API.UpgradePhase) *v1Alpha1API.UpgradeTask {
+	return &v1Alpha1API.UpgradeTask{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: upgradetesting.Namespace,
+			Labels:    map[string]string{"upgrade.openebs.io/test": "controller"},
+		},
+		Spec: v1Alpha1API.UpgradeTaskSpec{
+			FromVersion:  "2.12.0",
+			ToVersion:    testToVersion,
+			ResourceSpec: spec,
+		},
+		Status: v1Alpha1API.UpgradeTaskStatus{Phase: phase},
+	}
+}
+
+// newTestController returns a controller of the upgradetasks
+// created in the simulated cluster of newTestCSPIOptions
+func newTestController(t *testing.T, maxRetries int, utasks ...*v1Alpha1API.UpgradeTask) *upgradeTaskController {
+	t.Helper()
+	u := newTestCSPIOptions(t)
+	client := u.simulator.Client().OpenebsClientset
+	for _, utaskObj := range utasks {
+		_, err := client.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+			Create(context.TODO(), utaskObj, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &upgradeTaskController{
+		// the upgradetasks created by the upgrades are not selected
+		ControllerOptions: &ControllerOptions{selector: "upgrade.openebs.io/test=controller", maxRetries: maxRetries},
+		upgradeOptions:    u,
+		cmd:               &cobra.Command{},
+		client:            client,
+		queue:             workqueue.NewRateLimitingQueue(upgrader.NewUpgradeTaskRateLimiter()),
+	}
+}
+
+func TestUpgradeTaskController(t *testing.T) {
+	cspiSpec := v1Alpha1API.ResourceSpec{
+		CStorPoolInstance: &v1Alpha1API.CStorPoolInstance{CSPIName: "cspi-1"},
+	}
+	c := newTestController(t, 1,
+		newTestUpgradeTask("upgrade-cspi-1", cspiSpec, ""),
+		newTestUpgradeTask("upgrade-invalid", v1Alpha1API.ResourceSpec{}, ""),
+		newTestUpgradeTask("upgrade-done", cspiSpec, v1Alpha1API.UpgradeSuccess),
+	)
+	defer c.queue.ShutDown()
+
+	c.enqueuePending()
+	if c.queue.Len() != 2 {
+		t.Fatalf("enqueuePending() queued %d upgradetasks, want the 2 pending", c.queue.Len())
+	}
+	for i := 0; i < 2; i++ {
+		if !c.processNextItem() {
+			t.Fatalf("processNextItem() = false, want true")
+		}
+	}
+	getPhase := func(name string) (v1Alpha1API.UpgradePhase, int) {
+		utaskObj, err := c.client.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+			Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return utaskObj.Status.Phase, utaskObj.Status.Retries
+	}
+	if phase, _ := getPhase("upgrade-cspi-1"); phase != v1Alpha1API.UpgradeSuccess {
+		t.Errorf("processNextItem() phase of upgrade-cspi-1 = %s, want %s", phase, v1Alpha1API.UpgradeSuccess)
+	}
+	if phase, retries := getPhase("upgrade-invalid"); phase != "" || retries != 1 {
+		t.Errorf("processNextItem() upgrade-invalid is %q after %d retries, want a retry", phase, retries)
+	}
+
+	// the upgradetask waiting for its retry is not queued again by the resync
+	c.enqueuePending()
+	if c.queue.Len() != 0 {
+		t.Errorf("enqueuePending() queued %d upgradetasks, want none", c.queue.Len())
+	}
+	// the invalid upgradetask fails once the retries are exhausted,
+	// it is queued right away instead of waiting for the requeue delay
+	c.queue.Add("upgrade-invalid")
+	if !c.processNextItem() {
+		t.Fatalf("processNextItem() = false, want true")
+	}
+	if phase, _ := getPhase("upgrade-invalid"); phase != v1Alpha1API.UpgradeError {
+		t.Errorf("processNextItem() phase of upgrade-invalid = %s, want %s", phase, v1Alpha1API.UpgradeError)
+	}
+	if err := c.upgrade("upgrade-done"); err != nil {
+		t.Errorf("upgrade() of a successful upgradetask error = %v", err)
+	}
+	if err := c.upgrade("upgrade-missing"); err == nil {
+		t.Errorf("upgrade() of a missing upgradetask error = nil, want error")
+	}
+}
+
+func TestRunController(t *testing.T) {
+	u := newTestCSPIOptions(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err := u.RunController(ctx, &cobra.Command{}, &ControllerOptions{
+		resyncPeriod:    controllerOptions.resyncPeriod,
+		pauseThreshold:  controllerOptions.pauseThreshold,
+		resumeThreshold: controllerOptions.resumeThreshold,
+	})
+	if err != nil {
+		t.Errorf("RunController() error = %v", err)
+	}
+	err = u.RunController(ctx, &cobra.Command{}, &ControllerOptions{pauseThreshold: 10, resumeThreshold: 20})
+	if err == nil {
+		t.Errorf("RunController() with a resume threshold above the pause threshold error = nil, want error")
+	}
+}
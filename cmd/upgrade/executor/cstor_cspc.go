@@ -17,12 +17,15 @@ limitations under the License.
 package executor
 
 import (
+	"strings"
+	"sync"
+
 	"github.com/openebs/maya/pkg/util"
 	"github.com/spf13/cobra"
 	"k8s.io/klog"
 
 	upgrade "github.com/openebs/upgrade/pkg/upgrade"
-	"github.com/openebs/upgrade/pkg/version"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
 	errors "github.com/pkg/errors"
 )
 
@@ -43,35 +46,156 @@ func NewUpgradeCStorCSPCJob() *cobra.Command {
 		Long:    cstorCSPCUpgradeCmdHelpText,
 		Example: `upgrade cstor-cspc <spc-name>...`,
 		Run: func(cmd *cobra.Command, args []string) {
+			args = resourceArgs(args)
 			if len(args) == 0 {
 				util.Fatal("failed to upgrade: no cspc name provided")
 			}
-			for _, name := range args {
-				options.resourceKind = "cstorPoolCluster"
-				util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
-				util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
-				util.CheckErr(options.RunCStorCSPCUpgrade(cmd, name), util.Fatal)
+			if options.targetCSPI != "" && len(args) > 1 {
+				util.Fatal("failed to upgrade: --target-cspi needs a single cspc name")
+			}
+			options.resourceKind = "cstorPoolCluster"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(args))
+			if (options.nodeAwareScheduling || options.numaAware) && !options.isDryRun() {
+				CheckError(options.RunConcurrentCStorCSPCUpgrade(cmd, args))
+			} else {
+				for _, name := range args {
+					CheckError(options.RunCStorCSPCUpgrade(cmd, name))
+				}
 			}
+			CheckError(options.RunSmokeTests(args))
+			options.RunCleanup()
 		},
 	}
 
+	cmd.Flags().StringVarP(&options.maxUnavailable,
+		"max-unavailable", "",
+		options.maxUnavailable,
+		"[optional] maximum number or percentage of cspis that can be unavailable during the upgrade, e.g. 1 or 25%")
+
+	cmd.Flags().IntVarP(&options.minFreePoolSpace,
+		"min-free-pool-space", "",
+		options.minFreePoolSpace,
+		"[optional] minimum percentage of free space required on each cspi to upgrade it.")
+
+	cmd.Flags().BoolVarP(&options.allowOverprovisioned,
+		"allow-overprovisioned", "",
+		options.allowOverprovisioned,
+		"[optional] log a warning instead of failing if a cspi has less than min-free-pool-space free.")
+
+	cmd.Flags().BoolVarP(&options.ignoreNodePressure,
+		"ignore-node-pressure", "",
+		options.ignoreNodePressure,
+		"[optional] upgrade a cspi even if its node is under memory, disk or pid pressure.")
+
+	cmd.Flags().BoolVarP(&options.verifyBlockDevices,
+		"verify-block-devices", "",
+		options.verifyBlockDevices,
+		"[optional] fail the upgrade of a cspi if its blockdevices are no longer Active and Claimed after the upgrade.")
+
+	cmd.Flags().BoolVarP(&options.verifyPodImages,
+		"verify-pod-images", "",
+		options.verifyPodImages,
+		"[optional] fail the upgrade of a cspi if its pool pods are not running the images of the to-version after the upgrade.")
+
+	cmd.Flags().StringVarP(&options.lvmThinPool,
+		"lvm-thin-pool", "",
+		options.lvmThinPool,
+		"[optional] lvm thin pool as <vg>/<thinpool> whose --lvm-thin-pool-settings are applied on the node of each cspi before its upgrade.")
+
+	cmd.Flags().StringSliceVarP(&options.lvmThinPoolSettings,
+		"lvm-thin-pool-settings", "",
+		options.lvmThinPoolSettings,
+		"[optional] settings of the --lvm-thin-pool as key=value, one of discards=ignore|nopassdown|passdown, zero=y|n or errorwhenfull=y|n.")
+
+	cmd.Flags().StringVarP(&options.poolManagerImage,
+		"cspi-manager-image-override", "",
+		options.poolManagerImage,
+		"[optional] image used for the cstor-pool-manager container of the pools instead of the to-version image, for emergency use.")
+
+	cmd.Flags().IntVarP(&options.patchReapplyAttempts,
+		"patch-reapply-attempts", "",
+		options.patchReapplyAttempts,
+		"[optional] number of times the patch of the cspc is derived again and reapplied when its version does not reconcile within --reconcile-timeout.")
+
+	cmd.Flags().IntVarP(&options.maxParallelVerify,
+		"max-parallel-verify", "",
+		options.maxParallelVerify,
+		"[optional] maximum number of cspis whose version is verified at the same time once all the cspis of the cspc are upgraded.")
+
+	cmd.Flags().BoolVarP(&options.verifyPoolCount,
+		"verify-pool-count", "",
+		options.verifyPoolCount,
+		"[optional] fail the upgrade if the healthy cspis of the cspc are not equal to the pools in its spec after the upgrade.")
+
+	cmd.Flags().BoolVarP(&options.completeExpansion,
+		"complete-pending-expansion", "",
+		options.completeExpansion,
+		"[optional] wait for a pending expansion of the pools of the cspc to complete instead of failing the upgrade.")
+
+	cmd.Flags().IntVarP(&options.largeCSPCThreshold,
+		"large-upgrade-threshold", "",
+		options.largeCSPCThreshold,
+		"[optional] fail the upgrade of a cspc with more cspis than the threshold unless it is confirmed by --confirm-large-upgrade or the approval annotation.")
+
+	cmd.Flags().BoolVarP(&options.confirmLargeUpgrade,
+		"confirm-large-upgrade", "",
+		options.confirmLargeUpgrade,
+		"[optional] confirm the upgrade of a cspc with more cspis than the large-upgrade-threshold.")
+
+	cmd.Flags().StringVarP(&options.targetCSPI,
+		"target-cspi", "",
+		options.targetCSPI,
+		"[optional] upgrade only the named cspi of the cspc without patching the cspc, to debug the upgrade one cspi at a time.")
+
+	cmd.Flags().StringVarP(&options.skipAnnotation,
+		"skip-upgrade-annotation", "",
+		options.skipAnnotation,
+		"[optional] annotation key which skips the upgrade of a cspi when set to true. If not specified, openebs.io/skip-upgrade is used")
+
+	cmd.Flags().BoolVarP(&options.waitForRebuild,
+		"wait-for-rebuild", "",
+		options.waitForRebuild,
+		"[optional] wait for the replicas on an upgraded cspi to be healthy before upgrading the next cspi.")
+
+	cmd.Flags().DurationVarP(&options.rebuildTimeout,
+		"rebuild-timeout", "",
+		options.rebuildTimeout,
+		"[optional] maximum time to wait for the replicas on a cspi to rebuild, and for the other cspis to be ONLINE with --max-unavailable.")
+
+	cmd.Flags().BoolVarP(&options.nodeAwareScheduling,
+		"node-aware-scheduling", "",
+		options.nodeAwareScheduling,
+		"[optional] upgrade the cspcs concurrently while never upgrading two cspis on the same node at once.")
+
+	cmd.Flags().BoolVarP(&options.numaAware,
+		"numa-aware", "",
+		options.numaAware,
+		"[optional] upgrade the cspcs concurrently while never upgrading two cspis on the same NUMA node at once.")
+
 	return cmd
 }
 
 // RunCStorCSPCUpgrade upgrades the given Jiva Volume.
 func (u *UpgradeOptions) RunCStorCSPCUpgrade(cmd *cobra.Command, name string) error {
 
-	if version.IsCurrentVersionValid(u.fromVersion) && version.IsDesiredVersionValid(u.toVersion) {
+	if u.waitForVersion && !u.isDryRun() {
+		return u.RunWaitForVersion(name)
+	}
+	if u.isValidVersion() {
+		if u.precheckReport != "" {
+			return u.printPrecheckReport(name)
+		}
+		if u.generateTasks {
+			return u.printUpgradeTasks(name)
+		}
 		klog.Infof("Upgrading %s to %s", name, u.toVersion)
-		err := upgrade.Exec(u.fromVersion, u.toVersion,
-			u.resourceKind,
-			name,
-			u.openebsNamespace,
-			u.imageURLPrefix,
-			u.toVersionImageTag)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
 		if err != nil {
-			klog.Error(err)
-			return errors.Errorf("Failed to upgrade cStor CSPC %v", name)
+			return errors.Wrapf(err, "Failed to upgrade cStor CSPC %v", name)
 		}
 		klog.Infof("Successfully upgraded %s to %s", name, u.toVersion)
 	} else {
@@ -79,3 +203,50 @@ func (u *UpgradeOptions) RunCStorCSPCUpgrade(cmd *cobra.Command, name string) er
 	}
 	return nil
 }
+
+// RunConcurrentCStorCSPCUpgrade upgrades the given cStor CSPCs concurrently.
+// The cspis of the cspcs which share a node are upgraded one at a time.
+func (u *UpgradeOptions) RunConcurrentCStorCSPCUpgrade(cmd *cobra.Command, names []string) error {
+	if u.saveResult {
+		// initialize the shared result before the upgrades start
+		u.upgradeResult()
+	}
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			errs[i] = u.RunCStorCSPCUpgrade(cmd, name)
+		}(i, name)
+	}
+	wg.Wait()
+	return concurrentUpgradeError("cspcs", names, errs)
+}
+
+// concurrentUpgradeError logs the errors of the resources upgraded
+// concurrently, and returns the first one, as a partial failure naming
+// the failed resources if some of the resources were upgraded
+func concurrentUpgradeError(kind string, names []string, errs []error) error {
+	failed := []string{}
+	var firstErr error
+	for i, err := range errs {
+		if err != nil {
+			klog.Error(err)
+			failed = append(failed, names[i])
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr == nil {
+		return nil
+	}
+	if len(failed) < len(names) {
+		return &upgrader.Error{
+			Kind:  upgrader.ErrPartialFailure,
+			Cause: errors.Wrapf(firstErr, "failed to upgrade %s %s", kind, strings.Join(failed, ", ")),
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunSmokeTests(t *testing.T) {
+	u := newTestCSPIOptions(t)
+	u.resourceKind = "cstorPoolCluster"
+	if err := u.RunSmokeTests([]string{"cspc-a", "cspc-b"}); err != nil {
+		t.Fatalf("RunSmokeTests() without --run-smoke-test error = %v", err)
+	}
+	// the simulated cluster has no storageclass for any of the cspcs,
+	// failing the smoke test of the first one
+	u.runSmokeTest = true
+	err := u.RunSmokeTests([]string{"cspc-a", "cspc-b"})
+	if err == nil || !strings.Contains(err.Error(), "cspc-a") {
+		t.Errorf("RunSmokeTests() error = %v, want the smoke test of cspc-a to fail", err)
+	}
+}
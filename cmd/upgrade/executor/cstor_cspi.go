@@ -0,0 +1,143 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	cstorCSPIUpgradeCmdHelpText = `
+This command upgrades the given cStor CSPIs without walking their CSPC.
+It is meant to recover a single pool instance, the CSPC still has to be
+upgraded with cstor-cspc once all its CSPIs are healthy. The upgrade
+fails if the CSPC of a CSPI is newer than the to-version.
+
+Usage: upgrade cstor-cspi --options... <cspi-name>...
+`
+)
+
+// NewUpgradeCStorCSPIJob upgrades the given cStor Pool Instances
+func NewUpgradeCStorCSPIJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "cstor-cspi",
+		Short:   "Upgrade cStor CSPI",
+		Long:    cstorCSPIUpgradeCmdHelpText,
+		Example: `upgrade cstor-cspi <cspi-name>...`,
+		Run: func(cmd *cobra.Command, args []string) {
+			args = resourceArgs(args)
+			if len(args) == 0 {
+				util.Fatal("failed to upgrade: no cspi name provided")
+			}
+			options.resourceKind = "cstorPoolInstance"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(args))
+			for _, name := range args {
+				CheckError(options.RunCStorCSPIUpgrade(cmd, name))
+			}
+			options.RunCleanup()
+		},
+	}
+
+	cmd.Flags().IntVarP(&options.minFreePoolSpace,
+		"min-free-pool-space", "",
+		options.minFreePoolSpace,
+		"[optional] minimum percentage of free space required on the cspi to upgrade it.")
+
+	cmd.Flags().BoolVarP(&options.allowOverprovisioned,
+		"allow-overprovisioned", "",
+		options.allowOverprovisioned,
+		"[optional] log a warning instead of failing if the cspi has less than min-free-pool-space free.")
+
+	cmd.Flags().BoolVarP(&options.ignoreNodePressure,
+		"ignore-node-pressure", "",
+		options.ignoreNodePressure,
+		"[optional] upgrade the cspi even if its node is under memory, disk or pid pressure.")
+
+	cmd.Flags().BoolVarP(&options.verifyBlockDevices,
+		"verify-block-devices", "",
+		options.verifyBlockDevices,
+		"[optional] fail the upgrade of a cspi if its blockdevices are no longer Active and Claimed after the upgrade.")
+
+	cmd.Flags().BoolVarP(&options.verifyPodImages,
+		"verify-pod-images", "",
+		options.verifyPodImages,
+		"[optional] fail the upgrade of a cspi if its pool pods are not running the images of the to-version after the upgrade.")
+
+	cmd.Flags().StringVarP(&options.lvmThinPool,
+		"lvm-thin-pool", "",
+		options.lvmThinPool,
+		"[optional] lvm thin pool as <vg>/<thinpool> whose --lvm-thin-pool-settings are applied on the node of each cspi before its upgrade.")
+
+	cmd.Flags().StringSliceVarP(&options.lvmThinPoolSettings,
+		"lvm-thin-pool-settings", "",
+		options.lvmThinPoolSettings,
+		"[optional] settings of the --lvm-thin-pool as key=value, one of discards=ignore|nopassdown|passdown, zero=y|n or errorwhenfull=y|n.")
+
+	cmd.Flags().BoolVarP(&options.dryRun,
+		"dry-run", "",
+		options.dryRun,
+		"[optional] print the changes of the pool deployment and the cspi instead of patching them.")
+
+	cmd.Flags().StringVarP(&options.diffFormat,
+		"diff-format", "",
+		options.diffFormat,
+		"[optional] format of the changes printed by --dry-run, one of text or json which also has the raw patch.")
+
+	cmd.Flags().StringVarP(&options.poolManagerImage,
+		"cspi-manager-image-override", "",
+		options.poolManagerImage,
+		"[optional] image used for the cstor-pool-manager container of the pool instead of the to-version image, for emergency use.")
+
+	return cmd
+}
+
+// RunCStorCSPIUpgrade upgrades the given cStor CSPI.
+func (u *UpgradeOptions) RunCStorCSPIUpgrade(cmd *cobra.Command, name string) error {
+
+	if u.dryRun && (u.verifyOnly || u.waitForVersion) {
+		return errors.Errorf("--dry-run is not supported with --verify-only or --wait-for-version")
+	}
+	if u.waitForVersion && !u.isDryRun() {
+		return u.RunWaitForVersion(name)
+	}
+	if u.isValidVersion() {
+		if u.precheckReport != "" {
+			return u.printPrecheckReport(name)
+		}
+		if u.generateTasks {
+			return u.printUpgradeTasks(name)
+		}
+		klog.Infof("Upgrading %s to %s", name, u.toVersion)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to upgrade cStor CSPI %v", name)
+		}
+		klog.Infof("Successfully upgraded %s to %s", name, u.toVersion)
+	} else {
+		return errors.Errorf("Invalid from version %s or to version %s", u.fromVersion, u.toVersion)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	cstorCSPICRDUpgradeCmdHelpText = `
+This command migrates the CStorPoolInstances to a new version of the
+schema of their CRD. The CRD must already be upgraded to store the
+objects at the new version. Each CStorPoolInstance is read at the old
+version, converted and written at the new version, and the old version
+is then removed from the stored versions of the CRD.
+
+With --dry-run the CStorPoolInstances are converted and nothing is
+changed.
+
+Usage: upgrade cstor-cspi-crd --from-api-version=<version> --to-api-version=<version> --options...
+`
+)
+
+// NewUpgradeCStorCSPICRDJob migrates the cspis to
+// a new version of the schema of their crd
+func NewUpgradeCStorCSPICRDJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "cstor-cspi-crd",
+		Short:   "Migrate the CStorPoolInstances to a new version of their CRD",
+		Long:    cstorCSPICRDUpgradeCmdHelpText,
+		Example: `upgrade cstor-cspi-crd --from-version=2.12.0 --to-version=3.0.0 --from-api-version=v1alpha1 --to-api-version=v1`,
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			options.resourceKind = "cstorPoolInstanceCRD"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			if !options.dryRun {
+				CheckError(options.confirmUpgrade(args))
+			}
+			CheckError(options.RunCStorCSPICRDUpgrade(cmd))
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.fromAPIVersion,
+		"from-api-version", "",
+		options.fromAPIVersion,
+		"[optional] version of the crd schema the cspis are migrated from.")
+
+	cmd.Flags().StringVarP(&options.toAPIVersion,
+		"to-api-version", "",
+		options.toAPIVersion,
+		"[optional] version of the crd schema the cspis are migrated to.")
+
+	cmd.Flags().BoolVarP(&options.dryRun,
+		"dry-run", "",
+		options.dryRun,
+		"[optional] convert the cspis without writing them.")
+
+	return cmd
+}
+
+// RunCStorCSPICRDUpgrade migrates the cspis to the new version of their crd.
+func (u *UpgradeOptions) RunCStorCSPICRDUpgrade(cmd *cobra.Command) error {
+	if u.generateTasks {
+		return errors.Errorf("Upgradetasks are not used for the cspi crd migration")
+	}
+	if u.waitForVersion {
+		return errors.Errorf("Waiting for the version is not supported for the cspi crd migration")
+	}
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the cspi crd migration, use --dry-run")
+	}
+	if u.isValidVersion() {
+		klog.Infof("Migrating cspis from %s to %s", u.fromAPIVersion, u.toAPIVersion)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(""),
+			u.clientOptions()...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to migrate cspis to %s", u.toAPIVersion)
+		}
+		klog.Infof("Successfully migrated cspis to %s", u.toAPIVersion)
+	} else {
+		return errors.Errorf("Invalid from version %s or to version %s", u.fromVersion, u.toVersion)
+	}
+	return nil
+}
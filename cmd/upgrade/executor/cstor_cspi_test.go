@@ -0,0 +1,171 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// testToVersion is a custom version, as the release versions
+// are only valid with the version of the build
+const testToVersion = "ci-1234"
+
+// newTestCSPIOptions returns the options of an upgrade of the
+// cspis to the testToVersion in a simulated cluster
+func newTestCSPIOptions(t *testing.T) *UpgradeOptions {
+	t.Helper()
+	sim, err := upgrader.NewSimulator([]runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: upgradetesting.Namespace}},
+		upgradetesting.NewTestCSPC("cspc-a", "2.12.0"),
+		upgradetesting.NewTestCSPI("cspi-1", "cspc-a", "2.12.0"),
+		upgradetesting.NewTestDeployment("cspi-1", "2.12.0",
+			map[string]string{"openebs.io/cstor-pool-instance": "cspi-1"},
+			"openebs/cstor-pool:2.12.0", "openebs/cstor-pool-manager:2.12.0"),
+	}, upgrader.WithSimLatency(0))
+	if err != nil {
+		t.Fatalf("NewSimulator() error = %v", err)
+	}
+	return &UpgradeOptions{
+		resourceKind:        "cstorPoolInstance",
+		fromVersion:         "2.12.0",
+		toVersion:           testToVersion,
+		toVersionImageTag:   testToVersion,
+		openebsNamespace:    upgradetesting.Namespace,
+		allowCustomVersions: true,
+		pollInterval:        time.Millisecond,
+		simulator:           sim,
+	}
+}
+
+func TestRunCStorCSPIUpgrade(t *testing.T) {
+	cmd := &cobra.Command{}
+	tests := []struct {
+		name     string
+		resource string
+		setup    func(u *UpgradeOptions)
+		wantErr  string
+	}{
+		{
+			name:    "dry run with verify only",
+			setup:   func(u *UpgradeOptions) { u.dryRun, u.verifyOnly = true, true },
+			wantErr: "--dry-run is not supported",
+		},
+		{
+			name:    "invalid version",
+			setup:   func(u *UpgradeOptions) { u.allowCustomVersions = false },
+			wantErr: "Invalid from version",
+		},
+		{
+			name:  "generate upgradetasks",
+			setup: func(u *UpgradeOptions) { u.generateTasks = true },
+		},
+		{
+			name:  "precheck report",
+			setup: func(u *UpgradeOptions) { u.precheckReport = "json" },
+		},
+		{
+			name:    "precheck report of an unknown format",
+			setup:   func(u *UpgradeOptions) { u.precheckReport = "xml" },
+			wantErr: "xml",
+		},
+		{
+			name:  "dry run",
+			setup: func(u *UpgradeOptions) { u.dryRun = true },
+		},
+		{
+			name: "upgrade",
+		},
+		{
+			name:     "missing cspi",
+			resource: "cspi-2",
+			wantErr:  "Failed to upgrade cStor CSPI cspi-2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newTestCSPIOptions(t)
+			if tt.setup != nil {
+				tt.setup(u)
+			}
+			name := tt.resource
+			if name == "" {
+				name = "cspi-1"
+			}
+			err := u.RunCStorCSPIUpgrade(cmd, name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("RunCStorCSPIUpgrade() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RunCStorCSPIUpgrade() error = %v", err)
+			}
+			cspi, err := u.simulator.Client().OpenebsClientset.CstorV1().
+				CStorPoolInstances(upgradetesting.Namespace).Get(context.TODO(), "cspi-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "2.12.0"
+			if tt.setup == nil {
+				want = testToVersion
+			}
+			if cspi.VersionDetails.Status.Current != want {
+				t.Errorf("RunCStorCSPIUpgrade() cspi version = %s, want %s", cspi.VersionDetails.Status.Current, want)
+			}
+		})
+	}
+}
+
+func TestRunWaitForVersion(t *testing.T) {
+	u := newTestCSPIOptions(t)
+	if err := u.RunCStorCSPIUpgrade(&cobra.Command{}, "cspi-1"); err != nil {
+		t.Fatalf("RunCStorCSPIUpgrade() error = %v", err)
+	}
+	u.waitForVersion = true
+	if err := u.RunCStorCSPIUpgrade(&cobra.Command{}, "cspi-1"); err != nil {
+		t.Errorf("RunCStorCSPIUpgrade() waiting for an upgraded cspi error = %v", err)
+	}
+}
+
+func TestWriteUpgradeTasks(t *testing.T) {
+	u := newTestCSPIOptions(t)
+	u.resourceKind = "cstorPoolCluster"
+	utasks, err := upgrade.GenerateTasks(u.resourceKind, u.resourcePatchOptions("cspc-a"), u.clientOptions()...)
+	if err != nil {
+		t.Fatalf("failed to generate the upgradetasks: %v", err)
+	}
+	out := &bytes.Buffer{}
+	if err := writeUpgradeTasks(out, utasks); err != nil {
+		t.Fatalf("writeUpgradeTasks() error = %v", err)
+	}
+	if got := strings.Count(out.String(), "---\n"); got != len(utasks) || got == 0 {
+		t.Errorf("writeUpgradeTasks() wrote %d documents, want %d", got, len(utasks))
+	}
+}
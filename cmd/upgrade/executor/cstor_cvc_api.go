@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	cstorCVCAPIUpgradeCmdHelpText = `
+This command migrates the CStorVolumeClaims of the deprecated
+openebs.io/v1alpha1 API to the CStorVolumeConfigs of the
+cstor.openebs.io/v1 API. The old CVC is kept by a finalizer until
+the new CVC is bound and owns the CStorVolume, and is deleted only
+once the binding is verified. If no name is given, all the old CVCs
+in the openebs-namespace are migrated.
+
+With --dry-run the CVCs are converted and nothing is changed.
+
+Usage: upgrade cstor-cvc-api [name] --options...
+`
+)
+
+// NewUpgradeCStorCVCAPIJob migrates the v1alpha1 cvcs to the v1 api
+func NewUpgradeCStorCVCAPIJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "cstor-cvc-api",
+		Short:   "Migrate the v1alpha1 CStorVolumeClaims to v1 CStorVolumeConfigs",
+		Long:    cstorCVCAPIUpgradeCmdHelpText,
+		Example: `upgrade cstor-cvc-api --from-version=1.12.0 --to-version=3.0.0 pvc-1`,
+		Args:    cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			options.resourceKind = "cstorVolumeClaimAPI"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			if !options.dryRun {
+				CheckError(options.confirmUpgrade(args))
+			}
+			CheckError(options.RunCStorCVCAPIUpgrade(cmd, args))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&options.dryRun,
+		"dry-run", "",
+		options.dryRun,
+		"[optional] convert the cvcs without writing them.")
+
+	return cmd
+}
+
+// RunCStorCVCAPIUpgrade migrates the v1alpha1 cvcs to the v1 api.
+func (u *UpgradeOptions) RunCStorCVCAPIUpgrade(cmd *cobra.Command, args []string) error {
+	if u.generateTasks {
+		return errors.Errorf("Upgradetasks are not used for the cvc api migration")
+	}
+	if u.waitForVersion {
+		return errors.Errorf("Waiting for the version is not supported for the cvc api migration")
+	}
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the cvc api migration, use --dry-run")
+	}
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	if u.isValidVersion() {
+		klog.Infof("Migrating cvcs to cstor.openebs.io/v1")
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to migrate cvcs to cstor.openebs.io/v1")
+		}
+		klog.Infof("Successfully migrated cvcs to cstor.openebs.io/v1")
+	} else {
+		return errors.Errorf("Invalid from version %s or to version %s", u.fromVersion, u.toVersion)
+	}
+	return nil
+}
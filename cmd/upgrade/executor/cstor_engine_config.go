@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	cstorEngineConfigUpgradeCmdHelpText = `
+This command upgrades the CStorEngineConfigs of the cStor data engine.
+The fields whose default changed between the from-version and the
+to-version are set to the default of the to-version, except the fields
+listed in the openebs.io/user-modified annotation which are kept.
+If no name is given, all the CStorEngineConfigs in the openebs-namespace
+are upgraded. The from-version is read from each CStorEngineConfig if
+not specified.
+
+Usage: upgrade cstor-engine-config [name] --options...
+`
+)
+
+// NewUpgradeCStorEngineConfigJob upgrades the cstorengineconfigs
+func NewUpgradeCStorEngineConfigJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "cstor-engine-config",
+		Short:   "Upgrade the CStorEngineConfigs of the cStor data engine",
+		Long:    cstorEngineConfigUpgradeCmdHelpText,
+		Example: `upgrade cstor-engine-config --from-version=2.12.0 --to-version=3.0.0`,
+		Args:    cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			options.resourceKind = "cstorEngineConfig"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(args))
+			CheckError(options.RunCStorEngineConfigUpgrade(cmd, args))
+		},
+	}
+	return cmd
+}
+
+// RunCStorEngineConfigUpgrade upgrades the cstorengineconfigs.
+func (u *UpgradeOptions) RunCStorEngineConfigUpgrade(cmd *cobra.Command, args []string) error {
+
+	if u.generateTasks {
+		return errors.Errorf("Upgradetasks are not used for the cstorengineconfig upgrade")
+	}
+	if u.waitForVersion {
+		return errors.Errorf("Waiting for the version is not supported for the cstorengineconfig upgrade")
+	}
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the cstorengineconfig upgrade")
+	}
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	if u.isValidVersion() {
+		klog.Infof("Upgrading cstorengineconfigs to %s", u.toVersion)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to upgrade cstorengineconfigs")
+		}
+		klog.Infof("Successfully upgraded cstorengineconfigs to %s", u.toVersion)
+	} else {
+		return errors.Errorf("Invalid from version %s or to version %s", u.fromVersion, u.toVersion)
+	}
+	return nil
+}
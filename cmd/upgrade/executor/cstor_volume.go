@@ -17,12 +17,13 @@ limitations under the License.
 package executor
 
 import (
+	"sync"
+
 	"github.com/openebs/maya/pkg/util"
 	"github.com/spf13/cobra"
 	"k8s.io/klog"
 
 	upgrade "github.com/openebs/upgrade/pkg/upgrade"
-	"github.com/openebs/upgrade/pkg/version"
 	errors "github.com/pkg/errors"
 )
 
@@ -43,35 +44,93 @@ func NewUpgradeCStorVolumeJob() *cobra.Command {
 		Long:    cstorCStorVolumeUpgradeCmdHelpText,
 		Example: `upgrade cstor-volume <spc-name>...`,
 		Run: func(cmd *cobra.Command, args []string) {
+			args = resourceArgs(args)
 			if len(args) == 0 {
 				util.Fatal("failed to upgrade: no volume name provided")
 			}
-			for _, name := range args {
-				options.resourceKind = "cstorVolume"
-				util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			options.resourceKind = "cstorVolume"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(args))
+			CheckError(options.RunCVCReadinessGate())
+			args = options.sortVolumesByLineage(args)
+			if options.maxParallelVolumes > 1 && !options.isDryRun() {
 				util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
-				util.CheckErr(options.RunCStorVolumeUpgrade(cmd, name), util.Fatal)
+				CheckError(options.RunConcurrentVolumeUpgrade(cmd, args, options.RunCStorVolumeUpgrade))
+			} else {
+				for _, name := range args {
+					options.resourceKind = "cstorVolume"
+					util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+					util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+					CheckError(options.RunCStorVolumeUpgrade(cmd, name))
+				}
 			}
+			CheckError(options.RunSmokeTest(args[len(args)-1]))
+			options.RunCleanup()
 		},
 	}
 
+	cmd.Flags().BoolVarP(&options.allowInUseUpgrades,
+		"allow-in-use-upgrades", "",
+		options.allowInUseUpgrades,
+		"[optional] log a warning instead of failing if the pvc of a volume is used by a running pod.")
+
+	cmd.Flags().IntVarP(&options.minHealthyReplicas,
+		"min-healthy-replicas", "",
+		options.minHealthyReplicas,
+		"[optional] minimum of healthy replicas a volume needs before its target is restarted. If not specified, all the replicas of its replication factor are needed, -1 disables the check.")
+
+	cmd.Flags().DurationVarP(&options.replicaWaitTimeout,
+		"replica-wait-timeout", "",
+		options.replicaWaitTimeout,
+		"[optional] time to wait for the healthy replicas of --min-healthy-replicas instead of failing right away.")
+
+	cmd.Flags().BoolVarP(&options.failOnPendingResize,
+		"fail-on-pending-resize", "",
+		options.failOnPendingResize,
+		"[optional] fail the upgrade of a volume whose cvc has a pending resize instead of waiting for the resize to complete.")
+
+	cmd.Flags().DurationVarP(&options.cvcReadinessTimeout,
+		"cvc-readiness-timeout", "",
+		options.cvcReadinessTimeout,
+		"[optional] wait up to the timeout for the cvcs being provisioned to be Bound before upgrading the volumes, e.g. 5m.")
+
+	cmd.Flags().BoolVarP(&options.updateISCSIPortal,
+		"update-iscsi-portal", "",
+		options.updateISCSIPortal,
+		"[optional] move the iscsi session of each volume on the node it is mounted on to the portal of its upgraded target, which requires multipath.")
+
+	cmd.Flags().BoolVarP(&options.upgradeClones,
+		"upgrade-clones", "",
+		options.upgradeClones,
+		"[optional] upgrade the clones of each volume after the volume instead of logging a warning for them.")
+
+	cmd.Flags().IntVarP(&options.maxParallelVolumes,
+		"max-parallel-volumes", "",
+		options.maxParallelVolumes,
+		"[optional] maximum number of volumes upgraded at the same time.")
+
 	return cmd
 }
 
 // RunCStorVolumeUpgrade upgrades the given Jiva Volume.
 func (u *UpgradeOptions) RunCStorVolumeUpgrade(cmd *cobra.Command, name string) error {
 
-	if version.IsCurrentVersionValid(u.fromVersion) && version.IsDesiredVersionValid(u.toVersion) {
+	if u.waitForVersion && !u.isDryRun() {
+		return u.RunWaitForVersion(name)
+	}
+	if u.isValidVersion() {
+		if u.precheckReport != "" {
+			return u.printPrecheckReport(name)
+		}
+		if u.generateTasks {
+			return u.printUpgradeTasks(name)
+		}
 		klog.Infof("Upgrading %s to %s", name, u.toVersion)
-		err := upgrade.Exec(u.fromVersion, u.toVersion,
-			u.resourceKind,
-			name,
-			u.openebsNamespace,
-			u.imageURLPrefix,
-			u.toVersionImageTag)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
 		if err != nil {
-			klog.Error(err)
-			return errors.Errorf("Failed to upgrade CStorVolume %v", name)
+			return errors.Wrapf(err, "Failed to upgrade CStorVolume %v", name)
 		}
 		klog.Infof("Successfully upgraded %s to %s", name, u.toVersion)
 	} else {
@@ -79,3 +138,60 @@ func (u *UpgradeOptions) RunCStorVolumeUpgrade(cmd *cobra.Command, name string)
 	}
 	return nil
 }
+
+// RunCVCReadinessGate waits for the cvcs being provisioned before the
+// volumes are upgraded, if cvc-readiness-timeout is set
+func (u *UpgradeOptions) RunCVCReadinessGate() error {
+	if u.cvcReadinessTimeout <= 0 || u.isDryRun() {
+		return nil
+	}
+	err := upgrade.WaitForCVCReadiness(u.resourcePatchOptions(""), u.clientOptions()...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to wait for the cvcs to be provisioned")
+	}
+	return nil
+}
+
+// sortVolumesByLineage orders the volumes so that the parent of a clone
+// is upgraded first, the order is kept if the cvcs can not be read
+func (u *UpgradeOptions) sortVolumesByLineage(names []string) []string {
+	sorted, err := upgrade.SortVolumesByLineage(names, u.resourcePatchOptions(""), u.clientOptions()...)
+	if err != nil {
+		klog.Warningf("failed to order the volumes by their clones: %v", err)
+		return names
+	}
+	return sorted
+}
+
+// RunConcurrentVolumeUpgrade upgrades the given volumes using up to
+// max-parallel-volumes workers, each volume with its own upgradetask,
+// and returns the aggregated errors of the volumes once all are done.
+func (u *UpgradeOptions) RunConcurrentVolumeUpgrade(cmd *cobra.Command, names []string,
+	run func(cmd *cobra.Command, name string) error) error {
+	if u.saveResult {
+		// initialize the shared result before the upgrades start
+		u.upgradeResult()
+	}
+	workers := u.maxParallelVolumes
+	if workers > len(names) {
+		workers = len(names)
+	}
+	errs := make([]error, len(names))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = run(cmd, names[i])
+			}
+		}()
+	}
+	for i := range names {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return concurrentUpgradeError("volumes", names, errs)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func TestRunConcurrentVolumeUpgrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxParallel int
+		failing     map[string]bool
+		wantPartial bool
+		wantErr     bool
+	}{
+		{name: "all upgraded", maxParallel: 2},
+		{name: "one at a time", maxParallel: 1},
+		{name: "more workers than volumes", maxParallel: 10},
+		{name: "some failed", maxParallel: 3, failing: map[string]bool{"pvc-2": true}, wantPartial: true, wantErr: true},
+		{
+			name:        "all failed",
+			maxParallel: 3,
+			failing:     map[string]bool{"pvc-0": true, "pvc-1": true, "pvc-2": true, "pvc-3": true, "pvc-4": true},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := []string{}
+			for i := 0; i < 5; i++ {
+				names = append(names, fmt.Sprintf("pvc-%d", i))
+			}
+			var mutex sync.Mutex
+			inFlight, maxInFlight := 0, 0
+			upgraded := map[string]int{}
+			run := func(cmd *cobra.Command, name string) error {
+				mutex.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				upgraded[name]++
+				mutex.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mutex.Lock()
+				inFlight--
+				mutex.Unlock()
+				if tt.failing[name] {
+					return errors.Errorf("failed to upgrade %s", name)
+				}
+				return nil
+			}
+			u := &UpgradeOptions{maxParallelVolumes: tt.maxParallel}
+			err := u.RunConcurrentVolumeUpgrade(nil, names, run)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunConcurrentVolumeUpgrade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, upgrader.ErrPartialFailure) != tt.wantPartial {
+				t.Errorf("RunConcurrentVolumeUpgrade() error = %v, want partial failure %v", err, tt.wantPartial)
+			}
+			want := tt.maxParallel
+			if want > len(names) {
+				want = len(names)
+			}
+			if maxInFlight > want {
+				t.Errorf("%d volumes upgraded at once, want at most %d", maxInFlight, want)
+			}
+			for _, name := range names {
+				if upgraded[name] != 1 {
+					t.Errorf("volume %s upgraded %d times, want 1", name, upgraded[name])
+				}
+			}
+		})
+	}
+}
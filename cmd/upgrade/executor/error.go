@@ -20,15 +20,53 @@ import (
 	"context"
 	"fmt"
 	"os"
+
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	errors "github.com/pkg/errors"
+)
+
+const (
+	exitCodeFailure        = 1
+	exitCodeValidation     = 2
+	exitCodeTimeout        = 3
+	exitCodeAPI            = 4
+	exitCodePartialFailure = 5
 )
 
-// CheckError prints err to stderr and exits with code 1 if err is not nil. Otherwise, it is a
-// no-op.
+// CheckError prints err to stderr and exits with the exit code mapped
+// to the kind of err if err is not nil. Otherwise, it is a no-op.
 func CheckError(err error) {
 	if err != nil {
+		options.printSimulationReport()
+		options.writeUpgradeReport()
 		if err != context.Canceled {
 			fmt.Fprintf(os.Stderr, fmt.Sprintf("An error occurred: %v\n", err))
 		}
-		os.Exit(1)
+		os.Exit(ExitCode(err))
+	}
+}
+
+// ExitCode returns the exit code for the kind of the given error:
+//   - 1, unknown failure
+//   - 2, validation of the resource or options failed
+//   - 3, timed out waiting for the resource
+//   - 4, request to the kubernetes api server failed
+//   - 5, upgrade failed after some dependent resources were upgraded
+//
+// A validation error wrapped by an api error, like a failure to
+// resolve the from version while the resource is read, exits with 2.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, upgrader.ErrPartialFailure):
+		return exitCodePartialFailure
+	case errors.Is(err, upgrader.ErrTimeout):
+		return exitCodeTimeout
+	case errors.Is(err, upgrader.ErrValidation):
+		return exitCodeValidation
+	case errors.Is(err, upgrader.ErrAPI):
+		return exitCodeAPI
 	}
+	return exitCodeFailure
 }
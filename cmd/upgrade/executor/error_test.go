@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"testing"
+
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	errors "github.com/pkg/errors"
+)
+
+func TestExitCode(t *testing.T) {
+	cause := errors.New("failed")
+	tests := map[string]struct {
+		err  error
+		want int
+	}{
+		"no error":      {want: 0},
+		"unknown error": {err: cause, want: exitCodeFailure},
+		"validation error": {
+			err:  &upgrader.Error{Kind: upgrader.ErrValidation, Cause: cause},
+			want: exitCodeValidation,
+		},
+		"api error": {
+			err:  &upgrader.Error{Kind: upgrader.ErrAPI, Cause: cause},
+			want: exitCodeAPI,
+		},
+		"validation error wrapped by an api error": {
+			err: &upgrader.Error{
+				Kind:  upgrader.ErrAPI,
+				Cause: errors.Wrap(&upgrader.Error{Kind: upgrader.ErrValidation, Cause: cause}, "init"),
+			},
+			want: exitCodeValidation,
+		},
+		"partial failure": {
+			err:  &upgrader.Error{Kind: upgrader.ErrPartialFailure, Cause: cause},
+			want: exitCodePartialFailure,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
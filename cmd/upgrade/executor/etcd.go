@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	etcdUpgradeCmdHelpText = `
+This command upgrades the image of the etcd statefulset used by the
+replicated engine (formerly mayastor) and waits for all the etcd
+members to be healthy. The upgrade is refused if fewer than a quorum
+of the members are healthy.
+
+Usage: upgrade etcd --options... <statefulset-name>
+`
+)
+
+// NewUpgradeEtcdJob upgrades the etcd statefulset of the replicated engine
+func NewUpgradeEtcdJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "etcd",
+		Short:   "Upgrade the etcd statefulset of the replicated engine",
+		Long:    etcdUpgradeCmdHelpText,
+		Example: `upgrade etcd mayastor-etcd --openebs-namespace=mayastor --from-version=1.0.0 --to-version=2.0.0 --etcd-image=docker.io/bitnami/etcd:3.5.6`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				util.Fatal("failed to upgrade: provide the name of the etcd statefulset")
+			}
+			options.resourceKind = "etcd"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(args))
+			CheckError(options.RunEtcdUpgrade(cmd, args[0]))
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.etcdImage,
+		"etcd-image", "",
+		options.etcdImage,
+		"image the etcd container is upgraded to. If not specified, the current image with the to-version-image-tag is used")
+
+	cmd.Flags().StringVarP(&options.etcdCAFile,
+		"etcd-ca-file", "",
+		options.etcdCAFile,
+		"ca file to verify the certificates of the etcd members, if their client urls use https")
+
+	cmd.Flags().StringVarP(&options.etcdCertFile,
+		"etcd-cert-file", "",
+		options.etcdCertFile,
+		"client certificate file to get the health of the etcd members")
+
+	cmd.Flags().StringVarP(&options.etcdKeyFile,
+		"etcd-key-file", "",
+		options.etcdKeyFile,
+		"client key file to get the health of the etcd members")
+
+	return cmd
+}
+
+// RunEtcdUpgrade upgrades the given etcd statefulset. The from and to
+// versions are the versions of the replicated engine, so they are not
+// validated against the openebs releases.
+func (u *UpgradeOptions) RunEtcdUpgrade(cmd *cobra.Command, name string) error {
+	if u.generateTasks {
+		return errors.Errorf("Upgradetasks are not used for the etcd upgrade")
+	}
+	if u.waitForVersion {
+		return errors.Errorf("Waiting for the version is not supported for the etcd upgrade")
+	}
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the etcd upgrade")
+	}
+	if u.verifyImages {
+		return errors.Errorf("Verifying the images is not supported for the etcd upgrade")
+	}
+	// the default image tag is the to-version, which is not an etcd version
+	if u.etcdImage == "" && u.toVersionImageTag == u.toVersion {
+		return errors.Errorf("Cannot upgrade etcd: set --etcd-image or --to-version-image-tag")
+	}
+	klog.Infof("Upgrading etcd statefulset %s", name)
+	err := upgrade.Exec(u.resourceKind,
+		u.resourcePatchOptions(name),
+		u.clientOptions()...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to upgrade etcd statefulset %v", name)
+	}
+	klog.Infof("Successfully upgraded etcd statefulset %s", name)
+	return nil
+}
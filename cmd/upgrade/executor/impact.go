@@ -0,0 +1,50 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"strings"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/pkg/errors"
+)
+
+// RunAnalyzeImpact prints the estimated impact of upgrading
+// the resources in the openebs namespace to the to-version
+func (u *UpgradeOptions) RunAnalyzeImpact() error {
+	if len(strings.TrimSpace(u.toVersion)) == 0 {
+		return errors.Errorf("Cannot analyze the impact of the upgrade: to-version is missing")
+	}
+	report, err := upgrade.AnalyzeImpact(u.openebsNamespace,
+		u.fromVersion, u.toVersion, u.clientOptions()...)
+	if err != nil {
+		return err
+	}
+	return upgrader.PrintOutput(os.Stdout, upgrader.OutputTable, report)
+}
+
+// analyzeImpactAndExit prints the impact of the upgrade and
+// exits without running the command if --analyze-impact is set
+func analyzeImpactAndExit() {
+	if !options.analyzeImpact {
+		return
+	}
+	CheckError(options.RunAnalyzeImpact())
+	os.Exit(0)
+}
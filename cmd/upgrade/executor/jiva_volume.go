@@ -22,7 +22,6 @@ import (
 	"k8s.io/klog"
 
 	upgrade "github.com/openebs/upgrade/pkg/upgrade"
-	"github.com/openebs/upgrade/pkg/version"
 	errors "github.com/pkg/errors"
 )
 
@@ -42,34 +41,56 @@ func NewUpgradeJivaVolumeJob() *cobra.Command {
 		Long:    jivaVolumeUpgradeCmdHelpText,
 		Example: `upgrade jiva-volume <spc-name>...`,
 		Run: func(cmd *cobra.Command, args []string) {
+			args = resourceArgs(args)
 			if len(args) == 0 {
 				util.Fatal("failed to upgrade: no volume name provided")
 			}
-			for _, name := range args {
-				options.resourceKind = "jivaVolume"
-				util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			options.resourceKind = "jivaVolume"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(args))
+			if options.maxParallelVolumes > 1 && !options.isDryRun() {
 				util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
-				util.CheckErr(options.RunJivaVolumeUpgrade(cmd, name), util.Fatal)
+				CheckError(options.RunConcurrentVolumeUpgrade(cmd, args, options.RunJivaVolumeUpgrade))
+			} else {
+				for _, name := range args {
+					options.resourceKind = "jivaVolume"
+					util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+					util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+					CheckError(options.RunJivaVolumeUpgrade(cmd, name))
+				}
 			}
+			CheckError(options.RunSmokeTest(args[len(args)-1]))
+			options.RunCleanup()
 		},
 	}
+
+	cmd.Flags().IntVarP(&options.maxParallelVolumes,
+		"max-parallel-volumes", "",
+		options.maxParallelVolumes,
+		"[optional] maximum number of volumes upgraded at the same time.")
+
 	return cmd
 }
 
 // RunJivaVolumeUpgrade upgrades the given Jiva Volume.
 func (u *UpgradeOptions) RunJivaVolumeUpgrade(cmd *cobra.Command, name string) error {
 
-	if version.IsCurrentVersionValid(u.fromVersion) && version.IsDesiredVersionValid(u.toVersion) {
+	if u.waitForVersion && !u.isDryRun() {
+		return u.RunWaitForVersion(name)
+	}
+	if u.isValidVersion() {
+		if u.precheckReport != "" {
+			return u.printPrecheckReport(name)
+		}
+		if u.generateTasks {
+			return u.printUpgradeTasks(name)
+		}
 		klog.Infof("Upgrading JivaVolume %s to %s", name, u.toVersion)
-		err := upgrade.Exec(u.fromVersion, u.toVersion,
-			u.resourceKind,
-			name,
-			u.openebsNamespace,
-			u.imageURLPrefix,
-			u.toVersionImageTag)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
 		if err != nil {
-			klog.Error(err)
-			return errors.Errorf("Failed to upgrade JivaVolume %v", name)
+			return errors.Wrapf(err, "Failed to upgrade JivaVolume %v", name)
 		}
 		klog.Infof("Successfully upgraded %s to %s", name, u.toVersion)
 	} else {
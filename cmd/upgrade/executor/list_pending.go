@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+)
+
+var (
+	listPendingCmdHelpText = `
+This command lists the cStor resources in the openebs namespace
+whose current version is older than the to-version.
+
+Usage: upgrade list-pending --to-version=<version> [--output-format=table|json|yaml]
+`
+)
+
+// NewListPendingJob lists the resources which need to be upgraded
+func NewListPendingJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list-pending",
+		Short:   "List the resources which need to be upgraded",
+		Long:    listPendingCmdHelpText,
+		Example: `upgrade list-pending --to-version=3.0.0 --output-format=json`,
+		// the listing is read only, so the self test
+		// of the upgrade is not run
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			CheckError(initFromEnv(cmd))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			CheckError(options.RunListPending())
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.outputFormat,
+		"output-format", "o",
+		options.outputFormat,
+		"[optional] output format, one of table, json or yaml.")
+
+	return cmd
+}
+
+// RunListPending prints the resources older than the to-version
+// in the output format
+func (u *UpgradeOptions) RunListPending() error {
+	if len(strings.TrimSpace(u.toVersion)) == 0 {
+		return errors.Errorf("Cannot list pending resources: to-version is missing")
+	}
+	err := upgrader.ValidateOutputFormat(u.outputFormat)
+	if err != nil {
+		return err
+	}
+	list, err := upgrade.ListPendingResources(u.openebsNamespace, u.toVersion, u.clientOptions()...)
+	if err != nil {
+		return err
+	}
+	return upgrader.PrintOutput(os.Stdout, u.outputFormat, list)
+}
@@ -17,28 +17,170 @@ limitations under the License.
 package executor
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
 	"strings"
+	"time"
 
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/openebs/upgrade/pkg/version"
 	errors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+	"k8s.io/klog"
 )
 
 // UpgradeOptions stores information required for upgrade
 type UpgradeOptions struct {
-	fromVersion       string
-	toVersion         string
-	openebsNamespace  string
-	imageURLPrefix    string
-	toVersionImageTag string
-	resourceKind      string
-	name              string
+	fromVersion          string
+	toVersion            string
+	openebsNamespace     string
+	imageURLPrefix       string
+	imageRegistry        string
+	toVersionImageTag    string
+	resourceKind         string
+	name                 string
+	kubeConfigPath       string
+	masterURL            string
+	kubeContext          string
+	useContextNamespace  bool
+	verifyOnly           bool
+	maxUnavailable       string
+	maxParallelVolumes   int
+	minFreePoolSpace     int
+	allowOverprovisioned bool
+	allowRBACRemoval     bool
+	transientMessages    []string
+	reconcileThreshold   int
+	reconcileTimeout     time.Duration
+	stabilityPolls       int
+	stablePeriod         time.Duration
+	patchReapplyAttempts int
+	maxParallelVerify    int
+	generateTasks        bool
+	pollInterval         time.Duration
+	runSmokeTest         bool
+	smokeTestSC          string
+	skipAnnotation       string
+	operatorVersionLabel string
+	preUpgradeHook       string
+	postUpgradeHook      string
+	hookTimeout          time.Duration
+	waitForRebuild       bool
+	rebuildTimeout       time.Duration
+	fromStdin            bool
+	selfTest             bool
+	skipSelfTest         bool
+	saveResult           bool
+	resultName           string
+	resultNamespace      string
+	result               *upgrader.UpgradeResult
+	reportOutputFile     string
+	reportFormat         string
+	// smtp sends the report of the upgrade by email if its host is set
+	smtp                 upgrader.SMTPNotifier
+	cleanup              bool
+	olderThan            string
+	verifyImages         bool
+	imagePullSecret      string
+	outputFormat         string
+	nodeAwareScheduling  bool
+	numaAware            bool
+	waitForVersion       bool
+	qps                  float32
+	burst                int
+	lockTimeout          time.Duration
+	allowCustomVersions  bool
+	skipKubeVersionCheck bool
+	ignoreNodePressure   bool
+	poolManagerImage     string
+	visualize            string
+	analyzeImpact        bool
+	verifyPoolCount      bool
+	completeExpansion    bool
+	largeCSPCThreshold   int
+	confirmLargeUpgrade  bool
+	targetCSPI           string
+	verifyBlockDevices   bool
+	verifyPodImages      bool
+	backoffStrategy      string
+	cvcReadinessTimeout  time.Duration
+	lvmThinPool          string
+	lvmThinPoolSettings  []string
+	allowInUseUpgrades   bool
+	minHealthyReplicas   int
+	replicaWaitTimeout   time.Duration
+	failOnPendingResize  bool
+	updateISCSIPortal    bool
+	upgradeClones        bool
+	precheckReport       string
+	namespaceScoped      bool
+	noUpgradeTasks       bool
+	knownReleases        []string
+	snapshotDriver       string
+	// snapshotClassParameters are the parameters set on the snapshotclasses
+	snapshotClassParameters map[string]string
+	storageClassProvisioner string
+	newStorageClassName     string
+	fromAPIVersion          string
+	toAPIVersion            string
+	dryRun                  bool
+	diffFormat              string
+	confirm                 bool
+	yes                     bool
+	confirmTimeout          time.Duration
+	etcdImage               string
+	etcdCAFile              string
+	etcdCertFile            string
+	etcdKeyFile             string
+	requireApproval         bool
+	consolidateTasks        bool
+	approvalAnnotation      string
+	fromVersionAnnotation   string
+	approvalTimeout         time.Duration
+	podSecurityLevel        string
+	migratePSP              bool
+	simulate                bool
+	simCluster              string
+	simFailureRate          float64
+	simLatency              time.Duration
+	simulator               *upgrader.Simulator
 }
 
 var (
 	options = &UpgradeOptions{
-		openebsNamespace: "openebs",
-		imageURLPrefix:   "",
+		openebsNamespace:   "openebs",
+		imageURLPrefix:     "",
+		minFreePoolSpace:   10,
+		maxParallelVolumes: 1,
+		maxParallelVerify:  10,
+		fromAPIVersion:     "v1alpha1",
+		toAPIVersion:       "v1",
+		pollInterval:       10 * time.Second,
+		stabilityPolls:     2,
+		hookTimeout:        5 * time.Minute,
+		rebuildTimeout:     30 * time.Minute,
+		olderThan:          "7d",
+		outputFormat:       upgrader.OutputTable,
+		reportFormat:       upgrader.ReportJSON,
+		qps:                upgrader.DefaultQPS,
+		burst:              upgrader.DefaultBurst,
+		lockTimeout:        time.Hour,
+		confirmTimeout:     60 * time.Second,
+		approvalTimeout:    time.Hour,
+		simLatency:         upgrader.DefaultSimLatency,
+		diffFormat:         upgrader.DiffText,
+		smtp: upgrader.SMTPNotifier{
+			Port:          upgrader.DefaultSMTPPort,
+			SubjectPrefix: "[OpenEBS upgrade]",
+		},
 	}
 )
 
@@ -48,7 +190,11 @@ func (u *UpgradeOptions) RunPreFlightChecks(cmd *cobra.Command) error {
 		return errors.Errorf("Cannot execute upgrade job: namespace is missing")
 	}
 
-	if len(strings.TrimSpace(u.fromVersion)) == 0 {
+	// the from-version is not needed when only waiting for the
+	// externally patched resource to reconcile, or when it is read
+	// from the current version of the resource
+	if len(strings.TrimSpace(u.fromVersion)) == 0 && !u.waitForVersion &&
+		!upgrader.IsFromVersionOptional(u.resourceKind) {
 		return errors.Errorf("Cannot execute upgrade job: from-version is missing")
 	}
 
@@ -60,6 +206,32 @@ func (u *UpgradeOptions) RunPreFlightChecks(cmd *cobra.Command) error {
 		return errors.Errorf("Cannot execute upgrade job: resource details are missing")
 	}
 
+	return u.resolveToVersion()
+}
+
+// resolveToVersion resolves a minor-only to-version like 3.1 to the latest
+// known patch release of it. The releases are the known-releases if set,
+// or else the releases known to this build along with the versions of the
+// running operators.
+func (u *UpgradeOptions) resolveToVersion() error {
+	if !version.IsMinorVersion(u.toVersion) {
+		return nil
+	}
+	releases := u.knownReleases
+	if len(releases) == 0 {
+		releases = version.KnownReleases()
+		operatorVersions, err := upgrade.OperatorVersions(u.clientOptions()...)
+		if err != nil {
+			klog.Warningf("failed to get the versions of the operators: %v", err)
+		}
+		releases = append(releases, operatorVersions...)
+	}
+	resolved, err := version.ResolveMinorVersion(u.toVersion, releases)
+	if err != nil {
+		return &upgrader.Error{Kind: upgrader.ErrValidation, Cause: err}
+	}
+	klog.Infof("Resolved to-version %s to %s", u.toVersion, resolved)
+	u.toVersion = resolved
 	return nil
 }
 
@@ -71,3 +243,313 @@ func (u *UpgradeOptions) InitializeDefaults(cmd *cobra.Command) error {
 	}
 	return nil
 }
+
+// isValidVersion returns true if the upgrade from the from-version to the
+// to-version is supported, or if custom versions are allowed and either of
+// them is the version of a custom build
+func (u *UpgradeOptions) isValidVersion() bool {
+	// the from version read from the resource is verified by the upgrade
+	if u.fromVersion == "" && version.IsDesiredVersionValid(u.toVersion) {
+		return true
+	}
+	if version.IsCurrentVersionValid(u.fromVersion) && version.IsDesiredVersionValid(u.toVersion) {
+		return true
+	}
+	if !u.allowCustomVersions {
+		return false
+	}
+	err := version.IsCustomUpgradePathValid(u.fromVersion, u.toVersion)
+	if err != nil {
+		klog.Errorf("Invalid custom version: %v", err)
+		return false
+	}
+	klog.Warningf("Upgrading from %s to %s with custom versions, the compatibility checks are skipped",
+		u.fromVersion, u.toVersion)
+	return true
+}
+
+// clientOptions returns the options used to build the kubernetes clients
+func (u *UpgradeOptions) clientOptions() []upgrader.ClientOptions {
+	opts := []upgrader.ClientOptions{
+		upgrader.WithKubeConfigPath(u.kubeConfigPath),
+		upgrader.WithMasterURL(u.masterURL),
+		upgrader.WithKubeContext(u.kubeContext),
+		upgrader.WithNamespace(u.openebsNamespace),
+		upgrader.WithQPS(u.qps),
+		upgrader.WithBurst(u.burst),
+		upgrader.WithLockTimeout(u.lockTimeout),
+		upgrader.WithNamespaceScoped(u.namespaceScoped),
+		upgrader.WithoutUpgradeTasks(u.noUpgradeTasks),
+	}
+	if u.simulator != nil {
+		opts = append(opts, upgrader.WithSimulator(u.simulator))
+	}
+	return opts
+}
+
+// backoff returns the backoff strategy of the backoffStrategy name,
+// which is validated in PreRun, or nil for the default of each wait
+func (u *UpgradeOptions) backoff() upgrader.BackoffStrategy {
+	b, err := upgrader.NewBackoffStrategy(u.backoffStrategy)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// resourcePatchOptions returns the options used to build the
+// resource patch for the given resource name
+func (u *UpgradeOptions) resourcePatchOptions(name string) []upgrader.ResourcePatchOptions {
+	opts := []upgrader.ResourcePatchOptions{
+		upgrader.FromVersion(u.fromVersion),
+		upgrader.ToVersion(u.toVersion),
+		upgrader.WithName(name),
+		upgrader.WithOpenebsNamespace(u.openebsNamespace),
+		upgrader.WithBaseURL(u.imageURLPrefix),
+		upgrader.WithImageRegistry(u.imageRegistry),
+		upgrader.WithImageTag(u.toVersionImageTag),
+		upgrader.WithVerifyOnly(u.verifyOnly),
+		upgrader.WithMinFreePoolSpace(u.minFreePoolSpace),
+		upgrader.WithMaxParallelVolumes(u.maxParallelVolumes),
+		upgrader.WithAllowOverprovisioned(u.allowOverprovisioned),
+		upgrader.WithAllowRBACRemoval(u.allowRBACRemoval),
+		upgrader.WithTransientMessages(u.transientMessages),
+		upgrader.WithReconcileFailureThreshold(u.reconcileThreshold),
+		upgrader.WithReconcileTimeout(u.reconcileTimeout),
+		upgrader.WithVersionStabilityPolls(u.stabilityPolls),
+		upgrader.WithReconcileStablePeriod(u.stablePeriod),
+		upgrader.WithPatchReapplyAttempts(u.patchReapplyAttempts),
+		upgrader.WithMaxParallelVerify(u.maxParallelVerify),
+		upgrader.WithPollInterval(u.pollInterval),
+		upgrader.WithSmokeTestStorageClass(u.smokeTestSC),
+		upgrader.WithSkipUpgradeAnnotation(u.skipAnnotation),
+		upgrader.WithOperatorVersionLabel(u.operatorVersionLabel),
+		upgrader.WithPreUpgradeHook(u.preUpgradeHook),
+		upgrader.WithPostUpgradeHook(u.postUpgradeHook),
+		upgrader.WithHookTimeout(u.hookTimeout),
+		upgrader.WithWaitForRebuild(u.waitForRebuild),
+		upgrader.WithRebuildTimeout(u.rebuildTimeout),
+		upgrader.WithVerifyImages(u.verifyImages),
+		upgrader.WithImagePullSecret(u.imagePullSecret),
+		upgrader.WithNodeAwareScheduling(u.nodeAwareScheduling),
+		upgrader.WithNUMAAware(u.numaAware),
+		upgrader.WithIgnoreNodePressure(u.ignoreNodePressure),
+		upgrader.WithPoolManagerImage(u.poolManagerImage),
+		upgrader.WithVerifyPoolCount(u.verifyPoolCount),
+		upgrader.WithCompletePendingExpansion(u.completeExpansion),
+		upgrader.WithLargeUpgradeThreshold(u.largeCSPCThreshold),
+		upgrader.WithConfirmLargeUpgrade(u.confirmLargeUpgrade),
+		upgrader.WithTargetCSPI(u.targetCSPI),
+		upgrader.WithVerifyBlockDevices(u.verifyBlockDevices),
+		upgrader.WithVerifyPodImages(u.verifyPodImages),
+		upgrader.WithBackoffStrategy(u.backoff()),
+		upgrader.WithCVCReadinessTimeout(u.cvcReadinessTimeout),
+		upgrader.WithLVMThinPool(u.lvmThinPool, u.lvmThinPoolSettings),
+		upgrader.WithAllowInUseUpgrades(u.allowInUseUpgrades),
+		upgrader.WithMinHealthyReplicas(u.minHealthyReplicas),
+		upgrader.WithReplicaWaitTimeout(u.replicaWaitTimeout),
+		upgrader.WithFailOnPendingResize(u.failOnPendingResize),
+		upgrader.WithSkipKubernetesVersionCheck(u.skipKubeVersionCheck),
+		upgrader.WithUpdateISCSIPortal(u.updateISCSIPortal),
+		upgrader.WithUpgradeClones(u.upgradeClones),
+		upgrader.WithSnapshotDriver(u.snapshotDriver),
+		upgrader.WithSnapshotClassParameters(u.snapshotClassParameters),
+		upgrader.WithStorageClassProvisioner(u.storageClassProvisioner),
+		upgrader.WithNewStorageClassName(u.newStorageClassName),
+		upgrader.WithDryRun(u.dryRun),
+		upgrader.WithDiffOutput(os.Stdout, u.diffFormat, u.diffColor()),
+		upgrader.WithAPIVersions(u.fromAPIVersion, u.toAPIVersion),
+		upgrader.WithEtcdImage(u.etcdImage),
+		upgrader.WithEtcdTLS(u.etcdCAFile, u.etcdCertFile, u.etcdKeyFile),
+		upgrader.WithRequireApproval(u.requireApproval),
+		upgrader.WithConsolidateUpgradeTasks(u.consolidateTasks),
+		upgrader.WithApprovalAnnotation(u.approvalAnnotation),
+		upgrader.WithFromVersionAnnotation(u.fromVersionAnnotation),
+		upgrader.WithApprovalTimeout(u.approvalTimeout),
+		upgrader.WithPodSecurityLevel(u.podSecurityLevel),
+		upgrader.WithMigratePSP(u.migratePSP),
+	}
+	if u.saveResult || u.reportOutputFile != "" || u.smtp.Enabled() {
+		opts = append(opts, upgrader.WithResult(u.upgradeResult()))
+	}
+	if u.maxUnavailable != "" {
+		maxUnavailable := intstr.Parse(u.maxUnavailable)
+		opts = append(opts, upgrader.WithMaxUnavailable(&maxUnavailable))
+	}
+	return opts
+}
+
+// printUpgradeTasks writes the yaml of the upgradetasks that would be
+// created for the given resource to stdout, separated as yaml documents
+func (u *UpgradeOptions) printUpgradeTasks(name string) error {
+	utasks, err := upgrade.GenerateTasks(u.resourceKind,
+		u.resourcePatchOptions(name),
+		u.clientOptions()...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to generate upgradetasks for %v", name)
+	}
+	return writeUpgradeTasks(os.Stdout, utasks)
+}
+
+// writeUpgradeTasks writes the yaml of the upgradetasks
+// to w, separated as yaml documents
+func writeUpgradeTasks(w io.Writer, utasks []*v1Alpha1API.UpgradeTask) error {
+	for _, utaskObj := range utasks {
+		data, err := yaml.Marshal(utaskObj)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to generate upgradetask %v", utaskObj.Name)
+		}
+		fmt.Fprintf(w, "---\n%s", data)
+	}
+	return nil
+}
+
+// RunWaitForVersion waits for the given resource to reconcile to the
+// to-version without patching it, for upgrades driven outside the job.
+func (u *UpgradeOptions) RunWaitForVersion(name string) error {
+	klog.Infof("Waiting for %s to reconcile to %s", name, u.toVersion)
+	err := upgrade.WaitForVersion(u.resourceKind,
+		u.resourcePatchOptions(name),
+		u.clientOptions()...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to wait for %v to reconcile", name)
+	}
+	klog.Infof("Successfully reconciled %s to %s", name, u.toVersion)
+	return nil
+}
+
+// RunSmokeTest provisions a test volume after the upgrade of the given
+// resource and verifies that data can be written and read from it.
+func (u *UpgradeOptions) RunSmokeTest(name string) error {
+	if !u.runSmokeTest || u.isDryRun() {
+		return nil
+	}
+	err := upgrade.SmokeTest(u.resourceKind,
+		u.resourcePatchOptions(name),
+		u.clientOptions()...)
+	if err != nil {
+		return errors.Wrapf(err, "Smoke test failed after upgrading %v", name)
+	}
+	return nil
+}
+
+// RunSmokeTests runs the smoke test after the upgrade of each of the
+// cspcs, as each cspc has its own pools. The test volume is provisioned
+// only once if the --smoke-test-storage-class is set, as it is then the
+// same for all the cspcs.
+func (u *UpgradeOptions) RunSmokeTests(names []string) error {
+	if u.smokeTestSC != "" {
+		names = names[len(names)-1:]
+	}
+	for _, name := range names {
+		err := u.RunSmokeTest(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunSelfTest runs the self test before the upgrade of a resource, or
+// only the self test if --self-test is set. The self test is skipped
+// when only the upgradetasks are generated.
+func (u *UpgradeOptions) RunSelfTest(cmd *cobra.Command) error {
+	// the access reviews are not served by the simulated cluster
+	if !u.selfTest && (u.skipSelfTest || u.isDryRun() || u.simulate || !cmd.HasParent()) {
+		return nil
+	}
+	err := upgrade.SelfTest(context.TODO(), u.clientOptions()...)
+	if err != nil {
+		return err
+	}
+	if u.selfTest {
+		os.Exit(0)
+	}
+	return nil
+}
+
+// upgradeResult returns the result shared by all the resources
+// upgraded in this run, which is only saved with --save-result
+func (u *UpgradeOptions) upgradeResult() *upgrader.UpgradeResult {
+	if u.result != nil {
+		return u.result
+	}
+	name := u.resultName
+	if !u.saveResult {
+		name = ""
+	} else if name == "" {
+		name = "upgrade-result-" + time.Now().UTC().Format("20060102-150405")
+	}
+	namespace := u.resultNamespace
+	if namespace == "" {
+		namespace = u.openebsNamespace
+	}
+	u.result = upgrader.NewUpgradeResult(name, namespace, u.fromVersion, u.toVersion)
+	return u.result
+}
+
+// isDryRun returns true if the resources are only inspected, either
+// to generate the upgradetasks, the precheck report or the dry run
+func (u *UpgradeOptions) isDryRun() bool {
+	return u.generateTasks || u.precheckReport != "" || u.dryRun
+}
+
+// diffColor returns true if the text diffs of the dry run are written
+// to a terminal, which is colored unless NO_COLOR is set
+func (u *UpgradeOptions) diffColor() bool {
+	return u.diffFormat == upgrader.DiffText && os.Getenv("NO_COLOR") == "" &&
+		terminal.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// printPrecheckReport writes the precheck report of the given resource to
+// stdout and returns a validation error if the report has any blocker
+func (u *UpgradeOptions) printPrecheckReport(name string) error {
+	err := upgrader.ValidateOutputFormat(u.precheckReport)
+	if err != nil {
+		return err
+	}
+	report, err := upgrade.PreFlight(u.resourceKind,
+		u.resourcePatchOptions(name),
+		u.clientOptions()...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to run the prechecks of %v", name)
+	}
+	err = upgrader.PrintOutput(os.Stdout, u.precheckReport, report)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// confirmUpgrade prints a summary of the upgrade of the given resources and
+// asks for a yes on stdin before the cluster is changed, if --confirm is set.
+// The prompt is skipped with --yes and for the runs that change nothing.
+func (u *UpgradeOptions) confirmUpgrade(names []string) error {
+	if !u.confirm || u.isDryRun() || u.verifyOnly {
+		return nil
+	}
+	summary := &upgrader.UpgradeSummary{
+		Kind:  u.resourceKind,
+		Names: names,
+		From:  u.fromVersion,
+		To:    u.toVersion,
+	}
+	err := summary.Write(os.Stderr)
+	if err != nil {
+		return err
+	}
+	return u.promptConfirmation()
+}
+
+// promptConfirmation asks for the confirmation of the
+// upgrade once its summary is written, unless --yes is set
+func (u *UpgradeOptions) promptConfirmation() error {
+	if u.yes {
+		klog.Info("Skipping the confirmation as --yes is set")
+		return nil
+	}
+	if u.fromStdin {
+		return errors.Errorf("Cannot confirm the upgrade: stdin is used for the upgradetasks, set --yes")
+	}
+	return upgrader.Confirm(os.Stdin, os.Stderr, u.confirmTimeout)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+)
+
+var (
+	planCmdHelpText = `
+This command generates the UpgradeTasks to upgrade all the cspcs,
+cStor volumes and jiva volumes older than the to-version, without
+upgrading them, and writes their yaml to stdout.
+
+The cspcs and cStor volumes are matched by the labels of the cspc and
+the cv, and the jiva volumes by the labels of the pv. With --apply the
+UpgradeTasks which do not exist yet are created, to be upgraded by the
+controller or by a later job. The existing UpgradeTasks are left as
+they are, so the same plan can be applied again.
+
+Usage: upgrade plan --to-version=<version> [--selector=<label>] [--apply]
+`
+)
+
+// PlanOptions stores the information required to plan the upgrade
+type PlanOptions struct {
+	selector string
+	apply    bool
+}
+
+var planOptions = &PlanOptions{}
+
+// NewPlanJob generates the UpgradeTasks of the resources to be upgraded
+func NewPlanJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "plan",
+		Short:   "Generate the UpgradeTasks of the resources to be upgraded",
+		Long:    planCmdHelpText,
+		Example: `upgrade plan --to-version=3.0.0 --selector=openebs.io/upgrade-batch=march --apply`,
+		// the resources are not upgraded, so the
+		// self test of the upgrade is not run
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			CheckError(initFromEnv(cmd))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			CheckError(options.RunPlan(planOptions))
+		},
+	}
+
+	cmd.Flags().StringVarP(&planOptions.selector,
+		"selector", "",
+		planOptions.selector,
+		"[optional] label selector of the cspcs, cvs and jiva pvs to upgrade.")
+
+	cmd.Flags().BoolVarP(&planOptions.apply,
+		"apply", "",
+		planOptions.apply,
+		"[optional] create the upgradetasks which do not exist yet.")
+
+	return cmd
+}
+
+// RunPlan writes the upgradetasks of the resources older than the
+// to-version to stdout, and creates them if apply is set
+func (u *UpgradeOptions) RunPlan(opts *PlanOptions) error {
+	if len(strings.TrimSpace(u.toVersion)) == 0 {
+		return errors.Errorf("Cannot plan the upgrade: to-version is missing")
+	}
+	utasks, err := upgrade.PlanTasks(opts.selector, opts.apply,
+		u.resourcePatchOptions(""),
+		u.clientOptions()...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to plan the upgrade")
+	}
+	return writeUpgradeTasks(os.Stdout, utasks)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// pluginNamespaceEnv is set by kubectl to the namespace
+	// of the current context when invoking a plugin
+	pluginNamespaceEnv = "KUBECTL_PLUGINS_CURRENT_NAMESPACE"
+)
+
+var (
+	pluginHelpText = `
+An utility to upgrade OpenEBS Storage Pools and Volumes, run as a kubectl plugin.
+
+The plugin is invoked by kubectl when the kubectl-openebs_upgrade binary
+is present in the PATH:
+
+  kubectl openebs-upgrade cstor-cspc --from-version=2.12.0 --to-version=3.0.0 <cspc-name>
+
+The kubeconfig is read from the --kubeconfig flag, the first path of the
+KUBECONFIG env or $HOME/.kube/config in that order. Unlike kubectl, the
+other paths of KUBECONFIG are not merged. The resources are looked up in
+the --openebs-namespace, or in the namespace of the current context with
+--use-context-namespace.
+
+The user of the kubeconfig requires the following RBAC permissions, the
+ones of the commands and checks which are not run can be left out:
+
+  - cstor.openebs.io:
+      cstorpoolclusters, cstorpoolinstances, cstorvolumereplicas,
+      cstorvolumepolicies: get, list, patch
+      cstorvolumes: get, list, patch, update
+      cstorvolumeconfigs: get, list, create, patch
+      cstorvolumeconfigs/status: update
+      cstorbackups, cstorrestores, cstorcompletedbackups: create
+      cstorpoolinstances: create, update, for the cstor-cspi-crd command
+      cstorengineconfigs: get, list, patch, for the cstor-engine-config command
+  - openebs.io:
+      upgradetasks: get, list, watch, create, update, delete
+      upgradeplans: list, for the controller command
+      upgradeplans/status: update, for the controller command
+      cstorbackups, cstorrestores, cstorcompletedbackups: list, deletecollection
+      blockdevices, blockdeviceclaims: get, for --verify-block-devices
+      cstorvolumeclaims: get, list, update, delete, for the cstor-cvc-api command
+      jivavolumes: get, patch
+  - apiextensions.k8s.io, for the cstor-cspi-crd command:
+      customresourcedefinitions, customresourcedefinitions/status: get, update
+  - rbac.authorization.k8s.io, for the rbac command:
+      clusterroles, clusterrolebindings: get, create, update
+  - authorization.k8s.io, for the self test:
+      selfsubjectaccessreviews: create
+  - coordination.k8s.io, to lock the cspcs and cStor volumes being upgraded:
+      leases: get, create, update, delete
+  - snapshot.storage.k8s.io, for the snapshot-class command:
+      volumesnapshotclasses: get, create, delete
+      volumesnapshotcontents: list, get, create, update, delete
+      volumesnapshots: get, create, delete
+  - policy, for the pod-security command:
+      podsecuritypolicies: get, patch
+  - storage.k8s.io, for the storage-class command and the smoke test:
+      storageclasses: get, list, create, delete
+  - apps:
+      deployments, statefulsets: get, list, patch
+  - core:
+      pods: get, list, create, delete
+      services: list, patch
+      nodes: get, list, for the node pressure check
+      persistentvolumes: get, list, update
+      persistentvolumeclaims: create, delete, for the smoke test
+      configmaps: get, create, update, for the upgrade result and report
+      secrets: get, to verify the images of a private registry
+      namespaces: get, patch, for the pod-security command
+  - batch:
+      jobs: get, create, for the lvm thin pool and iscsi portal jobs
+
+With --namespace-scoped only a Role is needed, as in deploy/rbac-namespaced.yaml.
+`
+)
+
+// NewPlugin will setup the upgrade utility as a kubectl plugin
+func NewPlugin() *cobra.Command {
+	cmd := NewJob()
+	cmd.Use = "kubectl-openebs_upgrade"
+	cmd.Short = "OpenEBS Upgrade kubectl plugin"
+	cmd.Long = pluginHelpText
+	cmd.PersistentPreRun = PluginPreRun
+	cmd.PersistentFlags().BoolVarP(&options.useContextNamespace,
+		"use-context-namespace", "",
+		options.useContextNamespace,
+		"[optional] look up the resources in the namespace of the current context if openebs-namespace is not set.")
+	return cmd
+}
+
+// PluginPreRun will read the kubeconfig the way kubectl does if it is
+// not provided as a flag, and then run the pre-run of the upgrade job.
+// The namespace of the current context is only used if asked for, as it
+// is usually not the one openebs is installed in.
+func PluginPreRun(cmd *cobra.Command, args []string) {
+	if !cmd.Flags().Changed("kubeconfig") {
+		options.kubeConfigPath = pluginKubeConfigPath(options.kubeConfigPath)
+	}
+	namespace := os.Getenv(pluginNamespaceEnv)
+	if options.useContextNamespace && len(strings.TrimSpace(namespace)) != 0 &&
+		!cmd.Flags().Changed("openebs-namespace") {
+		options.openebsNamespace = namespace
+	}
+	PreRun(cmd, args)
+}
+
+// pluginKubeConfigPath returns the first path of the KUBECONFIG env or
+// else the kubeconfig in the home directory, as the plugin always runs
+// outside the cluster. Unlike kubectl the paths of KUBECONFIG are not
+// merged, as the upgrade is built from a single kubeconfig.
+func pluginKubeConfigPath(defaultPath string) string {
+	for _, path := range filepath.SplitList(os.Getenv(clientcmd.RecommendedConfigPathEnvVar)) {
+		if path != "" {
+			return path
+		}
+	}
+	if _, err := os.Stat(clientcmd.RecommendedHomeFile); err == nil {
+		return clientcmd.RecommendedHomeFile
+	}
+	return defaultPath
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const pluginSimulatedCluster = `apiVersion: cstor.openebs.io/v1
+kind: CStorPoolCluster
+metadata:
+  name: cspc-a
+  namespace: openebs
+versionDetails:
+  desired: 2.12.0
+  status:
+    current: 2.12.0
+`
+
+// runPluginPreRun runs the pre-run of the plugin for the cstor-cspc
+// command against a simulated cluster, with the given extra flags
+func runPluginPreRun(t *testing.T, flags ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	simCluster := filepath.Join(dir, "cluster.yaml")
+	err := ioutil.WriteFile(simCluster, []byte(pluginSimulatedCluster), 0600)
+	if err != nil {
+		t.Fatalf("failed to write the simulated cluster: %v", err)
+	}
+	plugin := NewPlugin()
+	cmd, args, err := plugin.Find([]string{"cstor-cspc", "cspc-a"})
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	err = cmd.ParseFlags(append([]string{
+		"--from-version=2.12.0", "--to-version=3.0.0", "--simulate",
+		"--sim-cluster=" + simCluster,
+		// the real cluster cannot be reached with this kubeconfig
+		"--kubeconfig=" + filepath.Join(dir, "missing-kubeconfig"),
+	}, flags...))
+	if err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+	plugin.PersistentPreRun(cmd, args)
+}
+
+func TestPluginPreRunSimulate(t *testing.T) {
+	defer func() { options.simulator = nil }()
+
+	runPluginPreRun(t)
+	if options.simulator == nil {
+		t.Fatalf("PluginPreRun() did not set up the simulated cluster")
+	}
+	u, err := upgrader.NewClusterUpgrade(options.clientOptions()...)
+	if err != nil {
+		t.Fatalf("NewClusterUpgrade() error = %v, want the clients of the simulated cluster", err)
+	}
+	if u.Client.Config != nil {
+		t.Errorf("NewClusterUpgrade() built a config of the real cluster")
+	}
+	_, err = u.Client.OpenebsClientset.CstorV1().CStorPoolClusters("openebs").
+		Get(context.TODO(), "cspc-a", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("failed to get cspc-a from the simulated cluster: %v", err)
+	}
+}
+
+func TestPluginPreRunNamespace(t *testing.T) {
+	tests := map[string]struct {
+		contextNamespace string
+		flags            []string
+		want             string
+	}{
+		"context namespace is not used by default": {
+			contextNamespace: "default",
+			want:             "openebs",
+		},
+		"context namespace is used if asked for": {
+			contextNamespace: "openebs-system",
+			flags:            []string{"--use-context-namespace"},
+			want:             "openebs-system",
+		},
+		"openebs namespace is preferred to the context namespace": {
+			contextNamespace: "default",
+			flags:            []string{"--use-context-namespace", "--openebs-namespace=openebs"},
+			want:             "openebs",
+		},
+		"empty context namespace is ignored": {
+			flags: []string{"--use-context-namespace"},
+			want:  "openebs",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			namespace := options.openebsNamespace
+			defer func() {
+				options.simulator = nil
+				options.openebsNamespace = namespace
+				options.useContextNamespace = false
+			}()
+			os.Setenv(pluginNamespaceEnv, tt.contextNamespace)
+			defer os.Unsetenv(pluginNamespaceEnv)
+
+			runPluginPreRun(t, tt.flags...)
+			if options.openebsNamespace != tt.want {
+				t.Errorf("PluginPreRun() namespace = %s, want %s", options.openebsNamespace, tt.want)
+			}
+		})
+	}
+}
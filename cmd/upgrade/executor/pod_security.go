@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	podSecurityUpgradeCmdHelpText = `
+This command upgrades the pod security of the OpenEBS components.
+Before kubernetes 1.25 the given PodSecurityPolicy is upgraded, and
+converted to the pod security admission label of the openebs namespace
+if --migrate-psp is set. From kubernetes 1.25 the PodSecurityPolicies
+are removed and only the label of the namespace is set.
+
+Usage: upgrade pod-security [psp-name] --options...
+`
+)
+
+// NewUpgradePodSecurityJob upgrades the pod security of the components
+func NewUpgradePodSecurityJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "pod-security",
+		Short:   "Upgrade the PodSecurityPolicy or the pod security admission labels",
+		Long:    podSecurityUpgradeCmdHelpText,
+		Example: `upgrade pod-security openebs-privileged --from-version=2.12.0 --to-version=3.0.0 --migrate-psp`,
+		Args:    cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			options.resourceKind = "podSecurity"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(args))
+			CheckError(options.RunPodSecurityUpgrade(cmd, args))
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.podSecurityLevel,
+		"pod-security-level", "",
+		options.podSecurityLevel,
+		"[optional] pod security admission level enforced in the openebs namespace, one of privileged, baseline or restricted. If not specified, it is derived from the PodSecurityPolicy, or privileged from kubernetes 1.25")
+
+	cmd.Flags().BoolVarP(&options.migratePSP,
+		"migrate-psp", "",
+		options.migratePSP,
+		"[optional] convert the PodSecurityPolicy to the pod security admission label of the openebs namespace before kubernetes 1.25.")
+
+	return cmd
+}
+
+// RunPodSecurityUpgrade upgrades the pod security of the components.
+func (u *UpgradeOptions) RunPodSecurityUpgrade(cmd *cobra.Command, args []string) error {
+
+	if u.generateTasks {
+		return errors.Errorf("Upgradetasks are not used for the pod security upgrade")
+	}
+	if u.waitForVersion {
+		return errors.Errorf("Waiting for the version is not supported for the pod security upgrade")
+	}
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the pod security upgrade")
+	}
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	if u.isValidVersion() {
+		klog.Infof("Upgrading pod security to %s", u.toVersion)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to upgrade pod security")
+		}
+		klog.Infof("Successfully upgraded pod security to %s", u.toVersion)
+	} else {
+		return errors.Errorf("Invalid from version %s or to version %s", u.fromVersion, u.toVersion)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	rbacUpgradeCmdHelpText = `
+This command upgrades the ClusterRoles and ClusterRoleBindings
+of the OpenEBS operators to the rules expected by the to-version.
+Rules and subjects are only removed if --allow-rbac-removal is set.
+
+Usage: upgrade rbac --options...
+`
+)
+
+// NewUpgradeRBACJob upgrades the rbac resources of the operators
+func NewUpgradeRBACJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "rbac",
+		Short:   "Upgrade operator RBAC",
+		Long:    rbacUpgradeCmdHelpText,
+		Example: `upgrade rbac --from-version=2.12.0 --to-version=3.0.0`,
+		Run: func(cmd *cobra.Command, args []string) {
+			options.resourceKind = "rbac"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(nil))
+			CheckError(options.RunRBACUpgrade(cmd))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&options.allowRBACRemoval,
+		"allow-rbac-removal", "",
+		options.allowRBACRemoval,
+		"[optional] allow removing rules and subjects that are not present in the to-version.")
+
+	return cmd
+}
+
+// RunRBACUpgrade upgrades the rbac resources of the operators.
+func (u *UpgradeOptions) RunRBACUpgrade(cmd *cobra.Command) error {
+
+	if u.generateTasks {
+		return errors.Errorf("Upgradetasks are not used for the rbac upgrade")
+	}
+	if u.waitForVersion {
+		return errors.Errorf("Waiting for the version is not supported for the rbac upgrade")
+	}
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the rbac upgrade")
+	}
+	if u.isValidVersion() {
+		klog.Infof("Upgrading rbac to %s", u.toVersion)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(""),
+			u.clientOptions()...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to upgrade rbac")
+		}
+		klog.Infof("Successfully upgraded rbac to %s", u.toVersion)
+	} else {
+		return errors.Errorf("Invalid from version %s or to version %s", u.fromVersion, u.toVersion)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	regenerateJobsCmdHelpText = `
+This command generates the upgrade Jobs which re-run the upgrades of
+the failed UpgradeTasks in the openebs namespace, for example after
+the pod of the failed upgrade Job was deleted.
+
+The Jobs are configured with the RESOURCE_NAME, FROM_VERSION and
+TO_VERSION env of each UpgradeTask, and run the upgrade image tagged
+with its to version. The manifests are printed, and with --apply the
+Jobs are created as well.
+
+Usage: upgrade regenerate-jobs [--apply] [--service-account=openebs-maya-operator] [--image=<image>]
+`
+)
+
+// RegenerateJobsOptions stores the information required to regenerate the jobs
+type RegenerateJobsOptions struct {
+	apply bool
+	upgrader.UpgradeJobConfig
+}
+
+var regenerateJobsOptions = &RegenerateJobsOptions{
+	UpgradeJobConfig: upgrader.UpgradeJobConfig{
+		ServiceAccount: upgrader.DefaultUpgradeJobServiceAccount,
+	},
+}
+
+// NewRegenerateJobsJob generates the upgrade jobs of the failed upgradetasks
+func NewRegenerateJobsJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "regenerate-jobs",
+		Short:   "Generate the upgrade Jobs which re-run the failed UpgradeTasks",
+		Long:    regenerateJobsCmdHelpText,
+		Example: `upgrade regenerate-jobs --apply`,
+		// the jobs run the upgrade, so the self
+		// test of the upgrade is not run here
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			CheckError(initFromEnv(cmd))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			CheckError(options.RunRegenerateJobs(os.Stdout, regenerateJobsOptions))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&regenerateJobsOptions.apply,
+		"apply", "",
+		regenerateJobsOptions.apply,
+		"[optional] create the jobs in addition to printing their manifests.")
+
+	cmd.Flags().StringVarP(&regenerateJobsOptions.ServiceAccount,
+		"service-account", "",
+		regenerateJobsOptions.ServiceAccount,
+		"[optional] service account the jobs are run with.")
+
+	cmd.Flags().StringVarP(&regenerateJobsOptions.Image,
+		"image", "",
+		regenerateJobsOptions.Image,
+		"[optional] image of the jobs. If not specified, the upgrade image of the to-version of each upgradetask will be used")
+
+	return cmd
+}
+
+// RunRegenerateJobs writes the manifests of the jobs re-running the failed
+// upgradetasks to w, and creates the jobs with apply unless it is a dry run
+func (u *UpgradeOptions) RunRegenerateJobs(w io.Writer, opts *RegenerateJobsOptions) error {
+	jobs, err := upgrade.RegenerateUpgradeJobs(u.openebsNamespace, opts.UpgradeJobConfig,
+		opts.apply && !u.isDryRun(), u.clientOptions()...)
+	if err != nil {
+		return err
+	}
+	return writeJobs(w, jobs)
+}
+
+// writeJobs writes the yaml of the jobs to w, separated as yaml documents
+func writeJobs(w io.Writer, jobs []*batchv1.Job) error {
+	for _, jobObj := range jobs {
+		data, err := yaml.Marshal(jobObj)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to generate job %v", jobObj.Name)
+		}
+		fmt.Fprintf(w, "---\n%s", data)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	"k8s.io/klog"
+)
+
+// upgradeReportOnce writes the report of the upgrade only once, as it
+// is written on the exit after an error as well as after the run
+var upgradeReportOnce sync.Once
+
+// writeUpgradeReport writes the report of the resources upgraded in
+// this run to the result configmap with --save-result, to the
+// --report-output-file if set, and emails it with --smtp-host.
+// Any failure is only logged.
+func (u *UpgradeOptions) writeUpgradeReport() {
+	if u.result == nil {
+		return
+	}
+	upgradeReportOnce.Do(func() {
+		err := upgrade.SaveReport(u.result, u.clientOptions()...)
+		if err != nil {
+			klog.Warningf("Failed to save the upgrade report: %v", err)
+		}
+		if u.reportOutputFile != "" {
+			err = u.writeReportFile()
+			if err != nil {
+				klog.Warningf("Failed to write the upgrade report to %s: %v", u.reportOutputFile, err)
+			} else {
+				klog.Infof("Wrote the upgrade report to %s", u.reportOutputFile)
+			}
+		}
+		if u.smtp.Enabled() {
+			err = u.smtp.Notify(u.result.Report())
+			if err != nil {
+				klog.Warningf("Failed to email the upgrade report: %v", err)
+			} else {
+				klog.Infof("Emailed the upgrade report to %s", strings.Join(u.smtp.To, ", "))
+			}
+		}
+	})
+}
+
+func (u *UpgradeOptions) writeReportFile() error {
+	f, err := os.Create(u.reportOutputFile)
+	if err != nil {
+		return err
+	}
+	err = u.result.Report().Write(f, u.reportFormat)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/pkg/errors"
+)
+
+func TestWriteUpgradeReportOnPartialFailure(t *testing.T) {
+	u := &UpgradeOptions{
+		fromVersion:      "2.12.0",
+		toVersion:        "3.0.0",
+		reportOutputFile: filepath.Join(t.TempDir(), "report.json"),
+		reportFormat:     upgrader.ReportJSON,
+	}
+	result := u.upgradeResult()
+	if result.Name != "" {
+		t.Errorf("upgradeResult() name = %s, want none without --save-result", result.Name)
+	}
+	result.Add("cstorPoolInstance", "cspi-1", nil)
+	result.Add("cstorPoolInstance", "cspi-2", errors.New("failed to patch"))
+	u.writeUpgradeReport()
+
+	data, err := ioutil.ReadFile(u.reportOutputFile)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	got := upgrader.PostUpgradeReport{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("failed to unmarshal report: %v", err)
+	}
+	if got.Upgraded != 1 || got.Failed != 1 {
+		t.Errorf("report = %d upgraded, %d failed, want 1 of each", got.Upgraded, got.Failed)
+	}
+}
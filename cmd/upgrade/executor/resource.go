@@ -21,7 +21,6 @@ import (
 	"os"
 	"strings"
 
-	"k8s.io/client-go/rest"
 	"k8s.io/klog"
 
 	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
@@ -29,11 +28,12 @@ import (
 	"github.com/openebs/maya/pkg/util"
 	cmdUtil "github.com/openebs/upgrade/cmd/util"
 	upgrade "github.com/openebs/upgrade/pkg/upgrade"
-	"github.com/openebs/upgrade/pkg/version"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
 	errors "github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 var (
@@ -41,7 +41,12 @@ var (
 This command upgrades the resource mentioned in the UpgradeTask CR.
 The name of the UpgradeTask CR is extracted from the ENV UPGRADE_TASK
 
-Usage: upgrade resource
+With --from-stdin the UpgradeTasks are read from stdin as yaml or json
+documents separated by ---. All the tasks are validated before any of
+them is created, the pools are upgraded before the volumes and the
+tasks are deleted once all the upgrades are successful.
+
+Usage: upgrade resource [--from-stdin]
 `
 )
 
@@ -52,14 +57,22 @@ type ResourceOptions struct {
 
 // NewUpgradeResourceJob upgrade a resource from upgradeTask
 func NewUpgradeResourceJob() *cobra.Command {
-	client, err := initClient()
-	util.CheckErr(err, util.Fatal)
 	cmd := &cobra.Command{
 		Use:     "resource",
 		Short:   "Upgrade a resource using the details specified in the UpgradeTask CR.",
 		Long:    resourceUpgradeCmdHelpText,
 		Example: `upgrade resource`,
 		Run: func(cmd *cobra.Command, args []string) {
+			if options.noUpgradeTasks {
+				util.Fatal("failed to upgrade: the resource command runs the upgradetasks, it can not be used with --no-upgrade-tasks")
+			}
+			if options.fromStdin {
+				CheckError(options.RunUpgradeTasksFromStdin(cmd, os.Stdin))
+				options.RunCleanup()
+				return
+			}
+			client, err := initClient(options)
+			util.CheckErr(err, util.Fatal)
 			upgradeTaskLabel := cmdUtil.GetUpgradeTaskLabel()
 			openebsNamespace := cmdUtil.GetOpenEBSNamespace()
 			upgradeTaskList, err := client.OpenebsV1alpha1().UpgradeTasks(openebsNamespace).
@@ -82,7 +95,7 @@ func NewUpgradeResourceJob() *cobra.Command {
 					if uerr != nil {
 						util.Fatal(uerr.Error())
 					}
-					backoffLimit, uerr := getBackoffLimit(openebsNamespace)
+					backoffLimit, uerr := getBackoffLimit(openebsNamespace, options)
 					if uerr != nil {
 						util.Fatal(uerr.Error())
 					}
@@ -96,7 +109,7 @@ func NewUpgradeResourceJob() *cobra.Command {
 					if uerr != nil {
 						util.Fatal(uerr.Error())
 					}
-					util.Fatal(err.Error())
+					CheckError(err)
 				} else {
 					utaskObj, uerr := client.OpenebsV1alpha1().UpgradeTasks(openebsNamespace).
 						Get(context.TODO(), cr.Name, metav1.GetOptions{})
@@ -112,8 +125,15 @@ func NewUpgradeResourceJob() *cobra.Command {
 					}
 				}
 			}
+			options.RunCleanup()
 		},
 	}
+
+	cmd.Flags().BoolVarP(&options.fromStdin,
+		"from-stdin", "",
+		options.fromStdin,
+		"[optional] read the UpgradeTasks from stdin, create them, upgrade the resources in order and delete the UpgradeTasks on completion.")
+
 	return cmd
 }
 
@@ -173,14 +193,14 @@ func (u *UpgradeOptions) RunResourceUpgradeChecks(cmd *cobra.Command) error {
 
 // RunResourceUpgrade upgrades the given upgradeTask
 func (u *UpgradeOptions) RunResourceUpgrade(cmd *cobra.Command) error {
-	if version.IsCurrentVersionValid(u.fromVersion) && version.IsDesiredVersionValid(u.toVersion) {
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the upgradetasks")
+	}
+	if u.isValidVersion() {
 		klog.Infof("Upgrading %s from %s to %s", u.resourceKind, u.fromVersion, u.toVersion)
-		err := upgrade.Exec(u.fromVersion, u.toVersion,
-			u.resourceKind,
-			u.name,
-			u.openebsNamespace,
-			u.imageURLPrefix,
-			u.toVersionImageTag)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(u.name),
+			u.clientOptions()...)
 		if err != nil {
 			return errors.Wrapf(err, "Failed to upgrade %v %v", u.resourceKind, u.name)
 		}
@@ -190,11 +210,24 @@ func (u *UpgradeOptions) RunResourceUpgrade(cmd *cobra.Command) error {
 	return nil
 }
 
-func initClient() (openebsclientset.Interface, error) {
-	cfg, err := rest.InClusterConfig()
+// restConfig returns the rest config with the client rate limits
+func (u *UpgradeOptions) restConfig() (*rest.Config, error) {
+	cfg, err := upgrader.BuildConfigForContext(u.kubeConfigPath, u.kubeContext, u.masterURL)
 	if err != nil {
 		return nil, errors.Wrap(err, "error building kubeconfig")
 	}
+	upgrader.SetRateLimits(cfg, u.qps, u.burst)
+	return cfg, nil
+}
+
+func initClient(u *UpgradeOptions) (openebsclientset.Interface, error) {
+	if u.simulator != nil {
+		return u.simulator.Client().OpenebsClientset, nil
+	}
+	cfg, err := u.restConfig()
+	if err != nil {
+		return nil, err
+	}
 	client, err := openebsclientset.NewForConfig(cfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "error building openebs clientset")
@@ -202,10 +235,25 @@ func initClient() (openebsclientset.Interface, error) {
 	return client, nil
 }
 
-func getBackoffLimit(openebsNamespace string) (int, error) {
-	cfg, err := rest.InClusterConfig()
+func initKubeClient(u *UpgradeOptions) (kubernetes.Interface, error) {
+	if u.simulator != nil {
+		return u.simulator.Client().KubeClientset, nil
+	}
+	cfg, err := u.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "error building kubernetes clientset")
+	}
+	return client, nil
+}
+
+func getBackoffLimit(openebsNamespace string, u *UpgradeOptions) (int, error) {
+	cfg, err := u.restConfig()
 	if err != nil {
-		return 0, errors.Wrap(err, "error building kubeconfig")
+		return 0, err
 	}
 	client, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
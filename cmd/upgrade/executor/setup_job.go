@@ -18,9 +18,11 @@ package executor
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"strings"
 
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
 	"github.com/spf13/cobra"
 )
 
@@ -32,20 +34,45 @@ func NewJob() *cobra.Command {
 		Short: "OpenEBS Upgrade Utility",
 		Long: `An utility to upgrade OpenEBS Storage Pools and Volumes,
 			run as a Kubernetes Job`,
-		PersistentPreRun: PreRun,
+		PersistentPreRun:  PreRun,
+		PersistentPostRun: PostRun,
+		Run: func(cmd *cobra.Command, args []string) {
+			_ = cmd.Help()
+		},
 	}
 
 	cmd.AddCommand(
 		NewUpgradeCStorCSPCJob(),
+		NewUpgradeCStorCSPIJob(),
 		NewUpgradeCStorVolumeJob(),
 		NewUpgradeResourceJob(),
 		NewUpgradeJivaVolumeJob(),
+		NewUpgradeRBACJob(),
+		NewUpgradeSnapshotClassJob(),
+		NewUpgradeStorageClassJob(),
+		NewUpgradeCStorCSPICRDJob(),
+		NewUpgradeEtcdJob(),
+		NewUpgradePodSecurityJob(),
+		NewUpgradeCStorEngineConfigJob(),
+		NewUpgradeCStorCVCAPIJob(),
+		NewCleanupJob(),
+		NewStatusJob(),
+		NewWatchJob(),
+		NewListPendingJob(),
+		NewPlanJob(),
+		NewControllerJob(),
+		NewRegenerateJobsJob(),
 	)
 
 	cmd.PersistentFlags().StringVarP(&options.fromVersion,
 		"from-version", "",
 		options.fromVersion,
-		"current version of the resource.")
+		"current version of the resource, read from the resource if not set for the pools and volumes.")
+
+	cmd.PersistentFlags().StringVarP(&options.fromVersionAnnotation,
+		"from-version-annotation", "",
+		options.fromVersionAnnotation,
+		"[optional] annotation of the pools and volumes which overrides their from-version, for batches of resources at mixed versions. Defaults to "+upgrader.DefaultFromVersionAnnotation)
 
 	cmd.PersistentFlags().StringVarP(&options.toVersion,
 		"to-version", "",
@@ -62,11 +89,316 @@ func NewJob() *cobra.Command {
 		options.imageURLPrefix,
 		"[optional] custom image prefix.")
 
+	cmd.PersistentFlags().StringVarP(&options.imageRegistry,
+		"image-registry-override", "",
+		options.imageRegistry,
+		"[optional] registry to pull all the upgraded images from, keeping their org and name.")
+
 	cmd.PersistentFlags().StringVarP(&options.toVersionImageTag,
 		"to-version-image-tag", "",
 		options.toVersionImageTag,
 		"[optional] custom image tag. If not specified, to-version will be used")
 
+	cmd.PersistentFlags().StringVarP(&options.kubeConfigPath,
+		"kubeconfig", "",
+		options.kubeConfigPath,
+		"[optional] path to the kubeconfig file. If not specified, in-cluster config will be used")
+
+	cmd.PersistentFlags().StringVarP(&options.masterURL,
+		"master", "",
+		options.masterURL,
+		"[optional] address of the kubernetes api server. Overrides any value in kubeconfig")
+
+	cmd.PersistentFlags().StringVarP(&options.kubeContext,
+		"context", "",
+		options.kubeContext,
+		"[optional] context of the kubeconfig to use. If not specified, the current context will be used")
+
+	cmd.PersistentFlags().BoolVarP(&options.verifyOnly,
+		"verify-only", "",
+		options.verifyOnly,
+		"[optional] skip patching and only verify the version reconciliation of already patched resources.")
+
+	cmd.PersistentFlags().StringSliceVarP(&options.transientMessages,
+		"transient-reconcile-messages", "",
+		options.transientMessages,
+		"[optional] reconcile messages to retry without counting them as failures. If not specified, common connection errors are used")
+
+	cmd.PersistentFlags().IntVarP(&options.reconcileThreshold,
+		"reconcile-failure-threshold", "",
+		options.reconcileThreshold,
+		"[optional] number of reconcile failures after which the upgrade fails. If not specified, the reconciliation is retried forever")
+
+	cmd.PersistentFlags().DurationVarP(&options.reconcileTimeout,
+		"reconcile-timeout", "",
+		options.reconcileTimeout,
+		"[optional] time after which the upgrade fails if the version is not reconciled. If not specified, the reconciliation is waited for forever")
+
+	cmd.PersistentFlags().IntVarP(&options.stabilityPolls,
+		"version-stability-polls", "",
+		options.stabilityPolls,
+		"[optional] number of polls the version must stay at the to-version once reconciled, set to 0 to not wait")
+
+	cmd.PersistentFlags().DurationVarP(&options.stablePeriod,
+		"reconcile-stable-period", "",
+		options.stablePeriod,
+		"[optional] time the version must stay at the to-version once reconciled, for operators flapping between versions. If not specified, only the version stability polls are waited for")
+
+	cmd.PersistentFlags().DurationVarP(&options.pollInterval,
+		"poll-interval", "",
+		options.pollInterval,
+		"[optional] initial interval to poll for the version reconciliation, doubled up to 5m while the resource is not reconciled.")
+
+	cmd.PersistentFlags().BoolVarP(&options.generateTasks,
+		"generate-tasks", "",
+		options.generateTasks,
+		"[optional] print the upgradetasks that would be created as yaml, without creating them or upgrading the resources.")
+
+	cmd.PersistentFlags().BoolVarP(&options.runSmokeTest,
+		"run-smoke-test", "",
+		options.runSmokeTest,
+		"[optional] provision a test volume after the upgrade, on each of the upgraded cspcs, and verify that data can be written and read.")
+
+	cmd.PersistentFlags().StringVarP(&options.smokeTestSC,
+		"smoke-test-storage-class", "",
+		options.smokeTestSC,
+		"[optional] storageclass for the smoke test volume. If not specified, the storageclass of the upgraded resource is used")
+
+	cmd.PersistentFlags().StringVarP(&options.preUpgradeHook,
+		"pre-upgrade-hook", "",
+		options.preUpgradeHook,
+		"[optional] path to an executable run before the upgrade of each resource. A failure aborts the upgrade")
+
+	cmd.PersistentFlags().StringVarP(&options.postUpgradeHook,
+		"post-upgrade-hook", "",
+		options.postUpgradeHook,
+		"[optional] path to an executable run after the upgrade of each resource. A failure is logged as a warning")
+
+	cmd.PersistentFlags().DurationVarP(&options.hookTimeout,
+		"hook-timeout", "",
+		options.hookTimeout,
+		"[optional] time after which the pre and post upgrade hooks are killed.")
+
+	cmd.PersistentFlags().BoolVarP(&options.selfTest,
+		"self-test", "",
+		options.selfTest,
+		"[optional] only run the self test, which verifies the api server, namespace, crds and permissions required for the upgrade.")
+
+	cmd.PersistentFlags().BoolVarP(&options.skipSelfTest,
+		"skip-self-test", "",
+		options.skipSelfTest,
+		"[optional] skip the self test that is run before the upgrade.")
+
+	cmd.PersistentFlags().BoolVarP(&options.saveResult,
+		"save-result", "",
+		options.saveResult,
+		"[optional] save the outcome of each upgraded resource in a configmap that outlives the job.")
+
+	cmd.PersistentFlags().StringVarP(&options.resultName,
+		"result-configmap-name", "",
+		options.resultName,
+		"[optional] name of the configmap to save the result in. If not specified, upgrade-result-<timestamp> is used")
+
+	cmd.PersistentFlags().StringVarP(&options.resultNamespace,
+		"result-configmap-namespace", "",
+		options.resultNamespace,
+		"[optional] namespace of the configmap to save the result in. If not specified, openebs-namespace is used")
+
+	cmd.PersistentFlags().StringVarP(&options.reportOutputFile,
+		"report-output-file", "",
+		options.reportOutputFile,
+		"[optional] file the report of the upgraded resources is written to at the end of the upgrade.")
+
+	cmd.PersistentFlags().StringVarP(&options.reportFormat,
+		"report-format", "",
+		options.reportFormat,
+		"[optional] format of the report written to --report-output-file, one of json or text.")
+
+	cmd.PersistentFlags().StringVarP(&options.smtp.Host,
+		"smtp-host", "",
+		options.smtp.Host,
+		"[optional] smtp server the report of the upgrade is emailed through at the end of the upgrade.")
+
+	cmd.PersistentFlags().IntVarP(&options.smtp.Port,
+		"smtp-port", "",
+		options.smtp.Port,
+		"[optional] port of the smtp server of --smtp-host.")
+
+	cmd.PersistentFlags().StringVarP(&options.smtp.From,
+		"smtp-from", "",
+		options.smtp.From,
+		"[optional] address the report email of --smtp-host is sent from.")
+
+	cmd.PersistentFlags().StringSliceVarP(&options.smtp.To,
+		"smtp-to", "",
+		options.smtp.To,
+		"[optional] addresses the report email of --smtp-host is sent to.")
+
+	cmd.PersistentFlags().StringVarP(&options.smtp.SubjectPrefix,
+		"smtp-subject-prefix", "",
+		options.smtp.SubjectPrefix,
+		"[optional] prefix of the subject of the report email of --smtp-host.")
+
+	cmd.PersistentFlags().StringVarP(&options.smtp.Username,
+		"smtp-username", "",
+		options.smtp.Username,
+		"[optional] username to authenticate with the smtp server of --smtp-host. Can also be set with "+upgrader.EnvSMTPUsername)
+
+	cmd.PersistentFlags().StringVarP(&options.smtp.Password,
+		"smtp-password", "",
+		options.smtp.Password,
+		"[optional] password to authenticate with the smtp server of --smtp-host. Prefer setting it with "+upgrader.EnvSMTPPassword)
+
+	cmd.PersistentFlags().StringVarP(&options.smtp.Auth,
+		"smtp-auth", "",
+		options.smtp.Auth,
+		"[optional] auth mechanism of --smtp-username, one of PLAIN or LOGIN. If not specified, PLAIN is used.")
+
+	cmd.PersistentFlags().BoolVarP(&options.smtp.TLS,
+		"smtp-tls", "",
+		options.smtp.TLS,
+		"[optional] connect to the smtp server of --smtp-host over tls, as on port 465. Otherwise STARTTLS is used if the server supports it.")
+
+	cmd.PersistentFlags().BoolVarP(&options.cleanup,
+		"cleanup", "",
+		options.cleanup,
+		"[optional] delete the successful upgradetasks at the end of the upgrade.")
+
+	cmd.PersistentFlags().BoolVarP(&options.verifyImages,
+		"verify-images", "",
+		options.verifyImages,
+		"[optional] verify the images of the to-version exist in the registry before upgrading.")
+
+	cmd.PersistentFlags().StringVarP(&options.imagePullSecret,
+		"image-pull-secret", "",
+		options.imagePullSecret,
+		"[optional] secret in the openebs-namespace used to authenticate to the registry when verifying images.")
+
+	cmd.PersistentFlags().StringVarP(&options.operatorVersionLabel,
+		"operator-version-label", "",
+		options.operatorVersionLabel,
+		"[optional] label key of the operator pods with the operator version, defaults to openebs.io/version.")
+
+	cmd.PersistentFlags().BoolVarP(&options.waitForVersion,
+		"wait-for-version", "",
+		options.waitForVersion,
+		"[optional] only wait for the resources patched outside the upgrade to reconcile to the to-version, from-version is not required.")
+
+	cmd.PersistentFlags().StringVarP(&options.backoffStrategy,
+		"backoff-strategy", "",
+		options.backoffStrategy,
+		"[optional] backoff of the polls and the cspi retries, one of constant, exponential or capped-exponential. If not specified, the reconcile polls double up to 5m and the other polls are constant.")
+
+	cmd.PersistentFlags().Float32VarP(&options.qps,
+		"qps", "",
+		options.qps,
+		"[optional] maximum queries per second from the upgrade to the kubernetes api server.")
+
+	cmd.PersistentFlags().IntVarP(&options.burst,
+		"burst", "",
+		options.burst,
+		"[optional] maximum burst of queries from the upgrade to the kubernetes api server.")
+
+	cmd.PersistentFlags().DurationVarP(&options.lockTimeout,
+		"lock-timeout", "",
+		options.lockTimeout,
+		"[optional] time after which the lock of a cspc or cStor volume being upgraded expires if the upgrade holding it stops renewing it.")
+
+	cmd.PersistentFlags().BoolVarP(&options.allowCustomVersions,
+		"allow-custom-versions", "",
+		options.allowCustomVersions,
+		"[optional] allow upgrading from or to custom builds with commit or ci-<tag> versions, skipping the compatibility checks.")
+
+	cmd.PersistentFlags().BoolVarP(&options.skipKubeVersionCheck,
+		"skip-kubernetes-version-check", "",
+		options.skipKubeVersionCheck,
+		"[optional] upgrade even if the kubernetes cluster is older than the minimum kubernetes version of the to-version.")
+
+	cmd.PersistentFlags().StringVarP(&options.visualize,
+		"visualize", "",
+		options.visualize,
+		"[optional] print the dependency graph of the upgrade relevant resources as dot or mermaid and exit.")
+
+	cmd.PersistentFlags().BoolVarP(&options.analyzeImpact,
+		"analyze-impact", "",
+		options.analyzeImpact,
+		"[optional] print the estimated number of affected volumes, restarted cspis and downtime of the upgrade and exit.")
+
+	cmd.PersistentFlags().StringVarP(&options.precheckReport,
+		"precheck-report", "",
+		options.precheckReport,
+		"[optional] print the findings of the prechecks as table, json or yaml without upgrading the resources.")
+
+	cmd.PersistentFlags().BoolVarP(&options.namespaceScoped,
+		"namespace-scoped", "",
+		options.namespaceScoped,
+		"[optional] only access the resources in the openebs namespace, so that a Role and RoleBinding are enough. The node and volume usage checks are skipped.")
+
+	cmd.PersistentFlags().BoolVarP(&options.noUpgradeTasks,
+		"no-upgrade-tasks", "",
+		options.noUpgradeTasks,
+		"[optional] do not read, create or update the upgradetasks, only patching and verifying the resources.")
+
+	cmd.PersistentFlags().StringSliceVarP(&options.knownReleases,
+		"known-releases", "",
+		options.knownReleases,
+		"[optional] releases a minor-only to-version like 3.1 is resolved against. If not specified, the releases known to the upgrade and the versions of the running operators are used")
+
+	cmd.PersistentFlags().BoolVarP(&options.confirm,
+		"confirm", "",
+		options.confirm,
+		"[optional] print a summary of the upgrade and ask to type yes before changing the cluster.")
+
+	cmd.PersistentFlags().BoolVarP(&options.yes,
+		"yes", "",
+		options.yes,
+		"[optional] skip the confirmation prompt of --confirm, for non-interactive use.")
+
+	cmd.PersistentFlags().DurationVarP(&options.confirmTimeout,
+		"confirm-timeout", "",
+		options.confirmTimeout,
+		"[optional] time to wait for the confirmation of --confirm before cancelling the upgrade.")
+
+	cmd.PersistentFlags().BoolVarP(&options.consolidateTasks,
+		"consolidate-upgradetasks", "",
+		options.consolidateTasks,
+		"[optional] delete the duplicate upgradetasks of the resource left by earlier upgrades, keeping one.")
+
+	cmd.PersistentFlags().BoolVarP(&options.requireApproval,
+		"require-approval", "",
+		options.requireApproval,
+		"[optional] after the prechecks, wait for the approval annotation to be set to the to-version on the resource before patching it.")
+
+	cmd.PersistentFlags().StringVarP(&options.approvalAnnotation,
+		"approval-annotation", "",
+		options.approvalAnnotation,
+		"[optional] annotation which approves the upgrade with --require-approval. Defaults to "+upgrader.DefaultApprovalAnnotation)
+
+	cmd.PersistentFlags().DurationVarP(&options.approvalTimeout,
+		"approval-timeout", "",
+		options.approvalTimeout,
+		"[optional] time to wait for the approval of --require-approval before failing the upgrade.")
+
+	cmd.PersistentFlags().BoolVarP(&options.simulate,
+		"simulate", "",
+		options.simulate,
+		"[optional] run the upgrade against an in-memory cluster read from --sim-cluster instead of the kubernetes cluster, and print the report of the api calls.")
+
+	cmd.PersistentFlags().StringVarP(&options.simCluster,
+		"sim-cluster", "",
+		options.simCluster,
+		"[optional] yaml manifest of the objects of the simulated cluster of --simulate, like the output of kubectl get -o yaml.")
+
+	cmd.PersistentFlags().Float64VarP(&options.simFailureRate,
+		"sim-failure-rate", "",
+		options.simFailureRate,
+		"[optional] fraction of the api calls of --simulate that fail, from 0 to 1.")
+
+	cmd.PersistentFlags().DurationVarP(&options.simLatency,
+		"sim-latency", "",
+		options.simLatency,
+		"[optional] mean latency of the api calls of --simulate.")
+
 	cmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
 
 	// Hack: Without the following line, the logs will be prefixed with Error
@@ -77,8 +409,130 @@ func NewJob() *cobra.Command {
 
 // PreRun will check for environement variables to be read and intialized.
 func PreRun(cmd *cobra.Command, args []string) {
-	namespace := os.Getenv("OPENEBS_NAMESPACE")
-	if len(strings.TrimSpace(namespace)) != 0 {
-		options.openebsNamespace = namespace
+	CheckError(initFromEnv(cmd))
+	CheckError(upgrader.ValidateReportFormat(options.reportFormat))
+	CheckError(upgrader.ValidateDiffFormat(options.diffFormat))
+	CheckError(options.smtp.Validate())
+	_, err := upgrader.NewBackoffStrategy(options.backoffStrategy)
+	CheckError(err)
+	CheckError(upgrader.ValidateLVMThinPool(options.lvmThinPool, options.lvmThinPoolSettings))
+	CheckError(options.initSimulator())
+	visualizeAndExit()
+	analyzeImpactAndExit()
+	CheckError(options.RunSelfTest(cmd))
+}
+
+// resourceArgs returns the names of the resources passed as args, or
+// else the comma separated names in the RESOURCE_NAME env of the job
+func resourceArgs(args []string) []string {
+	if len(args) != 0 {
+		return args
+	}
+	names := []string{}
+	for _, name := range strings.Split(os.Getenv(upgrader.EnvResourceName), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// initFromEnv initializes the options from the environment variables
+// set on the upgrade Job. The flags passed on the command line take
+// precedence, except for the namespace which is always read from the env.
+func initFromEnv(cmd *cobra.Command) error {
+	r, err := upgrader.LoadFromEnv()
+	if err != nil {
+		return err
+	}
+	if r.OpenebsNamespace != "" {
+		options.openebsNamespace = r.OpenebsNamespace
+	}
+	set := func(flag string, isSet bool, apply func()) {
+		if isSet && !cmd.Flags().Changed(flag) {
+			apply()
+		}
 	}
+	set("from-version", r.From != "", func() { options.fromVersion = r.From })
+	set("to-version", r.To != "", func() { options.toVersion = r.To })
+	set("to-version-image-tag", r.ImageTag != "", func() { options.toVersionImageTag = r.ImageTag })
+	set("to-version-image-prefix", r.BaseURL != "", func() { options.imageURLPrefix = r.BaseURL })
+	set("image-registry-override", r.ImageRegistry != "",
+		func() { options.imageRegistry = r.ImageRegistry })
+	set("verify-only", r.VerifyOnly, func() { options.verifyOnly = true })
+	set("max-unavailable", r.MaxUnavailable != nil, func() { options.maxUnavailable = r.MaxUnavailable.String() })
+	set("max-parallel-volumes", r.MaxParallelVolumes != 0,
+		func() { options.maxParallelVolumes = r.MaxParallelVolumes })
+	// 0 disables the free space check, so the env is checked instead of the value
+	set("min-free-pool-space", strings.TrimSpace(os.Getenv(upgrader.EnvMinFreePoolSpace)) != "",
+		func() { options.minFreePoolSpace = r.MinFreePoolSpace })
+	set("allow-overprovisioned", r.AllowOverprovisioned, func() { options.allowOverprovisioned = true })
+	set("allow-rbac-removal", r.AllowRBACRemoval, func() { options.allowRBACRemoval = true })
+	set("transient-reconcile-messages", r.TransientMessages != nil, func() { options.transientMessages = r.TransientMessages })
+	set("reconcile-failure-threshold", r.ReconcileFailureThreshold != 0,
+		func() { options.reconcileThreshold = r.ReconcileFailureThreshold })
+	set("poll-interval", r.PollInterval != 0, func() { options.pollInterval = r.PollInterval })
+	set("version-stability-polls", r.VersionStabilityPolls != 0,
+		func() { options.stabilityPolls = r.VersionStabilityPolls })
+	set("reconcile-timeout", r.ReconcileTimeout != 0, func() { options.reconcileTimeout = r.ReconcileTimeout })
+	set("reconcile-stable-period", r.ReconcileStablePeriod != 0,
+		func() { options.stablePeriod = r.ReconcileStablePeriod })
+	set("patch-reapply-attempts", r.PatchReapplyAttempts != 0,
+		func() { options.patchReapplyAttempts = r.PatchReapplyAttempts })
+	set("max-parallel-verify", r.MaxParallelVerify != 0,
+		func() { options.maxParallelVerify = r.MaxParallelVerify })
+	set("smoke-test-storage-class", r.SmokeTestStorageClass != "",
+		func() { options.smokeTestSC = r.SmokeTestStorageClass })
+	set("skip-upgrade-annotation", r.SkipUpgradeAnnotation != "",
+		func() { options.skipAnnotation = r.SkipUpgradeAnnotation })
+	set("operator-version-label", r.OperatorVersionLabel != "",
+		func() { options.operatorVersionLabel = r.OperatorVersionLabel })
+	set("pre-upgrade-hook", r.PreUpgradeHook != "", func() { options.preUpgradeHook = r.PreUpgradeHook })
+	set("post-upgrade-hook", r.PostUpgradeHook != "", func() { options.postUpgradeHook = r.PostUpgradeHook })
+	set("hook-timeout", r.HookTimeout != 0, func() { options.hookTimeout = r.HookTimeout })
+	set("wait-for-rebuild", r.WaitForRebuild, func() { options.waitForRebuild = true })
+	set("rebuild-timeout", r.RebuildTimeout != 0, func() { options.rebuildTimeout = r.RebuildTimeout })
+	set("verify-images", r.VerifyImages, func() { options.verifyImages = true })
+	set("image-pull-secret", r.ImagePullSecret != "", func() { options.imagePullSecret = r.ImagePullSecret })
+	set("node-aware-scheduling", r.NodeAwareScheduling, func() { options.nodeAwareScheduling = true })
+	set("numa-aware", r.NUMAAware, func() { options.numaAware = true })
+	set("ignore-node-pressure", r.IgnoreNodePressure, func() { options.ignoreNodePressure = true })
+	set("cspi-manager-image-override", r.PoolManagerImage != "",
+		func() { options.poolManagerImage = r.PoolManagerImage })
+	set("verify-pool-count", r.VerifyPoolCount, func() { options.verifyPoolCount = true })
+	set("complete-pending-expansion", r.CompletePendingExpansion, func() { options.completeExpansion = true })
+	set("large-upgrade-threshold", r.LargeUpgradeThreshold != 0,
+		func() { options.largeCSPCThreshold = r.LargeUpgradeThreshold })
+	set("confirm-large-upgrade", r.ConfirmLargeUpgrade, func() { options.confirmLargeUpgrade = true })
+	set("target-cspi", r.TargetCSPI != "", func() { options.targetCSPI = r.TargetCSPI })
+	set("verify-block-devices", r.VerifyBlockDevices, func() { options.verifyBlockDevices = true })
+	set("verify-pod-images", r.VerifyPodImages, func() { options.verifyPodImages = true })
+	set("backoff-strategy", r.Backoff != nil,
+		func() { options.backoffStrategy = fmt.Sprint(r.Backoff) })
+	set("cvc-readiness-timeout", r.CVCReadinessTimeout != 0,
+		func() { options.cvcReadinessTimeout = r.CVCReadinessTimeout })
+	set("lvm-thin-pool", r.LVMThinPool != "", func() { options.lvmThinPool = r.LVMThinPool })
+	set("lvm-thin-pool-settings", r.LVMThinPoolSettings != nil,
+		func() { options.lvmThinPoolSettings = r.LVMThinPoolSettings })
+	set("allow-in-use-upgrades", r.AllowInUseUpgrades, func() { options.allowInUseUpgrades = true })
+	set("min-healthy-replicas", r.MinHealthyReplicas != 0,
+		func() { options.minHealthyReplicas = r.MinHealthyReplicas })
+	set("replica-wait-timeout", r.ReplicaWaitTimeout != 0,
+		func() { options.replicaWaitTimeout = r.ReplicaWaitTimeout })
+	set("fail-on-pending-resize", r.FailOnPendingResize, func() { options.failOnPendingResize = true })
+	set("skip-kubernetes-version-check", r.SkipKubernetesVersionCheck,
+		func() { options.skipKubeVersionCheck = true })
+	set("update-iscsi-portal", r.UpdateISCSIPortal, func() { options.updateISCSIPortal = true })
+	set("upgrade-clones", r.UpgradeClones, func() { options.upgradeClones = true })
+	set("snapshot-driver", r.SnapshotDriver != "", func() { options.snapshotDriver = r.SnapshotDriver })
+	set("storage-class-provisioner", r.StorageClassProvisioner != "",
+		func() { options.storageClassProvisioner = r.StorageClassProvisioner })
+	set("require-approval", r.RequireApproval, func() { options.requireApproval = true })
+	set("consolidate-upgradetasks", r.ConsolidateUpgradeTasks, func() { options.consolidateTasks = true })
+	// the smtp credentials are kept out of the job args, so they are only read here
+	set("smtp-username", os.Getenv(upgrader.EnvSMTPUsername) != "",
+		func() { options.smtp.Username = os.Getenv(upgrader.EnvSMTPUsername) })
+	set("smtp-password", os.Getenv(upgrader.EnvSMTPPassword) != "",
+		func() { options.smtp.Password = os.Getenv(upgrader.EnvSMTPPassword) })
+	return nil
 }
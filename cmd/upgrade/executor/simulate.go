@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	errors "github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+// simulationReportOnce prints the report of the simulation only once,
+// as it is printed on the exit after an error as well as after the run
+var simulationReportOnce sync.Once
+
+// initSimulator builds the simulated cluster from the --sim-cluster
+// manifest when the upgrade is run with --simulate
+func (u *UpgradeOptions) initSimulator() error {
+	if !u.simulate {
+		return nil
+	}
+	if u.simCluster == "" {
+		return errors.Errorf("Cannot simulate the upgrade: the manifest of the simulated cluster is missing, set --sim-cluster")
+	}
+	f, err := os.Open(u.simCluster)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read the simulated cluster")
+	}
+	defer f.Close()
+	objs, err := upgrader.LoadSimulatedCluster(f)
+	if err != nil {
+		return err
+	}
+	u.simulator, err = upgrader.NewSimulator(objs,
+		upgrader.WithSimFailureRate(u.simFailureRate),
+		upgrader.WithSimLatency(u.simLatency),
+	)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Simulating the upgrade against %d objects of %s with a failure rate of %v",
+		len(objs), u.simCluster, u.simFailureRate)
+	return nil
+}
+
+// printSimulationReport prints the calls made to the simulated cluster
+func (u *UpgradeOptions) printSimulationReport() {
+	if u.simulator == nil {
+		return
+	}
+	simulationReportOnce.Do(func() {
+		fmt.Println("Simulated API calls:")
+		err := upgrader.PrintOutput(os.Stdout, upgrader.OutputTable, u.simulator.Report())
+		if err != nil {
+			klog.Errorf("Failed to print the simulation report: %v", err)
+		}
+	})
+}
+
+// PostRun prints the report of the simulation and
+// writes the report of the upgrade, if any
+func PostRun(cmd *cobra.Command, args []string) {
+	options.printSimulationReport()
+	options.writeUpgradeReport()
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	snapshotClassUpgradeCmdHelpText = `
+This command upgrades the driver and parameters of the given
+VolumeSnapshotClasses. The VolumeSnapshotContents of the classes
+with another driver are recreated with the new driver, and their
+VolumeSnapshots are recreated bound to the new contents.
+
+Usage: upgrade snapshot-class --options... <snapshotclass-name>...
+`
+)
+
+// NewUpgradeSnapshotClassJob upgrades the volumesnapshotclasses
+// and their volumesnapshotcontents
+func NewUpgradeSnapshotClassJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "snapshot-class",
+		Short:   "Upgrade VolumeSnapshotClasses and their VolumeSnapshotContents",
+		Long:    snapshotClassUpgradeCmdHelpText,
+		Example: `upgrade snapshot-class --from-version=2.12.0 --to-version=3.0.0 csi-cstor-snapshotclass`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				util.Fatal("failed to upgrade: no snapshotclass name provided")
+			}
+			options.resourceKind = "snapshotClass"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			CheckError(options.confirmUpgrade(args))
+			for _, name := range args {
+				CheckError(options.RunSnapshotClassUpgrade(cmd, name))
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.snapshotDriver,
+		"snapshot-driver", "",
+		options.snapshotDriver,
+		"[optional] driver of the snapshotclasses and their volumesnapshotcontents. If not specified, cstor.csi.openebs.io is used")
+
+	cmd.Flags().StringToStringVarP(&options.snapshotClassParameters,
+		"snapshot-class-parameters", "",
+		options.snapshotClassParameters,
+		"[optional] parameters set on the snapshotclasses, e.g. key=value, an empty value removes the parameter.")
+
+	return cmd
+}
+
+// RunSnapshotClassUpgrade upgrades the given volumesnapshotclass.
+func (u *UpgradeOptions) RunSnapshotClassUpgrade(cmd *cobra.Command, name string) error {
+	if u.generateTasks {
+		return errors.Errorf("Upgradetasks are not used for the snapshotclass upgrade")
+	}
+	if u.waitForVersion {
+		return errors.Errorf("Waiting for the version is not supported for the snapshotclass upgrade")
+	}
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the snapshotclass upgrade")
+	}
+	if u.isValidVersion() {
+		klog.Infof("Upgrading snapshotclass %s to %s", name, u.toVersion)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to upgrade snapshotclass %v", name)
+		}
+		klog.Infof("Successfully upgraded snapshotclass %s to %s", name, u.toVersion)
+	} else {
+		return errors.Errorf("Invalid from version %s or to version %s", u.fromVersion, u.toVersion)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+)
+
+var (
+	statusCmdHelpText = `
+This command lists the UpgradeTasks in the openebs namespace
+with the phase of the upgrade of their resources.
+
+Usage: upgrade status [--output-format=table|json|yaml]
+`
+)
+
+// NewStatusJob lists the status of the UpgradeTasks
+func NewStatusJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "status",
+		Short:   "List the status of the UpgradeTasks",
+		Long:    statusCmdHelpText,
+		Example: `upgrade status --output-format=json`,
+		// the status is read only, so the self test
+		// of the upgrade is not run
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			CheckError(initFromEnv(cmd))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			CheckError(options.RunStatus())
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.outputFormat,
+		"output-format", "o",
+		options.outputFormat,
+		"[optional] output format, one of table, json or yaml.")
+
+	return cmd
+}
+
+// RunStatus prints the status of the upgradetasks in the output format
+func (u *UpgradeOptions) RunStatus() error {
+	err := upgrader.ValidateOutputFormat(u.outputFormat)
+	if err != nil {
+		return err
+	}
+	list, err := upgrade.ListUpgradeTasks(u.openebsNamespace, u.clientOptions()...)
+	if err != nil {
+		return err
+	}
+	return upgrader.PrintOutput(os.Stdout, u.outputFormat, list)
+}
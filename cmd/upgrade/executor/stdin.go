@@ -0,0 +1,243 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	errors "github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog"
+)
+
+// upgradeOrder is the order in which the kinds of resources are
+// upgraded, the pools need to be upgraded before the volumes
+var upgradeOrder = map[string]int{
+	"cstorPoolCluster":  0,
+	"cstorPoolInstance": 1,
+	"cstorVolume":       2,
+	"jivaVolume":        2,
+}
+
+// stdinTask is an upgradetask read from stdin along with
+// the options to upgrade its resource
+type stdinTask struct {
+	utask   v1Alpha1API.UpgradeTask
+	options UpgradeOptions
+}
+
+// readUpgradeTasks decodes the yaml or json upgradetasks
+// separated by --- from the given reader
+func readUpgradeTasks(r io.Reader) ([]v1Alpha1API.UpgradeTask, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	utasks := []v1Alpha1API.UpgradeTask{}
+	for {
+		utask := v1Alpha1API.UpgradeTask{}
+		err := decoder.Decode(&utask)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode upgradetask")
+		}
+		// skip empty documents
+		if utask.Kind == "" && utask.Name == "" {
+			continue
+		}
+		utasks = append(utasks, utask)
+	}
+	return utasks, nil
+}
+
+// validateUpgradeTasks validates all the upgradetasks before any of them
+// is created and returns them sorted in the order of upgrade
+func (u *UpgradeOptions) validateUpgradeTasks(cmd *cobra.Command,
+	utasks []v1Alpha1API.UpgradeTask) ([]stdinTask, error) {
+	if len(utasks) == 0 {
+		return nil, errors.Errorf("no upgradetask found in stdin")
+	}
+	if u.saveResult {
+		// all the tasks share the result of the run
+		u.upgradeResult()
+	}
+	tasks := []stdinTask{}
+	for _, utask := range utasks {
+		if utask.Kind != "UpgradeTask" {
+			return nil, errors.Errorf("invalid kind %q for %s, expected UpgradeTask", utask.Kind, utask.Name)
+		}
+		if utask.Name == "" {
+			return nil, errors.Errorf("missing name for upgradetask")
+		}
+		// each task starts from the options passed as flags
+		o := *u
+		o.resourceKind = ""
+		o.name = ""
+		err := o.InitializeFromUpgradeTaskResource(utask)
+		if err == nil {
+			err = o.RunPreFlightChecks(cmd)
+		}
+		if err == nil {
+			err = o.RunResourceUpgradeChecks(cmd)
+		}
+		if err == nil && !o.isValidVersion() {
+			err = errors.Errorf("invalid from version %s or to version %s", o.fromVersion, o.toVersion)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid upgradetask %s", utask.Name)
+		}
+		if _, ok := upgradeOrder[o.resourceKind]; !ok {
+			return nil, errors.Errorf("unsupported resource %s in upgradetask %s", o.resourceKind, utask.Name)
+		}
+		utask.Namespace = o.openebsNamespace
+		// a minor-only to-version is created as the resolved version
+		utask.Spec.ToVersion = o.toVersion
+		tasks = append(tasks, stdinTask{utask: utask, options: o})
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return upgradeOrder[tasks[i].options.resourceKind] < upgradeOrder[tasks[j].options.resourceKind]
+	})
+	return tasks, nil
+}
+
+// upgradeTaskSummaries returns the summaries of the upgradetasks, with
+// the tasks of the same kind and versions in one summary
+func upgradeTaskSummaries(tasks []stdinTask) []*upgrader.UpgradeSummary {
+	summaries := []*upgrader.UpgradeSummary{}
+	for _, t := range tasks {
+		var summary *upgrader.UpgradeSummary
+		for _, s := range summaries {
+			if s.Kind == t.options.resourceKind && s.From == t.options.fromVersion && s.To == t.options.toVersion {
+				summary = s
+			}
+		}
+		if summary == nil {
+			summary = &upgrader.UpgradeSummary{
+				Kind: t.options.resourceKind,
+				From: t.options.fromVersion,
+				To:   t.options.toVersion,
+			}
+			summaries = append(summaries, summary)
+		}
+		summary.Names = append(summary.Names, t.options.name)
+	}
+	return summaries
+}
+
+// confirmUpgradeTasks writes the summaries of all the upgradetasks
+// and asks once for the confirmation of the whole batch
+func (u *UpgradeOptions) confirmUpgradeTasks(tasks []stdinTask) error {
+	if !u.confirm || u.isDryRun() || u.verifyOnly {
+		return nil
+	}
+	for _, summary := range upgradeTaskSummaries(tasks) {
+		err := summary.Write(os.Stderr)
+		if err != nil {
+			return err
+		}
+	}
+	return u.promptConfirmation()
+}
+
+// RunUpgradeTasksFromStdin creates the upgradetasks read from r, upgrades
+// their resources in order and deletes the upgradetasks once all the
+// upgrades are successful. If an upgrade fails the remaining upgrades are
+// not run and the upgradetasks are left behind for inspection.
+func (u *UpgradeOptions) RunUpgradeTasksFromStdin(cmd *cobra.Command, r io.Reader) error {
+	utasks, err := readUpgradeTasks(r)
+	if err != nil {
+		return &upgrader.Error{Kind: upgrader.ErrValidation, Cause: err}
+	}
+	tasks, err := u.validateUpgradeTasks(cmd, utasks)
+	if err != nil {
+		return &upgrader.Error{Kind: upgrader.ErrValidation, Cause: err}
+	}
+	client, err := initClient(u)
+	if err != nil {
+		return err
+	}
+	// fail before creating any task if one already exists
+	for _, t := range tasks {
+		_, err := client.OpenebsV1alpha1().UpgradeTasks(t.utask.Namespace).
+			Get(context.TODO(), t.utask.Name, metav1.GetOptions{})
+		if err == nil {
+			return &upgrader.Error{
+				Kind:  upgrader.ErrValidation,
+				Cause: errors.Errorf("upgradetask %s already exists", t.utask.Name),
+			}
+		}
+		if !k8serrors.IsNotFound(err) {
+			return &upgrader.Error{Kind: upgrader.ErrAPI, Cause: err}
+		}
+	}
+	err = u.confirmUpgradeTasks(tasks)
+	if err != nil {
+		return err
+	}
+	for i := range tasks {
+		t := &tasks[i]
+		klog.Infof("Creating upgradetask %s", t.utask.Name)
+		created, err := client.OpenebsV1alpha1().UpgradeTasks(t.utask.Namespace).
+			Create(context.TODO(), &t.utask, metav1.CreateOptions{})
+		if err != nil {
+			return &upgrader.Error{Kind: upgrader.ErrAPI, Cause: err}
+		}
+		t.utask = *created
+	}
+	for i := range tasks {
+		t := &tasks[i]
+		upgradeErr := t.options.RunResourceUpgrade(cmd)
+		utaskObj, err := client.OpenebsV1alpha1().UpgradeTasks(t.utask.Namespace).
+			Get(context.TODO(), t.utask.Name, metav1.GetOptions{})
+		if err != nil {
+			return &upgrader.Error{Kind: upgrader.ErrAPI, Cause: err}
+		}
+		utaskObj.Status.Phase = v1Alpha1API.UpgradeSuccess
+		if upgradeErr != nil {
+			utaskObj.Status.Phase = v1Alpha1API.UpgradeError
+		}
+		utaskObj.Status.CompletedTime = metav1.Now()
+		_, err = client.OpenebsV1alpha1().UpgradeTasks(t.utask.Namespace).
+			Update(context.TODO(), utaskObj, metav1.UpdateOptions{})
+		if err != nil {
+			return &upgrader.Error{Kind: upgrader.ErrAPI, Cause: err}
+		}
+		if upgradeErr != nil {
+			klog.Errorf("Upgrade failed for upgradetask %s, not deleting the upgradetasks", t.utask.Name)
+			if i > 0 {
+				return &upgrader.Error{Kind: upgrader.ErrPartialFailure, Cause: upgradeErr}
+			}
+			return upgradeErr
+		}
+	}
+	for _, t := range tasks {
+		klog.Infof("Deleting upgradetask %s", t.utask.Name)
+		err := client.OpenebsV1alpha1().UpgradeTasks(t.utask.Namespace).
+			Delete(context.TODO(), t.utask.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return &upgrader.Error{Kind: upgrader.ErrAPI, Cause: err}
+		}
+	}
+	return nil
+}
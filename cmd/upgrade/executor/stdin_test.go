@@ -0,0 +1,181 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const stdinYAML = `apiVersion: openebs.io/v1alpha1
+kind: UpgradeTask
+metadata:
+  name: upgrade-cstor-volume-pvc-1
+spec:
+  fromVersion: 2.12.0
+  toVersion: ci-3.0.0
+  cstorVolume:
+    pvName: pvc-1
+---
+---
+apiVersion: openebs.io/v1alpha1
+kind: UpgradeTask
+metadata:
+  name: upgrade-cstor-cspc-cspc-a
+spec:
+  fromVersion: 2.12.0
+  toVersion: ci-3.0.0
+  cstorPoolCluster:
+    cspcName: cspc-a
+`
+
+const stdinJSON = `{"apiVersion": "openebs.io/v1alpha1", "kind": "UpgradeTask",
+  "metadata": {"name": "upgrade-cstor-volume-pvc-1"},
+  "spec": {"fromVersion": "2.12.0", "toVersion": "ci-3.0.0", "cstorVolume": {"pvName": "pvc-1"}}}
+{"apiVersion": "openebs.io/v1alpha1", "kind": "UpgradeTask",
+  "metadata": {"name": "upgrade-cstor-cspc-cspc-a"},
+  "spec": {"fromVersion": "2.12.0", "toVersion": "ci-3.0.0", "cstorPoolCluster": {"cspcName": "cspc-a"}}}
+`
+
+func TestReadUpgradeTasks(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "yaml documents", input: stdinYAML, want: []string{"upgrade-cstor-volume-pvc-1", "upgrade-cstor-cspc-cspc-a"}},
+		{name: "json objects", input: stdinJSON, want: []string{"upgrade-cstor-volume-pvc-1", "upgrade-cstor-cspc-cspc-a"}},
+		{name: "empty", input: "", want: []string{}},
+		{name: "invalid", input: "kind: [UpgradeTask", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utasks, err := readUpgradeTasks(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readUpgradeTasks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := []string{}
+			for _, utask := range utasks {
+				got = append(got, utask.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readUpgradeTasks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testUpgradeTask(name string, spec v1Alpha1API.ResourceSpec) v1Alpha1API.UpgradeTask {
+	return v1Alpha1API.UpgradeTask{
+		TypeMeta:   metav1.TypeMeta{Kind: "UpgradeTask", APIVersion: "openebs.io/v1alpha1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1Alpha1API.UpgradeTaskSpec{
+			FromVersion:  "2.12.0",
+			ToVersion:    "ci-3.0.0",
+			ResourceSpec: spec,
+		},
+	}
+}
+
+func TestValidateUpgradeTasks(t *testing.T) {
+	volume := testUpgradeTask("upgrade-cstor-volume-pvc-1",
+		v1Alpha1API.ResourceSpec{CStorVolume: &v1Alpha1API.CStorVolume{PVName: "pvc-1"}})
+	jiva := testUpgradeTask("upgrade-jiva-volume-pvc-2",
+		v1Alpha1API.ResourceSpec{JivaVolume: &v1Alpha1API.JivaVolume{PVName: "pvc-2"}})
+	cspi := testUpgradeTask("upgrade-cstor-cspi-cspi-1",
+		v1Alpha1API.ResourceSpec{CStorPoolInstance: &v1Alpha1API.CStorPoolInstance{CSPIName: "cspi-1"}})
+	cspc := testUpgradeTask("upgrade-cstor-cspc-cspc-a",
+		v1Alpha1API.ResourceSpec{CStorPoolCluster: &v1Alpha1API.CStorPoolCluster{CSPCName: "cspc-a"}})
+	wrongKind := volume
+	wrongKind.Kind = "MigrationTask"
+	noResource := testUpgradeTask("upgrade-none", v1Alpha1API.ResourceSpec{})
+	badVersion := cspc
+	badVersion.Spec.ToVersion = "2.12.0"
+	tests := []struct {
+		name    string
+		utasks  []v1Alpha1API.UpgradeTask
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "pools before volumes",
+			utasks: []v1Alpha1API.UpgradeTask{volume, jiva, cspi, cspc},
+			want:   []string{"cspc-a", "cspi-1", "pvc-1", "pvc-2"},
+		},
+		{name: "no tasks", wantErr: "no upgradetask"},
+		{name: "invalid kind", utasks: []v1Alpha1API.UpgradeTask{cspc, wrongKind}, wantErr: "invalid kind"},
+		{name: "no resource", utasks: []v1Alpha1API.UpgradeTask{noResource}, wantErr: "invalid upgradetask upgrade-none"},
+		{name: "invalid version", utasks: []v1Alpha1API.UpgradeTask{badVersion}, wantErr: "invalid from version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &UpgradeOptions{openebsNamespace: "openebs", allowCustomVersions: true}
+			tasks, err := u.validateUpgradeTasks(nil, tt.utasks)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("validateUpgradeTasks() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateUpgradeTasks() error = %v", err)
+			}
+			got := []string{}
+			for _, task := range tasks {
+				got = append(got, task.options.name)
+				if task.utask.Namespace != "openebs" {
+					t.Errorf("validateUpgradeTasks() namespace of %s = %q", task.utask.Name, task.utask.Namespace)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateUpgradeTasks() order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeTaskSummaries(t *testing.T) {
+	task := func(kind, name, to string) stdinTask {
+		return stdinTask{options: UpgradeOptions{resourceKind: kind, name: name, fromVersion: "2.12.0", toVersion: to}}
+	}
+	tasks := []stdinTask{
+		task("cstorPoolCluster", "cspc-a", "3.0.0"),
+		task("cstorVolume", "pvc-1", "3.0.0"),
+		task("cstorVolume", "pvc-2", "3.0.0"),
+		task("cstorVolume", "pvc-3", "3.1.0"),
+	}
+	got := []string{}
+	for _, s := range upgradeTaskSummaries(tasks) {
+		got = append(got, s.Kind+" "+s.To+" "+strings.Join(s.Names, ","))
+	}
+	want := []string{
+		"cstorPoolCluster 3.0.0 cspc-a",
+		"cstorVolume 3.0.0 pvc-1,pvc-2",
+		"cstorVolume 3.1.0 pvc-3",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("upgradeTaskSummaries() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	storageClassUpgradeCmdHelpText = `
+This command migrates the given cStor StorageClasses to a new
+provisioner. As the provisioner of a StorageClass is immutable, the
+StorageClass is backed up in a ConfigMap, created again under a new
+name with the new provisioner, the PersistentVolumes are moved to the
+new StorageClass and the old StorageClass is deleted.
+
+With --dry-run the PersistentVolumes which would be moved are listed
+and nothing is changed.
+
+Usage: upgrade storage-class --storage-class-provisioner=<provisioner> --options... <storageclass-name>...
+`
+)
+
+// NewUpgradeStorageClassJob migrates the storageclasses
+// and their persistentvolumes to a new provisioner
+func NewUpgradeStorageClassJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "storage-class",
+		Short:   "Migrate cStor StorageClasses and their PersistentVolumes to a new provisioner",
+		Long:    storageClassUpgradeCmdHelpText,
+		Example: `upgrade storage-class --from-version=2.12.0 --to-version=3.0.0 --storage-class-provisioner=<provisioner> cstor-csi`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				util.Fatal("failed to upgrade: no storageclass name provided")
+			}
+			if len(args) > 1 && options.newStorageClassName != "" {
+				util.Fatal("failed to upgrade: --new-storage-class-name can only be used with a single storageclass")
+			}
+			options.resourceKind = "storageClass"
+			util.CheckErr(options.RunPreFlightChecks(cmd), util.Fatal)
+			util.CheckErr(options.InitializeDefaults(cmd), util.Fatal)
+			if !options.dryRun {
+				CheckError(options.confirmUpgrade(args))
+			}
+			for _, name := range args {
+				CheckError(options.RunStorageClassUpgrade(cmd, name))
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.storageClassProvisioner,
+		"storage-class-provisioner", "",
+		options.storageClassProvisioner,
+		"provisioner the storageclasses are migrated to.")
+
+	cmd.Flags().StringVarP(&options.newStorageClassName,
+		"new-storage-class-name", "",
+		options.newStorageClassName,
+		"[optional] name of the migrated storageclass. If not specified, the name suffixed with the to-version is used")
+
+	cmd.Flags().BoolVarP(&options.dryRun,
+		"dry-run", "",
+		options.dryRun,
+		"[optional] list the persistentvolumes which would be moved to the new storageclass without changing anything.")
+
+	return cmd
+}
+
+// RunStorageClassUpgrade migrates the given storageclass.
+func (u *UpgradeOptions) RunStorageClassUpgrade(cmd *cobra.Command, name string) error {
+	if u.generateTasks {
+		return errors.Errorf("Upgradetasks are not used for the storageclass migration")
+	}
+	if u.waitForVersion {
+		return errors.Errorf("Waiting for the version is not supported for the storageclass migration")
+	}
+	if u.precheckReport != "" {
+		return errors.Errorf("Precheck reports are not supported for the storageclass migration, use --dry-run")
+	}
+	if u.isValidVersion() {
+		klog.Infof("Migrating storageclass %s to %s", name, u.storageClassProvisioner)
+		err := upgrade.Exec(u.resourceKind,
+			u.resourcePatchOptions(name),
+			u.clientOptions()...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to migrate storageclass %v", name)
+		}
+		klog.Infof("Successfully migrated storageclass %s", name)
+	} else {
+		return errors.Errorf("Invalid from version %s or to version %s", u.fromVersion, u.toVersion)
+	}
+	return nil
+}
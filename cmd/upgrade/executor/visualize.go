@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+)
+
+// RunVisualize prints the dependency graph of the upgrade relevant
+// resources in the visualize format, dot or mermaid
+func (u *UpgradeOptions) RunVisualize() error {
+	// validate the format before listing all the resources
+	err := upgrader.ValidateGraphFormat(u.visualize)
+	if err != nil {
+		return err
+	}
+	graph, err := upgrade.BuildDependencyGraph(context.TODO(), u.clientOptions()...)
+	if err != nil {
+		return err
+	}
+	out, err := graph.Format(u.visualize)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stdout, out)
+	return nil
+}
+
+// visualizeAndExit prints the dependency graph and exits
+// without running the command if --visualize is set
+func visualizeAndExit() {
+	if options.visualize == "" {
+		return
+	}
+	CheckError(options.RunVisualize())
+	os.Exit(0)
+}
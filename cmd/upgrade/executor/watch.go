@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"os"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/maya/pkg/util"
+	"github.com/spf13/cobra"
+
+	upgrade "github.com/openebs/upgrade/pkg/upgrade"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	errors "github.com/pkg/errors"
+)
+
+var (
+	watchCmdHelpText = `
+This command prints the status of the given UpgradeTask in the openebs
+namespace each time its phase changes, until the upgrade succeeds or
+fails. It exits with a non-zero code if the upgrade failed.
+
+Usage: upgrade watch <upgradetask-name>
+`
+)
+
+// NewWatchJob watches the status of an UpgradeTask
+func NewWatchJob() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "watch",
+		Short:   "Watch the status of an UpgradeTask",
+		Long:    watchCmdHelpText,
+		Example: `upgrade watch upgrade-cstor-cspc-cspc-stripe`,
+		// the status is read only, so the self test
+		// of the upgrade is not run
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			CheckError(initFromEnv(cmd))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				util.Fatal("failed to watch: exactly one upgradetask name must be provided")
+			}
+			CheckError(options.RunWatch(args[0]))
+		},
+	}
+
+	return cmd
+}
+
+// RunWatch prints the status of the upgradetask until it is done
+func (u *UpgradeOptions) RunWatch(name string) error {
+	statuses, err := upgrade.WatchUpgradeTask(context.Background(), name,
+		u.openebsNamespace, u.clientOptions()...)
+	if err != nil {
+		return err
+	}
+	last, err := upgrader.PrintWatchOutput(os.Stdout, name, statuses)
+	if err != nil {
+		return err
+	}
+	if last.Phase == v1Alpha1API.UpgradeError {
+		return errors.Errorf("Upgradetask %s failed after %d retries", name, last.Retries)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	openebsio "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/api/v3/pkg/apis/types"
+	openebsFakeClientset "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestBackupMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "openebs",
+		Labels:    map[string]string{cspUIDLabel: "csp-uid"},
+	}
+}
+
+func TestCSPCMigrator_upgradeBackupRestore(t *testing.T) {
+	c := &CSPCMigrator{
+		OpenebsClientset: openebsFakeClientset.NewSimpleClientset([]runtime.Object{
+			&openebsio.CStorBackup{
+				ObjectMeta: newTestBackupMeta("backup-a"),
+				Spec:       openebsio.CStorBackupSpec{BackupName: "backup", SnapName: "snap-1"},
+			},
+			&openebsio.CStorRestore{
+				ObjectMeta: newTestBackupMeta("restore-a"),
+				Spec:       openebsio.CStorRestoreSpec{RestoreName: "restore", VolumeName: testPVName},
+			},
+			&openebsio.CStorCompletedBackup{
+				ObjectMeta: newTestBackupMeta("completed-a"),
+				Spec:       openebsio.CStorBackupSpec{SnapName: "snap-2", PrevSnapName: "snap-1"},
+			},
+		}...),
+		OpenebsNamespace: "openebs",
+	}
+	cspi := &cstor.CStorPoolInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "cspc-a-1", UID: "cspi-uid"},
+	}
+	if err := c.upgradeBackupRestore("csp-uid", cspi); err != nil {
+		t.Fatalf("upgradeBackupRestore() error = %v", err)
+	}
+	checkLabels := func(kind string, labels map[string]string) {
+		t.Helper()
+		if labels[types.CStorPoolInstanceNameLabelKey] != "cspc-a-1" ||
+			labels[types.CStorPoolInstanceUIDLabelKey] != "cspi-uid" || labels[cspUIDLabel] != "" {
+			t.Errorf("upgradeBackupRestore() %s labels = %v, want the cspi labels", kind, labels)
+		}
+	}
+	v1 := c.OpenebsClientset.CstorV1()
+	backup, err := v1.CStorBackups("openebs").Get(context.TODO(), "backup-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the v1 cstorbackup: %v", err)
+	}
+	checkLabels("cstorbackup", backup.Labels)
+	if backup.Spec.SnapName != "snap-1" {
+		t.Errorf("upgradeBackupRestore() cstorbackup snapshot = %s, want snap-1", backup.Spec.SnapName)
+	}
+	restore, err := v1.CStorRestores("openebs").Get(context.TODO(), "restore-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the v1 cstorrestore: %v", err)
+	}
+	checkLabels("cstorrestore", restore.Labels)
+	completed, err := v1.CStorCompletedBackups("openebs").Get(context.TODO(), "completed-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the v1 cstorcompletedbackup: %v", err)
+	}
+	checkLabels("cstorcompletedbackup", completed.Labels)
+	if completed.Spec.LastSnapName != "snap-1" || completed.Spec.SecondLastSnapName != "snap-2" {
+		t.Errorf("upgradeBackupRestore() cstorcompletedbackup snapshots = %s, %s, want snap-1, snap-2",
+			completed.Spec.LastSnapName, completed.Spec.SecondLastSnapName)
+	}
+}
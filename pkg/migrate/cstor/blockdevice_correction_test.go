@@ -0,0 +1,226 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	openebsFakeClientset "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	apis "github.com/openebs/maya/pkg/apis/openebs.io/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func newTestNode(hostname string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   hostname,
+			Labels: map[string]string{"kubernetes.io/hostname": hostname},
+		},
+	}
+}
+
+func newTestBD(name, hostname string, state v1alpha1.BlockDeviceState, claim string) *v1alpha1.BlockDevice {
+	bd := &v1alpha1.BlockDevice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openebs",
+			UID:       k8stypes.UID("uid-" + name),
+			Labels:    map[string]string{"kubernetes.io/hostname": hostname},
+			Annotations: map[string]string{
+				"internal.openebs.io/uuid-scheme": "legacy",
+			},
+		},
+		Spec: v1alpha1.DeviceSpec{
+			Capacity: v1alpha1.DeviceCapacity{Storage: 5 << 30},
+		},
+		Status: v1alpha1.DeviceStatus{State: state},
+	}
+	if claim != "" {
+		bd.Spec.ClaimRef = &corev1.ObjectReference{Name: claim}
+	}
+	return bd
+}
+
+func newTestBDC(name, spcName string) *v1alpha1.BlockDeviceClaim {
+	bdc := &v1alpha1.BlockDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openebs"},
+	}
+	if spcName != "" {
+		bdc.Labels = map[string]string{string(apis.StoragePoolClaimCPK): spcName}
+	}
+	return bdc
+}
+
+func TestCSPCMigrator_findBDforDevlink(t *testing.T) {
+	byPath := newTestBD("bd-path", "node-1", v1alpha1.BlockDeviceActive, "")
+	byPath.Spec.Path = "/dev/sdb"
+	byLink := newTestBD("bd-link", "node-1", v1alpha1.BlockDeviceActive, "")
+	byLink.Spec.DevLinks = []v1alpha1.DeviceDevLink{
+		{Kind: "by-id", Links: []string{"/dev/disk/by-id/ata-disk-c"}},
+	}
+	inactive := newTestBD("bd-inactive", "node-1", v1alpha1.BlockDeviceInactive, "")
+	inactive.Spec.Path = "/dev/sdd"
+	c := newTestCSPCMigrator(
+		[]runtime.Object{newTestNode("node-1")},
+		[]runtime.Object{byPath, byLink, inactive},
+	)
+
+	tests := map[string]struct {
+		devlink string
+		want    string
+		wantErr bool
+	}{
+		"bd of the path": {
+			devlink: "/dev/sdb1",
+			want:    "bd-path",
+		},
+		"bd of the devlink": {
+			devlink: "/dev/disk/by-id/ata-disk-c-part1",
+			want:    "bd-link",
+		},
+		"inactive bd": {
+			devlink: "/dev/sdd1",
+			wantErr: true,
+		},
+		"unknown devlink": {
+			devlink: "/dev/sde1",
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := c.findBDforDevlink(tt.devlink, "node-1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findBDforDevlink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("findBDforDevlink() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSPCMigrator_updateBDRefsAndlabels(t *testing.T) {
+	spcObj := &apis.StoragePoolClaim{ObjectMeta: metav1.ObjectMeta{Name: "spc-a", UID: "spc-uid"}}
+	bdcs := func(c *CSPCMigrator, name string) *v1alpha1.BlockDeviceClaim {
+		t.Helper()
+		bdc, err := c.OpenebsClientset.OpenebsV1alpha1().BlockDeviceClaims("openebs").
+			Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get bdc %s: %v", name, err)
+		}
+		return bdc
+	}
+
+	t.Run("claimed new bd", func(t *testing.T) {
+		c := newTestCSPCMigrator(nil, []runtime.Object{
+			newTestBD("bd-old", "node-1", v1alpha1.BlockDeviceActive, "bdc-old"),
+			newTestBD("bd-new", "node-1", v1alpha1.BlockDeviceActive, "bdc-new"),
+			newTestBDC("bdc-old", "spc-a"),
+			newTestBDC("bdc-new", ""),
+		})
+		if err := c.updateBDRefsAndlabels(spcObj, "bd-old", "bd-new"); err != nil {
+			t.Fatalf("updateBDRefsAndlabels() error = %v", err)
+		}
+		newBDC := bdcs(c, "bdc-new")
+		if newBDC.Labels[string(apis.StoragePoolClaimCPK)] != "spc-a" ||
+			len(newBDC.OwnerReferences) != 1 || newBDC.OwnerReferences[0].UID != "spc-uid" {
+			t.Errorf("updateBDRefsAndlabels() new bdc = %+v, want the spc label and owner", newBDC.ObjectMeta)
+		}
+		oldBDC := bdcs(c, "bdc-old")
+		if oldBDC.Labels[string(apis.StoragePoolClaimCPK)] != "" || len(oldBDC.OwnerReferences) != 0 {
+			t.Errorf("updateBDRefsAndlabels() old bdc = %+v, want no spc label and owner", oldBDC.ObjectMeta)
+		}
+	})
+
+	t.Run("unclaimed new bd", func(t *testing.T) {
+		client := openebsFakeClientset.NewSimpleClientset(
+			newTestBD("bd-old", "node-1", v1alpha1.BlockDeviceInactive, ""),
+			newTestBD("bd-new", "node-1", v1alpha1.BlockDeviceActive, ""),
+		)
+		// the new bdc is bound by ndm as soon as it is created
+		client.PrependReactor("create", "blockdeviceclaims",
+			func(action ktesting.Action) (bool, runtime.Object, error) {
+				bdc := action.(ktesting.CreateAction).GetObject().(*v1alpha1.BlockDeviceClaim)
+				bdc.Status.Phase = v1alpha1.BlockDeviceClaimStatusDone
+				return false, nil, nil
+			})
+		c := newTestCSPCMigrator(nil, nil)
+		c.OpenebsClientset = client
+		if err := c.updateBDRefsAndlabels(spcObj, "bd-old", "bd-new"); err != nil {
+			t.Fatalf("updateBDRefsAndlabels() error = %v", err)
+		}
+		newBDC := bdcs(c, "bdc-cstor-uid-bd-new")
+		if newBDC.Spec.BlockDeviceName != "bd-new" || newBDC.Labels[string(apis.StoragePoolClaimCPK)] != "spc-a" {
+			t.Errorf("updateBDRefsAndlabels() new bdc = %+v, want a claim of bd-new by spc-a", newBDC)
+		}
+		newBD, err := client.OpenebsV1alpha1().BlockDevices("openebs").Get(context.TODO(), "bd-new", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := newBD.Annotations["internal.openebs.io/uuid-scheme"]; ok {
+			t.Errorf("updateBDRefsAndlabels() kept the legacy annotation on the new bd")
+		}
+	})
+
+	t.Run("missing bd", func(t *testing.T) {
+		c := newTestCSPCMigrator(nil, []runtime.Object{
+			newTestBD("bd-old", "node-1", v1alpha1.BlockDeviceActive, "bdc-old"),
+		})
+		if err := c.updateBDRefsAndlabels(spcObj, "bd-old", "bd-new"); err == nil {
+			t.Errorf("updateBDRefsAndlabels() of a missing bd error = nil, want error")
+		}
+	})
+}
+
+func Test_byteCount(t *testing.T) {
+	tests := map[string]struct {
+		bytes uint64
+		want  string
+	}{
+		"bytes":     {bytes: 512, want: "512B"},
+		"kibibytes": {bytes: 2 << 10, want: "2K"},
+		"gibibytes": {bytes: 5 << 30, want: "5G"},
+		"tebibytes": {bytes: 3 << 40, want: "3T"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := byteCount(tt.bytes); got != tt.want {
+				t.Errorf("byteCount() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getCapacity(t *testing.T) {
+	got, err := getCapacity("5G")
+	if err != nil {
+		t.Fatalf("getCapacity() error = %v", err)
+	}
+	if got.Value() != 5000000000 {
+		t.Errorf("getCapacity() = %d, want 5G", got.Value())
+	}
+	if _, err := getCapacity("five"); err == nil {
+		t.Errorf("getCapacity() of an invalid capacity error = nil, want error")
+	}
+}
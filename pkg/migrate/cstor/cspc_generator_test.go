@@ -21,7 +21,13 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/api/v3/pkg/apis/types"
 	apis "github.com/openebs/maya/pkg/apis/openebs.io/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func Test_getDataRaidGroups(t *testing.T) {
@@ -190,3 +196,64 @@ func Test_getDataRaidGroups(t *testing.T) {
 		})
 	}
 }
+
+func Test_getCSPResources(t *testing.T) {
+	resources := func(cpu string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+		}
+	}
+	deploy := appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "cstor-pool", Resources: resources("2")},
+						{Name: "cstor-pool-mgmt", Resources: resources("500m")},
+					},
+				},
+			},
+		},
+	}
+	if got := getCSPResources(deploy); got == nil || !cmp.Equal(*got, resources("2")) {
+		t.Errorf("getCSPResources() = %v, want the resources of cstor-pool", got)
+	}
+	if got := getCSPAuxResources(deploy); got == nil || !cmp.Equal(*got, resources("500m")) {
+		t.Errorf("getCSPAuxResources() = %v, want the resources of cstor-pool-mgmt", got)
+	}
+	if getCSPResources(appsv1.Deployment{}) != nil || getCSPAuxResources(appsv1.Deployment{}) != nil {
+		t.Errorf("getCSPResources() of a deployment without the pool containers, want nil")
+	}
+}
+
+func TestCSPCMigrator_generateCSPC(t *testing.T) {
+	cspc := &cstor.CStorPoolCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cspc-a",
+			Namespace: "openebs",
+			Annotations: map[string]string{
+				types.OpenEBSDisableDependantsReconcileKey: "true",
+			},
+		},
+		Spec: cstor.CStorPoolClusterSpec{Pools: []cstor.PoolSpec{{}}},
+	}
+	cspi := &cstor.CStorPoolInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cspc-a-1",
+			Namespace: "openebs",
+			Labels:    map[string]string{types.CStorPoolClusterLabelKey: "cspc-a"},
+		},
+	}
+	c := newTestCSPCMigrator(nil, []runtime.Object{cspc, cspi})
+	got, err := c.generateCSPC("spc-a")
+	if err != nil {
+		t.Fatalf("generateCSPC() error = %v", err)
+	}
+	if _, ok := got.Annotations[types.OpenEBSDisableDependantsReconcileKey]; ok {
+		t.Errorf("generateCSPC() kept the annotation disabling the reconcile of the cspis")
+	}
+	// the reconcile of the cspis is already enabled
+	if _, err := c.generateCSPC("spc-a"); err != nil {
+		t.Errorf("generateCSPC() of a migrated cspc error = %v", err)
+	}
+}
@@ -18,6 +18,7 @@ package migrate
 
 import (
 	"context"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -117,9 +118,16 @@ func (v *VolumeMigrator) createCVPforConfig(sc *storagev1.StorageClass) error {
 			if err != nil {
 				panic(err.Error())
 			}
+			// the tolerations are added in the order of their names
+			// so that the policy is the same on every migration
+			names := make([]string, 0, len(tMap))
+			for name := range tMap {
+				names = append(names, name)
+			}
+			sort.Strings(names)
 			t := []corev1.Toleration{}
-			for _, y := range tMap {
-				t = append(t, y)
+			for _, name := range names {
+				t = append(t, tMap[name])
 			}
 			cvp.Spec.Target.Tolerations = t
 		case "Luworkers":
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"testing"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	openebsFakeClientset "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+)
+
+func Test_isValidStatus(t *testing.T) {
+	tests := map[string]struct {
+		status v1Alpha1API.MigrationDetailedStatuses
+		want   bool
+	}{
+		"waiting step": {
+			status: v1Alpha1API.MigrationDetailedStatuses{Step: "migrate", Phase: v1Alpha1API.StepWaiting},
+			want:   true,
+		},
+		"completed step": {
+			status: v1Alpha1API.MigrationDetailedStatuses{
+				Step: "migrate", Phase: v1Alpha1API.StepCompleted, Message: "migrated",
+			},
+			want: true,
+		},
+		"missing step": {
+			status: v1Alpha1API.MigrationDetailedStatuses{Phase: v1Alpha1API.StepWaiting},
+		},
+		"missing phase": {
+			status: v1Alpha1API.MigrationDetailedStatuses{Step: "migrate"},
+		},
+		"completed step without message": {
+			status: v1Alpha1API.MigrationDetailedStatuses{Step: "migrate", Phase: v1Alpha1API.StepCompleted},
+		},
+		"errored step without reason": {
+			status: v1Alpha1API.MigrationDetailedStatuses{
+				Step: "migrate", Phase: v1Alpha1API.StepErrored, Message: "failed",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isValidStatus(tt.status); got != tt.want {
+				t.Errorf("isValidStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getOrCreateMigrationTask(t *testing.T) {
+	client := openebsFakeClientset.NewSimpleClientset()
+	tests := map[string]struct {
+		kind     string
+		name     string
+		migrator Migrator
+		wantName string
+	}{
+		"pool": {
+			kind:     "cstorPool",
+			name:     "spc-a",
+			migrator: &CSPCMigrator{CSPCName: "cspc-a"},
+			wantName: "migrate-cstor-pool-spc-a",
+		},
+		"volume": {
+			kind:     "cstorVolume",
+			name:     testPVName,
+			migrator: &VolumeMigrator{PVName: testPVName},
+			wantName: "migrate-cstor-volume-" + testPVName,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// the second call fetches the migrationtask created by the first
+			for i := 0; i < 2; i++ {
+				mtask, err := getOrCreateMigrationTask(tt.kind, tt.name, "openebs", tt.migrator, client)
+				if err != nil {
+					t.Fatalf("getOrCreateMigrationTask() error = %v", err)
+				}
+				if mtask.Name != tt.wantName || mtask.Status.Phase != v1Alpha1API.MigrateStarted {
+					t.Errorf("getOrCreateMigrationTask() = %s in %s, want %s started",
+						mtask.Name, mtask.Status.Phase, tt.wantName)
+				}
+			}
+		})
+	}
+}
+
+func Test_updateMigrationDetailedStatus(t *testing.T) {
+	client := openebsFakeClientset.NewSimpleClientset()
+	mtask, err := getOrCreateMigrationTask("cstorVolume", testPVName, "openebs",
+		&VolumeMigrator{PVName: testPVName}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtask, err = updateMigrationDetailedStatus(mtask, v1Alpha1API.MigrationDetailedStatuses{
+		Step: "migrate", Phase: v1Alpha1API.StepWaiting,
+	}, "openebs", client)
+	if err != nil {
+		t.Fatalf("updateMigrationDetailedStatus() error = %v", err)
+	}
+	mtask, err = updateMigrationDetailedStatus(mtask, v1Alpha1API.MigrationDetailedStatuses{
+		Step: "migrate", Phase: v1Alpha1API.StepCompleted, Message: "migrated",
+	}, "openebs", client)
+	if err != nil {
+		t.Fatalf("updateMigrationDetailedStatus() error = %v", err)
+	}
+	statuses := mtask.Status.MigrationDetailedStatuses
+	if len(statuses) != 1 || statuses[0].Phase != v1Alpha1API.StepCompleted || statuses[0].StartTime.IsZero() {
+		t.Errorf("updateMigrationDetailedStatus() statuses = %+v, want the completed step", statuses)
+	}
+	_, err = updateMigrationDetailedStatus(mtask, v1Alpha1API.MigrationDetailedStatuses{
+		Step: "migrate", Phase: v1Alpha1API.StepErrored,
+	}, "openebs", client)
+	if err == nil {
+		t.Errorf("updateMigrationDetailedStatus() of an invalid status error = nil, want error")
+	}
+}
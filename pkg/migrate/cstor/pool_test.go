@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/api/v3/pkg/apis/types"
+	openebsFakeClientset "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	apis "github.com/openebs/maya/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/upgrade/pkg/version"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestCSPCMigrator returns a CSPCMigrator of the spc spc-a to the
+// cspc cspc-a with fake clientsets holding the given objects
+func newTestCSPCMigrator(kubeObjs, openebsObjs []runtime.Object) *CSPCMigrator {
+	return &CSPCMigrator{
+		KubeClientset:    fake.NewSimpleClientset(kubeObjs...),
+		OpenebsClientset: openebsFakeClientset.NewSimpleClientset(openebsObjs...),
+		CSPCObj: &cstor.CStorPoolCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "cspc-a", Namespace: "openebs", UID: "cspc-uid"},
+		},
+		SPCObj:           &apis.StoragePoolClaim{ObjectMeta: metav1.ObjectMeta{Name: "spc-a"}},
+		OpenebsNamespace: "openebs",
+		CSPCName:         "cspc-a",
+	}
+}
+
+func TestCSPCMigrator_validateCSPCOperator(t *testing.T) {
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = "3.0.0"
+
+	tests := map[string]struct {
+		pods    []runtime.Object
+		wantErr bool
+	}{
+		"operator at the migrate version": {
+			pods: []runtime.Object{newTestOperatorPod("cspc-operator", "3.0.0")},
+		},
+		"operator at another version": {
+			pods:    []runtime.Object{newTestOperatorPod("cspc-operator", "2.12.0")},
+			wantErr: true,
+		},
+		"operator missing": {
+			pods:    []runtime.Object{newTestOperatorPod("cvc-operator", "3.0.0")},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newTestCSPCMigrator(tt.pods, nil)
+			if err := c.validateCSPCOperator(); (err != nil) != tt.wantErr {
+				t.Errorf("validateCSPCOperator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCSPCMigrator_scaleDownDeployment(t *testing.T) {
+	var one int32 = 1
+	cspDeploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "spc-a-abcd",
+			Namespace: "openebs",
+			Labels:    map[string]string{"openebs.io/cstor-pool": "spc-a-abcd"},
+		},
+		Spec: appsv1.DeploymentSpec{Replicas: &one},
+	}
+	cspiDeploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "cspc-a-1", Namespace: "openebs"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "tmp"}}},
+			},
+		},
+	}
+	c := newTestCSPCMigrator([]runtime.Object{cspDeploy, cspiDeploy}, nil)
+	if err := c.scaleDownDeployment("spc-a-abcd", "cspc-a-1", "openebs"); err != nil {
+		t.Fatalf("scaleDownDeployment() error = %v", err)
+	}
+	got, err := c.KubeClientset.AppsV1().Deployments("openebs").
+		Get(context.TODO(), "spc-a-abcd", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.Spec.Replicas != 0 || len(got.Spec.Template.Spec.Volumes) != 1 {
+		t.Errorf("scaleDownDeployment() = %d replicas with volumes %v, want 0 replicas with the cspi volumes",
+			*got.Spec.Replicas, got.Spec.Template.Spec.Volumes)
+	}
+	if err := c.scaleDownDeployment("spc-a-efgh", "cspc-a-2", "openebs"); err == nil {
+		t.Errorf("scaleDownDeployment() of a missing deployment error = nil, want error")
+	}
+	if err := c.scaleDownDeployment("spc-a-abcd", "cspc-a-2", "openebs"); err == nil {
+		t.Errorf("scaleDownDeployment() without the cspi deployment error = nil, want error")
+	}
+}
+
+func TestCSPCMigrator_updateBDCs(t *testing.T) {
+	spcRef := metav1.OwnerReference{Kind: "StoragePoolClaim", Name: "spc-a"}
+	bdc := func(name string, labels map[string]string) *v1Alpha1API.BlockDeviceClaim {
+		return &v1Alpha1API.BlockDeviceClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "openebs",
+				Labels:          labels,
+				Finalizers:      []string{spcFinalizer},
+				OwnerReferences: []metav1.OwnerReference{spcRef},
+			},
+		}
+	}
+	c := newTestCSPCMigrator(nil, []runtime.Object{
+		bdc("bdc-1", map[string]string{string(apis.StoragePoolClaimCPK): "spc-a"}),
+		bdc("bdc-2", map[string]string{string(apis.StoragePoolClaimCPK): "spc-b"}),
+	})
+	if err := c.updateBDCLabels(); err != nil {
+		t.Fatalf("updateBDCLabels() error = %v", err)
+	}
+	if err := c.updateBDCOwnerRef(); err != nil {
+		t.Fatalf("updateBDCOwnerRef() error = %v", err)
+	}
+	// the bdcs are already migrated
+	if err := c.updateBDCOwnerRef(); err != nil {
+		t.Fatalf("updateBDCOwnerRef() of migrated bdcs error = %v", err)
+	}
+	bdcs := c.OpenebsClientset.OpenebsV1alpha1().BlockDeviceClaims("openebs")
+	got, err := bdcs.Get(context.TODO(), "bdc-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Labels[types.CStorPoolClusterLabelKey] != "cspc-a" || got.Labels[string(apis.StoragePoolClaimCPK)] != "" {
+		t.Errorf("updateBDCLabels() labels = %v, want the cspc label", got.Labels)
+	}
+	if len(got.Finalizers) != 1 || got.Finalizers[0] != cspcFinalizer {
+		t.Errorf("updateBDCLabels() finalizers = %v, want the cspc finalizer", got.Finalizers)
+	}
+	if len(got.OwnerReferences) != 1 || got.OwnerReferences[0].Kind != cspcKind ||
+		got.OwnerReferences[0].UID != "cspc-uid" {
+		t.Errorf("updateBDCOwnerRef() owner references = %v, want the cspc", got.OwnerReferences)
+	}
+	other, err := bdcs.Get(context.TODO(), "bdc-2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Labels[types.CStorPoolClusterLabelKey] != "" || other.OwnerReferences[0].Kind == cspcKind {
+		t.Errorf("updateBDCLabels() updated the bdc of another spc: %+v", other.ObjectMeta)
+	}
+}
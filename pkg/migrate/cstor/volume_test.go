@@ -0,0 +1,545 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/api/v3/pkg/apis/types"
+	openebsFakeClientset "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	"github.com/openebs/upgrade/pkg/version"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testPVName = "pvc-1"
+
+// newTestVolumeMigrator returns a VolumeMigrator of the pv pvc-1
+// with fake clientsets holding the given objects
+func newTestVolumeMigrator(kubeObjs, openebsObjs []runtime.Object) *VolumeMigrator {
+	return &VolumeMigrator{
+		KubeClientset:    fake.NewSimpleClientset(kubeObjs...),
+		OpenebsClientset: openebsFakeClientset.NewSimpleClientset(openebsObjs...),
+		PVName:           testPVName,
+		OpenebsNamespace: "openebs",
+		CVNamespace:      "openebs",
+		StorageClass:     &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "cstor-sc"}},
+	}
+}
+
+func newTestPV(csi bool) *corev1.PersistentVolume {
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: testPVName},
+		Spec: corev1.PersistentVolumeSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("5Gi"),
+			},
+			ClaimRef:                      &corev1.ObjectReference{Name: "data", Namespace: "app"},
+			StorageClassName:              "cstor-sc",
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+		},
+	}
+	if csi {
+		pv.Spec.CSI = &corev1.CSIPersistentVolumeSource{Driver: cstorCSIDriver, VolumeHandle: testPVName}
+	} else {
+		pv.Spec.ISCSI = &corev1.ISCSIPersistentVolumeSource{FSType: "ext4"}
+	}
+	return pv
+}
+
+func newTestPVC(provisioner string) *corev1.PersistentVolumeClaim {
+	sc := "cstor-sc"
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "data",
+			Namespace: "app",
+			Annotations: map[string]string{
+				"volume.beta.kubernetes.io/storage-provisioner": provisioner,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &sc,
+			VolumeName:       testPVName,
+		},
+	}
+}
+
+func newTestOperatorPod(component, operatorVersion string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      component + "-0",
+			Namespace: "openebs",
+			Labels: map[string]string{
+				"openebs.io/component-name": component,
+				"openebs.io/version":        operatorVersion,
+			},
+		},
+	}
+}
+
+func newTestTargetDeployment(affinity *corev1.Affinity) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: testPVName + "-target", Namespace: "openebs"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Affinity: affinity},
+			},
+		},
+	}
+}
+
+func newTestPodAffinity() *corev1.PodAffinity {
+	return &corev1.PodAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+			{
+				LabelSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "mysql"},
+				},
+				TopologyKey: "kubernetes.io/hostname",
+			},
+		},
+	}
+}
+
+func TestVolumeMigrator_validateCVCOperator(t *testing.T) {
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = "3.0.0"
+
+	tests := map[string]struct {
+		pods    []runtime.Object
+		wantErr bool
+	}{
+		"operator at the migrate version": {
+			pods: []runtime.Object{newTestOperatorPod("cvc-operator", "3.0.0-RC1")},
+		},
+		"operator at another version": {
+			pods:    []runtime.Object{newTestOperatorPod("cvc-operator", "2.12.0")},
+			wantErr: true,
+		},
+		"operator missing": {
+			pods:    []runtime.Object{newTestOperatorPod("cspc-operator", "3.0.0")},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := newTestVolumeMigrator(tt.pods, nil)
+			_, err := v.preMigrate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("preMigrate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVolumeMigrator_deleteTempPolicy(t *testing.T) {
+	v := newTestVolumeMigrator(nil, []runtime.Object{
+		&cstor.CStorVolumePolicy{ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: "openebs"}},
+	})
+	for i := 0; i < 2; i++ {
+		if err := v.deleteTempPolicy(); err != nil {
+			t.Fatalf("deleteTempPolicy() error = %v", err)
+		}
+	}
+	_, err := v.OpenebsClientset.CstorV1().CStorVolumePolicies("openebs").
+		Get(context.TODO(), testPVName, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("deleteTempPolicy() did not delete the policy, error = %v", err)
+	}
+}
+
+func TestVolumeMigrator_validatePVName(t *testing.T) {
+	tests := map[string]struct {
+		kubeObjs    []runtime.Object
+		wantPresent bool
+		wantPVC     bool
+		wantErr     bool
+	}{
+		"pv present": {
+			kubeObjs:    []runtime.Object{newTestPV(false)},
+			wantPresent: true,
+		},
+		"only the pvc is left": {
+			kubeObjs: []runtime.Object{newTestPVC(cstorCSIDriver)},
+			wantPVC:  true,
+		},
+		"neither pv nor pvc": {
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := newTestVolumeMigrator(tt.kubeObjs, nil)
+			pvc, present, err := v.validatePVName()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatePVName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if present != tt.wantPresent || (pvc != nil) != tt.wantPVC {
+				t.Errorf("validatePVName() = %v, %v, want pvc %v, present %v", pvc, present, tt.wantPVC, tt.wantPresent)
+			}
+		})
+	}
+}
+
+func TestVolumeMigrator_migratePVC(t *testing.T) {
+	tests := map[string]struct {
+		pvc      *corev1.PersistentVolumeClaim
+		wantSkip string
+	}{
+		"non csi pvc is recreated": {
+			pvc: newTestPVC("openebs.io/provisioner-iscsi"),
+		},
+		"csi pvc is kept": {
+			pvc: newTestPVC(cstorCSIDriver),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pv := newTestPV(false)
+			v := newTestVolumeMigrator([]runtime.Object{pv, tt.pvc}, nil)
+			got, err := v.migratePVC(pv)
+			if err != nil {
+				t.Fatalf("migratePVC() error = %v", err)
+			}
+			if got.Annotations["volume.beta.kubernetes.io/storage-provisioner"] != cstorCSIDriver {
+				t.Errorf("migratePVC() provisioner = %s, want %s",
+					got.Annotations["volume.beta.kubernetes.io/storage-provisioner"], cstorCSIDriver)
+			}
+			if got.Spec.VolumeName != testPVName || *got.Spec.StorageClassName != "cstor-sc" {
+				t.Errorf("migratePVC() = %+v, want the volume and storageclass of the pv", got.Spec)
+			}
+			pvc, err := v.KubeClientset.CoreV1().PersistentVolumeClaims("app").
+				Get(context.TODO(), "data", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get the migrated pvc: %v", err)
+			}
+			if pvc.Annotations["volume.beta.kubernetes.io/storage-provisioner"] != cstorCSIDriver {
+				t.Errorf("migratePVC() did not recreate the pvc with the csi provisioner")
+			}
+		})
+	}
+}
+
+func TestVolumeMigrator_addSkipAnnotationToPVC(t *testing.T) {
+	pvc := newTestPVC("openebs.io/provisioner-iscsi")
+	v := newTestVolumeMigrator([]runtime.Object{pvc}, nil)
+	if err := v.addSkipAnnotationToPVC(pvc); err != nil {
+		t.Fatalf("addSkipAnnotationToPVC() error = %v", err)
+	}
+	got, err := v.KubeClientset.CoreV1().PersistentVolumeClaims("app").
+		Get(context.TODO(), "data", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Annotations["openebs.io/skip-validations"] != "true" {
+		t.Errorf("addSkipAnnotationToPVC() annotations = %v, want skip-validations", got.Annotations)
+	}
+	// a deleted pvc is skipped
+	missing := newTestPVC("openebs.io/provisioner-iscsi")
+	missing.Name = "missing"
+	if err := v.addSkipAnnotationToPVC(missing); err != nil {
+		t.Errorf("addSkipAnnotationToPVC() of a missing pvc error = %v", err)
+	}
+}
+
+func TestVolumeMigrator_migratePV(t *testing.T) {
+	retain := corev1.PersistentVolumeReclaimRetain
+	tests := map[string]struct {
+		pv            *corev1.PersistentVolume
+		reclaimPolicy *corev1.PersistentVolumeReclaimPolicy
+		wantPolicy    corev1.PersistentVolumeReclaimPolicy
+	}{
+		"non csi pv is recreated with the policy of the storageclass": {
+			pv:            newTestPV(false),
+			reclaimPolicy: &retain,
+			wantPolicy:    corev1.PersistentVolumeReclaimRetain,
+		},
+		"non csi pv is recreated with the delete policy": {
+			pv:         newTestPV(false),
+			wantPolicy: corev1.PersistentVolumeReclaimDelete,
+		},
+		"csi pv is kept": {
+			pv:         newTestPV(true),
+			wantPolicy: corev1.PersistentVolumeReclaimDelete,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := newTestVolumeMigrator([]runtime.Object{tt.pv}, nil)
+			v.StorageClass.ReclaimPolicy = tt.reclaimPolicy
+			got, err := v.migratePV(newTestPVC(cstorCSIDriver))
+			if err != nil {
+				t.Fatalf("migratePV() error = %v", err)
+			}
+			if got.Spec.CSI == nil || got.Spec.CSI.Driver != cstorCSIDriver {
+				t.Errorf("migratePV() source = %+v, want the cstor csi driver", got.Spec.PersistentVolumeSource)
+			}
+			if got.Spec.PersistentVolumeReclaimPolicy != tt.wantPolicy {
+				t.Errorf("migratePV() reclaim policy = %s, want %s", got.Spec.PersistentVolumeReclaimPolicy, tt.wantPolicy)
+			}
+			pv, err := v.KubeClientset.CoreV1().PersistentVolumes().
+				Get(context.TODO(), testPVName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get the migrated pv: %v", err)
+			}
+			if pv.Spec.CSI == nil {
+				t.Errorf("migratePV() did not recreate the pv with the csi source")
+			}
+		})
+	}
+}
+
+func TestVolumeMigrator_IsVolumeMounted(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql", Namespace: "app"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{Name: "config"},
+				{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+					},
+				},
+			},
+		},
+	}
+	v := newTestVolumeMigrator([]runtime.Object{newTestPV(false), pod}, nil)
+	if _, err := v.IsVolumeMounted(testPVName); err == nil {
+		t.Errorf("IsVolumeMounted() of a mounted volume error = nil, want error")
+	}
+	if err := v.KubeClientset.CoreV1().Pods("app").Delete(context.TODO(), "mysql", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	pv, err := v.IsVolumeMounted(testPVName)
+	if err != nil {
+		t.Fatalf("IsVolumeMounted() error = %v", err)
+	}
+	if err := v.RetainPV(pv); err != nil {
+		t.Fatalf("RetainPV() error = %v", err)
+	}
+	pv, err = v.KubeClientset.CoreV1().PersistentVolumes().Get(context.TODO(), testPVName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+		t.Errorf("RetainPV() reclaim policy = %s, want Retain", pv.Spec.PersistentVolumeReclaimPolicy)
+	}
+	if _, err := v.IsVolumeMounted("missing"); err == nil {
+		t.Errorf("IsVolumeMounted() of a missing pv error = nil, want error")
+	}
+}
+
+func TestVolumeMigrator_storageClass(t *testing.T) {
+	sc := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "cstor-sc",
+			Annotations: map[string]string{"cas.openebs.io/config": casConfig},
+		},
+		Provisioner: "openebs.io/provisioner-iscsi",
+	}
+	v := newTestVolumeMigrator([]runtime.Object{sc}, nil)
+	required, err := isSCMigrationRequired(v, "cstor-sc")
+	if err != nil || !required {
+		t.Fatalf("isSCMigrationRequired() = %v, %v, want required", required, err)
+	}
+	tmpSC, err := v.createTmpSC("cstor-sc")
+	if err != nil {
+		t.Fatalf("createTmpSC() error = %v", err)
+	}
+	if tmpSC.Name != "tmp-migrate-cstor-sc" || tmpSC.Annotations["pv-name"] != testPVName {
+		t.Errorf("createTmpSC() = %s %v, want the temporary storageclass of %s", tmpSC.Name, tmpSC.Annotations, testPVName)
+	}
+	// the temporary storageclass is reused
+	if _, err := v.createTmpSC("cstor-sc"); err != nil {
+		t.Errorf("createTmpSC() of an existing storageclass error = %v", err)
+	}
+	required, err = isSCMigrationRequired(v, "cstor-sc")
+	if err != nil || !required {
+		t.Errorf("isSCMigrationRequired() by the same pv = %v, %v, want required", required, err)
+	}
+	// another volume does not migrate the storageclass
+	other := *v
+	other.PVName = "pvc-2"
+	required, err = isSCMigrationRequired(&other, "cstor-sc")
+	if err != nil || required {
+		t.Errorf("isSCMigrationRequired() by another pv = %v, %v, want not required", required, err)
+	}
+	if err := other.updateStorageClass("pvc-2", "cstor-sc"); err != nil {
+		t.Errorf("updateStorageClass() by another pv error = %v", err)
+	}
+	if _, err := v.createTmpSC("missing"); err == nil {
+		t.Errorf("createTmpSC() of a missing storageclass error = nil, want error")
+	}
+}
+
+func TestVolumeMigrator_removeOldTarget(t *testing.T) {
+	v := newTestVolumeMigrator([]runtime.Object{newTestTargetDeployment(nil)}, nil)
+	if err := v.removeOldTarget(); err != nil {
+		t.Fatalf("removeOldTarget() error = %v", err)
+	}
+	_, err := v.KubeClientset.AppsV1().Deployments("openebs").
+		Get(context.TODO(), testPVName+"-target", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("removeOldTarget() did not delete the target deployment, error = %v", err)
+	}
+
+	// the target is kept once the cvc is created
+	cvc := &cstor.CStorVolumeConfig{ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: "openebs"}}
+	v = newTestVolumeMigrator([]runtime.Object{newTestTargetDeployment(nil)}, []runtime.Object{cvc})
+	if err := v.removeOldTarget(); err != nil {
+		t.Fatalf("removeOldTarget() error = %v", err)
+	}
+	if _, err := v.KubeClientset.AppsV1().Deployments("openebs").
+		Get(context.TODO(), testPVName+"-target", metav1.GetOptions{}); err != nil {
+		t.Errorf("removeOldTarget() deleted the target of a migrated volume: %v", err)
+	}
+	// a cvc is not created again
+	if err := v.createCVC(newTestPV(true)); err != nil {
+		t.Errorf("createCVC() of an existing cvc error = %v", err)
+	}
+}
+
+func TestVolumeMigrator_migrateTargetSVC(t *testing.T) {
+	svc := func(namespace string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: namespace}}
+	}
+	v := newTestVolumeMigrator([]runtime.Object{svc("app"), svc("openebs")}, nil)
+	v.CVNamespace = "app"
+	if err := v.removeOldTarget(); err != nil {
+		t.Fatalf("removeOldTarget() error = %v", err)
+	}
+	_, err := v.KubeClientset.CoreV1().Services("app").Get(context.TODO(), testPVName, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("migrateTargetSVC() did not delete the service in the cv namespace, error = %v", err)
+	}
+	if _, err := v.KubeClientset.CoreV1().Services("openebs").
+		Get(context.TODO(), testPVName, metav1.GetOptions{}); err != nil {
+		t.Errorf("migrateTargetSVC() deleted the service in the openebs namespace: %v", err)
+	}
+}
+
+func TestVolumeMigrator_podAffinity(t *testing.T) {
+	affinity := newTestPodAffinity()
+	policy := &cstor.CStorVolumePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: "openebs"},
+		Spec: cstor.CStorVolumePolicySpec{
+			Target: cstor.TargetSpec{PodAffinity: affinity},
+		},
+	}
+	v := newTestVolumeMigrator(
+		[]runtime.Object{newTestTargetDeployment(&corev1.Affinity{PodAffinity: affinity})},
+		[]runtime.Object{policy},
+	)
+	getAffinity := func() *corev1.Affinity {
+		deploy, err := v.KubeClientset.AppsV1().Deployments("openebs").
+			Get(context.TODO(), testPVName+"-target", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deploy.Spec.Template.Spec.Affinity
+	}
+	if err := v.removePodAffinity(); err != nil {
+		t.Fatalf("removePodAffinity() error = %v", err)
+	}
+	if got := getAffinity(); got != nil && got.PodAffinity != nil {
+		t.Errorf("removePodAffinity() affinity = %+v, want none", got)
+	}
+	if err := v.patchTargetPodAffinity(); err != nil {
+		t.Fatalf("patchTargetPodAffinity() error = %v", err)
+	}
+	if got := getAffinity(); got == nil || got.PodAffinity == nil {
+		t.Errorf("patchTargetPodAffinity() did not restore the pod affinity")
+	}
+
+	// the target is not patched without a pod affinity in the policy
+	v = newTestVolumeMigrator(nil, []runtime.Object{
+		&cstor.CStorVolumePolicy{ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: "openebs"}},
+	})
+	if err := v.removePodAffinity(); err != nil {
+		t.Errorf("removePodAffinity() without affinity error = %v", err)
+	}
+	if err := v.patchTargetPodAffinity(); err != nil {
+		t.Errorf("patchTargetPodAffinity() without affinity error = %v", err)
+	}
+}
+
+func TestVolumeMigrator_validateMigratedVolume(t *testing.T) {
+	cvc := &cstor.CStorVolumeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: "openebs"},
+		Status:     cstor.CStorVolumeConfigStatus{Phase: cstor.CStorVolumeConfigPhaseBound},
+	}
+	cv := &cstor.CStorVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: "openebs"},
+		Status:     cstor.CStorVolumeStatus{Phase: cstor.CStorVolumePhase("Healthy")},
+	}
+	cvr := func(pool string) *cstor.CStorVolumeReplica {
+		return &cstor.CStorVolumeReplica{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      testPVName + "-" + pool,
+				Namespace: "openebs",
+				Labels: map[string]string{
+					"openebs.io/persistent-volume":      testPVName,
+					types.CStorPoolInstanceNameLabelKey: pool,
+				},
+			},
+		}
+	}
+	policy := func(pools ...string) *cstor.CStorVolumePolicy {
+		p := &cstor.CStorVolumePolicy{ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: "openebs"}}
+		for _, pool := range pools {
+			p.Spec.ReplicaPoolInfo = append(p.Spec.ReplicaPoolInfo, cstor.ReplicaPoolInfo{PoolName: pool})
+		}
+		return p
+	}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: testPVName, Namespace: "openebs"}}
+
+	v := newTestVolumeMigrator([]runtime.Object{svc},
+		[]runtime.Object{cvc, cv, cvr("cspc-a-1"), cvr("cspc-a-2"), policy("cspc-a-1", "cspc-a-2")})
+	if err := v.validateMigratedVolume(); err != nil {
+		t.Fatalf("validateMigratedVolume() error = %v", err)
+	}
+	got, err := v.KubeClientset.CoreV1().Services("openebs").Get(context.TODO(), testPVName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.OwnerReferences) != 1 || got.OwnerReferences[0].Kind != cvcKind {
+		t.Errorf("validateMigratedVolume() owner references = %v, want the cvc", got.OwnerReferences)
+	}
+
+	v = newTestVolumeMigrator([]runtime.Object{svc},
+		[]runtime.Object{cvc, cv, cvr("cspc-a-1"), policy("cspc-a-1", "cspc-a-3")})
+	if err := v.validateMigratedVolume(); err == nil {
+		t.Errorf("validateMigratedVolume() of a replica on another pool error = nil, want error")
+	}
+}
+
+func TestVolumeMigrator_patchTargetSVCOwnerRef(t *testing.T) {
+	v := newTestVolumeMigrator(nil, nil)
+	if err := v.patchTargetSVCOwnerRef(); err == nil {
+		t.Errorf("patchTargetSVCOwnerRef() without a service error = nil, want error")
+	}
+}
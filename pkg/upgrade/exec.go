@@ -17,24 +17,357 @@ limitations under the License.
 package executor
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/upgrade/pkg/upgrade/impact"
 	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/klog"
 )
 
 // Exec ...
-func Exec(fromVersion, toVersion, kind, name,
-	openebsNamespace, urlprefix, imagetag string) error {
-	rp := upgrader.NewResourcePatch(
-		upgrader.FromVersion(fromVersion),
-		upgrader.ToVersion(toVersion),
-		upgrader.WithName(name),
-		upgrader.WithOpenebsNamespace(openebsNamespace),
-		upgrader.WithBaseURL(urlprefix),
-		upgrader.WithImageTag(imagetag),
-	)
-	u := upgrader.NewUpgrade()
-	err := u.UpgradeMap[kind](rp, u.Client).Upgrade()
+func Exec(kind string, opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) error {
+	rp := upgrader.NewResourcePatch(opts...)
+	u := upgrader.NewUpgrade(clientOpts...)
+	return execUpgrade(kind, rp, u)
+}
+
+// execUpgrade upgrades the resource of the patch using the given upgrade
+func execUpgrade(kind string, rp *upgrader.ResourcePatch, u *upgrader.Upgrade) error {
+	// a failure to sync the upgradetask is only logged, as the
+	// upgradetask is updated again by the upgrade
+	err := upgrader.SyncUpgradeTaskFromResource(kind, rp, u.Client)
+	if err != nil {
+		klog.Warningf("failed to sync the upgradetask of %s %s: %v", kind, rp.Name, err)
+	}
+	// a failure to check is left to the upgrade to report
+	done, err := upgrader.IsAtTargetVersion(kind, rp, u.Client)
+	if err != nil {
+		klog.Warningf("failed to check if %s %s is at %s: %v", kind, rp.Name, rp.To, err)
+	}
+	if done {
+		klog.Infof("%s %s: resource already at target version %s, skipping the upgrade", kind, rp.Name, rp.To)
+		rp.Result.AddSkipped(kind, rp.Name, "already at target version")
+		rp.EmitProgress(upgrader.PhaseSkipped, kind, rp.Name, 100, "already at target version", nil)
+		if rp.DryRun {
+			return nil
+		}
+		serr := rp.Result.Save(u.Client)
+		if serr != nil {
+			klog.Warningf("failed to save upgrade result: %v", serr)
+		}
+		return nil
+	}
+	rp.Result.Start(kind, rp.Name)
+	rp.EmitProgress(upgrader.PhaseStarted, kind, rp.Name, 0, "", nil)
+	if !rp.VerifyOnly {
+		err := upgrader.CheckKubernetesCompatibility(rp, u.Client)
+		if errors.Is(err, upgrader.ErrValidation) {
+			rp.Result.Add(kind, rp.Name, err)
+			rp.EmitResult(kind, rp.Name, err)
+			return err
+		}
+		// the upgrade is not blocked if the version can not be read
+		if err != nil {
+			klog.Warningf("failed to check the kubernetes version: %v", err)
+		}
+	}
+	if rp.VerifyImages && !rp.VerifyOnly {
+		err := upgrader.VerifyImages(kind, rp, u.Client)
+		if err != nil {
+			rp.Result.Add(kind, rp.Name, err)
+			rp.EmitResult(kind, rp.Name, err)
+			return err
+		}
+	}
+	// a dry run does not change the cluster, so it is not approved,
+	// does not run the hooks and does not save its result
+	if rp.DryRun {
+		err = u.UpgradeMap[kind](rp, u.Client).Upgrade()
+		rp.Result.Add(kind, rp.Name, err)
+		rp.EmitResult(kind, rp.Name, err)
+		return err
+	}
+	if rp.RequireApproval && !rp.VerifyOnly {
+		err = waitForApproval(kind, rp, u.Client)
+		if err != nil {
+			rp.Result.Add(kind, rp.Name, err)
+			rp.EmitResult(kind, rp.Name, err)
+			return err
+		}
+	}
+	err = rp.RunPreUpgradeHook(kind)
+	if err != nil {
+		rp.Result.Add(kind, rp.Name, err)
+		rp.EmitResult(kind, rp.Name, err)
+		return err
+	}
+	err = u.UpgradeMap[kind](rp, u.Client).Upgrade()
+	rp.RunPostUpgradeHook(kind, err)
+	rp.Result.Add(kind, rp.Name, err)
+	rp.EmitResult(kind, rp.Name, err)
+	// the result is saved on a best effort basis
+	serr := rp.Result.Save(u.Client)
+	if serr != nil {
+		klog.Warningf("failed to save upgrade result: %v", serr)
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// ClusterTarget is a cluster to run an upgrade on, identified
+// by its name in the results
+type ClusterTarget struct {
+	Name           string
+	KubeConfigPath string
+	// KubeContext is the current context of the kubeconfig if empty
+	KubeContext string
+	MasterURL   string
+}
+
+// ClusterResult is the outcome of an upgrade on a cluster
+type ClusterResult struct {
+	Cluster string
+	Result  *upgrader.UpgradeResult
+	Err     error
+}
+
+// ExecMultiCluster runs the upgrade of the given resource on each of the
+// clusters concurrently, with clients of its own built from the client
+// options and the kubeconfig of the cluster. The outcome on each cluster
+// is recorded in a result of its own, kept in memory instead of any
+// result set in the options, and the results are returned in the order
+// of the clusters.
+func ExecMultiCluster(kind string, clusters []ClusterTarget, opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) []ClusterResult {
+	results := make([]ClusterResult, len(clusters))
+	var wg sync.WaitGroup
+	for i, cluster := range clusters {
+		wg.Add(1)
+		go func(i int, cluster ClusterTarget) {
+			defer wg.Done()
+			rp := upgrader.NewResourcePatch(opts...)
+			rp.Result = upgrader.NewUpgradeResult("", rp.OpenebsNamespace, rp.From, rp.To)
+			results[i] = ClusterResult{Cluster: cluster.Name, Result: rp.Result}
+			copts := append([]upgrader.ClientOptions{}, clientOpts...)
+			copts = append(copts,
+				upgrader.WithKubeConfigPath(cluster.KubeConfigPath),
+				upgrader.WithKubeContext(cluster.KubeContext),
+				upgrader.WithMasterURL(cluster.MasterURL),
+			)
+			u, err := upgrader.NewClusterUpgrade(copts...)
+			if err != nil {
+				err = &upgrader.Error{Kind: upgrader.ErrAPI, Cause: err}
+				rp.Result.Add(kind, rp.Name, err)
+				results[i].Err = err
+				return
+			}
+			klog.Infof("cluster %s: upgrading %s %s to %s", cluster.Name, kind, rp.Name, rp.To)
+			results[i].Err = execUpgrade(kind, rp, u)
+		}(i, cluster)
+	}
+	wg.Wait()
+	return results
+}
+
+// waitForApproval waits for the approval of the upgrade of the
+// resource, until the approval timeout if it is set
+func waitForApproval(kind string, rp *upgrader.ResourcePatch, client *upgrader.Client) error {
+	ctx := context.Background()
+	if rp.ApprovalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rp.ApprovalTimeout)
+		defer cancel()
+	}
+	return upgrader.WaitForApproval(ctx, kind, rp, client)
+}
+
+// WaitForVersion waits for the given resource to be reconciled
+// to the desired version without patching it
+func WaitForVersion(kind string, opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) error {
+	rp := upgrader.NewResourcePatch(opts...)
+	u := upgrader.NewUpgrade(clientOpts...)
+	err := upgrader.WaitForVersion(kind, rp, u.Client)
+	rp.Result.Add(kind, rp.Name, err)
+	serr := rp.Result.Save(u.Client)
+	if serr != nil {
+		klog.Warningf("failed to save upgrade result: %v", serr)
+	}
+	return err
+}
+
+// GenerateTasks returns the upgradetasks that would be
+// created to upgrade the given resource
+func GenerateTasks(kind string, opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) ([]*v1Alpha1API.UpgradeTask, error) {
+	rp := upgrader.NewResourcePatch(opts...)
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.GenerateUpgradeTasks(kind, rp, u.Client)
+}
+
+// PlanTasks returns the upgradetasks to upgrade all the resources
+// matching the selector which are older than the to version, and
+// creates those which do not exist yet if apply is set
+func PlanTasks(selector string, apply bool, opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) ([]*v1Alpha1API.UpgradeTask, error) {
+	rp := upgrader.NewResourcePatch(opts...)
+	u := upgrader.NewUpgrade(clientOpts...)
+	utasks, err := upgrader.PlanUpgradeTasks(rp, selector, u.Client)
+	if err != nil || !apply {
+		return utasks, err
+	}
+	created, err := upgrader.ApplyUpgradeTasks(utasks, u.Client)
+	klog.Infof("Created %d of %d upgradetasks", created, len(utasks))
+	return utasks, err
+}
+
+// SmokeTest provisions a test volume using the storageclass of the
+// given resource and verifies that data can be written and read
+func SmokeTest(kind string, opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) error {
+	rp := upgrader.NewResourcePatch(opts...)
+	u := upgrader.NewUpgrade(clientOpts...)
+	st := upgrader.NewSmokeTest(
+		upgrader.WithSmokeTestResorcePatch(rp),
+		upgrader.WithSmokeTestClient(u.Client),
+	)
+	err := st.Init(kind)
+	if err != nil {
+		return err
+	}
+	return st.Run()
+}
+
+// SelfTest verifies the upgrade can be run with the given client options
+func SelfTest(ctx context.Context, clientOpts ...upgrader.ClientOptions) error {
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.SelfTest(ctx, u.Client)
+}
+
+// Cleanup deletes the successful upgradetasks in the namespace
+// which completed more than olderThan ago
+func Cleanup(namespace string, olderThan time.Duration,
+	clientOpts ...upgrader.ClientOptions) (int, error) {
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.CleanupUpgradeTasks(namespace, olderThan, u.Client)
+}
+
+// ReconcileUpgradePlans creates and orchestrates the upgradetasks
+// of the upgradeplans in the namespace
+func ReconcileUpgradePlans(namespace string, clientOpts ...upgrader.ClientOptions) error {
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.ReconcileUpgradePlans(namespace, u.Client)
+}
+
+// ListUpgradeTasks returns the status of the upgradetasks in the namespace
+func ListUpgradeTasks(namespace string,
+	clientOpts ...upgrader.ClientOptions) (upgrader.UpgradeTaskInfoList, error) {
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.ListUpgradeTasks(namespace, u.Client)
+}
+
+// RegenerateUpgradeJobs returns the jobs which re-run the upgrades of the
+// failed upgradetasks in the namespace, and creates them if apply is set
+func RegenerateUpgradeJobs(namespace string, cfg upgrader.UpgradeJobConfig, apply bool,
+	clientOpts ...upgrader.ClientOptions) ([]*batchv1.Job, error) {
+	u := upgrader.NewUpgrade(clientOpts...)
+	jobs, err := upgrader.RegenerateUpgradeJobs(namespace, cfg, u.Client)
+	if err != nil || !apply {
+		return jobs, err
+	}
+	for _, jobObj := range jobs {
+		err = upgrader.CreateUpgradeJob(jobObj, u.Client)
+		if err != nil {
+			return jobs, err
+		}
+	}
+	return jobs, nil
+}
+
+// ListPendingResources returns the resources in the namespace whose
+// current version is older than the to version
+func ListPendingResources(namespace, to string,
+	clientOpts ...upgrader.ClientOptions) (upgrader.PendingResourceList, error) {
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.ListPendingResources(namespace, to, u.Client)
+}
+
+// WaitForCVCReadiness waits for the cvcs being
+// provisioned to be Bound before the volumes are upgraded
+func WaitForCVCReadiness(opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) error {
+	rp := upgrader.NewResourcePatch(opts...)
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.NewCVCReadinessGate(rp, u.Client).Wait()
+}
+
+// SortVolumesByLineage orders the given cstor volumes
+// so that the parent of a clone comes before the clone
+func SortVolumesByLineage(names []string, opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) ([]string, error) {
+	rp := upgrader.NewResourcePatch(opts...)
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.SortVolumesByLineage(names, rp.OpenebsNamespace, u.Client)
+}
+
+// AnalyzeImpact returns the estimated impact of upgrading the
+// resources in the namespace from the from version to the to version
+func AnalyzeImpact(namespace, from, to string,
+	clientOpts ...upgrader.ClientOptions) (*impact.ImpactReport, error) {
+	u := upgrader.NewUpgrade(clientOpts...)
+	a, err := impact.NewImpactAnalyzer(u.Client, namespace, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.Analyze()
+}
+
+// BuildDependencyGraph returns the dependency graph of the upgrade
+// relevant resources in the namespace of the client
+func BuildDependencyGraph(ctx context.Context,
+	clientOpts ...upgrader.ClientOptions) (*upgrader.DepGraph, error) {
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.BuildDependencyGraph(ctx, u.Client)
+}
+
+// PreFlight runs the prechecks of the upgrade of the given
+// resource and returns the report of the findings
+func PreFlight(kind string, opts []upgrader.ResourcePatchOptions,
+	clientOpts ...upgrader.ClientOptions) (*upgrader.PrecheckReport, error) {
+	rp := upgrader.NewResourcePatch(opts...)
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.PreFlight(kind, rp, u.Client)
+}
+
+// OperatorVersions returns the versions advertised by the
+// openebs operators in the namespace of the client
+func OperatorVersions(clientOpts ...upgrader.ClientOptions) ([]string, error) {
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.OperatorVersions(u.Client)
+}
+
+// WatchUpgradeTask streams the status of the upgradetask
+// in the namespace each time its phase changes
+func WatchUpgradeTask(ctx context.Context, name, namespace string,
+	clientOpts ...upgrader.ClientOptions) (<-chan v1Alpha1API.UpgradeTaskStatus, error) {
+	u := upgrader.NewUpgrade(clientOpts...)
+	return upgrader.WatchUpgradeTask(ctx, name, namespace, u.Client)
+}
+
+// SaveReport saves the report of the upgrade in the configmap of the
+// result, the client is not built if the result is not saved
+func SaveReport(result *upgrader.UpgradeResult, clientOpts ...upgrader.ClientOptions) error {
+	if result == nil || result.Name == "" {
+		return nil
+	}
+	u := upgrader.NewUpgrade(clientOpts...)
+	return result.SaveReport(u.Client)
+}
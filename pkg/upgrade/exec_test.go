@@ -0,0 +1,239 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	upgrader "github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestSimulator(t *testing.T) *upgrader.Simulator {
+	t.Helper()
+	sim, err := upgrader.NewSimulator([]runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: upgradetesting.Namespace}},
+		upgradetesting.NewTestCSPC("cspc-a", "2.12.0"),
+		upgradetesting.NewTestCSPI("cspi-1", "cspc-a", "2.12.0"),
+		upgradetesting.NewTestDeployment("cspi-1", "2.12.0",
+			map[string]string{"openebs.io/cstor-pool-instance": "cspi-1"},
+			"openebs/cstor-pool:2.12.0", "openebs/cstor-pool-manager:2.12.0"),
+	}, upgrader.WithSimLatency(0))
+	if err != nil {
+		t.Fatalf("NewSimulator() error = %v", err)
+	}
+	return sim
+}
+
+func TestExecDryRun(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "hook-ran")
+	hook := filepath.Join(dir, "hook.sh")
+	err := ioutil.WriteFile(hook, []byte("#!/bin/sh\ntouch "+marker+"\n"), 0755)
+	if err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+	sim := newTestSimulator(t)
+	diffs := &bytes.Buffer{}
+	err = Exec("cstorPoolInstance", []upgrader.ResourcePatchOptions{
+		upgrader.WithName("cspi-1"),
+		upgrader.FromVersion("2.12.0"),
+		upgrader.ToVersion("3.0.0"),
+		upgrader.WithOpenebsNamespace(upgradetesting.Namespace),
+		upgrader.WithDryRun(true),
+		upgrader.WithDiffOutput(diffs, upgrader.DiffText, false),
+		upgrader.WithRequireApproval(true),
+		upgrader.WithApprovalTimeout(time.Millisecond),
+		upgrader.WithPreUpgradeHook(hook),
+		upgrader.WithPostUpgradeHook(hook),
+		upgrader.WithResult(upgrader.NewUpgradeResult("upgrade-result", upgradetesting.Namespace, "2.12.0", "3.0.0")),
+	}, upgrader.WithSimulator(sim))
+	if err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+	if diffs.Len() == 0 {
+		t.Errorf("Exec() wrote no diffs in dry run")
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("Exec() ran the hooks in dry run")
+	}
+	client := sim.Client()
+	utasks, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utasks.Items) != 0 {
+		t.Errorf("Exec() created %d upgradetasks in dry run, want none", len(utasks.Items))
+	}
+	jobs, err := client.KubeClientset.BatchV1().Jobs(upgradetesting.Namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Errorf("Exec() created %d jobs in dry run, want none", len(jobs.Items))
+	}
+	_, err = client.KubeClientset.CoreV1().ConfigMaps(upgradetesting.Namespace).
+		Get(context.TODO(), "upgrade-result", metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("Exec() saved the upgrade result in dry run")
+	}
+}
+
+func TestExecUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "hook-ran")
+	hook := filepath.Join(dir, "hook.sh")
+	err := ioutil.WriteFile(hook, []byte("#!/bin/sh\ntouch "+marker+"\n"), 0755)
+	if err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+	sim := newTestSimulator(t)
+	opts := []upgrader.ResourcePatchOptions{
+		upgrader.WithName("cspi-1"),
+		upgrader.FromVersion("2.12.0"),
+		upgrader.ToVersion("3.0.0"),
+		upgrader.WithOpenebsNamespace(upgradetesting.Namespace),
+		upgrader.WithPreUpgradeHook(hook),
+		upgrader.WithResult(upgrader.NewUpgradeResult("upgrade-result", upgradetesting.Namespace, "2.12.0", "3.0.0")),
+	}
+	err = Exec("cstorPoolInstance", opts, upgrader.WithSimulator(sim))
+	if err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Exec() did not run the pre upgrade hook")
+	}
+	client := sim.Client()
+	_, err = client.KubeClientset.CoreV1().ConfigMaps(upgradetesting.Namespace).
+		Get(context.TODO(), "upgrade-result", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("Exec() did not save the upgrade result: %v", err)
+	}
+
+	// the upgraded cspi is skipped
+	diffs := &bytes.Buffer{}
+	err = Exec("cstorPoolInstance", append(opts, upgrader.WithDiffOutput(diffs, upgrader.DiffText, false)),
+		upgrader.WithSimulator(sim))
+	if err != nil {
+		t.Fatalf("Exec() of an upgraded cspi error = %v", err)
+	}
+	if diffs.Len() != 0 {
+		t.Errorf("Exec() of an upgraded cspi patched it again")
+	}
+}
+
+func TestExecMultiCluster(t *testing.T) {
+	dir := t.TempDir()
+	results := ExecMultiCluster("cstorPoolInstance", []ClusterTarget{
+		{Name: "a", KubeConfigPath: filepath.Join(dir, "missing-a")},
+		{Name: "b", KubeConfigPath: filepath.Join(dir, "missing-b")},
+	}, []upgrader.ResourcePatchOptions{
+		upgrader.WithName("cspi-1"),
+		upgrader.FromVersion("2.12.0"),
+		upgrader.ToVersion("3.0.0"),
+	})
+	if len(results) != 2 {
+		t.Fatalf("ExecMultiCluster() returned %d results, want 2", len(results))
+	}
+	for i, cluster := range []string{"a", "b"} {
+		if results[i].Cluster != cluster {
+			t.Errorf("ExecMultiCluster() result %d is of cluster %s, want %s", i, results[i].Cluster, cluster)
+		}
+		if !errors.Is(results[i].Err, upgrader.ErrAPI) {
+			t.Errorf("ExecMultiCluster() error on cluster %s = %v, want an api error", cluster, results[i].Err)
+		}
+	}
+}
+
+func TestExecHelpers(t *testing.T) {
+	sim := newTestSimulator(t)
+	clientOpts := []upgrader.ClientOptions{upgrader.WithSimulator(sim)}
+	opts := []upgrader.ResourcePatchOptions{
+		upgrader.WithName("cspc-a"),
+		upgrader.FromVersion("2.12.0"),
+		upgrader.ToVersion("3.0.0"),
+		upgrader.WithOpenebsNamespace(upgradetesting.Namespace),
+	}
+	utasks, err := GenerateTasks("cstorPoolCluster", opts, clientOpts...)
+	if err != nil || len(utasks) == 0 {
+		t.Errorf("GenerateTasks() = %d upgradetasks, error %v, want the upgradetasks of the cspc", len(utasks), err)
+	}
+	utasks, err = PlanTasks("", true, opts, clientOpts...)
+	if err != nil {
+		t.Errorf("PlanTasks() error = %v", err)
+	}
+	tasks, err := ListUpgradeTasks(upgradetesting.Namespace, clientOpts...)
+	if err != nil || len(tasks) != len(utasks) {
+		t.Errorf("ListUpgradeTasks() = %d upgradetasks, error %v, want the %d planned", len(tasks), err, len(utasks))
+	}
+	if _, err := Cleanup(upgradetesting.Namespace, time.Hour, clientOpts...); err != nil {
+		t.Errorf("Cleanup() error = %v", err)
+	}
+	if _, err := RegenerateUpgradeJobs(upgradetesting.Namespace, upgrader.UpgradeJobConfig{}, true, clientOpts...); err != nil {
+		t.Errorf("RegenerateUpgradeJobs() error = %v", err)
+	}
+	pending, err := ListPendingResources(upgradetesting.Namespace, "3.0.0", clientOpts...)
+	if err != nil || len(pending) == 0 {
+		t.Errorf("ListPendingResources() = %d resources, error %v, want the cspc", len(pending), err)
+	}
+	if _, err := SortVolumesByLineage(nil, opts, clientOpts...); err != nil {
+		t.Errorf("SortVolumesByLineage() error = %v", err)
+	}
+	if _, err := AnalyzeImpact(upgradetesting.Namespace, "2.12.0", "3.0.0", clientOpts...); err != nil {
+		t.Errorf("AnalyzeImpact() error = %v", err)
+	}
+	if _, err := BuildDependencyGraph(context.TODO(), clientOpts...); err != nil {
+		t.Errorf("BuildDependencyGraph() error = %v", err)
+	}
+	if _, err := PreFlight("cstorPoolCluster", opts, clientOpts...); err != nil {
+		t.Errorf("PreFlight() error = %v", err)
+	}
+	if _, err := OperatorVersions(clientOpts...); err != nil {
+		t.Errorf("OperatorVersions() error = %v", err)
+	}
+	if err := WaitForCVCReadiness(opts, clientOpts...); err != nil {
+		t.Errorf("WaitForCVCReadiness() error = %v", err)
+	}
+	// the upgradeplans are read with a dynamic clientset, which is not simulated
+	if err := ReconcileUpgradePlans(upgradetesting.Namespace, clientOpts...); err == nil {
+		t.Errorf("ReconcileUpgradePlans() without a dynamic clientset error = nil, want error")
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	if _, err := WatchUpgradeTask(ctx, "missing", upgradetesting.Namespace, clientOpts...); err == nil {
+		t.Errorf("WatchUpgradeTask() of a missing upgradetask error = nil, want error")
+	}
+	if err := SaveReport(nil, clientOpts...); err != nil {
+		t.Errorf("SaveReport() without a result error = %v", err)
+	}
+	result := upgrader.NewUpgradeResult("upgrade-result", upgradetesting.Namespace, "2.12.0", "3.0.0")
+	if err := SaveReport(result, clientOpts...); err != nil {
+		t.Errorf("SaveReport() error = %v", err)
+	}
+}
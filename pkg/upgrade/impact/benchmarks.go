@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impact
+
+import (
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// anyVersion matches any version in a benchmark
+const anyVersion = "*"
+
+// Benchmark is the time taken to restart the pools and the volume
+// targets measured while upgrading between a pair of versions
+type Benchmark struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// CSPIRestartSeconds is the time a cspi is unavailable
+	// while its pool pod restarts with the new image
+	CSPIRestartSeconds float64 `json:"cspiRestartSeconds"`
+	// TargetRestartSeconds is the time a cstor volume is unavailable
+	// while its target deployment restarts with the new image
+	TargetRestartSeconds float64 `json:"targetRestartSeconds"`
+}
+
+// ID returns the version pair of the benchmark
+func (b Benchmark) ID() string {
+	return b.From + "->" + b.To
+}
+
+// benchmarksYAML are the benchmarks measured on the release upgrades.
+// The entries with * match any version and are used when the exact
+// pair of versions was not benchmarked.
+const benchmarksYAML = `
+benchmarks:
+- from: "2.12.0"
+  to: "3.0.0"
+  cspiRestartSeconds: 45
+  targetRestartSeconds: 20
+- from: "2.11.0"
+  to: "3.0.0"
+  cspiRestartSeconds: 50
+  targetRestartSeconds: 22
+- from: "2.12.0"
+  to: "2.12.2"
+  cspiRestartSeconds: 40
+  targetRestartSeconds: 18
+- from: "*"
+  to: "3.0.0"
+  cspiRestartSeconds: 55
+  targetRestartSeconds: 25
+- from: "*"
+  to: "*"
+  cspiRestartSeconds: 60
+  targetRestartSeconds: 30
+`
+
+// loadBenchmarks parses the benchmarks yaml
+func loadBenchmarks(data string) ([]Benchmark, error) {
+	doc := struct {
+		Benchmarks []Benchmark `json:"benchmarks"`
+	}{}
+	err := yaml.Unmarshal([]byte(data), &doc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the upgrade benchmarks")
+	}
+	return doc.Benchmarks, nil
+}
+
+// findBenchmark returns the benchmark of the exact pair of versions,
+// else the one of any version to the to version, else the one of any
+// pair of versions. An empty from version only matches any version.
+func findBenchmark(benchmarks []Benchmark, from, to string) (Benchmark, error) {
+	for _, pair := range [][2]string{{from, to}, {anyVersion, to}, {anyVersion, anyVersion}} {
+		for _, b := range benchmarks {
+			if b.From == pair[0] && b.To == pair[1] {
+				return b, nil
+			}
+		}
+	}
+	return Benchmark{}, errors.Errorf("no upgrade benchmark found from %q to %q", from, to)
+}
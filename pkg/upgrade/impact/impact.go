@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impact
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImpactReport is the estimated impact of upgrading the cstor
+// resources of a namespace, before the upgrade is started
+type ImpactReport struct {
+	From string `json:"fromVersion,omitempty"`
+	To   string `json:"toVersion"`
+	// VolumesAffected are the volumes whose target restarts or
+	// which have a replica on a cspi that restarts
+	VolumesAffected int `json:"volumesAffected"`
+	// EstimatedDowntimeSeconds is the longest time a single volume
+	// is estimated to be unavailable during the upgrade
+	EstimatedDowntimeSeconds float64 `json:"estimatedDowntimeSeconds"`
+	CSPIsToRestart           int     `json:"cspisToRestart"`
+	// Benchmark is the version pair of the benchmark the estimate is based on
+	Benchmark string `json:"benchmark"`
+}
+
+// Headers returns the headers of the table of the report
+func (r *ImpactReport) Headers() []string {
+	return []string{"FROM", "TO", "VOLUMES AFFECTED", "CSPIS TO RESTART", "ESTIMATED DOWNTIME", "BENCHMARK"}
+}
+
+// Rows returns the row of the report
+func (r *ImpactReport) Rows() [][]string {
+	return [][]string{{
+		r.From,
+		r.To,
+		strconv.Itoa(r.VolumesAffected),
+		strconv.Itoa(r.CSPIsToRestart),
+		fmt.Sprintf("%.0fs", r.EstimatedDowntimeSeconds),
+		r.Benchmark,
+	}}
+}
+
+// ImpactAnalyzer estimates the impact of an upgrade from the pending
+// resources of a namespace and the benchmarks of the version pair
+type ImpactAnalyzer struct {
+	client     *upgrader.Client
+	namespace  string
+	from, to   string
+	benchmarks []Benchmark
+}
+
+// NewImpactAnalyzer returns an ImpactAnalyzer of the upgrade of the
+// namespace from the from version, which can be empty, to the to version
+func NewImpactAnalyzer(client *upgrader.Client, namespace, from, to string) (*ImpactAnalyzer, error) {
+	benchmarks, err := loadBenchmarks(benchmarksYAML)
+	if err != nil {
+		return nil, err
+	}
+	return &ImpactAnalyzer{
+		client:     client,
+		namespace:  namespace,
+		from:       from,
+		to:         to,
+		benchmarks: benchmarks,
+	}, nil
+}
+
+// Analyze reads the cstor resources of the namespace and estimates the
+// impact of their upgrade. A volume is unavailable while its target
+// restarts, and while its cspi restarts only if it has a single replica,
+// as the cspis are restarted one after the other.
+func (a *ImpactAnalyzer) Analyze() (*ImpactReport, error) {
+	b, err := findBenchmark(a.benchmarks, a.from, a.to)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := upgrader.ListPendingResources(a.namespace, a.to, a.client)
+	if err != nil {
+		return nil, err
+	}
+	cspis := map[string]bool{}
+	targets := map[string]bool{}
+	for _, res := range pending {
+		switch res.Kind {
+		case "cstorPoolInstance":
+			cspis[res.Name] = true
+		case "cstorVolume":
+			targets[res.Name] = true
+		}
+	}
+	cvrList, err := a.client.OpenebsClientset.CstorV1().CStorVolumeReplicas(a.namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list cvrs")
+	}
+	replicas := map[string]int{}
+	restarted := map[string]int{}
+	for _, cvrObj := range cvrList.Items {
+		volume := cvrObj.Labels["openebs.io/persistent-volume"]
+		if volume == "" {
+			continue
+		}
+		replicas[volume]++
+		if cspis[cvrObj.Labels["cstorpoolinstance.openebs.io/name"]] {
+			restarted[volume]++
+		}
+	}
+	volumes := map[string]float64{}
+	for volume := range targets {
+		volumes[volume] += b.TargetRestartSeconds
+	}
+	for volume, n := range restarted {
+		// a replicated volume stays available while one of its
+		// replicas restarts, so it is affected without a downtime
+		volumes[volume] += 0
+		if replicas[volume] == 1 {
+			volumes[volume] += float64(n) * b.CSPIRestartSeconds
+		}
+	}
+	report := &ImpactReport{
+		From:            a.from,
+		To:              a.to,
+		VolumesAffected: len(volumes),
+		CSPIsToRestart:  len(cspis),
+		Benchmark:       b.ID(),
+	}
+	for _, downtime := range volumes {
+		if downtime > report.EstimatedDowntimeSeconds {
+			report.EstimatedDowntimeSeconds = downtime
+		}
+	}
+	return report, nil
+}
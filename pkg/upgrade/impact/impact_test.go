@@ -0,0 +1,156 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impact
+
+import (
+	"reflect"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/openebs/upgrade/pkg/upgrade/upgrader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testCV(name, version string) *cstor.CStorVolume {
+	return &cstor.CStorVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: upgradetesting.Namespace,
+		},
+		VersionDetails: upgradetesting.NewTestVersionDetails(version),
+	}
+}
+
+func testCVR(name, volume, cspi string) *cstor.CStorVolumeReplica {
+	return &cstor.CStorVolumeReplica{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: upgradetesting.Namespace,
+			Labels: map[string]string{
+				"openebs.io/persistent-volume":      volume,
+				"cstorpoolinstance.openebs.io/name": cspi,
+			},
+		},
+	}
+}
+
+func TestImpactAnalyzer_Analyze(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		objects []runtime.Object
+		want    *ImpactReport
+	}{
+		{
+			name: "single replica volume on a restarted cspi",
+			from: "2.12.0",
+			objects: []runtime.Object{
+				upgradetesting.NewTestCSPI("cspi-a", "cspc", "2.12.0"),
+				testCV("pvc-1", "2.12.0"),
+				testCVR("pvc-1-cspi-a", "pvc-1", "cspi-a"),
+			},
+			want: &ImpactReport{
+				From: "2.12.0", To: "3.0.0",
+				VolumesAffected:          1,
+				EstimatedDowntimeSeconds: 65,
+				CSPIsToRestart:           1,
+				Benchmark:                "2.12.0->3.0.0",
+			},
+		},
+		{
+			name: "replicated volume only restarts its target",
+			from: "2.12.0",
+			objects: []runtime.Object{
+				upgradetesting.NewTestCSPI("cspi-a", "cspc", "2.12.0"),
+				upgradetesting.NewTestCSPI("cspi-b", "cspc", "2.12.0"),
+				testCV("pvc-1", "2.12.0"),
+				testCVR("pvc-1-cspi-a", "pvc-1", "cspi-a"),
+				testCVR("pvc-1-cspi-b", "pvc-1", "cspi-b"),
+			},
+			want: &ImpactReport{
+				From: "2.12.0", To: "3.0.0",
+				VolumesAffected:          1,
+				EstimatedDowntimeSeconds: 20,
+				CSPIsToRestart:           2,
+				Benchmark:                "2.12.0->3.0.0",
+			},
+		},
+		{
+			name: "upgraded volume with a replica on a restarted cspi",
+			objects: []runtime.Object{
+				upgradetesting.NewTestCSPI("cspi-a", "cspc", "2.12.0"),
+				upgradetesting.NewTestCSPI("cspi-b", "cspc", "3.0.0"),
+				testCV("pvc-1", "3.0.0"),
+				testCVR("pvc-1-cspi-a", "pvc-1", "cspi-a"),
+				testCVR("pvc-1-cspi-b", "pvc-1", "cspi-b"),
+			},
+			want: &ImpactReport{
+				To:              "3.0.0",
+				VolumesAffected: 1,
+				CSPIsToRestart:  1,
+				Benchmark:       "*->3.0.0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kube, openebs := upgradetesting.NewTestClientsets(tt.objects...)
+			client := &upgrader.Client{KubeClientset: kube, OpenebsClientset: openebs}
+			a, err := NewImpactAnalyzer(client, upgradetesting.Namespace, tt.from, "3.0.0")
+			if err != nil {
+				t.Fatalf("NewImpactAnalyzer() error = %v", err)
+			}
+			got, err := a.Analyze()
+			if err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Analyze() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findBenchmark(t *testing.T) {
+	benchmarks, err := loadBenchmarks(benchmarksYAML)
+	if err != nil {
+		t.Fatalf("loadBenchmarks() error = %v", err)
+	}
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"2.12.0", "3.0.0", "2.12.0->3.0.0"},
+		{"2.10.0", "3.0.0", "*->3.0.0"},
+		{"", "3.0.0", "*->3.0.0"},
+		{"2.12.0", "3.1.0", "*->*"},
+	}
+	for _, tt := range tests {
+		got, err := findBenchmark(benchmarks, tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("findBenchmark(%q, %q) error = %v", tt.from, tt.to, err)
+		}
+		if got.ID() != tt.want {
+			t.Errorf("findBenchmark(%q, %q) = %s, want %s", tt.from, tt.to, got.ID(), tt.want)
+		}
+	}
+	if _, err := findBenchmark(nil, "2.12.0", "3.0.0"); err == nil {
+		t.Errorf("findBenchmark() of no benchmarks error = nil, want an error")
+	}
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	openebsfake "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testNamespace = "openebs"
+	testPatch     = `{"metadata":{"labels":{"openebs.io/version":"3.0.0"}}}`
+)
+
+var errTestPatch = errors.New("patch denied")
+
+// getPatcher is a patcher which can read its object from the cluster
+type getPatcher interface {
+	Patcher
+	Get(name, namespace string) error
+}
+
+func testVersionDetails(version string) apis.VersionDetails {
+	return apis.VersionDetails{
+		Desired: version,
+		Status:  apis.VersionStatus{Current: version},
+	}
+}
+
+func testObjectMeta(name, version string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: testNamespace,
+		Labels:    map[string]string{"openebs.io/version": version},
+	}
+}
+
+// newCStorPatchers returns the patchers of the cstor resources, each
+// with the object at the given version in the fake clientset
+func newCStorPatchers(version string) (map[string]getPatcher, *openebsfake.Clientset) {
+	client := openebsfake.NewSimpleClientset(
+		&apis.CStorPoolCluster{ObjectMeta: testObjectMeta("cspc", version), VersionDetails: testVersionDetails(version)},
+		&apis.CStorPoolInstance{ObjectMeta: testObjectMeta("cspi", version), VersionDetails: testVersionDetails(version)},
+		&apis.CStorVolume{ObjectMeta: testObjectMeta("cv", version), VersionDetails: testVersionDetails(version)},
+		&apis.CStorVolumeConfig{ObjectMeta: testObjectMeta("cvc", version), VersionDetails: testVersionDetails(version)},
+		&apis.CStorVolumeReplica{ObjectMeta: testObjectMeta("cvr", version), VersionDetails: testVersionDetails(version)},
+	)
+	patchers := map[string]getPatcher{
+		"cspc": NewCSPC(WithCSPCClient(client)),
+		"cspi": NewCSPI(WithCSPIClient(client)),
+		"cv":   NewCV(WithCVClient(client)),
+		"cvc":  NewCVC(WithCVCClient(client)),
+		"cvr":  NewCVR(WithCVRClient(client)),
+	}
+	for _, p := range patchers {
+		setPatchData(p, []byte(testPatch))
+	}
+	return patchers, client
+}
+
+func setPatchData(p getPatcher, data []byte) {
+	switch obj := p.(type) {
+	case *CSPC:
+		obj.Data = data
+	case *CSPI:
+		obj.Data = data
+	case *CV:
+		obj.Data = data
+	case *CVC:
+		obj.Data = data
+	case *CVR:
+		obj.Data = data
+	}
+}
+
+func patchActions(actions []k8stesting.Action) int {
+	count := 0
+	for _, action := range actions {
+		if action.GetVerb() == "patch" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCStorPatchers(t *testing.T) {
+	tests := map[string]struct {
+		version     string
+		from, to    string
+		wantPreErr  bool
+		wantPatched bool
+	}{
+		"resource at the from version is patched": {
+			version:     "2.12.0",
+			from:        "2.12.0",
+			to:          "3.0.0",
+			wantPatched: true,
+		},
+		"resource at the to version is not patched": {
+			version: "3.0.0",
+			from:    "2.12.0",
+			to:      "3.0.0",
+		},
+		"resource at a dev build of the to version is not patched": {
+			version: "3.0.0-RC1",
+			from:    "2.12.0",
+			to:      "3.0.0",
+		},
+		"resource at another version fails the prechecks": {
+			version:    "2.11.0",
+			from:       "2.12.0",
+			to:         "3.0.0",
+			wantPreErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			patchers, client := newCStorPatchers(tt.version)
+			for kind, p := range patchers {
+				if err := p.Get(kind, testNamespace); err != nil {
+					t.Fatalf("%s Get() error = %v", kind, err)
+				}
+				client.ClearActions()
+				err := p.PreChecks(tt.from, tt.to)
+				if (err != nil) != tt.wantPreErr {
+					t.Errorf("%s PreChecks() error = %v, wantErr %v", kind, err, tt.wantPreErr)
+				}
+				if err != nil {
+					continue
+				}
+				if err := p.Patch(tt.from, tt.to); err != nil {
+					t.Errorf("%s Patch() error = %v", kind, err)
+				}
+				if got := patchActions(client.Actions()) == 1; got != tt.wantPatched {
+					t.Errorf("%s Patch() patched = %v, want %v", kind, got, tt.wantPatched)
+				}
+			}
+		})
+	}
+}
+
+func TestCStorPatchersErrors(t *testing.T) {
+	patchers, client := newCStorPatchers("2.12.0")
+	for kind, p := range patchers {
+		if err := p.Get(kind+"-missing", testNamespace); err == nil {
+			t.Errorf("%s Get() of a missing object error = nil, want error", kind)
+		}
+		if err := p.PreChecks("2.12.0", "3.0.0"); err == nil {
+			t.Errorf("%s PreChecks() without an object error = nil, want error", kind)
+		}
+		if err := p.Get(kind, testNamespace); err != nil {
+			t.Fatalf("%s Get() error = %v", kind, err)
+		}
+	}
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errTestPatch
+	})
+	for kind, p := range patchers {
+		if err := p.Patch("2.12.0", "3.0.0"); err == nil {
+			t.Errorf("%s Patch() error = nil, want the patch error", kind)
+		}
+	}
+}
+
+func testDeployment(version string) *appsv1.Deployment {
+	replicas := int32(1)
+	return &appsv1.Deployment{
+		ObjectMeta: testObjectMeta("cspi-deploy", version),
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{
+			Replicas:          1,
+			UpdatedReplicas:   1,
+			AvailableReplicas: 1,
+		},
+	}
+}
+
+func testStatefulSet(version string, strategy appsv1.StatefulSetUpdateStrategyType) *appsv1.StatefulSet {
+	replicas := int32(1)
+	return &appsv1.StatefulSet{
+		ObjectMeta: testObjectMeta("jiva-sts", version),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:       &replicas,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: strategy},
+		},
+		Status: appsv1.StatefulSetStatus{
+			ObservedGeneration: 1,
+			ReadyReplicas:      1,
+		},
+	}
+}
+
+func TestDeployment(t *testing.T) {
+	client := fake.NewSimpleClientset(testDeployment("2.12.0"))
+	d := NewDeployment(WithDeploymentClient(client))
+	if err := d.PreChecks("2.12.0", "3.0.0"); err == nil {
+		t.Errorf("PreChecks() without an object error = nil, want error")
+	}
+	if err := d.Get("app=missing", testNamespace); err == nil {
+		t.Errorf("Get() with no deployment error = nil, want error")
+	}
+	if err := d.Get("openebs.io/version=2.12.0", testNamespace); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if err := d.PreChecks("2.11.0", "3.0.0"); err == nil {
+		t.Errorf("PreChecks() of another version error = nil, want error")
+	}
+	if err := d.PreChecks("2.12.0", "3.0.0"); err != nil {
+		t.Errorf("PreChecks() error = %v", err)
+	}
+	d.Data = []byte(testPatch)
+	if err := d.Patch("2.12.0", "3.0.0"); err != nil {
+		t.Errorf("Patch() error = %v", err)
+	}
+	deploy, err := client.AppsV1().Deployments(testNamespace).
+		Get(context.TODO(), "cspi-deploy", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deploy.Labels["openebs.io/version"] != "3.0.0" {
+		t.Errorf("Patch() version = %s, want 3.0.0", deploy.Labels["openebs.io/version"])
+	}
+	d.Object = deploy
+	client.ClearActions()
+	if err := d.Patch("2.12.0", "3.0.0"); err != nil {
+		t.Errorf("Patch() of a patched deployment error = %v", err)
+	}
+	if n := patchActions(client.Actions()); n != 0 {
+		t.Errorf("Patch() of a patched deployment made %d patches, want none", n)
+	}
+}
+
+func TestStatefulSet(t *testing.T) {
+	client := fake.NewSimpleClientset(testStatefulSet("2.12.0", appsv1.RollingUpdateStatefulSetStrategyType))
+	s := NewStatefulSet(WithStatefulSetClient(client))
+	if err := s.PreChecks("2.12.0", "3.0.0"); err == nil {
+		t.Errorf("PreChecks() without an object error = nil, want error")
+	}
+	if err := s.Get("app=missing", testNamespace); err == nil {
+		t.Errorf("Get() with no statefulset error = nil, want error")
+	}
+	if err := s.Get("openebs.io/version=2.12.0", testNamespace); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if err := s.PreChecks("2.11.0", "3.0.0"); err == nil {
+		t.Errorf("PreChecks() of another version error = nil, want error")
+	}
+	if err := s.PreChecks("2.12.0", "3.0.0"); err != nil {
+		t.Errorf("PreChecks() error = %v", err)
+	}
+	s.Data = []byte(testPatch)
+	if err := s.Patch("2.12.0", "3.0.0"); err != nil {
+		t.Errorf("Patch() error = %v", err)
+	}
+	if err := s.Patch("2.12.0", "2.12.0"); err != nil {
+		t.Errorf("Patch() of a statefulset at the to version error = %v", err)
+	}
+
+	client = fake.NewSimpleClientset(testStatefulSet("2.12.0", appsv1.OnDeleteStatefulSetStrategyType))
+	s = NewStatefulSet(WithStatefulSetClient(client))
+	s.Object = testStatefulSet("2.12.0", appsv1.OnDeleteStatefulSetStrategyType)
+	s.Data = []byte(testPatch)
+	if err := s.Patch("2.12.0", "3.0.0"); err == nil {
+		t.Errorf("Patch() of an on delete statefulset error = nil, want the rollout status error")
+	}
+}
+
+func TestService(t *testing.T) {
+	svc := &corev1.Service{ObjectMeta: testObjectMeta("cv-svc", "2.12.0")}
+	client := fake.NewSimpleClientset(svc)
+	s := NewService(WithKubeClient(client))
+	if err := s.Get("openebs.io/version=2.12.0", testNamespace); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if err := s.PreChecks("2.11.0", "3.0.0"); err == nil {
+		t.Errorf("PreChecks() of another version error = nil, want error")
+	}
+	if err := s.PreChecks("2.12.0", "3.0.0"); err != nil {
+		t.Errorf("PreChecks() error = %v", err)
+	}
+	s.Data = []byte(testPatch)
+	if err := s.Patch("2.12.0", "3.0.0"); err != nil {
+		t.Errorf("Patch() error = %v", err)
+	}
+	if err := s.Patch("2.12.0", "2.12.0"); err != nil {
+		t.Errorf("Patch() of a service at the to version error = %v", err)
+	}
+	client.PrependReactor("patch", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errTestPatch
+	})
+	if err := s.Patch("2.12.0", "3.0.0"); err == nil {
+		t.Errorf("Patch() error = nil, want the patch error")
+	}
+	s.Object = &corev1.Service{}
+	if err := s.PreChecks("2.12.0", "3.0.0"); err == nil {
+		t.Errorf("PreChecks() of a service without a name error = nil, want error")
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testing has the builders of the objects and fake clientsets
+// used by the tests of the upgraders, so that each test only sets the
+// fields it depends on.
+package testing
+
+import (
+	"fmt"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	openebsfake "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	openebsscheme "github.com/openebs/api/v3/pkg/client/clientset/versioned/scheme"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Namespace is the namespace of the objects built by this package
+const Namespace = "openebs"
+
+// NewTestVersionDetails returns the version details of a
+// resource reconciled to the given version
+func NewTestVersionDetails(version string) cstor.VersionDetails {
+	return cstor.VersionDetails{
+		Desired: version,
+		Status:  cstor.VersionStatus{Current: version},
+	}
+}
+
+// NewTestCSPC returns a cspc reconciled to the given version,
+// the version details are left empty if version is empty
+func NewTestCSPC(name, version string) *cstor.CStorPoolCluster {
+	cspc := &cstor.CStorPoolCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: Namespace},
+	}
+	if version != "" {
+		cspc.VersionDetails = NewTestVersionDetails(version)
+	}
+	return cspc
+}
+
+// NewTestCSPI returns a cspi of the given cspc reconciled to the given
+// version, the version details and label are left empty if version is empty
+func NewTestCSPI(name, cspcName, version string) *cstor.CStorPoolInstance {
+	cspi := &cstor.CStorPoolInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: Namespace,
+			Labels:    map[string]string{"openebs.io/cstor-pool-cluster": cspcName},
+		},
+	}
+	if version != "" {
+		cspi.Labels["openebs.io/version"] = version
+		cspi.VersionDetails = NewTestVersionDetails(version)
+	}
+	return cspi
+}
+
+// NewTestCV returns a cv of the given volume reconciled to the given version
+func NewTestCV(name, version string) *cstor.CStorVolume {
+	return &cstor.CStorVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: Namespace,
+			Labels:    map[string]string{"openebs.io/version": version},
+		},
+		VersionDetails: NewTestVersionDetails(version),
+	}
+}
+
+// NewTestCVC returns a bound cvc of the given volume
+// reconciled to the given version
+func NewTestCVC(name, version string) *cstor.CStorVolumeConfig {
+	return &cstor.CStorVolumeConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   Namespace,
+			Labels:      map[string]string{"openebs.io/version": version},
+			Annotations: map[string]string{},
+		},
+		VersionDetails: NewTestVersionDetails(version),
+		Status:         cstor.CStorVolumeConfigStatus{Phase: cstor.CStorVolumeConfigPhaseBound},
+	}
+}
+
+// NewTestCVR returns a cvr of the given volume on the given
+// cspi reconciled to the given version
+func NewTestCVR(name, volume, cspiName, version string) *cstor.CStorVolumeReplica {
+	return &cstor.CStorVolumeReplica{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: Namespace,
+			Labels: map[string]string{
+				"openebs.io/persistent-volume":      volume,
+				"cstorpoolinstance.openebs.io/name": cspiName,
+				"openebs.io/version":                version,
+			},
+		},
+		VersionDetails: NewTestVersionDetails(version),
+	}
+}
+
+// NewTestDeployment returns a rolled out deployment of the given version
+// with the given labels on it and its pods, and a container of each image
+func NewTestDeployment(name, version string, labels map[string]string, images ...string) *appsv1.Deployment {
+	replicas := int32(1)
+	podLabels := map[string]string{"openebs.io/version": version}
+	for k, v := range labels {
+		podLabels[k] = v
+	}
+	deployLabels := map[string]string{}
+	for k, v := range podLabels {
+		deployLabels[k] = v
+	}
+	containers := []corev1.Container{}
+	for i, image := range images {
+		containers = append(containers, corev1.Container{Name: fmt.Sprintf("container-%d", i), Image: image})
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: Namespace, Labels: deployLabels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec:       corev1.PodSpec{Containers: containers},
+			},
+		},
+		Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+}
+
+// NewTestClientsets returns the fake kubernetes and openebs clientsets
+// with the given objects, each object is added to the clientset
+// whose scheme it belongs to
+func NewTestClientsets(objs ...runtime.Object) (*fake.Clientset, *openebsfake.Clientset) {
+	kubeObjs := []runtime.Object{}
+	openebsObjs := []runtime.Object{}
+	for _, obj := range objs {
+		if _, _, err := openebsscheme.Scheme.ObjectKinds(obj); err == nil {
+			openebsObjs = append(openebsObjs, obj)
+			continue
+		}
+		kubeObjs = append(kubeObjs, obj)
+	}
+	return fake.NewSimpleClientset(kubeObjs...), openebsfake.NewSimpleClientset(openebsObjs...)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewTestClientsets(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: Namespace}}
+	kube, openebs := NewTestClientsets(
+		NewTestCSPC("cspc-a", "3.0.0"),
+		NewTestCSPI("cspc-a-1", "cspc-a", "3.0.0"),
+		pod,
+	)
+	_, err := kube.CoreV1().Pods(Namespace).Get(context.TODO(), "pod-1", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("failed to get the pod from the kube clientset: %v", err)
+	}
+	cspi, err := openebs.CstorV1().CStorPoolInstances(Namespace).
+		Get(context.TODO(), "cspc-a-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the cspi from the openebs clientset: %v", err)
+	}
+	if cspi.Labels["openebs.io/cstor-pool-cluster"] != "cspc-a" || cspi.VersionDetails.Status.Current != "3.0.0" {
+		t.Errorf("NewTestCSPI() = %+v", cspi)
+	}
+	_, err = openebs.CstorV1().CStorPoolClusters(Namespace).
+		Get(context.TODO(), "cspc-a", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("failed to get the cspc from the openebs clientset: %v", err)
+	}
+}
+
+func TestNewTestCSPI_noVersion(t *testing.T) {
+	cspi := NewTestCSPI("cspc-a-1", "cspc-a", "")
+	if _, ok := cspi.Labels["openebs.io/version"]; ok || cspi.VersionDetails.Desired != "" {
+		t.Errorf("NewTestCSPI() = %+v, want no version", cspi)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"time"
+
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultApprovalAnnotation is the annotation which approves the
+	// upgrade of a resource when set to the to version, if none is configured
+	DefaultApprovalAnnotation = "openebs.io/upgrade-approved"
+	// approvalLogInterval is how often the wait for the approval is logged
+	approvalLogInterval = time.Minute
+)
+
+// approvalAnnotation returns the annotation which approves the upgrade
+func (r *ResourcePatch) approvalAnnotation() string {
+	if r.ApprovalAnnotation != "" {
+		return r.ApprovalAnnotation
+	}
+	return DefaultApprovalAnnotation
+}
+
+// resourceAnnotations returns the annotations of the resource of the given
+// kind the approval is set on. For a cstor volume it is the cvc.
+func resourceAnnotations(kind string, r *ResourcePatch, client *Client) (map[string]string, error) {
+	switch kind {
+	case "cstorPoolCluster":
+		cspc := patch.NewCSPC(patch.WithCSPCClient(client.OpenebsClientset))
+		err := cspc.Get(r.Name, r.OpenebsNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return cspc.Object.Annotations, nil
+	case "cstorPoolInstance":
+		cspi := patch.NewCSPI(patch.WithCSPIClient(client.OpenebsClientset))
+		err := cspi.Get(r.Name, r.OpenebsNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return cspi.Object.Annotations, nil
+	case "cstorVolume":
+		cvc := patch.NewCVC(patch.WithCVCClient(client.OpenebsClientset))
+		err := cvc.Get(r.Name, r.OpenebsNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return cvc.Object.Annotations, nil
+	case "jivaVolume":
+		if client.Config == nil {
+			return nil, errors.Errorf("the jivavolume %s can only be read using the rest config", r.Name)
+		}
+		cl, err := newJivaClient(client.Config)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create runtime client")
+		}
+		jivaVolume := patch.NewJV(patch.WithJVClient(cl))
+		err = jivaVolume.Get(r.Name, r.OpenebsNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return jivaVolume.Object.Annotations, nil
+	}
+	return nil, newValidationError(errors.Errorf("approval is not supported for %s", kind))
+}
+
+// WaitForApproval runs the prechecks of the resource and then blocks until
+// the approval annotation is set to the to version on it, so that an operator
+// can review the prechecks before the resource is patched. The value ties the
+// approval to one upgrade, an approval left from an earlier upgrade of the
+// resource does not approve the later ones. A blocker in the
+// prechecks fails the upgrade without waiting, and a timeout error is
+// returned if the approval is not granted before the context is done.
+func WaitForApproval(ctx context.Context, kind string, r *ResourcePatch, client *Client) error {
+	report, err := PreFlight(kind, r, client)
+	if err != nil {
+		return err
+	}
+	for _, f := range report.Findings {
+		klog.Infof("precheck %s %s: %s %s", f.Severity, f.Code, f.Resource, f.Message)
+	}
+	err = report.Err()
+	if err != nil {
+		return err
+	}
+	key := r.approvalAnnotation()
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	var lastLog time.Time
+	for {
+		annotations, err := resourceAnnotations(kind, r, client)
+		if err != nil {
+			if errors.Is(err, ErrValidation) {
+				return err
+			}
+			return newAPIError(errors.Wrapf(err, "failed to get the approval of %s", r.Name))
+		}
+		value := annotations[key]
+		if value == r.To {
+			klog.Infof("%s %s: upgrade to %s approved by annotation %s", kind, r.Name, r.To, key)
+			return nil
+		}
+		if time.Since(lastLog) >= approvalLogInterval {
+			if value != "" {
+				klog.Infof("%s %s: ignoring the approval %s=%s, which is not for the upgrade to %s",
+					kind, r.Name, key, value, r.To)
+			}
+			klog.Infof("%s %s: waiting for approval, set the annotation %s=%s to start the upgrade",
+				kind, r.Name, key, r.To)
+			r.EmitProgress(PhaseProgress, kind, r.Name, 0, "waiting for approval", nil)
+			lastLog = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return newTimeoutError(
+				errors.Wrapf(ctx.Err(), "upgrade of %s %s was not approved", kind, r.Name),
+			)
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestWaitForApproval(t *testing.T) {
+	approved := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+	approved.Annotations = map[string]string{DefaultApprovalAnnotation: "3.0.0"}
+	custom := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+	custom.Annotations = map[string]string{"example.com/approved": "3.0.0"}
+	// left from the upgrade to 2.12.0
+	stale := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+	stale.Annotations = map[string]string{DefaultApprovalAnnotation: "2.12.0"}
+	unversioned := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+	unversioned.Annotations = map[string]string{DefaultApprovalAnnotation: "true"}
+	tests := []struct {
+		name       string
+		kind       string
+		annotation string
+		objects    []runtime.Object
+		wantErr    error
+	}{
+		{
+			name:    "approved",
+			kind:    "cstorPoolCluster",
+			objects: []runtime.Object{testOperatorPod("cspc-operator", "3.0.0"), approved},
+		},
+		{
+			name:       "approved by custom annotation",
+			kind:       "cstorPoolCluster",
+			annotation: "example.com/approved",
+			objects:    []runtime.Object{testOperatorPod("cspc-operator", "3.0.0"), custom},
+		},
+		{
+			name: "not approved",
+			kind: "cstorPoolCluster",
+			objects: []runtime.Object{
+				testOperatorPod("cspc-operator", "3.0.0"),
+				upgradetesting.NewTestCSPC("cspc-a", "2.12.0"),
+			},
+			wantErr: ErrTimeout,
+		},
+		{
+			name:    "stale approval",
+			kind:    "cstorPoolCluster",
+			objects: []runtime.Object{testOperatorPod("cspc-operator", "3.0.0"), stale},
+			wantErr: ErrTimeout,
+		},
+		{
+			name:    "approval without a version",
+			kind:    "cstorPoolCluster",
+			objects: []runtime.Object{testOperatorPod("cspc-operator", "3.0.0"), unversioned},
+			wantErr: ErrTimeout,
+		},
+		{
+			name:    "blocked by prechecks",
+			kind:    "cstorPoolCluster",
+			objects: []runtime.Object{testOperatorPod("cspc-operator", "2.12.0"), approved},
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "unsupported kind",
+			kind:    "rbac",
+			wantErr: ErrValidation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objects...)
+			r := NewResourcePatch(
+				WithName("cspc-a"),
+				WithOpenebsNamespace(upgradetesting.Namespace),
+				FromVersion("2.12.0"),
+				ToVersion("3.0.0"),
+				WithPollInterval(10*time.Millisecond),
+				WithRequireApproval(true),
+				WithApprovalAnnotation(tt.annotation),
+			)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := WaitForApproval(ctx, tt.kind, r, client)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("WaitForApproval() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("WaitForApproval() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResourceAnnotations(t *testing.T) {
+	approved := map[string]string{DefaultApprovalAnnotation: "3.0.0"}
+	cspc := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+	cspc.Annotations = approved
+	cspi := upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", "2.12.0")
+	cspi.Annotations = approved
+	cvc := upgradetesting.NewTestCVC("pvc-1", "2.12.0")
+	cvc.Annotations = approved
+	client := NewTestClient(cspc, cspi, cvc)
+	tests := []struct {
+		kind     string
+		name     string
+		wantErr  bool
+		wantKind error
+	}{
+		{kind: "cstorPoolCluster", name: "cspc-a"},
+		{kind: "cstorPoolInstance", name: "cspc-a-1"},
+		{kind: "cstorVolume", name: "pvc-1"},
+		// the jivavolumes need the rest config of a cluster
+		{kind: "jivaVolume", name: "pvc-2", wantErr: true},
+		{kind: "rbac", name: "openebs", wantErr: true, wantKind: ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			r := NewResourcePatch(WithName(tt.name), WithOpenebsNamespace(upgradetesting.Namespace))
+			got, err := resourceAnnotations(tt.kind, r, client)
+			if tt.wantErr {
+				if err == nil || tt.wantKind != nil && !errors.Is(err, tt.wantKind) {
+					t.Errorf("resourceAnnotations() error = %v, want kind %v", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resourceAnnotations() error = %v", err)
+			}
+			if got[DefaultApprovalAnnotation] != "3.0.0" {
+				t.Errorf("resourceAnnotations() = %v, want the approval", got)
+			}
+		})
+	}
+}
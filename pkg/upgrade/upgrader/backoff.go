@@ -0,0 +1,163 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// BackoffConstant, BackoffExponential and BackoffCappedExponential
+	// are the names of the built in backoff strategies
+	BackoffConstant          = "constant"
+	BackoffExponential       = "exponential"
+	BackoffCappedExponential = "capped-exponential"
+)
+
+// BackoffStrategy returns the delay before the next attempt of a poll or
+// a retry. The interval is the configured PollInterval, and the attempts
+// are counted from 1 for the delay after the first attempt.
+type BackoffStrategy interface {
+	Delay(interval time.Duration, attempt int) time.Duration
+}
+
+// ConstantBackoff waits for the interval before every attempt
+type ConstantBackoff struct{}
+
+// Delay returns the interval
+func (ConstantBackoff) Delay(interval time.Duration, attempt int) time.Duration {
+	return interval
+}
+
+func (ConstantBackoff) String() string {
+	return BackoffConstant
+}
+
+// maxExponentialDelay only caps the ExponentialBackoff to not overflow
+const maxExponentialDelay = time.Hour
+
+// ExponentialBackoff doubles the delay on every attempt, starting at the interval
+type ExponentialBackoff struct{}
+
+// Delay returns the interval doubled for every attempt after the first
+func (ExponentialBackoff) Delay(interval time.Duration, attempt int) time.Duration {
+	return exponentialDelay(interval, attempt, maxExponentialDelay)
+}
+
+func (ExponentialBackoff) String() string {
+	return BackoffExponential
+}
+
+// CappedExponentialBackoff doubles the delay on every attempt up to Max,
+// and randomly changes it by up to the Jitter fraction in either direction
+// so that the resources polled together are not all polled at once
+type CappedExponentialBackoff struct {
+	Max    time.Duration
+	Jitter float64
+	mutex  sync.Mutex
+	rand   *rand.Rand
+}
+
+// NewCappedExponentialBackoff returns a CappedExponentialBackoff
+// capped at max, with a jitter of the given fraction
+func NewCappedExponentialBackoff(max time.Duration, jitter float64) *CappedExponentialBackoff {
+	return &CappedExponentialBackoff{
+		Max:    max,
+		Jitter: jitter,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Delay returns the capped exponential delay with the jitter applied
+func (b *CappedExponentialBackoff) Delay(interval time.Duration, attempt int) time.Duration {
+	delay := exponentialDelay(interval, attempt, b.Max)
+	if b.Jitter <= 0 || b.rand == nil {
+		return delay
+	}
+	b.mutex.Lock()
+	factor := 1 + b.Jitter*(2*b.rand.Float64()-1)
+	b.mutex.Unlock()
+	return time.Duration(float64(delay) * factor)
+}
+
+func (b *CappedExponentialBackoff) String() string {
+	return BackoffCappedExponential
+}
+
+// exponentialDelay returns the interval doubled for every attempt
+// after the first, capped at max
+func exponentialDelay(interval time.Duration, attempt int, max time.Duration) time.Duration {
+	delay := interval
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// NewBackoffStrategy returns the built in backoff strategy of the given
+// name, or nil for an empty name to use the default of each wait. The
+// capped exponential strategy is capped at 5m with the jitter of the
+// requeues of the failed upgradetasks.
+func NewBackoffStrategy(name string) (BackoffStrategy, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case BackoffConstant:
+		return ConstantBackoff{}, nil
+	case BackoffExponential:
+		return ExponentialBackoff{}, nil
+	case BackoffCappedExponential:
+		return NewCappedExponentialBackoff(maxPollInterval, RequeueJitter), nil
+	}
+	return nil, newValidationError(errors.Errorf(
+		"invalid backoff strategy %q, expected one of constant, exponential or capped-exponential", name,
+	))
+}
+
+// backoff returns the configured backoff strategy,
+// by default the constant strategy
+func (r *ResourcePatch) backoff() BackoffStrategy {
+	if r.Backoff == nil {
+		return ConstantBackoff{}
+	}
+	return r.Backoff
+}
+
+// reconcileBackoff returns the configured backoff strategy of the polls
+// of the version reconcile, by default the interval is doubled up to
+// maxPollInterval to reduce the load when many resources are verified
+func (r *ResourcePatch) reconcileBackoff() BackoffStrategy {
+	if r.Backoff == nil {
+		return &CappedExponentialBackoff{Max: maxPollInterval}
+	}
+	return r.Backoff
+}
+
+// pollInterval returns the configured PollInterval or its default
+func (r *ResourcePatch) pollInterval() time.Duration {
+	if r.PollInterval <= 0 {
+		return defaultPollInterval
+	}
+	return r.PollInterval
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffStrategy_Delay(t *testing.T) {
+	interval := 10 * time.Second
+	tests := []struct {
+		name    string
+		backoff BackoffStrategy
+		attempt int
+		want    time.Duration
+	}{
+		{name: "constant", backoff: ConstantBackoff{}, attempt: 5, want: 10 * time.Second},
+		{name: "exponential first attempt", backoff: ExponentialBackoff{}, attempt: 1, want: 10 * time.Second},
+		{name: "exponential doubles", backoff: ExponentialBackoff{}, attempt: 3, want: 40 * time.Second},
+		{name: "exponential does not overflow", backoff: ExponentialBackoff{}, attempt: 100, want: time.Hour},
+		{name: "capped doubles", backoff: &CappedExponentialBackoff{Max: time.Minute}, attempt: 2, want: 20 * time.Second},
+		{name: "capped caps", backoff: &CappedExponentialBackoff{Max: time.Minute}, attempt: 4, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backoff.Delay(interval, tt.attempt); got != tt.want {
+				t.Errorf("Delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCappedExponentialBackoff_Jitter(t *testing.T) {
+	b := NewCappedExponentialBackoff(time.Minute, 0.2)
+	for attempt := 1; attempt <= 10; attempt++ {
+		want := exponentialDelay(10*time.Second, attempt, time.Minute)
+		got := b.Delay(10*time.Second, attempt)
+		if got < want*8/10 || got > want*12/10 {
+			t.Errorf("Delay(%d) = %v, want within 20%% of %v", attempt, got, want)
+		}
+	}
+}
+
+func TestResourcePatch_reconcileBackoff(t *testing.T) {
+	// the reconcile polls double up to maxPollInterval by default
+	r := &ResourcePatch{}
+	for attempt, want := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second} {
+		if got := r.reconcileBackoff().Delay(10*time.Second, attempt+1); got != want {
+			t.Errorf("default reconcile Delay(%d) = %v, want %v", attempt+1, got, want)
+		}
+	}
+	if got := r.reconcileBackoff().Delay(160*time.Second, 2); got != maxPollInterval {
+		t.Errorf("default reconcile Delay() = %v, want %v", got, maxPollInterval)
+	}
+	if got := r.backoff().Delay(10*time.Second, 3); got != 10*time.Second {
+		t.Errorf("default Delay() = %v, want the constant interval", got)
+	}
+	r.Backoff = ConstantBackoff{}
+	if got := r.reconcileBackoff().Delay(10*time.Second, 3); got != 10*time.Second {
+		t.Errorf("constant reconcile Delay() = %v, want the constant interval", got)
+	}
+}
+
+func TestNewBackoffStrategy(t *testing.T) {
+	for _, name := range []string{BackoffConstant, BackoffExponential, BackoffCappedExponential} {
+		b, err := NewBackoffStrategy(name)
+		if err != nil {
+			t.Fatalf("NewBackoffStrategy(%q) error = %v", name, err)
+		}
+		if s, ok := b.(interface{ String() string }); !ok || s.String() != name {
+			t.Errorf("NewBackoffStrategy(%q) = %v", name, b)
+		}
+	}
+	if b, err := NewBackoffStrategy(""); b != nil || err != nil {
+		t.Errorf("NewBackoffStrategy(\"\") = %v, %v, want nil", b, err)
+	}
+	if _, err := NewBackoffStrategy("linear"); err == nil {
+		t.Errorf("NewBackoffStrategy(\"linear\") error = nil, want a validation error")
+	}
+}
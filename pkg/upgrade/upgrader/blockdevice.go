@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"strings"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	ndm "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	openebsclientset "github.com/openebs/api/v3/pkg/client/clientset/versioned"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// CheckCSPIBlockDevices verifies that the blockdevices in the spec of the
+// cspi are still Active and Claimed by a blockdeviceclaim bound to them.
+// The problems of all the blockdevices are returned in a single error,
+// while a claim which is not yet Bound to its Claimed blockdevice is
+// only logged as a warning, as the claim status may lag behind.
+func CheckCSPIBlockDevices(cspi *cstor.CStorPoolInstance, client openebsclientset.Interface) error {
+	if cspi == nil {
+		return errors.Errorf("nil cspi object")
+	}
+	bdClient := client.OpenebsV1alpha1().BlockDevices(cspi.Namespace)
+	bdcClient := client.OpenebsV1alpha1().BlockDeviceClaims(cspi.Namespace)
+	problems := []string{}
+	for _, name := range raidGroupBlockDevices(cspi.Spec.DataRaidGroups, cspi.Spec.WriteCacheRaidGroups) {
+		bdObj, err := bdClient.Get(context.TODO(), name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			problems = append(problems, "blockdevice "+name+" not found")
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to get blockdevice %s of cspi %s", name, cspi.Name)
+		}
+		if bdObj.Status.State != ndm.BlockDeviceActive {
+			problems = append(problems, "blockdevice "+name+" is "+string(bdObj.Status.State))
+		}
+		if bdObj.Status.ClaimState != ndm.BlockDeviceClaimed || bdObj.Spec.ClaimRef == nil {
+			problems = append(problems, "blockdevice "+name+" is "+string(bdObj.Status.ClaimState))
+			continue
+		}
+		bdcObj, err := bdcClient.Get(context.TODO(), bdObj.Spec.ClaimRef.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			problems = append(problems,
+				"blockdeviceclaim "+bdObj.Spec.ClaimRef.Name+" of blockdevice "+name+" not found")
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to get blockdeviceclaim %s of cspi %s",
+				bdObj.Spec.ClaimRef.Name, cspi.Name)
+		}
+		if bdcObj.Spec.BlockDeviceName != name {
+			problems = append(problems, "blockdeviceclaim "+bdcObj.Name+
+				" of blockdevice "+name+" is for blockdevice "+bdcObj.Spec.BlockDeviceName)
+			continue
+		}
+		if bdcObj.Status.Phase != ndm.BlockDeviceClaimStatusDone {
+			klog.Warningf("cspi %s: blockdeviceclaim %s of blockdevice %s is %s, expected %s",
+				cspi.Name, bdcObj.Name, name, bdcObj.Status.Phase, ndm.BlockDeviceClaimStatusDone)
+		}
+	}
+	if len(problems) != 0 {
+		return errors.Errorf("cspi %s has blockdevices which are not in use by the pool: %s",
+			cspi.Name, strings.Join(problems, ", "))
+	}
+	return nil
+}
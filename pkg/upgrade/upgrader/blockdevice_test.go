@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"strings"
+	"testing"
+
+	ndm "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testBlockDevice(name, claim string, state ndm.BlockDeviceState) *ndm.BlockDevice {
+	bd := &ndm.BlockDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: upgradetesting.Namespace},
+		Status: ndm.DeviceStatus{
+			ClaimState: ndm.BlockDeviceUnclaimed,
+			State:      state,
+		},
+	}
+	if claim != "" {
+		bd.Spec.ClaimRef = &corev1.ObjectReference{Name: claim}
+		bd.Status.ClaimState = ndm.BlockDeviceClaimed
+	}
+	return bd
+}
+
+func testBlockDeviceClaim(name, bd string, phase ndm.DeviceClaimPhase) *ndm.BlockDeviceClaim {
+	return &ndm.BlockDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: upgradetesting.Namespace},
+		Spec:       ndm.DeviceClaimSpec{BlockDeviceName: bd},
+		Status:     ndm.DeviceClaimStatus{Phase: phase},
+	}
+}
+
+func TestCheckCSPIBlockDevices(t *testing.T) {
+	cspi := upgradetesting.NewTestCSPI("cspi-a", "cspc", "3.0.0")
+	cspi.Spec.DataRaidGroups = testRaidGroups("bd-1", "bd-2")
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		wantErr []string
+	}{
+		{
+			name: "blockdevices claimed",
+			objects: []runtime.Object{
+				testBlockDevice("bd-1", "bdc-1", ndm.BlockDeviceActive),
+				testBlockDevice("bd-2", "bdc-2", ndm.BlockDeviceActive),
+				testBlockDeviceClaim("bdc-1", "bd-1", ndm.BlockDeviceClaimStatusDone),
+				// a claim not yet bound is only a warning
+				testBlockDeviceClaim("bdc-2", "bd-2", ndm.BlockDeviceClaimStatusPending),
+			},
+		},
+		{
+			name: "blockdevice released and inactive",
+			objects: []runtime.Object{
+				testBlockDevice("bd-1", "bdc-1", ndm.BlockDeviceActive),
+				testBlockDevice("bd-2", "", "Inactive"),
+				testBlockDeviceClaim("bdc-1", "bd-1", ndm.BlockDeviceClaimStatusDone),
+			},
+			wantErr: []string{"blockdevice bd-2 is Inactive", "blockdevice bd-2 is Unclaimed"},
+		},
+		{
+			name: "blockdevice and claim missing",
+			objects: []runtime.Object{
+				testBlockDevice("bd-1", "bdc-1", ndm.BlockDeviceActive),
+			},
+			wantErr: []string{"blockdeviceclaim bdc-1 of blockdevice bd-1 not found", "blockdevice bd-2 not found"},
+		},
+		{
+			name: "claim of another blockdevice",
+			objects: []runtime.Object{
+				testBlockDevice("bd-1", "bdc-1", ndm.BlockDeviceActive),
+				testBlockDevice("bd-2", "bdc-1", ndm.BlockDeviceActive),
+				testBlockDeviceClaim("bdc-1", "bd-1", ndm.BlockDeviceClaimStatusDone),
+			},
+			wantErr: []string{"blockdeviceclaim bdc-1 of blockdevice bd-2 is for blockdevice bd-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objects...)
+			err := CheckCSPIBlockDevices(cspi, client.OpenebsClientset)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("CheckCSPIBlockDevices() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("CheckCSPIBlockDevices() error = nil, want %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("CheckCSPIBlockDevices() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
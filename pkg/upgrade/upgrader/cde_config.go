@@ -0,0 +1,316 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// CStorEngineConfigGVR is the resource of the cstorengineconfigs, which
+// have no generated clientset and are read using the dynamic clientset
+var CStorEngineConfigGVR = schema.GroupVersionResource{
+	Group:    "cstor.openebs.io",
+	Version:  "v1",
+	Resource: "cstorengineconfigs",
+}
+
+// CDEUserModifiedAnnotation lists the comma separated fields of a
+// cstorengineconfig, like spec.pool.memoryLimit, customized by the user,
+// which keep their value when the defaults of the version change
+const CDEUserModifiedAnnotation = "openebs.io/user-modified"
+
+// cdeConfigDefaultsYAML is the default of the fields of the
+// cstorengineconfigs of the openebs versions. The defaults of an openebs
+// version are those of all the entries not newer than its major and
+// minor version, the newer entries overriding the older ones.
+const cdeConfigDefaultsYAML = `
+defaults:
+- openebs: "2.0"
+  fields:
+    spec.pool.memoryLimit: "2Gi"
+    spec.pool.cpuRequest: "250m"
+    spec.io.queueDepth: 32
+- openebs: "3.0"
+  fields:
+    spec.pool.memoryLimit: "4Gi"
+    spec.io.queueDepth: 64
+- openebs: "3.3"
+  fields:
+    spec.pool.cpuRequest: "500m"
+    spec.io.queueDepth: 128
+`
+
+// cdeConfigDefaults is an entry of the defaults table
+type cdeConfigDefaults struct {
+	OpenEBS string                 `json:"openebs"`
+	Fields  map[string]interface{} `json:"fields"`
+}
+
+// loadCDEConfigDefaults parses the defaults table
+func loadCDEConfigDefaults(data string) ([]cdeConfigDefaults, error) {
+	doc := struct {
+		Defaults []cdeConfigDefaults `json:"defaults"`
+	}{}
+	err := yaml.Unmarshal([]byte(data), &doc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the cstorengineconfig defaults table")
+	}
+	for _, entry := range doc.Defaults {
+		for field, value := range entry.Fields {
+			// the whole numbers are read as float64, but stored as int64
+			if f, ok := value.(float64); ok && f == math.Trunc(f) {
+				entry.Fields[field] = int64(f)
+			}
+		}
+	}
+	return doc.Defaults, nil
+}
+
+// cdeConfigDefaultsOf returns the default of the fields of the
+// cstorengineconfigs of the openebs version
+func cdeConfigDefaultsOf(table []cdeConfigDefaults, openebsVersion string) (map[string]interface{}, error) {
+	v, err := parseMinorVersion(openebsVersion)
+	if err != nil {
+		return nil, err
+	}
+	v[2] = 0
+	type entry struct {
+		version [3]int
+		fields  map[string]interface{}
+	}
+	entries := []entry{}
+	for _, e := range table {
+		ev, err := parseMinorVersion(e.OpenEBS)
+		if err != nil {
+			return nil, err
+		}
+		if compareMinorVersions(ev, v) <= 0 {
+			entries = append(entries, entry{ev, e.Fields})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareMinorVersions(entries[i].version, entries[j].version) < 0
+	})
+	defaults := map[string]interface{}{}
+	for _, e := range entries {
+		for field, value := range e.fields {
+			defaults[field] = value
+		}
+	}
+	return defaults, nil
+}
+
+// userModifiedFields returns the fields listed in the
+// CDEUserModifiedAnnotation of the cstorengineconfig
+func userModifiedFields(obj *unstructured.Unstructured) map[string]bool {
+	fields := map[string]bool{}
+	for _, f := range strings.Split(obj.GetAnnotations()[CDEUserModifiedAnnotation], ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields[f] = true
+		}
+	}
+	return fields
+}
+
+// CDEConfigPatch upgrades the cstorengineconfigs of the cStor data
+// engine, which set the resources and the io queue depth of the pools.
+// The fields whose default changed between the from and the to version
+// are set to the new default, unless customized by the user.
+type CDEConfigPatch struct {
+	*ResourcePatch
+	*Client
+	// defaults is the defaults table, the cdeConfigDefaultsYAML if nil
+	defaults []cdeConfigDefaults
+}
+
+// CDEConfigPatchOptions ...
+type CDEConfigPatchOptions func(*CDEConfigPatch)
+
+// WithCDEConfigResorcePatch ...
+func WithCDEConfigResorcePatch(r *ResourcePatch) CDEConfigPatchOptions {
+	return func(obj *CDEConfigPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithCDEConfigClient ...
+func WithCDEConfigClient(c *Client) CDEConfigPatchOptions {
+	return func(obj *CDEConfigPatch) {
+		obj.Client = c
+	}
+}
+
+// NewCDEConfigPatch ...
+func NewCDEConfigPatch(opts ...CDEConfigPatchOptions) *CDEConfigPatch {
+	obj := &CDEConfigPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// Upgrade upgrades the cstorengineconfig of the given name, or all the
+// cstorengineconfigs in the openebs namespace if no name is given.
+// Nothing is done if the cstorengineconfigs crd is not installed.
+func (obj *CDEConfigPatch) Upgrade() error {
+	if obj.DynamicClientset == nil {
+		return newValidationError(errors.Errorf("no dynamic clientset to read the cstorengineconfigs"))
+	}
+	table := obj.defaults
+	if table == nil {
+		var err error
+		table, err = loadCDEConfigDefaults(cdeConfigDefaultsYAML)
+		if err != nil {
+			return err
+		}
+	}
+	newDefaults, err := cdeConfigDefaultsOf(table, obj.To)
+	if err != nil {
+		return newValidationError(err)
+	}
+	client := obj.DynamicClientset.Resource(CStorEngineConfigGVR).Namespace(obj.OpenebsNamespace)
+	configs := []unstructured.Unstructured{}
+	if obj.Name != "" {
+		config, err := client.Get(context.TODO(), obj.Name, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to get cstorengineconfig %s", obj.Name))
+		}
+		configs = append(configs, *config)
+	} else {
+		list, err := client.List(context.TODO(), metav1.ListOptions{})
+		if k8serrors.IsNotFound(err) {
+			klog.Infof("No cstorengineconfigs to upgrade, the crd is not installed")
+			return nil
+		}
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to list cstorengineconfigs"))
+		}
+		configs = list.Items
+	}
+	failed := []string{}
+	for i := range configs {
+		err = obj.upgradeConfig(&configs[i], table, newDefaults)
+		if err != nil {
+			klog.Errorf("cstorengineconfig %s: %v", configs[i].GetName(), err)
+			failed = append(failed, fmt.Sprintf("%s: %v", configs[i].GetName(), err))
+		}
+	}
+	if len(failed) != 0 {
+		return newAPIError(errors.Errorf("failed to upgrade %d out of %d cstorengineconfigs: %s",
+			len(failed), len(configs), strings.Join(failed, "; ")))
+	}
+	return nil
+}
+
+// upgradeConfig patches the fields of the cstorengineconfig whose default
+// changed from the from version to newDefaults, along with its version
+// label. The from version is read from the version label if not set.
+// The fields customized by the user are kept and only logged.
+func (obj *CDEConfigPatch) upgradeConfig(config *unstructured.Unstructured,
+	table []cdeConfigDefaults, newDefaults map[string]interface{}) error {
+	current := config.GetLabels()["openebs.io/version"]
+	if current == obj.To {
+		klog.Infof("cstorengineconfig %s is already at %s", config.GetName(), obj.To)
+		return nil
+	}
+	if obj.VerifyOnly {
+		return errors.Errorf("cstorengineconfig %s is at version %s, expected %s",
+			config.GetName(), current, obj.To)
+	}
+	from := obj.From
+	if from == "" {
+		from = current
+	}
+	if from == "" {
+		return errors.Errorf("no from version set and no version label on the cstorengineconfig")
+	}
+	oldDefaults, err := cdeConfigDefaultsOf(table, from)
+	if err != nil {
+		return err
+	}
+	userModified := userModifiedFields(config)
+	newConfig := config.DeepCopy()
+	fields := []string{}
+	for field := range newDefaults {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		value := newDefaults[field]
+		if old, ok := oldDefaults[field]; ok && fmt.Sprint(old) == fmt.Sprint(value) {
+			continue
+		}
+		path := strings.Split(field, ".")
+		currentValue, found, _ := unstructured.NestedFieldNoCopy(config.Object, path...)
+		if userModified[field] {
+			klog.Infof("cstorengineconfig %s: keeping %s=%v customized by the user, the default of %s is %v",
+				config.GetName(), field, currentValue, obj.To, value)
+			continue
+		}
+		if found && fmt.Sprint(currentValue) == fmt.Sprint(value) {
+			continue
+		}
+		err = unstructured.SetNestedField(newConfig.Object, value, path...)
+		if err != nil {
+			return errors.Wrapf(err, "failed to set %s", field)
+		}
+		klog.Infof("cstorengineconfig %s: setting %s to the default %v of %s",
+			config.GetName(), field, value, obj.To)
+	}
+	labels := newConfig.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels["openebs.io/version"] = obj.To
+	newConfig.SetLabels(labels)
+	data, err := cdeConfigPatchData(newConfig)
+	if err != nil {
+		return err
+	}
+	_, err = obj.DynamicClientset.Resource(CStorEngineConfigGVR).Namespace(config.GetNamespace()).
+		Patch(context.TODO(), config.GetName(), k8stypes.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to patch cstorengineconfig")
+	}
+	return nil
+}
+
+// cdeConfigPatchData returns the merge patch setting the spec
+// and the labels of the cstorengineconfig to those of newConfig
+func cdeConfigPatchData(newConfig *unstructured.Unstructured) ([]byte, error) {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": newConfig.GetLabels()},
+	}
+	if spec, ok := newConfig.Object["spec"]; ok {
+		patch["spec"] = spec
+	}
+	return json.Marshal(patch)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newTestCDEConfig(name, version, userModified string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cstor.openebs.io/v1",
+		"kind":       "CStorEngineConfig",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": upgradetesting.Namespace,
+			"labels":    map[string]interface{}{"openebs.io/version": version},
+		},
+		"spec": spec,
+	}}
+	if userModified != "" {
+		obj.SetAnnotations(map[string]string{CDEUserModifiedAnnotation: userModified})
+	}
+	return obj
+}
+
+func newTestCDEConfigClient(objs ...runtime.Object) *Client {
+	client := NewTestClient()
+	client.DynamicClientset = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{CStorEngineConfigGVR: "CStorEngineConfigList"},
+		objs...,
+	)
+	return client
+}
+
+func getTestCDEConfig(t *testing.T, client *Client, name string) *unstructured.Unstructured {
+	t.Helper()
+	obj, err := client.DynamicClientset.Resource(CStorEngineConfigGVR).Namespace(upgradetesting.Namespace).
+		Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestCDEConfigDefaultsOf(t *testing.T) {
+	table, err := loadCDEConfigDefaults(cdeConfigDefaultsYAML)
+	if err != nil {
+		t.Fatalf("loadCDEConfigDefaults() error = %v", err)
+	}
+	tests := map[string]map[string]interface{}{
+		"2.12.0": {"spec.pool.memoryLimit": "2Gi", "spec.pool.cpuRequest": "250m", "spec.io.queueDepth": int64(32)},
+		"3.0.0":  {"spec.pool.memoryLimit": "4Gi", "spec.pool.cpuRequest": "250m", "spec.io.queueDepth": int64(64)},
+		"3.4.0":  {"spec.pool.memoryLimit": "4Gi", "spec.pool.cpuRequest": "500m", "spec.io.queueDepth": int64(128)},
+	}
+	for version, want := range tests {
+		got, err := cdeConfigDefaultsOf(table, version)
+		if err != nil {
+			t.Fatalf("cdeConfigDefaultsOf(%s) error = %v", version, err)
+		}
+		for field, value := range want {
+			if got[field] != value {
+				t.Errorf("cdeConfigDefaultsOf(%s)[%s] = %#v, want %#v", version, field, got[field], value)
+			}
+		}
+	}
+	if _, err := cdeConfigDefaultsOf(table, "master"); err == nil {
+		t.Errorf("cdeConfigDefaultsOf() of an invalid version did not fail")
+	}
+}
+
+func TestCDEConfigPatch_Upgrade(t *testing.T) {
+	defaults := func() map[string]interface{} {
+		return map[string]interface{}{
+			"pool": map[string]interface{}{"memoryLimit": "2Gi", "cpuRequest": "250m"},
+			"io":   map[string]interface{}{"queueDepth": int64(32)},
+		}
+	}
+	customized := defaults()
+	customized["pool"].(map[string]interface{})["memoryLimit"] = "8Gi"
+	client := newTestCDEConfigClient(
+		newTestCDEConfig("defaults", "2.12.0", "", defaults()),
+		newTestCDEConfig("customized", "2.12.0", "spec.pool.memoryLimit", customized),
+		newTestCDEConfig("upgraded", "3.0.0", "", defaults()),
+	)
+	obj := NewCDEConfigPatch(
+		WithCDEConfigResorcePatch(&ResourcePatch{To: "3.0.0", OpenebsNamespace: upgradetesting.Namespace}),
+		WithCDEConfigClient(client),
+	)
+	if err := obj.Upgrade(); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	tests := []struct {
+		name        string
+		version     string
+		memoryLimit string
+		queueDepth  int64
+	}{
+		{name: "defaults", version: "3.0.0", memoryLimit: "4Gi", queueDepth: 64},
+		// the memory limit customized by the user is preserved
+		{name: "customized", version: "3.0.0", memoryLimit: "8Gi", queueDepth: 64},
+		// the config already at the to version is not changed
+		{name: "upgraded", version: "3.0.0", memoryLimit: "2Gi", queueDepth: 32},
+	}
+	for _, tt := range tests {
+		got := getTestCDEConfig(t, client, tt.name)
+		if got.GetLabels()["openebs.io/version"] != tt.version {
+			t.Errorf("%s version = %s, want %s", tt.name, got.GetLabels()["openebs.io/version"], tt.version)
+		}
+		memoryLimit, _, _ := unstructured.NestedString(got.Object, "spec", "pool", "memoryLimit")
+		if memoryLimit != tt.memoryLimit {
+			t.Errorf("%s memoryLimit = %s, want %s", tt.name, memoryLimit, tt.memoryLimit)
+		}
+		queueDepth, _, _ := unstructured.NestedFieldNoCopy(got.Object, "spec", "io", "queueDepth")
+		if queueDepth != tt.queueDepth {
+			t.Errorf("%s queueDepth = %#v, want %d", tt.name, queueDepth, tt.queueDepth)
+		}
+		cpuRequest, _, _ := unstructured.NestedString(got.Object, "spec", "pool", "cpuRequest")
+		if cpuRequest != "250m" {
+			t.Errorf("%s cpuRequest = %s, want the unchanged default 250m", tt.name, cpuRequest)
+		}
+	}
+	if got := getTestCDEConfig(t, client, "customized"); got.GetAnnotations()[CDEUserModifiedAnnotation] == "" {
+		t.Errorf("Upgrade() removed the %s annotation", CDEUserModifiedAnnotation)
+	}
+}
+
+func TestCDEConfigPatch_UpgradeVerifyOnly(t *testing.T) {
+	client := newTestCDEConfigClient(newTestCDEConfig("defaults", "2.12.0", "", map[string]interface{}{}))
+	obj := NewCDEConfigPatch(
+		WithCDEConfigResorcePatch(&ResourcePatch{
+			To: "3.0.0", OpenebsNamespace: upgradetesting.Namespace, VerifyOnly: true,
+		}),
+		WithCDEConfigClient(client),
+	)
+	err := obj.Upgrade()
+	if !errors.Is(err, ErrAPI) {
+		t.Errorf("Upgrade() error = %v, want an api error", err)
+	}
+	if got := getTestCDEConfig(t, client, "defaults"); got.GetLabels()["openebs.io/version"] != "2.12.0" {
+		t.Errorf("Upgrade() patched the cstorengineconfig with VerifyOnly")
+	}
+}
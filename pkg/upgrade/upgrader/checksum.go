@@ -0,0 +1,190 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+)
+
+// upgradeAnnotationPrefix is the prefix of the annotations set on the
+// resources by the upgrade itself, like the approval or patch attempt
+const upgradeAnnotationPrefix = "openebs.io/upgrade-"
+
+// ComputeSpecChecksum returns the sha256 checksum of the resource without
+// the fields changed by an upgrade, that is its status, version details,
+// version labels, images and the metadata maintained by the api server.
+// The checksums of a resource before and after its upgrade differ only if
+// the upgrade changed some other field.
+func ComputeSpecChecksum(obj runtime.Object) (string, error) {
+	fields, err := versionlessFields(obj)
+	if err != nil {
+		return "", err
+	}
+	// the keys of the maps are marshalled in sorted order
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal resource fields")
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// versionlessFields returns the fields of the resource as unmarshalled
+// from its json, without the fields changed by an upgrade
+func versionlessFields(obj runtime.Object) (map[string]interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal resource")
+	}
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal resource")
+	}
+	delete(fields, "status")
+	delete(fields, "versionDetails")
+	if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+		for _, key := range []string{
+			"resourceVersion", "generation", "managedFields",
+			"uid", "creationTimestamp", "selfLink",
+		} {
+			delete(metadata, key)
+		}
+	}
+	stripVersionFields(fields)
+	return fields, nil
+}
+
+// stripVersionFields removes the version labels, upgrade annotations and
+// images from the nested fields, like those of a pod template
+func stripVersionFields(fields map[string]interface{}) {
+	for key, value := range fields {
+		switch key {
+		case "labels":
+			if labels, ok := value.(map[string]interface{}); ok {
+				delete(labels, "openebs.io/version")
+				if len(labels) == 0 {
+					delete(fields, key)
+				}
+			}
+		case "annotations":
+			if annotations, ok := value.(map[string]interface{}); ok {
+				for k := range annotations {
+					if strings.HasPrefix(k, upgradeAnnotationPrefix) {
+						delete(annotations, k)
+					}
+				}
+				if len(annotations) == 0 {
+					delete(fields, key)
+				}
+			}
+		case "image":
+			delete(fields, key)
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			stripVersionFields(v)
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					stripVersionFields(m)
+				}
+			}
+		}
+	}
+}
+
+// specDiff returns the paths of the fields that differ between the
+// resource before and after its upgrade, other than the version fields
+func specDiff(before, after runtime.Object) ([]string, error) {
+	oldFields, err := versionlessFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := versionlessFields(after)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	diffFields("", oldFields, newFields, &paths)
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func diffFields(path string, oldValue, newValue interface{}, paths *[]string) {
+	oldMap, oldOk := oldValue.(map[string]interface{})
+	newMap, newOk := newValue.(map[string]interface{})
+	// a missing map is diffed as an empty one, to list its added fields
+	if oldValue == nil && newOk {
+		oldMap, oldOk = map[string]interface{}{}, true
+	}
+	if newValue == nil && oldOk {
+		newMap, newOk = map[string]interface{}{}, true
+	}
+	if !oldOk || !newOk {
+		if !reflect.DeepEqual(oldValue, newValue) {
+			*paths = append(*paths, fmt.Sprintf("%s: %v -> %v", path, oldValue, newValue))
+		}
+		return
+	}
+	for key, value := range oldMap {
+		diffFields(path+"."+key, value, newMap[key], paths)
+	}
+	for key, value := range newMap {
+		if _, ok := oldMap[key]; !ok {
+			diffFields(path+"."+key, nil, value, paths)
+		}
+	}
+}
+
+// verifySpecChecksum logs a warning with the changed fields if the
+// resource was changed by its upgrade in fields other than the version
+// fields. A failure to compute the checksums is only logged, as the
+// resource has already been upgraded.
+func verifySpecChecksum(kind, name string, before, after runtime.Object) {
+	oldSum, err := ComputeSpecChecksum(before)
+	if err != nil {
+		klog.Warningf("%s %s: failed to compute the checksum before upgrade: %v", kind, name, err)
+		return
+	}
+	newSum, err := ComputeSpecChecksum(after)
+	if err != nil {
+		klog.Warningf("%s %s: failed to compute the checksum after upgrade: %v", kind, name, err)
+		return
+	}
+	if oldSum == newSum {
+		return
+	}
+	paths, err := specDiff(before, after)
+	if err != nil {
+		klog.Warningf("%s %s: failed to diff the resource: %v", kind, name, err)
+		return
+	}
+	klog.Warningf("%s %s: fields other than the version were changed by the upgrade:\n%s",
+		kind, name, strings.Join(paths, "\n"))
+}
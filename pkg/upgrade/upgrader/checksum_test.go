@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"reflect"
+	"testing"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestComputeSpecChecksum(t *testing.T) {
+	deploy := func(image, version string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "cspc-stripe-b9f6",
+				Labels: map[string]string{"openebs.io/version": version, "app": "cstor-pool"},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{"openebs.io/version": version},
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "cstor-pool", Image: image}},
+					},
+				},
+			},
+		}
+	}
+	cspc := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+	upgradedCSPC := cspc.DeepCopy()
+	upgradedCSPC.VersionDetails.Desired = "3.0.0"
+	upgradedCSPC.VersionDetails.Status.Current = "3.0.0"
+	upgradedCSPC.ResourceVersion = "2"
+	upgradedCSPC.Annotations = map[string]string{patchAttemptAnnotation: "1"}
+	changedCSPC := upgradedCSPC.DeepCopy()
+	changedCSPC.Annotations["openebs.io/other"] = "value"
+	changedDeploy := deploy("openebs/cstor-pool:3.0.0", "3.0.0")
+	changedDeploy.Spec.Replicas = new(int32)
+	tests := []struct {
+		name          string
+		before, after runtime.Object
+		wantDiff      []string
+	}{
+		{
+			name:     "cspc version fields",
+			before:   cspc,
+			after:    upgradedCSPC,
+			wantDiff: []string{},
+		},
+		{
+			name:     "cspc annotation",
+			before:   cspc,
+			after:    changedCSPC,
+			wantDiff: []string{".metadata.annotations.openebs.io/other: <nil> -> value"},
+		},
+		{
+			name:     "deployment images and version labels",
+			before:   deploy("openebs/cstor-pool:2.12.0", "2.12.0"),
+			after:    deploy("openebs/cstor-pool:3.0.0", "3.0.0"),
+			wantDiff: []string{},
+		},
+		{
+			name:     "deployment replicas",
+			before:   deploy("openebs/cstor-pool:2.12.0", "2.12.0"),
+			after:    changedDeploy,
+			wantDiff: []string{".spec.replicas: <nil> -> 0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldSum, err := ComputeSpecChecksum(tt.before)
+			if err != nil {
+				t.Fatalf("ComputeSpecChecksum() error = %v", err)
+			}
+			newSum, err := ComputeSpecChecksum(tt.after)
+			if err != nil {
+				t.Fatalf("ComputeSpecChecksum() error = %v", err)
+			}
+			if (oldSum == newSum) != (len(tt.wantDiff) == 0) {
+				t.Errorf("ComputeSpecChecksum() = %s and %s, want changed %v", oldSum, newSum, len(tt.wantDiff) != 0)
+			}
+			diff, err := specDiff(tt.before, tt.after)
+			if err != nil {
+				t.Fatalf("specDiff() error = %v", err)
+			}
+			if !reflect.DeepEqual(diff, tt.wantDiff) {
+				t.Errorf("specDiff() = %v, want %v", diff, tt.wantDiff)
+			}
+		})
+	}
+}
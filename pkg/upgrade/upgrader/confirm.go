@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// upgradeEstimates are the rough times taken to upgrade one
+// resource of each kind, used to estimate the upgrade duration
+var upgradeEstimates = map[string]time.Duration{
+	"cstorPoolCluster":    10 * time.Minute,
+	"cstorPoolInstance":   5 * time.Minute,
+	"cstorVolume":         2 * time.Minute,
+	"jivaVolume":          2 * time.Minute,
+	"snapshotClass":       time.Minute,
+	"storageClass":        time.Minute,
+	"rbac":                time.Minute,
+	"podSecurity":         time.Minute,
+	"cstorEngineConfig":   time.Minute,
+	"cstorVolumeClaimAPI": time.Minute,
+}
+
+// ConfirmPrompt is the prompt the answer to which is read by Confirm
+const ConfirmPrompt = "Type 'yes' to proceed: "
+
+// UpgradeSummary describes the resources that are going
+// to be upgraded, printed before asking for the confirmation
+type UpgradeSummary struct {
+	Kind  string
+	Names []string
+	From  string
+	To    string
+}
+
+// Estimate returns the rough duration of the upgrade
+func (s *UpgradeSummary) Estimate() time.Duration {
+	estimate, ok := upgradeEstimates[s.Kind]
+	if !ok {
+		estimate = 5 * time.Minute
+	}
+	count := len(s.Names)
+	if count == 0 {
+		// the kinds like rbac are upgraded without names
+		count = 1
+	}
+	return estimate * time.Duration(count)
+}
+
+// Write writes the summary to w
+func (s *UpgradeSummary) Write(w io.Writer) error {
+	names := strings.Join(s.Names, ", ")
+	if names == "" {
+		names = "-"
+	}
+	_, err := fmt.Fprintf(w,
+		"Upgrading %d %s from %s to %s\nResources: %s\nEstimated duration: %s\n",
+		len(s.Names), s.Kind, s.From, s.To, names, s.Estimate())
+	return err
+}
+
+// Confirm writes the prompt to out and waits for the answer to be read from
+// in. It returns nil only if the answer is yes. A timeout error is returned if
+// no answer is read within the timeout, and an error if the answer is anything
+// else or in is closed.
+func Confirm(in io.Reader, out io.Writer, timeout time.Duration) error {
+	_, err := fmt.Fprint(out, ConfirmPrompt)
+	if err != nil {
+		return err
+	}
+	answers := make(chan string, 1)
+	go func() {
+		// a line without a newline before the end is still an answer
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		answers <- strings.TrimSpace(line)
+	}()
+	select {
+	case answer := <-answers:
+		if strings.ToLower(answer) != "yes" {
+			return errors.Errorf("upgrade cancelled: answer %q is not yes", answer)
+		}
+		return nil
+	case <-time.After(timeout):
+		fmt.Fprintln(out)
+		return newTimeoutError(
+			errors.Errorf("upgrade cancelled: no confirmation in %s", timeout),
+		)
+	}
+}
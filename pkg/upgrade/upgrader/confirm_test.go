@@ -0,0 +1,82 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestConfirm(t *testing.T) {
+	blocked, _ := io.Pipe()
+	tests := []struct {
+		name        string
+		in          io.Reader
+		wantErr     bool
+		wantTimeout bool
+	}{
+		{name: "yes", in: strings.NewReader("yes\n")},
+		{name: "yes without newline", in: strings.NewReader(" YES")},
+		{name: "no", in: strings.NewReader("no\n"), wantErr: true},
+		{name: "closed stdin", in: strings.NewReader(""), wantErr: true},
+		{name: "no answer", in: blocked, wantErr: true, wantTimeout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Confirm(tt.in, &out, 50*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Confirm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrTimeout) != tt.wantTimeout {
+				t.Errorf("Confirm() error = %v, want timeout %v", err, tt.wantTimeout)
+			}
+			if !strings.HasPrefix(out.String(), ConfirmPrompt) {
+				t.Errorf("Confirm() wrote %q, want the prompt", out.String())
+			}
+		})
+	}
+}
+
+func TestUpgradeSummary(t *testing.T) {
+	s := &UpgradeSummary{
+		Kind:  "cstorVolume",
+		Names: []string{"pvc-1", "pvc-2"},
+		From:  "2.12.0",
+		To:    "3.0.0",
+	}
+	if got := s.Estimate(); got != 4*time.Minute {
+		t.Errorf("Estimate() = %s, want 4m0s", got)
+	}
+	var out bytes.Buffer
+	if err := s.Write(&out); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	want := "Upgrading 2 cstorVolume from 2.12.0 to 3.0.0\nResources: pvc-1, pvc-2\nEstimated duration: 4m0s\n"
+	if out.String() != want {
+		t.Errorf("Write() = %q, want %q", out.String(), want)
+	}
+	rbac := &UpgradeSummary{Kind: "rbac"}
+	if got := rbac.Estimate(); got != time.Minute {
+		t.Errorf("Estimate() = %s, want 1m0s", got)
+	}
+}
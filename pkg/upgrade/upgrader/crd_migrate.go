@@ -0,0 +1,252 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// crdGVR is the resource of the crds, whose status has the versions
+// the objects of a crd are stored at in etcd
+var crdGVR = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// CRDConversionFunc converts an object of a crd from the old version of
+// its schema to the new one
+type CRDConversionFunc func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error)
+
+// crdConversion identifies the conversion of the objects of a kind
+// between two versions of its schema
+type crdConversion struct {
+	kind, from, to string
+}
+
+// crdConversions are the conversions known to the CRDMigrator
+var crdConversions = map[crdConversion]CRDConversionFunc{
+	{kind: "CStorPoolInstance", from: "v1alpha1", to: "v1"}: convertCSPIV1alpha1ToV1,
+}
+
+// RegisterCRDConversion registers the conversion of the objects of the
+// kind from the from version of its schema to the to version, for the
+// programs migrating their own crds. It is not safe to call
+// concurrently with a migration.
+func RegisterCRDConversion(kind, from, to string, fn CRDConversionFunc) {
+	crdConversions[crdConversion{kind: kind, from: from, to: to}] = fn
+}
+
+// CRDMigrator migrates the objects of a crd to a new version of its
+// schema. The objects are read at the old version, converted and written
+// at the new version, and the old version is then removed from the
+// stored versions of the crd, as is done by the storage version migrator.
+type CRDMigrator struct {
+	*ResourcePatch
+	*Client
+	// Group, Resource and Kind are of the crd being migrated
+	Group, Resource, Kind string
+}
+
+// CRDMigratorOptions ...
+type CRDMigratorOptions func(*CRDMigrator)
+
+// WithCRDMigratorResorcePatch ...
+func WithCRDMigratorResorcePatch(r *ResourcePatch) CRDMigratorOptions {
+	return func(obj *CRDMigrator) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithCRDMigratorClient ...
+func WithCRDMigratorClient(c *Client) CRDMigratorOptions {
+	return func(obj *CRDMigrator) {
+		obj.Client = c
+	}
+}
+
+// WithCRDMigratorResource ...
+func WithCRDMigratorResource(group, resource, kind string) CRDMigratorOptions {
+	return func(obj *CRDMigrator) {
+		obj.Group = group
+		obj.Resource = resource
+		obj.Kind = kind
+	}
+}
+
+// NewCRDMigrator ...
+func NewCRDMigrator(opts ...CRDMigratorOptions) *CRDMigrator {
+	obj := &CRDMigrator{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+func (obj *CRDMigrator) gvr(version string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: obj.Group, Version: version, Resource: obj.Resource}
+}
+
+// crdName is the name of the crd of the migrated resource
+func (obj *CRDMigrator) crdName() string {
+	return obj.Resource + "." + obj.Group
+}
+
+// Upgrade migrates the objects in the openebs namespace from the
+// FromAPIVersion to the ToAPIVersion. The crd must already store its
+// objects at the new version. The objects already migrated are written
+// again, so a failed migration can be rerun.
+func (obj *CRDMigrator) Upgrade() error {
+	from, to := obj.FromAPIVersion, obj.ToAPIVersion
+	if from == "" || to == "" || from == to {
+		return newValidationError(errors.Errorf(
+			"the api versions of %s to migrate between must be set and differ, got %q and %q",
+			obj.crdName(), from, to))
+	}
+	convert, ok := crdConversions[crdConversion{kind: obj.Kind, from: from, to: to}]
+	if !ok {
+		return newValidationError(errors.Errorf("no conversion of %s from %s to %s", obj.Kind, from, to))
+	}
+	if obj.DynamicClientset == nil {
+		return newValidationError(errors.Errorf("no dynamic clientset to migrate %s", obj.crdName()))
+	}
+	crd, err := obj.DynamicClientset.Resource(crdGVR).Get(context.TODO(), obj.crdName(), metav1.GetOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to get crd %s", obj.crdName()))
+	}
+	storage, err := crdStorageVersion(crd)
+	if err != nil {
+		return newValidationError(err)
+	}
+	if storage != to {
+		return newValidationError(errors.Errorf(
+			"crd %s stores the objects at %s, upgrade the crd to store them at %s before the migration",
+			obj.crdName(), storage, to))
+	}
+	objs, err := obj.listObjects(from)
+	if err != nil {
+		return err
+	}
+	for i := range objs {
+		err = obj.migrateObject(&objs[i], convert, to)
+		if err != nil {
+			return err
+		}
+	}
+	if obj.DryRun {
+		klog.Infof("%d %s would be migrated from %s to %s", len(objs), obj.Resource, from, to)
+		return nil
+	}
+	return obj.updateStoredVersions(crd, to)
+}
+
+// listObjects lists the objects at the old version. Once the old version
+// is no longer served there is nothing left to convert, as the objects
+// were migrated before it was removed.
+func (obj *CRDMigrator) listObjects(version string) ([]unstructured.Unstructured, error) {
+	list, err := obj.DynamicClientset.Resource(obj.gvr(version)).Namespace(obj.OpenebsNamespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if k8serrors.IsNotFound(err) {
+		klog.Infof("%s %s is not served, no objects to migrate", obj.crdName(), version)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list %s at %s", obj.crdName(), version))
+	}
+	return list.Items, nil
+}
+
+// migrateObject writes the converted object at the new version, updating
+// it if the object is served at both versions and creating it otherwise
+func (obj *CRDMigrator) migrateObject(old *unstructured.Unstructured,
+	convert CRDConversionFunc, to string) error {
+	name := old.GetName()
+	converted, err := convert(old.DeepCopy())
+	if err != nil {
+		return newValidationError(errors.Wrapf(err, "failed to convert %s %s to %s", obj.Kind, name, to))
+	}
+	converted.SetAPIVersion(obj.Group + "/" + to)
+	converted.SetKind(obj.Kind)
+	if obj.DryRun {
+		klog.Infof("%s %s would be migrated from %s to %s", obj.Kind, name, old.GetAPIVersion(), to)
+		return nil
+	}
+	client := obj.DynamicClientset.Resource(obj.gvr(to)).Namespace(old.GetNamespace())
+	current, err := client.Get(context.TODO(), name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		converted.SetResourceVersion("")
+		converted.SetUID("")
+		_, err = client.Create(context.TODO(), converted, metav1.CreateOptions{})
+	case err == nil:
+		converted.SetResourceVersion(current.GetResourceVersion())
+		_, err = client.Update(context.TODO(), converted, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to write %s %s at %s", obj.Kind, name, to))
+	}
+	klog.Infof("%s %s: migrated from %s to %s", obj.Kind, name, old.GetAPIVersion(), to)
+	return nil
+}
+
+// updateStoredVersions sets the stored versions of the crd to only the
+// new version, once all the objects are written at it
+func (obj *CRDMigrator) updateStoredVersions(crd *unstructured.Unstructured, to string) error {
+	stored, _, err := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	if err != nil {
+		return newValidationError(errors.Wrapf(err, "failed to read stored versions of crd %s", obj.crdName()))
+	}
+	if len(stored) == 1 && stored[0] == to {
+		return nil
+	}
+	err = unstructured.SetNestedStringSlice(crd.Object, []string{to}, "status", "storedVersions")
+	if err != nil {
+		return newValidationError(errors.Wrapf(err, "failed to set stored versions of crd %s", obj.crdName()))
+	}
+	_, err = obj.DynamicClientset.Resource(crdGVR).UpdateStatus(context.TODO(), crd, metav1.UpdateOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to update stored versions of crd %s", obj.crdName()))
+	}
+	klog.Infof("crd %s: stored versions %v -> [%s]", obj.crdName(), stored, to)
+	return nil
+}
+
+// crdStorageVersion returns the version the crd stores its objects at
+func crdStorageVersion(crd *unstructured.Unstructured) (string, error) {
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read versions of crd %s", crd.GetName())
+	}
+	for _, v := range versions {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if storage, _ := m["storage"].(bool); storage {
+			name, _ := m["name"].(string)
+			return name, nil
+		}
+	}
+	return "", errors.Errorf("crd %s has no storage version", crd.GetName())
+}
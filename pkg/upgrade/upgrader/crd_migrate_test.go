@@ -0,0 +1,253 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the crd conversions")
+
+// TestConvertCSPIV1alpha1ToV1 converts the cspis in testdata/crd_migration
+// and compares them with their golden files, which are written again
+// when the tests are run with -update
+func TestConvertCSPIV1alpha1ToV1(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "crd_migration", "*.input.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no cspis found in testdata/crd_migration")
+	}
+	for _, input := range inputs {
+		golden := strings.TrimSuffix(input, ".input.yaml") + ".golden.yaml"
+		t.Run(filepath.Base(input), func(t *testing.T) {
+			obj := readUnstructured(t, input)
+			converted, err := convertCSPIV1alpha1ToV1(obj)
+			if err != nil {
+				t.Fatalf("convertCSPIV1alpha1ToV1() error = %v", err)
+			}
+			got, err := yaml.Marshal(converted.Object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *updateGolden {
+				err = ioutil.WriteFile(golden, got, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file, run the tests with -update to write it: %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("convertCSPIV1alpha1ToV1() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestConvertCSPIV1alpha1ToV1Errors(t *testing.T) {
+	bd := func(name string) interface{} {
+		return map[string]interface{}{"blockDeviceName": name}
+	}
+	tests := []struct {
+		name       string
+		raidGroups []interface{}
+	}{
+		{
+			name: "spare group",
+			raidGroups: []interface{}{
+				map[string]interface{}{"blockDevices": []interface{}{bd("bd-1")}},
+				map[string]interface{}{"isSpare": true, "blockDevices": []interface{}{bd("bd-2")}},
+			},
+		},
+		{
+			name: "read cache group",
+			raidGroups: []interface{}{
+				map[string]interface{}{"blockDevices": []interface{}{bd("bd-1")}},
+				map[string]interface{}{"isReadCache": true, "blockDevices": []interface{}{bd("bd-2")}},
+			},
+		},
+		{
+			name: "mixed data raid types",
+			raidGroups: []interface{}{
+				map[string]interface{}{"type": "mirror", "blockDevices": []interface{}{bd("bd-1"), bd("bd-2")}},
+				map[string]interface{}{"type": "stripe", "blockDevices": []interface{}{bd("bd-3")}},
+			},
+		},
+		{
+			name: "only write cache",
+			raidGroups: []interface{}{
+				map[string]interface{}{"isWriteCache": true, "blockDevices": []interface{}{bd("bd-1")}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := newV1alpha1CSPI("cspi-1", tt.raidGroups)
+			_, err := convertCSPIV1alpha1ToV1(obj)
+			if err == nil {
+				t.Errorf("convertCSPIV1alpha1ToV1() expected error")
+			}
+		})
+	}
+}
+
+func TestCRDMigrator(t *testing.T) {
+	stripe := []interface{}{
+		map[string]interface{}{"blockDevices": []interface{}{
+			map[string]interface{}{"blockDeviceName": "bd-1"},
+		}},
+	}
+	tests := []struct {
+		name        string
+		from, to    string
+		storage     string
+		dryRun      bool
+		wantErr     bool
+		wantStored  []string
+		wantWritten bool
+	}{
+		{name: "migrated", from: "v1alpha1", to: "v1", storage: "v1",
+			wantStored: []string{"v1"}, wantWritten: true},
+		{name: "dry run", from: "v1alpha1", to: "v1", storage: "v1", dryRun: true,
+			wantStored: []string{"v1alpha1", "v1"}},
+		{name: "crd not upgraded", from: "v1alpha1", to: "v1", storage: "v1alpha1", wantErr: true,
+			wantStored: []string{"v1alpha1", "v1"}},
+		{name: "no conversion", from: "v1beta1", to: "v1", storage: "v1", wantErr: true,
+			wantStored: []string{"v1alpha1", "v1"}},
+		{name: "same versions", from: "v1", to: "v1", storage: "v1", wantErr: true,
+			wantStored: []string{"v1alpha1", "v1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldGVR := schema.GroupVersionResource{Group: "cstor.openebs.io", Version: "v1alpha1", Resource: "cstorpoolinstances"}
+			dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{oldGVR: "CStorPoolInstanceList"},
+				newTestCSPICRD(tt.storage),
+				newV1alpha1CSPI("cspi-1", stripe),
+				newV1alpha1CSPI("cspi-2", stripe),
+			)
+			obj := NewCRDMigrator(
+				WithCRDMigratorResorcePatch(&ResourcePatch{
+					OpenebsNamespace: "openebs",
+					FromAPIVersion:   tt.from,
+					ToAPIVersion:     tt.to,
+					DryRun:           tt.dryRun,
+				}),
+				WithCRDMigratorClient(&Client{DynamicClientset: dynamic}),
+				WithCRDMigratorResource("cstor.openebs.io", "cstorpoolinstances", "CStorPoolInstance"),
+			)
+			err := obj.Upgrade()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Upgrade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			crd, err := dynamic.Resource(crdGVR).Get(context.TODO(), obj.crdName(), metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+			if strings.Join(stored, ",") != strings.Join(tt.wantStored, ",") {
+				t.Errorf("Upgrade() stored versions = %v, want %v", stored, tt.wantStored)
+			}
+			for _, name := range []string{"cspi-1", "cspi-2"} {
+				got, err := dynamic.Resource(obj.gvr("v1")).Namespace("openebs").
+					Get(context.TODO(), name, metav1.GetOptions{})
+				if (err == nil) != tt.wantWritten {
+					t.Fatalf("Upgrade() cspi %s written = %v, want %v", name, err == nil, tt.wantWritten)
+				}
+				if err != nil {
+					continue
+				}
+				groups, _, _ := unstructured.NestedSlice(got.Object, "spec", "dataRaidGroups")
+				if got.GetAPIVersion() != "cstor.openebs.io/v1" || len(groups) != 1 {
+					t.Errorf("Upgrade() cspi %s = %v, want a v1 cspi with a data raid group", name, got.Object)
+				}
+			}
+		})
+	}
+}
+
+func readUnstructured(t *testing.T, path string) *unstructured.Unstructured {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := &unstructured.Unstructured{}
+	err = yaml.Unmarshal(data, &obj.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func newV1alpha1CSPI(name string, raidGroups []interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cstor.openebs.io/v1alpha1",
+		"kind":       "CStorPoolInstance",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "openebs",
+		},
+		"spec": map[string]interface{}{
+			"hostName": "node-1",
+			"poolConfig": map[string]interface{}{
+				"defaultRaidGroupType": "stripe",
+			},
+			"raidGroup": raidGroups,
+		},
+	}}
+}
+
+func newTestCSPICRD(storage string) *unstructured.Unstructured {
+	versions := []interface{}{}
+	for _, v := range []string{"v1alpha1", "v1"} {
+		versions = append(versions, map[string]interface{}{
+			"name":    v,
+			"served":  true,
+			"storage": v == storage,
+		})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata": map[string]interface{}{
+			"name": "cstorpoolinstances.cstor.openebs.io",
+		},
+		"spec": map[string]interface{}{
+			"group":    "cstor.openebs.io",
+			"versions": versions,
+		},
+		"status": map[string]interface{}{
+			"storedVersions": []interface{}{"v1alpha1", "v1"},
+		},
+	}}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// expansionTimeout is the time to wait for the cspc-operator to
+// complete a pending expansion of the pools of a cspc
+const expansionTimeout = 10 * time.Minute
+
+// CheckPendingExpansion returns true if a pool of the cspc has blockdevices
+// in its spec which are not yet added to its cspi, the pending blockdevices
+// are logged. Errors to list the cspis are logged and treated as no
+// pending expansion, as the upgrade fails later on them anyway.
+func (obj *CSPCPatch) CheckPendingExpansion(cspc *cstor.CStorPoolCluster) bool {
+	pending, err := obj.pendingExpansion(cspc)
+	if err != nil {
+		klog.Warningf("cspc %s: failed to check for pending expansion: %v", cspc.Name, err)
+		return false
+	}
+	if len(pending) == 0 {
+		return false
+	}
+	klog.Infof("cspc %s: expansion pending for blockdevices %s",
+		cspc.Name, strings.Join(pending, ", "))
+	return true
+}
+
+// pendingExpansion returns the blockdevices in the pool spec of the cspc
+// which are not in the spec of the cspi on the same node. The pools
+// without a cspi are not provisioned yet, which is not an expansion.
+func (obj *CSPCPatch) pendingExpansion(cspc *cstor.CStorPoolCluster) ([]string, error) {
+	cspiList, err := obj.OpenebsClientset.CstorV1().CStorPoolInstances(cspc.Namespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "openebs.io/cstor-pool-cluster=" + cspc.Name,
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list cspis of cspc %s", cspc.Name)
+	}
+	pending := []string{}
+	for _, pool := range cspc.Spec.Pools {
+		var cspiObj *cstor.CStorPoolInstance
+		for i := range cspiList.Items {
+			if reflect.DeepEqual(cspiList.Items[i].Spec.NodeSelector, pool.NodeSelector) {
+				cspiObj = &cspiList.Items[i]
+				break
+			}
+		}
+		if cspiObj == nil {
+			continue
+		}
+		added := map[string]bool{}
+		for _, bd := range raidGroupBlockDevices(cspiObj.Spec.DataRaidGroups, cspiObj.Spec.WriteCacheRaidGroups) {
+			added[bd] = true
+		}
+		for _, bd := range raidGroupBlockDevices(pool.DataRaidGroups, pool.WriteCacheRaidGroups) {
+			if !added[bd] {
+				pending = append(pending, cspiObj.Name+"/"+bd)
+			}
+		}
+	}
+	return pending, nil
+}
+
+// raidGroupBlockDevices returns the names of the blockdevices of the raid groups
+func raidGroupBlockDevices(groups ...[]cstor.RaidGroup) []string {
+	names := []string{}
+	for _, group := range groups {
+		for _, rg := range group {
+			for _, bd := range rg.CStorPoolInstanceBlockDevices {
+				names = append(names, bd.BlockDeviceName)
+			}
+		}
+	}
+	return names
+}
+
+// handlePendingExpansion fails the upgrade if the cspc has a pending
+// expansion, or with CompletePendingExpansion waits for the cspc-operator
+// to add the pending blockdevices to the cspis before upgrading them
+func (obj *CSPCPatch) handlePendingExpansion() error {
+	cspc := obj.CSPC.Object
+	pending, err := obj.pendingExpansion(cspc)
+	if err != nil {
+		return newAPIError(err)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if !obj.CompletePendingExpansion {
+		return newValidationError(errors.Errorf(
+			"cspc %s has a pending expansion of blockdevices %s, "+
+				"wait for the expansion to complete or use --complete-pending-expansion",
+			obj.Name, strings.Join(pending, ", "),
+		))
+	}
+	interval := obj.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	log := &waitLogger{}
+	deadline := time.Now().Add(expansionTimeout)
+	for len(pending) != 0 {
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf(
+				"timed out waiting for the expansion of cspc %s, blockdevices %s are still pending",
+				obj.Name, strings.Join(pending, ", "),
+			))
+		}
+		log.Infof("cspc %s: waiting for the expansion of blockdevices %s to complete",
+			obj.Name, strings.Join(pending, ", "))
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
+		pending, err = obj.pendingExpansion(cspc)
+		if err != nil {
+			return newAPIError(err)
+		}
+	}
+	klog.Infof("cspc %s: pending expansion completed", obj.Name)
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testRaidGroups(bds ...string) []cstor.RaidGroup {
+	rg := cstor.RaidGroup{}
+	for _, bd := range bds {
+		rg.CStorPoolInstanceBlockDevices = append(rg.CStorPoolInstanceBlockDevices,
+			cstor.CStorPoolInstanceBlockDevice{BlockDeviceName: bd})
+	}
+	return []cstor.RaidGroup{rg}
+}
+
+func testExpansionCSPC(poolBDs map[string][]string) *cstor.CStorPoolCluster {
+	cspc := upgradetesting.NewTestCSPC("cspc-stripe", "2.12.0")
+	for _, node := range []string{"node-1", "node-2"} {
+		if bds, ok := poolBDs[node]; ok {
+			cspc.Spec.Pools = append(cspc.Spec.Pools, cstor.PoolSpec{
+				NodeSelector:   map[string]string{"kubernetes.io/hostname": node},
+				DataRaidGroups: testRaidGroups(bds...),
+			})
+		}
+	}
+	return cspc
+}
+
+func testExpansionCSPI(name, node string, bds ...string) *cstor.CStorPoolInstance {
+	cspi := upgradetesting.NewTestCSPI(name, "cspc-stripe", "2.12.0")
+	cspi.Spec.NodeSelector = map[string]string{"kubernetes.io/hostname": node}
+	cspi.Spec.DataRaidGroups = testRaidGroups(bds...)
+	return cspi
+}
+
+func TestCSPCPatch_pendingExpansion(t *testing.T) {
+	tests := []struct {
+		name  string
+		cspc  *cstor.CStorPoolCluster
+		cspis []*cstor.CStorPoolInstance
+		want  []string
+	}{
+		{
+			name: "no pending expansion",
+			cspc: testExpansionCSPC(map[string][]string{
+				"node-1": {"bd-1"},
+				"node-2": {"bd-2"},
+			}),
+			cspis: []*cstor.CStorPoolInstance{
+				testExpansionCSPI("cspc-stripe-a", "node-1", "bd-1"),
+				testExpansionCSPI("cspc-stripe-b", "node-2", "bd-2"),
+			},
+			want: []string{},
+		},
+		{
+			name: "blockdevice added to a pool",
+			cspc: testExpansionCSPC(map[string][]string{
+				"node-1": {"bd-1", "bd-3"},
+				"node-2": {"bd-2"},
+			}),
+			cspis: []*cstor.CStorPoolInstance{
+				testExpansionCSPI("cspc-stripe-a", "node-1", "bd-1"),
+				testExpansionCSPI("cspc-stripe-b", "node-2", "bd-2"),
+			},
+			want: []string{"cspc-stripe-a/bd-3"},
+		},
+		{
+			name: "pool not provisioned yet",
+			cspc: testExpansionCSPC(map[string][]string{
+				"node-1": {"bd-1"},
+				"node-2": {"bd-2"},
+			}),
+			cspis: []*cstor.CStorPoolInstance{
+				testExpansionCSPI("cspc-stripe-a", "node-1", "bd-1"),
+			},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := []runtime.Object{tt.cspc}
+			for _, cspi := range tt.cspis {
+				objs = append(objs, cspi)
+			}
+			client := NewTestClient(objs...)
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(WithName("cspc-stripe"))),
+				WithCSPCClient(client),
+			)
+			got, err := obj.pendingExpansion(tt.cspc)
+			if err != nil {
+				t.Fatalf("pendingExpansion() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pendingExpansion() = %v, want %v", got, tt.want)
+			}
+			if obj.CheckPendingExpansion(tt.cspc) != (len(tt.want) != 0) {
+				t.Errorf("CheckPendingExpansion() = %v, want %v", len(tt.want) == 0, len(tt.want) != 0)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_handlePendingExpansion(t *testing.T) {
+	cspc := testExpansionCSPC(map[string][]string{"node-1": {"bd-1", "bd-3"}})
+	cspi := testExpansionCSPI("cspc-stripe-a", "node-1", "bd-1")
+
+	client := NewTestClient(cspc, cspi)
+	obj := NewCSPCPatch(
+		WithCSPCResorcePatch(NewResourcePatch(WithName("cspc-stripe"))),
+		WithCSPCClient(client),
+	)
+	obj.CSPC = &patch.CSPC{Object: cspc}
+	err := obj.handlePendingExpansion()
+	if err == nil || !strings.Contains(err.Error(), "cspc-stripe-a/bd-3") {
+		t.Fatalf("handlePendingExpansion() error = %v, want the pending blockdevice", err)
+	}
+
+	obj.CompletePendingExpansion = true
+	obj.PollInterval = time.Millisecond
+	expanded := cspi.DeepCopy()
+	expanded.Spec.DataRaidGroups = testRaidGroups("bd-1", "bd-3")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.OpenebsClientset.CstorV1().CStorPoolInstances(expanded.Namespace).
+			Update(context.TODO(), expanded, metav1.UpdateOptions{})
+	}()
+	err = obj.handlePendingExpansion()
+	if err != nil {
+		t.Errorf("handlePendingExpansion() error = %v, want the expansion to complete", err)
+	}
+}
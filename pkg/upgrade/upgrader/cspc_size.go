@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// checkLargeUpgrade returns a validation error if the cspc has more cspis
+// than the LargeUpgradeThreshold, as upgrading it restarts all of its pools,
+// unless the upgrade is confirmed by ConfirmLargeUpgrade or by the approval
+// annotation set to the to version on the cspc. No cspi count is checked
+// if the LargeUpgradeThreshold is not set or only a TargetCSPI is upgraded.
+func (obj *CSPCPatch) checkLargeUpgrade() error {
+	// a single TargetCSPI restarts a single pool
+	if obj.LargeUpgradeThreshold <= 0 || obj.TargetCSPI != "" {
+		return nil
+	}
+	cspiList, err := obj.OpenebsClientset.CstorV1().CStorPoolInstances(obj.Namespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "openebs.io/cstor-pool-cluster=" + obj.Name,
+		})
+	if err != nil {
+		return errors.Wrapf(err, "failed to list cspis of cspc %s", obj.Name)
+	}
+	count := len(cspiList.Items)
+	if count <= obj.LargeUpgradeThreshold {
+		return nil
+	}
+	key := obj.approvalAnnotation()
+	switch {
+	case obj.ConfirmLargeUpgrade:
+		klog.Warningf("cspc %s has %d cspis, more than %d, upgrading it as confirmed by --confirm-large-upgrade",
+			obj.Name, count, obj.LargeUpgradeThreshold)
+		return nil
+	case obj.CSPC.Object.Annotations[key] == obj.To:
+		klog.Warningf("cspc %s has %d cspis, more than %d, upgrading it as approved by annotation %s",
+			obj.Name, count, obj.LargeUpgradeThreshold, key)
+		return nil
+	}
+	return errors.Errorf(
+		"cspc %s has %d cspis, more than the %d which can be upgraded without confirmation: "+
+			"the upgrade restarts the pools of all of them, pass --confirm-large-upgrade or "+
+			"set the annotation %s=%s on the cspc to upgrade it",
+		obj.Name, count, obj.LargeUpgradeThreshold, key, obj.To,
+	)
+}
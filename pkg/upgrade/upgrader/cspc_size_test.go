@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+)
+
+func TestCSPCPatch_checkLargeUpgrade(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		confirm   bool
+		approval  string
+		wantErr   bool
+	}{
+		{name: "no threshold"},
+		{name: "at the threshold", threshold: 3},
+		{name: "above the threshold", threshold: 2, wantErr: true},
+		{name: "confirmed", threshold: 2, confirm: true},
+		{name: "approved", threshold: 2, approval: "3.0.0"},
+		{name: "approved for an other version", threshold: 2, approval: "2.12.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cspc := upgradetesting.NewTestCSPC("cspc-stripe", "2.12.0")
+			if tt.approval != "" {
+				cspc.Annotations = map[string]string{DefaultApprovalAnnotation: tt.approval}
+			}
+			client := NewTestClient(
+				cspc,
+				upgradetesting.NewTestCSPI("cspc-stripe-a", "cspc-stripe", "2.12.0"),
+				upgradetesting.NewTestCSPI("cspc-stripe-b", "cspc-stripe", "2.12.0"),
+				upgradetesting.NewTestCSPI("cspc-stripe-c", "cspc-stripe", "2.12.0"),
+				upgradetesting.NewTestCSPI("cspc-other-a", "cspc-other", "2.12.0"),
+			)
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(
+					WithName("cspc-stripe"),
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					ToVersion("3.0.0"),
+					WithLargeUpgradeThreshold(tt.threshold),
+					WithConfirmLargeUpgrade(tt.confirm),
+				)),
+				WithCSPCClient(client),
+			)
+			obj.Namespace = upgradetesting.Namespace
+			obj.CSPC = &patch.CSPC{Object: cspc}
+			err := obj.checkLargeUpgrade()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkLargeUpgrade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "--confirm-large-upgrade") {
+				t.Errorf("checkLargeUpgrade() error = %v, want how to confirm the upgrade", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/json"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// cspcUpgradeStateAnnotation is the annotation of the upgradetask of a
+// cspc with the state of its upgrade, as the status of an upgradetask
+// has no field for it
+const cspcUpgradeStateAnnotation = "openebs.io/upgrade-state"
+
+// CSPCUpgradePhase is a phase of the upgrade of a cspc
+type CSPCUpgradePhase string
+
+// The phases of the upgrade of a cspc, in the order they are gone through
+const (
+	CSPCPhasePreUpgrade      CSPCUpgradePhase = "PreUpgrade"
+	CSPCPhaseUpgradingCSPIs  CSPCUpgradePhase = "UpgradingCSPIs"
+	CSPCPhaseUpgradingParent CSPCUpgradePhase = "UpgradingParent"
+	CSPCPhaseVerifying       CSPCUpgradePhase = "Verifying"
+	CSPCPhaseDone            CSPCUpgradePhase = "Done"
+)
+
+var cspcPhaseOrder = map[CSPCUpgradePhase]int{
+	CSPCPhasePreUpgrade:      0,
+	CSPCPhaseUpgradingCSPIs:  1,
+	CSPCPhaseUpgradingParent: 2,
+	CSPCPhaseVerifying:       3,
+	CSPCPhaseDone:            4,
+}
+
+// CSPCUpgradeState is the state of the upgrade of a cspc, saved in its
+// upgradetask as the upgrade advances so that a restarted upgrade
+// resumes from where it stopped
+type CSPCUpgradeState struct {
+	Phase CSPCUpgradePhase `json:"phase"`
+	// To is the version of the upgrade, the state of an
+	// upgrade to another version is not resumed
+	To string `json:"to"`
+	// CompletedCSPIs are the cspis upgraded so far
+	CompletedCSPIs []string `json:"completedCSPIs,omitempty"`
+	// Current is the cspi being upgraded
+	Current string `json:"current,omitempty"`
+}
+
+// cspcStateMachine advances the state of the upgrade of a cspc, saving
+// it to the upgradetask of the cspc if there is one
+type cspcStateMachine struct {
+	state     CSPCUpgradeState
+	utask     *v1Alpha1API.UpgradeTask
+	namespace string
+	client    *Client
+}
+
+// loadCSPCState reads the state of the upgrade from the upgradetask. The
+// upgrade starts over if there is no state, if it is of an upgrade to
+// another version or if the upgrade was done.
+func loadCSPCState(utask *v1Alpha1API.UpgradeTask, to, namespace string, client *Client) *cspcStateMachine {
+	m := &cspcStateMachine{
+		state:     CSPCUpgradeState{Phase: CSPCPhasePreUpgrade, To: to},
+		utask:     utask,
+		namespace: namespace,
+		client:    client,
+	}
+	if utask == nil || utask.Annotations[cspcUpgradeStateAnnotation] == "" {
+		return m
+	}
+	saved := CSPCUpgradeState{}
+	err := json.Unmarshal([]byte(utask.Annotations[cspcUpgradeStateAnnotation]), &saved)
+	if err != nil {
+		klog.Warningf("upgradetask %s: ignoring the invalid upgrade state: %v", utask.Name, err)
+		return m
+	}
+	if _, ok := cspcPhaseOrder[saved.Phase]; !ok || saved.To != to || saved.Phase == CSPCPhaseDone {
+		return m
+	}
+	klog.Infof("upgradetask %s: resuming the upgrade at phase %s with %d cspis upgraded",
+		utask.Name, saved.Phase, len(saved.CompletedCSPIs))
+	if saved.Current != "" {
+		klog.Infof("upgradetask %s: cspi %s was being upgraded, upgrading it again", utask.Name, saved.Current)
+	}
+	m.state = saved
+	return m
+}
+
+// resumedAfter returns true if the upgrade was resumed after the phase,
+// in which case the phase is not run again
+func (m *cspcStateMachine) resumedAfter(phase CSPCUpgradePhase) bool {
+	return cspcPhaseOrder[m.state.Phase] > cspcPhaseOrder[phase]
+}
+
+// advance moves the upgrade to the phase, it never moves back
+func (m *cspcStateMachine) advance(phase CSPCUpgradePhase) error {
+	if cspcPhaseOrder[phase] < cspcPhaseOrder[m.state.Phase] {
+		return nil
+	}
+	m.state.Phase = phase
+	m.state.Current = ""
+	return m.save()
+}
+
+// isCSPICompleted returns true if the cspi was upgraded before a restart
+func (m *cspcStateMachine) isCSPICompleted(name string) bool {
+	for _, completed := range m.state.CompletedCSPIs {
+		if completed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// startCSPI sets the cspi being upgraded
+func (m *cspcStateMachine) startCSPI(name string) error {
+	m.state.Current = name
+	return m.save()
+}
+
+// completeCSPI adds the cspi to the upgraded cspis
+func (m *cspcStateMachine) completeCSPI(name string) error {
+	if !m.isCSPICompleted(name) {
+		m.state.CompletedCSPIs = append(m.state.CompletedCSPIs, name)
+	}
+	m.state.Current = ""
+	return m.save()
+}
+
+// save updates the state in the upgradetask, which completes once the
+// upgrade is done. A failure to save fails the upgrade only when it is
+// run by an upgradetask job.
+func (m *cspcStateMachine) save() error {
+	if m.utask == nil {
+		return nil
+	}
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode the upgrade state")
+	}
+	utask := m.utask.DeepCopy()
+	if utask.Annotations == nil {
+		utask.Annotations = map[string]string{}
+	}
+	utask.Annotations[cspcUpgradeStateAnnotation] = string(data)
+	switch {
+	case m.state.Phase == CSPCPhaseDone:
+		utask.Status.Phase = v1Alpha1API.UpgradeSuccess
+		utask.Status.CompletedTime = metav1.Now()
+	case utask.Status.Phase == v1Alpha1API.UpgradeSuccess:
+		// the upgradetask of an earlier upgrade is used again
+		utask.Status.Phase = v1Alpha1API.UpgradeStarted
+		utask.Status.CompletedTime = metav1.Time{}
+	}
+	updated, err := m.client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(m.namespace).
+		Update(context.TODO(), utask, metav1.UpdateOptions{})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to save the upgrade state to upgradetask %s", utask.Name)
+		if m.client.IsUpgradeTaskJob() {
+			return newAPIError(err)
+		}
+		klog.Warning(err)
+		return nil
+	}
+	m.utask = updated
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestCSPCUpgradeTask(state string) *v1Alpha1API.UpgradeTask {
+	utaskObj := buildUpgradeTask("cstorPoolCluster", &ResourcePatch{
+		Name:             "cspc-a",
+		OpenebsNamespace: upgradetesting.Namespace,
+		From:             "2.12.0",
+		To:               "3.0.0",
+	})
+	if state != "" {
+		utaskObj.Annotations = map[string]string{cspcUpgradeStateAnnotation: state}
+	}
+	return utaskObj
+}
+
+func Test_loadCSPCState(t *testing.T) {
+	tests := []struct {
+		name          string
+		state         string
+		wantPhase     CSPCUpgradePhase
+		wantCompleted int
+	}{
+		{name: "no state", wantPhase: CSPCPhasePreUpgrade},
+		{name: "invalid state", state: "{", wantPhase: CSPCPhasePreUpgrade},
+		{name: "unknown phase", state: `{"phase":"Patching","to":"3.0.0"}`, wantPhase: CSPCPhasePreUpgrade},
+		{name: "other version", state: `{"phase":"UpgradingParent","to":"2.12.0"}`, wantPhase: CSPCPhasePreUpgrade},
+		{name: "done", state: `{"phase":"Done","to":"3.0.0","completedCSPIs":["cspi-1"]}`, wantPhase: CSPCPhasePreUpgrade},
+		{
+			name:          "resumed",
+			state:         `{"phase":"UpgradingCSPIs","to":"3.0.0","completedCSPIs":["cspi-1","cspi-2"],"current":"cspi-3"}`,
+			wantPhase:     CSPCPhaseUpgradingCSPIs,
+			wantCompleted: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := loadCSPCState(newTestCSPCUpgradeTask(tt.state), "3.0.0", upgradetesting.Namespace, nil)
+			if m.state.Phase != tt.wantPhase {
+				t.Errorf("loadCSPCState() phase = %s, want %s", m.state.Phase, tt.wantPhase)
+			}
+			if len(m.state.CompletedCSPIs) != tt.wantCompleted {
+				t.Errorf("loadCSPCState() completed = %v, want %d cspis", m.state.CompletedCSPIs, tt.wantCompleted)
+			}
+		})
+	}
+}
+
+func TestCSPCStateMachine(t *testing.T) {
+	utaskObj := newTestCSPCUpgradeTask("")
+	client := NewTestClient(utaskObj)
+	m := loadCSPCState(utaskObj, "3.0.0", upgradetesting.Namespace, client)
+	saved := func() CSPCUpgradeState {
+		t.Helper()
+		got, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+			Get(context.TODO(), utaskObj.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := CSPCUpgradeState{}
+		err = json.Unmarshal([]byte(got.Annotations[cspcUpgradeStateAnnotation]), &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+	for _, step := range []func() error{
+		func() error { return m.advance(CSPCPhasePreUpgrade) },
+		func() error { return m.advance(CSPCPhaseUpgradingCSPIs) },
+		func() error { return m.startCSPI("cspi-1") },
+		func() error { return m.completeCSPI("cspi-1") },
+		func() error { return m.startCSPI("cspi-2") },
+	} {
+		if err := step(); err != nil {
+			t.Fatalf("failed to advance the state: %v", err)
+		}
+	}
+	state := saved()
+	if state.Phase != CSPCPhaseUpgradingCSPIs || state.Current != "cspi-2" ||
+		len(state.CompletedCSPIs) != 1 || state.CompletedCSPIs[0] != "cspi-1" {
+		t.Fatalf("saved state = %+v, want cspi-2 upgrading after cspi-1", state)
+	}
+
+	// the restarted upgrade resumes with the cspis upgraded before
+	got, _ := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+		Get(context.TODO(), utaskObj.Name, metav1.GetOptions{})
+	m = loadCSPCState(got, "3.0.0", upgradetesting.Namespace, client)
+	if !m.isCSPICompleted("cspi-1") || m.isCSPICompleted("cspi-2") {
+		t.Errorf("resumed state = %+v, want only cspi-1 completed", m.state)
+	}
+	if m.resumedAfter(CSPCPhaseUpgradingCSPIs) || !m.resumedAfter(CSPCPhasePreUpgrade) {
+		t.Errorf("resumed state = %+v, want the cspis upgraded again", m.state)
+	}
+	// the phases never move back
+	if err := m.advance(CSPCPhasePreUpgrade); err != nil {
+		t.Fatal(err)
+	}
+	if m.state.Phase != CSPCPhaseUpgradingCSPIs {
+		t.Errorf("advance() moved the phase back to %s", m.state.Phase)
+	}
+	if err := m.advance(CSPCPhaseDone); err != nil {
+		t.Fatal(err)
+	}
+	if state := saved(); state.Phase != CSPCPhaseDone {
+		t.Errorf("saved phase = %s, want %s", state.Phase, CSPCPhaseDone)
+	}
+	if m.utask.Status.Phase != v1Alpha1API.UpgradeSuccess {
+		t.Errorf("upgradetask phase = %s, want %s", m.utask.Status.Phase, v1Alpha1API.UpgradeSuccess)
+	}
+}
+
+func TestCSPCStateMachineWithoutUpgradeTask(t *testing.T) {
+	m := loadCSPCState(nil, "3.0.0", upgradetesting.Namespace, nil)
+	if err := m.advance(CSPCPhaseUpgradingCSPIs); err != nil {
+		t.Fatalf("advance() error = %v", err)
+	}
+	if err := m.completeCSPI("cspi-1"); err != nil {
+		t.Fatalf("completeCSPI() error = %v", err)
+	}
+	if !m.isCSPICompleted("cspi-1") {
+		t.Errorf("completeCSPI() state = %+v, want cspi-1 completed", m.state)
+	}
+}
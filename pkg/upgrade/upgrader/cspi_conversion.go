@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// cspiV1alpha1 is the CStorPoolInstance of the v1alpha1 schema, which
+// has all the raid groups of the pool in one list, with the raid type
+// and the use of each group set on the group
+type cspiV1alpha1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec cspiV1alpha1Spec `json:"spec"`
+}
+
+type cspiV1alpha1Spec struct {
+	HostName     string                  `json:"hostName,omitempty"`
+	NodeSelector map[string]string       `json:"nodeSelector"`
+	PoolConfig   cspiV1alpha1PoolConfig  `json:"poolConfig,omitempty"`
+	RaidGroups   []cspiV1alpha1RaidGroup `json:"raidGroup"`
+}
+
+type cspiV1alpha1PoolConfig struct {
+	CacheFile            string                       `json:"cacheFile,omitempty"`
+	DefaultRaidGroupType string                       `json:"defaultRaidGroupType"`
+	OverProvisioning     bool                         `json:"overProvisioning"`
+	Compression          string                       `json:"compression,omitempty"`
+	Resources            *corev1.ResourceRequirements `json:"resources,omitempty"`
+	AuxResources         *corev1.ResourceRequirements `json:"auxResources,omitempty"`
+	Tolerations          []corev1.Toleration          `json:"tolerations,omitempty"`
+	PriorityClassName    string                       `json:"priorityClassName,omitempty"`
+	ROThresholdLimit     *int                         `json:"roThresholdLimit,omitempty"`
+}
+
+type cspiV1alpha1RaidGroup struct {
+	Type         string                               `json:"type,omitempty"`
+	IsWriteCache bool                                 `json:"isWriteCache,omitempty"`
+	IsSpare      bool                                 `json:"isSpare,omitempty"`
+	IsReadCache  bool                                 `json:"isReadCache,omitempty"`
+	BlockDevices []cstor.CStorPoolInstanceBlockDevice `json:"blockDevices"`
+}
+
+// convertCSPIV1alpha1ToV1 converts a v1alpha1 cspi to v1. The raid groups
+// are split into the data and write cache groups, each with a single
+// raid type, and the overprovisioning is turned into thick provisioning.
+// The owners in the group are referred to at v1 and the version details
+// are kept as they are. The cache file is dropped as the v1 pool manager
+// sets it, and so is the status, which the pool manager reports again.
+func convertCSPIV1alpha1ToV1(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	old := &cspiV1alpha1{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, old)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode v1alpha1 cspi")
+	}
+	config := old.Spec.PoolConfig
+	cspi := &cstor.CStorPoolInstance{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: cstor.SchemeGroupVersion.String(),
+			Kind:       "CStorPoolInstance",
+		},
+		ObjectMeta: old.ObjectMeta,
+		Spec: cstor.CStorPoolInstanceSpec{
+			HostName:     old.Spec.HostName,
+			NodeSelector: old.Spec.NodeSelector,
+			PoolConfig: cstor.PoolConfig{
+				ThickProvision:   !config.OverProvisioning,
+				Compression:      config.Compression,
+				Resources:        config.Resources,
+				AuxResources:     config.AuxResources,
+				Tolerations:      config.Tolerations,
+				ROThresholdLimit: config.ROThresholdLimit,
+			},
+		},
+	}
+	cspi.SelfLink = ""
+	for i, ref := range cspi.OwnerReferences {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err == nil && gv.Group == cstor.SchemeGroupVersion.Group {
+			cspi.OwnerReferences[i].APIVersion = cstor.SchemeGroupVersion.String()
+		}
+	}
+	if config.PriorityClassName != "" {
+		cspi.Spec.PoolConfig.PriorityClassName = &config.PriorityClassName
+	}
+	dataType, writeCacheType := "", ""
+	for _, rg := range old.Spec.RaidGroups {
+		if rg.IsSpare || rg.IsReadCache {
+			return nil, errors.Errorf("raid group %v is a spare or read cache group, which v1 pools do not have",
+				blockDeviceNames(rg.BlockDevices))
+		}
+		rgType := rg.Type
+		if rgType == "" {
+			rgType = config.DefaultRaidGroupType
+		}
+		group := cstor.RaidGroup{CStorPoolInstanceBlockDevices: rg.BlockDevices}
+		if rg.IsWriteCache {
+			if writeCacheType != "" && writeCacheType != rgType {
+				return nil, errors.Errorf("write cache raid groups of types %s and %s, v1 pools have a single type",
+					writeCacheType, rgType)
+			}
+			writeCacheType = rgType
+			cspi.Spec.WriteCacheRaidGroups = append(cspi.Spec.WriteCacheRaidGroups, group)
+			continue
+		}
+		if dataType != "" && dataType != rgType {
+			return nil, errors.Errorf("data raid groups of types %s and %s, v1 pools have a single type",
+				dataType, rgType)
+		}
+		dataType = rgType
+		cspi.Spec.DataRaidGroups = append(cspi.Spec.DataRaidGroups, group)
+	}
+	if len(cspi.Spec.DataRaidGroups) == 0 {
+		return nil, errors.Errorf("no data raid groups")
+	}
+	cspi.Spec.PoolConfig.DataRaidGroupType = dataType
+	cspi.Spec.PoolConfig.WriteCacheGroupType = writeCacheType
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cspi)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode v1 cspi")
+	}
+	delete(u, "status")
+	delete(u, "versionDetails")
+	if versionDetails, ok := obj.Object["versionDetails"]; ok {
+		u["versionDetails"] = versionDetails
+	}
+	return &unstructured.Unstructured{Object: u}, nil
+}
+
+func blockDeviceNames(bds []cstor.CStorPoolInstanceBlockDevice) []string {
+	names := make([]string, 0, len(bds))
+	for _, bd := range bds {
+		names = append(names, bd.BlockDeviceName)
+	}
+	return names
+}
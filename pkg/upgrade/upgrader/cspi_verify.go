@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	"github.com/pkg/errors"
+)
+
+// defaultMaxParallelVerify is the number of cspis whose version
+// reconciliation is verified at the same time, if none is configured
+const defaultMaxParallelVerify = 10
+
+// maxParallelVerify returns the MaxParallelVerify if set,
+// else the defaultMaxParallelVerify
+func (r *ResourcePatch) maxParallelVerify() int {
+	if r.MaxParallelVerify > 0 {
+		return r.MaxParallelVerify
+	}
+	return defaultMaxParallelVerify
+}
+
+// verifyAllCSPIVersionReconcile verifies the version reconciliation of
+// the given cspis once all of them are upgraded, each cspi being polled
+// by its own goroutine, up to MaxParallelVerify at a time. The polls of
+// all the cspis stop at their next poll once the shared context is done.
+// The errors of all the cspis which failed to reconcile are combined.
+func (obj *CSPCPatch) verifyAllCSPIVersionReconcile(ctx context.Context, cspiNames []string) error {
+	workers := obj.maxParallelVerify()
+	if workers > len(cspiNames) {
+		workers = len(cspiNames)
+	}
+	errs := make([]error, len(cspiNames))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = obj.verifyCSPIReconcile(ctx, cspiNames[i])
+			}
+		}()
+	}
+	for i := range cspiNames {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	failed := []string{}
+	timedOut := false
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed = append(failed, fmt.Sprintf("%s: %v", cspiNames[i], err))
+		timedOut = timedOut || errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	err := errors.Errorf("failed to verify the version of %d out of %d cspis of cspc %s: %s",
+		len(failed), len(cspiNames), obj.Name, strings.Join(failed, "; "))
+	if timedOut {
+		return newTimeoutError(err)
+	}
+	return err
+}
+
+// verifyCSPIReconcile verifies the version reconciliation of the cspi
+// until the context is done
+func (obj *CSPCPatch) verifyCSPIReconcile(ctx context.Context, name string) error {
+	cspi := patch.NewCSPI(patch.WithCSPIClient(obj.OpenebsClientset))
+	return obj.verifyVersionReconcile(name, func() (ReconcileStatus, error) {
+		if err := ctx.Err(); err != nil {
+			return ReconcileStatus{}, errors.Wrapf(err, "stopped verifying cspi %s", name)
+		}
+		err := cspi.Get(name, obj.Namespace)
+		if err != nil {
+			return ReconcileStatus{}, errors.Wrapf(err, "failed to get cspi %s to verify", name)
+		}
+		status := cspi.Object.VersionDetails.Status
+		return ReconcileStatus{status.Current, status.Message, status.Reason, cspi.Object}, nil
+	})
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testCSPIVerifyPatch(client *Client, opts ...ResourcePatchOptions) *CSPCPatch {
+	opts = append([]ResourcePatchOptions{
+		WithName("cspc-a"),
+		WithOpenebsNamespace(upgradetesting.Namespace),
+		ToVersion("3.0.0"),
+		WithPollInterval(time.Millisecond),
+	}, opts...)
+	obj := NewCSPCPatch(
+		WithCSPCResorcePatch(NewResourcePatch(opts...)),
+		WithCSPCClient(client),
+	)
+	obj.Namespace = upgradetesting.Namespace
+	return obj
+}
+
+func TestCSPCPatch_verifyAllCSPIVersionReconcile(t *testing.T) {
+	client := NewTestClient(
+		upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", "3.0.0"),
+		upgradetesting.NewTestCSPI("cspc-a-2", "cspc-a", "3.0.0"),
+		upgradetesting.NewTestCSPI("cspc-a-3", "cspc-a", "2.12.0"),
+	)
+	obj := testCSPIVerifyPatch(client, WithReconcileTimeout(20*time.Millisecond))
+	err := obj.verifyAllCSPIVersionReconcile(context.Background(), []string{"cspc-a-1", "cspc-a-2"})
+	if err != nil {
+		t.Errorf("verifyAllCSPIVersionReconcile() of reconciled cspis error = %v", err)
+	}
+	err = obj.verifyAllCSPIVersionReconcile(context.Background(), []string{"cspc-a-1", "cspc-a-3", "cspc-a-4"})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("verifyAllCSPIVersionReconcile() error = %v, want a timeout error", err)
+	}
+	// the errors of all the failed cspis are combined
+	for _, name := range []string{"cspc-a-3", "cspc-a-4"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("verifyAllCSPIVersionReconcile() error = %v, want it to name %s", err, name)
+		}
+	}
+	if strings.Contains(err.Error(), "cspc-a-1:") {
+		t.Errorf("verifyAllCSPIVersionReconcile() error = %v, want it to not name cspc-a-1", err)
+	}
+
+	// the polls stop once the shared context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = testCSPIVerifyPatch(client).verifyAllCSPIVersionReconcile(ctx, []string{"cspc-a-3"})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("verifyAllCSPIVersionReconcile() with a done context error = %v, want a timeout error", err)
+	}
+}
+
+func TestCSPCPatch_verifyAllCSPIVersionReconcileBounded(t *testing.T) {
+	names := []string{}
+	objs := []runtime.Object{}
+	for _, name := range []string{"cspc-a-1", "cspc-a-2", "cspc-a-3", "cspc-a-4", "cspc-a-5"} {
+		names = append(names, name)
+		objs = append(objs, upgradetesting.NewTestCSPI(name, "cspc-a", "3.0.0"))
+	}
+	client := NewTestClient(objs...)
+	clientset := fakeOpenebs(client)
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	clientset.PrependReactor("get", "cstorpoolinstances", func(k8stesting.Action) (bool, runtime.Object, error) {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return false, nil, nil
+	})
+	err := testCSPIVerifyPatch(client, WithMaxParallelVerify(2)).
+		verifyAllCSPIVersionReconcile(context.Background(), names)
+	if err != nil {
+		t.Fatalf("verifyAllCSPIVersionReconcile() error = %v", err)
+	}
+	if maxRunning > 2 {
+		t.Errorf("%d cspis verified at the same time, want at most 2", maxRunning)
+	}
+}
@@ -18,15 +18,33 @@ package upgrader
 
 import (
 	"context"
+	"strconv"
+	"strings"
 	"time"
 
 	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
 	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
 	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/klog"
 )
 
+// defaultRebuildTimeout is the default time to wait for the
+// replicas on an upgraded cspi to rebuild
+const defaultRebuildTimeout = 30 * time.Minute
+
+// poolCountTimeout is the time to wait for the healthy cspis of an
+// upgraded cspc to match its pools, while the restarted pools come up
+const poolCountTimeout = 5 * time.Minute
+
+// patchAttemptAnnotation is the annotation stamped with the attempt
+// when the patch of a cspc is reapplied
+const patchAttemptAnnotation = "openebs.io/upgrade-patch-attempt"
+
 // CSPCPatch is the patch required to upgrade CSPC
 type CSPCPatch struct {
 	*ResourcePatch
@@ -63,12 +81,23 @@ func NewCSPCPatch(opts ...CSPCPatchOptions) *CSPCPatch {
 
 // PreUpgrade ...
 func (obj *CSPCPatch) PreUpgrade() error {
-	err := isOperatorUpgraded("cspc-operator", obj.Namespace, obj.To, obj.KubeClientset)
+	err := isOperatorUpgraded("cspc-operator", obj.Namespace, obj.To,
+		obj.operatorVersionLabel(), obj.KubeClientset)
 	if err != nil {
 		return err
 	}
 	err = obj.CSPC.PreChecks(obj.From, obj.To)
-	return err
+	if err != nil {
+		return err
+	}
+	if obj.VerifyOnly {
+		return nil
+	}
+	err = obj.checkLargeUpgrade()
+	if err != nil {
+		return err
+	}
+	return obj.handlePendingExpansion()
 }
 
 // Init initializes all the fields of the CSPCPatch
@@ -81,6 +110,14 @@ func (obj *CSPCPatch) Init() error {
 	if err != nil {
 		return err
 	}
+	err = obj.resolveFromVersion("cspc", obj.Name, obj.CSPC.Object.VersionDetails.Status.Current,
+		obj.CSPC.Object.Annotations)
+	if err != nil {
+		return err
+	}
+	if obj.VerifyOnly {
+		return nil
+	}
 	err = getCSPCPatchData(obj)
 	return err
 }
@@ -91,7 +128,8 @@ func getCSPCPatchData(obj *CSPCPatch) error {
 	if err != nil {
 		return err
 	}
-	obj.CSPC.Data, err = GetPatchData(obj.CSPC.Object, newCSPC)
+	obj.CSPC.Data, err = GetPatchData(obj.CSPC.Object, newCSPC,
+		IncludePatchFields("versionDetails"))
 	return err
 }
 
@@ -102,6 +140,10 @@ func transformCSPC(c *cstor.CStorPoolCluster, res *ResourcePatch) error {
 
 // CSPCUpgrade ...
 func (obj *CSPCPatch) CSPCUpgrade() error {
+	if obj.VerifyOnly {
+		klog.Infof("skipping patch of cspc %s in verify only mode", obj.Name)
+		return nil
+	}
 	err := obj.CSPC.Patch(obj.From, obj.To)
 	if err != nil {
 		return err
@@ -111,15 +153,36 @@ func (obj *CSPCPatch) CSPCUpgrade() error {
 
 // Upgrade execute the steps to upgrade CSPC
 func (obj *CSPCPatch) Upgrade() error {
-	err := obj.Init()
+	release, err := lockResource("cstorPoolCluster", obj.ResourcePatch, obj.Client)
 	if err != nil {
 		return err
 	}
-	err = obj.PreUpgrade()
+	defer release()
+	err = obj.Init()
+	if err != nil {
+		return newAPIError(err)
+	}
+	if obj.TargetCSPI != "" {
+		return obj.upgradeTargetCSPI()
+	}
+	state, err := obj.loadState()
 	if err != nil {
 		return err
 	}
+	err = state.advance(CSPCPhasePreUpgrade)
+	if err != nil {
+		return err
+	}
+	err = obj.PreUpgrade()
+	if err != nil {
+		return newValidationError(err)
+	}
+	before := obj.CSPC.Object.DeepCopy()
 	res := *obj.ResourcePatch
+	if res.fromDetected {
+		// each cspi is upgraded from its own from version
+		res.From = res.globalFrom
+	}
 	cspiList, err := obj.Client.OpenebsClientset.CstorV1().
 		CStorPoolInstances(obj.Namespace).List(context.TODO(),
 		metav1.ListOptions{
@@ -127,77 +190,600 @@ func (obj *CSPCPatch) Upgrade() error {
 		},
 	)
 	if err != nil {
-		return err
+		return newAPIError(newObjectError("list", "cspi", obj.Namespace, "", err))
 	}
-	for _, cspiObj := range cspiList.Items {
-		res.Name = cspiObj.Name
-		dependant := NewCSPIPatch(
-			WithCSPIResorcePatch(&res),
-			WithCSPIClient(obj.Client),
-		)
-		err = dependant.Upgrade()
-		if err != nil {
-			utaskObj, uerr := obj.OpenebsClientset.OpenebsV1alpha1().
-				UpgradeTasks(obj.OpenebsNamespace).
-				Get(context.TODO(), "upgrade-cstor-cspi-"+cspiObj.Name, metav1.GetOptions{})
-			if uerr != nil && isUpgradeTaskJob {
-				return uerr
-			}
-			backoffLimit, uerr := getBackoffLimit(obj.OpenebsNamespace, obj.Client)
-			if uerr != nil && isUpgradeTaskJob {
-				return uerr
+	if obj.NUMAAware {
+		// the cspis are upgraded batch by batch, with at most one cspi
+		// per NUMA node in a batch
+		batches := CheckNUMATopology(cspiList.Items, obj.KubeClientset)
+		for i, batch := range batches {
+			klog.Infof("cspc %s: NUMA batch %d: %s", obj.Name, i+1, strings.Join(batch, ", "))
+		}
+		cspiList.Items = orderByNUMABatches(cspiList.Items, batches)
+	}
+	maxUnavailable, err := getMaxUnavailable(obj.MaxUnavailable, len(cspiList.Items))
+	if err != nil {
+		return newValidationError(err)
+	}
+	skipped := []skippedResource{}
+	// deleting are the cspis being deleted by a scale down of the cspc,
+	// which may still be counted as healthy until they are gone
+	deleting := 0
+	// upgraded are the cspis whose version is verified once all are upgraded
+	upgraded := []string{}
+	// upgradedCount are the cspis upgraded by this upgrade, unlike the
+	// skipped ones and the ones already at the target version
+	upgradedCount := 0
+	if state.resumedAfter(CSPCPhaseUpgradingCSPIs) {
+		klog.Infof("cspc %s: the cspis were upgraded before the restart", obj.Name)
+		// the version of the cspis is still verified once the cspc is
+		for _, cspiObj := range cspiList.Items {
+			if obj.getCSPISkipReason(&cspiObj) == "" {
+				upgraded = append(upgraded, cspiObj.Name)
 			}
-			utaskObj.Status.Retries = utaskObj.Status.Retries + 1
-			if utaskObj.Status.Retries == backoffLimit {
-				utaskObj.Status.Phase = v1Alpha1API.UpgradeError
-				utaskObj.Status.CompletedTime = metav1.Now()
+		}
+		cspiList.Items = nil
+	}
+	err = state.advance(CSPCPhaseUpgradingCSPIs)
+	if err != nil {
+		return err
+	}
+	for i, cspiObj := range cspiList.Items {
+		if state.isCSPICompleted(cspiObj.Name) {
+			klog.Infof("cspi %s: upgraded before the restart", cspiObj.Name)
+			upgraded = append(upgraded, cspiObj.Name)
+			upgradedCount++
+			obj.Result.Add("cstorPoolInstance", cspiObj.Name, nil)
+			obj.emitCSPIProgress(i+1, len(cspiList.Items), "cspi "+cspiObj.Name+" upgraded before the restart")
+			continue
+		}
+		if reason := obj.getCSPISkipReason(&cspiObj); reason != "" {
+			klog.Infof("cspi %s: skipping, %s", cspiObj.Name, reason)
+			skipped = append(skipped, skippedResource{cspiObj.Name, reason})
+			if cspiObj.DeletionTimestamp != nil {
+				deleting++
 			}
-			_, uerr = obj.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(obj.OpenebsNamespace).
-				Update(context.TODO(), utaskObj, metav1.UpdateOptions{})
-			if uerr != nil && isUpgradeTaskJob {
-				return uerr
+			obj.Result.AddSkipped("cstorPoolInstance", cspiObj.Name, reason)
+			obj.emitCSPIProgress(i+1, len(cspiList.Items), "cspi "+cspiObj.Name+" skipped, "+reason)
+			continue
+		}
+		// a failure to check is left to the upgrade of the cspi to report
+		if done, _ := isCSPIAtTargetVersion(cspiObj.Name, obj.ResourcePatch, obj.Client); done {
+			klog.Infof("cspi %s: resource already at target version %s", cspiObj.Name, obj.To)
+			upgraded = append(upgraded, cspiObj.Name)
+			obj.Result.AddSkipped("cstorPoolInstance", cspiObj.Name, "already at target version")
+			obj.emitCSPIProgress(i+1, len(cspiList.Items), "cspi "+cspiObj.Name+" already at target version")
+			continue
+		}
+		if obj.MaxUnavailable != nil {
+			err = obj.waitForUnavailableBudget(maxUnavailable)
+			if err != nil {
+				if errors.Is(err, ErrTimeout) {
+					return err
+				}
+				return newAPIError(err)
 			}
+		}
+		res.Name = cspiObj.Name
+		completed, err := obj.upgradeCSPI(&cspiObj, res, state, upgradedCount, len(cspiList.Items))
+		if completed {
+			upgraded = append(upgraded, cspiObj.Name)
+			upgradedCount++
+		}
+		if err != nil {
+			return err
+		}
+		obj.emitCSPIProgress(i+1, len(cspiList.Items), "cspi "+cspiObj.Name+" upgraded")
+	}
+	if !state.resumedAfter(CSPCPhaseUpgradingParent) {
+		err = state.advance(CSPCPhaseUpgradingParent)
+		if err != nil {
 			return err
 		}
-		utaskObj, uerr := obj.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(obj.OpenebsNamespace).
-			Get(context.TODO(), "upgrade-cstor-cspi-"+cspiObj.Name, metav1.GetOptions{})
-		if uerr != nil && isUpgradeTaskJob {
-			return uerr
+		err = obj.CSPCUpgrade()
+		if err != nil {
+			return newPartialFailureError(newAPIError(err))
+		}
+	}
+	err = state.advance(CSPCPhaseVerifying)
+	if err != nil {
+		return err
+	}
+	err = obj.verifyCSPCReconcileWithReapply()
+	if err != nil {
+		return newPartialFailureError(newAPIError(err))
+	}
+	verifySpecChecksum("cspc", obj.Name, before, obj.CSPC.Object)
+	ctx := context.Background()
+	if obj.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, obj.ReconcileTimeout)
+		defer cancel()
+	}
+	err = obj.verifyAllCSPIVersionReconcile(ctx, upgraded)
+	if err != nil {
+		return newPartialFailureError(err)
+	}
+	if obj.VerifyPoolCount {
+		err = obj.verifyPoolCount(poolCountTimeout, deleting)
+		if err != nil {
+			return newPartialFailureError(err)
+		}
+	}
+	logSkippedResources("cspi", skipped)
+	return state.advance(CSPCPhaseDone)
+}
+
+// upgradeCSPI upgrades a cspi of the cspc while holding its node, which
+// is released once the cspi is upgraded or fails. It returns true if the
+// cspi was upgraded, even if its replicas then failed to rebuild, and
+// upgradedCount are the cspis out of total upgraded before this one.
+func (obj *CSPCPatch) upgradeCSPI(cspiObj *cstor.CStorPoolInstance, res ResourcePatch,
+	state *cspcStateMachine, upgradedCount, total int) (bool, error) {
+	err := state.startCSPI(cspiObj.Name)
+	if err != nil {
+		return false, err
+	}
+	release := obj.scheduleCSPI(cspiObj)
+	defer release()
+	dependant := NewCSPIPatch(
+		WithCSPIResorcePatch(&res),
+		WithCSPIClient(obj.Client),
+	)
+	obj.Result.Start("cstorPoolInstance", cspiObj.Name)
+	err = dependant.Upgrade()
+	obj.Result.Add("cstorPoolInstance", cspiObj.Name, err)
+	if err != nil {
+		uerr := obj.updateCSPIUpgradeTask(cspiObj.Name, err)
+		if uerr != nil && obj.IsUpgradeTaskJob() {
+			return false, newAPIError(uerr)
+		}
+		if upgradedCount > 0 {
+			return false, newPartialFailureError(
+				errors.Wrapf(err, "upgraded %d out of %d cspis", upgradedCount, total),
+			)
 		}
+		return false, err
+	}
+	uerr := obj.updateCSPIUpgradeTask(cspiObj.Name, nil)
+	if uerr != nil && obj.IsUpgradeTaskJob() {
+		return false, newAPIError(uerr)
+	}
+	err = state.completeCSPI(cspiObj.Name)
+	if err != nil {
+		return false, err
+	}
+	if obj.WaitForRebuild {
+		err = obj.waitForRebuild(cspiObj.Name)
+		if err != nil {
+			return true, newPartialFailureError(
+				errors.Wrapf(err, "upgraded %d out of %d cspis", upgradedCount+1, total),
+			)
+		}
+	}
+	return true, nil
+}
+
+// upgradeTargetCSPI upgrades only the TargetCSPI of the cspc, to debug
+// the upgrade one cspi at a time. The cspc is not patched, as it is only
+// patched once all of its cspis are upgraded, and the state of the upgrade
+// of the cspc is left as is for the upgrade of all its cspis to resume.
+func (obj *CSPCPatch) upgradeTargetCSPI() error {
+	err := obj.PreUpgrade()
+	if err != nil {
+		return newValidationError(err)
+	}
+	cspiObj, err := obj.OpenebsClientset.CstorV1().CStorPoolInstances(obj.Namespace).
+		Get(context.TODO(), obj.TargetCSPI, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return newValidationError(errors.Errorf("target cspi %s not found", obj.TargetCSPI))
+	}
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to get target cspi %s", obj.TargetCSPI))
+	}
+	if cspc := cspiObj.Labels["openebs.io/cstor-pool-cluster"]; cspc != obj.Name {
+		return newValidationError(errors.Errorf("target cspi %s belongs to cspc %q, not to cspc %s",
+			obj.TargetCSPI, cspc, obj.Name))
+	}
+	if reason := obj.getCSPISkipReason(cspiObj); reason != "" {
+		klog.Infof("cspi %s: skipping, %s", cspiObj.Name, reason)
+		obj.Result.AddSkipped("cstorPoolInstance", cspiObj.Name, reason)
+		return nil
+	}
+	if done, _ := isCSPIAtTargetVersion(cspiObj.Name, obj.ResourcePatch, obj.Client); done {
+		klog.Infof("cspi %s: resource already at target version %s", cspiObj.Name, obj.To)
+		obj.Result.AddSkipped("cstorPoolInstance", cspiObj.Name, "already at target version")
+		return nil
+	}
+	res := *obj.ResourcePatch
+	if res.fromDetected {
+		res.From = res.globalFrom
+	}
+	res.Name = cspiObj.Name
+	release := obj.scheduleCSPI(cspiObj)
+	defer release()
+	obj.Result.Start("cstorPoolInstance", cspiObj.Name)
+	err = NewCSPIPatch(
+		WithCSPIResorcePatch(&res),
+		WithCSPIClient(obj.Client),
+	).Upgrade()
+	obj.Result.Add("cstorPoolInstance", cspiObj.Name, err)
+	uerr := obj.updateCSPIUpgradeTask(cspiObj.Name, err)
+	if uerr != nil && obj.IsUpgradeTaskJob() {
+		return newAPIError(uerr)
+	}
+	if err != nil {
+		return err
+	}
+	if obj.WaitForRebuild {
+		err = obj.waitForRebuild(cspiObj.Name)
+		if err != nil {
+			return err
+		}
+	}
+	klog.Infof("cspc %s: upgraded target cspi %s, the cspc is patched once all its cspis are upgraded",
+		obj.Name, cspiObj.Name)
+	return nil
+}
+
+// loadState returns the state of the upgrade saved in the upgradetask of
+// the cspc, which is created if missing. The state is not saved in the
+// verify only mode, as nothing is patched.
+func (obj *CSPCPatch) loadState() (*cspcStateMachine, error) {
+	if obj.VerifyOnly {
+		return loadCSPCState(nil, obj.To, obj.OpenebsNamespace, obj.Client), nil
+	}
+	utaskObj, err := getOrCreateUpgradeTask("cstorPoolCluster", obj.ResourcePatch, obj.Client)
+	if err != nil {
+		if obj.IsUpgradeTaskJob() {
+			return nil, newAPIError(err)
+		}
+		klog.Warningf("cspc %s: the upgrade state is not saved: %v", obj.Name, err)
+	}
+	return loadCSPCState(utaskObj, obj.To, obj.OpenebsNamespace, obj.Client), nil
+}
+
+// updateCSPIUpgradeTask sets the phase of the upgradetask of the cspi
+// after its upgrade, counting a retry if the upgrade failed. No
+// upgradetask is updated if it can not be read or they are disabled. If a Backoff strategy
+// is set, a failed cspi with retries left is delayed by the backoff of
+// its retries, so that the restarted job does not retry it right away.
+func (obj *CSPCPatch) updateCSPIUpgradeTask(name string, upgradeErr error) error {
+	if obj.upgradeTasksDisabled() {
+		return nil
+	}
+	utaskObj, err := obj.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(obj.OpenebsNamespace).
+		Get(context.TODO(), "upgrade-cstor-cspi-"+name, metav1.GetOptions{})
+	if err != nil {
+		return newObjectError("get", "upgradetask", obj.OpenebsNamespace, "upgrade-cstor-cspi-"+name, err)
+	}
+	if upgradeErr != nil {
+		backoffLimit, err := getBackoffLimit(obj.OpenebsNamespace, obj.Client)
+		if err != nil {
+			return err
+		}
+		utaskObj.Status.Retries = utaskObj.Status.Retries + 1
+		if utaskObj.Status.Retries == backoffLimit {
+			utaskObj.Status.Phase = v1Alpha1API.UpgradeError
+			utaskObj.Status.CompletedTime = metav1.Now()
+		} else if obj.Backoff != nil {
+			defer obj.delayRetry(name, utaskObj.Status.Retries, backoffLimit)
+		}
+	} else {
 		utaskObj.Status.Phase = v1Alpha1API.UpgradeSuccess
 		utaskObj.Status.CompletedTime = metav1.Now()
-		_, uerr = obj.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(obj.OpenebsNamespace).
-			Update(context.TODO(), utaskObj, metav1.UpdateOptions{})
-		if uerr != nil && isUpgradeTaskJob {
-			return uerr
+	}
+	_, err = obj.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(obj.OpenebsNamespace).
+		Update(context.TODO(), utaskObj, metav1.UpdateOptions{})
+	return newObjectError("update", "upgradetask", obj.OpenebsNamespace, utaskObj.Name, err)
+}
+
+// delayRetry waits for the backoff of the given retry of the cspi
+func (obj *CSPCPatch) delayRetry(name string, retries, backoffLimit int) {
+	delay := obj.Backoff.Delay(obj.pollInterval(), retries)
+	klog.Infof("cspi %s: failed %d of %d times, retrying in %s", name, retries, backoffLimit, delay)
+	time.Sleep(delay)
+}
+
+// emitCSPIProgress sends the progress of the cspc after the given number
+// of its cspis are done. The cspc is only at 100 percent once it has
+// reconciled, so the cspis are counted up to 90 percent.
+func (obj *CSPCPatch) emitCSPIProgress(done, total int, message string) {
+	obj.EmitProgress(PhaseProgress, "cstorPoolCluster", obj.Name, done*90/total, message, nil)
+}
+
+// verifyPoolCount waits for the healthy instances in the status of the
+// cspc to be equal to the pools in its spec, failing after the timeout if
+// pools went missing during the upgrade even though the version reconciled.
+// The given number of cspis being deleted may still be counted as healthy
+// while the pools are removed from the spec.
+func (obj *CSPCPatch) verifyPoolCount(timeout time.Duration, deleting int) error {
+	interval := obj.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	log := &waitLogger{}
+	deadline := time.Now().Add(timeout)
+	for {
+		// the cspc object is the latest one got while verifying the version
+		cspcObj := obj.CSPC.Object
+		pools := int32(len(cspcObj.Spec.Pools))
+		healthy := cspcObj.Status.HealthyInstances
+		if healthy >= pools && healthy <= pools+int32(deleting) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return newValidationError(errors.Errorf(
+				"cspc %s has %d healthy out of %d provisioned cspis, expected %d pools as in its spec",
+				obj.Name, cspcObj.Status.HealthyInstances, cspcObj.Status.ProvisionedInstances, pools,
+			))
+		}
+		log.Infof("cspc %s: waiting for the pools to be healthy, %d out of %d healthy",
+			obj.Name, cspcObj.Status.HealthyInstances, pools)
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
+		err := obj.CSPC.Get(obj.Name, obj.Namespace)
+		if err != nil {
+			return newAPIError(err)
 		}
 	}
-	err = obj.CSPCUpgrade()
+}
+
+// getCSPISkipReason returns the reason to skip the upgrade of the
+// cspi, or an empty string if the cspi needs to be upgraded
+func (obj *CSPCPatch) getCSPISkipReason(cspiObj *cstor.CStorPoolInstance) string {
+	// the cspi of a scale down would never reconcile
+	if cspiObj.DeletionTimestamp != nil {
+		return "being deleted"
+	}
+	if isSkipUpgrade(cspiObj, obj.skipUpgradeAnnotation()) {
+		return "excluded by annotation " + obj.skipUpgradeAnnotation()
+	}
+	if obj.isCSPIUpgradeComplete(cspiObj) {
+		return "already complete"
+	}
+	return ""
+}
+
+// isCSPIUpgradeComplete returns true only if the upgradetask of the cspi
+// is successful, the cspi is reconciled to the desired version and the
+// images of its pool deployment are the ones of the upgrade. If the
+// upgradetask is successful but the version or the images have drifted
+// the cspi needs to be upgraded again.
+func (obj *CSPCPatch) isCSPIUpgradeComplete(cspiObj *cstor.CStorPoolInstance) bool {
+	if obj.upgradeTasksDisabled() {
+		return false
+	}
+	utaskObj, err := obj.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(obj.OpenebsNamespace).
+		Get(context.TODO(), "upgrade-cstor-cspi-"+cspiObj.Name, metav1.GetOptions{})
+	if err != nil || utaskObj.Status.Phase != v1Alpha1API.UpgradeSuccess {
+		return false
+	}
+	if !isVersionAt(cspiObj.VersionDetails, obj.To) {
+		klog.Infof("cspi %s: upgradetask is successful but version is %s with desired %s, upgrading again",
+			cspiObj.Name, cspiObj.VersionDetails.Status.Current, cspiObj.VersionDetails.Desired)
+		return false
+	}
+	deploy := patch.NewDeployment(patch.WithDeploymentClient(obj.KubeClientset))
+	err = deploy.Get("openebs.io/cstor-pool-instance="+cspiObj.Name, obj.OpenebsNamespace)
 	if err != nil {
-		return err
+		klog.Infof("cspi %s: upgradetask is successful but the pool deployment can not be read, upgrading again: %v",
+			cspiObj.Name, err)
+		return false
+	}
+	desired := deploy.Object.DeepCopy()
+	err = transformCSPIDeploy(desired, obj.ResourcePatch)
+	if err != nil {
+		return false
+	}
+	for i, c := range deploy.Object.Spec.Template.Spec.Containers {
+		if c.Image != desired.Spec.Template.Spec.Containers[i].Image {
+			klog.Infof("cspi %s: upgradetask is successful but container %s has image %s, upgrading again",
+				cspiObj.Name, c.Name, c.Image)
+			return false
+		}
+	}
+	return true
+}
+
+// verifyCSPCReconcileWithReapply verifies the version reconciliation of
+// the cspc, and reapplies its patch up to PatchReapplyAttempts times when
+// the verification times out, each followed by a new verification
+func (obj *CSPCPatch) verifyCSPCReconcileWithReapply() error {
+	err := obj.verifyCSPCVersionReconcile()
+	for attempt := 1; errors.Is(err, ErrTimeout) && !obj.VerifyOnly && attempt <= obj.PatchReapplyAttempts; attempt++ {
+		klog.Warningf("cspc %s: reapplying the patch, attempt %d of %d: %v",
+			obj.Name, attempt, obj.PatchReapplyAttempts, err)
+		err = obj.reapplyCSPCPatch(attempt)
+		if err != nil {
+			return err
+		}
+		err = obj.verifyCSPCVersionReconcile()
+		if err == nil {
+			klog.Infof("cspc %s: reconciled after reapplying the patch %d times", obj.Name, attempt)
+		}
 	}
-	err = obj.verifyCSPCVersionReconcile()
 	return err
 }
 
-func (obj *CSPCPatch) verifyCSPCVersionReconcile() error {
-	// get the latest cspc object
+// reapplyCSPCPatch derives the patch of the cspc again from its latest
+// state and applies it, for when the operator missed the first patch due
+// to a gap in its watch. The attempt is stamped in an annotation, so that
+// the patch changes the cspc and is seen by the operator even when the
+// desired version is already set.
+func (obj *CSPCPatch) reapplyCSPCPatch(attempt int) error {
 	err := obj.CSPC.Get(obj.Name, obj.Namespace)
 	if err != nil {
 		return err
 	}
-	// waiting for the current version to be equal to desired version
-	for obj.CSPC.Object.VersionDetails.Status.Current != obj.To {
-		klog.Infof("Verifying the reconciliation of version for %s", obj.CSPC.Object.Name)
-		// Sleep equal to the default sync time
-		time.Sleep(10 * time.Second)
-		err = obj.CSPC.Get(obj.Name, obj.Namespace)
+	newCSPC := obj.CSPC.Object.DeepCopy()
+	err = transformCSPC(newCSPC, obj.ResourcePatch)
+	if err != nil {
+		return err
+	}
+	if newCSPC.Annotations == nil {
+		newCSPC.Annotations = map[string]string{}
+	}
+	newCSPC.Annotations[patchAttemptAnnotation] = strconv.Itoa(attempt)
+	data, err := GetPatchData(obj.CSPC.Object, newCSPC,
+		IncludePatchFields("metadata.annotations", "versionDetails"))
+	if err != nil {
+		return err
+	}
+	_, err = obj.OpenebsClientset.CstorV1().CStorPoolClusters(obj.Namespace).
+		Patch(context.TODO(), obj.Name, k8stypes.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to reapply the patch of cspc %s", obj.Name)
+	}
+	return nil
+}
+
+func (obj *CSPCPatch) verifyCSPCVersionReconcile() error {
+	return obj.verifyVersionReconcile(obj.Name, func() (ReconcileStatus, error) {
+		err := obj.CSPC.Get(obj.Name, obj.Namespace)
 		if err != nil {
-			return err
+			return ReconcileStatus{}, err
+		}
+		status := obj.CSPC.Object.VersionDetails.Status
+		return ReconcileStatus{status.Current, status.Message, status.Reason, obj.CSPC.Object}, nil
+	})
+}
+
+// getMaxUnavailable returns the number of cspis that are allowed to be
+// unavailable at a time, including the cspi being upgraded. The budget
+// is rejected if it allows more than a minority of the pools to be
+// disrupted, as the volumes with replicas on those pools would lose
+// quorum. A cspc of one or two pools has no such minority, its cspis are
+// upgraded one at a time with the others ONLINE, and a volume with a
+// replica on each of two pools loses its quorum while a pool is upgraded.
+func getMaxUnavailable(maxUnavailable *intstr.IntOrString, total int) (int, error) {
+	if maxUnavailable == nil || total == 0 {
+		return total, nil
+	}
+	value, err := intstr.GetValueFromIntOrPercent(maxUnavailable, total, false)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid max unavailable %s", maxUnavailable.String())
+	}
+	if value < 1 {
+		return 0, errors.Errorf(
+			"max unavailable %s allows no cspi to be upgraded out of %d cspis",
+			maxUnavailable.String(), total,
+		)
+	}
+	// the cspi being upgraded is always disrupted to make progress
+	allowed := total - (total/2 + 1)
+	if allowed < 1 {
+		allowed = 1
+	}
+	if value > allowed {
+		return 0, errors.Errorf(
+			"max unavailable %s can lead to loss of quorum, at most %d out of %d cspis can be unavailable",
+			maxUnavailable.String(), allowed, total,
+		)
+	}
+	return value, nil
+}
+
+// waitForUnavailableBudget waits till the number of cspis of the cspc
+// which are not ONLINE is less than the given budget, polling at the
+// PollInterval, and fails with a timeout error after the RebuildTimeout
+func (obj *CSPCPatch) waitForUnavailableBudget(maxUnavailable int) error {
+	timeout := obj.RebuildTimeout
+	if timeout <= 0 {
+		timeout = defaultRebuildTimeout
+	}
+	interval := obj.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	log := &waitLogger{}
+	deadline := time.Now().Add(timeout)
+	for {
+		cspiList, err := obj.Client.OpenebsClientset.CstorV1().
+			CStorPoolInstances(obj.Namespace).List(context.TODO(),
+			metav1.ListOptions{
+				LabelSelector: "openebs.io/cstor-pool-cluster=" + obj.Name,
+			},
+		)
+		if err != nil {
+			return newObjectError("list", "cspi", obj.Namespace, "", err)
+		}
+		unavailable := []string{}
+		for _, cspiObj := range cspiList.Items {
+			if cspiObj.Status.Phase != cstor.CStorPoolStatusOnline {
+				unavailable = append(unavailable, cspiObj.Name)
+			}
+		}
+		if len(unavailable) < maxUnavailable {
+			return nil
 		}
-		if obj.CSPC.Object.VersionDetails.Status.Message != "" {
-			klog.Errorf("failed to reconcile: %s", obj.CSPC.Object.VersionDetails.Status.Reason)
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf(
+				"cspis %v are not ONLINE after %s, max unavailable is %d",
+				unavailable, timeout, maxUnavailable,
+			))
 		}
+		log.Infof("Waiting for cspis %v to be ONLINE, max unavailable is %d",
+			unavailable, maxUnavailable)
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
 	}
-	return nil
+}
+
+// waitForRebuild waits for all the replicas on the upgraded cspi to be
+// healthy, so that the next cspi is not upgraded while the replicas
+// are still rebuilding
+func (obj *CSPCPatch) waitForRebuild(cspiName string) error {
+	timeout := obj.RebuildTimeout
+	if timeout <= 0 {
+		timeout = defaultRebuildTimeout
+	}
+	interval := obj.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	log := &waitLogger{}
+	deadline := time.Now().Add(timeout)
+	for {
+		cspiObj, err := obj.OpenebsClientset.CstorV1().CStorPoolInstances(obj.Namespace).
+			Get(context.TODO(), cspiName, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(newObjectError("get", "cspi", obj.Namespace, cspiName, err))
+		}
+		if cspiObj.Status.HealthyReplicas >= cspiObj.Status.ProvisionedReplicas {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf(
+				"timed out after %s waiting for replicas on cspi %s to rebuild, %d out of %d healthy",
+				timeout, cspiName,
+				cspiObj.Status.HealthyReplicas, cspiObj.Status.ProvisionedReplicas,
+			))
+		}
+		rebuilding, err := obj.getRebuildingCVRs(cspiName)
+		if err != nil {
+			return newAPIError(err)
+		}
+		log.Infof("cspi %s: waiting for rebuild, %d out of %d replicas healthy, rebuilding %v",
+			cspiName, cspiObj.Status.HealthyReplicas, cspiObj.Status.ProvisionedReplicas, rebuilding)
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
+	}
+}
+
+// getRebuildingCVRs returns the names of the cvrs on the
+// cspi which are rebuilding or reconstructing
+func (obj *CSPCPatch) getRebuildingCVRs(cspiName string) ([]string, error) {
+	cvrList, err := obj.OpenebsClientset.CstorV1().CStorVolumeReplicas(obj.Namespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "cstorpoolinstance.openebs.io/name=" + cspiName,
+		})
+	if err != nil {
+		return nil, newObjectError("list", "cvr", obj.Namespace, "", err)
+	}
+	rebuilding := []string{}
+	for _, cvrObj := range cvrList.Items {
+		switch cvrObj.Status.Phase {
+		case cstor.CVRStatusRebuilding, cstor.CVRStatusReconstructingNewReplica:
+			rebuilding = append(rebuilding, cvrObj.Name)
+		}
+	}
+	return rebuilding, nil
 }
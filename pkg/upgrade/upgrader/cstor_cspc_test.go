@@ -0,0 +1,844 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_getMaxUnavailable(t *testing.T) {
+	intOrStr := func(s string) *intstr.IntOrString {
+		v := intstr.Parse(s)
+		return &v
+	}
+	tests := []struct {
+		name           string
+		maxUnavailable *intstr.IntOrString
+		total          int
+		want           int
+		wantErr        bool
+	}{
+		{
+			name:           "no budget",
+			maxUnavailable: nil,
+			total:          3,
+			want:           3,
+		},
+		{
+			name:           "single pool",
+			maxUnavailable: intOrStr("1"),
+			total:          1,
+			want:           1,
+		},
+		{
+			name:           "one out of three",
+			maxUnavailable: intOrStr("1"),
+			total:          3,
+			want:           1,
+		},
+		{
+			name:           "two out of three loses quorum",
+			maxUnavailable: intOrStr("2"),
+			total:          3,
+			wantErr:        true,
+		},
+		{
+			name:           "percentage rounds down",
+			maxUnavailable: intOrStr("50%"),
+			total:          5,
+			want:           2,
+		},
+		{
+			name:           "percentage allowing no pool",
+			maxUnavailable: intOrStr("10%"),
+			total:          5,
+			wantErr:        true,
+		},
+		{
+			name:           "zero",
+			maxUnavailable: intOrStr("0"),
+			total:          3,
+			wantErr:        true,
+		},
+		{name: "n=1 one at a time", maxUnavailable: intOrStr("1"), total: 1, want: 1},
+		{name: "n=1 all", maxUnavailable: intOrStr("100%"), total: 1, want: 1},
+		{name: "n=2 one at a time", maxUnavailable: intOrStr("1"), total: 2, want: 1},
+		{name: "n=2 both", maxUnavailable: intOrStr("2"), total: 2, wantErr: true},
+		{name: "n=3 one at a time", maxUnavailable: intOrStr("1"), total: 3, want: 1},
+		{name: "n=3 two", maxUnavailable: intOrStr("2"), total: 3, wantErr: true},
+		{name: "n=5 two", maxUnavailable: intOrStr("2"), total: 5, want: 2},
+		{name: "n=5 three", maxUnavailable: intOrStr("3"), total: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getMaxUnavailable(tt.maxUnavailable, tt.total)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getMaxUnavailable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("getMaxUnavailable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_waitForRebuild(t *testing.T) {
+	cspi := func(name string, provisioned, healthy int32) *cstor.CStorPoolInstance {
+		cspi := upgradetesting.NewTestCSPI(name, "cspc-a", "")
+		cspi.Status.ProvisionedReplicas = provisioned
+		cspi.Status.HealthyReplicas = healthy
+		return cspi
+	}
+	client := NewTestClient(
+		cspi("cspi-healthy", 3, 3),
+		cspi("cspi-rebuilding", 3, 2),
+	)
+	tests := []struct {
+		name     string
+		cspi     string
+		wantKind error
+	}{
+		{name: "all replicas healthy", cspi: "cspi-healthy"},
+		{name: "rebuild times out", cspi: "cspi-rebuilding", wantKind: ErrTimeout},
+		{name: "missing cspi", cspi: "cspi-missing", wantKind: ErrAPI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(
+					WithRebuildTimeout(30*time.Millisecond),
+					WithPollInterval(10*time.Millisecond),
+				)),
+				WithCSPCClient(client),
+			)
+			obj.Namespace = "openebs"
+			err := obj.waitForRebuild(tt.cspi)
+			if tt.wantKind == nil {
+				if err != nil {
+					t.Fatalf("waitForRebuild() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("waitForRebuild() error = %v, want kind %v", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_waitForUnavailableBudget(t *testing.T) {
+	cspi := func(name string, phase cstor.CStorPoolInstancePhase) *cstor.CStorPoolInstance {
+		cspi := upgradetesting.NewTestCSPI(name, "cspc-a", "")
+		cspi.Status.Phase = phase
+		return cspi
+	}
+	tests := []struct {
+		name           string
+		maxUnavailable int
+		cspis          []runtime.Object
+		wantKind       error
+	}{
+		{
+			name:           "all online",
+			maxUnavailable: 1,
+			cspis:          []runtime.Object{cspi("cspi-1", cstor.CStorPoolStatusOnline), cspi("cspi-2", cstor.CStorPoolStatusOnline)},
+		},
+		{
+			name:           "one offline within budget",
+			maxUnavailable: 2,
+			cspis:          []runtime.Object{cspi("cspi-1", cstor.CStorPoolStatusOffline), cspi("cspi-2", cstor.CStorPoolStatusOnline)},
+		},
+		{
+			name:           "offline cspi times out",
+			maxUnavailable: 1,
+			cspis:          []runtime.Object{cspi("cspi-1", cstor.CStorPoolStatusOffline), cspi("cspi-2", cstor.CStorPoolStatusOnline)},
+			wantKind:       ErrTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(
+					WithName("cspc-a"),
+					WithRebuildTimeout(30*time.Millisecond),
+					WithPollInterval(10*time.Millisecond),
+				)),
+				WithCSPCClient(NewTestClient(tt.cspis...)),
+			)
+			obj.Namespace = upgradetesting.Namespace
+			err := obj.waitForUnavailableBudget(tt.maxUnavailable)
+			if tt.wantKind == nil {
+				if err != nil {
+					t.Fatalf("waitForUnavailableBudget() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("waitForUnavailableBudget() error = %v, want kind %v", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_verifyPoolCount(t *testing.T) {
+	cspc := func(pools int, healthy int32) *cstor.CStorPoolCluster {
+		cspc := upgradetesting.NewTestCSPC("cspc-a", "")
+		cspc.Spec.Pools = make([]cstor.PoolSpec, pools)
+		cspc.Status.ProvisionedInstances = healthy
+		cspc.Status.HealthyInstances = healthy
+		return cspc
+	}
+	tests := []struct {
+		name string
+		// stale is the cspc object got while verifying the version
+		stale, latest *cstor.CStorPoolCluster
+		// deleting is the number of cspis being deleted
+		deleting int
+		wantKind error
+	}{
+		{name: "all pools healthy", stale: cspc(3, 3), latest: cspc(3, 3)},
+		{name: "pools become healthy", stale: cspc(3, 2), latest: cspc(3, 3)},
+		{name: "pool went missing", stale: cspc(3, 2), latest: cspc(3, 2), wantKind: ErrValidation},
+		{name: "cspi being deleted still healthy", stale: cspc(2, 3), latest: cspc(2, 3), deleting: 1},
+		{name: "more healthy than pools", stale: cspc(2, 3), latest: cspc(2, 3), wantKind: ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.latest)
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(
+					WithName("cspc-a"),
+					WithPollInterval(10*time.Millisecond),
+				)),
+				WithCSPCClient(client),
+			)
+			obj.Namespace = "openebs"
+			obj.CSPC = patch.NewCSPC(patch.WithCSPCClient(client.OpenebsClientset))
+			obj.CSPC.Object = tt.stale
+			err := obj.verifyPoolCount(30*time.Millisecond, tt.deleting)
+			if tt.wantKind == nil {
+				if err != nil {
+					t.Fatalf("verifyPoolCount() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("verifyPoolCount() error = %v, want kind %v", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_getCSPISkipReason(t *testing.T) {
+	deleting := upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", "2.12.0")
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	excluded := upgradetesting.NewTestCSPI("cspc-a-2", "cspc-a", "2.12.0")
+	excluded.Annotations = map[string]string{defaultSkipUpgradeAnnotation: "true"}
+	tests := []struct {
+		name string
+		cspi *cstor.CStorPoolInstance
+		want string
+	}{
+		{name: "being deleted", cspi: deleting, want: "being deleted"},
+		{name: "excluded", cspi: excluded, want: "excluded by annotation " + defaultSkipUpgradeAnnotation},
+		{name: "pending", cspi: upgradetesting.NewTestCSPI("cspc-a-3", "cspc-a", "2.12.0"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(
+					WithName("cspc-a"),
+					WithOpenebsNamespace("openebs"),
+					ToVersion("3.0.0"),
+				)),
+				WithCSPCClient(NewTestClient(tt.cspi)),
+			)
+			if got := obj.getCSPISkipReason(tt.cspi); got != tt.want {
+				t.Errorf("getCSPISkipReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_verifyCSPCReconcileWithReapply(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		// seenAfter is the number of reapplied patches after
+		// which the operator reconciles the cspc
+		seenAfter   int
+		wantPatches int
+		wantErr     bool
+	}{
+		{name: "reconciled after reapply", attempts: 3, seenAfter: 1, wantPatches: 1},
+		{name: "no reapply", attempts: 0, seenAfter: 1, wantErr: true},
+		{name: "reapply attempts exhausted", attempts: 2, seenAfter: 3, wantPatches: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the first patch set the desired version, but was missed by the operator
+			cspc := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+			cspc.VersionDetails.Desired = "3.0.0"
+			client := NewTestClient(cspc)
+			clientset := fakeOpenebs(client)
+			patches := 0
+			clientset.PrependReactor("patch", "cstorpoolclusters", func(k8stesting.Action) (bool, runtime.Object, error) {
+				patches++
+				return false, nil, nil
+			})
+			clientset.PrependReactor("get", "cstorpoolclusters", func(k8stesting.Action) (bool, runtime.Object, error) {
+				if patches < tt.seenAfter {
+					return false, nil, nil
+				}
+				obj, err := clientset.Tracker().Get(cstor.SchemeGroupVersion.WithResource("cstorpoolclusters"),
+					upgradetesting.Namespace, "cspc-a")
+				if err != nil {
+					return true, nil, err
+				}
+				reconciled := obj.(*cstor.CStorPoolCluster).DeepCopy()
+				reconciled.VersionDetails.Status.Current = reconciled.VersionDetails.Desired
+				return true, reconciled, nil
+			})
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(
+					WithName("cspc-a"),
+					FromVersion("2.12.0"),
+					ToVersion("3.0.0"),
+					WithPollInterval(5*time.Millisecond),
+					WithReconcileTimeout(20*time.Millisecond),
+					WithPatchReapplyAttempts(tt.attempts),
+				)),
+				WithCSPCClient(client),
+			)
+			obj.Namespace = upgradetesting.Namespace
+			obj.CSPC = patch.NewCSPC(patch.WithCSPCClient(clientset))
+			err := obj.verifyCSPCReconcileWithReapply()
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrTimeout)) {
+				t.Fatalf("verifyCSPCReconcileWithReapply() error = %v, want timeout error %v", err, tt.wantErr)
+			}
+			if patches != tt.wantPatches {
+				t.Errorf("verifyCSPCReconcileWithReapply() reapplied %d patches, want %d", patches, tt.wantPatches)
+			}
+			if tt.wantPatches == 0 {
+				return
+			}
+			got, _ := clientset.Tracker().Get(cstor.SchemeGroupVersion.WithResource("cstorpoolclusters"),
+				upgradetesting.Namespace, "cspc-a")
+			want := strconv.Itoa(tt.wantPatches)
+			if a := got.(*cstor.CStorPoolCluster).Annotations[patchAttemptAnnotation]; a != want {
+				t.Errorf("%s = %q, want %q", patchAttemptAnnotation, a, want)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_updateCSPIUpgradeTask(t *testing.T) {
+	backoffLimit := int32(2)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "upgrade-job", Namespace: upgradetesting.Namespace},
+		Spec:       batchv1.JobSpec{BackoffLimit: &backoffLimit},
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            "upgrade-job-x",
+		Namespace:       upgradetesting.Namespace,
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "upgrade-job"}},
+	}}
+	utask := func() *v1Alpha1API.UpgradeTask {
+		return &v1Alpha1API.UpgradeTask{
+			ObjectMeta: metav1.ObjectMeta{Name: "upgrade-cstor-cspi-cspi-1", Namespace: upgradetesting.Namespace},
+			Status:     v1Alpha1API.UpgradeTaskStatus{Phase: v1Alpha1API.UpgradeStarted, Retries: 1},
+		}
+	}
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		upgradeErr  error
+		wantErr     bool
+		wantPhase   v1Alpha1API.UpgradePhase
+		wantRetries int
+	}{
+		{
+			name:        "upgraded",
+			objects:     []runtime.Object{utask()},
+			wantPhase:   v1Alpha1API.UpgradeSuccess,
+			wantRetries: 1,
+		},
+		{
+			name:        "failed at the backoff limit",
+			objects:     []runtime.Object{utask(), pod, job},
+			upgradeErr:  errors.Errorf("failed"),
+			wantPhase:   v1Alpha1API.UpgradeError,
+			wantRetries: 2,
+		},
+		{
+			name:    "no upgradetask",
+			wantErr: true,
+		},
+		{
+			name:       "no backoff limit",
+			objects:    []runtime.Object{utask()},
+			upgradeErr: errors.Errorf("failed"),
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("POD_NAME", pod.Name)
+			client := NewTestClient(tt.objects...)
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(WithOpenebsNamespace(upgradetesting.Namespace))),
+				WithCSPCClient(client),
+			)
+			err := obj.updateCSPIUpgradeTask("cspi-1", tt.upgradeErr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateCSPIUpgradeTask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			fake := fakeOpenebs(client)
+			for _, action := range fake.Actions() {
+				if tt.wantErr && action.GetVerb() == "update" {
+					t.Errorf("updateCSPIUpgradeTask() updated the upgradetask after failing to read it")
+				}
+			}
+			if tt.wantErr {
+				return
+			}
+			got, _ := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+				Get(context.TODO(), "upgrade-cstor-cspi-cspi-1", metav1.GetOptions{})
+			if got.Status.Phase != tt.wantPhase || got.Status.Retries != tt.wantRetries {
+				t.Errorf("updateCSPIUpgradeTask() phase %s retries %d, want %s and %d",
+					got.Status.Phase, got.Status.Retries, tt.wantPhase, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_isCSPIUpgradeComplete(t *testing.T) {
+	utask := func(phase v1Alpha1API.UpgradePhase) *v1Alpha1API.UpgradeTask {
+		return &v1Alpha1API.UpgradeTask{
+			ObjectMeta: metav1.ObjectMeta{Name: "upgrade-cstor-cspi-cspi-1", Namespace: upgradetesting.Namespace},
+			Status:     v1Alpha1API.UpgradeTaskStatus{Phase: phase},
+		}
+	}
+	cspi := func(desired, current string) *cstor.CStorPoolInstance {
+		cspi := upgradetesting.NewTestCSPI("cspi-1", "cspc-a", desired)
+		cspi.VersionDetails.Status.Current = current
+		return cspi
+	}
+	deploy := func(managerTag string) *appsv1.Deployment {
+		labels := map[string]string{"openebs.io/cstor-pool-instance": "cspi-1", "openebs.io/version": "3.0.0"}
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "cspi-1", Namespace: upgradetesting.Namespace, Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"openebs.io/version": "3.0.0"}},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{
+						{Name: "cstor-pool", Image: "openebs/cstor-pool:3.0.0"},
+						{Name: poolManagerContainer, Image: "openebs/cstor-pool-manager:" + managerTag},
+					}},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		cspi    *cstor.CStorPoolInstance
+		want    bool
+	}{
+		{
+			name:    "already at the to version",
+			objects: []runtime.Object{utask(v1Alpha1API.UpgradeSuccess), deploy("3.0.0")},
+			cspi:    cspi("3.0.0", "3.0.0"),
+			want:    true,
+		},
+		{
+			name:    "current version lags the desired version",
+			objects: []runtime.Object{utask(v1Alpha1API.UpgradeSuccess), deploy("3.0.0")},
+			cspi:    cspi("3.0.0", "2.12.0"),
+		},
+		{
+			name:    "deployment image drifted",
+			objects: []runtime.Object{utask(v1Alpha1API.UpgradeSuccess), deploy("2.12.0")},
+			cspi:    cspi("3.0.0", "3.0.0"),
+		},
+		{
+			name:    "no pool deployment",
+			objects: []runtime.Object{utask(v1Alpha1API.UpgradeSuccess)},
+			cspi:    cspi("3.0.0", "3.0.0"),
+		},
+		{
+			name:    "upgradetask not successful",
+			objects: []runtime.Object{utask(v1Alpha1API.UpgradeError), deploy("3.0.0")},
+			cspi:    cspi("3.0.0", "3.0.0"),
+		},
+		{
+			name:    "no upgradetask",
+			objects: []runtime.Object{deploy("3.0.0")},
+			cspi:    cspi("3.0.0", "3.0.0"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					FromVersion("2.12.0"),
+					ToVersion("3.0.0"),
+				)),
+				WithCSPCClient(NewTestClient(tt.objects...)),
+			)
+			if got := obj.isCSPIUpgradeComplete(tt.cspi); got != tt.want {
+				t.Errorf("isCSPIUpgradeComplete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_upgradeTargetCSPI(t *testing.T) {
+	cspc := upgradetesting.NewTestCSPC("cspc-stripe", "2.12.0")
+	cspc.Labels = map[string]string{"openebs.io/version": "2.12.0"}
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cspi-upgraded",
+			Namespace: upgradetesting.Namespace,
+			Labels: map[string]string{
+				"openebs.io/cstor-pool-instance": "cspi-upgraded",
+				"openebs.io/version":             "3.0.0",
+			},
+		},
+	}
+	objs := []runtime.Object{
+		deploy,
+		testOperatorPod("cspc-operator", "3.0.0"),
+		cspc,
+		upgradetesting.NewTestCSPI("cspi-other", "cspc-mirror", "2.12.0"),
+		upgradetesting.NewTestCSPI("cspi-upgraded", "cspc-stripe", "3.0.0"),
+		upgradetesting.NewTestCSPI("cspi-pending", "cspc-stripe", "2.12.0"),
+		upgradetesting.NewTestDeployment("cspi-pending", "2.12.0",
+			map[string]string{"openebs.io/cstor-pool-instance": "cspi-pending"},
+			"openebs/cstor-pool:2.12.0"),
+	}
+	tests := []struct {
+		target     string
+		wantKind   error
+		wantStatus string
+	}{
+		{target: "cspi-other", wantKind: ErrValidation},
+		{target: "cspi-missing", wantKind: ErrValidation},
+		{target: "cspi-upgraded", wantStatus: ResultSkipped},
+		{target: "cspi-pending", wantStatus: ResultSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			client := NewTestClient(objs...)
+			reconcileOnGet(fakeOpenebs(client))
+			result := NewUpgradeResult("", upgradetesting.Namespace, "2.12.0", "3.0.0")
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(&ResourcePatch{
+					Name: "cspc-stripe", From: "2.12.0", To: "3.0.0",
+					OpenebsNamespace: upgradetesting.Namespace, TargetCSPI: tt.target, Result: result,
+					PollInterval: time.Millisecond, ReconcileTimeout: 50 * time.Millisecond,
+				}),
+				WithCSPCClient(client),
+			)
+			err := obj.Upgrade()
+			if tt.wantKind != nil {
+				if !errors.Is(err, tt.wantKind) {
+					t.Fatalf("Upgrade() error = %v, want a %v error", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Upgrade() error = %v", err)
+			}
+			if len(result.Resources) != 1 || result.Resources[0].Name != tt.target ||
+				result.Resources[0].Status != tt.wantStatus {
+				t.Errorf("Upgrade() result = %+v, want only the target cspi with %s", result.Resources, tt.wantStatus)
+			}
+			got, err := client.OpenebsClientset.CstorV1().CStorPoolClusters(upgradetesting.Namespace).
+				Get(context.TODO(), "cspc-stripe", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.VersionDetails.Desired != "2.12.0" {
+				t.Errorf("Upgrade() patched the cspc to %s with a target cspi", got.VersionDetails.Desired)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_Upgrade(t *testing.T) {
+	defer func(sa string) { cstorOperatorServiceAccount = sa }(cstorOperatorServiceAccount)
+	cspc := upgradetesting.NewTestCSPC("cspc-stripe", "2.12.0")
+	cspc.Labels = map[string]string{"openebs.io/version": "2.12.0"}
+	client := NewTestClient(
+		testOperatorPod("cspc-operator", "3.0.0"),
+		cspc,
+		upgradetesting.NewTestCSPI("cspi-upgraded", "cspc-stripe", "3.0.0"),
+		upgradetesting.NewTestCSPI("cspi-pending", "cspc-stripe", "2.12.0"),
+		upgradetesting.NewTestDeployment("cspi-upgraded", "3.0.0",
+			map[string]string{"openebs.io/cstor-pool-instance": "cspi-upgraded"},
+			"openebs/cstor-pool:3.0.0"),
+		upgradetesting.NewTestDeployment("cspi-pending", "2.12.0",
+			map[string]string{"openebs.io/cstor-pool-instance": "cspi-pending"},
+			"openebs/cstor-pool:2.12.0"),
+	)
+	reconcileOnGet(fakeOpenebs(client))
+	result := NewUpgradeResult("", upgradetesting.Namespace, "2.12.0", "3.0.0")
+	obj := NewCSPCPatch(
+		WithCSPCResorcePatch(NewResourcePatch(
+			WithName("cspc-stripe"),
+			FromVersion("2.12.0"),
+			ToVersion("3.0.0"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			WithPollInterval(time.Millisecond),
+			WithReconcileTimeout(50*time.Millisecond),
+			WithResult(result),
+		)),
+		WithCSPCClient(client),
+	)
+	if err := obj.Upgrade(); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	got, err := client.OpenebsClientset.CstorV1().CStorPoolClusters(upgradetesting.Namespace).
+		Get(context.TODO(), "cspc-stripe", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.VersionDetails.Desired != "3.0.0" {
+		t.Errorf("cspc desired version = %s, want 3.0.0", got.VersionDetails.Desired)
+	}
+	statuses := map[string]string{}
+	for _, res := range result.Resources {
+		statuses[res.Name] = res.Status
+	}
+	want := map[string]string{"cspi-upgraded": ResultSkipped, "cspi-pending": ResultSuccess}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Upgrade() result = %v, want %v", statuses, want)
+	}
+}
+
+func TestCSPCPatch_UpgradePartialFailure(t *testing.T) {
+	defer func(sa string) { cstorOperatorServiceAccount = sa }(cstorOperatorServiceAccount)
+	tests := map[string]struct {
+		pending     bool
+		wantPartial bool
+		wantMsg     string
+	}{
+		"failure after a cspi at the target version": {},
+		"failure after an upgraded cspi": {
+			pending:     true,
+			wantPartial: true,
+			wantMsg:     "upgraded 1 out of 3 cspis",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			objs := []runtime.Object{
+				testOperatorPod("cspc-operator", "3.0.0"),
+				upgradetesting.NewTestCSPC("cspc-stripe", "2.12.0"),
+				upgradetesting.NewTestCSPI("cspi-a", "cspc-stripe", "3.0.0"),
+				upgradetesting.NewTestDeployment("cspi-a", "3.0.0",
+					map[string]string{"openebs.io/cstor-pool-instance": "cspi-a"},
+					"openebs/cstor-pool:3.0.0"),
+				// the deployment of cspi-c is missing, failing its upgrade
+				upgradetesting.NewTestCSPI("cspi-c", "cspc-stripe", "2.12.0"),
+			}
+			if tt.pending {
+				objs = append(objs,
+					upgradetesting.NewTestCSPI("cspi-b", "cspc-stripe", "2.12.0"),
+					upgradetesting.NewTestDeployment("cspi-b", "2.12.0",
+						map[string]string{"openebs.io/cstor-pool-instance": "cspi-b"},
+						"openebs/cstor-pool:2.12.0"),
+				)
+			} else {
+				objs = append(objs,
+					upgradetesting.NewTestCSPI("cspi-b", "cspc-stripe", "3.0.0"),
+					upgradetesting.NewTestDeployment("cspi-b", "3.0.0",
+						map[string]string{"openebs.io/cstor-pool-instance": "cspi-b"},
+						"openebs/cstor-pool:3.0.0"),
+				)
+			}
+			client := NewTestClient(objs...)
+			reconcileOnGet(fakeOpenebs(client))
+			obj := NewCSPCPatch(
+				WithCSPCResorcePatch(NewResourcePatch(
+					WithName("cspc-stripe"),
+					FromVersion("2.12.0"),
+					ToVersion("3.0.0"),
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					WithPollInterval(time.Millisecond),
+					WithReconcileTimeout(50*time.Millisecond),
+				)),
+				WithCSPCClient(client),
+			)
+			err := obj.Upgrade()
+			if err == nil {
+				t.Fatalf("Upgrade() error = nil, want error")
+			}
+			if errors.Is(err, ErrPartialFailure) != tt.wantPartial {
+				t.Errorf("Upgrade() error = %v, want partial failure %v", err, tt.wantPartial)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Upgrade() error = %v, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestCSPCPatch_UpgradeResumedAtVerifying(t *testing.T) {
+	defer func(sa string) { cstorOperatorServiceAccount = sa }(cstorOperatorServiceAccount)
+	cspc := upgradetesting.NewTestCSPC("cspc-a", "3.0.0")
+	client := NewTestClient(
+		testOperatorPod("cspc-operator", "3.0.0"),
+		cspc,
+		newTestCSPCUpgradeTask(`{"phase":"Verifying","to":"3.0.0","completedCSPIs":["cspi-1"]}`),
+		upgradetesting.NewTestCSPI("cspi-1", "cspc-a", "3.0.0"),
+		// the stale cspi never reconciles to the target version
+		upgradetesting.NewTestCSPI("cspi-2", "cspc-a", "2.12.0"),
+	)
+	reconcileOnGet(fakeOpenebs(client))
+	obj := NewCSPCPatch(
+		WithCSPCResorcePatch(NewResourcePatch(
+			WithName("cspc-a"),
+			FromVersion("2.12.0"),
+			ToVersion("3.0.0"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			WithPollInterval(time.Millisecond),
+			WithReconcileTimeout(50*time.Millisecond),
+		)),
+		WithCSPCClient(client),
+	)
+	err := obj.Upgrade()
+	if err == nil || !strings.Contains(err.Error(), "cspi-2") {
+		t.Fatalf("Upgrade() resumed at verifying error = %v, want the stale cspi-2 to fail", err)
+	}
+	if strings.Contains(err.Error(), "cspi-1:") {
+		t.Errorf("Upgrade() resumed at verifying failed the upgraded cspi-1: %v", err)
+	}
+}
+
+func TestCSPCPatch_UpgradeNUMAAware(t *testing.T) {
+	defer func(sa string) { cstorOperatorServiceAccount = sa }(cstorOperatorServiceAccount)
+	numaNode := newTestNode("node-a", "node-a")
+	numaNode.Labels[numaNodeLabel] = "true"
+	objs := []runtime.Object{
+		testOperatorPod("cspc-operator", "3.0.0"),
+		numaNode,
+		upgradetesting.NewTestCSPC("cspc-a", "2.12.0"),
+	}
+	for name, numa := range map[string]string{"cspi-1": "0", "cspi-2": "0", "cspi-3": "1"} {
+		cspi := upgradetesting.NewTestCSPI(name, "cspc-a", "3.0.0")
+		cspi.Spec.HostName = "node-a"
+		cspi.Annotations = map[string]string{numaNodeAnnotation: numa}
+		objs = append(objs, cspi, upgradetesting.NewTestDeployment(name, "3.0.0",
+			map[string]string{"openebs.io/cstor-pool-instance": name},
+			"openebs/cstor-pool:3.0.0"))
+	}
+	client := NewTestClient(objs...)
+	reconcileOnGet(fakeOpenebs(client))
+	result := NewUpgradeResult("", upgradetesting.Namespace, "2.12.0", "3.0.0")
+	obj := NewCSPCPatch(
+		WithCSPCResorcePatch(NewResourcePatch(
+			WithName("cspc-a"),
+			FromVersion("2.12.0"),
+			ToVersion("3.0.0"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			WithPollInterval(time.Millisecond),
+			WithReconcileTimeout(50*time.Millisecond),
+			WithNUMAAware(true),
+			WithResult(result),
+		)),
+		WithCSPCClient(client),
+	)
+	if err := obj.Upgrade(); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	got := []string{}
+	for _, res := range result.Resources {
+		got = append(got, res.Name)
+	}
+	// cspi-2 shares the NUMA node of cspi-1 and is upgraded in the next batch
+	want := []string{"cspi-1", "cspi-3", "cspi-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Upgrade() order = %v, want %v", got, want)
+	}
+}
+
+func TestCSPCPatch_UpgradeWithoutFromVersion(t *testing.T) {
+	client := NewTestClient(upgradetesting.NewTestCSPC("cspc-a", ""))
+	obj := NewCSPCPatch(
+		WithCSPCResorcePatch(NewResourcePatch(
+			WithName("cspc-a"),
+			ToVersion("3.0.0"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+		)),
+		WithCSPCClient(client),
+	)
+	// the from version of a cspc without a current version can not be resolved
+	if err := obj.Upgrade(); !errors.Is(err, ErrValidation) {
+		t.Errorf("Upgrade() error = %v, want a validation error", err)
+	}
+}
+
+func TestCSPCPatch_upgradeCSPIReleasesNode(t *testing.T) {
+	cspi := upgradetesting.NewTestCSPI("cspi-1", "cspc-a", "2.12.0")
+	cspi.Spec.HostName = "node-a"
+	// the deployment of the cspi is missing, failing its upgrade
+	client := NewTestClient(cspi)
+	scheduler := NewNodeScheduler()
+	obj := NewCSPCPatch(
+		WithCSPCResorcePatch(NewResourcePatch(
+			WithName("cspc-a"),
+			FromVersion("2.12.0"),
+			ToVersion("3.0.0"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			WithNodeAwareScheduling(true),
+			WithNodeScheduler(scheduler),
+		)),
+		WithCSPCClient(client),
+	)
+	state := loadCSPCState(nil, "3.0.0", upgradetesting.Namespace, client)
+	res := *obj.ResourcePatch
+	res.Name = cspi.Name
+	completed, err := obj.upgradeCSPI(cspi, res, state, 0, 1)
+	if err == nil || completed {
+		t.Fatalf("upgradeCSPI() = %v, %v, want a failed upgrade", completed, err)
+	}
+	if len(scheduler.busy) != 0 {
+		t.Errorf("upgradeCSPI() left the nodes %v busy, want the node released", scheduler.busy)
+	}
+}
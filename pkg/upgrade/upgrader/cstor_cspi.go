@@ -18,20 +18,29 @@ package upgrader
 
 import (
 	"context"
-	"time"
+	"os"
+	"strings"
 
 	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
 	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
 	"github.com/openebs/api/v3/pkg/apis/types"
 	translate "github.com/openebs/upgrade/pkg/migrate/cstor"
 	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	"github.com/openebs/upgrade/pkg/version"
 	"github.com/pkg/errors"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 )
 
+// poolManagerContainer is the name of the cstor-pool-manager
+// sidecar container in the cstor pool deployment
+const poolManagerContainer = "cstor-pool-mgmt"
+
 // CSPIPatch is the patch required to upgrade cspi
 type CSPIPatch struct {
 	*ResourcePatch
@@ -39,6 +48,9 @@ type CSPIPatch struct {
 	Deploy    *patch.Deployment
 	CSPI      *patch.CSPI
 	Utask     *v1Alpha1API.UpgradeTask
+	// CheckCSPC verifies the version of the cspc owning the cspi
+	// before the upgrade, when the cspi is upgraded without its cspc
+	CheckCSPC bool
 	*Client
 }
 
@@ -66,6 +78,13 @@ func WithCSPIDeploy(t *patch.Deployment) CSPIPatchOptions {
 	}
 }
 
+// WithCSPICheckCSPC ...
+func WithCSPICheckCSPC(check bool) CSPIPatchOptions {
+	return func(obj *CSPIPatch) {
+		obj.CheckCSPC = check
+	}
+}
+
 // NewCSPIPatch ...
 func NewCSPIPatch(opts ...CSPIPatchOptions) *CSPIPatch {
 	obj := &CSPIPatch{}
@@ -85,11 +104,158 @@ func (obj *CSPIPatch) PreUpgrade() (string, error) {
 	if err != nil {
 		return "failed to verify cstor pool instance", err
 	}
+	if obj.CheckCSPC {
+		err = obj.checkCSPCVersion()
+		if err != nil {
+			return "failed to verify the cspc of cstor pool instance", err
+		}
+	}
+	err = CheckCSPIOverprovisioning(obj.CSPI.Object, obj.MinFreePoolSpace)
+	if err != nil {
+		if !obj.AllowOverprovisioned {
+			return "failed to verify cstor pool instance capacity", err
+		}
+		klog.Warningf("proceeding with the upgrade of overprovisioned cspi: %v", err)
+	}
+	// the pool pod is not restarted when only verifying
+	if !obj.VerifyOnly && !obj.IgnoreNodePressure {
+		node := cspiNode(obj.CSPI.Object)
+		if node != "" && obj.namespaceScoped {
+			klog.Warningf("skipping the pressure check of node %s of cspi %s in namespace scoped mode",
+				node, obj.Name)
+		} else if node != "" {
+			err = CheckNodePressure(node, obj.KubeClientset)
+			if err != nil {
+				return "failed to verify the node of cstor pool instance", err
+			}
+		}
+	}
 	return "", nil
 }
 
+// checkCSPCVersion verifies that the cspc owning the cspi is not newer
+// than the To version, as the cspi would then be left behind its cspc.
+// The cspc is usually older, as the cspis are upgraded before it, so
+// only a warning is logged that the cspi will be ahead of its cspc.
+func (obj *CSPIPatch) checkCSPCVersion() error {
+	cspcName := obj.CSPI.Object.Labels["openebs.io/cstor-pool-cluster"]
+	if cspcName == "" {
+		return errors.Errorf("cspi %s has no openebs.io/cstor-pool-cluster label", obj.Name)
+	}
+	cspcObj, err := obj.OpenebsClientset.CstorV1().CStorPoolClusters(obj.Namespace).
+		Get(context.TODO(), cspcName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get cspc %s", cspcName)
+	}
+	current := cspcObj.VersionDetails.Status.Current
+	cspcVersion, err := version.ParseVersionFlexible(current)
+	if err != nil {
+		klog.Warningf("skipping the version check of cspc %s: %v", cspcName, err)
+		return nil
+	}
+	toVersion, err := version.ParseVersionFlexible(obj.To)
+	if err != nil {
+		return err
+	}
+	cmp, err := cspcVersion.Compare(toVersion)
+	if err != nil {
+		klog.Warningf("cspi %s may not be compatible with cspc %s: %v", obj.Name, cspcName, err)
+		return nil
+	}
+	if cmp > 0 {
+		return errors.Errorf("cspc %s is at %s which is newer than the to version %s of cspi %s",
+			cspcName, current, obj.To, obj.Name)
+	}
+	if cmp < 0 {
+		klog.Warningf("cspi %s will be ahead of its cspc %s at %s, upgrade the cspc to %s once the cspi is healthy",
+			obj.Name, cspcName, current, obj.To)
+	}
+	return nil
+}
+
+// nodePressureConditions are the node conditions which can keep
+// the restarted pool pod from being scheduled on the node
+var nodePressureConditions = []corev1.NodeConditionType{
+	corev1.NodeMemoryPressure,
+	corev1.NodeDiskPressure,
+	corev1.NodePIDPressure,
+}
+
+// getNode returns the node of the given name, or else the node with
+// the given hostname as the cspis are pinned to a node by its hostname
+func getNode(nodeName string, client kubernetes.Interface) (*corev1.Node, error) {
+	nodeObj, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		nodeList, lerr := client.CoreV1().Nodes().List(context.TODO(),
+			metav1.ListOptions{LabelSelector: hostNameLabel + "=" + nodeName})
+		if lerr != nil {
+			return nil, errors.Wrapf(lerr, "failed to list nodes with hostname %s", nodeName)
+		}
+		if len(nodeList.Items) != 1 {
+			return nil, errors.Wrapf(err, "failed to get node %s", nodeName)
+		}
+		nodeObj, err = &nodeList.Items[0], nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get node %s", nodeName)
+	}
+	return nodeObj, nil
+}
+
+// CheckNodePressure returns an error if the node is under memory, disk
+// or pid pressure. The node is looked up by its hostname label if no node
+// has the given name, as the cspis are pinned to the hostname.
+func CheckNodePressure(nodeName string, client kubernetes.Interface) error {
+	nodeObj, err := getNode(nodeName, client)
+	if err != nil {
+		return err
+	}
+	pressure := []string{}
+	for _, t := range nodePressureConditions {
+		for _, c := range nodeObj.Status.Conditions {
+			if c.Type == t && c.Status == corev1.ConditionTrue {
+				pressure = append(pressure, string(t))
+			}
+		}
+	}
+	if len(pressure) != 0 {
+		return errors.Errorf("node %s has %s, the restarted pool pod may fail to schedule",
+			nodeObj.Name, strings.Join(pressure, ", "))
+	}
+	return nil
+}
+
+// CheckCSPIOverprovisioning verifies that the free space on the cspi,
+// computed as total capacity minus used capacity, is not below the given
+// percentage of the total capacity. Upgrading an overprovisioned pool can
+// trigger a pool reconstruction which fails due to lack of space.
+func CheckCSPIOverprovisioning(cspi *cstor.CStorPoolInstance, minFreePercent int) error {
+	if cspi == nil {
+		return errors.Errorf("nil cspi object")
+	}
+	total := cspi.Status.Capacity.Total.Value()
+	if total <= 0 || minFreePercent <= 0 {
+		return nil
+	}
+	free := total - cspi.Status.Capacity.Used.Value()
+	if free*100 < total*int64(minFreePercent) {
+		return errors.Errorf(
+			"cspi %s has %s free out of %s, which is below the minimum free space of %d%%",
+			cspi.Name,
+			resource.NewQuantity(free, resource.BinarySI).String(),
+			cspi.Status.Capacity.Total.String(),
+			minFreePercent,
+		)
+	}
+	return nil
+}
+
 // DeployUpgrade ...
 func (obj *CSPIPatch) DeployUpgrade() (string, error) {
+	if obj.VerifyOnly {
+		klog.Infof("skipping patch of cstor pool deployment for %s in verify only mode", obj.Name)
+		return "", nil
+	}
 	err := obj.Deploy.Patch(obj.From, obj.To)
 	if err != nil {
 		return "failed to patch cstor pool deployment", err
@@ -97,8 +263,39 @@ func (obj *CSPIPatch) DeployUpgrade() (string, error) {
 	return "", nil
 }
 
+// writeDryRunDiffs writes the diffs of the pool deployment and
+// the cspi to the DiffOutput instead of patching them
+func (obj *CSPIPatch) writeDryRunDiffs() error {
+	w, format := obj.DiffOutput, obj.DiffFormat
+	if w == nil {
+		w = os.Stdout
+	}
+	if format == "" {
+		format = DiffText
+	}
+	deployDiff, err := NewObjectDiff("deployment", obj.Deploy.Object.Name, obj.Deploy.Object, obj.Deploy.Data)
+	if err != nil {
+		return newValidationError(err)
+	}
+	cspiDiff, err := NewObjectDiff("cstorPoolInstance", obj.Name, obj.CSPI.Object, obj.CSPI.Data)
+	if err != nil {
+		return newValidationError(err)
+	}
+	for _, d := range []*ObjectDiff{deployDiff, cspiDiff} {
+		err = d.Write(w, format, obj.DiffColor)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CSPIUpgrade ...
 func (obj *CSPIPatch) CSPIUpgrade() (string, error) {
+	if obj.VerifyOnly {
+		klog.Infof("skipping patch of cspi %s in verify only mode", obj.Name)
+		return "", nil
+	}
 	err := obj.CSPI.Patch(obj.From, obj.To)
 	if err != nil {
 		return "failed to verify cstor pool instance", err
@@ -106,21 +303,38 @@ func (obj *CSPIPatch) CSPIUpgrade() (string, error) {
 	return "", nil
 }
 
+// dryRun writes the diffs of the upgrade of the cspi once it passes
+// the pre-upgrade checks, without creating or updating its upgradetask
+func (obj *CSPIPatch) dryRun() error {
+	msg, err := obj.Init()
+	if err != nil {
+		return newAPIError(errors.Wrap(err, msg))
+	}
+	msg, err = obj.PreUpgrade()
+	if err != nil {
+		return newValidationError(errors.Wrap(err, msg))
+	}
+	return obj.writeDryRunDiffs()
+}
+
 // Upgrade execute the steps to upgrade cspi
 func (obj *CSPIPatch) Upgrade() error {
+	if obj.DryRun {
+		return obj.dryRun()
+	}
 	var err, uerr error
 	obj.Utask, err = getOrCreateUpgradeTask(
 		"cstorPoolInstance",
 		obj.ResourcePatch,
 		obj.Client,
 	)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj := v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.PreUpgrade}
 	statusObj.Phase = v1Alpha1API.StepWaiting
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj.Phase = v1Alpha1API.StepErrored
@@ -129,81 +343,113 @@ func (obj *CSPIPatch) Upgrade() error {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newAPIError(errors.Wrap(err, msg))
 	}
 	msg, err = obj.PreUpgrade()
 	if err != nil {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newValidationError(errors.Wrap(err, msg))
 	}
+	before := obj.CSPI.Object.DeepCopy()
 	statusObj.Phase = v1Alpha1API.StepCompleted
 	statusObj.Message = "Pre-upgrade steps were successful"
 	statusObj.Reason = ""
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 
 	statusObj = v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.PoolInstanceUpgrade}
 	statusObj.Phase = v1Alpha1API.StepWaiting
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj.Phase = v1Alpha1API.StepErrored
-	msg, err = obj.DeployUpgrade()
+	msg, err = obj.upgradeLVMPool()
 	if err != nil {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
 		return errors.Wrap(err, msg)
 	}
+	msg, err = obj.DeployUpgrade()
+	if err != nil {
+		statusObj.Message = msg
+		statusObj.Reason = err.Error()
+		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
+		if uerr != nil && obj.IsUpgradeTaskJob() {
+			return uerr
+		}
+		return newAPIError(errors.Wrap(err, msg))
+	}
 	msg, err = obj.CSPIUpgrade()
 	if err != nil {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newAPIError(errors.Wrap(err, msg))
 	}
 	msg, err = obj.verifyCSPIVersionReconcile()
 	if err != nil {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newAPIError(errors.Wrap(err, msg))
+	}
+	verifySpecChecksum("cspi", obj.Name, before, obj.CSPI.Object)
+	msg, err = obj.verifyBlockDevices()
+	if err != nil {
+		statusObj.Message = msg
+		statusObj.Reason = err.Error()
+		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
+		if uerr != nil && obj.IsUpgradeTaskJob() {
+			return uerr
+		}
+		return newValidationError(errors.Wrap(err, msg))
+	}
+	msg, err = obj.verifyPodImages()
+	if err != nil {
+		statusObj.Message = msg
+		statusObj.Reason = err.Error()
+		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
+		if uerr != nil && obj.IsUpgradeTaskJob() {
+			return uerr
+		}
+		return newValidationError(errors.Wrap(err, msg))
 	}
 	msg, err = obj.upgradeBackupRestore()
 	if err != nil {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newAPIError(errors.Wrap(err, msg))
 	}
 	statusObj.Phase = v1Alpha1API.StepCompleted
 	statusObj.Message = "Pool instance upgrade was successful"
 	statusObj.Reason = ""
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	return nil
@@ -230,6 +476,14 @@ func (obj *CSPIPatch) Init() (string, error) {
 	if err != nil {
 		return "failed to get cstor pool instance", err
 	}
+	err = obj.resolveFromVersion("cspi", obj.Name, obj.CSPI.Object.VersionDetails.Status.Current,
+		obj.CSPI.Object.Annotations)
+	if err != nil {
+		return "failed to get the version of cstor pool instance", err
+	}
+	if obj.VerifyOnly {
+		return "", nil
+	}
 	err = getCSPIDeployPatchData(obj)
 	if err != nil {
 		return "failed to create cstor pool deployment patch", err
@@ -259,10 +513,7 @@ func transformCSPIDeploy(d *appsv1.Deployment, res *ResourcePatch) error {
 	}
 	cons := len(d.Spec.Template.Spec.Containers)
 	for i := 0; i < cons; i++ {
-		url, err := getImageURL(
-			d.Spec.Template.Spec.Containers[i].Image,
-			res.BaseURL,
-		)
+		url, err := res.imageURL(d.Spec.Template.Spec.Containers[i].Image)
 		if err != nil {
 			return err
 		}
@@ -270,6 +521,20 @@ func transformCSPIDeploy(d *appsv1.Deployment, res *ResourcePatch) error {
 		url = removeSuffixFromEnd(url, "-amd64")
 		d.Spec.Template.Spec.Containers[i].Image = url + ":" + tag
 	}
+	if res.PoolManagerImage != "" {
+		overridden := false
+		for i, c := range d.Spec.Template.Spec.Containers {
+			if c.Name == poolManagerContainer {
+				d.Spec.Template.Spec.Containers[i].Image = res.PoolManagerImage
+				overridden = true
+			}
+		}
+		// the pool deployments of older clusters may not have the sidecar
+		if !overridden {
+			klog.Warningf("deployment %s has no %s container, ignoring the pool manager image %s",
+				d.Name, poolManagerContainer, res.PoolManagerImage)
+		}
+	}
 	d.Labels["openebs.io/version"] = res.To
 	d.Spec.Template.Labels["openebs.io/version"] = res.To
 	d.Spec.Template.Spec.ServiceAccountName = cstorOperatorServiceAccount
@@ -282,7 +547,8 @@ func getCSPIPatchData(obj *CSPIPatch) error {
 	if err != nil {
 		return err
 	}
-	obj.CSPI.Data, err = GetPatchData(obj.CSPI.Object, newCSPI)
+	obj.CSPI.Data, err = GetPatchData(obj.CSPI.Object, newCSPI,
+		IncludePatchFields("metadata.labels", "versionDetails"))
 	return err
 }
 
@@ -293,28 +559,71 @@ func transformCSPI(c *cstor.CStorPoolInstance, res *ResourcePatch) error {
 }
 
 func (obj *CSPIPatch) verifyCSPIVersionReconcile() (string, error) {
-	// get the latest cspi object
-	err := obj.CSPI.Get(obj.Name, obj.Namespace)
-	if err != nil {
-		return "failed to get cstor pool to verify ", err
-	}
-	// waiting for the current version to be equal to desired version
-	for obj.CSPI.Object.VersionDetails.Status.Current != obj.To {
-		klog.Infof("Verifying the reconciliation of version for %s", obj.CSPI.Object.Name)
-		// Sleep equal to the default sync time
-		time.Sleep(10 * time.Second)
-		err = obj.CSPI.Get(obj.Name, obj.Namespace)
+	err := obj.verifyVersionReconcile(obj.Name, func() (ReconcileStatus, error) {
+		err := obj.CSPI.Get(obj.Name, obj.Namespace)
 		if err != nil {
-			return "failed to get cstor pool to verify ", err
-		}
-		if obj.CSPI.Object.VersionDetails.Status.Message != "" {
-			klog.Errorf("failed to reconcile: %s", obj.CSPI.Object.VersionDetails.Status.Reason)
+			return ReconcileStatus{}, errors.Wrap(err, "failed to get cstor pool to verify")
 		}
+		status := obj.CSPI.Object.VersionDetails.Status
+		return ReconcileStatus{status.Current, status.Message, status.Reason, obj.CSPI.Object}, nil
+	})
+	if err != nil {
+		return "failed to verify cstor pool version reconciliation", err
+	}
+	return "", nil
+}
+
+// upgradeLVMPool applies the settings of the lvm thin pool on the
+// node of the cspi before its pool is restarted, if LVMThinPool is set
+func (obj *CSPIPatch) upgradeLVMPool() (string, error) {
+	if obj.LVMThinPool == "" {
+		return "", nil
+	}
+	err := NewLVMPoolPatch(
+		WithLVMPoolResorcePatch(obj.ResourcePatch),
+		WithLVMPoolClient(obj.Client),
+		WithLVMPoolNode(obj.CSPI.Object.Spec.HostName),
+	).Upgrade()
+	if err != nil {
+		return "failed to update the lvm thin pool of cspi", err
+	}
+	return "", nil
+}
+
+// verifyBlockDevices checks the blockdevices of the upgraded
+// cspi are still claimed by it, if VerifyBlockDevices is set
+func (obj *CSPIPatch) verifyBlockDevices() (string, error) {
+	if !obj.VerifyBlockDevices {
+		return "", nil
+	}
+	err := CheckCSPIBlockDevices(obj.CSPI.Object, obj.OpenebsClientset)
+	if err != nil {
+		return "failed to verify the blockdevices of cspi", err
+	}
+	return "", nil
+}
+
+// verifyPodImages checks the pool pods of the upgraded cspi run
+// the images of the to version, if VerifyPodImages is set
+func (obj *CSPIPatch) verifyPodImages() (string, error) {
+	if !obj.VerifyPodImages {
+		return "", nil
+	}
+	images, err := cspiTargetImages(obj.Deploy.Object, obj.ResourcePatch)
+	if err != nil {
+		return "failed to get the images of the cstor pool deployment", err
+	}
+	err = CheckCSPIPodImages(obj.Name, obj.Namespace, images, obj.KubeClientset)
+	if err != nil {
+		return "failed to verify the pod images of cspi", err
 	}
 	return "", nil
 }
 
 func (obj *CSPIPatch) upgradeBackupRestore() (string, error) {
+	if obj.VerifyOnly {
+		return "", nil
+	}
 	// Migrate backup to v1 version
 	oldBackupList, err := obj.OpenebsClientset.OpenebsV1alpha1().
 		CStorBackups(obj.OpenebsNamespace).List(context.TODO(), metav1.ListOptions{
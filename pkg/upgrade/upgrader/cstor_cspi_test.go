@@ -0,0 +1,433 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/api/v3/pkg/apis/types"
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestNode(name, hostname string, conditions ...corev1.NodeCondition) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{hostNameLabel: hostname},
+		},
+		Status: corev1.NodeStatus{Conditions: conditions},
+	}
+}
+
+func TestCheckNodePressure(t *testing.T) {
+	ready := corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionTrue}
+	noMemoryPressure := corev1.NodeCondition{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse}
+	tests := []struct {
+		name     string
+		nodeName string
+		node     *corev1.Node
+		wantErr  string
+	}{
+		{
+			name:     "no pressure",
+			nodeName: "node-1",
+			node:     newTestNode("node-1", "node-1", ready, noMemoryPressure),
+		},
+		{
+			name:     "disk and pid pressure",
+			nodeName: "node-1",
+			node: newTestNode("node-1", "node-1", ready,
+				corev1.NodeCondition{Type: corev1.NodeDiskPressure, Status: corev1.ConditionTrue},
+				corev1.NodeCondition{Type: corev1.NodePIDPressure, Status: corev1.ConditionTrue},
+			),
+			wantErr: "DiskPressure, PIDPressure",
+		},
+		{
+			name:     "node found by hostname",
+			nodeName: "host-1",
+			node: newTestNode("node-1.example.com", "host-1",
+				corev1.NodeCondition{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue},
+			),
+			wantErr: "node node-1.example.com has MemoryPressure",
+		},
+		{
+			name:     "node not found",
+			nodeName: "node-2",
+			node:     newTestNode("node-1", "node-1", ready),
+			wantErr:  "failed to get node node-2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckNodePressure(tt.nodeName, NewTestClient(tt.node).KubeClientset)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckNodePressure() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckNodePressure() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func poolDeployment(containers ...corev1.Container) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "cspc-stripe-b9f6",
+			Labels: map[string]string{},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{}},
+				Spec:       corev1.PodSpec{Containers: containers},
+			},
+		},
+	}
+}
+
+func Test_transformCSPIDeploy(t *testing.T) {
+	tests := []struct {
+		name             string
+		deploy           *appsv1.Deployment
+		poolManagerImage string
+		want             []string
+	}{
+		{
+			name: "with the pool manager",
+			deploy: poolDeployment(
+				corev1.Container{Name: "cstor-pool", Image: "openebs/cstor-pool:2.12.0"},
+				corev1.Container{Name: poolManagerContainer, Image: "openebs/cstor-pool-manager:2.12.0"},
+			),
+			want: []string{"openebs/cstor-pool:3.0.0", "openebs/cstor-pool-manager:3.0.0"},
+		},
+		{
+			name: "pool manager image override",
+			deploy: poolDeployment(
+				corev1.Container{Name: "cstor-pool", Image: "openebs/cstor-pool:2.12.0"},
+				corev1.Container{Name: poolManagerContainer, Image: "openebs/cstor-pool-manager-amd64:2.12.0"},
+			),
+			poolManagerImage: "registry.local/openebs/cstor-pool-manager:3.0.0-fix1",
+			want:             []string{"openebs/cstor-pool:3.0.0", "registry.local/openebs/cstor-pool-manager:3.0.0-fix1"},
+		},
+		{
+			name: "override without the pool manager",
+			deploy: poolDeployment(
+				corev1.Container{Name: "cstor-pool", Image: "openebs/cstor-pool:2.12.0"},
+			),
+			poolManagerImage: "registry.local/openebs/cstor-pool-manager:3.0.0-fix1",
+			want:             []string{"openebs/cstor-pool:3.0.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourcePatch{To: "3.0.0", PoolManagerImage: tt.poolManagerImage}
+			err := transformCSPIDeploy(tt.deploy, r)
+			if err != nil {
+				t.Fatalf("transformCSPIDeploy() error = %v", err)
+			}
+			got := []string{}
+			for _, c := range tt.deploy.Spec.Template.Spec.Containers {
+				got = append(got, c.Image)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transformCSPIDeploy() images = %v, want %v", got, tt.want)
+			}
+			if tt.deploy.Labels["openebs.io/version"] != "3.0.0" {
+				t.Errorf("transformCSPIDeploy() version label = %s", tt.deploy.Labels["openebs.io/version"])
+			}
+		})
+	}
+}
+
+func TestCheckCSPIOverprovisioning(t *testing.T) {
+	tests := []struct {
+		name        string
+		total, used string
+		allow       bool
+		wantErr     bool
+	}{
+		{name: "under provisioned", total: "100Gi", used: "50Gi"},
+		{name: "over provisioned", total: "100Gi", used: "95Gi", wantErr: true},
+		{name: "at the threshold", total: "100Gi", used: "90Gi"},
+		{name: "just over the threshold", total: "100Gi", used: "90.5Gi", wantErr: true},
+		{name: "over provisioned allowed", total: "100Gi", used: "95Gi", allow: true, wantErr: true},
+		{name: "no capacity reported", total: "0", used: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cspi := upgradetesting.NewTestCSPI("cspi-1", "cspc-a", "2.12.0")
+			cspi.Status.Capacity.Total = resource.MustParse(tt.total)
+			cspi.Status.Capacity.Used = resource.MustParse(tt.used)
+			client := NewTestClient(cspi)
+			got, err := client.OpenebsClientset.CstorV1().CStorPoolInstances(upgradetesting.Namespace).
+				Get(context.TODO(), "cspi-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 10% of the pool needs to be free
+			err = CheckCSPIOverprovisioning(got, 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckCSPIOverprovisioning() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			report, err := PreFlight("cstorPoolInstance", NewResourcePatch(
+				WithName("cspi-1"),
+				WithOpenebsNamespace(upgradetesting.Namespace),
+				FromVersion("2.12.0"),
+				ToVersion("3.0.0"),
+				WithMinFreePoolSpace(10),
+				WithAllowOverprovisioned(tt.allow),
+			), client)
+			if err != nil {
+				t.Fatalf("PreFlight() error = %v", err)
+			}
+			var severity Severity
+			for _, f := range report.Findings {
+				if f.Code == CodePoolOverprovisioned {
+					severity = f.Severity
+				}
+			}
+			want := Severity("")
+			switch {
+			case tt.wantErr && tt.allow:
+				want = SeverityWarning
+			case tt.wantErr:
+				want = SeverityBlocker
+			}
+			if severity != want {
+				t.Errorf("PreFlight() overprovisioned severity = %q, want %q", severity, want)
+			}
+		})
+	}
+}
+
+func TestCSPIPatch_checkCSPCVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		cspcVersion string
+		noLabel     bool
+		noCSPC      bool
+		wantErr     bool
+	}{
+		{name: "cspc at from version", cspcVersion: "2.12.0"},
+		{name: "cspc at to version", cspcVersion: "3.0.0"},
+		{name: "cspc newer than to version", cspcVersion: "3.1.0", wantErr: true},
+		{name: "cspc of a custom build", cspcVersion: "ci-1234"},
+		{name: "cspc without version", cspcVersion: ""},
+		{name: "cspi without cspc label", cspcVersion: "2.12.0", noLabel: true, wantErr: true},
+		{name: "missing cspc", cspcVersion: "2.12.0", noCSPC: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cspi := upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", "2.12.0")
+			if tt.noLabel {
+				delete(cspi.Labels, "openebs.io/cstor-pool-cluster")
+			}
+			objs := []runtime.Object{cspi}
+			if !tt.noCSPC {
+				objs = append(objs, upgradetesting.NewTestCSPC("cspc-a", tt.cspcVersion))
+			}
+			obj := NewCSPIPatch(
+				WithCSPIResorcePatch(NewResourcePatch(
+					WithName("cspc-a-1"),
+					FromVersion("2.12.0"),
+					ToVersion("3.0.0"),
+				)),
+				WithCSPIClient(NewTestClient(objs...)),
+				WithCSPICheckCSPC(true),
+			)
+			obj.Namespace = upgradetesting.Namespace
+			obj.CSPI = patch.NewCSPI(patch.WithCSPIClient(obj.OpenebsClientset))
+			obj.CSPI.Object = cspi
+			err := obj.checkCSPCVersion()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkCSPCVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCSPIPatch_Upgrade(t *testing.T) {
+	defer func(sa string) { cstorOperatorServiceAccount = sa }(cstorOperatorServiceAccount)
+	cstorOperatorServiceAccount = "openebs-cstor-operator"
+	tests := []struct {
+		name        string
+		version     string
+		dryRun      bool
+		verifyOnly  bool
+		wantVersion string
+		wantImage   string
+	}{
+		{name: "upgraded", version: "2.12.0", wantVersion: "3.0.0", wantImage: "openebs/cstor-pool:3.0.0"},
+		{name: "dry run", version: "2.12.0", dryRun: true, wantVersion: "2.12.0", wantImage: "openebs/cstor-pool:2.12.0"},
+		{name: "verify only", version: "3.0.0", verifyOnly: true, wantVersion: "3.0.0", wantImage: "openebs/cstor-pool:3.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(
+				upgradetesting.NewTestCSPI("cspi-1", "cspc-a", tt.version),
+				upgradetesting.NewTestDeployment("cspi-1", tt.version,
+					map[string]string{"openebs.io/cstor-pool-instance": "cspi-1"},
+					"openebs/cstor-pool:"+tt.version, "openebs/cstor-pool-manager:"+tt.version),
+			)
+			reconcileOnGet(fakeOpenebs(client))
+			diffs := &bytes.Buffer{}
+			obj := NewCSPIPatch(
+				WithCSPIResorcePatch(NewResourcePatch(
+					WithName("cspi-1"),
+					FromVersion("2.12.0"),
+					ToVersion("3.0.0"),
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					WithPollInterval(time.Millisecond),
+					WithReconcileTimeout(50*time.Millisecond),
+					WithDryRun(tt.dryRun),
+					WithDiffOutput(diffs, DiffText, false),
+					WithVerifyOnly(tt.verifyOnly),
+				)),
+				WithCSPIClient(client),
+			)
+			if err := obj.Upgrade(); err != nil {
+				t.Fatalf("Upgrade() error = %v", err)
+			}
+			cspi, err := client.OpenebsClientset.CstorV1().CStorPoolInstances(upgradetesting.Namespace).
+				Get(context.TODO(), "cspi-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cspi.Labels["openebs.io/version"] != tt.wantVersion {
+				t.Errorf("cspi version = %s, want %s", cspi.Labels["openebs.io/version"], tt.wantVersion)
+			}
+			deploy, err := client.KubeClientset.AppsV1().Deployments(upgradetesting.Namespace).
+				Get(context.TODO(), "cspi-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image := deploy.Spec.Template.Spec.Containers[0].Image; image != tt.wantImage {
+				t.Errorf("pool image = %s, want %s", image, tt.wantImage)
+			}
+			if tt.dryRun && !strings.Contains(diffs.String(), "openebs/cstor-pool:3.0.0") {
+				t.Errorf("dry run diffs = %q, want the image of the to version", diffs.String())
+			}
+			utasks, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+				List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.dryRun && len(utasks.Items) != 0 {
+				t.Errorf("dry run created %d upgradetasks, want none", len(utasks.Items))
+			}
+		})
+	}
+}
+
+func TestCSPIPatch_Upgrade_verifyPods(t *testing.T) {
+	defer func(sa string) { cstorOperatorServiceAccount = sa }(cstorOperatorServiceAccount)
+	cstorOperatorServiceAccount = "openebs-cstor-operator"
+	tests := []struct {
+		name     string
+		podImage string
+		wantErr  bool
+	}{
+		{name: "pod on the upgraded image", podImage: "openebs/cstor-pool:3.0.0"},
+		{name: "pod on the old image", podImage: "openebs/cstor-pool:2.12.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{"openebs.io/cstor-pool-instance": "cspi-1"}
+			client := NewTestClient(
+				upgradetesting.NewTestCSPI("cspi-1", "cspc-a", "2.12.0"),
+				upgradetesting.NewTestDeployment("cspi-1", "2.12.0", labels, "openebs/cstor-pool:2.12.0"),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "cspi-1-0", Namespace: upgradetesting.Namespace, Labels: labels},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{
+						{Name: "container-0", Image: tt.podImage},
+					}},
+				},
+			)
+			reconcileOnGet(fakeOpenebs(client))
+			obj := NewCSPIPatch(
+				WithCSPIResorcePatch(NewResourcePatch(
+					WithName("cspi-1"),
+					FromVersion("2.12.0"),
+					ToVersion("3.0.0"),
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					WithPollInterval(time.Millisecond),
+					WithReconcileTimeout(50*time.Millisecond),
+					WithVerifyBlockDevices(true),
+					WithVerifyPodImages(true),
+				)),
+				WithCSPIClient(client),
+			)
+			err := obj.Upgrade()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Upgrade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCSPIPatch_upgradeBackupRestore(t *testing.T) {
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:      name,
+			Namespace: upgradetesting.Namespace,
+			Labels:    map[string]string{types.CStorPoolInstanceNameLabelKey: "cspi-1"},
+		}
+	}
+	client := NewTestClient(
+		&v1Alpha1API.CStorBackup{ObjectMeta: meta("backup-1")},
+		&v1Alpha1API.CStorRestore{ObjectMeta: meta("restore-1")},
+		&v1Alpha1API.CStorCompletedBackup{ObjectMeta: meta("completed-1")},
+	)
+	obj := NewCSPIPatch(
+		WithCSPIResorcePatch(NewResourcePatch(
+			WithName("cspi-1"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+		)),
+		WithCSPIClient(client),
+	)
+	if msg, err := obj.upgradeBackupRestore(); err != nil {
+		t.Fatalf("upgradeBackupRestore() error = %s: %v", msg, err)
+	}
+	cstorClient := client.OpenebsClientset.CstorV1()
+	if _, err := cstorClient.CStorBackups(upgradetesting.Namespace).
+		Get(context.TODO(), "backup-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("v1 cstorbackup not created: %v", err)
+	}
+	if _, err := cstorClient.CStorRestores(upgradetesting.Namespace).
+		Get(context.TODO(), "restore-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("v1 cstorrestore not created: %v", err)
+	}
+	if _, err := cstorClient.CStorCompletedBackups(upgradetesting.Namespace).
+		Get(context.TODO(), "completed-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("v1 cstorcompletedbackup not created: %v", err)
+	}
+}
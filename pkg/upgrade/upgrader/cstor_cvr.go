@@ -18,7 +18,6 @@ package upgrader
 
 import (
 	"context"
-	"time"
 
 	apis "github.com/openebs/api/v3/pkg/apis/cstor/v1"
 	"github.com/openebs/upgrade/pkg/upgrade/patch"
@@ -73,6 +72,10 @@ func (obj *CVRPatch) PreUpgrade() error {
 
 // CVRUpgrade ...
 func (obj *CVRPatch) CVRUpgrade() error {
+	if obj.VerifyOnly {
+		klog.Infof("skipping patch of cvr %s in verify only mode", obj.Name)
+		return nil
+	}
 	err := obj.CVR.Patch(obj.From, obj.To)
 	if err != nil {
 		return err
@@ -84,18 +87,18 @@ func (obj *CVRPatch) CVRUpgrade() error {
 func (obj *CVRPatch) Upgrade() error {
 	err := obj.Init()
 	if err != nil {
-		return err
+		return newAPIError(err)
 	}
 	err = obj.PreUpgrade()
 	if err != nil {
-		return err
+		return newValidationError(err)
 	}
 	err = obj.CVRUpgrade()
 	if err != nil {
-		return err
+		return newAPIError(err)
 	}
 	err = obj.verifyCVRVersionReconcile()
-	return err
+	return newAPIError(err)
 }
 
 // Init initializes all the fields of the CVRPatch
@@ -108,6 +111,9 @@ func (obj *CVRPatch) Init() error {
 	if err != nil {
 		return err
 	}
+	if obj.VerifyOnly {
+		return nil
+	}
 	err = getCVRPatchData(obj)
 	return err
 }
@@ -118,7 +124,8 @@ func getCVRPatchData(obj *CVRPatch) error {
 	if err != nil {
 		return err
 	}
-	obj.CVR.Data, err = GetPatchData(obj.CVR.Object, newCVR)
+	obj.CVR.Data, err = GetPatchData(obj.CVR.Object, newCVR,
+		IncludePatchFields("metadata.labels", "versionDetails"))
 	return err
 }
 
@@ -129,25 +136,14 @@ func transformCVR(c *apis.CStorVolumeReplica, res *ResourcePatch) error {
 }
 
 func (obj *CVRPatch) verifyCVRVersionReconcile() error {
-	// get the latest cvr object
-	err := obj.CVR.Get(obj.Name, obj.Namespace)
-	if err != nil {
-		return err
-	}
-	// waiting for the current version to be equal to desired version
-	for obj.CVR.Object.VersionDetails.Status.Current != obj.To {
-		klog.Infof("Verifying the reconciliation of version for %s", obj.CVR.Object.Name)
-		// Sleep equal to the default sync time
-		time.Sleep(10 * time.Second)
-		err = obj.CVR.Get(obj.Name, obj.Namespace)
+	return obj.verifyVersionReconcile(obj.Name, func() (ReconcileStatus, error) {
+		err := obj.CVR.Get(obj.Name, obj.Namespace)
 		if err != nil {
-			return err
+			return ReconcileStatus{}, err
 		}
-		if obj.CVR.Object.VersionDetails.Status.Message != "" {
-			klog.Errorf("failed to reconcile: %s", obj.CVR.Object.VersionDetails.Status.Reason)
-		}
-	}
-	return nil
+		status := obj.CVR.Object.VersionDetails.Status
+		return ReconcileStatus{status.Current, status.Message, status.Reason, obj.CVR.Object}, nil
+	})
 }
 
 func (obj *CVRPatch) verifyCSPIVersion() error {
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCVRPatch_Upgrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		cvrVersion  string
+		cspiVersion string
+		cspiLabel   string
+		verifyOnly  bool
+		wantErr     bool
+		wantDesired string
+	}{
+		{name: "upgraded", cvrVersion: "2.12.0", cspiVersion: "3.0.0", cspiLabel: "cspi-1", wantDesired: "3.0.0"},
+		{name: "verify only", cvrVersion: "3.0.0", cspiVersion: "3.0.0", cspiLabel: "cspi-1", verifyOnly: true, wantDesired: "3.0.0"},
+		{name: "cspi not upgraded", cvrVersion: "2.12.0", cspiVersion: "2.12.0", cspiLabel: "cspi-1", wantErr: true, wantDesired: "2.12.0"},
+		{name: "no cspi label", cvrVersion: "2.12.0", cspiVersion: "3.0.0", wantErr: true, wantDesired: "2.12.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cvr := upgradetesting.NewTestCVR("pvc-1-cspi-1", "pvc-1", tt.cspiLabel, tt.cvrVersion)
+			if tt.cspiLabel == "" {
+				delete(cvr.Labels, "cstorpoolinstance.openebs.io/name")
+			}
+			client := NewTestClient(
+				upgradetesting.NewTestCSPI("cspi-1", "cspc-a", tt.cspiVersion),
+				cvr,
+			)
+			clientset := fakeOpenebs(client)
+			reconcileOnGet(clientset)
+			obj := NewCVRPatch(
+				WithCVRResorcePatch(NewResourcePatch(
+					WithName("pvc-1-cspi-1"),
+					FromVersion("2.12.0"),
+					ToVersion("3.0.0"),
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					WithPollInterval(time.Millisecond),
+					WithReconcileTimeout(time.Second),
+					WithVerifyOnly(tt.verifyOnly),
+				)),
+				WithCVRClient(client),
+			)
+			err := obj.Upgrade()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Upgrade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := clientset.CstorV1().CStorVolumeReplicas(upgradetesting.Namespace).
+				Get(context.TODO(), "pvc-1-cspi-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.VersionDetails.Desired != tt.wantDesired {
+				t.Errorf("desired version = %s, want %s", got.VersionDetails.Desired, tt.wantDesired)
+			}
+		})
+	}
+}
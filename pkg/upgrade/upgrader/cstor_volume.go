@@ -18,7 +18,6 @@ package upgrader
 
 import (
 	"context"
-	"time"
 
 	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
 	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
@@ -27,6 +26,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 )
 
@@ -70,7 +70,8 @@ func NewCStorVolumePatch(opts ...CStorVolumePatchOptions) *CStorVolumePatch {
 
 // PreUpgrade ...
 func (obj *CStorVolumePatch) PreUpgrade() (string, error) {
-	err := isOperatorUpgraded("cvc-operator", obj.Namespace, obj.To, obj.KubeClientset)
+	err := isOperatorUpgraded("cvc-operator", obj.Namespace, obj.To,
+		obj.operatorVersionLabel(), obj.KubeClientset)
 	if err != nil {
 		return "failed to verify cvc-operator", err
 	}
@@ -90,9 +91,105 @@ func (obj *CStorVolumePatch) PreUpgrade() (string, error) {
 	if err != nil {
 		return "failed to verify target svc", err
 	}
+	obj.checkParentVersion()
+	// the i/o is not disrupted when only verifying, and the pv
+	// and the pods of the pvc cannot be read in namespace scoped mode
+	if obj.namespaceScoped {
+		klog.Warningf("skipping the usage check of volume %s in namespace scoped mode", obj.Name)
+	} else if !obj.VerifyOnly {
+		err = obj.checkVolumeInUse()
+		if err != nil {
+			return "failed to verify the usage of the volume", err
+		}
+	}
+	if !obj.VerifyOnly {
+		err = obj.checkHealthyReplicas()
+		if err != nil {
+			return "failed to verify the replicas of the volume", err
+		}
+		err = obj.handlePendingResize()
+		if err != nil {
+			return "failed to verify the pending resize of the volume", err
+		}
+	}
 	return "", nil
 }
 
+// claimName returns the name of the pvc of the volume from the pv, or in
+// namespace scoped mode from the cvc, cv or target deployment as the pvs
+// are cluster scoped
+func (obj *CStorVolumePatch) claimName() (string, error) {
+	if obj.namespaceScoped {
+		for _, claim := range []string{
+			obj.CVC.Object.Annotations["openebs.io/persistent-volume-claim"],
+			obj.CV.Object.Labels["openebs.io/persistent-volume-claim"],
+			obj.Deploy.Object.Labels["openebs.io/persistent-volume-claim"],
+		} {
+			if claim != "" {
+				return claim, nil
+			}
+		}
+		return "", errors.Errorf("failed to find the pvc of volume %s in namespace scoped mode", obj.Name)
+	}
+	pvObj, err := obj.KubeClientset.CoreV1().PersistentVolumes().
+		Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", newObjectError("get", "pv", "", obj.Name, err)
+	}
+	if pvObj.Spec.ClaimRef == nil {
+		return "", errors.Errorf("pv %s is not bound to a pvc", obj.Name)
+	}
+	return pvObj.Spec.ClaimRef.Name, nil
+}
+
+// checkVolumeInUse returns an error if the pvc bound to the volume is used
+// by a running pod, unless the upgrade of volumes in use is allowed
+func (obj *CStorVolumePatch) checkVolumeInUse() error {
+	pvObj, err := obj.KubeClientset.CoreV1().PersistentVolumes().
+		Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get pv %s", obj.Name)
+	}
+	ref := pvObj.Spec.ClaimRef
+	if ref == nil {
+		return nil
+	}
+	inUse, err := CheckPVCInUse(ref.Name, ref.Namespace, obj.KubeClientset)
+	if err != nil {
+		return err
+	}
+	if !inUse {
+		return nil
+	}
+	if obj.AllowInUseUpgrades {
+		klog.Warningf("pvc %s/%s of volume %s is in use by a running pod, the i/o may fail during the upgrade",
+			ref.Namespace, ref.Name, obj.Name)
+		return nil
+	}
+	return errors.Errorf("pvc %s/%s of volume %s is in use by a running pod", ref.Namespace, ref.Name, obj.Name)
+}
+
+// CheckPVCInUse returns true if any running pod in the namespace
+// mounts the given pvc
+func CheckPVCInUse(pvcName, namespace string, client kubernetes.Interface) (bool, error) {
+	podList, err := client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list pods in %s", namespace)
+	}
+	for _, podObj := range podList.Items {
+		if podObj.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, v := range podObj.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == pvcName {
+				klog.Infof("pvc %s/%s is used by pod %s", namespace, pvcName, podObj.Name)
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 // Init initializes all the fields of the CStorVolumePatch
 func (obj *CStorVolumePatch) Init() (string, error) {
 	label := "openebs.io/persistent-volume=" + obj.Name
@@ -111,6 +208,12 @@ func (obj *CStorVolumePatch) Init() (string, error) {
 	if err != nil {
 		return "failed to get CV for volume" + obj.Name, err
 	}
+	// the annotations of the volume are set on its cvc
+	err = obj.resolveFromVersion("cv", obj.Name, obj.CV.Object.VersionDetails.Status.Current,
+		obj.CVC.Object.Annotations)
+	if err != nil {
+		return "failed to get the version of volume" + obj.Name, err
+	}
 	obj.Deploy = patch.NewDeployment(
 		patch.WithDeploymentClient(obj.KubeClientset),
 	)
@@ -128,7 +231,32 @@ func (obj *CStorVolumePatch) Init() (string, error) {
 	return "", nil
 }
 
+// upgradeVolumePolicy upgrades the cstorvolumepolicy referenced by the
+// cvc, before the volume is patched so that the upgraded target does not
+// reconcile with a policy of an older version
+func (obj *CStorVolumePatch) upgradeVolumePolicy() (string, error) {
+	name := cvcPolicyName(obj.CVC.Object)
+	if name == "" {
+		return "", nil
+	}
+	res := *obj.ResourcePatch
+	res.Name = name
+	policy := NewCStorVolumePolicyPatch(
+		WithCStorVolumePolicyResorcePatch(&res),
+		WithCStorVolumePolicyClient(obj.Client),
+	)
+	err := policy.Upgrade()
+	if err != nil {
+		return "failed to upgrade cstorvolumepolicy " + name, err
+	}
+	return "", nil
+}
+
+// GetVolumePatches ...
 func (obj *CStorVolumePatch) GetVolumePatches() (string, error) {
+	if obj.VerifyOnly {
+		return "", nil
+	}
 	err := obj.getCVCPatchData()
 	if err != nil {
 		return "failed to create CVC patch for volume" + obj.Name, err
@@ -154,17 +282,17 @@ func (obj *CStorVolumePatch) getCVCPatchData() error {
 	if err != nil {
 		return err
 	}
-	obj.CVC.Data, err = GetPatchData(obj.CVC.Object, newCVC)
+	obj.CVC.Data, err = GetPatchData(obj.CVC.Object, newCVC,
+		IncludePatchFields("metadata.annotations", "versionDetails"))
 	return err
 }
 
 func (obj *CStorVolumePatch) transformCVC(c *cstor.CStorVolumeConfig, res *ResourcePatch) error {
-	pvObj, err := obj.KubeClientset.CoreV1().PersistentVolumes().
-		Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	claim, err := obj.claimName()
 	if err != nil {
 		return err
 	}
-	c.Annotations["openebs.io/persistent-volume-claim"] = pvObj.Spec.ClaimRef.Name
+	c.Annotations["openebs.io/persistent-volume-claim"] = claim
 	c.VersionDetails.Desired = res.To
 	return nil
 }
@@ -175,17 +303,17 @@ func (obj *CStorVolumePatch) getCVPatchData() error {
 	if err != nil {
 		return err
 	}
-	obj.CV.Data, err = GetPatchData(obj.CV.Object, newCV)
+	obj.CV.Data, err = GetPatchData(obj.CV.Object, newCV,
+		IncludePatchFields("metadata.labels", "versionDetails"))
 	return err
 }
 
 func (obj *CStorVolumePatch) transformCV(c *cstor.CStorVolume, res *ResourcePatch) error {
-	pvObj, err := obj.KubeClientset.CoreV1().PersistentVolumes().
-		Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	claim, err := obj.claimName()
 	if err != nil {
 		return err
 	}
-	c.Labels["openebs.io/persistent-volume-claim"] = pvObj.Spec.ClaimRef.Name
+	c.Labels["openebs.io/persistent-volume-claim"] = claim
 	c.VersionDetails.Desired = res.To
 	return nil
 }
@@ -208,10 +336,7 @@ func (obj *CStorVolumePatch) transformCVDeploy(d *appsv1.Deployment, res *Resour
 	}
 	cons := len(d.Spec.Template.Spec.Containers)
 	for i := 0; i < cons; i++ {
-		url, err := getImageURL(
-			d.Spec.Template.Spec.Containers[i].Image,
-			res.BaseURL,
-		)
+		url, err := res.imageURL(d.Spec.Template.Spec.Containers[i].Image)
 		if err != nil {
 			return err
 		}
@@ -219,13 +344,12 @@ func (obj *CStorVolumePatch) transformCVDeploy(d *appsv1.Deployment, res *Resour
 		url = removeSuffixFromEnd(url, "-amd64")
 		d.Spec.Template.Spec.Containers[i].Image = url + ":" + tag
 	}
-	pvObj, err := obj.KubeClientset.CoreV1().PersistentVolumes().
-		Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	claim, err := obj.claimName()
 	if err != nil {
 		return err
 	}
-	d.Labels["openebs.io/persistent-volume-claim"] = pvObj.Spec.ClaimRef.Name
-	d.Spec.Template.Labels["openebs.io/persistent-volume-claim"] = pvObj.Spec.ClaimRef.Name
+	d.Labels["openebs.io/persistent-volume-claim"] = claim
+	d.Spec.Template.Labels["openebs.io/persistent-volume-claim"] = claim
 	d.Labels["openebs.io/version"] = res.To
 	d.Spec.Template.Labels["openebs.io/version"] = res.To
 	d.Spec.Template.Spec.ServiceAccountName = cstorOperatorServiceAccount
@@ -249,6 +373,9 @@ func transformCVService(svc *corev1.Service, res *ResourcePatch) error {
 
 // CStorVolumeUpgrade ...
 func (obj *CStorVolumePatch) CStorVolumeUpgrade() (string, error) {
+	if obj.VerifyOnly {
+		return obj.verifyVolumeReconcile()
+	}
 	err := obj.Deploy.Patch(obj.From, obj.To)
 	if err != nil {
 		return "failed to patch target deploy", err
@@ -273,24 +400,48 @@ func (obj *CStorVolumePatch) CStorVolumeUpgrade() (string, error) {
 	if err != nil {
 		return "failed to verify version reconcile on CVC", err
 	}
+	err = obj.updateISCSIPortal()
+	if err != nil {
+		return "failed to update the iscsi portal of the initiator", err
+	}
+	return "", nil
+}
+
+// verifyVolumeReconcile only verifies the version reconciliation
+// of the CV and CVC without patching them
+func (obj *CStorVolumePatch) verifyVolumeReconcile() (string, error) {
+	klog.Infof("skipping patch of volume %s in verify only mode", obj.Name)
+	err := obj.verifyCVVersionReconcile()
+	if err != nil {
+		return "failed to verify version reconcile on CV", err
+	}
+	err = obj.verifyCVCVersionReconcile()
+	if err != nil {
+		return "failed to verify version reconcile on CVC", err
+	}
 	return "", nil
 }
 
 // Upgrade execute the steps to upgrade CStorVolume
 func (obj *CStorVolumePatch) Upgrade() error {
 	var err, uerr error
+	release, err := lockResource("cstorVolume", obj.ResourcePatch, obj.Client)
+	if err != nil {
+		return err
+	}
+	defer release()
 	obj.Utask, err = getOrCreateUpgradeTask(
 		"cstorVolume",
 		obj.ResourcePatch,
 		obj.Client,
 	)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj := v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.PreUpgrade}
 	statusObj.Phase = v1Alpha1API.StepWaiting
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj.Phase = v1Alpha1API.StepErrored
@@ -299,27 +450,37 @@ func (obj *CStorVolumePatch) Upgrade() error {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newAPIError(errors.Wrap(err, msg))
 	}
 	msg, err = obj.PreUpgrade()
 	if err != nil {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newValidationError(errors.Wrap(err, msg))
 	}
 	msg, err = obj.GetVolumePatches()
 	if err != nil {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
+			return uerr
+		}
+		return newAPIError(errors.Wrap(err, msg))
+	}
+	msg, err = obj.upgradeVolumePolicy()
+	if err != nil {
+		statusObj.Message = msg
+		statusObj.Reason = err.Error()
+		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
 		return errors.Wrap(err, msg)
@@ -328,14 +489,14 @@ func (obj *CStorVolumePatch) Upgrade() error {
 	statusObj.Message = "Pre-upgrade steps were successful"
 	statusObj.Reason = ""
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 
 	statusObj = v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.ReplicaUpgrade}
 	statusObj.Phase = v1Alpha1API.StepWaiting
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj.Phase = v1Alpha1API.StepErrored
@@ -346,15 +507,15 @@ func (obj *CStorVolumePatch) Upgrade() error {
 			LabelSelector: "openebs.io/persistent-volume=" + obj.Name,
 		},
 	)
-	if err != nil && isUpgradeTaskJob {
+	if err != nil && obj.IsUpgradeTaskJob() {
 		msg = "failed to list cvrs for volume"
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newAPIError(errors.Wrap(err, msg))
 	}
 	for _, cvrObj := range cvrList.Items {
 		res.Name = cvrObj.Name
@@ -368,7 +529,7 @@ func (obj *CStorVolumePatch) Upgrade() error {
 			statusObj.Message = msg
 			statusObj.Reason = err.Error()
 			obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-			if uerr != nil && isUpgradeTaskJob {
+			if uerr != nil && obj.IsUpgradeTaskJob() {
 				return uerr
 			}
 			return errors.Wrap(err, msg)
@@ -378,13 +539,13 @@ func (obj *CStorVolumePatch) Upgrade() error {
 	statusObj.Message = "Replica upgrade was successful"
 	statusObj.Reason = ""
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj = v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.TargetUpgrade}
 	statusObj.Phase = v1Alpha1API.StepWaiting
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj.Phase = v1Alpha1API.StepErrored
@@ -393,61 +554,45 @@ func (obj *CStorVolumePatch) Upgrade() error {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newPartialFailureError(errors.Wrap(err, msg))
 	}
 	statusObj.Phase = v1Alpha1API.StepCompleted
 	statusObj.Message = "Target upgrade was successful"
 	statusObj.Reason = ""
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
+	// the clones are upgraded after their parent so that a clone
+	// is never left ahead of the volume it is cloned from
+	err = obj.upgradeClones()
+	if err != nil {
+		return newPartialFailureError(errors.Wrapf(err, "failed to upgrade the clones of volume %s", obj.Name))
+	}
 	return nil
 }
 
 func (obj *CStorVolumePatch) verifyCVVersionReconcile() error {
-	// get the latest cvc object
-	err := obj.CV.Get(obj.Name, obj.Namespace)
-	if err != nil {
-		return err
-	}
-	// waiting for the current version to be equal to desired version
-	for obj.CV.Object.VersionDetails.Status.Current != obj.To {
-		klog.Infof("Verifying the reconciliation of version for %s", obj.CV.Object.Name)
-		// Sleep equal to the default sync time
-		time.Sleep(10 * time.Second)
-		err = obj.CV.Get(obj.Name, obj.Namespace)
+	return obj.verifyVersionReconcile(obj.Name, func() (ReconcileStatus, error) {
+		err := obj.CV.Get(obj.Name, obj.Namespace)
 		if err != nil {
-			return err
-		}
-		if obj.CV.Object.VersionDetails.Status.Message != "" {
-			klog.Errorf("failed to reconcile: %s", obj.CV.Object.VersionDetails.Status.Reason)
+			return ReconcileStatus{}, err
 		}
-	}
-	return nil
+		status := obj.CV.Object.VersionDetails.Status
+		return ReconcileStatus{status.Current, status.Message, status.Reason, obj.CV.Object}, nil
+	})
 }
 
 func (obj *CStorVolumePatch) verifyCVCVersionReconcile() error {
-	// get the latest cvc object
-	err := obj.CVC.Get(obj.Name, obj.Namespace)
-	if err != nil {
-		return err
-	}
-	// waiting for the current version to be equal to desired version
-	for obj.CVC.Object.VersionDetails.Status.Current != obj.To {
-		klog.Infof("Verifying the reconciliation of version for %s", obj.CVC.Object.Name)
-		// Sleep equal to the default sync time
-		time.Sleep(10 * time.Second)
-		err = obj.CVC.Get(obj.Name, obj.Namespace)
+	return obj.verifyVersionReconcile(obj.Name, func() (ReconcileStatus, error) {
+		err := obj.CVC.Get(obj.Name, obj.Namespace)
 		if err != nil {
-			return err
+			return ReconcileStatus{}, err
 		}
-		if obj.CVC.Object.VersionDetails.Status.Message != "" {
-			klog.Errorf("failed to reconcile: %s", obj.CVC.Object.VersionDetails.Status.Reason)
-		}
-	}
-	return nil
+		status := obj.CVC.Object.VersionDetails.Status
+		return ReconcileStatus{status.Current, status.Message, status.Reason, obj.CVC.Object}, nil
+	})
 }
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/api/v3/pkg/apis/types"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// CStorVolumePolicyPatch is the patch required to upgrade the
+// cstorvolumepolicy referenced by the cvcs of the cstor volumes
+type CStorVolumePolicyPatch struct {
+	*ResourcePatch
+	*Client
+	Namespace string
+	Policy    *cstor.CStorVolumePolicy
+}
+
+// CStorVolumePolicyPatchOptions ...
+type CStorVolumePolicyPatchOptions func(*CStorVolumePolicyPatch)
+
+// WithCStorVolumePolicyResorcePatch ...
+func WithCStorVolumePolicyResorcePatch(r *ResourcePatch) CStorVolumePolicyPatchOptions {
+	return func(obj *CStorVolumePolicyPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithCStorVolumePolicyClient ...
+func WithCStorVolumePolicyClient(c *Client) CStorVolumePolicyPatchOptions {
+	return func(obj *CStorVolumePolicyPatch) {
+		obj.Client = c
+	}
+}
+
+// NewCStorVolumePolicyPatch ...
+func NewCStorVolumePolicyPatch(opts ...CStorVolumePolicyPatchOptions) *CStorVolumePolicyPatch {
+	obj := &CStorVolumePolicyPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// cvcPolicyName returns the name of the cstorvolumepolicy
+// referenced by the cvc, empty if it has none
+func cvcPolicyName(cvc *cstor.CStorVolumeConfig) string {
+	return cvc.Annotations[types.VolumePolicyKey]
+}
+
+// isPolicyUpgraded returns true if the policy is stamped with the
+// version, which is the case for the policies shared by many volumes
+// once the upgrade of the first of them has upgraded it
+func isPolicyUpgraded(p *cstor.CStorVolumePolicy, version string) bool {
+	return p.Labels["openebs.io/version"] == version
+}
+
+func transformCStorVolumePolicy(p *cstor.CStorVolumePolicy, res *ResourcePatch) error {
+	if p.Labels == nil {
+		p.Labels = map[string]string{}
+	}
+	p.Labels["openebs.io/version"] = res.To
+	return nil
+}
+
+// Init gets the cstorvolumepolicy, the policy is nil if it does not exist
+func (obj *CStorVolumePolicyPatch) Init() error {
+	obj.Namespace = obj.OpenebsNamespace
+	policy, err := obj.OpenebsClientset.CstorV1().CStorVolumePolicies(obj.Namespace).
+		Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get cstorvolumepolicy %s", obj.Name)
+	}
+	obj.Policy = policy
+	return nil
+}
+
+// Upgrade stamps the cstorvolumepolicy with the to version. A policy which
+// is already at the to version is skipped, so a policy shared by many
+// volumes is only upgraded once, and a missing policy is only logged as
+// the volumes already provisioned with it do not depend on it.
+func (obj *CStorVolumePolicyPatch) Upgrade() error {
+	err := obj.Init()
+	if err != nil {
+		return newAPIError(err)
+	}
+	if obj.Policy == nil {
+		klog.Warningf("cstorvolumepolicy %s not found, skipping its upgrade", obj.Name)
+		return nil
+	}
+	if isPolicyUpgraded(obj.Policy, obj.To) {
+		klog.Infof("cstorvolumepolicy %s already in %s version", obj.Name, obj.To)
+		return nil
+	}
+	if obj.VerifyOnly {
+		klog.Infof("skipping patch of cstorvolumepolicy %s in verify only mode", obj.Name)
+		return nil
+	}
+	newPolicy := obj.Policy.DeepCopy()
+	err = transformCStorVolumePolicy(newPolicy, obj.ResourcePatch)
+	if err != nil {
+		return err
+	}
+	data, err := GetPatchData(obj.Policy, newPolicy)
+	if err != nil {
+		return err
+	}
+	_, err = obj.OpenebsClientset.CstorV1().CStorVolumePolicies(obj.Namespace).
+		Patch(context.TODO(), obj.Name, k8stypes.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to patch cstorvolumepolicy %s", obj.Name))
+	}
+	return obj.verifyPolicyVersion()
+}
+
+// verifyPolicyVersion gets the patched policy to verify it is stamped
+// with the to version, as the policy has no version status to reconcile
+func (obj *CStorVolumePolicyPatch) verifyPolicyVersion() error {
+	err := obj.Init()
+	if err != nil {
+		return newAPIError(err)
+	}
+	if obj.Policy == nil || !isPolicyUpgraded(obj.Policy, obj.To) {
+		return newAPIError(errors.Errorf("cstorvolumepolicy %s is not in %s version after the patch", obj.Name, obj.To))
+	}
+	klog.Infof("cstorvolumepolicy %s patched successfully", obj.Name)
+	return nil
+}
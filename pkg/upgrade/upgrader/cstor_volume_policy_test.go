@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testVolumePolicy(version string) *cstor.CStorVolumePolicy {
+	p := &cstor.CStorVolumePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy-a", Namespace: "openebs"},
+	}
+	if version != "" {
+		p.Labels = map[string]string{"openebs.io/version": version}
+	}
+	return p
+}
+
+func TestCStorVolumePolicyPatch_Upgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		objects    []runtime.Object
+		verifyOnly bool
+		// volumes is the number of volumes sharing the policy
+		volumes     int
+		wantPatches int
+	}{
+		{name: "unversioned policy", objects: []runtime.Object{testVolumePolicy("")}, volumes: 1, wantPatches: 1},
+		{name: "older policy", objects: []runtime.Object{testVolumePolicy("2.12.0")}, volumes: 1, wantPatches: 1},
+		{name: "shared policy", objects: []runtime.Object{testVolumePolicy("2.12.0")}, volumes: 3, wantPatches: 1},
+		{name: "already upgraded", objects: []runtime.Object{testVolumePolicy("3.0.0")}, volumes: 1},
+		{name: "missing policy", volumes: 1},
+		{name: "verify only", objects: []runtime.Object{testVolumePolicy("2.12.0")}, verifyOnly: true, volumes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objects...)
+			clientset := fakeOpenebs(client)
+			for i := 0; i < tt.volumes; i++ {
+				obj := NewCStorVolumePolicyPatch(
+					WithCStorVolumePolicyResorcePatch(NewResourcePatch(
+						WithName("policy-a"),
+						WithOpenebsNamespace("openebs"),
+						FromVersion("2.12.0"),
+						ToVersion("3.0.0"),
+						WithVerifyOnly(tt.verifyOnly),
+					)),
+					WithCStorVolumePolicyClient(client),
+				)
+				err := obj.Upgrade()
+				if err != nil {
+					t.Fatalf("Upgrade() error = %v", err)
+				}
+			}
+			patches := 0
+			for _, action := range clientset.Actions() {
+				if action.GetVerb() == "patch" {
+					patches++
+				}
+			}
+			if patches != tt.wantPatches {
+				t.Errorf("Upgrade() made %d patches, want %d", patches, tt.wantPatches)
+			}
+			if tt.wantPatches == 0 {
+				return
+			}
+			p, err := clientset.CstorV1().CStorVolumePolicies("openebs").
+				Get(context.TODO(), "policy-a", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get the policy: %v", err)
+			}
+			if p.Labels["openebs.io/version"] != "3.0.0" {
+				t.Errorf("Upgrade() policy labels = %v, want version 3.0.0", p.Labels)
+			}
+		})
+	}
+}
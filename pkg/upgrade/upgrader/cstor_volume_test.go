@@ -0,0 +1,293 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/api/v3/pkg/apis/types"
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testPod(name, namespace, claim string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+					},
+				},
+			},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestCheckPVCInUse(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []runtime.Object
+		want bool
+	}{
+		{
+			name: "used by a running pod",
+			pods: []runtime.Object{testPod("app-0", "default", "data-1", corev1.PodRunning)},
+			want: true,
+		},
+		{
+			name: "used by a completed pod",
+			pods: []runtime.Object{testPod("job-0", "default", "data-1", corev1.PodSucceeded)},
+		},
+		{
+			name: "running pod with another pvc",
+			pods: []runtime.Object{testPod("app-0", "default", "data-2", corev1.PodRunning)},
+		},
+		{
+			name: "running pod in another namespace",
+			pods: []runtime.Object{testPod("app-0", "other", "data-1", corev1.PodRunning)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckPVCInUse("data-1", "default", NewTestClient(tt.pods...).KubeClientset)
+			if err != nil {
+				t.Fatalf("CheckPVCInUse() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CheckPVCInUse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCStorVolumePatch_checkVolumeInUse(t *testing.T) {
+	pv := testPV("pvc-1", cstorCSIProvisioner, "data-1")
+	pod := testPod("app-0", "default", "data-1", corev1.PodRunning)
+	tests := []struct {
+		name    string
+		allow   bool
+		wantErr bool
+	}{
+		{name: "in use fails", wantErr: true},
+		{name: "in use allowed", allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := NewCStorVolumePatch(
+				WithCStorVolumeResorcePatch(NewResourcePatch(
+					WithName("pvc-1"),
+					WithAllowInUseUpgrades(tt.allow),
+				)),
+				WithCStorVolumeClient(NewTestClient(pv, pod)),
+			)
+			err := obj.checkVolumeInUse()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkVolumeInUse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCStorVolumePatch_claimName(t *testing.T) {
+	labels := map[string]string{"openebs.io/persistent-volume-claim": "data-1"}
+	tests := []struct {
+		name            string
+		namespaceScoped bool
+		cvLabels        map[string]string
+		want            string
+		wantErr         bool
+	}{
+		{name: "from the pv", want: "data-1"},
+		{name: "from the cv in namespace scoped mode", namespaceScoped: true, cvLabels: labels, want: "data-1"},
+		{name: "missing in namespace scoped mode", namespaceScoped: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(testPV("pvc-1", cstorCSIProvisioner, "data-1"))
+			client.namespaceScoped = tt.namespaceScoped
+			obj := NewCStorVolumePatch(
+				WithCStorVolumeResorcePatch(NewResourcePatch(WithName("pvc-1"))),
+				WithCStorVolumeClient(client),
+			)
+			obj.CVC = &patch.CVC{Object: &cstor.CStorVolumeConfig{}}
+			obj.CV = &patch.CV{Object: &cstor.CStorVolume{ObjectMeta: metav1.ObjectMeta{Labels: tt.cvLabels}}}
+			obj.Deploy = &patch.Deployment{Object: &appsv1.Deployment{}}
+			got, err := obj.claimName()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("claimName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("claimName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestCStorVolumeClient returns the client of a cstor volume pvc-1 of
+// the given version with a healthy replica on an upgraded cspi, whose
+// operators reconcile the cstor resources as soon as they are patched
+func newTestCStorVolumeClient(version, operatorVersion string) *Client {
+	labels := map[string]string{"openebs.io/persistent-volume": "pvc-1"}
+	cv := upgradetesting.NewTestCV("pvc-1", version)
+	cv.Spec.ReplicationFactor = 1
+	cv.Status.ReplicaStatuses = []cstor.ReplicaStatus{{ID: "1", Mode: "Healthy"}}
+	cvc := upgradetesting.NewTestCVC("pvc-1", version)
+	cvc.Annotations[types.VolumePolicyKey] = "policy-a"
+	client := NewTestClient(
+		testOperatorPod("cvc-operator", operatorVersion),
+		testPV("pvc-1", cstorCSIProvisioner, "data-1"),
+		upgradetesting.NewTestDeployment("pvc-1-target", version, labels, "openebs/cstor-istgt:"+version),
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Name:      "pvc-1",
+			Namespace: upgradetesting.Namespace,
+			Labels:    map[string]string{"openebs.io/persistent-volume": "pvc-1", "openebs.io/version": version},
+		}},
+		cvc,
+		testVolumePolicy(version),
+		cv,
+		upgradetesting.NewTestCVR("pvc-1-cspi-1", "pvc-1", "cspi-1", version),
+		upgradetesting.NewTestCSPI("cspi-1", "cspc-a", "3.0.0"),
+	)
+	reconcileOnGet(fakeOpenebs(client))
+	return client
+}
+
+// setTestVolumeDesired sets the desired version of the volume
+// resources, as if another run had patched them
+func setTestVolumeDesired(t *testing.T, client *Client, version string) {
+	cstorClient := client.OpenebsClientset.CstorV1()
+	cv, err := cstorClient.CStorVolumes(upgradetesting.Namespace).Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv.VersionDetails.Desired = version
+	cvc, err := cstorClient.CStorVolumeConfigs(upgradetesting.Namespace).Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cvc.VersionDetails.Desired = version
+	cvr, err := cstorClient.CStorVolumeReplicas(upgradetesting.Namespace).Get(context.TODO(), "pvc-1-cspi-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cvr.VersionDetails.Desired = version
+	_, err = cstorClient.CStorVolumes(upgradetesting.Namespace).Update(context.TODO(), cv, metav1.UpdateOptions{})
+	if err == nil {
+		_, err = cstorClient.CStorVolumeConfigs(upgradetesting.Namespace).Update(context.TODO(), cvc, metav1.UpdateOptions{})
+	}
+	if err == nil {
+		_, err = cstorClient.CStorVolumeReplicas(upgradetesting.Namespace).Update(context.TODO(), cvr, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCStorVolumePatch_Upgrade(t *testing.T) {
+	defer func(sa string) { cstorOperatorServiceAccount = sa }(cstorOperatorServiceAccount)
+	tests := []struct {
+		name            string
+		operatorVersion string
+		verifyOnly      bool
+		desired         string
+		wantErr         bool
+		wantVersion     string
+	}{
+		{name: "upgraded", operatorVersion: "3.0.0", wantVersion: "3.0.0"},
+		{name: "operator not upgraded", operatorVersion: "2.12.0", wantErr: true, wantVersion: "2.12.0"},
+		{name: "verify only", operatorVersion: "3.0.0", verifyOnly: true, wantErr: true, wantVersion: "2.12.0"},
+		{name: "verify only reconciled", operatorVersion: "3.0.0", verifyOnly: true, desired: "3.0.0", wantVersion: "3.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestCStorVolumeClient("2.12.0", tt.operatorVersion)
+			if tt.desired != "" {
+				setTestVolumeDesired(t, client, tt.desired)
+			}
+			obj := NewCStorVolumePatch(
+				WithCStorVolumeResorcePatch(NewResourcePatch(
+					WithName("pvc-1"),
+					FromVersion("2.12.0"),
+					ToVersion("3.0.0"),
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					WithPollInterval(time.Millisecond),
+					WithReconcileTimeout(50*time.Millisecond),
+					WithVerifyOnly(tt.verifyOnly),
+				)),
+				WithCStorVolumeClient(client),
+			)
+			err := obj.Upgrade()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Upgrade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			cvObj, err := client.OpenebsClientset.CstorV1().CStorVolumes(upgradetesting.Namespace).
+				Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cvObj.VersionDetails.Status.Current != tt.wantVersion {
+				t.Errorf("cv version = %s, want %s", cvObj.VersionDetails.Status.Current, tt.wantVersion)
+			}
+			cvrObj, err := client.OpenebsClientset.CstorV1().CStorVolumeReplicas(upgradetesting.Namespace).
+				Get(context.TODO(), "pvc-1-cspi-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cvrObj.VersionDetails.Status.Current != tt.wantVersion {
+				t.Errorf("cvr version = %s, want %s", cvrObj.VersionDetails.Status.Current, tt.wantVersion)
+			}
+			if tt.wantErr || tt.verifyOnly {
+				return
+			}
+			cvcObj, err := client.OpenebsClientset.CstorV1().CStorVolumeConfigs(upgradetesting.Namespace).
+				Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cvcObj.Annotations["openebs.io/persistent-volume-claim"] != "data-1" {
+				t.Errorf("cvc annotations = %v, want the pvc data-1", cvcObj.Annotations)
+			}
+			deployObj, err := client.KubeClientset.AppsV1().Deployments(upgradetesting.Namespace).
+				Get(context.TODO(), "pvc-1-target", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image := deployObj.Spec.Template.Spec.Containers[0].Image; image != "openebs/cstor-istgt:3.0.0" {
+				t.Errorf("target image = %s, want openebs/cstor-istgt:3.0.0", image)
+			}
+			policy, err := client.OpenebsClientset.CstorV1().CStorVolumePolicies(upgradetesting.Namespace).
+				Get(context.TODO(), "policy-a", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isPolicyUpgraded(policy, "3.0.0") {
+				t.Errorf("policy labels = %v, want version 3.0.0", policy.Labels)
+			}
+		})
+	}
+}
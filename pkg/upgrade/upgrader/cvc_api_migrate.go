@@ -0,0 +1,383 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+const (
+	// CVCAPIMigrationFinalizer is set on the v1alpha1 cvc being migrated,
+	// so that it is not deleted before its v1 cvc is bound to the cv
+	CVCAPIMigrationFinalizer = "openebs.io/cvc-api-migration"
+
+	// defaultCVCBindTimeout is the time waited for the v1 cvc
+	// to be bound if no ReconcileTimeout is set
+	defaultCVCBindTimeout = 5 * time.Minute
+)
+
+// CStorVolumeClaimGVR is the resource of the cvcs of the
+// deprecated openebs.io/v1alpha1 api of older clusters
+var CStorVolumeClaimGVR = schema.GroupVersionResource{
+	Group:    "openebs.io",
+	Version:  "v1alpha1",
+	Resource: "cstorvolumeclaims",
+}
+
+// CVCAPIMigrator migrates the CStorVolumeClaims of the openebs.io/v1alpha1
+// api to the CStorVolumeConfigs of the cstor.openebs.io/v1 api. The old cvc
+// is kept by a finalizer until the new cvc is bound to the cv, which is
+// then owned by the new cvc, so an interrupted migration can be rerun.
+type CVCAPIMigrator struct {
+	*ResourcePatch
+	*Client
+}
+
+// CVCAPIMigratorOptions ...
+type CVCAPIMigratorOptions func(*CVCAPIMigrator)
+
+// WithCVCAPIMigratorResorcePatch ...
+func WithCVCAPIMigratorResorcePatch(r *ResourcePatch) CVCAPIMigratorOptions {
+	return func(obj *CVCAPIMigrator) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithCVCAPIMigratorClient ...
+func WithCVCAPIMigratorClient(c *Client) CVCAPIMigratorOptions {
+	return func(obj *CVCAPIMigrator) {
+		obj.Client = c
+	}
+}
+
+// NewCVCAPIMigrator ...
+func NewCVCAPIMigrator(opts ...CVCAPIMigratorOptions) *CVCAPIMigrator {
+	obj := &CVCAPIMigrator{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// Upgrade migrates the v1alpha1 cvc of the given name, or all the v1alpha1
+// cvcs in the openebs namespace if no name is given. Nothing is done if the
+// v1alpha1 crd is not installed. With VerifyOnly the cvcs are checked to be
+// migrated and bound instead.
+func (obj *CVCAPIMigrator) Upgrade() error {
+	if obj.DynamicClientset == nil {
+		return newValidationError(errors.Errorf("no dynamic clientset to read the v1alpha1 cvcs"))
+	}
+	client := obj.DynamicClientset.Resource(CStorVolumeClaimGVR).Namespace(obj.OpenebsNamespace)
+	cvcs := []unstructured.Unstructured{}
+	if obj.Name != "" {
+		old, err := client.Get(context.TODO(), obj.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			// the cvc may have been migrated by an earlier run
+			return obj.verifyBinding(obj.Name, "")
+		}
+		if err != nil {
+			return newAPIError(newObjectError("get", "v1alpha1 cvc", obj.OpenebsNamespace, obj.Name, err))
+		}
+		cvcs = append(cvcs, *old)
+	} else {
+		list, err := client.List(context.TODO(), metav1.ListOptions{})
+		if k8serrors.IsNotFound(err) {
+			klog.Infof("No v1alpha1 cvcs to migrate, the crd is not installed")
+			return nil
+		}
+		if err != nil {
+			return newAPIError(newObjectError("list", "v1alpha1 cvc", obj.OpenebsNamespace, "", err))
+		}
+		cvcs = list.Items
+	}
+	failed := []string{}
+	for i := range cvcs {
+		err := obj.migrateCVC(&cvcs[i])
+		if err != nil {
+			klog.Errorf("cvc %s: %v", cvcs[i].GetName(), err)
+			failed = append(failed, fmt.Sprintf("%s: %v", cvcs[i].GetName(), err))
+		}
+	}
+	if len(failed) != 0 {
+		return newAPIError(errors.Errorf("failed to migrate %d out of %d cvcs: %s",
+			len(failed), len(cvcs), strings.Join(failed, "; ")))
+	}
+	return nil
+}
+
+// migrateCVC creates the v1 cvc of the v1alpha1 cvc and moves the
+// ownership of the cv to it. The v1alpha1 cvc is only deleted once
+// the binding of the v1 cvc to the cv is verified.
+func (obj *CVCAPIMigrator) migrateCVC(old *unstructured.Unstructured) error {
+	name := old.GetName()
+	if obj.VerifyOnly {
+		return errors.Errorf("cvc %s is still at openebs.io/v1alpha1", name)
+	}
+	newCVC, err := convertCVCV1alpha1ToV1(old)
+	if err != nil {
+		return newValidationError(err)
+	}
+	if obj.DryRun {
+		klog.Infof("cvc %s would be migrated from openebs.io/v1alpha1 to cstor.openebs.io/v1", name)
+		return nil
+	}
+	old, err = obj.addMigrationFinalizer(old)
+	if err != nil {
+		return err
+	}
+	newCVC, err = obj.createCVC(newCVC)
+	if err != nil {
+		return err
+	}
+	err = obj.waitForBound(name)
+	if err != nil {
+		return err
+	}
+	err = obj.rebindCV(old, newCVC)
+	if err != nil {
+		return err
+	}
+	err = obj.verifyBinding(name, old.GetUID())
+	if err != nil {
+		return err
+	}
+	err = obj.removeOldCVC(old)
+	if err != nil {
+		return err
+	}
+	klog.Infof("cvc %s: migrated from openebs.io/v1alpha1 to cstor.openebs.io/v1", name)
+	return nil
+}
+
+// convertCVCV1alpha1ToV1 returns the v1 cvc of the v1alpha1 cvc. The
+// fields shared by both apis are copied as is, the replica count and
+// capacity of the v1alpha1 spec are the provision of the v1 cvc, and
+// the reference to the cv is moved to the v1 api of the cvs.
+func convertCVCV1alpha1ToV1(old *unstructured.Unstructured) (*cstor.CStorVolumeConfig, error) {
+	newCVC := &cstor.CStorVolumeConfig{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(old.UnstructuredContent(), newCVC)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert cvc %s", old.GetName())
+	}
+	newCVC.TypeMeta = metav1.TypeMeta{}
+	newCVC.ObjectMeta = metav1.ObjectMeta{
+		Name:        old.GetName(),
+		Namespace:   old.GetNamespace(),
+		Labels:      old.GetLabels(),
+		Annotations: old.GetAnnotations(),
+		Finalizers:  withoutFinalizer(old.GetFinalizers(), CVCAPIMigrationFinalizer),
+	}
+	replicaCount, _, err := unstructured.NestedInt64(old.Object, "spec", "replicaCount")
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid replica count of cvc %s", old.GetName())
+	}
+	if replicaCount == 0 {
+		return nil, errors.Errorf("cvc %s has no replica count", old.GetName())
+	}
+	newCVC.Spec.Provision = cstor.VolumeProvision{
+		Capacity:     newCVC.Spec.Capacity,
+		ReplicaCount: int(replicaCount),
+	}
+	if ref := newCVC.Spec.CStorVolumeRef; ref != nil && ref.Kind == "CStorVolume" {
+		ref.APIVersion = cstor.SchemeGroupVersion.String()
+	}
+	return newCVC, nil
+}
+
+// withoutFinalizer returns the finalizers without the given one
+func withoutFinalizer(finalizers []string, finalizer string) []string {
+	kept := []string{}
+	for _, f := range finalizers {
+		if f != finalizer {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// addMigrationFinalizer sets the finalizer of the migration on
+// the v1alpha1 cvc, if it is not set by an earlier run
+func (obj *CVCAPIMigrator) addMigrationFinalizer(old *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	for _, f := range old.GetFinalizers() {
+		if f == CVCAPIMigrationFinalizer {
+			return old, nil
+		}
+	}
+	old = old.DeepCopy()
+	old.SetFinalizers(append(old.GetFinalizers(), CVCAPIMigrationFinalizer))
+	updated, err := obj.DynamicClientset.Resource(CStorVolumeClaimGVR).Namespace(old.GetNamespace()).
+		Update(context.TODO(), old, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, newAPIError(newObjectError("update", "v1alpha1 cvc", old.GetNamespace(), old.GetName(), err))
+	}
+	return updated, nil
+}
+
+// createCVC creates the v1 cvc along with the status of the v1alpha1
+// cvc, so that the cvc-operator does not provision it again. The v1
+// cvc created by an earlier run is used as is.
+func (obj *CVCAPIMigrator) createCVC(newCVC *cstor.CStorVolumeConfig) (*cstor.CStorVolumeConfig, error) {
+	cvcClient := obj.OpenebsClientset.CstorV1().CStorVolumeConfigs(newCVC.Namespace)
+	created, err := cvcClient.Create(context.TODO(), newCVC, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		created, err = cvcClient.Get(context.TODO(), newCVC.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, newAPIError(newObjectError("get", "cvc", newCVC.Namespace, newCVC.Name, err))
+		}
+		return created, nil
+	}
+	if err != nil {
+		return nil, newAPIError(newObjectError("create", "cvc", newCVC.Namespace, newCVC.Name, err))
+	}
+	if created.Status.Phase == newCVC.Status.Phase {
+		return created, nil
+	}
+	// the status is not set on create if it is a subresource
+	created.Status = newCVC.Status
+	created, err = cvcClient.UpdateStatus(context.TODO(), created, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, newAPIError(newObjectError("update the status of", "cvc", newCVC.Namespace, newCVC.Name, err))
+	}
+	return created, nil
+}
+
+// waitForBound waits up to the ReconcileTimeout for the v1 cvc to be Bound
+func (obj *CVCAPIMigrator) waitForBound(name string) error {
+	timeout := obj.ReconcileTimeout
+	if timeout <= 0 {
+		timeout = defaultCVCBindTimeout
+	}
+	interval := obj.pollInterval()
+	log := &waitLogger{}
+	deadline := time.Now().Add(timeout)
+	for {
+		cvcObj, err := obj.OpenebsClientset.CstorV1().CStorVolumeConfigs(obj.OpenebsNamespace).
+			Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(newObjectError("get", "cvc", obj.OpenebsNamespace, name, err))
+		}
+		if cvcObj.Status.Phase == cstor.CStorVolumeConfigPhaseBound {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf("cvc %s is %s after %s, expected %s",
+				name, cvcObj.Status.Phase, timeout, cstor.CStorVolumeConfigPhaseBound))
+		}
+		log.Infof("cvc %s: waiting for the v1 cvc to be bound, phase is %q", name, cvcObj.Status.Phase)
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
+	}
+}
+
+// rebindCV moves the owner references of the cv from the v1alpha1 cvc
+// to the v1 cvc, so that the cv is not garbage collected along with the
+// v1alpha1 cvc
+func (obj *CVCAPIMigrator) rebindCV(old *unstructured.Unstructured, newCVC *cstor.CStorVolumeConfig) error {
+	cvClient := obj.OpenebsClientset.CstorV1().CStorVolumes(obj.OpenebsNamespace)
+	cvObj, err := cvClient.Get(context.TODO(), newCVC.Name, metav1.GetOptions{})
+	if err != nil {
+		return newAPIError(newObjectError("get", "cv", obj.OpenebsNamespace, newCVC.Name, err))
+	}
+	changed := false
+	for i, ref := range cvObj.OwnerReferences {
+		if ref.UID != old.GetUID() {
+			continue
+		}
+		cvObj.OwnerReferences[i].APIVersion = cstor.SchemeGroupVersion.String()
+		cvObj.OwnerReferences[i].Kind = "CStorVolumeConfig"
+		cvObj.OwnerReferences[i].UID = newCVC.UID
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	_, err = cvClient.Update(context.TODO(), cvObj, metav1.UpdateOptions{})
+	if err != nil {
+		return newAPIError(newObjectError("update", "cv", obj.OpenebsNamespace, cvObj.Name, err))
+	}
+	klog.Infof("cvc %s: cv %s is now owned by the v1 cvc", newCVC.Name, cvObj.Name)
+	return nil
+}
+
+// verifyBinding returns an error unless the v1 cvc of the given name is
+// bound to its cv, and the cv is not owned by the v1alpha1 cvc of the
+// given uid any more
+func (obj *CVCAPIMigrator) verifyBinding(name string, oldUID types.UID) error {
+	cvcObj, err := obj.OpenebsClientset.CstorV1().CStorVolumeConfigs(obj.OpenebsNamespace).
+		Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return newAPIError(newObjectError("get", "cvc", obj.OpenebsNamespace, name, err))
+	}
+	if cvcObj.Status.Phase != cstor.CStorVolumeConfigPhaseBound {
+		return newValidationError(errors.Errorf("cvc %s is %s, expected %s",
+			name, cvcObj.Status.Phase, cstor.CStorVolumeConfigPhaseBound))
+	}
+	ref := cvcObj.Spec.CStorVolumeRef
+	if ref == nil {
+		return newValidationError(errors.Errorf("cvc %s has no reference to its cv", name))
+	}
+	cvObj, err := obj.OpenebsClientset.CstorV1().CStorVolumes(obj.OpenebsNamespace).
+		Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return newAPIError(newObjectError("get", "cv", obj.OpenebsNamespace, ref.Name, err))
+	}
+	if ref.UID != "" && ref.UID != cvObj.UID {
+		return newValidationError(errors.Errorf("cvc %s references cv %s with uid %s, found uid %s",
+			name, ref.Name, ref.UID, cvObj.UID))
+	}
+	for _, owner := range cvObj.OwnerReferences {
+		if oldUID != "" && owner.UID == oldUID {
+			return newValidationError(errors.Errorf("cv %s is still owned by the v1alpha1 cvc %s", cvObj.Name, name))
+		}
+	}
+	return nil
+}
+
+// removeOldCVC removes the finalizers from the v1alpha1 cvc and deletes
+// it, orphaning any object it still owns. Along with the finalizer of the
+// migration, the finalizers of the operator are removed as no operator
+// reconciles the v1alpha1 cvcs any more, and they are kept on the v1 cvc.
+func (obj *CVCAPIMigrator) removeOldCVC(old *unstructured.Unstructured) error {
+	client := obj.DynamicClientset.Resource(CStorVolumeClaimGVR).Namespace(old.GetNamespace())
+	old = old.DeepCopy()
+	old.SetFinalizers(nil)
+	_, err := client.Update(context.TODO(), old, metav1.UpdateOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return newAPIError(newObjectError("update", "v1alpha1 cvc", old.GetNamespace(), old.GetName(), err))
+	}
+	orphan := metav1.DeletePropagationOrphan
+	err = client.Delete(context.TODO(), old.GetName(), metav1.DeleteOptions{PropagationPolicy: &orphan})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return newAPIError(newObjectError("delete", "v1alpha1 cvc", old.GetNamespace(), old.GetName(), err))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const testOldCVCUID = types.UID("old-cvc-uid")
+
+func newTestOldCVC(name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "openebs.io/v1alpha1",
+		"kind":       "CStorVolumeClaim",
+		"metadata": map[string]interface{}{
+			"name":       name,
+			"namespace":  upgradetesting.Namespace,
+			"uid":        string(testOldCVCUID),
+			"labels":     map[string]interface{}{"openebs.io/version": "1.12.0"},
+			"finalizers": []interface{}{"cvc.openebs.io/finalizer"},
+		},
+		"spec": map[string]interface{}{
+			"capacity":     map[string]interface{}{"storage": "5Gi"},
+			"replicaCount": int64(3),
+			"cstorVolumeRef": map[string]interface{}{
+				"apiVersion": "openebs.io/v1alpha1",
+				"kind":       "CStorVolume",
+				"name":       name,
+				"namespace":  upgradetesting.Namespace,
+				"uid":        "cv-uid",
+			},
+		},
+		"status": map[string]interface{}{"phase": "Bound"},
+	}}
+	return obj
+}
+
+func newTestCVCAPIClient(name string) *Client {
+	client := NewTestClient(&cstor.CStorVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: upgradetesting.Namespace,
+			UID:       "cv-uid",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "openebs.io/v1alpha1",
+				Kind:       "CStorVolumeClaim",
+				Name:       name,
+				UID:        testOldCVCUID,
+			}},
+		},
+	})
+	client.DynamicClientset = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{CStorVolumeClaimGVR: "CStorVolumeClaimList"},
+		newTestOldCVC(name),
+	)
+	return client
+}
+
+func TestConvertCVCV1alpha1ToV1(t *testing.T) {
+	old := newTestOldCVC("pvc-1")
+	old.SetFinalizers(append(old.GetFinalizers(), CVCAPIMigrationFinalizer))
+	got, err := convertCVCV1alpha1ToV1(old)
+	if err != nil {
+		t.Fatalf("convertCVCV1alpha1ToV1() error = %v", err)
+	}
+	if got.UID != "" || got.ResourceVersion != "" {
+		t.Errorf("convertCVCV1alpha1ToV1() kept the uid %q and resource version %q", got.UID, got.ResourceVersion)
+	}
+	if len(got.Finalizers) != 1 || got.Finalizers[0] != "cvc.openebs.io/finalizer" {
+		t.Errorf("convertCVCV1alpha1ToV1() finalizers = %v", got.Finalizers)
+	}
+	if got.Spec.Provision.ReplicaCount != 3 || got.Spec.Provision.Capacity.Storage().String() != "5Gi" {
+		t.Errorf("convertCVCV1alpha1ToV1() provision = %+v", got.Spec.Provision)
+	}
+	if got.Spec.CStorVolumeRef.APIVersion != "cstor.openebs.io/v1" {
+		t.Errorf("convertCVCV1alpha1ToV1() cv ref api version = %s", got.Spec.CStorVolumeRef.APIVersion)
+	}
+	if got.Status.Phase != cstor.CStorVolumeConfigPhaseBound {
+		t.Errorf("convertCVCV1alpha1ToV1() phase = %s", got.Status.Phase)
+	}
+
+	unstructured.RemoveNestedField(old.Object, "spec", "replicaCount")
+	if _, err := convertCVCV1alpha1ToV1(old); err == nil {
+		t.Errorf("convertCVCV1alpha1ToV1() of a cvc without replica count did not fail")
+	}
+}
+
+func TestCVCAPIMigrator_Upgrade(t *testing.T) {
+	tests := map[string]struct {
+		dryRun     bool
+		verifyOnly bool
+		wantErr    bool
+		migrated   bool
+	}{
+		"migrate":     {migrated: true},
+		"dry run":     {dryRun: true},
+		"verify only": {verifyOnly: true, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := newTestCVCAPIClient("pvc-1")
+			obj := NewCVCAPIMigrator(
+				WithCVCAPIMigratorResorcePatch(&ResourcePatch{
+					To:               "3.0.0",
+					OpenebsNamespace: upgradetesting.Namespace,
+					DryRun:           tt.dryRun,
+					VerifyOnly:       tt.verifyOnly,
+				}),
+				WithCVCAPIMigratorClient(client),
+			)
+			err := obj.Upgrade()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Upgrade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			_, err = client.DynamicClientset.Resource(CStorVolumeClaimGVR).Namespace(upgradetesting.Namespace).
+				Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+			if tt.migrated != k8serrors.IsNotFound(err) {
+				t.Errorf("v1alpha1 cvc deleted = %v, want %v", k8serrors.IsNotFound(err), tt.migrated)
+			}
+			newCVC, err := client.OpenebsClientset.CstorV1().CStorVolumeConfigs(upgradetesting.Namespace).
+				Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+			if tt.migrated != (err == nil) {
+				t.Fatalf("v1 cvc created = %v, want %v", err == nil, tt.migrated)
+			}
+			if !tt.migrated {
+				return
+			}
+			cvObj, err := client.OpenebsClientset.CstorV1().CStorVolumes(upgradetesting.Namespace).
+				Get(context.TODO(), "pvc-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			owner := cvObj.OwnerReferences[0]
+			if owner.Kind != "CStorVolumeConfig" || owner.UID != newCVC.UID {
+				t.Errorf("cv owner = %+v, want the v1 cvc %s", owner, newCVC.UID)
+			}
+			// a rerun verifies the migrated cvc
+			if err := obj.Upgrade(); err != nil {
+				t.Errorf("Upgrade() of a migrated cvc error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// CVCReadinessGate pauses the upgrade of the cstor volumes while
+// cvcs are still being provisioned, as the provisioning of a new
+// cvc may fail while the old and new operators are both active
+type CVCReadinessGate struct {
+	*ResourcePatch
+	*Client
+}
+
+// NewCVCReadinessGate returns the CVCReadinessGate of the
+// cvcs in the openebs namespace of the ResourcePatch
+func NewCVCReadinessGate(r *ResourcePatch, client *Client) *CVCReadinessGate {
+	return &CVCReadinessGate{ResourcePatch: r, Client: client}
+}
+
+// pendingCVCs returns the names of the cvcs which are not yet Bound
+// or Failed. The Failed cvcs are logged, as they are not retried.
+func (g *CVCReadinessGate) pendingCVCs() ([]string, error) {
+	cvcList, err := g.OpenebsClientset.CstorV1().CStorVolumeConfigs(g.OpenebsNamespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list cvcs")
+	}
+	pending := []string{}
+	for _, cvcObj := range cvcList.Items {
+		switch cvcObj.Status.Phase {
+		case cstor.CStorVolumeConfigPhaseBound:
+		case cstor.CStorVolumeConfigPhaseFailed:
+			klog.Warningf("cvc %s failed to provision, not waiting for it", cvcObj.Name)
+		default:
+			pending = append(pending, cvcObj.Name)
+		}
+	}
+	return pending, nil
+}
+
+// Wait waits up to the CVCReadinessTimeout for all the cvcs to be Bound
+// or Failed. It returns right away if the CVCReadinessTimeout is not set.
+func (g *CVCReadinessGate) Wait() error {
+	if g.CVCReadinessTimeout <= 0 {
+		return nil
+	}
+	interval := g.pollInterval()
+	log := &waitLogger{}
+	deadline := time.Now().Add(g.CVCReadinessTimeout)
+	for {
+		pending, err := g.pendingCVCs()
+		if err != nil {
+			return newAPIError(err)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf(
+				"cvcs %s are not provisioned after %s",
+				strings.Join(pending, ", "), g.CVCReadinessTimeout,
+			))
+		}
+		log.Infof("waiting for cvcs %s to be provisioned", strings.Join(pending, ", "))
+		time.Sleep(g.backoff().Delay(interval, log.polls))
+	}
+}
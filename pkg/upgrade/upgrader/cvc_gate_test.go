@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testCVC(name string, phase cstor.CStorVolumeConfigPhase) *cstor.CStorVolumeConfig {
+	return &cstor.CStorVolumeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: upgradetesting.Namespace},
+		Status:     cstor.CStorVolumeConfigStatus{Phase: phase},
+	}
+}
+
+func testCVCGate(timeout time.Duration, client *Client) *CVCReadinessGate {
+	return NewCVCReadinessGate(NewResourcePatch(
+		WithOpenebsNamespace(upgradetesting.Namespace),
+		WithPollInterval(time.Millisecond),
+		WithCVCReadinessTimeout(timeout),
+	), client)
+}
+
+func TestCVCReadinessGate_pendingCVCs(t *testing.T) {
+	client := NewTestClient(
+		testCVC("pvc-1", cstor.CStorVolumeConfigPhaseBound),
+		testCVC("pvc-2", cstor.CStorVolumeConfigPhasePending),
+		testCVC("pvc-3", cstor.CStorVolumeConfigPhaseFailed),
+		testCVC("pvc-4", ""),
+	)
+	got, err := testCVCGate(time.Second, client).pendingCVCs()
+	if err != nil {
+		t.Fatalf("pendingCVCs() error = %v", err)
+	}
+	if want := []string{"pvc-2", "pvc-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pendingCVCs() = %v, want %v", got, want)
+	}
+}
+
+func TestCVCReadinessGate_Wait(t *testing.T) {
+	cvc := testCVC("pvc-1", cstor.CStorVolumeConfigPhasePending)
+
+	// the gate is disabled without a timeout
+	client := NewTestClient(cvc)
+	if err := testCVCGate(0, client).Wait(); err != nil {
+		t.Errorf("Wait() without a timeout error = %v", err)
+	}
+
+	err := testCVCGate(20*time.Millisecond, client).Wait()
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Wait() error = %v, want a timeout error", err)
+	}
+
+	bound := cvc.DeepCopy()
+	bound.Status.Phase = cstor.CStorVolumeConfigPhaseBound
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.OpenebsClientset.CstorV1().CStorVolumeConfigs(bound.Namespace).
+			Update(context.TODO(), bound, metav1.UpdateOptions{})
+	}()
+	if err := testCVCGate(time.Second, client).Wait(); err != nil {
+		t.Errorf("Wait() error = %v, want the cvc to be bound", err)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// resizeTimeout is the time to wait for the cvc-operator
+// to complete a pending resize of a cstor volume
+const resizeTimeout = 10 * time.Minute
+
+// pendingResize returns the capacity a pending resize of the cvc resizes
+// it from and to, if the capacity in its status differs from its spec.
+// A cvc without a capacity in its status is not provisioned yet.
+func pendingResize(cvcObj *cstor.CStorVolumeConfig) (from, to string, pending bool) {
+	status, ok := cvcObj.Status.Capacity[corev1.ResourceStorage]
+	if !ok {
+		return "", "", false
+	}
+	spec := cvcObj.Spec.Capacity[corev1.ResourceStorage]
+	if spec.Cmp(status) == 0 {
+		return "", "", false
+	}
+	return status.String(), spec.String(), true
+}
+
+// handlePendingResize fails the upgrade if the cvc of the volume has a
+// pending resize with FailOnPendingResize, or else waits for the old
+// cvc-operator to complete the resize, as the upgraded target may not
+// support the resize protocol of the old operator
+func (obj *CStorVolumePatch) handlePendingResize() error {
+	from, to, pending := pendingResize(obj.CVC.Object)
+	if !pending {
+		return nil
+	}
+	if obj.FailOnPendingResize {
+		return errors.Errorf(
+			"cvc %s has a pending resize from %s to %s, wait for the resize to complete "+
+				"or run the upgrade without --fail-on-pending-resize",
+			obj.Name, from, to,
+		)
+	}
+	log := &waitLogger{}
+	deadline := time.Now().Add(resizeTimeout)
+	for pending {
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf(
+				"timed out waiting for the resize of cvc %s from %s to %s",
+				obj.Name, from, to,
+			))
+		}
+		log.Infof("cvc %s: waiting for the resize from %s to %s to complete", obj.Name, from, to)
+		time.Sleep(obj.backoff().Delay(obj.pollInterval(), log.polls))
+		err := obj.CVC.Get(obj.Name, obj.Namespace)
+		if err != nil {
+			return err
+		}
+		from, to, pending = pendingResize(obj.CVC.Object)
+	}
+	klog.Infof("cvc %s: pending resize completed", obj.Name)
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testResizeCVC returns a cvc with the given capacities,
+// the status capacity is not set if empty
+func testResizeCVC(spec, status string) *cstor.CStorVolumeConfig {
+	cvcObj := upgradetesting.NewTestCVC("pvc-1", "2.12.0")
+	cvcObj.Spec.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(spec)}
+	if status != "" {
+		cvcObj.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(status)}
+	}
+	return cvcObj
+}
+
+func TestPendingResize(t *testing.T) {
+	tests := []struct {
+		name        string
+		cvc         *cstor.CStorVolumeConfig
+		wantPending bool
+		wantFrom    string
+		wantTo      string
+	}{
+		{name: "resized", cvc: testResizeCVC("10Gi", "10Gi")},
+		{name: "same capacity in other units", cvc: testResizeCVC("1Gi", "1024Mi")},
+		{name: "not provisioned", cvc: testResizeCVC("10Gi", "")},
+		{name: "pending resize", cvc: testResizeCVC("20Gi", "10Gi"), wantPending: true, wantFrom: "10Gi", wantTo: "20Gi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, pending := pendingResize(tt.cvc)
+			if pending != tt.wantPending || from != tt.wantFrom || to != tt.wantTo {
+				t.Errorf("pendingResize() = %s, %s, %v, want %s, %s, %v",
+					from, to, pending, tt.wantFrom, tt.wantTo, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestCStorVolumePatch_handlePendingResize(t *testing.T) {
+	newPatch := func(client *Client, fail bool) *CStorVolumePatch {
+		obj := NewCStorVolumePatch(
+			WithCStorVolumeResorcePatch(NewResourcePatch(
+				WithName("pvc-1"),
+				WithOpenebsNamespace(upgradetesting.Namespace),
+				WithPollInterval(time.Millisecond),
+				WithFailOnPendingResize(fail),
+			)),
+			WithCStorVolumeClient(client),
+		)
+		obj.Namespace = upgradetesting.Namespace
+		obj.CVC = patch.NewCVC(patch.WithCVCClient(client.OpenebsClientset))
+		if err := obj.CVC.Get(obj.Name, obj.Namespace); err != nil {
+			t.Fatal(err)
+		}
+		return obj
+	}
+
+	client := NewTestClient(testResizeCVC("10Gi", "10Gi"))
+	if err := newPatch(client, true).handlePendingResize(); err != nil {
+		t.Errorf("handlePendingResize() error = %v without a pending resize", err)
+	}
+
+	client = NewTestClient(testResizeCVC("20Gi", "10Gi"))
+	if err := newPatch(client, true).handlePendingResize(); err == nil {
+		t.Errorf("handlePendingResize() error = nil with --fail-on-pending-resize")
+	}
+
+	obj := newPatch(client, false)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.OpenebsClientset.CstorV1().CStorVolumeConfigs(upgradetesting.Namespace).
+			Update(context.TODO(), testResizeCVC("20Gi", "20Gi"), metav1.UpdateOptions{})
+	}()
+	if err := obj.handlePendingResize(); err != nil {
+		t.Errorf("handlePendingResize() error = %v, want the resize to complete", err)
+	}
+	if _, _, pending := pendingResize(obj.CVC.Object); pending {
+		t.Errorf("cvc capacity = %v, want the resized cvc", obj.CVC.Object.Status.Capacity)
+	}
+}
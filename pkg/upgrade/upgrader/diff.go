@@ -0,0 +1,302 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+const (
+	// DiffText and DiffJSON are the supported formats of the diffs
+	// of the dry run, the json format also has the raw patch
+	DiffText = "text"
+	DiffJSON = "json"
+
+	// the ansi colors of the added, removed and changed fields
+	diffColorAdded   = "\x1b[32m"
+	diffColorRemoved = "\x1b[31m"
+	diffColorChanged = "\x1b[33m"
+	diffColorReset   = "\x1b[0m"
+)
+
+// FieldChange is the change of a field of an object, with the path of
+// the field like spec.template.spec.containers[name=cstor-pool].image.
+// Old is nil for an added field and New is nil for a removed one.
+type FieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// ObjectDiff is the diff of an object patched by the upgrade
+type ObjectDiff struct {
+	Kind    string          `json:"kind"`
+	Name    string          `json:"name"`
+	Changes []FieldChange   `json:"changes"`
+	Patch   json.RawMessage `json:"patch,omitempty"`
+}
+
+// ValidateDiffFormat returns a validation error
+// if the diff format is not supported
+func ValidateDiffFormat(format string) error {
+	switch format {
+	case DiffText, DiffJSON:
+		return nil
+	}
+	return newValidationError(
+		errors.Errorf("invalid diff format %q, expected one of text or json", format),
+	)
+}
+
+// DiffObjects returns the changes of the fields from before to after,
+// sorted by their path. The nested objects and arrays are compared
+// field by field, so only their changed leaves are returned.
+func DiffObjects(before, after interface{}) ([]FieldChange, error) {
+	oldValue, err := toJSONValue(before)
+	if err != nil {
+		return nil, err
+	}
+	newValue, err := toJSONValue(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := []FieldChange{}
+	diffValues("", oldValue, newValue, &changes)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// NewObjectDiff returns the diff of the object of the given kind and
+// name with the patch applied, which is a strategic merge patch for the
+// typed objects and a json merge patch for the unstructured ones
+func NewObjectDiff(kind, name string, before interface{}, patch []byte) (*ObjectDiff, error) {
+	original, err := json.Marshal(before)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal %s %s", kind, name)
+	}
+	var patched []byte
+	if _, ok := before.(*unstructured.Unstructured); ok {
+		patched, err = jsonpatch.MergePatch(original, patch)
+	} else {
+		patched, err = strategicpatch.StrategicMergePatch(original, patch, before)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to apply the patch of %s %s", kind, name)
+	}
+	changes, err := DiffObjects(json.RawMessage(original), json.RawMessage(patched))
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectDiff{Kind: kind, Name: name, Changes: changes, Patch: json.RawMessage(patch)}, nil
+}
+
+// Write writes the diff to w in the given diff format. The text format
+// has a line per changed field as path: old -> new, colored with the
+// ansi colors if color is set. The json format is never colored.
+func (d *ObjectDiff) Write(w io.Writer, format string, color bool) error {
+	err := ValidateDiffFormat(format)
+	if err != nil {
+		return err
+	}
+	if format == DiffJSON {
+		out, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal the diff of %s %s", d.Kind, d.Name)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	fmt.Fprintf(w, "%s %s:\n", d.Kind, d.Name)
+	if len(d.Changes) == 0 {
+		_, err = fmt.Fprintln(w, "  no changes")
+		return err
+	}
+	for _, c := range d.Changes {
+		sign, ansi := "~", diffColorChanged
+		line := fmt.Sprintf("%s: %s -> %s", c.Path, diffValue(c.Old), diffValue(c.New))
+		switch {
+		case c.Old == nil:
+			sign, line, ansi = "+", fmt.Sprintf("%s: %s", c.Path, diffValue(c.New)), diffColorAdded
+		case c.New == nil:
+			sign, line, ansi = "-", fmt.Sprintf("%s: %s", c.Path, diffValue(c.Old)), diffColorRemoved
+		}
+		if color {
+			_, err = fmt.Fprintf(w, "  %s%s %s%s\n", ansi, sign, line, diffColorReset)
+		} else {
+			_, err = fmt.Fprintf(w, "  %s %s\n", sign, line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toJSONValue returns the object as the generic value of its json
+func toJSONValue(obj interface{}) (interface{}, error) {
+	data, ok := obj.(json.RawMessage)
+	if !ok {
+		var err error
+		data, err = json.Marshal(obj)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to marshal object to diff")
+		}
+	}
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal object to diff")
+	}
+	return value, nil
+}
+
+// diffValues appends the changes from oldValue to newValue at the path
+func diffValues(path string, oldValue, newValue interface{}, changes *[]FieldChange) {
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		for key, value := range oldMap {
+			diffValues(fieldPath(path, key), value, newMap[key], changes)
+		}
+		for key, value := range newMap {
+			if _, ok := oldMap[key]; !ok {
+				diffValues(fieldPath(path, key), nil, value, changes)
+			}
+		}
+		return
+	}
+	oldList, oldIsList := oldValue.([]interface{})
+	newList, newIsList := newValue.([]interface{})
+	if oldIsList && newIsList {
+		diffLists(path, oldList, newList, changes)
+		return
+	}
+	// the added or removed objects are listed by their leaves
+	if oldValue == nil && (newIsMap && len(newMap) != 0 || newIsList && len(newList) != 0) {
+		diffValues(path, emptyLike(newValue), newValue, changes)
+		return
+	}
+	if newValue == nil && (oldIsMap && len(oldMap) != 0 || oldIsList && len(oldList) != 0) {
+		diffValues(path, oldValue, emptyLike(oldValue), changes)
+		return
+	}
+	if oldValue == nil && newValue == nil {
+		return
+	}
+	oldJSON, _ := json.Marshal(oldValue)
+	newJSON, _ := json.Marshal(newValue)
+	if string(oldJSON) != string(newJSON) {
+		*changes = append(*changes, FieldChange{Path: path, Old: oldValue, New: newValue})
+	}
+}
+
+// diffLists appends the changes of the elements of the lists, which are
+// matched by their name if all of them are objects with a name, like
+// the containers, and by their index otherwise
+func diffLists(path string, oldList, newList []interface{}, changes *[]FieldChange) {
+	oldNames, oldNamed := listNames(oldList)
+	newNames, newNamed := listNames(newList)
+	if oldNamed && newNamed {
+		for i, name := range oldNames {
+			diffValues(path+"[name="+name+"]", oldList[i], namedElement(newList, newNames, name), changes)
+		}
+		for i, name := range newNames {
+			if namedElement(oldList, oldNames, name) == nil {
+				diffValues(path+"[name="+name+"]", nil, newList[i], changes)
+			}
+		}
+		return
+	}
+	for i := 0; i < len(oldList) || i < len(newList); i++ {
+		var oldValue, newValue interface{}
+		if i < len(oldList) {
+			oldValue = oldList[i]
+		}
+		if i < len(newList) {
+			newValue = newList[i]
+		}
+		diffValues(path+"["+strconv.Itoa(i)+"]", oldValue, newValue, changes)
+	}
+}
+
+// listNames returns the names of the elements of the list, and false
+// if any of them is not an object with a name
+func listNames(list []interface{}) ([]string, bool) {
+	names := []string{}
+	for _, e := range list {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := m["name"].(string)
+		if !ok {
+			return nil, false
+		}
+		names = append(names, name)
+	}
+	return names, len(list) != 0
+}
+
+func namedElement(list []interface{}, names []string, name string) interface{} {
+	for i, n := range names {
+		if n == name {
+			return list[i]
+		}
+	}
+	return nil
+}
+
+func emptyLike(value interface{}) interface{} {
+	if _, ok := value.([]interface{}); ok {
+		return []interface{}{}
+	}
+	return map[string]interface{}{}
+}
+
+// plainKey matches the keys which need no quoting in a path
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// fieldPath returns the path of the key of the object at the path, the
+// keys with dots or slashes, like most labels, are quoted in brackets
+func fieldPath(path, key string) string {
+	if !plainKey.MatchString(key) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// diffValue returns the compact json of the value of a change
+func diffValue(value interface{}) string {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestDiffObjects(t *testing.T) {
+	before := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{"openebs.io/version": "2.12.0", "app": "pool"},
+		},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "cstor-pool", "image": "openebs/cstor-pool:2.12.0"},
+				map[string]interface{}{"name": "sidecar", "image": "sidecar:1"},
+			},
+			"args":    []interface{}{"-v", "2"},
+			"removed": map[string]interface{}{"a": 1, "b": true},
+		},
+	}
+	after := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{"openebs.io/version": "3.0.0", "app": "pool"},
+		},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "sidecar", "image": "sidecar:1"},
+				map[string]interface{}{"name": "cstor-pool", "image": "openebs/cstor-pool:3.0.0"},
+			},
+			"args":  []interface{}{"-v", "4", "--new"},
+			"added": map[string]interface{}{"nested": map[string]interface{}{"x": "y"}},
+		},
+	}
+	got, err := DiffObjects(before, after)
+	if err != nil {
+		t.Fatalf("DiffObjects() error = %v", err)
+	}
+	want := []FieldChange{
+		{Path: `metadata.labels["openebs.io/version"]`, Old: "2.12.0", New: "3.0.0"},
+		{Path: "spec.added.nested.x", New: "y"},
+		{Path: "spec.args[1]", Old: "2", New: "4"},
+		{Path: "spec.args[2]", New: "--new"},
+		{Path: "spec.containers[name=cstor-pool].image", Old: "openebs/cstor-pool:2.12.0", New: "openebs/cstor-pool:3.0.0"},
+		{Path: "spec.removed.a", Old: float64(1)},
+		{Path: "spec.removed.b", Old: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffObjects() = %#v, want %#v", got, want)
+	}
+}
+
+func TestNewObjectDiff(t *testing.T) {
+	// the service account is read from the operator pods by other tests
+	defer func(sa string) { cstorOperatorServiceAccount = sa }(cstorOperatorServiceAccount)
+	cstorOperatorServiceAccount = "openebs-cstor-operator"
+	deploy := poolDeployment(
+		corev1.Container{Name: "cstor-pool", Image: "openebs/cstor-pool:2.12.0"},
+		corev1.Container{Name: poolManagerContainer, Image: "openebs/cstor-pool-manager:2.12.0"},
+	)
+	newDeploy := deploy.DeepCopy()
+	if err := transformCSPIDeploy(newDeploy, &ResourcePatch{To: "3.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	patch, err := GetPatchData(deploy, newDeploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewObjectDiff("deployment", deploy.Name, deploy, patch)
+	if err != nil {
+		t.Fatalf("NewObjectDiff() error = %v", err)
+	}
+	var text bytes.Buffer
+	if err := d.Write(&text, DiffText, false); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	for _, want := range []string{
+		"deployment cspc-stripe-b9f6:\n",
+		`  ~ spec.template.spec.containers[name=cstor-pool].image: "openebs/cstor-pool:2.12.0" -> "openebs/cstor-pool:3.0.0"`,
+		`  + metadata.labels["openebs.io/version"]: "3.0.0"`,
+		`  + spec.template.spec.serviceAccountName: "openebs-cstor-operator"`,
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("Write() = %q, want it to contain %q", text.String(), want)
+		}
+	}
+	var colored bytes.Buffer
+	if err := d.Write(&colored, DiffText, true); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !strings.Contains(colored.String(), diffColorAdded+"+ metadata.labels") {
+		t.Errorf("Write() with color = %q, want the added fields in green", colored.String())
+	}
+	var out bytes.Buffer
+	if err := d.Write(&out, DiffJSON, true); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got := ObjectDiff{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal json diff %q: %v", out.String(), err)
+	}
+	if len(got.Changes) != len(d.Changes) || string(got.Patch) == "" {
+		t.Errorf("json diff = %+v, want the changes and the raw patch", got)
+	}
+	if err := d.Write(&out, "yaml", false); err == nil {
+		t.Errorf("Write() of an invalid format did not fail")
+	}
+}
+
+func TestNewObjectDiffUnstructured(t *testing.T) {
+	obj := newTestCDEConfig("config", "2.12.0", "", map[string]interface{}{
+		"io": map[string]interface{}{"queueDepth": int64(32)},
+	})
+	d, err := NewObjectDiff("cstorEngineConfig", "config", obj, []byte(`{"spec":{"io":{"queueDepth":64}}}`))
+	if err != nil {
+		t.Fatalf("NewObjectDiff() error = %v", err)
+	}
+	want := []FieldChange{{Path: "spec.io.queueDepth", Old: float64(32), New: float64(64)}}
+	if !reflect.DeepEqual(d.Changes, want) {
+		t.Errorf("NewObjectDiff() changes = %#v, want %#v", d.Changes, want)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// The environment variables read by LoadFromEnv, which are
+// used to configure the upgrade when it is run as a Job
+const (
+	EnvOpenebsNamespace          = "OPENEBS_NAMESPACE"
+	EnvFromVersion               = "FROM_VERSION"
+	EnvToVersion                 = "TO_VERSION"
+	EnvToVersionImageTag         = "TO_VERSION_IMAGE_TAG"
+	EnvToVersionImagePrefix      = "TO_VERSION_IMAGE_PREFIX"
+	EnvResourceName              = "RESOURCE_NAME"
+	EnvImageRegistryOverride     = "IMAGE_REGISTRY_OVERRIDE"
+	EnvVerifyOnly                = "VERIFY_ONLY"
+	EnvMaxUnavailable            = "MAX_UNAVAILABLE"
+	EnvMaxParallelVolumes        = "MAX_PARALLEL_VOLUMES"
+	EnvMinFreePoolSpace          = "MIN_FREE_POOL_SPACE"
+	EnvAllowOverprovisioned      = "ALLOW_OVERPROVISIONED"
+	EnvAllowRBACRemoval          = "ALLOW_RBAC_REMOVAL"
+	EnvTransientMessages         = "TRANSIENT_MESSAGES"
+	EnvReconcileFailureThreshold = "RECONCILE_FAILURE_THRESHOLD"
+	EnvPollInterval              = "POLL_INTERVAL"
+	EnvVersionStabilityPolls     = "VERSION_STABILITY_POLLS"
+	EnvReconcileTimeout          = "RECONCILE_TIMEOUT"
+	EnvReconcileStablePeriod     = "RECONCILE_STABLE_PERIOD"
+	EnvPatchReapplyAttempts      = "PATCH_REAPPLY_ATTEMPTS"
+	EnvMaxParallelVerify         = "MAX_PARALLEL_VERIFY"
+	EnvSmokeTestStorageClass     = "SMOKE_TEST_STORAGE_CLASS"
+	EnvSkipUpgradeAnnotation     = "SKIP_UPGRADE_ANNOTATION"
+	EnvOperatorVersionLabel      = "OPERATOR_VERSION_LABEL"
+	EnvPreUpgradeHook            = "PRE_UPGRADE_HOOK"
+	EnvPostUpgradeHook           = "POST_UPGRADE_HOOK"
+	EnvHookTimeout               = "HOOK_TIMEOUT"
+	EnvWaitForRebuild            = "WAIT_FOR_REBUILD"
+	EnvRebuildTimeout            = "REBUILD_TIMEOUT"
+	EnvVerifyImages              = "VERIFY_IMAGES"
+	EnvImagePullSecret           = "IMAGE_PULL_SECRET"
+	EnvNodeAwareScheduling       = "NODE_AWARE_SCHEDULING"
+	EnvNUMAAware                 = "NUMA_AWARE"
+	EnvIgnoreNodePressure        = "IGNORE_NODE_PRESSURE"
+	EnvPoolManagerImage          = "CSPI_MANAGER_IMAGE_OVERRIDE"
+	EnvVerifyPoolCount           = "VERIFY_POOL_COUNT"
+	EnvCompletePendingExpansion  = "COMPLETE_PENDING_EXPANSION"
+	EnvLargeUpgradeThreshold     = "LARGE_UPGRADE_THRESHOLD"
+	EnvConfirmLargeUpgrade       = "CONFIRM_LARGE_UPGRADE"
+	EnvTargetCSPI                = "TARGET_CSPI"
+	EnvVerifyBlockDevices        = "VERIFY_BLOCK_DEVICES"
+	EnvVerifyPodImages           = "VERIFY_POD_IMAGES"
+	EnvBackoffStrategy           = "BACKOFF_STRATEGY"
+	EnvCVCReadinessTimeout       = "CVC_READINESS_TIMEOUT"
+	EnvLVMThinPool               = "LVM_THIN_POOL"
+	EnvLVMThinPoolSettings       = "LVM_THIN_POOL_SETTINGS"
+	EnvAllowInUseUpgrades        = "ALLOW_IN_USE_UPGRADES"
+	EnvMinHealthyReplicas        = "MIN_HEALTHY_REPLICAS"
+	EnvReplicaWaitTimeout        = "REPLICA_WAIT_TIMEOUT"
+	EnvFailOnPendingResize       = "FAIL_ON_PENDING_RESIZE"
+	EnvSkipKubeVersionCheck      = "SKIP_KUBERNETES_VERSION_CHECK"
+	EnvUpdateISCSIPortal         = "UPDATE_ISCSI_PORTAL"
+	EnvUpgradeClones             = "UPGRADE_CLONES"
+	EnvSnapshotDriver            = "SNAPSHOT_DRIVER"
+	EnvStorageClassProvisioner   = "STORAGE_CLASS_PROVISIONER"
+	EnvRequireApproval           = "REQUIRE_APPROVAL"
+	EnvConsolidateUpgradeTasks   = "CONSOLIDATE_UPGRADETASKS"
+)
+
+// envLoader parses the environment variables into typed values,
+// keeping the first error which names the offending variable
+type envLoader struct {
+	lookup func(string) (string, bool)
+	err    error
+}
+
+func (l *envLoader) get(name string) (string, bool) {
+	if l.err != nil {
+		return "", false
+	}
+	value, ok := l.lookup(name)
+	value = strings.TrimSpace(value)
+	return value, ok && value != ""
+}
+
+func (l *envLoader) fail(name, value, expected string) {
+	l.err = newValidationError(
+		errors.Errorf("invalid value %q of environment variable %s, expected %s", value, name, expected),
+	)
+}
+
+func (l *envLoader) string(name string, p *string) {
+	if value, ok := l.get(name); ok {
+		*p = value
+	}
+}
+
+func (l *envLoader) bool(name string, p *bool) {
+	value, ok := l.get(name)
+	if !ok {
+		return
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.fail(name, value, "true or false")
+		return
+	}
+	*p = b
+}
+
+func (l *envLoader) int(name string, p *int, min, max int) {
+	value, ok := l.get(name)
+	if !ok {
+		return
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < min || i > max {
+		l.fail(name, value, "an integer from "+strconv.Itoa(min)+" to "+strconv.Itoa(max))
+		return
+	}
+	*p = i
+}
+
+func (l *envLoader) duration(name string, p *time.Duration) {
+	value, ok := l.get(name)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		l.fail(name, value, "a positive duration like 30s or 5m")
+		return
+	}
+	*p = d
+}
+
+func (l *envLoader) list(name string, p *[]string) {
+	value, ok := l.get(name)
+	if !ok {
+		return
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*p = list
+}
+
+func (l *envLoader) intOrPercent(name string, p **intstr.IntOrString) {
+	value, ok := l.get(name)
+	if !ok {
+		return
+	}
+	v := intstr.Parse(value)
+	_, err := intstr.GetValueFromIntOrPercent(&v, 100, false)
+	if err != nil || (v.Type == intstr.Int && v.IntValue() < 0) {
+		l.fail(name, value, "a number or a percentage like 1 or 25%")
+		return
+	}
+	*p = &v
+}
+
+func (l *envLoader) backoff(name string, p *BackoffStrategy) {
+	value, ok := l.get(name)
+	if !ok {
+		return
+	}
+	b, err := NewBackoffStrategy(value)
+	if err != nil {
+		l.fail(name, value, "one of constant, exponential or capped-exponential")
+		return
+	}
+	*p = b
+}
+
+// LoadFromEnv returns the ResourcePatch configured by the environment
+// variables. The variables that are not set or empty are left as the
+// zero value, and an invalid value returns a validation error naming
+// the variable.
+func LoadFromEnv() (*ResourcePatch, error) {
+	return loadFromEnv(os.LookupEnv)
+}
+
+func loadFromEnv(lookup func(string) (string, bool)) (*ResourcePatch, error) {
+	r := &ResourcePatch{}
+	l := &envLoader{lookup: lookup}
+	l.string(EnvOpenebsNamespace, &r.OpenebsNamespace)
+	l.string(EnvFromVersion, &r.From)
+	l.string(EnvToVersion, &r.To)
+	l.string(EnvToVersionImageTag, &r.ImageTag)
+	l.string(EnvToVersionImagePrefix, &r.BaseURL)
+	l.string(EnvImageRegistryOverride, &r.ImageRegistry)
+	l.bool(EnvVerifyOnly, &r.VerifyOnly)
+	l.intOrPercent(EnvMaxUnavailable, &r.MaxUnavailable)
+	l.int(EnvMaxParallelVolumes, &r.MaxParallelVolumes, 1, math.MaxInt32)
+	l.int(EnvMinFreePoolSpace, &r.MinFreePoolSpace, 0, 100)
+	l.bool(EnvAllowOverprovisioned, &r.AllowOverprovisioned)
+	l.bool(EnvAllowRBACRemoval, &r.AllowRBACRemoval)
+	l.list(EnvTransientMessages, &r.TransientMessages)
+	l.int(EnvReconcileFailureThreshold, &r.ReconcileFailureThreshold, 0, math.MaxInt32)
+	l.duration(EnvPollInterval, &r.PollInterval)
+	l.int(EnvVersionStabilityPolls, &r.VersionStabilityPolls, 0, math.MaxInt32)
+	l.duration(EnvReconcileTimeout, &r.ReconcileTimeout)
+	l.duration(EnvReconcileStablePeriod, &r.ReconcileStablePeriod)
+	l.int(EnvPatchReapplyAttempts, &r.PatchReapplyAttempts, 0, math.MaxInt32)
+	l.int(EnvMaxParallelVerify, &r.MaxParallelVerify, 1, math.MaxInt32)
+	l.string(EnvSmokeTestStorageClass, &r.SmokeTestStorageClass)
+	l.string(EnvSkipUpgradeAnnotation, &r.SkipUpgradeAnnotation)
+	l.string(EnvOperatorVersionLabel, &r.OperatorVersionLabel)
+	l.string(EnvPreUpgradeHook, &r.PreUpgradeHook)
+	l.string(EnvPostUpgradeHook, &r.PostUpgradeHook)
+	l.duration(EnvHookTimeout, &r.HookTimeout)
+	l.bool(EnvWaitForRebuild, &r.WaitForRebuild)
+	l.duration(EnvRebuildTimeout, &r.RebuildTimeout)
+	l.bool(EnvVerifyImages, &r.VerifyImages)
+	l.string(EnvImagePullSecret, &r.ImagePullSecret)
+	l.bool(EnvNodeAwareScheduling, &r.NodeAwareScheduling)
+	l.bool(EnvNUMAAware, &r.NUMAAware)
+	l.bool(EnvIgnoreNodePressure, &r.IgnoreNodePressure)
+	l.string(EnvPoolManagerImage, &r.PoolManagerImage)
+	l.bool(EnvVerifyPoolCount, &r.VerifyPoolCount)
+	l.bool(EnvCompletePendingExpansion, &r.CompletePendingExpansion)
+	l.int(EnvLargeUpgradeThreshold, &r.LargeUpgradeThreshold, 0, math.MaxInt32)
+	l.bool(EnvConfirmLargeUpgrade, &r.ConfirmLargeUpgrade)
+	l.string(EnvTargetCSPI, &r.TargetCSPI)
+	l.bool(EnvVerifyBlockDevices, &r.VerifyBlockDevices)
+	l.bool(EnvVerifyPodImages, &r.VerifyPodImages)
+	l.backoff(EnvBackoffStrategy, &r.Backoff)
+	l.duration(EnvCVCReadinessTimeout, &r.CVCReadinessTimeout)
+	l.string(EnvLVMThinPool, &r.LVMThinPool)
+	l.list(EnvLVMThinPoolSettings, &r.LVMThinPoolSettings)
+	l.bool(EnvAllowInUseUpgrades, &r.AllowInUseUpgrades)
+	l.int(EnvMinHealthyReplicas, &r.MinHealthyReplicas, -1, math.MaxInt32)
+	l.duration(EnvReplicaWaitTimeout, &r.ReplicaWaitTimeout)
+	l.bool(EnvFailOnPendingResize, &r.FailOnPendingResize)
+	l.bool(EnvSkipKubeVersionCheck, &r.SkipKubernetesVersionCheck)
+	l.bool(EnvUpdateISCSIPortal, &r.UpdateISCSIPortal)
+	l.bool(EnvUpgradeClones, &r.UpgradeClones)
+	l.string(EnvSnapshotDriver, &r.SnapshotDriver)
+	l.string(EnvStorageClassProvisioner, &r.StorageClassProvisioner)
+	l.bool(EnvRequireApproval, &r.RequireApproval)
+	l.bool(EnvConsolidateUpgradeTasks, &r.ConsolidateUpgradeTasks)
+	if l.err != nil {
+		return nil, l.err
+	}
+	return r, nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	maxUnavailable := intstr.Parse("25%")
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    *ResourcePatch
+		wantErr string
+	}{
+		{
+			name: "empty",
+			env:  map[string]string{EnvFromVersion: "  "},
+			want: &ResourcePatch{},
+		},
+		{
+			name: "all the types",
+			env: map[string]string{
+				EnvOpenebsNamespace:    "openebs",
+				EnvFromVersion:         "2.12.0",
+				EnvToVersion:           "3.0.0",
+				EnvVerifyOnly:          "true",
+				EnvMaxUnavailable:      "25%",
+				EnvMinFreePoolSpace:    "20",
+				EnvTransientMessages:   "connection refused, ,timeout",
+				EnvPollInterval:        "30s",
+				EnvNodeAwareScheduling: "1",
+			},
+			want: &ResourcePatch{
+				OpenebsNamespace:    "openebs",
+				From:                "2.12.0",
+				To:                  "3.0.0",
+				VerifyOnly:          true,
+				MaxUnavailable:      &maxUnavailable,
+				MinFreePoolSpace:    20,
+				TransientMessages:   []string{"connection refused", "timeout"},
+				PollInterval:        30 * time.Second,
+				NodeAwareScheduling: true,
+			},
+		},
+		{
+			name:    "invalid bool",
+			env:     map[string]string{EnvWaitForRebuild: "yes"},
+			wantErr: "WAIT_FOR_REBUILD",
+		},
+		{
+			name:    "int out of range",
+			env:     map[string]string{EnvMinFreePoolSpace: "120"},
+			wantErr: "MIN_FREE_POOL_SPACE",
+		},
+		{
+			name:    "negative duration",
+			env:     map[string]string{EnvHookTimeout: "-5m"},
+			wantErr: "HOOK_TIMEOUT",
+		},
+		{
+			name:    "invalid percentage",
+			env:     map[string]string{EnvMaxUnavailable: "a lot"},
+			wantErr: "MAX_UNAVAILABLE",
+		},
+		{
+			name:    "invalid backoff strategy",
+			env:     map[string]string{EnvBackoffStrategy: "linear"},
+			wantErr: "BACKOFF_STRATEGY",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadFromEnv(envLookup(tt.env))
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadFromEnv() error = %v, want validation error for %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadFromEnv() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadFromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_process(t *testing.T) {
+	defer os.Unsetenv(EnvToVersion)
+	defer os.Unsetenv(EnvBackoffStrategy)
+	os.Setenv(EnvToVersion, "3.0.0")
+	os.Setenv(EnvBackoffStrategy, "constant")
+	got, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv() error = %v", err)
+	}
+	if got.To != "3.0.0" || got.Backoff == nil {
+		t.Errorf("LoadFromEnv() = %+v, want the to version and backoff of the environment", got)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrValidation is the kind of error returned when the resource
+	// or the upgrade options fail the pre-upgrade checks
+	ErrValidation = errors.New("validation failed")
+	// ErrTimeout is the kind of error returned when a wait for the
+	// resource exceeds its deadline
+	ErrTimeout = errors.New("timed out")
+	// ErrAPI is the kind of error returned when a call to the
+	// kubernetes api server fails
+	ErrAPI = errors.New("api request failed")
+	// ErrPartialFailure is the kind of error returned when the upgrade
+	// fails after some of the dependent resources were upgraded
+	ErrPartialFailure = errors.New("upgrade partially failed")
+
+	// ErrOperatorNotUpgraded and ErrOperatorUnavailable are the causes
+	// of the validation errors of an operator which is not running the
+	// to version, or which is running it but is not available
+	ErrOperatorNotUpgraded = errors.New("operator not upgraded")
+	ErrOperatorUnavailable = errors.New("operator not available")
+)
+
+// Error wraps the cause of an upgrade failure with the kind of the failure.
+// The kind can be checked using errors.Is and the Error using errors.As.
+type Error struct {
+	Kind  error
+	Cause error
+}
+
+func (e *Error) Error() string {
+	return e.Cause.Error()
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether the error is of the given kind
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+func newError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Cause: err}
+}
+
+func newValidationError(err error) error {
+	return newError(ErrValidation, err)
+}
+
+func newTimeoutError(err error) error {
+	return newError(ErrTimeout, err)
+}
+
+func newAPIError(err error) error {
+	return newError(ErrAPI, err)
+}
+
+func newPartialFailureError(err error) error {
+	return newError(ErrPartialFailure, err)
+}
+
+// ObjectError is the error of a call to the api server with the
+// operation and the object it failed on, as the errors of the
+// clientsets do not tell which object they are about. The error
+// of the clientset is kept as its cause, so that it can still be
+// checked using errors.Is or the functions of k8serrors.
+type ObjectError struct {
+	// Op is the operation of the call, like get, list or patch
+	Op        string
+	Kind      string
+	Namespace string
+	// Name is empty for a list of the objects of the kind
+	Name string
+	Err  error
+}
+
+func (e *ObjectError) Error() string {
+	object := e.Kind
+	switch {
+	case e.Name != "" && e.Namespace != "":
+		object += " " + e.Namespace + "/" + e.Name
+	case e.Name != "":
+		object += " " + e.Name
+	case e.Namespace != "":
+		object += "s in " + e.Namespace
+	default:
+		object += "s"
+	}
+	return fmt.Sprintf("failed to %s %s: %v", e.Op, object, e.Err)
+}
+
+// Unwrap returns the error of the clientset
+func (e *ObjectError) Unwrap() error {
+	return e.Err
+}
+
+// newObjectError returns the ObjectError of the failed operation on
+// the object, it returns nil if the error is nil
+func newObjectError(op, kind, namespace, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ObjectError{Op: op, Kind: kind, Namespace: namespace, Name: name, Err: err}
+}
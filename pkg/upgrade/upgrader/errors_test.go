@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestErrorKinds(t *testing.T) {
+	cause := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		err      error
+		kinds    []error
+		notKinds []error
+	}{
+		{
+			name:     "api error",
+			err:      newAPIError(cause),
+			kinds:    []error{ErrAPI},
+			notKinds: []error{ErrValidation, ErrTimeout, ErrPartialFailure},
+		},
+		{
+			name:     "wrapped validation error",
+			err:      errors.Wrap(newValidationError(cause), "failed to upgrade"),
+			kinds:    []error{ErrValidation},
+			notKinds: []error{ErrAPI},
+		},
+		{
+			name:  "partial failure of an api error",
+			err:   newPartialFailureError(newAPIError(cause)),
+			kinds: []error{ErrPartialFailure, ErrAPI},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, kind := range tt.kinds {
+				if !errors.Is(tt.err, kind) {
+					t.Errorf("errors.Is(%v, %v) = false, want true", tt.err, kind)
+				}
+			}
+			for _, kind := range tt.notKinds {
+				if errors.Is(tt.err, kind) {
+					t.Errorf("errors.Is(%v, %v) = true, want false", tt.err, kind)
+				}
+			}
+			if !errors.Is(tt.err, cause) {
+				t.Errorf("errors.Is(%v, cause) = false, want true", tt.err)
+			}
+			var upgradeErr *Error
+			if !errors.As(tt.err, &upgradeErr) {
+				t.Errorf("errors.As(%v, *Error) = false, want true", tt.err)
+			}
+		})
+	}
+	if newAPIError(nil) != nil {
+		t.Errorf("newAPIError(nil) should be nil")
+	}
+}
+
+func TestObjectError(t *testing.T) {
+	notFound := k8serrors.NewNotFound(schema.GroupResource{Resource: "cstorpoolinstances"}, "cspc-a-1")
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "namespaced object",
+			err:  newObjectError("get", "cspi", "openebs", "cspc-a-1", notFound),
+			want: `failed to get cspi openebs/cspc-a-1: cstorpoolinstances "cspc-a-1" not found`,
+		},
+		{
+			name: "cluster scoped object",
+			err:  newObjectError("update", "clusterrole", "", "openebs-cstor-operator", notFound),
+			want: `failed to update clusterrole openebs-cstor-operator: cstorpoolinstances "cspc-a-1" not found`,
+		},
+		{
+			name: "list in a namespace",
+			err:  newObjectError("list", "cvr", "openebs", "", notFound),
+			want: `failed to list cvrs in openebs: cstorpoolinstances "cspc-a-1" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+			wrapped := newAPIError(tt.err)
+			if !k8serrors.IsNotFound(wrapped) {
+				t.Errorf("IsNotFound(%v) = false, want the clientset error to be kept", wrapped)
+			}
+			var objErr *ObjectError
+			if !errors.As(wrapped, &objErr) || objErr.Op == "" || objErr.Kind == "" {
+				t.Errorf("errors.As(%v, *ObjectError) = %v, want the operation and kind", wrapped, objErr)
+			}
+		})
+	}
+	if newObjectError("get", "cspi", "openebs", "cspc-a-1", nil) != nil {
+		t.Errorf("newObjectError() of a nil error should be nil")
+	}
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+const (
+	// etcdContainerName is the name of the etcd container
+	// in the pods of the etcd statefulset
+	etcdContainerName = "etcd"
+	// etcdClientPortName and etcdClientPort are the port the etcd
+	// members serve the clients on, if the container has no such port
+	etcdClientPortName = "client"
+	etcdClientPort     = 2379
+	// etcdDialTimeout is the time to get the health of an etcd member
+	etcdDialTimeout = 5 * time.Second
+	// etcdRolloutTimeout is the time to wait for the etcd statefulset
+	// to roll out and all its members to be healthy
+	etcdRolloutTimeout = 10 * time.Minute
+)
+
+// etcdClientURLFlags are the flags and environment variables of the
+// etcd container with the urls the members serve the clients on
+var etcdClientURLFlags = []string{
+	"advertise-client-urls",
+	"listen-client-urls",
+}
+
+// etcdMemberStatus is the status of an etcd member, as served
+// on the /health endpoint of the member
+type etcdMemberStatus struct {
+	// Health is true if the member follows a leader and has no alarms
+	Health string `json:"health"`
+	// Reason is the reason the member is not healthy
+	Reason string `json:"reason,omitempty"`
+}
+
+// etcdStatusFunc returns the status of the etcd member serving the endpoint
+type etcdStatusFunc func(ctx context.Context, endpoint string) (*etcdMemberStatus, error)
+
+// EtcdPatch is the patch required to upgrade the etcd statefulset
+// used by the replicated engine (formerly mayastor)
+type EtcdPatch struct {
+	*ResourcePatch
+	*Client
+	Namespace  string
+	sts        *appsv1.StatefulSet
+	httpClient *http.Client
+	// memberStatus returns the status of a member and rolloutTimeout
+	// is the time to wait for the members, they are replaced in the
+	// tests which have no etcd cluster
+	memberStatus   etcdStatusFunc
+	rolloutTimeout time.Duration
+}
+
+// EtcdPatchOptions ...
+type EtcdPatchOptions func(*EtcdPatch)
+
+// WithEtcdResorcePatch ...
+func WithEtcdResorcePatch(r *ResourcePatch) EtcdPatchOptions {
+	return func(obj *EtcdPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithEtcdClient ...
+func WithEtcdClient(c *Client) EtcdPatchOptions {
+	return func(obj *EtcdPatch) {
+		obj.Client = c
+	}
+}
+
+// NewEtcdPatch ...
+func NewEtcdPatch(opts ...EtcdPatchOptions) *EtcdPatch {
+	obj := &EtcdPatch{rolloutTimeout: etcdRolloutTimeout}
+	obj.memberStatus = obj.healthStatus
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// etcdHTTPClient returns the client used to get the health of the
+// members, with the ca and the client certificate of the etcd tls
+func (obj *EtcdPatch) etcdHTTPClient() (*http.Client, error) {
+	config := &tls.Config{}
+	if obj.EtcdCAFile != "" {
+		ca, err := ioutil.ReadFile(obj.EtcdCAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read etcd ca file")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificates found in etcd ca file %s", obj.EtcdCAFile)
+		}
+	}
+	if obj.EtcdCertFile != "" || obj.EtcdKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(obj.EtcdCertFile, obj.EtcdKeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load etcd client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{
+		Timeout:   etcdDialTimeout,
+		Transport: &http.Transport{TLSClientConfig: config},
+	}, nil
+}
+
+// healthStatus gets the status of the member from its /health endpoint
+func (obj *EtcdPatch) healthStatus(ctx context.Context, endpoint string) (*etcdMemberStatus, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint+"/health", nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get health of etcd member %s", endpoint)
+	}
+	resp, err := obj.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get health of etcd member %s", endpoint)
+	}
+	defer resp.Body.Close()
+	status := &etcdMemberStatus{}
+	// an unhealthy member responds with 503 and the reason in the body
+	err = json.NewDecoder(resp.Body).Decode(status)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode health of etcd member %s, status %s", endpoint, resp.Status)
+	}
+	return status, nil
+}
+
+// Init gets the etcd statefulset
+func (obj *EtcdPatch) Init() error {
+	obj.Namespace = obj.OpenebsNamespace
+	sts, err := obj.KubeClientset.AppsV1().StatefulSets(obj.Namespace).
+		Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get etcd statefulset %s", obj.Name)
+	}
+	obj.sts = sts
+	return nil
+}
+
+// etcdContainer returns the index of the etcd container in the pod template
+func etcdContainer(sts *appsv1.StatefulSet) (int, error) {
+	for i, c := range sts.Spec.Template.Spec.Containers {
+		if c.Name == etcdContainerName {
+			return i, nil
+		}
+	}
+	return -1, errors.Errorf("statefulset %s has no container named %s", sts.Name, etcdContainerName)
+}
+
+// desiredImage returns the image the etcd container is upgraded to,
+// which is the etcd image if set, or else the current image with the
+// image tag and prefix of the upgrade
+func (obj *EtcdPatch) desiredImage(current string) (string, error) {
+	if obj.EtcdImage != "" {
+		return obj.EtcdImage, nil
+	}
+	if obj.ImageTag == "" {
+		return "", errors.Errorf("no image to upgrade etcd to, set the etcd image or the image tag")
+	}
+	base, err := obj.imageURL(current)
+	if err != nil {
+		return "", err
+	}
+	return base + ":" + obj.ImageTag, nil
+}
+
+// clientURLScheme returns the scheme of the client urls of the etcd
+// container, set in its args or its environment, http if none is set
+func clientURLScheme(container corev1.Container) string {
+	urls := []string{}
+	args := append(append([]string{}, container.Command...), container.Args...)
+	for i, arg := range args {
+		for _, flag := range etcdClientURLFlags {
+			switch {
+			case strings.HasPrefix(arg, "--"+flag+"="):
+				urls = append(urls, strings.TrimPrefix(arg, "--"+flag+"="))
+			case arg == "--"+flag && i+1 < len(args):
+				urls = append(urls, args[i+1])
+			}
+		}
+	}
+	for _, env := range container.Env {
+		for _, flag := range etcdClientURLFlags {
+			if env.Name == "ETCD_"+strings.ToUpper(strings.ReplaceAll(flag, "-", "_")) {
+				urls = append(urls, env.Value)
+			}
+		}
+	}
+	for _, url := range urls {
+		if strings.HasPrefix(url, "https://") {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+// memberEndpoints returns the client urls of the etcd members, which
+// are resolved through the governing service of the statefulset
+func memberEndpoints(sts *appsv1.StatefulSet, container corev1.Container) []string {
+	port := int32(etcdClientPort)
+	for _, p := range container.Ports {
+		if p.Name == etcdClientPortName {
+			port = p.ContainerPort
+		}
+	}
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	scheme := clientURLScheme(container)
+	endpoints := []string{}
+	for i := int32(0); i < replicas; i++ {
+		endpoints = append(endpoints, fmt.Sprintf("%s://%s-%d.%s.%s.svc:%d",
+			scheme, sts.Name, i, sts.Spec.ServiceName, sts.Namespace, port))
+	}
+	return endpoints
+}
+
+// checkQuorum returns the members which are healthy, and an error if
+// they are not more than the quorum of the cluster, as the restart of
+// a healthy member would then leave the raft cluster unavailable.
+// With all set, every member must be healthy.
+func (obj *EtcdPatch) checkQuorum(endpoints []string, all bool) (int, error) {
+	healthy := 0
+	unhealthy := []string{}
+	for _, ep := range endpoints {
+		ctx, cancel := context.WithTimeout(context.TODO(), etcdDialTimeout)
+		status, err := obj.memberStatus(ctx, ep)
+		cancel()
+		switch {
+		case err != nil:
+			klog.V(2).Infof("etcd member %s is not reachable: %v", ep, err)
+			unhealthy = append(unhealthy, ep)
+		case status.Health != "true":
+			klog.V(2).Infof("etcd member %s is not healthy: %s", ep, status.Reason)
+			unhealthy = append(unhealthy, ep)
+		default:
+			healthy++
+		}
+	}
+	quorum := len(endpoints)/2 + 1
+	if all && healthy < len(endpoints) {
+		return healthy, errors.Errorf("%d of %d etcd members are healthy, unhealthy members: %v",
+			healthy, len(endpoints), unhealthy)
+	}
+	if !all && healthy <= quorum {
+		return healthy, errors.Errorf(
+			"%d of %d etcd members are healthy, more than the quorum of %d are needed to restart a member, unhealthy members: %v",
+			healthy, len(endpoints), quorum, unhealthy)
+	}
+	return healthy, nil
+}
+
+// waitForMembers waits for the statefulset to roll out
+// and all the etcd members to be healthy
+func (obj *EtcdPatch) waitForMembers(endpoints []string, timeout time.Duration) error {
+	interval := obj.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	log := &waitLogger{}
+	deadline := time.Now().Add(timeout)
+	for {
+		sts, err := obj.KubeClientset.AppsV1().StatefulSets(obj.Namespace).
+			Get(context.TODO(), obj.Name, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to get etcd statefulset %s", obj.Name))
+		}
+		msg, rolledOut, err := (&patch.StatefulSetStatusViewer{}).Status(sts)
+		if err != nil {
+			return newValidationError(err)
+		}
+		if rolledOut {
+			_, err = obj.checkQuorum(endpoints, true)
+			if err == nil {
+				return nil
+			}
+			msg = err.Error()
+		}
+		if time.Now().After(deadline) {
+			return newTimeoutError(
+				errors.Errorf("etcd statefulset %s is not healthy after %s: %s", obj.Name, timeout, msg),
+			)
+		}
+		log.Infof("waiting for etcd statefulset %s: %s", obj.Name, msg)
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
+	}
+}
+
+// Upgrade updates the image of the etcd statefulset and waits for all
+// its members to be healthy. The upgrade is refused if the members
+// which are healthy are not more than the quorum.
+func (obj *EtcdPatch) Upgrade() error {
+	err := obj.Init()
+	if err != nil {
+		return newAPIError(err)
+	}
+	i, err := etcdContainer(obj.sts)
+	if err != nil {
+		return newValidationError(err)
+	}
+	container := obj.sts.Spec.Template.Spec.Containers[i]
+	image, err := obj.desiredImage(container.Image)
+	if err != nil {
+		return newValidationError(err)
+	}
+	obj.httpClient, err = obj.etcdHTTPClient()
+	if err != nil {
+		return newValidationError(err)
+	}
+	endpoints := memberEndpoints(obj.sts, container)
+	if obj.VerifyOnly {
+		if container.Image != image {
+			return newValidationError(
+				errors.Errorf("etcd statefulset %s is at image %s, not %s", obj.Name, container.Image, image),
+			)
+		}
+		klog.Infof("verifying etcd statefulset %s at image %s", obj.Name, image)
+		return obj.waitForMembers(endpoints, obj.rolloutTimeout)
+	}
+	if container.Image == image {
+		klog.Infof("etcd statefulset %s is already at image %s", obj.Name, image)
+		return obj.waitForMembers(endpoints, obj.rolloutTimeout)
+	}
+	healthy, err := obj.checkQuorum(endpoints, false)
+	if err != nil {
+		return newValidationError(errors.Wrapf(err, "etcd members can not be restarted without losing quorum"))
+	}
+	klog.Infof("%d of %d etcd members of %s are healthy", healthy, len(endpoints), obj.Name)
+	newSts := obj.sts.DeepCopy()
+	newSts.Spec.Template.Spec.Containers[i].Image = image
+	data, err := GetPatchData(obj.sts, newSts)
+	if err != nil {
+		return err
+	}
+	klog.Infof("patching etcd statefulset %s to image %s", obj.Name, image)
+	_, err = obj.KubeClientset.AppsV1().StatefulSets(obj.Namespace).
+		Patch(context.TODO(), obj.Name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to patch etcd statefulset %s", obj.Name))
+	}
+	err = obj.waitForMembers(endpoints, obj.rolloutTimeout)
+	if err != nil {
+		return newPartialFailureError(err)
+	}
+	klog.Infof("etcd statefulset %s upgraded to %s", obj.Name, image)
+	return nil
+}
@@ -0,0 +1,379 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testEtcdStatefulSet(image string) *appsv1.StatefulSet {
+	replicas := int32(3)
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "mayastor-etcd", Namespace: "mayastor", Generation: 1},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:       &replicas,
+			ServiceName:    "mayastor-etcd-headless",
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType},
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "metrics", Image: "docker.io/bitnami/etcd-exporter:0.1"},
+						{
+							Name:  "etcd",
+							Image: image,
+							Ports: []corev1.ContainerPort{{Name: "client", ContainerPort: 2379}},
+						},
+					},
+				},
+			},
+		},
+		Status: appsv1.StatefulSetStatus{
+			ObservedGeneration: 1,
+			ReadyReplicas:      3,
+			CurrentReplicas:    3,
+			UpdatedReplicas:    3,
+		},
+	}
+}
+
+// testEtcdStatus returns the status of the members, the
+// members not in health are unreachable
+func testEtcdStatus(health map[string]bool) etcdStatusFunc {
+	return func(ctx context.Context, endpoint string) (*etcdMemberStatus, error) {
+		for member, healthy := range health {
+			if strings.Contains(endpoint, "//mayastor-etcd-"+member+".") {
+				if !healthy {
+					return &etcdMemberStatus{Health: "false", Reason: "RAFT NO LEADER"}, nil
+				}
+				return &etcdMemberStatus{Health: "true"}, nil
+			}
+		}
+		return nil, errors.Errorf("connection refused")
+	}
+}
+
+func TestMemberEndpoints(t *testing.T) {
+	sts := testEtcdStatefulSet("docker.io/bitnami/etcd:3.4.15")
+	got := memberEndpoints(sts, sts.Spec.Template.Spec.Containers[1])
+	want := []string{
+		"http://mayastor-etcd-0.mayastor-etcd-headless.mayastor.svc:2379",
+		"http://mayastor-etcd-1.mayastor-etcd-headless.mayastor.svc:2379",
+		"http://mayastor-etcd-2.mayastor-etcd-headless.mayastor.svc:2379",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("memberEndpoints() = %v, want %v", got, want)
+	}
+}
+
+func TestClientURLScheme(t *testing.T) {
+	tests := []struct {
+		name      string
+		container corev1.Container
+		want      string
+	}{
+		{name: "no urls", container: corev1.Container{}, want: "http"},
+		{
+			name:      "http advertise flag",
+			container: corev1.Container{Args: []string{"--advertise-client-urls=http://$(POD_NAME):2379"}},
+			want:      "http",
+		},
+		{
+			name:      "https advertise flag",
+			container: corev1.Container{Command: []string{"etcd", "--advertise-client-urls=https://$(POD_NAME):2379"}},
+			want:      "https",
+		},
+		{
+			name:      "https listen flag with separate value",
+			container: corev1.Container{Args: []string{"--listen-client-urls", "https://0.0.0.0:2379"}},
+			want:      "https",
+		},
+		{
+			name: "https environment",
+			container: corev1.Container{Env: []corev1.EnvVar{
+				{Name: "ETCD_ADVERTISE_CLIENT_URLS", Value: "https://$(MY_POD_NAME).mayastor-etcd-headless:2379"},
+			}},
+			want: "https",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientURLScheme(tt.container); got != tt.want {
+				t.Errorf("clientURLScheme() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEtcdContainer(t *testing.T) {
+	sts := testEtcdStatefulSet("docker.io/bitnami/etcd:3.4.15")
+	if i, err := etcdContainer(sts); err != nil || i != 1 {
+		t.Errorf("etcdContainer() = %d, %v, want 1", i, err)
+	}
+	sts.Spec.Template.Spec.Containers[1].Name = "server"
+	if _, err := etcdContainer(sts); err == nil {
+		t.Errorf("etcdContainer() found a container not named etcd")
+	}
+}
+
+func TestEtcdPatch_healthStatus(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("X-Unhealthy") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"health":"false","reason":"RAFT NO LEADER"}`)
+			return
+		}
+		fmt.Fprint(w, `{"health":"true","reason":""}`)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		obj := NewEtcdPatch(WithEtcdResorcePatch(NewResourcePatch()))
+		obj.httpClient, _ = obj.etcdHTTPClient()
+		status, err := obj.healthStatus(context.TODO(), server.URL)
+		if err != nil || status.Health != "true" {
+			t.Errorf("healthStatus() = %v, %v, want healthy", status, err)
+		}
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		server := httptest.NewTLSServer(handler)
+		defer server.Close()
+		caFile := filepath.Join(t.TempDir(), "ca.crt")
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+			t.Fatal(err)
+		}
+		obj := NewEtcdPatch(WithEtcdResorcePatch(NewResourcePatch()))
+		obj.httpClient, _ = obj.etcdHTTPClient()
+		if _, err := obj.healthStatus(context.TODO(), server.URL); err == nil {
+			t.Errorf("healthStatus() trusted the certificate of the member without its ca")
+		}
+		obj = NewEtcdPatch(WithEtcdResorcePatch(NewResourcePatch(WithEtcdTLS(caFile, "", ""))))
+		var err error
+		obj.httpClient, err = obj.etcdHTTPClient()
+		if err != nil {
+			t.Fatalf("etcdHTTPClient() error = %v", err)
+		}
+		status, err := obj.healthStatus(context.TODO(), server.URL)
+		if err != nil || status.Health != "true" {
+			t.Errorf("healthStatus() = %v, %v, want healthy", status, err)
+		}
+	})
+
+	t.Run("unhealthy", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("X-Unhealthy", "true")
+			handler(w, r)
+		}))
+		defer server.Close()
+		obj := NewEtcdPatch(WithEtcdResorcePatch(NewResourcePatch()))
+		obj.httpClient, _ = obj.etcdHTTPClient()
+		status, err := obj.healthStatus(context.TODO(), server.URL)
+		if err != nil || status.Health != "false" || status.Reason != "RAFT NO LEADER" {
+			t.Errorf("healthStatus() = %v, %v, want unhealthy", status, err)
+		}
+	})
+}
+
+func TestEtcdPatch_desiredImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       *ResourcePatch
+		want    string
+		wantErr bool
+	}{
+		{name: "etcd image", r: &ResourcePatch{EtcdImage: "quay.io/etcd:3.5.0"}, want: "quay.io/etcd:3.5.0"},
+		{name: "image tag", r: &ResourcePatch{ImageTag: "3.5.0"}, want: "docker.io/bitnami/etcd:3.5.0"},
+		{
+			name: "image tag and prefix",
+			r:    &ResourcePatch{ImageTag: "3.5.0", BaseURL: "registry.local/"},
+			want: "registry.local/etcd:3.5.0",
+		},
+		{name: "no image", r: &ResourcePatch{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := NewEtcdPatch(WithEtcdResorcePatch(tt.r))
+			got, err := obj.desiredImage("docker.io/bitnami/etcd:3.4.15")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("desiredImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("desiredImage() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEtcdPatch_Upgrade(t *testing.T) {
+	const (
+		oldImage = "docker.io/bitnami/etcd:3.4.15"
+		newImage = "docker.io/bitnami/etcd:3.5.0"
+	)
+	tests := []struct {
+		name       string
+		image      string
+		container  string
+		health     map[string]bool
+		verifyOnly bool
+		wantPatch  bool
+		wantKind   error
+	}{
+		{
+			name:      "all members healthy",
+			image:     oldImage,
+			health:    map[string]bool{"0": true, "1": true, "2": true},
+			wantPatch: true,
+		},
+		{
+			name:     "quorum lost",
+			image:    oldImage,
+			health:   map[string]bool{"0": true},
+			wantKind: ErrValidation,
+		},
+		{
+			// restarting one of the two healthy members loses the quorum
+			name:     "only the quorum healthy",
+			image:    oldImage,
+			health:   map[string]bool{"0": true, "1": true, "2": false},
+			wantKind: ErrValidation,
+		},
+		{
+			name:     "no leader",
+			image:    oldImage,
+			health:   map[string]bool{"0": false, "1": false, "2": false},
+			wantKind: ErrValidation,
+		},
+		{
+			name:      "no etcd container",
+			image:     oldImage,
+			container: "server",
+			health:    map[string]bool{"0": true, "1": true, "2": true},
+			wantKind:  ErrValidation,
+		},
+		{
+			name:   "already upgraded",
+			image:  newImage,
+			health: map[string]bool{"0": true, "1": true, "2": true},
+		},
+		{
+			name:       "verify only",
+			image:      newImage,
+			health:     map[string]bool{"0": true, "1": true, "2": true},
+			verifyOnly: true,
+		},
+		{
+			name:       "verify only with a member down",
+			image:      newImage,
+			health:     map[string]bool{"0": true, "1": true},
+			verifyOnly: true,
+			wantKind:   ErrTimeout,
+		},
+		{
+			name:       "verify only not patched",
+			image:      oldImage,
+			health:     map[string]bool{"0": true, "1": true, "2": true},
+			verifyOnly: true,
+			wantKind:   ErrValidation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sts := testEtcdStatefulSet(tt.image)
+			if tt.container != "" {
+				sts.Spec.Template.Spec.Containers[1].Name = tt.container
+			}
+			client := NewTestClient(sts)
+			clientset := fakeKube(client)
+			obj := NewEtcdPatch(
+				WithEtcdResorcePatch(NewResourcePatch(
+					WithName("mayastor-etcd"),
+					WithOpenebsNamespace("mayastor"),
+					WithEtcdImage(newImage),
+					WithVerifyOnly(tt.verifyOnly),
+					WithPollInterval(time.Millisecond),
+				)),
+				WithEtcdClient(client),
+			)
+			obj.memberStatus = testEtcdStatus(tt.health)
+			obj.rolloutTimeout = 10 * time.Millisecond
+			err := obj.Upgrade()
+			if tt.wantKind == nil && err != nil {
+				t.Fatalf("Upgrade() error = %v", err)
+			}
+			if tt.wantKind != nil && !errors.Is(err, tt.wantKind) {
+				t.Fatalf("Upgrade() error = %v, want kind %v", err, tt.wantKind)
+			}
+			patched := false
+			for _, action := range clientset.Actions() {
+				patched = patched || action.GetVerb() == "patch"
+			}
+			if patched != tt.wantPatch {
+				t.Errorf("Upgrade() patched = %v, want %v", patched, tt.wantPatch)
+			}
+			if !tt.wantPatch {
+				return
+			}
+			sts, _ = clientset.AppsV1().StatefulSets("mayastor").
+				Get(context.TODO(), "mayastor-etcd", metav1.GetOptions{})
+			if got := sts.Spec.Template.Spec.Containers[1].Image; got != newImage {
+				t.Errorf("Upgrade() etcd image = %s", got)
+			}
+			if got := sts.Spec.Template.Spec.Containers[0].Image; got != "docker.io/bitnami/etcd-exporter:0.1" {
+				t.Errorf("Upgrade() changed the image of the metrics container to %s", got)
+			}
+		})
+	}
+}
+
+func TestEtcdPatch_waitForMembers(t *testing.T) {
+	sts := testEtcdStatefulSet("docker.io/bitnami/etcd:3.5.0")
+	obj := NewEtcdPatch(
+		WithEtcdResorcePatch(NewResourcePatch(
+			WithName("mayastor-etcd"),
+			WithPollInterval(time.Millisecond),
+		)),
+		WithEtcdClient(NewTestClient(sts)),
+	)
+	obj.Namespace = "mayastor"
+	// a member that is down fails the wait even though the quorum is healthy
+	obj.memberStatus = testEtcdStatus(map[string]bool{"0": true, "1": true})
+	err := obj.waitForMembers(memberEndpoints(sts, sts.Spec.Template.Spec.Containers[1]), 10*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("waitForMembers() error = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// GraphDOT and GraphMermaid are the formats the
+	// dependency graph can be printed in
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+
+	// jivaCSIProvisioner is the provisioner of the jiva csi volumes
+	jivaCSIProvisioner = "jiva.csi.openebs.io"
+)
+
+// DepNode is an upgrade relevant resource in the dependency graph
+type DepNode struct {
+	Kind    string
+	Name    string
+	Version string
+}
+
+// ID returns the unique id of the node in the graph
+func (n DepNode) ID() string {
+	return n.Kind + "/" + n.Name
+}
+
+// DepEdge is a dependency of the To node on the From node,
+// the ids of the nodes are the ids returned by DepNode.ID
+type DepEdge struct {
+	From, To string
+}
+
+// DepGraph is the graph of the upgrade relevant resources. The pools are
+// upgraded before the volumes, so a cvr depends on its cspi which depends
+// on its cspc, while a volume is reached from its pvc through the pv.
+type DepGraph struct {
+	Nodes []DepNode
+	Edges []DepEdge
+	nodes map[string]bool
+}
+
+func newDepGraph() *DepGraph {
+	return &DepGraph{nodes: map[string]bool{}}
+}
+
+func (g *DepGraph) addNode(kind, name, version string) string {
+	n := DepNode{Kind: kind, Name: name, Version: version}
+	if !g.nodes[n.ID()] {
+		g.nodes[n.ID()] = true
+		g.Nodes = append(g.Nodes, n)
+	}
+	return n.ID()
+}
+
+func (g *DepGraph) addEdge(from, to string) {
+	g.Edges = append(g.Edges, DepEdge{From: from, To: to})
+}
+
+// sort orders the nodes and edges so that the output is stable. The edges
+// to resources that were not found, like the cspc of an orphaned cspi,
+// are dropped.
+func (g *DepGraph) sort() {
+	edges := []DepEdge{}
+	for _, e := range g.Edges {
+		if g.nodes[e.From] && g.nodes[e.To] {
+			edges = append(edges, e)
+		}
+	}
+	g.Edges = edges
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].ID() < g.Nodes[j].ID()
+	})
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+}
+
+// BuildDependencyGraph discovers the cspcs, cspis, cstor and jiva volumes
+// with their cvrs, pvs and pvcs and returns the graph of their dependencies
+func BuildDependencyGraph(ctx context.Context, client *Client) (*DepGraph, error) {
+	g := newDepGraph()
+	ns := client.namespace
+	cspcList, err := client.OpenebsClientset.CstorV1().CStorPoolClusters(ns).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrap(err, "failed to list cspcs"))
+	}
+	for _, cspcObj := range cspcList.Items {
+		g.addNode("cstorPoolCluster", cspcObj.Name, cspcObj.VersionDetails.Status.Current)
+	}
+	cspiList, err := client.OpenebsClientset.CstorV1().CStorPoolInstances(ns).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrap(err, "failed to list cspis"))
+	}
+	for _, cspiObj := range cspiList.Items {
+		id := g.addNode("cstorPoolInstance", cspiObj.Name, cspiObj.VersionDetails.Status.Current)
+		if cspc := cspiObj.Labels["openebs.io/cstor-pool-cluster"]; cspc != "" {
+			g.addEdge(DepNode{Kind: "cstorPoolCluster", Name: cspc}.ID(), id)
+		}
+	}
+	cvList, err := client.OpenebsClientset.CstorV1().CStorVolumes(ns).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrap(err, "failed to list cstorvolumes"))
+	}
+	for _, cvObj := range cvList.Items {
+		g.addNode("cstorVolume", cvObj.Name, cvObj.VersionDetails.Status.Current)
+	}
+	cvrList, err := client.OpenebsClientset.CstorV1().CStorVolumeReplicas(ns).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrap(err, "failed to list cvrs"))
+	}
+	for _, cvrObj := range cvrList.Items {
+		id := g.addNode("cstorVolumeReplica", cvrObj.Name, cvrObj.VersionDetails.Status.Current)
+		if cspi := cvrObj.Labels["cstorpoolinstance.openebs.io/name"]; cspi != "" {
+			g.addEdge(DepNode{Kind: "cstorPoolInstance", Name: cspi}.ID(), id)
+		}
+		if cv := cvrObj.Labels["openebs.io/persistent-volume"]; cv != "" {
+			g.addEdge(DepNode{Kind: "cstorVolume", Name: cv}.ID(), id)
+		}
+	}
+	// the pvs are cluster scoped, so the volumes are not
+	// linked to their pvcs in namespace scoped mode
+	if client.namespaceScoped {
+		klog.Warningf("skipping the pvs and pvcs of the volumes in namespace scoped mode")
+		g.sort()
+		return g, nil
+	}
+	pvList, err := client.KubeClientset.CoreV1().PersistentVolumes().
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrap(err, "failed to list pvs"))
+	}
+	for _, pvObj := range pvList.Items {
+		if pvObj.Spec.CSI == nil {
+			continue
+		}
+		// the cstorvolume and the jivavolume are named after the pv
+		var volume string
+		switch pvObj.Spec.CSI.Driver {
+		case cstorCSIProvisioner:
+			volume = g.addNode("cstorVolume", pvObj.Name, "")
+		case jivaCSIProvisioner:
+			volume = g.addNode("jivaVolume", pvObj.Name, pvObj.Labels["openebs.io/version"])
+		default:
+			continue
+		}
+		pv := g.addNode("persistentVolume", pvObj.Name, "")
+		g.addEdge(pv, volume)
+		if ref := pvObj.Spec.ClaimRef; ref != nil {
+			pvc := g.addNode("persistentVolumeClaim", ref.Namespace+"/"+ref.Name, "")
+			g.addEdge(pvc, pv)
+		}
+	}
+	g.sort()
+	return g, nil
+}
+
+// label returns the kind, name and the version if known of the node
+func (n DepNode) label(sep string) string {
+	label := n.Kind + sep + n.Name
+	if n.Version != "" {
+		label += sep + n.Version
+	}
+	return label
+}
+
+// ToDOT returns the graph in the DOT language of Graphviz
+func (g *DepGraph) ToDOT() string {
+	var b strings.Builder
+	b.WriteString("digraph upgrade {\n\trankdir=LR;\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "\t%q [label=%q];\n", n.ID(), n.label("\n"))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%q -> %q;\n", e.From, e.To)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ToMermaid returns the graph as a Mermaid flowchart. The node ids of
+// Mermaid cannot contain all the characters of the resource names, so
+// the nodes are numbered in order.
+func (g *DepGraph) ToMermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	ids := map[string]string{}
+	for i, n := range g.Nodes {
+		ids[n.ID()] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(&b, "\t%s[\"%s\"]\n", ids[n.ID()], n.label("<br/>"))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%s --> %s\n", ids[e.From], ids[e.To])
+	}
+	return b.String()
+}
+
+// ValidateGraphFormat returns a validation error if the
+// format is neither dot nor mermaid
+func ValidateGraphFormat(format string) error {
+	switch format {
+	case GraphDOT, GraphMermaid:
+		return nil
+	}
+	return newValidationError(
+		errors.Errorf("invalid graph format %q, expected dot or mermaid", format),
+	)
+}
+
+// Format returns the graph in the given format, dot or mermaid
+func (g *DepGraph) Format(format string) (string, error) {
+	err := ValidateGraphFormat(format)
+	if err != nil {
+		return "", err
+	}
+	if format == GraphMermaid {
+		return g.ToMermaid(), nil
+	}
+	return g.ToDOT(), nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPV(name, driver, claim string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver},
+			},
+			ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: claim},
+		},
+	}
+}
+
+func TestBuildDependencyGraph(t *testing.T) {
+	version := cstor.VersionDetails{Status: cstor.VersionStatus{Current: "2.12.0"}}
+	cspc := upgradetesting.NewTestCSPC("cspc-a", "")
+	cspc.VersionDetails = version
+	cspi := upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", "")
+	cspi.VersionDetails = version
+	client := NewTestClient(
+		cspc,
+		cspi,
+		// the cspc of the orphaned cspi does not exist
+		upgradetesting.NewTestCSPI("cspc-b-1", "cspc-b", ""),
+		&cstor.CStorVolume{
+			ObjectMeta:     metav1.ObjectMeta{Name: "pvc-1", Namespace: "openebs"},
+			VersionDetails: version,
+		},
+		&cstor.CStorVolumeReplica{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "pvc-1-cspc-a-1",
+				Namespace: "openebs",
+				Labels: map[string]string{
+					"cstorpoolinstance.openebs.io/name": "cspc-a-1",
+					"openebs.io/persistent-volume":      "pvc-1",
+				},
+			},
+		},
+		testPV("pvc-1", cstorCSIProvisioner, "data-1"),
+		testPV("pvc-2", jivaCSIProvisioner, "data-2"),
+		testPV("pvc-3", "ebs.csi.aws.com", "data-3"),
+	)
+	client.namespace = "openebs"
+	g, err := BuildDependencyGraph(context.TODO(), client)
+	if err != nil {
+		t.Fatalf("BuildDependencyGraph() error = %v", err)
+	}
+	wantEdges := []DepEdge{
+		{From: "cstorPoolCluster/cspc-a", To: "cstorPoolInstance/cspc-a-1"},
+		{From: "cstorPoolInstance/cspc-a-1", To: "cstorVolumeReplica/pvc-1-cspc-a-1"},
+		{From: "cstorVolume/pvc-1", To: "cstorVolumeReplica/pvc-1-cspc-a-1"},
+		{From: "persistentVolume/pvc-1", To: "cstorVolume/pvc-1"},
+		{From: "persistentVolume/pvc-2", To: "jivaVolume/pvc-2"},
+		{From: "persistentVolumeClaim/default/data-1", To: "persistentVolume/pvc-1"},
+		{From: "persistentVolumeClaim/default/data-2", To: "persistentVolume/pvc-2"},
+	}
+	if !reflect.DeepEqual(g.Edges, wantEdges) {
+		t.Errorf("BuildDependencyGraph() edges = %v, want %v", g.Edges, wantEdges)
+	}
+	if len(g.Nodes) != 10 {
+		t.Errorf("BuildDependencyGraph() got %d nodes, want 10: %v", len(g.Nodes), g.Nodes)
+	}
+	for _, n := range g.Nodes {
+		if n.ID() == "cstorVolume/pvc-1" && n.Version != "2.12.0" {
+			t.Errorf("BuildDependencyGraph() version of %s = %q, want 2.12.0", n.ID(), n.Version)
+		}
+	}
+}
+
+func TestDepGraphFormat(t *testing.T) {
+	g := newDepGraph()
+	cspc := g.addNode("cstorPoolCluster", "cspc-a", "2.12.0")
+	cspi := g.addNode("cstorPoolInstance", "cspc-a-1", "")
+	g.addEdge(cspc, cspi)
+	g.sort()
+
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			format: GraphDOT,
+			want: `digraph upgrade {
+	rankdir=LR;
+	"cstorPoolCluster/cspc-a" [label="cstorPoolCluster\ncspc-a\n2.12.0"];
+	"cstorPoolInstance/cspc-a-1" [label="cstorPoolInstance\ncspc-a-1"];
+	"cstorPoolCluster/cspc-a" -> "cstorPoolInstance/cspc-a-1";
+}
+`,
+		},
+		{
+			format: GraphMermaid,
+			want: `flowchart LR
+	n0["cstorPoolCluster<br/>cspc-a<br/>2.12.0"]
+	n1["cstorPoolInstance<br/>cspc-a-1"]
+	n0 --> n1
+`,
+		},
+		{format: "png", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := g.Format(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Format() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Format() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultPauseOnPressureThreshold and DefaultResumeOnPressureThreshold
+	// are the percentages of the nodes under memory pressure above which
+	// the upgrades are paused, and below which they are resumed
+	DefaultPauseOnPressureThreshold  = 85
+	DefaultResumeOnPressureThreshold = 70
+	// DefaultHealthCheckInterval is the interval the nodes are checked at
+	DefaultHealthCheckInterval = 30 * time.Second
+)
+
+// ClusterHealthMonitor pauses the upgrades while too many nodes of the
+// cluster are under memory pressure. The upgrades are paused once the
+// percentage of the nodes with the MemoryPressure condition exceeds the
+// pause threshold, and resumed only once it drops below the resume
+// threshold, so that they do not flap around a single threshold.
+type ClusterHealthMonitor struct {
+	client kubernetes.Interface
+	// PauseThreshold and ResumeThreshold are percentages of the nodes
+	PauseThreshold  int
+	ResumeThreshold int
+	Interval        time.Duration
+	// sem is held by the monitor while the upgrades are paused
+	sem    chan struct{}
+	mutex  sync.Mutex
+	paused bool
+}
+
+// NewClusterHealthMonitor returns a new instance of ClusterHealthMonitor
+// with the given thresholds, it returns a validation error if they are
+// not percentages or the resume threshold is above the pause threshold
+func NewClusterHealthMonitor(client kubernetes.Interface,
+	pauseThreshold, resumeThreshold int) (*ClusterHealthMonitor, error) {
+	for _, t := range []int{pauseThreshold, resumeThreshold} {
+		if t < 0 || t > 100 {
+			return nil, newValidationError(
+				errors.Errorf("invalid pressure threshold %d, expected a percentage from 0 to 100", t),
+			)
+		}
+	}
+	if resumeThreshold > pauseThreshold {
+		return nil, newValidationError(
+			errors.Errorf("resume pressure threshold %d is above the pause pressure threshold %d",
+				resumeThreshold, pauseThreshold),
+		)
+	}
+	return &ClusterHealthMonitor{
+		client:          client,
+		PauseThreshold:  pauseThreshold,
+		ResumeThreshold: resumeThreshold,
+		Interval:        DefaultHealthCheckInterval,
+		sem:             make(chan struct{}, 1),
+	}, nil
+}
+
+// Run checks the nodes at every interval until the context is done,
+// and resumes the upgrades if they are paused when it returns
+func (m *ClusterHealthMonitor) Run(ctx context.Context) {
+	wait.Until(m.check, m.Interval, ctx.Done())
+	m.resume()
+}
+
+// Wait blocks while the upgrades are paused, it returns
+// the error of the context if it is done before they resume
+func (m *ClusterHealthMonitor) Wait(ctx context.Context) error {
+	select {
+	case m.sem <- struct{}{}:
+		<-m.sem
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Paused returns true if the upgrades are paused
+func (m *ClusterHealthMonitor) Paused() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.paused
+}
+
+// check pauses or resumes the upgrades as per the memory pressure of
+// the nodes, the state is left as is if the nodes cannot be listed
+func (m *ClusterHealthMonitor) check() {
+	pressure, err := MemoryPressurePercent(m.client)
+	if err != nil {
+		klog.Errorf("failed to check the memory pressure of the nodes: %v", err)
+		return
+	}
+	switch {
+	case pressure > float64(m.PauseThreshold) && m.pause():
+		klog.Warningf("%.0f%% of the nodes are under memory pressure, pausing the upgrades until it drops below %d%%",
+			pressure, m.ResumeThreshold)
+	case pressure < float64(m.ResumeThreshold) && m.resume():
+		klog.Infof("%.0f%% of the nodes are under memory pressure, resuming the upgrades", pressure)
+	}
+}
+
+// pause acquires the semaphore so that Wait blocks,
+// it returns false if the upgrades are already paused
+func (m *ClusterHealthMonitor) pause() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.paused {
+		return false
+	}
+	m.sem <- struct{}{}
+	m.paused = true
+	return true
+}
+
+// resume releases the semaphore so that Wait returns,
+// it returns false if the upgrades are not paused
+func (m *ClusterHealthMonitor) resume() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.paused {
+		return false
+	}
+	<-m.sem
+	m.paused = false
+	return true
+}
+
+// MemoryPressurePercent returns the percentage of the nodes of the
+// cluster with the MemoryPressure condition, it is 0 if there are no nodes
+func MemoryPressurePercent(client kubernetes.Interface) (float64, error) {
+	nodeList, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list nodes")
+	}
+	if len(nodeList.Items) == 0 {
+		return 0, nil
+	}
+	count := 0
+	for _, nodeObj := range nodeList.Items {
+		for _, c := range nodeObj.Status.Conditions {
+			if c.Type == corev1.NodeMemoryPressure && c.Status == corev1.ConditionTrue {
+				count++
+				break
+			}
+		}
+	}
+	return 100 * float64(count) / float64(len(nodeList.Items)), nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// setMemoryPressure sets the MemoryPressure condition on the
+// first count of the nodes and clears it on the rest of them
+func setMemoryPressure(t *testing.T, client kubernetes.Interface, count int) {
+	nodeList, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list nodes: %v", err)
+	}
+	for i := range nodeList.Items {
+		status := corev1.ConditionFalse
+		if i < count {
+			status = corev1.ConditionTrue
+		}
+		nodeObj := nodeList.Items[i]
+		nodeObj.Status.Conditions = []corev1.NodeCondition{
+			{Type: corev1.NodeMemoryPressure, Status: status},
+		}
+		_, err = client.CoreV1().Nodes().Update(context.TODO(), &nodeObj, metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatalf("failed to update node %s: %v", nodeObj.Name, err)
+		}
+	}
+}
+
+func TestNewClusterHealthMonitor(t *testing.T) {
+	tests := []struct {
+		name    string
+		pause   int
+		resume  int
+		wantErr bool
+	}{
+		{name: "default thresholds", pause: 85, resume: 70},
+		{name: "equal thresholds", pause: 50, resume: 50},
+		{name: "pause threshold above 100", pause: 101, resume: 70, wantErr: true},
+		{name: "negative resume threshold", pause: 85, resume: -1, wantErr: true},
+		{name: "resume threshold above pause threshold", pause: 70, resume: 85, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClusterHealthMonitor(NewTestClient().KubeClientset, tt.pause, tt.resume)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClusterHealthMonitor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrValidation) {
+				t.Errorf("NewClusterHealthMonitor() error = %v, want a validation error", err)
+			}
+		})
+	}
+}
+
+func TestClusterHealthMonitor_check(t *testing.T) {
+	nodes := []runtime.Object{}
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, newTestNode(fmt.Sprintf("node-%d", i), fmt.Sprintf("node-%d", i)))
+	}
+	client := NewTestClient(nodes...).KubeClientset
+	m, err := NewClusterHealthMonitor(client,
+		DefaultPauseOnPressureThreshold, DefaultResumeOnPressureThreshold)
+	if err != nil {
+		t.Fatalf("NewClusterHealthMonitor() error = %v", err)
+	}
+	steps := []struct {
+		pressured  int
+		wantPaused bool
+	}{
+		{pressured: 0, wantPaused: false},
+		{pressured: 8, wantPaused: false},
+		{pressured: 9, wantPaused: true},
+		{pressured: 10, wantPaused: true},
+		// paused until the pressure drops below the resume threshold
+		{pressured: 7, wantPaused: true},
+		{pressured: 6, wantPaused: false},
+		{pressured: 8, wantPaused: false},
+	}
+	for _, step := range steps {
+		setMemoryPressure(t, client, step.pressured)
+		m.check()
+		if got := m.Paused(); got != step.wantPaused {
+			t.Fatalf("%d of 10 nodes under pressure: Paused() = %v, want %v",
+				step.pressured, got, step.wantPaused)
+		}
+	}
+}
+
+func TestClusterHealthMonitor_Wait(t *testing.T) {
+	client := NewTestClient(newTestNode("node-1", "node-1",
+		corev1.NodeCondition{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue})).KubeClientset
+	m, err := NewClusterHealthMonitor(client,
+		DefaultPauseOnPressureThreshold, DefaultResumeOnPressureThreshold)
+	if err != nil {
+		t.Fatalf("NewClusterHealthMonitor() error = %v", err)
+	}
+	if err := m.Wait(context.TODO()); err != nil {
+		t.Fatalf("Wait() error = %v before the upgrades are paused", err)
+	}
+	m.check()
+	if !m.Paused() {
+		t.Fatalf("Paused() = false with all the nodes under pressure")
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait() error = %v while paused, want %v", err, context.DeadlineExceeded)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- m.Wait(context.TODO())
+	}()
+	setMemoryPressure(t, client, 0)
+	m.check()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait() error = %v after the upgrades resumed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Wait() blocked after the upgrades resumed")
+	}
+}
+
+func TestClusterHealthMonitor_Run(t *testing.T) {
+	client := NewTestClient(newTestNode("node-1", "node-1",
+		corev1.NodeCondition{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue})).KubeClientset
+	m, err := NewClusterHealthMonitor(client,
+		DefaultPauseOnPressureThreshold, DefaultResumeOnPressureThreshold)
+	if err != nil {
+		t.Fatalf("NewClusterHealthMonitor() error = %v", err)
+	}
+	m.Interval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.TODO())
+	stopped := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !m.Paused() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Run() did not pause the upgrades")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-stopped
+	if m.Paused() {
+		t.Errorf("Paused() = true after Run() returned")
+	}
+}
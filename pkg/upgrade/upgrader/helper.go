@@ -22,16 +22,107 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/openebs/upgrade/pkg/version"
 	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
 )
 
 var (
 	cstorOperatorServiceAccount = "openebs-cstor-operator"
+	// defaultSkipUpgradeAnnotation excludes a resource from batch upgrades
+	// when set to true
+	defaultSkipUpgradeAnnotation = "openebs.io/skip-upgrade"
+	// defaultOperatorVersionLabel is the label of the operator
+	// pods with the version of the operator
+	defaultOperatorVersionLabel = "openebs.io/version"
 )
 
+// DefaultFromVersionAnnotation is the annotation of a resource which
+// overrides the from version of its upgrade, if none is configured
+const DefaultFromVersionAnnotation = "openebs.io/upgrade-from-version"
+
+// fromVersionKinds are the kinds which read the from version from the
+// current version of the resource when it is not set
+var fromVersionKinds = map[string]bool{
+	"cstorPoolCluster":  true,
+	"cstorPoolInstance": true,
+	"cstorVolume":       true,
+	"jivaVolume":        true,
+}
+
+// IsFromVersionOptional returns true if the upgrade of the kind reads
+// the from version from the resource when it is not set
+func IsFromVersionOptional(kind string) bool {
+	return fromVersionKinds[kind]
+}
+
+// fromVersionAnnotation returns the annotation which overrides the
+// from version of a resource
+func (r *ResourcePatch) fromVersionAnnotation() string {
+	if r.FromVersionAnnotation != "" {
+		return r.FromVersionAnnotation
+	}
+	return DefaultFromVersionAnnotation
+}
+
+// resolveFromVersion sets the from version of the upgrade to the from
+// version annotation of the resource if it is set, so that a batch can
+// upgrade resources at mixed versions. Otherwise the from version set
+// for the upgrade is used, or else the given current version of the
+// resource, failing if the resource has no current version or either
+// version is one the upgrade does not support. An explicit from version
+// is left as is and checked by the prechecks of the resource.
+func (r *ResourcePatch) resolveFromVersion(kind, name, current string, annotations map[string]string) error {
+	if !r.fromDetected {
+		r.globalFrom = r.From
+	}
+	key := r.fromVersionAnnotation()
+	if from := strings.TrimSpace(annotations[key]); from != "" {
+		if !version.IsCurrentVersionValid(from) {
+			return newValidationError(errors.Errorf(
+				"%s %s has an invalid from version %q in the annotation %s", kind, name, from, key))
+		}
+		if r.From != from {
+			klog.Infof("%s %s: upgrading from the version %s of its annotation %s", kind, name, from, key)
+		}
+		r.From = from
+		r.fromDetected = true
+		return nil
+	}
+	if r.globalFrom != "" {
+		r.From = r.globalFrom
+		r.fromDetected = false
+		return nil
+	}
+	if current == "" {
+		return newValidationError(errors.Errorf(
+			"%s %s has no current version to upgrade from, set the from version", kind, name))
+	}
+	if !version.IsCurrentVersionValid(current) {
+		return newValidationError(errors.Errorf(
+			"%s %s is at version %s which can not be upgraded from, set the from version to upgrade it",
+			kind, name, current))
+	}
+	if r.From != current {
+		klog.Infof("%s %s: upgrading from its current version %s", kind, name, current)
+	}
+	r.From = current
+	r.fromDetected = true
+	return nil
+}
+
+// skippedResource is a resource skipped during a batch upgrade
+type skippedResource struct {
+	name   string
+	reason string
+}
+
 func getImageURL(url, prefix string) (string, error) {
 	lastIndex := strings.LastIndex(url, ":")
 	if lastIndex == -1 {
@@ -52,9 +143,61 @@ func getImageURL(url, prefix string) (string, error) {
 	return baseImage, nil
 }
 
+// imageURL returns the image without its tag as it is patched by the
+// upgrade, with the BaseURL prefix and then the ImageRegistry applied
+func (r *ResourcePatch) imageURL(image string) (string, error) {
+	url, err := getImageURL(image, r.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	if r.ImageRegistry != "" {
+		url = overrideRegistry(url, r.ImageRegistry)
+	}
+	return url, nil
+}
+
+// overrideRegistry replaces the registry host of the image name with
+// the given registry, keeping its organization and repository. The
+// images without a registry host are from the default registry, so
+// openebs/jiva and docker.io/openebs/jiva both become registry/openebs/jiva.
+func overrideRegistry(name, registry string) string {
+	registry = strings.TrimSuffix(registry, "/")
+	parts := strings.SplitN(name, "/", 2)
+	// the first part is a registry host only if it looks like one
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		name = parts[1]
+	}
+	return registry + "/" + name
+}
+
+// PatchFieldOption restricts the fields GetPatchData takes from the new object
+type PatchFieldOption func(*patchFields)
+
+// patchFields are the paths of the fields to patch or to leave out of
+// the patch, as the json field names separated by dots
+type patchFields struct {
+	include, exclude []string
+}
+
+// IncludePatchFields limits the patch to the fields at the given paths,
+// like versionDetails or metadata.labels
+func IncludePatchFields(paths ...string) PatchFieldOption {
+	return func(f *patchFields) {
+		f.include = append(f.include, paths...)
+	}
+}
+
+// ExcludePatchFields leaves the fields at the given paths out of the patch
+func ExcludePatchFields(paths ...string) PatchFieldOption {
+	return func(f *patchFields) {
+		f.exclude = append(f.exclude, paths...)
+	}
+}
+
 // GetPatchData returns patch data by
-// marshalling and taking diff of two objects
-func GetPatchData(oldObj, newObj interface{}) ([]byte, error) {
+// marshalling and taking diff of two objects,
+// restricted to the fields of the options if any
+func GetPatchData(oldObj, newObj interface{}, opts ...PatchFieldOption) ([]byte, error) {
 	oldData, err := json.Marshal(oldObj)
 	if err != nil {
 		return nil, fmt.Errorf("marshal old object failed: %v", err)
@@ -63,6 +206,12 @@ func GetPatchData(oldObj, newObj interface{}) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("mashal new object failed: %v", err)
 	}
+	if len(opts) > 0 {
+		newData, err = filterPatchFields(oldData, newData, opts)
+		if err != nil {
+			return nil, fmt.Errorf("filter patch fields failed: %v", err)
+		}
+	}
 	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, oldObj)
 	if err != nil {
 		return nil, fmt.Errorf("CreateTwoWayMergePatch failed: %v", err)
@@ -70,31 +219,196 @@ func GetPatchData(oldObj, newObj interface{}) ([]byte, error) {
 	return patchBytes, nil
 }
 
+// filterPatchFields returns the new object with only the included fields
+// taken from it, and the excluded fields taken from the old object. The
+// other differences, like the fields defaulted by the apiserver on only
+// one of the objects, are then left out of the patch.
+func filterPatchFields(oldData, newData []byte, opts []PatchFieldOption) ([]byte, error) {
+	f := &patchFields{}
+	for _, o := range opts {
+		o(f)
+	}
+	oldMap := map[string]interface{}{}
+	err := json.Unmarshal(oldData, &oldMap)
+	if err != nil {
+		return nil, err
+	}
+	target := map[string]interface{}{}
+	err = json.Unmarshal(newData, &target)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.include) > 0 {
+		newMap := target
+		target = map[string]interface{}{}
+		err = json.Unmarshal(oldData, &target)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range f.include {
+			err = copyField(target, newMap, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, path := range f.exclude {
+		err = copyField(target, oldMap, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(target)
+}
+
+// copyField sets the field at the path of dst to that of src,
+// removing it from dst if src does not have the field
+func copyField(dst, src map[string]interface{}, path string) error {
+	fields := strings.Split(path, ".")
+	value, found, err := unstructured.NestedFieldNoCopy(src, fields...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read field %s", path)
+	}
+	if !found {
+		unstructured.RemoveNestedField(dst, fields...)
+		return nil
+	}
+	err = unstructured.SetNestedField(dst, value, fields...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to set field %s", path)
+	}
+	return nil
+}
+
+// isOperatorUpgraded returns an error unless all the pods of the operator
+// have the to version in their label with the given key, and the operator
+// is available. The errors of an operator which is not at the to version
+// and of one which is not available can be told apart using errors.Is
+// with ErrOperatorNotUpgraded and ErrOperatorUnavailable.
 func isOperatorUpgraded(componentName string, namespace string,
-	toVersion string, kubeClient kubernetes.Interface) error {
+	toVersion string, labelKey string, kubeClient kubernetes.Interface) error {
 	operatorPods, err := kubeClient.CoreV1().
 		Pods(namespace).
 		List(context.TODO(), metav1.ListOptions{
 			LabelSelector: "openebs.io/component-name=" + componentName,
 		})
 	if err != nil {
-		return err
+		return newObjectError("list", "pod", namespace, "", err)
 	}
 	if len(operatorPods.Items) == 0 {
-		return fmt.Errorf("operator pod missing for %s", componentName)
+		return newValidationError(
+			errors.Wrapf(ErrOperatorUnavailable, "operator pod missing for %s", componentName),
+		)
 	}
 	for _, pod := range operatorPods.Items {
-		if pod.Labels["openebs.io/version"] != toVersion {
-			return fmt.Errorf("%s is in %s version, please upgrade it to %s version",
-				componentName, pod.Labels["openebs.io/version"], toVersion)
+		v, ok := pod.Labels[labelKey]
+		if !ok {
+			return newValidationError(
+				errors.Wrapf(ErrOperatorNotUpgraded, "%s pod %s has no %s label to read its version from, "+
+					"set the label key of the operator version if the operator is relabeled",
+					componentName, pod.Name, labelKey),
+			)
+		}
+		if v != toVersion {
+			return newValidationError(
+				errors.Wrapf(ErrOperatorNotUpgraded, "%s is in %s version, please upgrade it to %s version",
+					componentName, v, toVersion),
+			)
 		}
 	}
+	err = isOperatorAvailable(componentName, namespace, operatorPods.Items, kubeClient)
+	if err != nil {
+		return err
+	}
 	if componentName == "cspc-operator" || componentName == "cvc-operator" {
 		cstorOperatorServiceAccount = operatorPods.Items[0].Spec.ServiceAccountName
 	}
 	return nil
 }
 
+// isOperatorAvailable returns an error if the deployment of the operator
+// is not available, or if a pod of the operator is not ready or its
+// container is crashlooping. The deployment is the one with the component
+// name label of the operator, and the checks of the conditions are skipped
+// for the deployments and the pods which do not publish them yet.
+func isOperatorAvailable(componentName, namespace string,
+	pods []corev1.Pod, kubeClient kubernetes.Interface) error {
+	deployList, err := kubeClient.AppsV1().Deployments(namespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "openebs.io/component-name=" + componentName,
+		})
+	if err != nil {
+		return newObjectError("list", "deployment", namespace, "", err)
+	}
+	for _, deployObj := range deployList.Items {
+		if c := deploymentCondition(deployObj.Status, appsv1.DeploymentAvailable); c != nil {
+			if c.Status != corev1.ConditionTrue {
+				return newValidationError(
+					errors.Wrapf(ErrOperatorUnavailable, "%s deployment %s is not available: %s",
+						componentName, deployObj.Name, c.Message),
+				)
+			}
+		} else if deployObj.Status.ObservedGeneration > 0 && deployObj.Status.AvailableReplicas == 0 {
+			return newValidationError(
+				errors.Wrapf(ErrOperatorUnavailable, "%s deployment %s has no available replicas",
+					componentName, deployObj.Name),
+			)
+		}
+	}
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
+				return newValidationError(
+					errors.Wrapf(ErrOperatorUnavailable, "%s pod %s container %s is crashlooping: %s",
+						componentName, pod.Name, cs.Name, cs.State.Waiting.Message),
+				)
+			}
+		}
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodReady && c.Status != corev1.ConditionTrue {
+				return newValidationError(
+					errors.Wrapf(ErrOperatorUnavailable, "%s pod %s is not ready", componentName, pod.Name),
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// deploymentCondition returns the condition of the deployment
+// of the given type, it returns nil if there is no such condition
+func deploymentCondition(status appsv1.DeploymentStatus,
+	condType appsv1.DeploymentConditionType) *appsv1.DeploymentCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == condType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// OperatorVersions returns the distinct versions advertised by the
+// openebs operator pods in the namespace of the client
+func OperatorVersions(client *Client) ([]string, error) {
+	podList, err := client.KubeClientset.CoreV1().Pods(client.namespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "openebs.io/component-name,openebs.io/version",
+		})
+	if err != nil {
+		return nil, newAPIError(errors.Wrap(err, "failed to list operator pods"))
+	}
+	seen := map[string]bool{}
+	versions := []string{}
+	for _, podObj := range podList.Items {
+		v := podObj.Labels["openebs.io/version"]
+		if !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
 // Remove the suffix only if it is present
 // at the end of the string
 func removeSuffixFromEnd(str, suffix string) string {
@@ -109,3 +423,38 @@ func removeSuffixFromEnd(str, suffix string) string {
 	}
 	return str
 }
+
+// operatorVersionLabel returns the label key of the
+// operator pods with the version of the operator
+func (r *ResourcePatch) operatorVersionLabel() string {
+	if r.OperatorVersionLabel == "" {
+		return defaultOperatorVersionLabel
+	}
+	return r.OperatorVersionLabel
+}
+
+// skipUpgradeAnnotation returns the annotation key used to
+// exclude resources from batch upgrades
+func (r *ResourcePatch) skipUpgradeAnnotation() string {
+	if r.SkipUpgradeAnnotation == "" {
+		return defaultSkipUpgradeAnnotation
+	}
+	return r.SkipUpgradeAnnotation
+}
+
+// isSkipUpgrade returns true if the resource has the
+// skip upgrade annotation key set to true
+func isSkipUpgrade(obj metav1.Object, key string) bool {
+	return strings.EqualFold(obj.GetAnnotations()[key], "true")
+}
+
+// logSkippedResources logs the resources skipped during a batch upgrade
+func logSkippedResources(kind string, skipped []skippedResource) {
+	if len(skipped) == 0 {
+		return
+	}
+	klog.Infof("skipped %d %ss:", len(skipped), kind)
+	for _, s := range skipped {
+		klog.Infof("%s %s: skipped, %s", kind, s.name, s.reason)
+	}
+}
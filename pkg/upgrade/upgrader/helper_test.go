@@ -16,7 +16,22 @@ limitations under the License.
 
 package upgrader
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
 
 func Test_removeSuffixFromEnd(t *testing.T) {
 	type args struct {
@@ -85,3 +100,405 @@ func Test_removeSuffixFromEnd(t *testing.T) {
 		})
 	}
 }
+
+func Test_isSkipUpgrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		key         string
+		want        bool
+	}{
+		{
+			name:        "default annotation",
+			annotations: map[string]string{"openebs.io/skip-upgrade": "true"},
+			key:         NewResourcePatch().skipUpgradeAnnotation(),
+			want:        true,
+		},
+		{
+			name:        "annotation set to false",
+			annotations: map[string]string{"openebs.io/skip-upgrade": "false"},
+			key:         NewResourcePatch().skipUpgradeAnnotation(),
+			want:        false,
+		},
+		{
+			name:        "overridden annotation",
+			annotations: map[string]string{"example.com/hold": "True"},
+			key:         NewResourcePatch(WithSkipUpgradeAnnotation("example.com/hold")).skipUpgradeAnnotation(),
+			want:        true,
+		},
+		{
+			name:        "default annotation ignored when overridden",
+			annotations: map[string]string{"openebs.io/skip-upgrade": "true"},
+			key:         NewResourcePatch(WithSkipUpgradeAnnotation("example.com/hold")).skipUpgradeAnnotation(),
+			want:        false,
+		},
+		{
+			name: "no annotations",
+			key:  NewResourcePatch().skipUpgradeAnnotation(),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &metav1.ObjectMeta{Annotations: tt.annotations}
+			if got := isSkipUpgrade(obj, tt.key); got != tt.want {
+				t.Errorf("isSkipUpgrade() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isOperatorUpgraded(t *testing.T) {
+	pod := func(labels map[string]string, conds ...corev1.PodCondition) *corev1.Pod {
+		labels["openebs.io/component-name"] = "cvc-operator"
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cvc-operator-0",
+				Namespace: "openebs",
+				Labels:    labels,
+			},
+			Status: corev1.PodStatus{Conditions: conds},
+		}
+	}
+	upgraded := map[string]string{"openebs.io/version": "3.0.0"}
+	deploy := func(status corev1.ConditionStatus) *appsv1.Deployment {
+		d := upgradetesting.NewTestDeployment("cvc-operator", "3.0.0",
+			map[string]string{"openebs.io/component-name": "cvc-operator"})
+		d.Status.Conditions = []appsv1.DeploymentCondition{
+			{Type: appsv1.DeploymentAvailable, Status: status, Message: "Deployment does not have minimum availability."},
+		}
+		return d
+	}
+	crashlooping := pod(upgraded)
+	crashlooping.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{
+			Name: "cvc-operator",
+			State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		labelKey string
+		wantErr  string
+		wantKind error
+	}{
+		{
+			name:     "default label upgraded",
+			objects:  []runtime.Object{pod(map[string]string{"openebs.io/version": "3.0.0"})},
+			labelKey: defaultOperatorVersionLabel,
+		},
+		{
+			name:     "default label not upgraded",
+			objects:  []runtime.Object{pod(map[string]string{"openebs.io/version": "2.12.0"})},
+			labelKey: defaultOperatorVersionLabel,
+			wantErr:  "cvc-operator is in 2.12.0 version",
+			wantKind: ErrOperatorNotUpgraded,
+		},
+		{
+			name:     "custom label upgraded",
+			objects:  []runtime.Object{pod(map[string]string{"example.com/version": "3.0.0"})},
+			labelKey: "example.com/version",
+		},
+		{
+			name:     "custom label missing",
+			objects:  []runtime.Object{pod(map[string]string{"openebs.io/version": "3.0.0"})},
+			labelKey: "example.com/version",
+			wantErr:  "has no example.com/version label",
+			wantKind: ErrOperatorNotUpgraded,
+		},
+		{
+			name:     "operator pod missing",
+			labelKey: defaultOperatorVersionLabel,
+			wantErr:  "operator pod missing for cvc-operator",
+			wantKind: ErrOperatorUnavailable,
+		},
+		{
+			name:     "deployment available",
+			objects:  []runtime.Object{pod(upgraded), deploy(corev1.ConditionTrue)},
+			labelKey: defaultOperatorVersionLabel,
+		},
+		{
+			name:     "deployment not available",
+			objects:  []runtime.Object{pod(upgraded), deploy(corev1.ConditionFalse)},
+			labelKey: defaultOperatorVersionLabel,
+			wantErr:  "deployment cvc-operator is not available",
+			wantKind: ErrOperatorUnavailable,
+		},
+		{
+			name: "not available and not upgraded",
+			objects: []runtime.Object{
+				pod(map[string]string{"openebs.io/version": "2.12.0"}), deploy(corev1.ConditionFalse),
+			},
+			labelKey: defaultOperatorVersionLabel,
+			wantErr:  "cvc-operator is in 2.12.0 version",
+			wantKind: ErrOperatorNotUpgraded,
+		},
+		{
+			name: "pod not ready",
+			objects: []runtime.Object{
+				pod(upgraded, corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionFalse}),
+			},
+			labelKey: defaultOperatorVersionLabel,
+			wantErr:  "pod cvc-operator-0 is not ready",
+			wantKind: ErrOperatorUnavailable,
+		},
+		{
+			name: "pod ready",
+			objects: []runtime.Object{
+				pod(upgraded, corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue}),
+			},
+			labelKey: defaultOperatorVersionLabel,
+		},
+		{
+			name:     "container crashlooping",
+			objects:  []runtime.Object{crashlooping},
+			labelKey: defaultOperatorVersionLabel,
+			wantErr:  "container cvc-operator is crashlooping",
+			wantKind: ErrOperatorUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objects...)
+			err := isOperatorUpgraded("cvc-operator", "openebs", "3.0.0", tt.labelKey, client.KubeClientset)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("isOperatorUpgraded() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("isOperatorUpgraded() error = %v, want %q", err, tt.wantErr)
+			}
+			if !errors.Is(err, tt.wantKind) || !errors.Is(err, ErrValidation) {
+				t.Errorf("isOperatorUpgraded() error = %v, want a validation error of %v", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestResourcePatch_resolveFromVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		from       string
+		detected   bool
+		current    string
+		annotation string
+		wantFrom   string
+		wantErr    bool
+	}{
+		{name: "from read from the resource", current: "2.12.0", wantFrom: "2.12.0"},
+		{name: "explicit from kept", from: "2.11.0", current: "2.12.0", wantFrom: "2.11.0"},
+		{name: "detected from of another resource", from: "2.11.0", detected: true, current: "2.12.0", wantFrom: "2.12.0"},
+		{name: "no current version", wantErr: true},
+		{name: "unsupported current version", current: "1.0.0", wantErr: true},
+		{name: "custom build", current: "ci-1234", wantErr: true},
+		{name: "annotation overrides the explicit from", from: "2.11.0", current: "2.12.0", annotation: "2.10.0", wantFrom: "2.10.0"},
+		{name: "annotation overrides the current version", current: "2.12.0", annotation: "2.11.0", wantFrom: "2.11.0"},
+		{name: "invalid annotation", current: "2.12.0", annotation: "latest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourcePatch{From: tt.from, To: "3.0.0", fromDetected: tt.detected}
+			annotations := map[string]string{}
+			if tt.annotation != "" {
+				annotations[DefaultFromVersionAnnotation] = tt.annotation
+			}
+			err := r.resolveFromVersion("cspi", "cspi-1", tt.current, annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveFromVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrValidation) {
+				t.Errorf("resolveFromVersion() error = %v, want a validation error", err)
+			}
+			if err == nil && r.From != tt.wantFrom {
+				t.Errorf("resolveFromVersion() from = %s, want %s", r.From, tt.wantFrom)
+			}
+		})
+	}
+}
+
+func TestResourcePatch_resolveFromVersionBatch(t *testing.T) {
+	// the dependants of a resource whose from version is annotated
+	// fall back to the from version set for the upgrade
+	r := &ResourcePatch{From: "2.11.0", To: "3.0.0", FromVersionAnnotation: "example.com/from"}
+	err := r.resolveFromVersion("cspc", "cspc-a", "2.12.0", map[string]string{"example.com/from": "2.10.0"})
+	if err != nil || r.From != "2.10.0" {
+		t.Fatalf("resolveFromVersion() from = %s, error = %v, want 2.10.0", r.From, err)
+	}
+	res := *r
+	if res.fromDetected {
+		res.From = res.globalFrom
+	}
+	err = res.resolveFromVersion("cspi", "cspc-a-1", "2.12.0", nil)
+	if err != nil || res.From != "2.11.0" {
+		t.Errorf("resolveFromVersion() of a dependant from = %s, error = %v, want 2.11.0", res.From, err)
+	}
+}
+
+func TestResourcePatch_imageURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		baseURL  string
+		registry string
+		want     string
+		wantErr  bool
+	}{
+		{name: "no override", image: "quay.io/openebs/cstor-pool:2.12.0", want: "quay.io/openebs/cstor-pool"},
+		{name: "registry host replaced", image: "quay.io/openebs/cstor-pool:2.12.0",
+			registry: "mirror.local:5000", want: "mirror.local:5000/openebs/cstor-pool"},
+		{name: "default registry", image: "openebs/jiva:2.12.0",
+			registry: "mirror.local:5000/", want: "mirror.local:5000/openebs/jiva"},
+		{name: "localhost registry", image: "localhost/openebs/jiva:2.12.0",
+			registry: "mirror.local", want: "mirror.local/openebs/jiva"},
+		{name: "override after prefix", image: "openebs/jiva:2.12.0", baseURL: "quay.io/openebs/",
+			registry: "mirror.local", want: "mirror.local/openebs/jiva"},
+		{name: "no tag", image: "openebs/jiva", registry: "mirror.local", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourcePatch{BaseURL: tt.baseURL, ImageRegistry: tt.registry}
+			got, err := r.imageURL(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("imageURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("imageURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPatchData(t *testing.T) {
+	roThreshold := 85
+	// the old cspi is as read from the apiserver, which defaulted the
+	// read only threshold, and the new cspi is built without it
+	oldCSPI := &cstor.CStorPoolInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "cspi-1",
+			Labels: map[string]string{"openebs.io/version": "2.12.0"},
+		},
+		Spec: cstor.CStorPoolInstanceSpec{
+			PoolConfig: cstor.PoolConfig{ROThresholdLimit: &roThreshold},
+		},
+		VersionDetails: cstor.VersionDetails{Desired: "2.12.0"},
+	}
+	newCSPI := &cstor.CStorPoolInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "cspi-1",
+			Labels: map[string]string{"openebs.io/version": "3.0.0"},
+		},
+		VersionDetails: cstor.VersionDetails{Desired: "3.0.0"},
+	}
+	tests := []struct {
+		name        string
+		opts        []PatchFieldOption
+		wantFields  [][]string
+		wantDropped [][]string
+	}{
+		{
+			name: "whole object",
+			wantFields: [][]string{
+				{"metadata", "labels"}, {"versionDetails", "desired"}, {"spec", "poolConfig", "roThresholdLimit"},
+			},
+		},
+		{
+			name:        "included fields",
+			opts:        []PatchFieldOption{IncludePatchFields("metadata.labels", "versionDetails")},
+			wantFields:  [][]string{{"metadata", "labels"}, {"versionDetails", "desired"}},
+			wantDropped: [][]string{{"spec"}},
+		},
+		{
+			name:        "only versionDetails",
+			opts:        []PatchFieldOption{IncludePatchFields("versionDetails")},
+			wantFields:  [][]string{{"versionDetails", "desired"}},
+			wantDropped: [][]string{{"spec"}, {"metadata"}},
+		},
+		{
+			name:        "excluded fields",
+			opts:        []PatchFieldOption{ExcludePatchFields("spec.poolConfig.roThresholdLimit")},
+			wantFields:  [][]string{{"metadata", "labels"}, {"versionDetails", "desired"}},
+			wantDropped: [][]string{{"spec"}},
+		},
+		{
+			name: "included and excluded fields",
+			opts: []PatchFieldOption{
+				IncludePatchFields("metadata", "versionDetails"),
+				ExcludePatchFields("metadata.labels"),
+			},
+			wantFields:  [][]string{{"versionDetails", "desired"}},
+			wantDropped: [][]string{{"spec"}, {"metadata"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := GetPatchData(oldCSPI, newCSPI, tt.opts...)
+			if err != nil {
+				t.Fatalf("GetPatchData() error = %v", err)
+			}
+			patch := map[string]interface{}{}
+			err = json.Unmarshal(data, &patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range tt.wantFields {
+				if _, found, _ := unstructured.NestedFieldNoCopy(patch, f...); !found {
+					t.Errorf("GetPatchData() = %s, want field %v", data, f)
+				}
+			}
+			for _, f := range tt.wantDropped {
+				if _, found, _ := unstructured.NestedFieldNoCopy(patch, f...); found {
+					t.Errorf("GetPatchData() = %s, want no field %v", data, f)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPatchDataRemovedField(t *testing.T) {
+	oldCSPI := &cstor.CStorPoolInstance{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"openebs.io/version": "2.12.0"}},
+		Spec:       cstor.CStorPoolInstanceSpec{HostName: "node-1"},
+	}
+	newCSPI := &cstor.CStorPoolInstance{}
+	data, err := GetPatchData(oldCSPI, newCSPI, IncludePatchFields("metadata.labels"))
+	if err != nil {
+		t.Fatalf("GetPatchData() error = %v", err)
+	}
+	if string(data) != `{"metadata":{"labels":null}}` {
+		t.Errorf("GetPatchData() = %s, want only the labels removed", data)
+	}
+}
+
+func TestOperatorVersions(t *testing.T) {
+	client := NewTestClient(
+		testOperatorPod("cspc-operator", "3.0.0"),
+		testOperatorPod("cvc-operator", "3.0.0"),
+		testOperatorPod("openebs-cstor-csi-controller", "2.12.0"),
+	)
+	client.namespace = "openebs"
+	got, err := OperatorVersions(client)
+	if err != nil {
+		t.Fatalf("OperatorVersions() error = %v", err)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"2.12.0", "3.0.0"}) {
+		t.Errorf("OperatorVersions() = %v, want 2.12.0 and 3.0.0", got)
+	}
+}
+
+func TestIsFromVersionOptional(t *testing.T) {
+	for kind, want := range map[string]bool{
+		"cstorVolume":      true,
+		"cstorPoolCluster": true,
+		"rbac":             false,
+	} {
+		if got := IsFromVersionOptional(kind); got != want {
+			t.Errorf("IsFromVersionOptional(%s) = %v, want %v", kind, got, want)
+		}
+	}
+}
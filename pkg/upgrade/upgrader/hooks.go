@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// defaultHookTimeout is the time after which a hook is killed
+const defaultHookTimeout = 5 * time.Minute
+
+// RunPreUpgradeHook runs the pre upgrade hook, if set, for the
+// resource of the given kind. A failure of the hook aborts the upgrade.
+func (r *ResourcePatch) RunPreUpgradeHook(kind string) error {
+	if r.PreUpgradeHook == "" {
+		return nil
+	}
+	err := r.runHook(r.PreUpgradeHook, kind, nil)
+	if err != nil {
+		return newValidationError(errors.Wrapf(err, "pre upgrade hook failed for %s", r.Name))
+	}
+	return nil
+}
+
+// RunPostUpgradeHook runs the post upgrade hook, if set, for the
+// resource of the given kind. The result of the upgrade is passed to
+// the hook and a failure of the hook is only logged.
+func (r *ResourcePatch) RunPostUpgradeHook(kind string, upgradeErr error) {
+	if r.PostUpgradeHook == "" {
+		return
+	}
+	err := r.runHook(r.PostUpgradeHook, kind, upgradeErr)
+	if err != nil {
+		klog.Warningf("post upgrade hook failed for %s: %v", r.Name, err)
+	}
+}
+
+// runHook executes the hook with the details of the resource
+// as environment variables and logs its stdout and stderr
+func (r *ResourcePatch) runHook(path, kind string, upgradeErr error) error {
+	timeout := r.HookTimeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.Command(path)
+	// the hook runs in its own process group, so the processes
+	// it forks are killed with it once it times out
+	setProcessGroup(cmd)
+	cmd.Env = append(os.Environ(),
+		"OPENEBS_UPGRADE_KIND="+kind,
+		"OPENEBS_UPGRADE_NAME="+r.Name,
+		"OPENEBS_UPGRADE_NAMESPACE="+r.OpenebsNamespace,
+		"OPENEBS_UPGRADE_FROM_VERSION="+r.From,
+		"OPENEBS_UPGRADE_TO_VERSION="+r.To,
+	)
+	if upgradeErr != nil {
+		cmd.Env = append(cmd.Env, "OPENEBS_UPGRADE_RESULT=failed")
+	} else {
+		cmd.Env = append(cmd.Env, "OPENEBS_UPGRADE_RESULT=success")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	klog.Infof("Running hook %s for %s %s", path, kind, r.Name)
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrapf(err, "failed to start hook %s", path)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go logHookOutput(&wg, path, stdout, false)
+	go logHookOutput(&wg, path, stderr, true)
+	// the pipes need to be read completely before waiting on the hook,
+	// they are closed once all the processes of the hook exit
+	wg.Wait()
+	err = cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return newTimeoutError(errors.Errorf("hook %s timed out after %s", path, timeout))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "hook %s failed", path)
+	}
+	return nil
+}
+
+func logHookOutput(wg *sync.WaitGroup, path string, r io.Reader, isStderr bool) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if isStderr {
+			klog.Warningf("hook %s: %s", path, scanner.Text())
+			continue
+		}
+		klog.Infof("hook %s: %s", path, scanner.Text())
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func writeHook(t *testing.T, dir, name, script string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatalf("failed to write hook: %v", err)
+	}
+	return path
+}
+
+func TestResourcePatch_RunPreUpgradeHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name     string
+		script   string
+		timeout  time.Duration
+		wantKind error
+	}{
+		{
+			name:   "env is passed",
+			script: `[ "$OPENEBS_UPGRADE_KIND" = "cstorVolume" ] && [ "$OPENEBS_UPGRADE_NAME" = "pvc-1" ] && [ "$OPENEBS_UPGRADE_TO_VERSION" = "3.0.0" ]`,
+		},
+		{
+			name:     "failure aborts",
+			script:   "echo failing >&2; exit 1",
+			wantKind: ErrValidation,
+		},
+		{
+			name:     "timeout",
+			script:   "exec sleep 5",
+			timeout:  100 * time.Millisecond,
+			wantKind: ErrTimeout,
+		},
+		{
+			// the forked sleep keeps the output pipes open
+			name:     "timeout of a forking hook",
+			script:   "sleep 5; echo done",
+			timeout:  100 * time.Millisecond,
+			wantKind: ErrTimeout,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResourcePatch(
+				WithName("pvc-1"),
+				WithOpenebsNamespace("openebs"),
+				FromVersion("2.12.0"),
+				ToVersion("3.0.0"),
+				WithPreUpgradeHook(writeHook(t, dir, "hook"+string(rune('a'+i)), tt.script)),
+				WithHookTimeout(tt.timeout),
+			)
+			start := time.Now()
+			err := r.RunPreUpgradeHook("cstorVolume")
+			if tt.timeout > 0 && time.Since(start) > 2*time.Second {
+				t.Errorf("RunPreUpgradeHook() returned after %s, timeout is %s", time.Since(start), tt.timeout)
+			}
+			if tt.wantKind == nil {
+				if err != nil {
+					t.Fatalf("RunPreUpgradeHook() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("RunPreUpgradeHook() error = %v, want kind %v", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestResourcePatch_RunPostUpgradeHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "result")
+	tests := []struct {
+		name       string
+		upgradeErr error
+		want       string
+	}{
+		{
+			name: "success",
+			want: "success",
+		},
+		{
+			name:       "failure",
+			upgradeErr: errors.New("upgrade failed"),
+			want:       "failed",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResourcePatch(
+				WithName("pvc-1"),
+				ToVersion("3.0.0"),
+				WithPostUpgradeHook(writeHook(t, dir, "post"+string(rune('a'+i)),
+					`printf "$OPENEBS_UPGRADE_RESULT" > `+out+`; exit 1`)),
+			)
+			// the failure of the hook is only logged
+			r.RunPostUpgradeHook("cstorVolume", tt.upgradeErr)
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatalf("hook did not run: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("RunPostUpgradeHook() passed result %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
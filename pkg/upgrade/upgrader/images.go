@@ -0,0 +1,473 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// defaultRegistry is the registry of the images without a registry host
+	defaultRegistry = "docker.io"
+	// dockerHubRegistry is the api endpoint of the default registry
+	dockerHubRegistry = "registry-1.docker.io"
+	registryTimeout   = 30 * time.Second
+)
+
+// manifestMediaTypes are the manifest types accepted from the registry,
+// including the manifest lists of the multi arch images
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// upgradeImages are the images of the components patched by the upgrade,
+// along with the upgrade job itself, as they are in the default registry
+var upgradeImages = []string{
+	"openebs/cstor-istgt",
+	"openebs/cstor-pool",
+	"openebs/cstor-pool-manager",
+	"openebs/cstor-volume-manager",
+	"openebs/jiva",
+	"openebs/m-exporter",
+	"openebs/upgrade",
+}
+
+// GenerateImageList returns the images needed by the upgrade from the
+// from version to the to version, to be mirrored to a private registry
+// before the upgrade of an air-gapped cluster. The images of the older
+// versions, like those with the -amd64 suffix, are all upgraded to the
+// same images, so the list is only empty if the versions are the same.
+func GenerateImageList(from, to string) []string {
+	if from == to {
+		return []string{}
+	}
+	list := []string{}
+	for _, image := range upgradeImages {
+		list = append(list, defaultRegistry+"/"+image+":"+to)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// imageRef is a parsed image reference
+type imageRef struct {
+	registry   string
+	repository string
+	tag        string
+}
+
+// registryAuth is the credential of a registry from a pull secret
+type registryAuth struct {
+	username, password string
+}
+
+// imageVerifier checks the manifests of images in their registries
+type imageVerifier struct {
+	httpClient *http.Client
+	// auths are the credentials keyed by the registry host
+	auths map[string]registryAuth
+	// scheme is https except in tests
+	scheme string
+}
+
+// podSpecLabel returns the label selecting the deployments
+// and statefulsets of the resource of the given kind
+func podSpecLabel(kind, name string) (string, error) {
+	switch kind {
+	case "cstorPoolCluster":
+		return "openebs.io/cstor-pool-cluster=" + name, nil
+	case "cstorPoolInstance":
+		return "openebs.io/cstor-pool-instance=" + name, nil
+	case "cstorVolume", "jivaVolume":
+		return "openebs.io/persistent-volume=" + name, nil
+	}
+	return "", errors.Errorf("image verification is not supported for %s", kind)
+}
+
+// getPodSpecs returns the pod specs of the deployments and
+// statefulsets of the resource of the given kind
+func (r *ResourcePatch) getPodSpecs(kind string, client *Client) ([]corev1.PodSpec, error) {
+	label, err := podSpecLabel(kind, r.Name)
+	if err != nil {
+		return nil, newValidationError(err)
+	}
+	opts := metav1.ListOptions{LabelSelector: label}
+	deployList, err := client.KubeClientset.AppsV1().Deployments(r.OpenebsNamespace).
+		List(context.TODO(), opts)
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list deployments for %s", r.Name))
+	}
+	stsList, err := client.KubeClientset.AppsV1().StatefulSets(r.OpenebsNamespace).
+		List(context.TODO(), opts)
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list statefulsets for %s", r.Name))
+	}
+	specs := []corev1.PodSpec{}
+	for _, d := range deployList.Items {
+		specs = append(specs, d.Spec.Template.Spec)
+	}
+	for _, s := range stsList.Items {
+		specs = append(specs, s.Spec.Template.Spec)
+	}
+	return specs, nil
+}
+
+// targetImages returns the sorted images the containers of the pod
+// specs are patched to, the same way the upgrade transforms them
+func (r *ResourcePatch) targetImages(specs []corev1.PodSpec) ([]string, error) {
+	tag := r.To
+	if r.ImageTag != "" {
+		tag = r.ImageTag
+	}
+	images := map[string]bool{}
+	for _, spec := range specs {
+		for _, c := range spec.Containers {
+			url, err := r.imageURL(c.Image)
+			if err != nil {
+				return nil, err
+			}
+			url = removeSuffixFromEnd(url, "-amd64")
+			if c.Name == poolManagerContainer && r.PoolManagerImage != "" {
+				images[r.PoolManagerImage] = true
+				continue
+			}
+			images[url+":"+tag] = true
+		}
+	}
+	list := []string{}
+	for image := range images {
+		list = append(list, image)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// pullSecretNames returns the image pull secrets of the pod
+// specs along with the ImagePullSecret if set
+func (r *ResourcePatch) pullSecretNames(specs []corev1.PodSpec) []string {
+	names := map[string]bool{}
+	if r.ImagePullSecret != "" {
+		names[r.ImagePullSecret] = true
+	}
+	for _, spec := range specs {
+		for _, s := range spec.ImagePullSecrets {
+			names[s.Name] = true
+		}
+	}
+	list := []string{}
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// VerifyImages checks that the images of the To version of the resource
+// of the given kind exist in their registries before it is patched, to
+// catch a wrong To version or image tag before any resource is touched.
+func VerifyImages(kind string, r *ResourcePatch, client *Client) error {
+	specs, err := r.getPodSpecs(kind, client)
+	if err != nil {
+		return err
+	}
+	images, err := r.targetImages(specs)
+	if err != nil {
+		return newValidationError(err)
+	}
+	auths := map[string]registryAuth{}
+	for _, name := range r.pullSecretNames(specs) {
+		secret, err := client.KubeClientset.CoreV1().Secrets(r.OpenebsNamespace).
+			Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to get image pull secret %s", name))
+		}
+		err = parsePullSecret(secret, auths)
+		if err != nil {
+			return newValidationError(errors.Wrapf(err, "invalid image pull secret %s", name))
+		}
+	}
+	v := &imageVerifier{
+		httpClient: &http.Client{Timeout: registryTimeout},
+		auths:      auths,
+		scheme:     "https",
+	}
+	return v.verify(images)
+}
+
+// verify checks all the images and returns an error
+// listing the images missing from the registries
+func (v *imageVerifier) verify(images []string) error {
+	missing := []string{}
+	for _, image := range images {
+		klog.Infof("Verifying image %s exists in the registry", image)
+		exists, err := v.imageExists(image)
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to verify image %s", image))
+		}
+		if !exists {
+			missing = append(missing, image)
+		}
+	}
+	if len(missing) != 0 {
+		return newValidationError(
+			errors.Errorf("images not found in the registry: %s", strings.Join(missing, ", ")),
+		)
+	}
+	return nil
+}
+
+// parseImage splits the image into its registry, repository and tag
+// following the docker conventions for the default registry
+func parseImage(image string) (imageRef, error) {
+	ref := imageRef{registry: defaultRegistry}
+	lastIndex := strings.LastIndex(image, ":")
+	if lastIndex == -1 || strings.Contains(image[lastIndex:], "/") {
+		return ref, errors.Errorf("no version tag found on image %s", image)
+	}
+	name, tag := image[:lastIndex], image[lastIndex+1:]
+	ref.tag = tag
+	parts := strings.SplitN(name, "/", 2)
+	// the first part is a registry host only if it looks like one
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.registry, name = parts[0], parts[1]
+	}
+	if ref.registry == defaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.repository = name
+	return ref, nil
+}
+
+// parsePullSecret adds the credentials of a dockerconfigjson
+// or dockercfg secret to the auths keyed by the registry host
+func parsePullSecret(secret *corev1.Secret, auths map[string]registryAuth) error {
+	type authEntry struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	entries := map[string]authEntry{}
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		config := struct {
+			Auths map[string]authEntry `json:"auths"`
+		}{}
+		err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config)
+		if err != nil {
+			return err
+		}
+		entries = config.Auths
+	case corev1.SecretTypeDockercfg:
+		err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &entries)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("unsupported secret type %s", secret.Type)
+	}
+	for host, entry := range entries {
+		auth := registryAuth{username: entry.Username, password: entry.Password}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return errors.Wrapf(err, "failed to decode auth for %s", host)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return errors.Errorf("invalid auth for %s", host)
+			}
+			auth = registryAuth{username: parts[0], password: parts[1]}
+		}
+		auths[registryHost(host)] = auth
+	}
+	return nil
+}
+
+// registryHost normalizes the registry key of a pull secret,
+// which can be a url like https://index.docker.io/v1/
+func registryHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	if host == "index.docker.io" || host == dockerHubRegistry {
+		return defaultRegistry
+	}
+	return host
+}
+
+// imageExists sends a HEAD request for the manifest of the image,
+// fetching a bearer token if the registry asks for one
+func (v *imageVerifier) imageExists(image string) (bool, error) {
+	ref, err := parseImage(image)
+	if err != nil {
+		return false, err
+	}
+	host := ref.registry
+	if host == defaultRegistry {
+		host = dockerHubRegistry
+	}
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", v.scheme, host, ref.repository, ref.tag)
+	auth, hasAuth := v.auths[ref.registry]
+	resp, err := v.headManifest(manifestURL, "")
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("Www-Authenticate")
+		authHeader := ""
+		if strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			token, err := v.getToken(challenge, auth, hasAuth)
+			if err != nil {
+				return false, err
+			}
+			authHeader = "Bearer " + token
+		} else if hasAuth {
+			authHeader = "Basic " + base64.StdEncoding.EncodeToString(
+				[]byte(auth.username+":"+auth.password))
+		}
+		if authHeader != "" {
+			resp, err = v.headManifest(manifestURL, authHeader)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, errors.Errorf("unexpected status %s from %s", resp.Status, manifestURL)
+}
+
+func (v *imageVerifier) headManifest(manifestURL, authHeader string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// getToken fetches a bearer token from the realm of the challenge,
+// for example Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func (v *imageVerifier) getToken(challenge string, auth registryAuth, hasAuth bool) (string, error) {
+	params := parseChallenge(challenge[len("bearer "):])
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.Errorf("no realm in authentication challenge %q", challenge)
+	}
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenURL := realm
+	if len(query) != 0 {
+		tokenURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if hasAuth {
+		req.SetBasicAuth(auth.username, auth.password)
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected status %s from %s", resp.Status, realm)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to decode token from %s", realm)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// parseChallenge parses the comma separated key="value" pairs of
+// an authentication challenge
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, ", ")
+		eq := strings.Index(s, "=")
+		if eq == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+		value := ""
+		if strings.HasPrefix(s, "\"") {
+			end := strings.Index(s[1:], "\"")
+			if end == -1 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.Index(s, ",")
+			if end == -1 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end:]
+			}
+		}
+		params[key] = value
+	}
+	return params
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		image   string
+		want    imageRef
+		wantErr bool
+	}{
+		{
+			image: "openebs/cstor-pool:3.0.0",
+			want:  imageRef{registry: "docker.io", repository: "openebs/cstor-pool", tag: "3.0.0"},
+		},
+		{
+			image: "busybox:1.33",
+			want:  imageRef{registry: "docker.io", repository: "library/busybox", tag: "1.33"},
+		},
+		{
+			image: "quay.io/openebs/cstor-pool-manager:3.0.0",
+			want:  imageRef{registry: "quay.io", repository: "openebs/cstor-pool-manager", tag: "3.0.0"},
+		},
+		{
+			image: "localhost:5000/openebs/m-exporter:3.0.0",
+			want:  imageRef{registry: "localhost:5000", repository: "openebs/m-exporter", tag: "3.0.0"},
+		},
+		{
+			image:   "localhost:5000/openebs/m-exporter",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, err := parseImage(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseImage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePullSecret(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	secret := &corev1.Secret{
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths": {
+				"https://index.docker.io/v1/": {"auth": "` + auth + `"},
+				"quay.io": {"username": "quser", "password": "qpass"}
+			}}`),
+		},
+	}
+	auths := map[string]registryAuth{}
+	err := parsePullSecret(secret, auths)
+	if err != nil {
+		t.Fatalf("parsePullSecret() error = %v", err)
+	}
+	want := map[string]registryAuth{
+		"docker.io": {username: "user", password: "pass"},
+		"quay.io":   {username: "quser", password: "qpass"},
+	}
+	if !reflect.DeepEqual(auths, want) {
+		t.Errorf("parsePullSecret() = %+v, want %+v", auths, want)
+	}
+	err = parsePullSecret(&corev1.Secret{Type: corev1.SecretTypeOpaque}, auths)
+	if err == nil {
+		t.Errorf("parsePullSecret() expected error for opaque secret")
+	}
+}
+
+func TestTargetImages(t *testing.T) {
+	r := &ResourcePatch{To: "3.0.0", BaseURL: "registry.local/openebs/"}
+	specs := []corev1.PodSpec{
+		{
+			Containers: []corev1.Container{
+				{Image: "openebs/cstor-pool-manager-amd64:2.12.0"},
+				{Image: "openebs/cstor-pool:2.12.0"},
+			},
+		},
+		{
+			Containers: []corev1.Container{
+				{Image: "openebs/cstor-pool:2.12.0"},
+			},
+		},
+	}
+	got, err := r.targetImages(specs)
+	if err != nil {
+		t.Fatalf("targetImages() error = %v", err)
+	}
+	want := []string{
+		"registry.local/openebs/cstor-pool-manager:3.0.0",
+		"registry.local/openebs/cstor-pool:3.0.0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("targetImages() = %v, want %v", got, want)
+	}
+}
+
+func TestResourcePatch_getPodSpecs(t *testing.T) {
+	labels := map[string]string{"openebs.io/persistent-volume": "pvc-1"}
+	deploy := upgradetesting.NewTestDeployment("pvc-1-ctrl", "2.12.0", labels, "openebs/jiva:2.12.0")
+	deploy.Spec.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "regcred"}}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1-rep", Namespace: upgradetesting.Namespace, Labels: labels},
+		Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Image: "openebs/jiva:2.12.0"}},
+		}}},
+	}
+	client := NewTestClient(deploy, sts,
+		upgradetesting.NewTestDeployment("pvc-2-ctrl", "2.12.0",
+			map[string]string{"openebs.io/persistent-volume": "pvc-2"}, "openebs/jiva:2.12.0"),
+	)
+	r := &ResourcePatch{Name: "pvc-1", To: "3.0.0", OpenebsNamespace: upgradetesting.Namespace, ImagePullSecret: "mirror"}
+	specs, err := r.getPodSpecs("jivaVolume", client)
+	if err != nil {
+		t.Fatalf("getPodSpecs() error = %v", err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("getPodSpecs() got %d specs, want the deployment and statefulset of pvc-1", len(specs))
+	}
+	if got, want := r.pullSecretNames(specs), []string{"mirror", "regcred"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pullSecretNames() = %v, want %v", got, want)
+	}
+	if _, err := r.getPodSpecs("storageClass", client); !errors.Is(err, ErrValidation) {
+		t.Errorf("getPodSpecs() of an unsupported kind error = %v, want a validation error", err)
+	}
+}
+
+func TestVerifyImages_pullSecrets(t *testing.T) {
+	deploy := upgradetesting.NewTestDeployment("cspi-1", "2.12.0",
+		map[string]string{"openebs.io/cstor-pool-instance": "cspi-1"}, "openebs/cstor-pool:2.12.0")
+	opaque := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: upgradetesting.Namespace},
+		Type:       corev1.SecretTypeOpaque,
+	}
+	tests := []struct {
+		name     string
+		secret   string
+		wantKind error
+	}{
+		{name: "missing secret", secret: "regcred", wantKind: ErrAPI},
+		{name: "invalid secret", secret: "opaque", wantKind: ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourcePatch{Name: "cspi-1", To: "3.0.0", OpenebsNamespace: upgradetesting.Namespace,
+				ImagePullSecret: tt.secret}
+			err := VerifyImages("cstorPoolInstance", r, NewTestClient(deploy, opaque))
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("VerifyImages() error = %v, want a %v error", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestGenerateImageList(t *testing.T) {
+	if got := GenerateImageList("3.0.0", "3.0.0"); len(got) != 0 {
+		t.Errorf("GenerateImageList() = %v, want no images", got)
+	}
+	got := GenerateImageList("2.12.0", "3.0.0")
+	if len(got) != len(upgradeImages) {
+		t.Fatalf("GenerateImageList() = %v, want %d images", got, len(upgradeImages))
+	}
+	for _, image := range got {
+		if !strings.HasPrefix(image, "docker.io/openebs/") || !strings.HasSuffix(image, ":3.0.0") {
+			t.Errorf("GenerateImageList() unexpected image %s", image)
+		}
+	}
+	if !sort.StringsAreSorted(got) {
+		t.Errorf("GenerateImageList() = %v, want sorted", got)
+	}
+}
+
+func TestImageVerifier(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/token":
+			user, pass, ok := req.BasicAuth()
+			if !ok || user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if req.URL.Query().Get("service") != "test-registry" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token": "secret-token"}`))
+		case req.Header.Get("Authorization") != "Bearer secret-token":
+			w.Header().Set("Www-Authenticate",
+				`Bearer realm="`+server.URL+`/token",service="test-registry",scope="repository:openebs/cstor-pool:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case req.URL.Path == "/v2/openebs/cstor-pool/manifests/3.0.0":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name        string
+		images      []string
+		auths       map[string]registryAuth
+		wantErr     bool
+		wantMissing string
+	}{
+		{
+			name:   "image exists",
+			images: []string{host + "/openebs/cstor-pool:3.0.0"},
+			auths:  map[string]registryAuth{host: {username: "user", password: "pass"}},
+		},
+		{
+			name: "image is missing",
+			images: []string{
+				host + "/openebs/cstor-pool:3.0.0",
+				host + "/openebs/cstor-pool:3.0.1",
+			},
+			auths:       map[string]registryAuth{host: {username: "user", password: "pass"}},
+			wantErr:     true,
+			wantMissing: host + "/openebs/cstor-pool:3.0.1",
+		},
+		{
+			name:    "wrong credentials",
+			images:  []string{host + "/openebs/cstor-pool:3.0.0"},
+			auths:   map[string]registryAuth{host: {username: "user", password: "wrong"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &imageVerifier{
+				httpClient: server.Client(),
+				auths:      tt.auths,
+				scheme:     "http",
+			}
+			err := v.verify(tt.images)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantMissing != "" && !strings.Contains(err.Error(), tt.wantMissing) {
+				t.Errorf("verify() error = %v, want missing %s", err, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	got := parseChallenge(`realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:openebs/cstor-pool:pull"`)
+	want := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:openebs/cstor-pool:pull",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChallenge() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	iscsiJobTimeout = 10 * time.Minute
+	// iscsiJobLabel identifies the jobs which update the iscsi portals
+	iscsiJobLabel = "openebs.io/upgrade-iscsi-portal"
+	// iscsiMultipathExitCode is the exit code of the job if the device
+	// of the session is not part of a multipath map
+	iscsiMultipathExitCode = 2
+)
+
+// ISCSIPortalPatch moves the iscsi session of a volume on the node it
+// is mounted on to the current portal of its target, by running a
+// privileged job on the node which runs the iscsiadm of the host in its
+// namespaces. The session of the new portal is logged in before the one
+// of the old portal is logged out, which only keeps the device of the
+// volume if it is part of a multipath map, so the job first verifies it.
+type ISCSIPortalPatch struct {
+	*ResourcePatch
+	*Client
+	// Node is the name of the node the volume is mounted on
+	Node string
+	// IQN and Portal are the iqn and the current portal of the target
+	IQN    string
+	Portal string
+}
+
+// ISCSIPortalPatchOptions ...
+type ISCSIPortalPatchOptions func(*ISCSIPortalPatch)
+
+// WithISCSIPortalResorcePatch ...
+func WithISCSIPortalResorcePatch(r *ResourcePatch) ISCSIPortalPatchOptions {
+	return func(obj *ISCSIPortalPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithISCSIPortalClient ...
+func WithISCSIPortalClient(c *Client) ISCSIPortalPatchOptions {
+	return func(obj *ISCSIPortalPatch) {
+		obj.Client = c
+	}
+}
+
+// WithISCSIPortalTarget ...
+func WithISCSIPortalTarget(node, iqn, portal string) ISCSIPortalPatchOptions {
+	return func(obj *ISCSIPortalPatch) {
+		obj.Node = node
+		obj.IQN = iqn
+		obj.Portal = portal
+	}
+}
+
+// NewISCSIPortalPatch ...
+func NewISCSIPortalPatch(opts ...ISCSIPortalPatchOptions) *ISCSIPortalPatch {
+	obj := &ISCSIPortalPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// script returns the shell script which logs the session of the iqn in
+// to the portal and out of any other portal, after verifying that the
+// device of the session is part of a multipath map. It exits right away
+// if the session is already on the portal only, or there is no session.
+func (obj *ISCSIPortalPatch) script() string {
+	return strings.Join([]string{
+		"set -e",
+		fmt.Sprintf("iqn=%q", obj.IQN),
+		fmt.Sprintf("portal=%q", obj.Portal),
+		`sessions=$(iscsiadm -m session 2>/dev/null || true)`,
+		`old=$(echo "$sessions" | awk -v iqn="$iqn" -v p="$portal," '$4 == iqn && index($3, p) != 1 {split($3, a, ","); print a[1]; exit}')`,
+		`[ -n "$old" ] || exit 0`,
+		`sid=$(echo "$sessions" | awk -v iqn="$iqn" -v p="$old," '$4 == iqn && index($3, p) == 1 {gsub(/[][]/, "", $2); print $2; exit}')`,
+		`dev=$(iscsiadm -m session -r "$sid" -P 3 | awk '/Attached scsi disk/ {print $4; exit}')`,
+		fmt.Sprintf(`if [ -z "$dev" ] || ! multipath -ll 2>/dev/null | grep -qw "$dev"; then `+
+			`echo "device $dev of $iqn is not part of a multipath map" >&2; exit %d; fi`, iscsiMultipathExitCode),
+		`if ! echo "$sessions" | awk -v iqn="$iqn" -v p="$portal," '$4 == iqn && index($3, p) == 1 {f=1} END {exit !f}'; then`,
+		`  iscsiadm -m node -T "$iqn" -p "$portal" -o new`,
+		`  iscsiadm -m node -T "$iqn" -p "$portal" --login`,
+		`fi`,
+		`iscsiadm -m node -T "$iqn" -p "$old" --logout`,
+		`iscsiadm -m node -T "$iqn" -p "$old" -o delete`,
+	}, "\n")
+}
+
+// jobName returns the name of the job of the volume and the script, so
+// that the same portal is set by the same job on a node
+func (obj *ISCSIPortalPatch) jobName(script string) string {
+	sum := sha256.Sum256([]byte(obj.Node + "\n" + script))
+	hash := hex.EncodeToString(sum[:])[:8]
+	name := strings.Trim(strings.ToLower(obj.Name), ".-")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return "upgrade-iscsi-" + name + "-" + hash
+}
+
+func (obj *ISCSIPortalPatch) buildJob(name, script string) *batchv1.Job {
+	privileged := true
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: obj.OpenebsNamespace,
+			Labels:    map[string]string{iscsiJobLabel: "true"},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{iscsiJobLabel: "true"},
+				},
+				Spec: corev1.PodSpec{
+					NodeName:      obj.Node,
+					HostPID:       true,
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:  "iscsi",
+							Image: nodeJobImage,
+							// run the iscsiadm and multipath of the host in its namespaces
+							Command: []string{
+								"nsenter", "--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid",
+								"--", "sh", "-c", script,
+							},
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: &privileged,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Upgrade moves the iscsi session of the volume to the portal. A job of
+// the same portal that already succeeded is not run again, and a failed
+// one is reported with the name of the job to read its logs from.
+func (obj *ISCSIPortalPatch) Upgrade() error {
+	if obj.Node == "" || obj.IQN == "" || obj.Portal == "" {
+		return nil
+	}
+	script := obj.script()
+	name := obj.jobName(script)
+	jobClient := obj.KubeClientset.BatchV1().Jobs(obj.OpenebsNamespace)
+	_, err := jobClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		if obj.VerifyOnly {
+			klog.Infof("skipping the iscsi portal of volume %s on node %s in verify only mode", obj.Name, obj.Node)
+			return nil
+		}
+		klog.Infof("node %s: updating the iscsi portal of volume %s to %s using job %s",
+			obj.Node, obj.Name, obj.Portal, name)
+		_, err = jobClient.Create(context.TODO(), obj.buildJob(name, script), metav1.CreateOptions{})
+	}
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to run iscsi job %s", name))
+	}
+	return obj.waitForJob(name)
+}
+
+func (obj *ISCSIPortalPatch) waitForJob(name string) error {
+	interval := obj.pollInterval()
+	log := &waitLogger{}
+	deadline := time.Now().Add(iscsiJobTimeout)
+	for {
+		jobObj, err := obj.KubeClientset.BatchV1().Jobs(obj.OpenebsNamespace).
+			Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to get iscsi job %s", name))
+		}
+		if jobObj.Status.Succeeded > 0 {
+			klog.Infof("node %s: the iscsi portal of volume %s is up to date", obj.Node, obj.Name)
+			return nil
+		}
+		if jobObj.Status.Failed > 0 {
+			return errors.Errorf("iscsi job %s failed to update the portal of volume %s on node %s, "+
+				"the device must be part of a multipath map, delete the job after checking its logs to retry",
+				name, obj.Name, obj.Node)
+		}
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf("timed out waiting for iscsi job %s to complete", name))
+		}
+		log.Infof("node %s: waiting for iscsi job %s to complete", obj.Node, name)
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
+	}
+}
+
+// updateISCSIPortal moves the iscsi session of the volume on the node it
+// is published on to the portal of the upgraded target, if
+// UpdateISCSIPortal is set and the volume is published
+func (obj *CStorVolumePatch) updateISCSIPortal() error {
+	if !obj.UpdateISCSIPortal {
+		return nil
+	}
+	node := obj.CVC.Object.Publish.NodeID
+	if node == "" {
+		klog.Infof("volume %s is not published, skipping the update of its iscsi portal", obj.Name)
+		return nil
+	}
+	return NewISCSIPortalPatch(
+		WithISCSIPortalResorcePatch(obj.ResourcePatch),
+		WithISCSIPortalClient(obj.Client),
+		WithISCSIPortalTarget(node, obj.CV.Object.Spec.Iqn, obj.CV.Object.Spec.TargetPortal),
+	).Upgrade()
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testISCSIPortalPatch(client *Client) *ISCSIPortalPatch {
+	return NewISCSIPortalPatch(
+		WithISCSIPortalResorcePatch(NewResourcePatch(
+			WithName("pvc-1"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			WithPollInterval(time.Millisecond),
+		)),
+		WithISCSIPortalClient(client),
+		WithISCSIPortalTarget("node-1", "iqn.2016-09.com.openebs.cstor:pvc-1", "10.0.0.2:3260"),
+	)
+}
+
+func TestISCSIPortalPatch_script(t *testing.T) {
+	script := testISCSIPortalPatch(NewTestClient()).script()
+	for _, want := range []string{
+		`iqn="iqn.2016-09.com.openebs.cstor:pvc-1"`,
+		`portal="10.0.0.2:3260"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script() = %s, want it to contain %s", script, want)
+		}
+	}
+	// the multipath map is verified before any session is changed
+	check := strings.Index(script, "multipath -ll")
+	login := strings.Index(script, "--login")
+	logout := strings.Index(script, "--logout")
+	if check < 0 || check > login || login > logout {
+		t.Errorf("script() = %s, want the multipath check, then the login, then the logout", script)
+	}
+}
+
+func TestISCSIPortalPatch_Upgrade(t *testing.T) {
+	client := NewTestClient()
+	obj := testISCSIPortalPatch(client)
+	name := obj.jobName(obj.script())
+	jobs := client.KubeClientset.BatchV1().Jobs(upgradetesting.Namespace)
+	// complete the job once it is created
+	go func() {
+		for {
+			jobObj, err := jobs.Get(context.TODO(), name, metav1.GetOptions{})
+			if err == nil {
+				jobObj.Status.Succeeded = 1
+				jobs.Update(context.TODO(), jobObj, metav1.UpdateOptions{})
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err := obj.Upgrade()
+	if err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	jobObj, err := jobs.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the iscsi job: %v", err)
+	}
+	podSpec := jobObj.Spec.Template.Spec
+	if !podSpec.HostPID || podSpec.NodeName != "node-1" ||
+		!*podSpec.Containers[0].SecurityContext.Privileged {
+		t.Errorf("iscsi job pod spec = %+v, want a privileged pod with host pid on node-1", podSpec)
+	}
+
+	// the succeeded job of the same portal is not run again
+	err = obj.Upgrade()
+	if err != nil {
+		t.Errorf("Upgrade() again error = %v", err)
+	}
+
+	// a failed job is reported with its name
+	failed := jobObj.DeepCopy()
+	failed.Status = batchv1.JobStatus{Failed: 1}
+	jobs.Update(context.TODO(), failed, metav1.UpdateOptions{})
+	err = obj.Upgrade()
+	if err == nil || !strings.Contains(err.Error(), name) {
+		t.Errorf("Upgrade() of a failed job error = %v, want the job name", err)
+	}
+}
+
+func TestCStorVolumePatch_updateISCSIPortal(t *testing.T) {
+	client := NewTestClient()
+	obj := NewCStorVolumePatch(
+		WithCStorVolumeResorcePatch(NewResourcePatch(
+			WithName("pvc-1"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			WithUpdateISCSIPortal(true),
+		)),
+		WithCStorVolumeClient(client),
+	)
+	obj.CVC = &patch.CVC{Object: &cstor.CStorVolumeConfig{}}
+	obj.CV = &patch.CV{Object: &cstor.CStorVolume{}}
+	obj.CV.Object.Spec.Iqn = "iqn.2016-09.com.openebs.cstor:pvc-1"
+	obj.CV.Object.Spec.TargetPortal = "10.0.0.2:3260"
+	// no job is run for a volume which is not published
+	if err := obj.updateISCSIPortal(); err != nil {
+		t.Fatalf("updateISCSIPortal() error = %v", err)
+	}
+	jobList, err := client.KubeClientset.BatchV1().Jobs(upgradetesting.Namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list jobs: %v", err)
+	}
+	if len(jobList.Items) != 0 {
+		t.Errorf("updateISCSIPortal() of an unpublished volume created %d jobs", len(jobList.Items))
+	}
+}
@@ -17,20 +17,18 @@ limitations under the License.
 package upgrader
 
 import (
-	"time"
-
 	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1"
 	"github.com/openebs/upgrade/pkg/upgrade/patch"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	"github.com/pkg/errors"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
 	"k8s.io/klog"
 )
 
@@ -74,7 +72,8 @@ func NewJivaVolumePatch(opts ...JivaVolumePatchOptions) *JivaVolumePatch {
 
 // PreUpgrade ...
 func (obj *JivaVolumePatch) PreUpgrade() (string, error) {
-	err := isOperatorUpgraded("jiva-operator", obj.Namespace, obj.To, obj.KubeClientset)
+	err := isOperatorUpgraded("jiva-operator", obj.Namespace, obj.To,
+		obj.operatorVersionLabel(), obj.KubeClientset)
 	if err != nil {
 		return "failed to verify jiva-operator", err
 	}
@@ -93,6 +92,17 @@ func (obj *JivaVolumePatch) PreUpgrade() (string, error) {
 	return "", nil
 }
 
+// newJivaClient returns the runtime client used to get and patch
+// the jivavolume custom resources
+func newJivaClient(config *rest.Config) (client.Client, error) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(jv.AddToScheme(scheme))
+	return client.New(config, client.Options{
+		Scheme: scheme,
+	})
+}
+
 // Init initializes all the fields of the JivaVolumePatch
 func (obj *JivaVolumePatch) Init() (string, error) {
 	pvLabel := "openebs.io/persistent-volume=" + obj.Name
@@ -117,13 +127,7 @@ func (obj *JivaVolumePatch) Init() (string, error) {
 	obj.Service = patch.NewService(
 		patch.WithKubeClient(obj.KubeClientset),
 	)
-	scheme := runtime.NewScheme()
-	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
-	utilruntime.Must(jv.AddToScheme(scheme))
-	clientgoscheme.AddToScheme(scheme)
-	cl, err := client.New(config.GetConfigOrDie(), client.Options{
-		Scheme: scheme,
-	})
+	cl, err := newJivaClient(obj.Config)
 	if err != nil {
 		return "failed to create runtime client", err
 	}
@@ -139,6 +143,14 @@ func (obj *JivaVolumePatch) Init() (string, error) {
 	if err != nil {
 		return "failed to get jivavolume CR for volume" + obj.Name, err
 	}
+	err = obj.resolveFromVersion("jivavolume", obj.Name, obj.JivaVolumeCR.Object.VersionDetails.Status.Current,
+		obj.JivaVolumeCR.Object.Annotations)
+	if err != nil {
+		return "failed to get the version of volume" + obj.Name, err
+	}
+	if obj.VerifyOnly {
+		return "", nil
+	}
 	err = obj.getJivaControllerPatchData()
 	if err != nil {
 		return "failed to create target deploy patch for volume" + obj.Name, err
@@ -176,10 +188,7 @@ func (obj *JivaVolumePatch) transformJivaController(d *appsv1.Deployment, res *R
 	}
 	cons := len(d.Spec.Template.Spec.Containers)
 	for i := 0; i < cons; i++ {
-		url, err := getImageURL(
-			d.Spec.Template.Spec.Containers[i].Image,
-			res.BaseURL,
-		)
+		url, err := res.imageURL(d.Spec.Template.Spec.Containers[i].Image)
 		if err != nil {
 			return err
 		}
@@ -208,10 +217,7 @@ func (obj *JivaVolumePatch) transformJivaReplica(s *appsv1.StatefulSet, res *Res
 	}
 	cons := len(s.Spec.Template.Spec.Containers)
 	for i := 0; i < cons; i++ {
-		url, err := getImageURL(
-			s.Spec.Template.Spec.Containers[i].Image,
-			res.BaseURL,
-		)
+		url, err := res.imageURL(s.Spec.Template.Spec.Containers[i].Image)
 		if err != nil {
 			return err
 		}
@@ -254,6 +260,14 @@ func transformJivaService(svc *corev1.Service, res *ResourcePatch) error {
 
 // JivaVolumeUpgrade ...
 func (obj *JivaVolumePatch) JivaVolumeUpgrade() (string, error) {
+	if obj.VerifyOnly {
+		klog.Infof("skipping patch of volume %s in verify only mode", obj.Name)
+		err := obj.verifyJivaVolumeCRversionReconcile()
+		if err != nil {
+			return "failed to verify version reconcile on JivaVolumeCR", err
+		}
+		return "", nil
+	}
 	err := obj.Controller.Patch(obj.From, obj.To)
 	if err != nil {
 		return "failed to patch target deploy", err
@@ -281,13 +295,13 @@ func (obj *JivaVolumePatch) Upgrade() error {
 		obj.ResourcePatch,
 		obj.Client,
 	)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj := v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.PreUpgrade}
 	statusObj.Phase = v1Alpha1API.StepWaiting
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj.Phase = v1Alpha1API.StepErrored
@@ -296,59 +310,61 @@ func (obj *JivaVolumePatch) Upgrade() error {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newAPIError(errors.Wrap(err, msg))
 	}
 	msg, err = obj.PreUpgrade()
 	if err != nil {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newValidationError(errors.Wrap(err, msg))
 	}
 	statusObj.Phase = v1Alpha1API.StepCompleted
 	statusObj.Message = "Pre-upgrade steps were successful"
 	statusObj.Reason = ""
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 
 	statusObj = v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.ReplicaUpgrade}
 	statusObj.Phase = v1Alpha1API.StepWaiting
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj.Phase = v1Alpha1API.StepErrored
 
-	err = obj.Replicas.Patch(obj.From, obj.To)
+	if !obj.VerifyOnly {
+		err = obj.Replicas.Patch(obj.From, obj.To)
+	}
 	if err != nil {
 		statusObj.Message = "failed to patch replica sts"
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newAPIError(errors.Wrap(err, msg))
 	}
 
 	statusObj.Phase = v1Alpha1API.StepCompleted
 	statusObj.Message = "Replica upgrade was successful"
 	statusObj.Reason = ""
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj = v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.TargetUpgrade}
 	statusObj.Phase = v1Alpha1API.StepWaiting
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	statusObj.Phase = v1Alpha1API.StepErrored
@@ -357,39 +373,28 @@ func (obj *JivaVolumePatch) Upgrade() error {
 		statusObj.Message = msg
 		statusObj.Reason = err.Error()
 		obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-		if uerr != nil && isUpgradeTaskJob {
+		if uerr != nil && obj.IsUpgradeTaskJob() {
 			return uerr
 		}
-		return errors.Wrap(err, msg)
+		return newPartialFailureError(errors.Wrap(err, msg))
 	}
 	statusObj.Phase = v1Alpha1API.StepCompleted
 	statusObj.Message = "Target upgrade was successful"
 	statusObj.Reason = ""
 	obj.Utask, uerr = updateUpgradeDetailedStatus(obj.Utask, statusObj, obj.OpenebsNamespace, obj.Client)
-	if uerr != nil && isUpgradeTaskJob {
+	if uerr != nil && obj.IsUpgradeTaskJob() {
 		return uerr
 	}
 	return nil
 }
 
 func (obj *JivaVolumePatch) verifyJivaVolumeCRversionReconcile() error {
-	// get the latest cvc object
-	err := obj.JivaVolumeCR.Get(obj.Name, obj.Namespace)
-	if err != nil {
-		return err
-	}
-	// waiting for the current version to be equal to desired version
-	for obj.JivaVolumeCR.Object.VersionDetails.Status.Current != obj.To {
-		klog.Infof("Verifying the reconciliation of version for %s", obj.JivaVolumeCR.Object.Name)
-		// Sleep equal to the default sync time
-		time.Sleep(10 * time.Second)
-		err = obj.JivaVolumeCR.Get(obj.Name, obj.Namespace)
+	return obj.verifyVersionReconcile(obj.Name, func() (ReconcileStatus, error) {
+		err := obj.JivaVolumeCR.Get(obj.Name, obj.Namespace)
 		if err != nil {
-			return err
-		}
-		if obj.JivaVolumeCR.Object.VersionDetails.Status.Message != "" {
-			klog.Errorf("failed to reconcile: %s", obj.JivaVolumeCR.Object.VersionDetails.Status.Reason)
+			return ReconcileStatus{}, err
 		}
-	}
-	return nil
+		status := obj.JivaVolumeCR.Object.VersionDetails.Status
+		return ReconcileStatus{status.Current, status.Message, status.Reason, obj.JivaVolumeCR.Object}, nil
+	})
 }
@@ -0,0 +1,271 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1"
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeJivaClient is a runtime client holding a single jivavolume, the
+// version of a patched jivavolume is reconciled to its desired version
+type fakeJivaClient struct {
+	client.Client
+	jv      *jv.JivaVolume
+	patches int
+}
+
+func (c *fakeJivaClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if c.jv == nil || c.jv.Name != key.Name || c.jv.Namespace != key.Namespace {
+		return k8serrors.NewNotFound(jv.SchemeGroupVersion.WithResource("jivavolumes").GroupResource(), key.Name)
+	}
+	c.jv.DeepCopyInto(obj.(*jv.JivaVolume))
+	return nil
+}
+
+func (c *fakeJivaClient) Patch(ctx context.Context, obj client.Object, p client.Patch, opts ...client.PatchOption) error {
+	c.patches++
+	c.jv = obj.(*jv.JivaVolume).DeepCopy()
+	c.jv.VersionDetails.Status.Current = c.jv.VersionDetails.Desired
+	return nil
+}
+
+func newTestJivaVolume(name, version string) *jv.JivaVolume {
+	return &jv.JivaVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: upgradetesting.Namespace},
+		VersionDetails: jv.VersionDetails{
+			Desired: version,
+			Status:  jv.VersionStatus{Current: version},
+		},
+	}
+}
+
+func newTestJivaReplicas(name, version string) *appsv1.StatefulSet {
+	replicas := int32(1)
+	labels := map[string]string{"openebs.io/version": version}
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-jiva-rep", Namespace: upgradetesting.Namespace, Labels: labels},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"openebs.io/version": version}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "replica", Image: "openebs/jiva:" + version}},
+				},
+			},
+		},
+	}
+}
+
+// newTestJivaVolumePatch returns the patch of a jiva volume at the
+// from version, with all its resources fetched as Init would
+func newTestJivaVolumePatch(t *testing.T, name string, objs ...runtime.Object) (*JivaVolumePatch, *fakeJivaClient) {
+	t.Helper()
+	c := NewTestClient(objs...)
+	jc := &fakeJivaClient{jv: newTestJivaVolume(name, "2.12.0")}
+	obj := NewJivaVolumePatch(
+		WithJivaVolumeResorcePatch(&ResourcePatch{
+			Name:             name,
+			From:             "2.12.0",
+			To:               "3.0.0",
+			OpenebsNamespace: upgradetesting.Namespace,
+			PollInterval:     time.Millisecond,
+		}),
+		WithJivaVolumeClient(c),
+	)
+	obj.Namespace = upgradetesting.Namespace
+	obj.Controller = patch.NewDeployment(patch.WithDeploymentClient(c.KubeClientset))
+	obj.Replicas = patch.NewStatefulSet(patch.WithStatefulSetClient(c.KubeClientset))
+	obj.Service = patch.NewService(patch.WithKubeClient(c.KubeClientset))
+	obj.JivaVolumeCR = patch.NewJV(patch.WithJVClient(jc))
+	pvLabel := "openebs.io/persistent-volume=" + name
+	if err := obj.Controller.Get(pvLabel, obj.Namespace); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Replicas.Get(pvLabel, obj.Namespace); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Service.Get(pvLabel, obj.Namespace); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.JivaVolumeCR.Get(name, obj.Namespace); err != nil {
+		t.Fatal(err)
+	}
+	return obj, jc
+}
+
+func newTestJivaObjects(name, version string) []runtime.Object {
+	pvLabel := map[string]string{"openebs.io/persistent-volume": name}
+	replicas := newTestJivaReplicas(name, version)
+	replicas.Labels["openebs.io/persistent-volume"] = name
+	return []runtime.Object{
+		upgradetesting.NewTestDeployment(name+"-jiva-ctrl", version, pvLabel, "openebs/jiva:"+version),
+		replicas,
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name + "-jiva-ctrl-svc",
+				Namespace: upgradetesting.Namespace,
+				Labels:    map[string]string{"openebs.io/persistent-volume": name, "openebs.io/version": version},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "jiva-operator-0",
+				Namespace: upgradetesting.Namespace,
+				Labels: map[string]string{
+					"openebs.io/component-name": "jiva-operator",
+					"openebs.io/version":        "3.0.0",
+				},
+			},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		},
+	}
+}
+
+func TestJivaVolumePatch_transform(t *testing.T) {
+	tests := []struct {
+		name     string
+		imageTag string
+		registry string
+		want     string
+	}{
+		{
+			name: "to version",
+			want: "openebs/jiva:3.0.0",
+		},
+		{
+			name:     "image tag",
+			imageTag: "3.0.0-custom",
+			want:     "openebs/jiva:3.0.0-custom",
+		},
+		{
+			name:     "image registry",
+			registry: "registry.example.com",
+			want:     "registry.example.com/openebs/jiva:3.0.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, _ := newTestJivaVolumePatch(t, "pvc-1", newTestJivaObjects("pvc-1", "2.12.0")...)
+			obj.ImageTag = tt.imageTag
+			obj.ImageRegistry = tt.registry
+
+			deploy := obj.Controller.Object.DeepCopy()
+			if err := obj.transformJivaController(deploy, obj.ResourcePatch); err != nil {
+				t.Fatalf("transformJivaController() error = %v", err)
+			}
+			if got := deploy.Spec.Template.Spec.Containers[0].Image; got != tt.want {
+				t.Errorf("transformJivaController() image = %s, want %s", got, tt.want)
+			}
+			if deploy.Labels["openebs.io/version"] != "3.0.0" || deploy.Spec.Template.Labels["openebs.io/version"] != "3.0.0" {
+				t.Errorf("transformJivaController() did not set the version labels")
+			}
+			sts := obj.Replicas.Object.DeepCopy()
+			if err := obj.transformJivaReplica(sts, obj.ResourcePatch); err != nil {
+				t.Fatalf("transformJivaReplica() error = %v", err)
+			}
+			if got := sts.Spec.Template.Spec.Containers[0].Image; got != tt.want {
+				t.Errorf("transformJivaReplica() image = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJivaVolumePatch_JivaVolumeUpgrade(t *testing.T) {
+	obj, jc := newTestJivaVolumePatch(t, "pvc-1", newTestJivaObjects("pvc-1", "2.12.0")...)
+	if msg, err := obj.PreUpgrade(); err != nil {
+		t.Fatalf("PreUpgrade() error = %v, %s", err, msg)
+	}
+	for name, get := range map[string]func() error{
+		"controller": obj.getJivaControllerPatchData,
+		"replica":    obj.getJivaReplicaPatchData,
+		"jivavolume": obj.getJVPatchData,
+		"service":    func() error { return getJivaServicePatchData(obj) },
+	} {
+		if err := get(); err != nil {
+			t.Fatalf("failed to get the %s patch: %v", name, err)
+		}
+	}
+	if obj.JivaVolumeCR.NewObject.VersionDetails.Desired != "3.0.0" {
+		t.Errorf("getJVPatchData() desired = %s, want 3.0.0", obj.JivaVolumeCR.NewObject.VersionDetails.Desired)
+	}
+	if msg, err := obj.JivaVolumeUpgrade(); err != nil {
+		t.Fatalf("JivaVolumeUpgrade() error = %v, %s", err, msg)
+	}
+	if jc.patches != 1 || jc.jv.VersionDetails.Status.Current != "3.0.0" {
+		t.Errorf("JivaVolumeUpgrade() patched the jivavolume %d times to %s, want once to 3.0.0",
+			jc.patches, jc.jv.VersionDetails.Status.Current)
+	}
+	svc, err := obj.KubeClientset.CoreV1().Services(upgradetesting.Namespace).
+		Get(context.TODO(), "pvc-1-jiva-ctrl-svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Labels["openebs.io/version"] != "3.0.0" {
+		t.Errorf("JivaVolumeUpgrade() service version = %s, want 3.0.0", svc.Labels["openebs.io/version"])
+	}
+
+	// in verify only mode nothing is patched
+	obj.VerifyOnly = true
+	if msg, err := obj.JivaVolumeUpgrade(); err != nil {
+		t.Fatalf("JivaVolumeUpgrade() in verify only mode error = %v, %s", err, msg)
+	}
+	if jc.patches != 1 {
+		t.Errorf("JivaVolumeUpgrade() in verify only mode patched the jivavolume")
+	}
+}
+
+func TestJivaVolumePatch_PreUpgrade(t *testing.T) {
+	obj, _ := newTestJivaVolumePatch(t, "pvc-1", newTestJivaObjects("pvc-1", "2.12.0")...)
+	obj.To = "3.1.0"
+	if _, err := obj.PreUpgrade(); !errors.Is(err, ErrOperatorNotUpgraded) {
+		t.Errorf("PreUpgrade() with an older operator error = %v, want %v", err, ErrOperatorNotUpgraded)
+	}
+}
+
+func TestJivaVolumePatch_Upgrade(t *testing.T) {
+	obj := NewJivaVolumePatch(
+		WithJivaVolumeResorcePatch(&ResourcePatch{
+			Name:             "pvc-1",
+			From:             "2.12.0",
+			To:               "3.0.0",
+			OpenebsNamespace: upgradetesting.Namespace,
+		}),
+		WithJivaVolumeClient(NewTestClient()),
+	)
+	if err := obj.Upgrade(); !errors.Is(err, ErrAPI) {
+		t.Errorf("Upgrade() of a missing volume error = %v, want %v", err, ErrAPI)
+	}
+	if obj.Utask == nil || len(obj.Utask.Status.UpgradeDetailedStatuses) == 0 {
+		t.Errorf("Upgrade() did not record the failed pre upgrade step")
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/openebs/upgrade/pkg/version"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// kubernetesCompatibilityYAML is the minimum kubernetes version of the
+// openebs versions. An openebs version requires the minimum of the latest
+// entry which is not newer than its major and minor version.
+const kubernetesCompatibilityYAML = `
+compatibility:
+- openebs: "2.0"
+  minKubernetes: "1.14"
+- openebs: "2.6"
+  minKubernetes: "1.17"
+- openebs: "3.0"
+  minKubernetes: "1.18"
+- openebs: "3.3"
+  minKubernetes: "1.20"
+- openebs: "3.5"
+  minKubernetes: "1.22"
+`
+
+// kubernetesCompatibility is an entry of the compatibility table
+type kubernetesCompatibility struct {
+	OpenEBS       string `json:"openebs"`
+	MinKubernetes string `json:"minKubernetes"`
+}
+
+// loadKubernetesCompatibility parses the compatibility table
+func loadKubernetesCompatibility(data string) ([]kubernetesCompatibility, error) {
+	doc := struct {
+		Compatibility []kubernetesCompatibility `json:"compatibility"`
+	}{}
+	err := yaml.Unmarshal([]byte(data), &doc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the kubernetes compatibility table")
+	}
+	return doc.Compatibility, nil
+}
+
+// parseMinorVersion parses the major, minor and optional patch of a
+// version like 1.22, v1.22.3 or 3.5.0-RC1, the pre-release is ignored
+func parseMinorVersion(v string) ([3]int, error) {
+	parsed := [3]int{}
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return parsed, errors.Errorf("invalid version %q, expected <major>.<minor>[.<patch>]", v)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return parsed, errors.Errorf("invalid version %q, expected <major>.<minor>[.<patch>]", v)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// compareMinorVersions returns -1, 0 or 1 if a is older, equal or
+// newer than b
+func compareMinorVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// minKubernetesVersion returns the minimum kubernetes version of the
+// openebs version as per the compatibility table, empty if it has none
+func minKubernetesVersion(table []kubernetesCompatibility, openebs string) (string, error) {
+	v, err := parseMinorVersion(openebs)
+	if err != nil {
+		return "", err
+	}
+	// the patch versions share the requirement of their minor version
+	v[2] = 0
+	min, latest := "", [3]int{-1}
+	for _, c := range table {
+		cv, err := parseMinorVersion(c.OpenEBS)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid kubernetes compatibility of openebs %s", c.OpenEBS)
+		}
+		if compareMinorVersions(cv, v) <= 0 && compareMinorVersions(cv, latest) > 0 {
+			min, latest = c.MinKubernetes, cv
+		}
+	}
+	return min, nil
+}
+
+// serverKubernetesVersion returns the major, minor and patch version of
+// the kubernetes cluster. The managed clusters report minor versions like
+// 25+ and git versions like v1.25.3-eks-1, so the major and minor are read
+// from their fields and only the patch from the git version.
+func serverKubernetesVersion(client kubernetes.Interface) ([3]int, error) {
+	info, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return [3]int{}, newAPIError(errors.Wrap(err, "failed to get the kubernetes version"))
+	}
+	v, err := parseMinorVersion(strings.TrimRight(info.Major, "+") + "." + strings.TrimRight(info.Minor, "+"))
+	if err != nil {
+		return v, errors.Wrapf(err, "unsupported kubernetes version %s.%s", info.Major, info.Minor)
+	}
+	if git, err := parseMinorVersion(info.GitVersion); err == nil && git[0] == v[0] && git[1] == v[1] {
+		v[2] = git[2]
+	}
+	return v, nil
+}
+
+func formatMinorVersion(v [3]int) string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// isKubernetesVersionAtLeast returns the version of the kubernetes
+// cluster and whether it is not older than the minVersion
+func isKubernetesVersionAtLeast(client kubernetes.Interface, minVersion string) (string, bool, error) {
+	min, err := parseMinorVersion(minVersion)
+	if err != nil {
+		return "", false, newValidationError(errors.Wrapf(err, "invalid minimum kubernetes version"))
+	}
+	cluster, err := serverKubernetesVersion(client)
+	if err != nil {
+		return "", false, err
+	}
+	return formatMinorVersion(cluster), compareMinorVersions(cluster, min) >= 0, nil
+}
+
+// CheckKubernetesVersion returns a validation error if the version of the
+// kubernetes cluster is older than the minVersion, like 1.22 or 1.22.3
+func CheckKubernetesVersion(client kubernetes.Interface, minVersion string) error {
+	cluster, ok, err := isKubernetesVersionAtLeast(client, minVersion)
+	if err != nil || ok {
+		return err
+	}
+	return newValidationError(errors.Errorf(
+		"Kubernetes >= %s is required, cluster is at %s", minVersion, cluster,
+	))
+}
+
+// CheckKubernetesCompatibility returns a validation error if the kubernetes
+// cluster is older than the minimum kubernetes version of the to version
+// as per the compatibility table. The check is skipped for the custom
+// versions and with SkipKubernetesVersionCheck.
+func CheckKubernetesCompatibility(r *ResourcePatch, client *Client) error {
+	if r.SkipKubernetesVersionCheck {
+		return nil
+	}
+	to, err := version.ParseVersionFlexible(r.To)
+	if err != nil || to.Kind != version.KindSemver {
+		klog.Infof("skipping the kubernetes version check of the custom version %s", r.To)
+		return nil
+	}
+	table, err := loadKubernetesCompatibility(kubernetesCompatibilityYAML)
+	if err != nil {
+		return err
+	}
+	minVersion, err := minKubernetesVersion(table, r.To)
+	if err != nil || minVersion == "" {
+		return err
+	}
+	cluster, ok, err := isKubernetesVersionAtLeast(client.KubeClientset, minVersion)
+	if err != nil || ok {
+		return err
+	}
+	return newValidationError(errors.Errorf(
+		"OpenEBS %d.%d requires Kubernetes >= %s, cluster is at %s",
+		to.Major, to.Minor, minVersion, cluster,
+	))
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+)
+
+func testKubernetesVersionClient(major, minor, gitVersion string) *Client {
+	client := NewTestClient()
+	client.KubeClientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion =
+		&version.Info{Major: major, Minor: minor, GitVersion: gitVersion}
+	return client
+}
+
+func TestCheckKubernetesVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		major      string
+		minor      string
+		gitVersion string
+		minVersion string
+		wantErr    error
+	}{
+		{name: "newer", major: "1", minor: "23", gitVersion: "v1.23.1", minVersion: "1.22"},
+		{name: "equal", major: "1", minor: "22", gitVersion: "v1.22.0", minVersion: "1.22"},
+		{name: "managed cluster", major: "1", minor: "22+", gitVersion: "v1.22.3-eks-1", minVersion: "1.22.3"},
+		{name: "older", major: "1", minor: "20", gitVersion: "v1.20.4", minVersion: "1.22", wantErr: ErrValidation},
+		{name: "older patch", major: "1", minor: "22", gitVersion: "v1.22.2", minVersion: "1.22.3", wantErr: ErrValidation},
+		{name: "invalid minimum", major: "1", minor: "22", minVersion: "latest", wantErr: ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testKubernetesVersionClient(tt.major, tt.minor, tt.gitVersion)
+			err := CheckKubernetesVersion(client.KubeClientset, tt.minVersion)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckKubernetesVersion() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// a version which can not be parsed is not a validation error
+	client := testKubernetesVersionClient("", "", "v0.0.0-master")
+	err := CheckKubernetesVersion(client.KubeClientset, "1.22")
+	if err == nil || errors.Is(err, ErrValidation) {
+		t.Errorf("CheckKubernetesVersion() of an unknown version error = %v, want a non validation error", err)
+	}
+}
+
+func Test_minKubernetesVersion(t *testing.T) {
+	table, err := loadKubernetesCompatibility(kubernetesCompatibilityYAML)
+	if err != nil {
+		t.Fatalf("loadKubernetesCompatibility() error = %v", err)
+	}
+	tests := map[string]string{
+		"1.12.0":    "",
+		"2.5.0":     "1.14",
+		"2.12.0":    "1.17",
+		"3.0.0-RC1": "1.18",
+		"3.2.0":     "1.18",
+		"v3.3.0":    "1.20",
+		"3.4.1":     "1.20",
+		"3.5.0":     "1.22",
+		"3.10.0":    "1.22",
+		"4.0.0":     "1.22",
+	}
+	for to, want := range tests {
+		got, err := minKubernetesVersion(table, to)
+		if err != nil {
+			t.Errorf("minKubernetesVersion(%s) error = %v", to, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("minKubernetesVersion(%s) = %q, want %q", to, got, want)
+		}
+	}
+}
+
+func TestCheckKubernetesCompatibility(t *testing.T) {
+	client := testKubernetesVersionClient("1", "20", "v1.20.4")
+	err := CheckKubernetesCompatibility(NewResourcePatch(ToVersion("3.5.0")), client)
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("CheckKubernetesCompatibility() error = %v, want a validation error", err)
+	}
+	want := "OpenEBS 3.5 requires Kubernetes >= 1.22, cluster is at 1.20.4"
+	if err.Error() != want {
+		t.Errorf("CheckKubernetesCompatibility() error = %q, want %q", err, want)
+	}
+	for _, r := range []*ResourcePatch{
+		NewResourcePatch(ToVersion("3.4.0")),
+		NewResourcePatch(ToVersion("3.5.0"), WithSkipKubernetesVersionCheck(true)),
+		NewResourcePatch(ToVersion("dev-main-abc1234")),
+	} {
+		if err := CheckKubernetesCompatibility(r, client); err != nil {
+			t.Errorf("CheckKubernetesCompatibility(%s) error = %v", r.To, err)
+		}
+	}
+}
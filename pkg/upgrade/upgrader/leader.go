@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultLeaseName is the name of the lease the
+	// replicas of the controller elect the leader with
+	DefaultLeaseName = "openebs-upgrade-controller"
+
+	// DefaultLeaseDuration, DefaultRenewDeadline and DefaultRetryPeriod
+	// are the durations of the leader election, same as the defaults
+	// of the kubernetes controller manager
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// LeaderElectionConfig is the lease the replicas of the controller elect
+// the leader with, and the durations the lease is held and renewed with
+type LeaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	// LeaseDuration is the time the standby replicas wait before
+	// taking over the lease of a leader which stopped renewing it
+	LeaseDuration time.Duration
+	// RenewDeadline is the time the leader retries renewing
+	// the lease for before it gives up the leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval the lease is acquired or renewed at
+	RetryPeriod time.Duration
+	// Identity is the holder of the lease, it defaults to
+	// the pod name or else the hostname and the process id
+	Identity string
+}
+
+// leaderIdentity returns the identity the lease is held with,
+// the pod name if set or else the hostname and the process id
+func leaderIdentity() string {
+	if podName := os.Getenv("POD_NAME"); podName != "" {
+		return podName
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s_%d", hostname, os.Getpid())
+}
+
+// RunAsLeader blocks until the lease is acquired and then calls run
+// with a context which is done once the leadership is lost. The lease
+// is released once the given context is done. It returns an error if
+// the leadership is lost before the given context is done, so that the
+// replica restarts on standby instead of running without the lease.
+func RunAsLeader(ctx context.Context, client kubernetes.Interface,
+	cfg LeaderElectionConfig, run func(context.Context)) error {
+	identity := cfg.Identity
+	if identity == "" {
+		identity = leaderIdentity()
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      cfg.LeaseName,
+			Namespace: cfg.LeaseNamespace,
+		},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				klog.Infof("%s stopped leading with lease %s/%s", identity, cfg.LeaseNamespace, cfg.LeaseName)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					klog.Infof("%s is the leader, waiting on standby", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return newValidationError(errors.Wrapf(err, "invalid leader election config"))
+	}
+	klog.Infof("%s waiting to acquire the lease %s/%s", identity, cfg.LeaseNamespace, cfg.LeaseName)
+	elector.Run(ctx)
+	if ctx.Err() == nil {
+		return errors.Errorf("lost the lease %s/%s", cfg.LeaseNamespace, cfg.LeaseName)
+	}
+	return nil
+}
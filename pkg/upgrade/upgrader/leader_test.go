@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testLeaderElectionConfig(identity string) LeaderElectionConfig {
+	return LeaderElectionConfig{
+		LeaseName:      DefaultLeaseName,
+		LeaseNamespace: upgradetesting.Namespace,
+		LeaseDuration:  time.Second,
+		RenewDeadline:  500 * time.Millisecond,
+		RetryPeriod:    50 * time.Millisecond,
+		Identity:       identity,
+	}
+}
+
+func TestRunAsLeader(t *testing.T) {
+	client := NewTestClient().KubeClientset
+	leading := make(chan string, 2)
+	run := func(identity string) func(context.Context) {
+		return func(ctx context.Context) {
+			leading <- identity
+			<-ctx.Done()
+		}
+	}
+
+	ctxA, cancelA := context.WithCancel(context.TODO())
+	doneA := make(chan error)
+	go func() {
+		doneA <- RunAsLeader(ctxA, client, testLeaderElectionConfig("replica-a"), run("replica-a"))
+	}()
+	select {
+	case got := <-leading:
+		if got != "replica-a" {
+			t.Fatalf("leader = %s, want replica-a", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("replica-a did not acquire the lease")
+	}
+
+	ctxB, cancelB := context.WithCancel(context.TODO())
+	defer cancelB()
+	doneB := make(chan error)
+	go func() {
+		doneB <- RunAsLeader(ctxB, client, testLeaderElectionConfig("replica-b"), run("replica-b"))
+	}()
+	select {
+	case got := <-leading:
+		t.Fatalf("%s is leading while replica-a holds the lease", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// the lease is released once the leader is stopped
+	cancelA()
+	if err := <-doneA; err != nil {
+		t.Fatalf("RunAsLeader() error = %v after the context is done", err)
+	}
+	select {
+	case got := <-leading:
+		if got != "replica-b" {
+			t.Fatalf("leader = %s, want replica-b", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("replica-b did not take over the released lease")
+	}
+	leaseObj, err := client.CoordinationV1().Leases(upgradetesting.Namespace).
+		Get(context.TODO(), DefaultLeaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if holder := *leaseObj.Spec.HolderIdentity; holder != "replica-b" {
+		t.Errorf("lease holder = %s, want replica-b", holder)
+	}
+	cancelB()
+	if err := <-doneB; err != nil {
+		t.Errorf("RunAsLeader() error = %v after the context is done", err)
+	}
+}
+
+func TestRunAsLeader_invalidConfig(t *testing.T) {
+	cfg := testLeaderElectionConfig("replica-a")
+	cfg.RenewDeadline = 2 * cfg.LeaseDuration
+	err := RunAsLeader(context.TODO(), NewTestClient().KubeClientset, cfg, func(context.Context) {
+		t.Errorf("run called with an invalid leader election config")
+	})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("RunAsLeader() error = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/upgrade/pkg/version"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// VolumeLineage is the parent volume and snapshot a cstor volume is
+// cloned from, if any, along with its own snapshots and clones
+type VolumeLineage struct {
+	Volume string
+	// Parent and Snapshot are the volume and snapshot
+	// the volume is cloned from, empty if it is not a clone
+	Parent   string
+	Snapshot string
+	// Snapshots are the snapshots reported by the cvrs of the volume
+	Snapshots []string
+	// Clones are the volumes cloned from the snapshots of the volume
+	Clones []string
+}
+
+// String returns the lineage as logged after the upgrade of the volume
+func (l *VolumeLineage) String() string {
+	parent := "-"
+	if l.Parent != "" {
+		parent = l.Parent + "@" + l.Snapshot
+	}
+	return fmt.Sprintf("volume %s: parent %s, snapshots [%s], clones [%s]",
+		l.Volume, parent, strings.Join(l.Snapshots, ", "), strings.Join(l.Clones, ", "))
+}
+
+// cloneSource returns the volume and snapshot a cvc is cloned from,
+// which are empty if the cvc is not a clone
+func cloneSource(cvcObj *cstor.CStorVolumeConfig) (string, string) {
+	source := cvcObj.Spec.CStorVolumeSource
+	if source == "" {
+		return "", ""
+	}
+	i := strings.Index(source, "@")
+	if i < 0 {
+		return source, ""
+	}
+	return source[:i], source[i+1:]
+}
+
+// GetVolumeLineage returns the lineage of the cstor volume from the cvcs
+// and the cvrs in the namespace
+func GetVolumeLineage(name, namespace string, client *Client) (*VolumeLineage, error) {
+	c := client.OpenebsClientset.CstorV1()
+	cvcList, err := c.CStorVolumeConfigs(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list cvcs")
+	}
+	l := &VolumeLineage{Volume: name, Snapshots: []string{}, Clones: []string{}}
+	for i := range cvcList.Items {
+		parent, snapshot := cloneSource(&cvcList.Items[i])
+		switch {
+		case cvcList.Items[i].Name == name:
+			l.Parent, l.Snapshot = parent, snapshot
+		case parent == name:
+			l.Clones = append(l.Clones, cvcList.Items[i].Name)
+		}
+	}
+	cvrList, err := c.CStorVolumeReplicas(namespace).List(context.TODO(),
+		metav1.ListOptions{LabelSelector: "openebs.io/persistent-volume=" + name})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list cvrs of volume %s", name)
+	}
+	snapshots := map[string]bool{}
+	for _, cvrObj := range cvrList.Items {
+		for snap := range cvrObj.Status.Snapshots {
+			snapshots[snap] = true
+		}
+	}
+	for snap := range snapshots {
+		l.Snapshots = append(l.Snapshots, snap)
+	}
+	sort.Strings(l.Snapshots)
+	sort.Strings(l.Clones)
+	return l, nil
+}
+
+// SortVolumesByLineage returns the volumes ordered so that the parent of
+// a clone is upgraded before the clone if both are in the volumes, the
+// order of the volumes is kept otherwise
+func SortVolumesByLineage(names []string, namespace string, client *Client) ([]string, error) {
+	cvcList, err := client.OpenebsClientset.CstorV1().CStorVolumeConfigs(namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list cvcs")
+	}
+	parents := map[string]string{}
+	for i := range cvcList.Items {
+		parent, _ := cloneSource(&cvcList.Items[i])
+		parents[cvcList.Items[i].Name] = parent
+	}
+	remaining := map[string]bool{}
+	for _, name := range names {
+		remaining[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for len(sorted) < len(names) {
+		added := false
+		for _, name := range names {
+			if remaining[name] && !remaining[parents[name]] {
+				sorted = append(sorted, name)
+				delete(remaining, name)
+				added = true
+			}
+		}
+		// the sources of the cvcs can not form a cycle, but the
+		// remaining volumes are kept in order rather than dropped
+		if !added {
+			for _, name := range names {
+				if remaining[name] {
+					sorted = append(sorted, name)
+					delete(remaining, name)
+				}
+			}
+		}
+	}
+	return sorted, nil
+}
+
+// volumeVersion returns the current version of the cvc of the volume
+func (obj *CStorVolumePatch) volumeVersion(name string) (string, error) {
+	cvcObj, err := obj.OpenebsClientset.CstorV1().CStorVolumeConfigs(obj.Namespace).
+		Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get cvc %s", name)
+	}
+	return cvcObj.VersionDetails.Status.Current, nil
+}
+
+// checkParentVersion logs a warning if the volume is a clone whose
+// parent is not yet upgraded, as the clone is then ahead of its parent
+func (obj *CStorVolumePatch) checkParentVersion() {
+	parent, _ := cloneSource(obj.CVC.Object)
+	if parent == "" {
+		return
+	}
+	toVersion, err := version.ParseVersionFlexible(obj.To)
+	if err != nil {
+		return
+	}
+	current, err := obj.volumeVersion(parent)
+	if err != nil {
+		klog.Warningf("failed to get the version of volume %s, the parent of %s: %v", parent, obj.Name, err)
+		return
+	}
+	if isVersionPending(current, toVersion) {
+		klog.Warningf("volume %s is a clone of volume %s which is still at %s, upgrade %s to %s as well",
+			obj.Name, parent, current, parent, obj.To)
+	}
+}
+
+// upgradeClones logs the lineage of the volume and upgrades the clones
+// of the volume which are older than the to version if UpgradeClones is
+// set, or else logs a warning for each of them
+func (obj *CStorVolumePatch) upgradeClones() error {
+	l, err := GetVolumeLineage(obj.Name, obj.Namespace, obj.Client)
+	if err != nil {
+		return err
+	}
+	klog.Infof("%s", l)
+	toVersion, err := version.ParseVersionFlexible(obj.To)
+	if err != nil {
+		return newValidationError(err)
+	}
+	for _, clone := range l.Clones {
+		current, err := obj.volumeVersion(clone)
+		if err != nil {
+			return err
+		}
+		if !isVersionPending(current, toVersion) {
+			continue
+		}
+		if !obj.UpgradeClones {
+			klog.Warningf("clone %s of volume %s is still at %s, upgrade it to %s as well",
+				clone, obj.Name, current, obj.To)
+			continue
+		}
+		klog.Infof("Upgrading clone %s of volume %s to %s", clone, obj.Name, obj.To)
+		res := *obj.ResourcePatch
+		res.Name = clone
+		err = NewCStorVolumePatch(
+			WithCStorVolumeResorcePatch(&res),
+			WithCStorVolumeClient(obj.Client),
+		).Upgrade()
+		if err != nil {
+			return errors.Wrapf(err, "failed to upgrade clone %s", clone)
+		}
+	}
+	return nil
+}
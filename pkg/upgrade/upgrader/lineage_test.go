@@ -0,0 +1,130 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"reflect"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testCloneCVC(name, source, current string) *cstor.CStorVolumeConfig {
+	cvcObj := testCVC(name, cstor.CStorVolumeConfigPhaseBound)
+	cvcObj.Spec.CStorVolumeSource = source
+	cvcObj.VersionDetails.Status.Current = current
+	return cvcObj
+}
+
+func testSnapshotCVR(name, volume string, snapshots ...string) *cstor.CStorVolumeReplica {
+	cvrObj := &cstor.CStorVolumeReplica{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: upgradetesting.Namespace,
+			Labels:    map[string]string{"openebs.io/persistent-volume": volume},
+		},
+	}
+	cvrObj.Status.Snapshots = map[string]cstor.CStorSnapshotInfo{}
+	for _, snap := range snapshots {
+		cvrObj.Status.Snapshots[snap] = cstor.CStorSnapshotInfo{}
+	}
+	return cvrObj
+}
+
+func TestGetVolumeLineage(t *testing.T) {
+	client := NewTestClient(
+		testCloneCVC("pvc-1", "", "2.7.0"),
+		testCloneCVC("pvc-2", "pvc-1@snap-1", "2.7.0"),
+		testCloneCVC("pvc-3", "pvc-2@snap-2", "2.7.0"),
+		testCloneCVC("pvc-4", "pvc-1@snap-1", "2.7.0"),
+		testSnapshotCVR("pvc-2-cstor-pool-a", "pvc-2", "snap-2", "snap-3"),
+		testSnapshotCVR("pvc-2-cstor-pool-b", "pvc-2", "snap-2"),
+		testSnapshotCVR("pvc-1-cstor-pool-a", "pvc-1", "snap-1"),
+	)
+	tests := map[string]*VolumeLineage{
+		"pvc-1": {Volume: "pvc-1", Snapshots: []string{"snap-1"}, Clones: []string{"pvc-2", "pvc-4"}},
+		"pvc-2": {Volume: "pvc-2", Parent: "pvc-1", Snapshot: "snap-1",
+			Snapshots: []string{"snap-2", "snap-3"}, Clones: []string{"pvc-3"}},
+		"pvc-3": {Volume: "pvc-3", Parent: "pvc-2", Snapshot: "snap-2",
+			Snapshots: []string{}, Clones: []string{}},
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := GetVolumeLineage(name, upgradetesting.Namespace, client)
+			if err != nil {
+				t.Fatalf("GetVolumeLineage() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("GetVolumeLineage() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSortVolumesByLineage(t *testing.T) {
+	client := NewTestClient(
+		testCloneCVC("pvc-1", "", ""),
+		testCloneCVC("pvc-2", "pvc-1@snap-1", ""),
+		testCloneCVC("pvc-3", "pvc-2@snap-2", ""),
+		testCloneCVC("pvc-4", "", ""),
+	)
+	tests := []struct {
+		names, want []string
+	}{
+		{[]string{"pvc-3", "pvc-2", "pvc-1"}, []string{"pvc-1", "pvc-2", "pvc-3"}},
+		{[]string{"pvc-3", "pvc-4", "pvc-1"}, []string{"pvc-3", "pvc-4", "pvc-1"}},
+		{[]string{"pvc-2", "pvc-4", "pvc-1"}, []string{"pvc-4", "pvc-1", "pvc-2"}},
+		{[]string{"pvc-5", "pvc-2"}, []string{"pvc-5", "pvc-2"}},
+	}
+	for _, tt := range tests {
+		got, err := SortVolumesByLineage(tt.names, upgradetesting.Namespace, client)
+		if err != nil {
+			t.Fatalf("SortVolumesByLineage(%v) error = %v", tt.names, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SortVolumesByLineage(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestCStorVolumePatch_upgradeClones(t *testing.T) {
+	client := NewTestClient(
+		testCloneCVC("pvc-1", "", "3.0.0"),
+		testCloneCVC("pvc-2", "pvc-1@snap-1", "3.0.0"),
+		testCloneCVC("pvc-3", "pvc-1@snap-1", "2.12.0"),
+	)
+	obj := NewCStorVolumePatch(
+		WithCStorVolumeResorcePatch(NewResourcePatch(
+			WithName("pvc-1"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			ToVersion("3.0.0"),
+		)),
+		WithCStorVolumeClient(client),
+	)
+	obj.Namespace = upgradetesting.Namespace
+	// the clones left behind are only logged without UpgradeClones
+	if err := obj.upgradeClones(); err != nil {
+		t.Errorf("upgradeClones() error = %v", err)
+	}
+	// the clone can not be upgraded as it has no cv
+	obj.UpgradeClones = true
+	if err := obj.upgradeClones(); err == nil {
+		t.Errorf("upgradeClones() of a clone without a cv error = nil, want an error")
+	}
+}
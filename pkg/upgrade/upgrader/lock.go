@@ -0,0 +1,223 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// defaultLockTimeout is the time after which the lock of a resource
+	// expires if it is not renewed, so that the lock left by a crashed
+	// upgrade does not block the later upgrades for long
+	defaultLockTimeout = time.Hour
+
+	// lockPrefix is the prefix of the names of the leases locking the resources
+	lockPrefix = "openebs-upgrade-lock-"
+)
+
+func (c *Client) getLockTimeout() time.Duration {
+	if c.lockTimeout <= 0 {
+		return defaultLockTimeout
+	}
+	return c.lockTimeout
+}
+
+// lockName returns the name of the lease locking the resource,
+// the kind is lower cased as the names of leases are lower case
+func lockName(kind, name string) string {
+	return lockPrefix + strings.ToLower(kind) + "-" + name
+}
+
+// lockHolder returns the identity the locks are held with. The pods of
+// an upgrade job hold the locks as their job, so that a pod restarted
+// by the job after a crash takes over the locks of the crashed pod.
+func lockHolder(namespace string, client *Client) string {
+	podName := os.Getenv("POD_NAME")
+	if podName == "" {
+		hostname, _ := os.Hostname()
+		return fmt.Sprintf("%s_%d", hostname, os.Getpid())
+	}
+	podObj, err := client.KubeClientset.CoreV1().Pods(namespace).
+		Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("failed to get the job of pod %s, holding the locks as the pod: %v", podName, err)
+		return "pod/" + podName
+	}
+	for _, ref := range podObj.OwnerReferences {
+		if ref.Kind == "Job" {
+			return "job/" + ref.Name
+		}
+	}
+	return "pod/" + podName
+}
+
+// isLeaseExpired returns true if the lease was not renewed within its duration
+func isLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return lease.Spec.RenewTime.Add(duration).Before(now)
+}
+
+// AcquireResourceLock locks the resource against concurrent upgrades by
+// creating or taking over the lease openebs-upgrade-lock-<kind>-<name> in
+// the namespace, and returns the function releasing it. The lock fails
+// with a validation error if the lease is held by another upgrade and has
+// not expired. The lease is renewed until it is released, and expires
+// after the lock timeout of the client if the upgrade holding it crashes.
+func AcquireResourceLock(ctx context.Context, resourceKind, resourceName, namespace string,
+	client *Client) (func(), error) {
+	timeout := client.getLockTimeout()
+	name := lockName(resourceKind, resourceName)
+	holder := lockHolder(namespace, client)
+	leaseClient := client.KubeClientset.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(timeout / time.Second)
+	spec := coordinationv1.LeaseSpec{
+		HolderIdentity:       &holder,
+		LeaseDurationSeconds: &seconds,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+	leaseObj, err := leaseClient.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case k8serror.IsNotFound(err):
+		_, err = leaseClient.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       spec,
+		}, metav1.CreateOptions{})
+	case err != nil:
+		return nil, newAPIError(errors.Wrapf(err, "failed to get lock %s", name))
+	default:
+		current := ""
+		if leaseObj.Spec.HolderIdentity != nil {
+			current = *leaseObj.Spec.HolderIdentity
+		}
+		if current != "" && current != holder && !isLeaseExpired(leaseObj, now.Time) {
+			return nil, newValidationError(errors.Errorf(
+				"%s %s is being upgraded by %s, its lock %s expires at %s if not renewed",
+				resourceKind, resourceName, current, name,
+				leaseObj.Spec.RenewTime.Add(time.Duration(*leaseObj.Spec.LeaseDurationSeconds)*time.Second).
+					UTC().Format(time.RFC3339),
+			))
+		}
+		if current != "" && current != holder {
+			klog.Infof("%s %s: taking over the expired lock %s of %s", resourceKind, resourceName, name, current)
+		}
+		leaseObj.Spec = spec
+		_, err = leaseClient.Update(ctx, leaseObj, metav1.UpdateOptions{})
+	}
+	// the lease was created or updated by another upgrade since it was read
+	if k8serror.IsAlreadyExists(err) || k8serror.IsConflict(err) {
+		return nil, newValidationError(errors.Errorf(
+			"%s %s is being upgraded by another upgrade, failed to acquire its lock %s",
+			resourceKind, resourceName, name,
+		))
+	}
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to acquire lock %s", name))
+	}
+	klog.Infof("%s %s: acquired the lock %s as %s", resourceKind, resourceName, name, holder)
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				renewResourceLock(name, namespace, holder, client)
+			}
+		}
+	}()
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(stop)
+			releaseResourceLock(name, namespace, holder, client)
+		})
+	}
+	return release, nil
+}
+
+// renewResourceLock extends the lease of the lock if it is still held by
+// the holder, a failure is only logged as it is retried on the next renewal
+func renewResourceLock(name, namespace, holder string, client *Client) {
+	leaseClient := client.KubeClientset.CoordinationV1().Leases(namespace)
+	leaseObj, err := leaseClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("failed to renew lock %s: %v", name, err)
+		return
+	}
+	if leaseObj.Spec.HolderIdentity == nil || *leaseObj.Spec.HolderIdentity != holder {
+		klog.Warningf("failed to renew lock %s: it is no longer held by %s", name, holder)
+		return
+	}
+	now := metav1.NewMicroTime(time.Now())
+	leaseObj.Spec.RenewTime = &now
+	_, err = leaseClient.Update(context.TODO(), leaseObj, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Warningf("failed to renew lock %s: %v", name, err)
+	}
+}
+
+// releaseResourceLock deletes the lease of the lock if it is still held
+// by the holder, a failure is only logged as the lease expires anyway
+func releaseResourceLock(name, namespace, holder string, client *Client) {
+	leaseClient := client.KubeClientset.CoordinationV1().Leases(namespace)
+	leaseObj, err := leaseClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		klog.Warningf("failed to release lock %s: %v", name, err)
+		return
+	}
+	if leaseObj.Spec.HolderIdentity == nil || *leaseObj.Spec.HolderIdentity != holder {
+		return
+	}
+	err = leaseClient.Delete(context.TODO(), name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &leaseObj.ResourceVersion},
+	})
+	if err != nil && !k8serror.IsNotFound(err) {
+		klog.Warningf("failed to release lock %s: %v", name, err)
+	}
+}
+
+// lockResource acquires the lock of the resource being upgraded, the
+// resource is not locked in the verify only mode as nothing is patched
+func lockResource(kind string, r *ResourcePatch, client *Client) (func(), error) {
+	if r.VerifyOnly {
+		return func() {}, nil
+	}
+	return AcquireResourceLock(context.TODO(), kind, r.Name, r.OpenebsNamespace, client)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testLease(holder string, renewed time.Time) *coordinationv1.Lease {
+	seconds := int32(60)
+	renewTime := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "openebs-upgrade-lock-cstorpoolcluster-cspc-a",
+			Namespace: "openebs",
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &seconds,
+			RenewTime:            &renewTime,
+		},
+	}
+}
+
+func TestAcquireResourceLock(t *testing.T) {
+	tests := []struct {
+		name     string
+		objs     []runtime.Object
+		wantKind error
+	}{
+		{name: "no lease"},
+		{
+			name:     "lease held by another upgrade",
+			objs:     []runtime.Object{testLease("job/other", time.Now())},
+			wantKind: ErrValidation,
+		},
+		{
+			name: "expired lease",
+			objs: []runtime.Object{testLease("job/other", time.Now().Add(-2*time.Minute))},
+		},
+		{
+			name: "released lease",
+			objs: []runtime.Object{testLease("", time.Now())},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objs...)
+			release, err := AcquireResourceLock(context.TODO(), "cstorPoolCluster", "cspc-a", "openebs", client)
+			if tt.wantKind != nil {
+				if !errors.Is(err, tt.wantKind) {
+					t.Fatalf("AcquireResourceLock() error = %v, want kind %v", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AcquireResourceLock() error = %v", err)
+			}
+			leaseClient := client.KubeClientset.CoordinationV1().Leases("openebs")
+			leaseObj, err := leaseClient.Get(context.TODO(),
+				"openebs-upgrade-lock-cstorpoolcluster-cspc-a", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get lease: %v", err)
+			}
+			holder := lockHolder("openebs", client)
+			if *leaseObj.Spec.HolderIdentity != holder {
+				t.Errorf("lease is held by %s, want %s", *leaseObj.Spec.HolderIdentity, holder)
+			}
+			if *leaseObj.Spec.LeaseDurationSeconds != int32(defaultLockTimeout/time.Second) {
+				t.Errorf("lease duration = %d, want %s", *leaseObj.Spec.LeaseDurationSeconds, defaultLockTimeout)
+			}
+			// the same holder can lock the resource again
+			again, err := AcquireResourceLock(context.TODO(), "cstorPoolCluster", "cspc-a", "openebs", client)
+			if err != nil {
+				t.Fatalf("AcquireResourceLock() again error = %v", err)
+			}
+			defer again()
+			release()
+			_, err = leaseClient.Get(context.TODO(),
+				"openebs-upgrade-lock-cstorpoolcluster-cspc-a", metav1.GetOptions{})
+			if !k8serror.IsNotFound(err) {
+				t.Errorf("lease is not deleted on release, got error %v", err)
+			}
+			// releasing again is a no-op
+			release()
+		})
+	}
+}
+
+func TestReleaseResourceLockOfAnotherHolder(t *testing.T) {
+	client := NewTestClient(testLease("job/other", time.Now()))
+	releaseResourceLock("openebs-upgrade-lock-cstorpoolcluster-cspc-a", "openebs", "job/mine", client)
+	_, err := client.KubeClientset.CoordinationV1().Leases("openebs").Get(context.TODO(),
+		"openebs-upgrade-lock-cstorpoolcluster-cspc-a", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("lease of another holder is deleted, got error %v", err)
+	}
+}
+
+func TestRenewResourceLock(t *testing.T) {
+	const name = "openebs-upgrade-lock-cstorpoolcluster-cspc-a"
+	renewed := time.Now().Add(-time.Minute).Truncate(time.Second)
+	tests := []struct {
+		holder      string
+		wantRenewed bool
+	}{
+		{holder: "job/mine", wantRenewed: true},
+		{holder: "job/other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.holder, func(t *testing.T) {
+			client := NewTestClient(testLease(tt.holder, renewed))
+			renewResourceLock(name, "openebs", "job/mine", client)
+			leaseObj, err := client.KubeClientset.CoordinationV1().Leases("openebs").Get(context.TODO(),
+				name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := leaseObj.Spec.RenewTime.After(renewed); got != tt.wantRenewed {
+				t.Errorf("renewResourceLock() renewed = %v, want %v", got, tt.wantRenewed)
+			}
+		})
+	}
+}
+
+func TestLockHolder(t *testing.T) {
+	pod := func(name string, owners ...metav1.OwnerReference) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openebs", OwnerReferences: owners},
+		}
+	}
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want string
+	}{
+		{
+			name: "pod of a job",
+			pod:  pod("upgrade-cspc-x7k2p", metav1.OwnerReference{Kind: "Job", Name: "upgrade-cspc"}),
+			want: "job/upgrade-cspc",
+		},
+		{name: "pod without a job", pod: pod("upgrade"), want: "pod/upgrade"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("POD_NAME", tt.pod.Name)
+			if got := lockHolder("openebs", NewTestClient(tt.pod)); got != tt.want {
+				t.Errorf("lockHolder() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
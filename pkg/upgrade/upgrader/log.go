@@ -0,0 +1,49 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"fmt"
+
+	"k8s.io/klog"
+)
+
+// The klog verbosity levels of the upgrade logs. The phase transitions,
+// warnings and errors are logged at the default level, while the polls
+// of the waits are only logged from LogLevelWait, and the details of
+// the requests from LogLevelDebug.
+const (
+	LogLevelWait  klog.Level = 2
+	LogLevelDebug klog.Level = 4
+)
+
+// waitLogger logs the progress of a wait. The first poll is logged at
+// the default level as the start of the wait, and the later polls only
+// at LogLevelWait, so that the slow waits don't flood the logs.
+type waitLogger struct {
+	polls int
+}
+
+// Infof logs a poll of the wait
+func (l *waitLogger) Infof(format string, args ...interface{}) {
+	l.polls++
+	if l.polls == 1 {
+		klog.InfoDepth(1, fmt.Sprintf(format, args...))
+		return
+	}
+	klog.V(LogLevelWait).Infof(format, args...)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"flag"
+	"strconv"
+	"strings"
+	"testing"
+
+	"k8s.io/klog"
+)
+
+func TestWaitLogger(t *testing.T) {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	_ = fs.Set("logtostderr", "false")
+	buf := &bytes.Buffer{}
+	klog.SetOutput(buf)
+	defer func() {
+		_ = fs.Set("v", "0")
+		_ = fs.Set("logtostderr", "true")
+	}()
+
+	tests := []struct {
+		verbosity int
+		want      int
+	}{
+		{verbosity: 0, want: 1},
+		{verbosity: int(LogLevelWait), want: 3},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		_ = fs.Set("v", strconv.Itoa(tt.verbosity))
+		log := &waitLogger{}
+		for i := 1; i <= 3; i++ {
+			log.Infof("waiting for cspi-1, poll %d", i)
+		}
+		klog.Flush()
+		got := strings.Count(buf.String(), "waiting for cspi-1")
+		if got != tt.want {
+			t.Errorf("waitLogger at -v=%d logged %d polls, want %d:\n%s", tt.verbosity, got, tt.want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// nodeJobImage runs the commands of the host through nsenter in
+	// the privileged jobs run on the nodes
+	nodeJobImage  = "busybox:1.33"
+	lvmJobTimeout = 10 * time.Minute
+	// lvmJobLabel identifies the jobs which change the lvm thin pools
+	lvmJobLabel = "openebs.io/upgrade-lvm-pool"
+)
+
+// lvmThinPoolSetting is a setting of a thin pool that lvchange can change
+// on an existing pool, along with the lvs field reporting its value
+type lvmThinPoolSetting struct {
+	// field is the lvs field of the setting
+	field string
+	// values maps the accepted values to the value reported by lvs
+	values map[string]string
+	// option is the lvchange option of the setting
+	option string
+}
+
+// lvmThinPoolSettings are the supported settings. The chunk size of an
+// existing thin pool can not be changed by lvchange, so it is not one.
+var lvmThinPoolSettings = map[string]lvmThinPoolSetting{
+	"discards": {
+		field:  "discards",
+		values: map[string]string{"ignore": "ignore", "nopassdown": "nopassdown", "passdown": "passdown"},
+		option: "--discards",
+	},
+	"zero": {
+		field:  "zero",
+		values: map[string]string{"y": "zero", "n": ""},
+		option: "--zero",
+	},
+	"errorwhenfull": {
+		field:  "lv_when_full",
+		values: map[string]string{"y": "error", "n": "queue"},
+		option: "--errorwhenfull",
+	},
+}
+
+// lvmNamePattern matches the names of the volume groups and logical volumes
+var lvmNamePattern = regexp.MustCompile(`^[a-zA-Z0-9+_.-]+/[a-zA-Z0-9+_.-]+$`)
+
+// ValidateLVMThinPool returns a validation error if the thin pool
+// is not a vg/lv name or a setting is not supported, like chunk size
+func ValidateLVMThinPool(pool string, settings []string) error {
+	if pool == "" {
+		if len(settings) != 0 {
+			return newValidationError(errors.Errorf("lvm thin pool settings are set without a thin pool"))
+		}
+		return nil
+	}
+	if !lvmNamePattern.MatchString(pool) {
+		return newValidationError(errors.Errorf("invalid lvm thin pool %q, expected <vg>/<thinpool>", pool))
+	}
+	_, err := parseLVMThinPoolSettings(settings)
+	return err
+}
+
+// parseLVMThinPoolSettings parses the key=value settings
+func parseLVMThinPoolSettings(settings []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, s := range settings {
+		kv := strings.SplitN(s, "=", 2)
+		setting, ok := lvmThinPoolSettings[kv[0]]
+		if !ok {
+			return nil, newValidationError(errors.Errorf(
+				"unsupported lvm thin pool setting %q, expected one of discards, zero or errorwhenfull", s,
+			))
+		}
+		if _, ok := setting.values[kv[len(kv)-1]]; len(kv) != 2 || !ok {
+			return nil, newValidationError(errors.Errorf("invalid value of lvm thin pool setting %q", s))
+		}
+		parsed[kv[0]] = kv[1]
+	}
+	return parsed, nil
+}
+
+// LVMPoolPatch changes the settings of the lvm thin pool on a node by
+// running a privileged job on the node, which runs the lvm commands of
+// the host in its namespaces. Each setting is only changed if lvs
+// reports a different value, so the job can be run again safely.
+type LVMPoolPatch struct {
+	*ResourcePatch
+	*Client
+	// Node is the hostname of the node of the thin pool
+	Node string
+}
+
+// LVMPoolPatchOptions ...
+type LVMPoolPatchOptions func(*LVMPoolPatch)
+
+// WithLVMPoolResorcePatch ...
+func WithLVMPoolResorcePatch(r *ResourcePatch) LVMPoolPatchOptions {
+	return func(obj *LVMPoolPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithLVMPoolClient ...
+func WithLVMPoolClient(c *Client) LVMPoolPatchOptions {
+	return func(obj *LVMPoolPatch) {
+		obj.Client = c
+	}
+}
+
+// WithLVMPoolNode ...
+func WithLVMPoolNode(node string) LVMPoolPatchOptions {
+	return func(obj *LVMPoolPatch) {
+		obj.Node = node
+	}
+}
+
+// NewLVMPoolPatch ...
+func NewLVMPoolPatch(opts ...LVMPoolPatchOptions) *LVMPoolPatch {
+	obj := &LVMPoolPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// script returns the shell script which changes the settings of the
+// thin pool that differ from the current values reported by lvs
+func (obj *LVMPoolPatch) script() (string, error) {
+	settings, err := parseLVMThinPoolSettings(obj.LVMThinPoolSettings)
+	if err != nil {
+		return "", err
+	}
+	keys := []string{}
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := []string{"set -e"}
+	for _, key := range keys {
+		setting := lvmThinPoolSettings[key]
+		value := settings[key]
+		lines = append(lines, fmt.Sprintf(
+			`if [ "$(lvs --noheadings -o %s %s | tr -d ' ')" != "%s" ]; then lvchange %s %s %s; fi`,
+			setting.field, obj.LVMThinPool, setting.values[value], setting.option, value, obj.LVMThinPool,
+		))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// jobName returns the name of the job of the node and the script, so
+// that the same settings are applied by the same job on a node
+func (obj *LVMPoolPatch) jobName(script string) string {
+	sum := sha256.Sum256([]byte(obj.LVMThinPool + "\n" + script))
+	hash := hex.EncodeToString(sum[:])[:8]
+	node := strings.Trim(strings.ToLower(obj.Node), ".-")
+	if len(node) > 40 {
+		node = node[:40]
+	}
+	return "upgrade-lvm-" + node + "-" + hash
+}
+
+func (obj *LVMPoolPatch) buildJob(name, script string) *batchv1.Job {
+	privileged := true
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: obj.OpenebsNamespace,
+			Labels:    map[string]string{lvmJobLabel: "true"},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{lvmJobLabel: "true"},
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector:  map[string]string{hostNameLabel: obj.Node},
+					HostPID:       true,
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:  "lvm",
+							Image: nodeJobImage,
+							// run the lvm commands of the host in its namespaces
+							Command: []string{
+								"nsenter", "--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid",
+								"--", "sh", "-c", script,
+							},
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: &privileged,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Upgrade applies the settings of the thin pool on the node. A job of the
+// same settings that already succeeded is not run again, and a failed
+// one is reported with the name of the job to read its logs from.
+func (obj *LVMPoolPatch) Upgrade() error {
+	if obj.LVMThinPool == "" || len(obj.LVMThinPoolSettings) == 0 {
+		return nil
+	}
+	script, err := obj.script()
+	if err != nil {
+		return err
+	}
+	name := obj.jobName(script)
+	jobClient := obj.KubeClientset.BatchV1().Jobs(obj.OpenebsNamespace)
+	_, err = jobClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		if obj.VerifyOnly {
+			klog.Infof("skipping the lvm thin pool %s on node %s in verify only mode", obj.LVMThinPool, obj.Node)
+			return nil
+		}
+		klog.Infof("node %s: updating lvm thin pool %s using job %s", obj.Node, obj.LVMThinPool, name)
+		_, err = jobClient.Create(context.TODO(), obj.buildJob(name, script), metav1.CreateOptions{})
+	}
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to run lvm job %s", name))
+	}
+	return obj.waitForJob(name)
+}
+
+func (obj *LVMPoolPatch) waitForJob(name string) error {
+	interval := obj.pollInterval()
+	log := &waitLogger{}
+	deadline := time.Now().Add(lvmJobTimeout)
+	for {
+		jobObj, err := obj.KubeClientset.BatchV1().Jobs(obj.OpenebsNamespace).
+			Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to get lvm job %s", name))
+		}
+		if jobObj.Status.Succeeded > 0 {
+			klog.Infof("node %s: lvm thin pool %s is up to date", obj.Node, obj.LVMThinPool)
+			return nil
+		}
+		if jobObj.Status.Failed > 0 {
+			return errors.Errorf("lvm job %s failed to update thin pool %s on node %s, "+
+				"delete the job after checking its logs to retry", name, obj.LVMThinPool, obj.Node)
+		}
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf("timed out waiting for lvm job %s to complete", name))
+		}
+		log.Infof("node %s: waiting for lvm job %s to complete", obj.Node, name)
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateLVMThinPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		pool     string
+		settings []string
+		wantErr  bool
+	}{
+		{name: "not set"},
+		{name: "valid", pool: "lvmvg/thinpool", settings: []string{"discards=passdown", "zero=n"}},
+		{name: "settings without pool", settings: []string{"zero=n"}, wantErr: true},
+		{name: "invalid pool", pool: "thinpool; reboot", wantErr: true},
+		{name: "chunk size", pool: "lvmvg/thinpool", settings: []string{"chunksize=64k"}, wantErr: true},
+		{name: "invalid value", pool: "lvmvg/thinpool", settings: []string{"discards=all"}, wantErr: true},
+		{name: "missing value", pool: "lvmvg/thinpool", settings: []string{"zero"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLVMThinPool(tt.pool, tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateLVMThinPool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrValidation) {
+				t.Errorf("ValidateLVMThinPool() error = %v, want a validation error", err)
+			}
+		})
+	}
+}
+
+func TestLVMPoolPatch_script(t *testing.T) {
+	obj := NewLVMPoolPatch(
+		WithLVMPoolResorcePatch(NewResourcePatch(
+			WithLVMThinPool("lvmvg/thinpool", []string{"zero=n", "discards=passdown"}),
+		)),
+	)
+	got, err := obj.script()
+	if err != nil {
+		t.Fatalf("script() error = %v", err)
+	}
+	want := strings.Join([]string{
+		"set -e",
+		`if [ "$(lvs --noheadings -o discards lvmvg/thinpool | tr -d ' ')" != "passdown" ]; then lvchange --discards passdown lvmvg/thinpool; fi`,
+		`if [ "$(lvs --noheadings -o zero lvmvg/thinpool | tr -d ' ')" != "" ]; then lvchange --zero n lvmvg/thinpool; fi`,
+	}, "\n")
+	if got != want {
+		t.Errorf("script() = %s, want %s", got, want)
+	}
+}
+
+func TestLVMPoolPatch_Upgrade(t *testing.T) {
+	client := NewTestClient()
+	obj := NewLVMPoolPatch(
+		WithLVMPoolResorcePatch(NewResourcePatch(
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			WithPollInterval(time.Millisecond),
+			WithLVMThinPool("lvmvg/thinpool", []string{"discards=passdown"}),
+		)),
+		WithLVMPoolClient(client),
+		WithLVMPoolNode("node-1"),
+	)
+	script, _ := obj.script()
+	name := obj.jobName(script)
+	jobs := client.KubeClientset.BatchV1().Jobs(upgradetesting.Namespace)
+	// complete the job once it is created
+	go func() {
+		for {
+			jobObj, err := jobs.Get(context.TODO(), name, metav1.GetOptions{})
+			if err == nil {
+				jobObj.Status.Succeeded = 1
+				jobs.Update(context.TODO(), jobObj, metav1.UpdateOptions{})
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err := obj.Upgrade()
+	if err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	jobObj, err := jobs.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the lvm job: %v", err)
+	}
+	podSpec := jobObj.Spec.Template.Spec
+	if !podSpec.HostPID || podSpec.NodeSelector[hostNameLabel] != "node-1" ||
+		!*podSpec.Containers[0].SecurityContext.Privileged {
+		t.Errorf("lvm job pod spec = %+v, want a privileged pod with host pid on node-1", podSpec)
+	}
+
+	// the succeeded job of the same settings is not run again
+	err = obj.Upgrade()
+	if err != nil {
+		t.Errorf("Upgrade() again error = %v", err)
+	}
+
+	// a failed job is reported with its name
+	failed := jobObj.DeepCopy()
+	failed.Status = batchv1.JobStatus{Failed: 1}
+	jobs.Update(context.TODO(), failed, metav1.UpdateOptions{})
+	err = obj.Upgrade()
+	if err == nil || !strings.Contains(err.Error(), name) {
+		t.Errorf("Upgrade() of a failed job error = %v, want the job name", err)
+	}
+}
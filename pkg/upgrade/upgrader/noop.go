@@ -0,0 +1,163 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IsAtTargetVersion returns true if the resource of the given kind and all
+// its dependents are already at the To version, which means the desired
+// and current versions are the To version and the pods are labelled with
+// it. The upgrade of such a resource is a no-op and can be skipped. The
+// rbac is always upgraded, as its version is not recorded.
+func IsAtTargetVersion(kind string, r *ResourcePatch, client *Client) (bool, error) {
+	switch kind {
+	case "cstorPoolCluster":
+		return isCSPCAtTargetVersion(r, client)
+	case "cstorPoolInstance":
+		return isCSPIAtTargetVersion(r.Name, r, client)
+	case "cstorVolume":
+		return isCStorVolumeAtTargetVersion(r, client)
+	case "jivaVolume":
+		return isJivaVolumeAtTargetVersion(r, client)
+	}
+	return false, nil
+}
+
+func isVersionAt(v cstor.VersionDetails, to string) bool {
+	return v.Desired == to && v.Status.Current == to
+}
+
+// areLabelsAt returns true if all the workloads matching the label
+// are labelled with the given version
+func areLabelsAt(label, to string, r *ResourcePatch, client *Client) (bool, error) {
+	deployList, err := client.KubeClientset.AppsV1().Deployments(r.OpenebsNamespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: label})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list deployments with label %s", label)
+	}
+	for _, d := range deployList.Items {
+		if d.Labels["openebs.io/version"] != to {
+			return false, nil
+		}
+	}
+	stsList, err := client.KubeClientset.AppsV1().StatefulSets(r.OpenebsNamespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: label})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list statefulsets with label %s", label)
+	}
+	for _, s := range stsList.Items {
+		if s.Labels["openebs.io/version"] != to {
+			return false, nil
+		}
+	}
+	return len(deployList.Items)+len(stsList.Items) != 0, nil
+}
+
+func isCSPCAtTargetVersion(r *ResourcePatch, client *Client) (bool, error) {
+	cspc := patch.NewCSPC(patch.WithCSPCClient(client.OpenebsClientset))
+	err := cspc.Get(r.Name, r.OpenebsNamespace)
+	if err != nil {
+		return false, err
+	}
+	if !isVersionAt(cspc.Object.VersionDetails, r.To) {
+		return false, nil
+	}
+	cspiList, err := client.OpenebsClientset.CstorV1().CStorPoolInstances(r.OpenebsNamespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "openebs.io/cstor-pool-cluster=" + r.Name,
+		})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list cspis of %s", r.Name)
+	}
+	for _, cspiObj := range cspiList.Items {
+		done, err := isCSPIAtTargetVersion(cspiObj.Name, r, client)
+		if err != nil || !done {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func isCSPIAtTargetVersion(name string, r *ResourcePatch, client *Client) (bool, error) {
+	cspi := patch.NewCSPI(patch.WithCSPIClient(client.OpenebsClientset))
+	err := cspi.Get(name, r.OpenebsNamespace)
+	if err != nil {
+		return false, err
+	}
+	if cspi.Object.Labels["openebs.io/version"] != r.To ||
+		!isVersionAt(cspi.Object.VersionDetails, r.To) {
+		return false, nil
+	}
+	return areLabelsAt("openebs.io/cstor-pool-instance="+name, r.To, r, client)
+}
+
+func isCStorVolumeAtTargetVersion(r *ResourcePatch, client *Client) (bool, error) {
+	cvc := patch.NewCVC(patch.WithCVCClient(client.OpenebsClientset))
+	err := cvc.Get(r.Name, r.OpenebsNamespace)
+	if err != nil {
+		return false, err
+	}
+	cv := patch.NewCV(patch.WithCVClient(client.OpenebsClientset))
+	err = cv.Get(r.Name, r.OpenebsNamespace)
+	if err != nil {
+		return false, err
+	}
+	if !isVersionAt(cvc.Object.VersionDetails, r.To) || !isVersionAt(cv.Object.VersionDetails, r.To) {
+		return false, nil
+	}
+	label := "openebs.io/persistent-volume=" + r.Name
+	cvrList, err := client.OpenebsClientset.CstorV1().CStorVolumeReplicas(r.OpenebsNamespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: label})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list cvrs of %s", r.Name)
+	}
+	for _, cvrObj := range cvrList.Items {
+		if !isVersionAt(cvrObj.VersionDetails, r.To) {
+			return false, nil
+		}
+	}
+	return areLabelsAt(label, r.To, r, client)
+}
+
+func isJivaVolumeAtTargetVersion(r *ResourcePatch, client *Client) (bool, error) {
+	// the jivavolume can only be read using the rest config
+	if client.Config == nil {
+		return false, nil
+	}
+	done, err := areLabelsAt("openebs.io/persistent-volume="+r.Name, r.To, r, client)
+	if err != nil || !done {
+		return false, err
+	}
+	cl, err := newJivaClient(client.Config)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create runtime client")
+	}
+	jivaVolume := patch.NewJV(patch.WithJVClient(cl))
+	err = jivaVolume.Get(r.Name, r.OpenebsNamespace)
+	if err != nil {
+		return false, err
+	}
+	v := jivaVolume.Object.VersionDetails
+	return v.Desired == r.To && v.Status.Current == r.To, nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestIsAtTargetVersion(t *testing.T) {
+	at := upgradetesting.NewTestVersionDetails
+	meta := func(name string, labels map[string]string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "openebs", Labels: labels}
+	}
+	cspc := func(v string) *cstor.CStorPoolCluster {
+		return upgradetesting.NewTestCSPC("cspc-a", v)
+	}
+	cspi := func(v string) *cstor.CStorPoolInstance {
+		return upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", v)
+	}
+	deploy := func(label, v string) *appsv1.Deployment {
+		return &appsv1.Deployment{ObjectMeta: meta(label+"-deploy", map[string]string{
+			"openebs.io/cstor-pool-instance": "cspc-a-1",
+			"openebs.io/persistent-volume":   "pvc-1",
+			"openebs.io/version":             v,
+		})}
+	}
+	volume := func(cv, cvr string) []runtime.Object {
+		return []runtime.Object{
+			&cstor.CStorVolumeConfig{ObjectMeta: meta("pvc-1", nil), VersionDetails: at("3.0.0")},
+			&cstor.CStorVolume{ObjectMeta: meta("pvc-1", nil), VersionDetails: at(cv)},
+			&cstor.CStorVolumeReplica{
+				ObjectMeta:     meta("pvc-1-cspc-a-1", map[string]string{"openebs.io/persistent-volume": "pvc-1"}),
+				VersionDetails: at(cvr),
+			},
+		}
+	}
+	tests := []struct {
+		name    string
+		kind    string
+		objects []runtime.Object
+		deploy  *appsv1.Deployment
+		want    bool
+	}{
+		{
+			name:    "cspc at target",
+			kind:    "cstorPoolCluster",
+			objects: []runtime.Object{cspc("3.0.0"), cspi("3.0.0")},
+			deploy:  deploy("pool", "3.0.0"),
+			want:    true,
+		},
+		{
+			name:    "cspi not upgraded",
+			kind:    "cstorPoolCluster",
+			objects: []runtime.Object{cspc("3.0.0"), cspi("2.12.0")},
+			deploy:  deploy("pool", "2.12.0"),
+		},
+		{
+			name:    "pool deployment not upgraded",
+			kind:    "cstorPoolInstance",
+			objects: []runtime.Object{cspi("3.0.0")},
+			deploy:  deploy("pool", "2.12.0"),
+		},
+		{
+			name:    "volume at target",
+			kind:    "cstorVolume",
+			objects: volume("3.0.0", "3.0.0"),
+			deploy:  deploy("target", "3.0.0"),
+			want:    true,
+		},
+		{
+			name:    "cvr not upgraded",
+			kind:    "cstorVolume",
+			objects: volume("3.0.0", "2.12.0"),
+			deploy:  deploy("target", "3.0.0"),
+		},
+		{
+			name:   "rbac is always upgraded",
+			kind:   "rbac",
+			deploy: deploy("pool", "3.0.0"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(append(tt.objects, tt.deploy)...)
+			name := "cspc-a"
+			switch tt.kind {
+			case "cstorPoolInstance":
+				name = "cspc-a-1"
+			case "cstorVolume":
+				name = "pvc-1"
+			}
+			r := &ResourcePatch{Name: name, To: "3.0.0", OpenebsNamespace: "openebs"}
+			got, err := IsAtTargetVersion(tt.kind, r, client)
+			if err != nil {
+				t.Fatalf("IsAtTargetVersion() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("IsAtTargetVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+const (
+	// numaNodeAnnotation is set on a cspi to the NUMA node of its node
+	// the pool is pinned to, like 0 or 1
+	numaNodeAnnotation = "openebs.io/numa-node"
+	// numaNodeLabel is set by the node feature discovery on the nodes
+	// with more than one NUMA node
+	numaNodeLabel = "feature.node.kubernetes.io/memory-numa"
+)
+
+// numaPlacement is the NUMA node of a node a cspi is upgraded on,
+// covering the whole node if numaNode is empty
+type numaPlacement struct {
+	node     string
+	numaNode string
+}
+
+func (p numaPlacement) String() string {
+	if p.numaNode == "" {
+		return p.node
+	}
+	return p.node + " numa " + p.numaNode
+}
+
+// conflicts returns true if the pools of both placements may share a
+// NUMA node, that is they are on the same node and one of them is
+// not pinned or both are pinned to the same NUMA node
+func (p numaPlacement) conflicts(o numaPlacement) bool {
+	return p.node == o.node &&
+		(p.numaNode == "" || o.numaNode == "" || p.numaNode == o.numaNode)
+}
+
+// cspiNUMAPlacement returns the NUMA node the pool of the cspi is pinned
+// to. A node which is not labelled as having multiple NUMA nodes, or a
+// cspi without the NUMA node annotation, is placed on the whole node.
+func cspiNUMAPlacement(cspiObj *cstor.CStorPoolInstance, client kubernetes.Interface) numaPlacement {
+	p := numaPlacement{node: cspiNode(cspiObj)}
+	numaNode := cspiObj.Annotations[numaNodeAnnotation]
+	if p.node == "" || numaNode == "" {
+		return p
+	}
+	nodeObj, err := getNode(p.node, client)
+	if err != nil {
+		klog.Warningf("cspi %s: upgrading as not NUMA pinned: %v", cspiObj.Name, err)
+		return p
+	}
+	if nodeObj.Labels[numaNodeLabel] == "true" {
+		p.numaNode = numaNode
+	}
+	return p
+}
+
+// CheckNUMATopology groups the cspis by their NUMA node and returns the
+// names of the cspis in batches which can be upgraded at the same time,
+// with at most one cspi per NUMA node in a batch. The cspis are placed
+// in the first batch without a cspi sharing their NUMA node, in order.
+func CheckNUMATopology(cspiList []cstor.CStorPoolInstance, client kubernetes.Interface) [][]string {
+	batches := [][]string{}
+	placements := [][]numaPlacement{}
+	for i := range cspiList {
+		p := cspiNUMAPlacement(&cspiList[i], client)
+		b := 0
+		for ; b < len(batches); b++ {
+			if !conflictsWithAny(p, placements[b]) {
+				break
+			}
+		}
+		if b == len(batches) {
+			batches = append(batches, []string{})
+			placements = append(placements, []numaPlacement{})
+		}
+		batches[b] = append(batches[b], cspiList[i].Name)
+		placements[b] = append(placements[b], p)
+	}
+	return batches
+}
+
+func conflictsWithAny(p numaPlacement, placements []numaPlacement) bool {
+	for _, o := range placements {
+		if p.conflicts(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// orderByNUMABatches returns the cspis in the order of the batches, so
+// that the cspis sharing a NUMA node are upgraded a batch apart
+func orderByNUMABatches(cspiList []cstor.CStorPoolInstance, batches [][]string) []cstor.CStorPoolInstance {
+	byName := make(map[string]cstor.CStorPoolInstance, len(cspiList))
+	for _, cspiObj := range cspiList {
+		byName[cspiObj.Name] = cspiObj
+	}
+	ordered := make([]cstor.CStorPoolInstance, 0, len(cspiList))
+	for _, batch := range batches {
+		for _, name := range batch {
+			ordered = append(ordered, byName[name])
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"reflect"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+)
+
+func TestCheckNUMATopology(t *testing.T) {
+	cspi := func(name, node, numaNode string) cstor.CStorPoolInstance {
+		c := cstor.CStorPoolInstance{}
+		c.Name = name
+		c.Spec.HostName = node
+		if numaNode != "" {
+			c.Annotations = map[string]string{numaNodeAnnotation: numaNode}
+		}
+		return c
+	}
+	numaNode := newTestNode("node-a", "node-a")
+	numaNode.Labels[numaNodeLabel] = "true"
+	client := NewTestClient(numaNode, newTestNode("node-b", "node-b")).KubeClientset
+	tests := []struct {
+		name  string
+		cspis []cstor.CStorPoolInstance
+		want  [][]string
+	}{
+		{
+			name: "pinned to different NUMA nodes",
+			cspis: []cstor.CStorPoolInstance{
+				cspi("cspi-1", "node-a", "0"),
+				cspi("cspi-2", "node-a", "1"),
+				cspi("cspi-3", "node-a", "0"),
+			},
+			want: [][]string{{"cspi-1", "cspi-2"}, {"cspi-3"}},
+		},
+		{
+			name: "not pinned",
+			cspis: []cstor.CStorPoolInstance{
+				cspi("cspi-1", "node-a", "0"),
+				cspi("cspi-2", "node-a", ""),
+				cspi("cspi-3", "node-a", "1"),
+			},
+			want: [][]string{{"cspi-1", "cspi-3"}, {"cspi-2"}},
+		},
+		{
+			name: "node without NUMA label",
+			cspis: []cstor.CStorPoolInstance{
+				cspi("cspi-1", "node-b", "0"),
+				cspi("cspi-2", "node-b", "1"),
+				cspi("cspi-3", "node-a", "1"),
+			},
+			want: [][]string{{"cspi-1", "cspi-3"}, {"cspi-2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CheckNUMATopology(tt.cspis, client)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckNUMATopology() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_orderByNUMABatches(t *testing.T) {
+	cspis := []cstor.CStorPoolInstance{}
+	for _, name := range []string{"cspi-1", "cspi-2", "cspi-3"} {
+		c := cstor.CStorPoolInstance{}
+		c.Name = name
+		cspis = append(cspis, c)
+	}
+	got := []string{}
+	for _, c := range orderByNUMABatches(cspis, [][]string{{"cspi-1", "cspi-3"}, {"cspi-2"}}) {
+		got = append(got, c.Name)
+	}
+	want := []string{"cspi-1", "cspi-3", "cspi-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("orderByNUMABatches() = %v, want %v", got, want)
+	}
+}
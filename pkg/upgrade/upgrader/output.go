@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// OutputTable, OutputJSON and OutputYAML are
+	// the supported output formats
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// Table is the data that can be printed as a table, the same
+// data is marshalled for the json and yaml output formats
+type Table interface {
+	Headers() []string
+	Rows() [][]string
+}
+
+// ValidateOutputFormat returns a validation error
+// if the output format is not supported
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	}
+	return newValidationError(
+		errors.Errorf("invalid output format %q, expected one of table, json or yaml", format),
+	)
+}
+
+// PrintOutput writes the data to w in the given output format
+func PrintOutput(w io.Writer, format string, data Table) error {
+	err := ValidateOutputFormat(format)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case OutputJSON:
+		out, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal output")
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	case OutputYAML:
+		out, err := yaml.Marshal(data)
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal output")
+		}
+		_, err = w.Write(out)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(data.Headers(), "\t"))
+	for _, row := range data.Rows() {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
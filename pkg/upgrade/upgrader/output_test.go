@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func testUpgradeTaskInfoList(t *testing.T) UpgradeTaskInfoList {
+	client := NewTestClient(
+		&v1Alpha1API.UpgradeTask{
+			ObjectMeta: metav1.ObjectMeta{Name: "upgrade-cstor-cspc-cspc-a", Namespace: "openebs"},
+			Spec: v1Alpha1API.UpgradeTaskSpec{
+				FromVersion: "2.12.0",
+				ToVersion:   "3.0.0",
+				ResourceSpec: v1Alpha1API.ResourceSpec{
+					CStorPoolCluster: &v1Alpha1API.CStorPoolCluster{CSPCName: "cspc-a"},
+				},
+			},
+			Status: v1Alpha1API.UpgradeTaskStatus{Phase: v1Alpha1API.UpgradeSuccess},
+		},
+		&v1Alpha1API.UpgradeTask{
+			ObjectMeta: metav1.ObjectMeta{Name: "upgrade-cstor-pvc-1", Namespace: "openebs"},
+			Spec: v1Alpha1API.UpgradeTaskSpec{
+				FromVersion: "2.12.0",
+				ToVersion:   "3.0.0",
+				ResourceSpec: v1Alpha1API.ResourceSpec{
+					CStorVolume: &v1Alpha1API.CStorVolume{PVName: "pvc-1"},
+				},
+			},
+			Status: v1Alpha1API.UpgradeTaskStatus{Phase: v1Alpha1API.UpgradeError, Retries: 2},
+		},
+	)
+	list, err := ListUpgradeTasks("openebs", client)
+	if err != nil {
+		t.Fatalf("ListUpgradeTasks() error = %v", err)
+	}
+	return list
+}
+
+func TestPrintOutputJSON(t *testing.T) {
+	list := testUpgradeTaskInfoList(t)
+	buf := &bytes.Buffer{}
+	err := PrintOutput(buf, OutputJSON, list)
+	if err != nil {
+		t.Fatalf("PrintOutput() error = %v", err)
+	}
+	got := UpgradeTaskInfoList{}
+	err = json.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("failed to parse json output: %v\n%s", err, buf.String())
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d upgradetasks, want 2", len(got))
+	}
+	if got[0].Kind != "cstorPoolCluster" || got[0].Resource != "cspc-a" ||
+		got[0].Phase != string(v1Alpha1API.UpgradeSuccess) {
+		t.Errorf("unexpected upgradetask %+v", got[0])
+	}
+	if got[1].Kind != "cstorVolume" || got[1].Retries != 2 {
+		t.Errorf("unexpected upgradetask %+v", got[1])
+	}
+}
+
+func TestPrintOutputYAML(t *testing.T) {
+	list := testUpgradeTaskInfoList(t)
+	buf := &bytes.Buffer{}
+	err := PrintOutput(buf, OutputYAML, list)
+	if err != nil {
+		t.Fatalf("PrintOutput() error = %v", err)
+	}
+	got := UpgradeTaskInfoList{}
+	err = yaml.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("failed to parse yaml output: %v\n%s", err, buf.String())
+	}
+	if len(got) != 2 || got[1].Resource != "pvc-1" {
+		t.Errorf("unexpected yaml output %+v", got)
+	}
+}
+
+func TestPrintOutputTable(t *testing.T) {
+	list := testUpgradeTaskInfoList(t)
+	buf := &bytes.Buffer{}
+	err := PrintOutput(buf, OutputTable, list)
+	if err != nil {
+		t.Fatalf("PrintOutput() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), buf.String())
+	}
+	if got := strings.Fields(lines[0]); strings.Join(got, " ") != strings.Join(list.Headers(), " ") {
+		t.Errorf("table headers = %v, want %v", got, list.Headers())
+	}
+	// the columns are aligned with the headers
+	if strings.Index(lines[0], "PHASE") != strings.Index(lines[1], "Success") {
+		t.Errorf("table columns are not aligned:\n%s", buf.String())
+	}
+}
+
+func TestPrintOutputInvalidFormat(t *testing.T) {
+	err := PrintOutput(&bytes.Buffer{}, "xml", UpgradeTaskInfoList{})
+	if err == nil {
+		t.Errorf("PrintOutput() expected error for invalid format")
+	}
+}
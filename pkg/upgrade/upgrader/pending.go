@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/openebs/upgrade/pkg/version"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PendingResource is a resource whose current version is older than
+// the version it is to be upgraded to
+type PendingResource struct {
+	Kind           string `json:"kind"`
+	Name           string `json:"name"`
+	Namespace      string `json:"namespace"`
+	CurrentVersion string `json:"currentVersion"`
+	Status         string `json:"status"`
+}
+
+// PendingResourceList is the list of the resources pending upgrade
+type PendingResourceList []PendingResource
+
+// Headers returns the column names of the table output
+func (l PendingResourceList) Headers() []string {
+	return []string{"KIND", "NAME", "NAMESPACE", "CURRENT", "STATUS"}
+}
+
+// Rows returns the rows of the table output
+func (l PendingResourceList) Rows() [][]string {
+	rows := [][]string{}
+	for _, p := range l {
+		current, status := p.CurrentVersion, p.Status
+		if current == "" {
+			current = "-"
+		}
+		if status == "" {
+			status = "-"
+		}
+		rows = append(rows, []string{p.Kind, p.Name, p.Namespace, current, status})
+	}
+	return rows
+}
+
+// isVersionPending returns true if the current version is older than
+// the to version. A missing or custom current version is pending unless
+// it is the to version, as it can not be known to be up to date.
+func isVersionPending(current string, to version.FlexibleVersion) bool {
+	v, err := version.ParseVersionFlexible(current)
+	if err != nil {
+		return true
+	}
+	c, err := v.Compare(to)
+	if err != nil {
+		return true
+	}
+	return c < 0
+}
+
+// ListPendingResources returns the cspcs, cspis, cvs, cvcs and cvrs of
+// the namespace whose current version is older than the to version. The
+// resources are only read.
+func ListPendingResources(namespace, to string, client *Client) (PendingResourceList, error) {
+	toVersion, err := version.ParseVersionFlexible(to)
+	if err != nil {
+		return nil, newValidationError(err)
+	}
+	c := client.OpenebsClientset.CstorV1()
+	ctx := context.TODO()
+	list := PendingResourceList{}
+	add := func(kind string, obj metav1.Object, status cstor.VersionStatus) {
+		if isVersionPending(status.Current, toVersion) {
+			list = append(list, PendingResource{
+				Kind:           kind,
+				Name:           obj.GetName(),
+				Namespace:      obj.GetNamespace(),
+				CurrentVersion: status.Current,
+				Status:         string(status.State),
+			})
+		}
+	}
+	cspcList, err := c.CStorPoolClusters(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list cspcs"))
+	}
+	for i := range cspcList.Items {
+		add("cstorPoolCluster", &cspcList.Items[i], cspcList.Items[i].VersionDetails.Status)
+	}
+	cspiList, err := c.CStorPoolInstances(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list cspis"))
+	}
+	for i := range cspiList.Items {
+		add("cstorPoolInstance", &cspiList.Items[i], cspiList.Items[i].VersionDetails.Status)
+	}
+	cvList, err := c.CStorVolumes(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list cvs"))
+	}
+	for i := range cvList.Items {
+		add("cstorVolume", &cvList.Items[i], cvList.Items[i].VersionDetails.Status)
+	}
+	cvcList, err := c.CStorVolumeConfigs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list cvcs"))
+	}
+	for i := range cvcList.Items {
+		add("cstorVolumeConfig", &cvcList.Items[i], cvcList.Items[i].VersionDetails.Status)
+	}
+	cvrList, err := c.CStorVolumeReplicas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list cvrs"))
+	}
+	for i := range cvrList.Items {
+		add("cstorVolumeReplica", &cvrList.Items[i], cvrList.Items[i].VersionDetails.Status)
+	}
+	return list, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"reflect"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestListPendingResources(t *testing.T) {
+	cv := &cstor.CStorVolume{
+		ObjectMeta:     metav1.ObjectMeta{Name: "pvc-1", Namespace: upgradetesting.Namespace},
+		VersionDetails: upgradetesting.NewTestVersionDetails("2.12.0"),
+	}
+	cv.VersionDetails.Status.State = cstor.ReconcilePending
+	cvr := &cstor.CStorVolumeReplica{
+		ObjectMeta:     metav1.ObjectMeta{Name: "pvc-1-cspc-a-1", Namespace: upgradetesting.Namespace},
+		VersionDetails: upgradetesting.NewTestVersionDetails("3.0.0"),
+	}
+	client := NewTestClient(
+		upgradetesting.NewTestCSPC("cspc-a", "3.0.0"),
+		upgradetesting.NewTestCSPC("cspc-b", "3.1.0"),
+		upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", "2.12.0"),
+		upgradetesting.NewTestCSPI("cspc-a-2", "cspc-a", "ci-1234"),
+		upgradetesting.NewTestCSPI("cspc-a-3", "cspc-a", ""),
+		cv, cvr,
+	)
+	got, err := ListPendingResources(upgradetesting.Namespace, "3.0.0", client)
+	if err != nil {
+		t.Fatalf("ListPendingResources() error = %v", err)
+	}
+	want := PendingResourceList{
+		{Kind: "cstorPoolInstance", Name: "cspc-a-1", Namespace: "openebs", CurrentVersion: "2.12.0"},
+		{Kind: "cstorPoolInstance", Name: "cspc-a-2", Namespace: "openebs", CurrentVersion: "ci-1234"},
+		{Kind: "cstorPoolInstance", Name: "cspc-a-3", Namespace: "openebs"},
+		{Kind: "cstorVolume", Name: "pvc-1", Namespace: "openebs", CurrentVersion: "2.12.0", Status: "ReconcilePending"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListPendingResources() = %v, want %v", got, want)
+	}
+	wantRow := []string{"cstorPoolInstance", "cspc-a-3", "openebs", "-", "-"}
+	if rows := got.Rows(); !reflect.DeepEqual(rows[2], wantRow) {
+		t.Errorf("Rows() = %v, want %v", rows[2], wantRow)
+	}
+	if headers := got.Headers(); len(headers) != len(wantRow) {
+		t.Errorf("Headers() = %v, want a column of each value of the rows", headers)
+	}
+	_, err = ListPendingResources(upgradetesting.Namespace, "not a version", client)
+	if err == nil {
+		t.Errorf("ListPendingResources() with an invalid to version did not fail")
+	}
+}
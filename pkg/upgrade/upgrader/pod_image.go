@@ -0,0 +1,88 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// cspiTargetImages returns the images of the containers of the pool
+// deployment of a cspi once it is upgraded, by the container name
+func cspiTargetImages(d *appsv1.Deployment, res *ResourcePatch) (map[string]string, error) {
+	newDeploy := d.DeepCopy()
+	if newDeploy.Labels == nil {
+		newDeploy.Labels = map[string]string{}
+	}
+	if newDeploy.Spec.Template.Labels == nil {
+		newDeploy.Spec.Template.Labels = map[string]string{}
+	}
+	err := transformCSPIDeploy(newDeploy, res)
+	if err != nil {
+		return nil, err
+	}
+	images := map[string]string{}
+	for _, c := range newDeploy.Spec.Template.Spec.Containers {
+		images[c.Name] = c.Image
+	}
+	return images, nil
+}
+
+// CheckCSPIPodImages verifies that the containers of the pool pods of the
+// cspi run the given images, by the container name. The containers not
+// in the images, like injected sidecars, and the pods being deleted are
+// ignored. The containers of all the pods on other images are returned
+// in a single error.
+func CheckCSPIPodImages(name, namespace string, images map[string]string,
+	client kubernetes.Interface) error {
+	pods, err := client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "openebs.io/cstor-pool-instance=" + name,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to list the pods of cspi %s", name)
+	}
+	problems := []string{}
+	running := 0
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		running++
+		for _, c := range pod.Spec.Containers {
+			want, ok := images[c.Name]
+			if ok && c.Image != want {
+				problems = append(problems,
+					"container "+c.Name+" of pod "+pod.Name+" is on "+c.Image+", expected "+want)
+			}
+		}
+	}
+	if running == 0 {
+		return errors.Errorf("no pods of cspi %s found", name)
+	}
+	if len(problems) != 0 {
+		sort.Strings(problems)
+		return errors.Errorf("pods of cspi %s are not on the upgraded images: %s",
+			name, strings.Join(problems, "; "))
+	}
+	return nil
+}
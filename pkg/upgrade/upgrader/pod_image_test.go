@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestPoolPod(name, cspi string, deleting bool, containers ...corev1.Container) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openebs",
+			Labels:    map[string]string{"openebs.io/cstor-pool-instance": cspi},
+		},
+		Spec: corev1.PodSpec{Containers: containers},
+	}
+	if deleting {
+		now := metav1.Now()
+		pod.DeletionTimestamp = &now
+	}
+	return pod
+}
+
+func TestCheckCSPIPodImages(t *testing.T) {
+	deploy := poolDeployment(
+		corev1.Container{Name: "cstor-pool", Image: "openebs/cstor-pool:2.12.0"},
+		corev1.Container{Name: poolManagerContainer, Image: "openebs/cstor-pool-manager-amd64:2.12.0"},
+	)
+	images, err := cspiTargetImages(deploy, &ResourcePatch{To: "3.0.0"})
+	if err != nil {
+		t.Fatalf("cspiTargetImages() error = %v", err)
+	}
+	if deploy.Spec.Template.Spec.Containers[0].Image != "openebs/cstor-pool:2.12.0" {
+		t.Errorf("cspiTargetImages() modified the deployment")
+	}
+	upgraded := []corev1.Container{
+		{Name: "cstor-pool", Image: "openebs/cstor-pool:3.0.0"},
+		{Name: poolManagerContainer, Image: "openebs/cstor-pool-manager:3.0.0"},
+		{Name: "istio-proxy", Image: "istio/proxyv2:1.9.0"},
+	}
+	tests := []struct {
+		name    string
+		pods    []runtime.Object
+		wantErr string
+	}{
+		{
+			name: "upgraded",
+			pods: []runtime.Object{newTestPoolPod("pool-1", "cspi-1", false, upgraded...)},
+		},
+		{
+			name: "old pod being deleted",
+			pods: []runtime.Object{
+				newTestPoolPod("pool-1", "cspi-1", false, upgraded...),
+				newTestPoolPod("pool-0", "cspi-1", true,
+					corev1.Container{Name: "cstor-pool", Image: "openebs/cstor-pool:2.12.0"}),
+			},
+		},
+		{
+			name: "one container on the old image",
+			pods: []runtime.Object{newTestPoolPod("pool-1", "cspi-1", false,
+				corev1.Container{Name: "cstor-pool", Image: "openebs/cstor-pool:3.0.0"},
+				corev1.Container{Name: poolManagerContainer, Image: "openebs/cstor-pool-manager-amd64:2.12.0"},
+			)},
+			wantErr: "container cstor-pool-mgmt of pod pool-1 is on openebs/cstor-pool-manager-amd64:2.12.0",
+		},
+		{
+			name:    "no pods",
+			pods:    []runtime.Object{newTestPoolPod("pool-1", "cspi-2", false, upgraded...)},
+			wantErr: "no pods of cspi cspi-1 found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.pods...)
+			err := CheckCSPIPodImages("cspi-1", "openebs", images, client.KubeClientset)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckCSPIPodImages() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckCSPIPodImages() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
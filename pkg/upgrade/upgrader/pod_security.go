@@ -0,0 +1,391 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// The pod security admission levels, from the least to the most restrictive
+const (
+	PodSecurityPrivileged = "privileged"
+	PodSecurityBaseline   = "baseline"
+	PodSecurityRestricted = "restricted"
+)
+
+const (
+	// PodSecurityEnforceLabel is the namespace label which sets
+	// the pod security admission level enforced in the namespace
+	PodSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	// pspRemovedMinor is the minor version of kubernetes
+	// from which the podsecuritypolicies are removed
+	pspRemovedMinor = 25
+	// psaEnabledMinor is the minor version of kubernetes
+	// from which the pod security admission is enabled by default
+	psaEnabledMinor = 23
+)
+
+var podSecurityLevels = map[string]int{
+	PodSecurityPrivileged: 0,
+	PodSecurityBaseline:   1,
+	PodSecurityRestricted: 2,
+}
+
+// baselineCapabilities are the capabilities which may be
+// added to the containers by the baseline level
+var baselineCapabilities = map[string]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true,
+	"FSETID": true, "KILL": true, "MKNOD": true, "NET_BIND_SERVICE": true,
+	"SETFCAP": true, "SETGID": true, "SETPCAP": true, "SETUID": true, "SYS_CHROOT": true,
+}
+
+// restrictedVolumes are the volume types allowed by the restricted level
+var restrictedVolumes = map[policy.FSType]bool{
+	policy.ConfigMap: true, policy.CSI: true, policy.DownwardAPI: true, policy.EmptyDir: true,
+	policy.Ephemeral: true, policy.PersistentVolumeClaim: true, policy.Projected: true, policy.Secret: true,
+}
+
+// PSPToPSALevel returns the least restrictive pod security admission level
+// which still admits the pods admitted by the podsecuritypolicy, so that
+// converting the policy to the namespace label does not reject any pod
+func PSPToPSALevel(spec *policy.PodSecurityPolicySpec) string {
+	if spec.Privileged || spec.HostNetwork || spec.HostPID || spec.HostIPC || len(spec.HostPorts) != 0 {
+		return PodSecurityPrivileged
+	}
+	for _, c := range spec.AllowedCapabilities {
+		if !baselineCapabilities[string(c)] {
+			return PodSecurityPrivileged
+		}
+	}
+	for _, v := range spec.Volumes {
+		if v == policy.HostPath || v == policy.All {
+			return PodSecurityPrivileged
+		}
+	}
+	if spec.AllowPrivilegeEscalation == nil || *spec.AllowPrivilegeEscalation ||
+		spec.RunAsUser.Rule != policy.RunAsUserStrategyMustRunAsNonRoot {
+		return PodSecurityBaseline
+	}
+	for _, c := range spec.AllowedCapabilities {
+		if c != "NET_BIND_SERVICE" {
+			return PodSecurityBaseline
+		}
+	}
+	dropsAll := false
+	for _, c := range spec.RequiredDropCapabilities {
+		dropsAll = dropsAll || c == "ALL"
+	}
+	if !dropsAll {
+		return PodSecurityBaseline
+	}
+	for _, v := range spec.Volumes {
+		if !restrictedVolumes[v] {
+			return PodSecurityBaseline
+		}
+	}
+	return PodSecurityRestricted
+}
+
+// serverMinorVersion returns the minor version of the kubernetes cluster
+func serverMinorVersion(client *Client) (int, error) {
+	info, err := client.KubeClientset.Discovery().ServerVersion()
+	if err != nil {
+		return 0, newAPIError(errors.Wrap(err, "failed to get the kubernetes version"))
+	}
+	// the managed clusters report minor versions like 25+
+	minor, err := strconv.Atoi(strings.TrimRight(info.Minor, "+"))
+	if err != nil || info.Major != "1" {
+		return 0, errors.Errorf("unsupported kubernetes version %s.%s", info.Major, info.Minor)
+	}
+	return minor, nil
+}
+
+// PodSecurityPatch is the patch required to upgrade the pod security of
+// the openebs components, using the podsecuritypolicy before kubernetes
+// 1.25 and the pod security admission labels of the namespace after it
+type PodSecurityPatch struct {
+	*ResourcePatch
+	*Client
+}
+
+// PodSecurityPatchOptions ...
+type PodSecurityPatchOptions func(*PodSecurityPatch)
+
+// WithPodSecurityResorcePatch ...
+func WithPodSecurityResorcePatch(r *ResourcePatch) PodSecurityPatchOptions {
+	return func(obj *PodSecurityPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithPodSecurityClient ...
+func WithPodSecurityClient(c *Client) PodSecurityPatchOptions {
+	return func(obj *PodSecurityPatch) {
+		obj.Client = c
+	}
+}
+
+// NewPodSecurityPatch ...
+func NewPodSecurityPatch(opts ...PodSecurityPatchOptions) *PodSecurityPatch {
+	obj := &PodSecurityPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// Upgrade upgrades the podsecuritypolicy named by the resource patch if the
+// cluster still serves them, and converts it to the pod security admission
+// label of the openebs namespace if MigratePSP is set. From kubernetes 1.25
+// the label is set to the PodSecurityLevel, privileged if not set, as the
+// policies are removed.
+func (obj *PodSecurityPatch) Upgrade() error {
+	if obj.namespaceScoped {
+		return newValidationError(
+			errors.Errorf("the pod security upgrade needs cluster scoped permissions, it is not supported in namespace scoped mode"),
+		)
+	}
+	if obj.PodSecurityLevel != "" {
+		if _, ok := podSecurityLevels[obj.PodSecurityLevel]; !ok {
+			return newValidationError(errors.Errorf("invalid pod security level %s, expected %s, %s or %s",
+				obj.PodSecurityLevel, PodSecurityPrivileged, PodSecurityBaseline, PodSecurityRestricted))
+		}
+	}
+	minor, err := serverMinorVersion(obj.Client)
+	if err != nil {
+		return err
+	}
+	level := obj.PodSecurityLevel
+	if minor >= pspRemovedMinor {
+		klog.Infof("kubernetes 1.%d has no podsecuritypolicies, using the pod security admission", minor)
+		if level == "" {
+			level = PodSecurityPrivileged
+		}
+		return obj.psaLabelPatch(level).Upgrade()
+	}
+	pspPatch := NewPSPPatch(WithPSPResorcePatch(obj.ResourcePatch), WithPSPClient(obj.Client))
+	err = pspPatch.Upgrade()
+	if err != nil {
+		return err
+	}
+	if !obj.MigratePSP {
+		return nil
+	}
+	if minor < psaEnabledMinor {
+		klog.Warningf("the pod security admission is not enabled by default before kubernetes 1.%d, "+
+			"the label of namespace %s may not be enforced", psaEnabledMinor, obj.OpenebsNamespace)
+	}
+	if level == "" {
+		if pspPatch.PSP == nil {
+			return newValidationError(
+				errors.Errorf("podsecuritypolicy %s not found to migrate, set the pod security level", obj.Name),
+			)
+		}
+		level = PSPToPSALevel(&pspPatch.PSP.Spec)
+		klog.Infof("podsecuritypolicy %s is equivalent to the %s level", obj.Name, level)
+	}
+	return obj.psaLabelPatch(level).Upgrade()
+}
+
+func (obj *PodSecurityPatch) psaLabelPatch(level string) *PSALabelPatch {
+	return NewPSALabelPatch(
+		WithPSALabelResorcePatch(obj.ResourcePatch),
+		WithPSALabelClient(obj.Client),
+		WithPSALabelLevel(level),
+	)
+}
+
+// PSPPatch is the patch required to upgrade the
+// podsecuritypolicy used by the openebs components
+type PSPPatch struct {
+	*ResourcePatch
+	*Client
+	PSP *policy.PodSecurityPolicy
+}
+
+// PSPPatchOptions ...
+type PSPPatchOptions func(*PSPPatch)
+
+// WithPSPResorcePatch ...
+func WithPSPResorcePatch(r *ResourcePatch) PSPPatchOptions {
+	return func(obj *PSPPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithPSPClient ...
+func WithPSPClient(c *Client) PSPPatchOptions {
+	return func(obj *PSPPatch) {
+		obj.Client = c
+	}
+}
+
+// NewPSPPatch ...
+func NewPSPPatch(opts ...PSPPatchOptions) *PSPPatch {
+	obj := &PSPPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// Init gets the podsecuritypolicy, the policy is nil if it does not exist
+func (obj *PSPPatch) Init() error {
+	if obj.Name == "" {
+		return nil
+	}
+	psp, err := obj.KubeClientset.PolicyV1beta1().PodSecurityPolicies().
+		Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get podsecuritypolicy %s", obj.Name)
+	}
+	obj.PSP = psp
+	return nil
+}
+
+// Upgrade stamps the podsecuritypolicy with the to version. A missing
+// policy is only logged, as the components may not need one.
+func (obj *PSPPatch) Upgrade() error {
+	err := obj.Init()
+	if err != nil {
+		return newAPIError(err)
+	}
+	if obj.PSP == nil {
+		klog.Warningf("podsecuritypolicy %q not found, skipping its upgrade", obj.Name)
+		return nil
+	}
+	if obj.PSP.Labels["openebs.io/version"] == obj.To {
+		klog.Infof("podsecuritypolicy %s already in %s version", obj.Name, obj.To)
+		return nil
+	}
+	if obj.VerifyOnly {
+		klog.Infof("skipping patch of podsecuritypolicy %s in verify only mode", obj.Name)
+		return nil
+	}
+	newPSP := obj.PSP.DeepCopy()
+	if newPSP.Labels == nil {
+		newPSP.Labels = map[string]string{}
+	}
+	newPSP.Labels["openebs.io/version"] = obj.To
+	data, err := GetPatchData(obj.PSP, newPSP)
+	if err != nil {
+		return err
+	}
+	_, err = obj.KubeClientset.PolicyV1beta1().PodSecurityPolicies().
+		Patch(context.TODO(), obj.Name, k8stypes.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to patch podsecuritypolicy %s", obj.Name))
+	}
+	klog.Infof("podsecuritypolicy %s patched successfully", obj.Name)
+	return nil
+}
+
+// PSALabelPatch is the patch required to set the pod security
+// admission level enforced in the openebs namespace
+type PSALabelPatch struct {
+	*ResourcePatch
+	*Client
+	Level     string
+	Namespace *corev1.Namespace
+}
+
+// PSALabelPatchOptions ...
+type PSALabelPatchOptions func(*PSALabelPatch)
+
+// WithPSALabelResorcePatch ...
+func WithPSALabelResorcePatch(r *ResourcePatch) PSALabelPatchOptions {
+	return func(obj *PSALabelPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithPSALabelClient ...
+func WithPSALabelClient(c *Client) PSALabelPatchOptions {
+	return func(obj *PSALabelPatch) {
+		obj.Client = c
+	}
+}
+
+// WithPSALabelLevel ...
+func WithPSALabelLevel(level string) PSALabelPatchOptions {
+	return func(obj *PSALabelPatch) {
+		obj.Level = level
+	}
+}
+
+// NewPSALabelPatch ...
+func NewPSALabelPatch(opts ...PSALabelPatchOptions) *PSALabelPatch {
+	obj := &PSALabelPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// Upgrade sets the enforce label of the openebs namespace to the level
+func (obj *PSALabelPatch) Upgrade() error {
+	if _, ok := podSecurityLevels[obj.Level]; !ok {
+		return newValidationError(errors.Errorf("invalid pod security level %s", obj.Level))
+	}
+	ns, err := obj.KubeClientset.CoreV1().Namespaces().
+		Get(context.TODO(), obj.OpenebsNamespace, metav1.GetOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to get namespace %s", obj.OpenebsNamespace))
+	}
+	obj.Namespace = ns
+	current := ns.Labels[PodSecurityEnforceLabel]
+	if current == obj.Level {
+		klog.Infof("namespace %s already enforces the %s level", ns.Name, obj.Level)
+		return nil
+	}
+	if level, ok := podSecurityLevels[current]; ok && level > podSecurityLevels[obj.Level] {
+		klog.Warningf("namespace %s: relaxing the enforced level from %s to %s", ns.Name, current, obj.Level)
+	}
+	if obj.VerifyOnly {
+		klog.Infof("skipping patch of namespace %s in verify only mode", ns.Name)
+		return nil
+	}
+	newNS := ns.DeepCopy()
+	if newNS.Labels == nil {
+		newNS.Labels = map[string]string{}
+	}
+	newNS.Labels[PodSecurityEnforceLabel] = obj.Level
+	data, err := GetPatchData(ns, newNS)
+	if err != nil {
+		return err
+	}
+	_, err = obj.KubeClientset.CoreV1().Namespaces().
+		Patch(context.TODO(), ns.Name, k8stypes.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to patch namespace %s", ns.Name))
+	}
+	klog.Infof("namespace %s: enforcing the %s level", ns.Name, obj.Level)
+	return nil
+}
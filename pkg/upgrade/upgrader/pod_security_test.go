@@ -0,0 +1,180 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+)
+
+func testPSP(name string, spec policy.PodSecurityPolicySpec) *policy.PodSecurityPolicy {
+	return &policy.PodSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       spec,
+	}
+}
+
+func TestPSPToPSALevel(t *testing.T) {
+	noEscalation := false
+	restricted := policy.PodSecurityPolicySpec{
+		AllowPrivilegeEscalation: &noEscalation,
+		RequiredDropCapabilities: []corev1.Capability{"ALL"},
+		RunAsUser:                policy.RunAsUserStrategyOptions{Rule: policy.RunAsUserStrategyMustRunAsNonRoot},
+		Volumes:                  []policy.FSType{policy.ConfigMap, policy.Secret, policy.PersistentVolumeClaim},
+	}
+	withHostPath := *restricted.DeepCopy()
+	withHostPath.Volumes = append(withHostPath.Volumes, policy.HostPath)
+	withRoot := *restricted.DeepCopy()
+	withRoot.RunAsUser.Rule = policy.RunAsUserStrategyRunAsAny
+	tests := []struct {
+		name string
+		spec policy.PodSecurityPolicySpec
+		want string
+	}{
+		{name: "privileged", spec: policy.PodSecurityPolicySpec{Privileged: true}, want: PodSecurityPrivileged},
+		{name: "host path", spec: withHostPath, want: PodSecurityPrivileged},
+		{
+			name: "sys admin",
+			spec: policy.PodSecurityPolicySpec{AllowedCapabilities: []corev1.Capability{"SYS_ADMIN"}},
+			want: PodSecurityPrivileged,
+		},
+		{name: "run as root", spec: withRoot, want: PodSecurityBaseline},
+		{name: "restricted", spec: restricted, want: PodSecurityRestricted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PSPToPSALevel(&tt.spec); got != tt.want {
+				t.Errorf("PSPToPSALevel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodSecurityPatch_Upgrade(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: upgradetesting.Namespace}}
+	psp := func() *policy.PodSecurityPolicy {
+		return testPSP("openebs-privileged", policy.PodSecurityPolicySpec{Privileged: true})
+	}
+	tests := []struct {
+		name       string
+		minor      string
+		level      string
+		migrate    bool
+		verifyOnly bool
+		objects    []runtime.Object
+		wantLabel  string
+		wantPSP    bool
+		wantErr    error
+	}{
+		{
+			name:      "psa by default from 1.25",
+			minor:     "26+",
+			objects:   []runtime.Object{ns},
+			wantLabel: PodSecurityPrivileged,
+		},
+		{
+			name:      "psa level from 1.25",
+			minor:     "25",
+			level:     PodSecurityBaseline,
+			objects:   []runtime.Object{ns},
+			wantLabel: PodSecurityBaseline,
+		},
+		{
+			name:    "psp upgraded before 1.25",
+			minor:   "24",
+			objects: []runtime.Object{ns, psp()},
+			wantPSP: true,
+		},
+		{
+			name:      "psp migrated to psa",
+			minor:     "24",
+			migrate:   true,
+			objects:   []runtime.Object{ns, psp()},
+			wantLabel: PodSecurityPrivileged,
+			wantPSP:   true,
+		},
+		{
+			name:       "verify only",
+			minor:      "24",
+			migrate:    true,
+			verifyOnly: true,
+			objects:    []runtime.Object{ns, psp()},
+		},
+		{
+			name:    "missing psp without level",
+			minor:   "24",
+			migrate: true,
+			objects: []runtime.Object{ns},
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "invalid level",
+			minor:   "26",
+			level:   "strict",
+			objects: []runtime.Object{ns},
+			wantErr: ErrValidation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objects...)
+			client.KubeClientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion =
+				&version.Info{Major: "1", Minor: tt.minor}
+			r := NewResourcePatch(
+				WithName("openebs-privileged"),
+				WithOpenebsNamespace(upgradetesting.Namespace),
+				FromVersion("2.12.0"),
+				ToVersion("3.0.0"),
+				WithVerifyOnly(tt.verifyOnly),
+				WithPodSecurityLevel(tt.level),
+				WithMigratePSP(tt.migrate),
+			)
+			err := RegisterPodSecurity(r, client).Upgrade()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Upgrade() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Upgrade() error = %v", err)
+			}
+			gotNS, err := client.KubeClientset.CoreV1().Namespaces().
+				Get(context.TODO(), upgradetesting.Namespace, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get namespace: %v", err)
+			}
+			if got := gotNS.Labels[PodSecurityEnforceLabel]; got != tt.wantLabel {
+				t.Errorf("enforce label = %q, want %q", got, tt.wantLabel)
+			}
+			gotPSP, err := client.KubeClientset.PolicyV1beta1().PodSecurityPolicies().
+				Get(context.TODO(), "openebs-privileged", metav1.GetOptions{})
+			if err == nil && (gotPSP.Labels["openebs.io/version"] == "3.0.0") != tt.wantPSP {
+				t.Errorf("psp labels = %v, want upgraded %v", gotPSP.Labels, tt.wantPSP)
+			}
+		})
+	}
+}
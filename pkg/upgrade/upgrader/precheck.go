@@ -0,0 +1,335 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Severity is the impact of a precheck finding on the upgrade
+type Severity string
+
+const (
+	// SeverityBlocker fails the upgrade
+	SeverityBlocker Severity = "blocker"
+	// SeverityWarning is allowed by the upgrade options but can
+	// disrupt the upgrade or the applications
+	SeverityWarning Severity = "warning"
+	// SeverityInfo does not affect the upgrade
+	SeverityInfo Severity = "info"
+)
+
+// The codes of the precheck findings
+const (
+	CodeOperatorMissing        = "OPERATOR_MISSING"
+	CodeOperatorNotUpgraded    = "OPERATOR_NOT_UPGRADED"
+	CodeOperatorUnavailable    = "OPERATOR_UNAVAILABLE"
+	CodeResourceNotFound       = "RESOURCE_NOT_FOUND"
+	CodeAlreadyAtTargetVersion = "ALREADY_AT_TARGET_VERSION"
+	CodePoolOverprovisioned    = "POOL_OVERPROVISIONED"
+	CodeNodePressure           = "NODE_PRESSURE"
+	CodeVolumeInUse            = "VOLUME_IN_USE"
+	CodeCheckSkipped           = "CHECK_SKIPPED"
+)
+
+// PrecheckFinding is the result of a failed precheck
+type PrecheckFinding struct {
+	Code        string   `json:"code"`
+	Severity    Severity `json:"severity"`
+	Resource    string   `json:"resource"`
+	Message     string   `json:"message"`
+	Remediation string   `json:"remediation"`
+}
+
+// PrecheckReport is the list of findings of the prechecks of a resource
+type PrecheckReport struct {
+	Kind     string            `json:"kind"`
+	Name     string            `json:"name"`
+	From     string            `json:"fromVersion"`
+	To       string            `json:"toVersion"`
+	Findings []PrecheckFinding `json:"findings"`
+}
+
+func (r *PrecheckReport) add(code string, severity Severity, resource, message, remediation string) {
+	r.Findings = append(r.Findings, PrecheckFinding{
+		Code:        code,
+		Severity:    severity,
+		Resource:    resource,
+		Message:     message,
+		Remediation: remediation,
+	})
+}
+
+// Blockers returns the findings which fail the upgrade
+func (r *PrecheckReport) Blockers() []PrecheckFinding {
+	blockers := []PrecheckFinding{}
+	for _, f := range r.Findings {
+		if f.Severity == SeverityBlocker {
+			blockers = append(blockers, f)
+		}
+	}
+	return blockers
+}
+
+// Err returns a validation error if the report has any blocker
+func (r *PrecheckReport) Err() error {
+	blockers := r.Blockers()
+	if len(blockers) == 0 {
+		return nil
+	}
+	return newValidationError(
+		errors.Errorf("%s %s has %d blocking precheck findings, first %s: %s",
+			r.Kind, r.Name, len(blockers), blockers[0].Code, blockers[0].Message),
+	)
+}
+
+// Headers returns the column names of the table output
+func (r *PrecheckReport) Headers() []string {
+	return []string{"CODE", "SEVERITY", "RESOURCE", "MESSAGE", "REMEDIATION"}
+}
+
+// Rows returns the rows of the table output
+func (r *PrecheckReport) Rows() [][]string {
+	rows := [][]string{}
+	for _, f := range r.Findings {
+		rows = append(rows, []string{
+			f.Code, string(f.Severity), f.Resource, f.Message, f.Remediation,
+		})
+	}
+	return rows
+}
+
+// WriteTable writes the findings to w as a table
+func (r *PrecheckReport) WriteTable(w io.Writer) error {
+	return PrintOutput(w, OutputTable, r)
+}
+
+// JSON returns the report as indented json
+func (r *PrecheckReport) JSON() ([]byte, error) {
+	out, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal precheck report")
+	}
+	return out, nil
+}
+
+// PreFlight runs the prechecks of the upgrade of the given resource without
+// changing it, and returns the findings of all the checks instead of
+// stopping at the first failure. The checks allowed by the upgrade options,
+// like --allow-overprovisioned, are reported as warnings. An error is
+// returned only if the checks could not be run.
+func PreFlight(kind string, r *ResourcePatch, client *Client) (*PrecheckReport, error) {
+	report := &PrecheckReport{Kind: kind, Name: r.Name, From: r.From, To: r.To, Findings: []PrecheckFinding{}}
+	var err error
+	switch kind {
+	case "cstorPoolCluster":
+		err = preFlightCSPC(report, r, client)
+	case "cstorPoolInstance":
+		err = preFlightCSPI(report, r, client)
+	case "cstorVolume":
+		err = preFlightCStorVolume(report, r, client)
+	case "jivaVolume":
+		err = preFlightOperator(report, "jiva-operator", r, client)
+	default:
+		return nil, newValidationError(errors.Errorf("prechecks are not supported for %s", kind))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Blockers()) == 0 {
+		done, err := IsAtTargetVersion(kind, r, client)
+		if err == nil && done {
+			report.add(CodeAlreadyAtTargetVersion, SeverityInfo, kind+"/"+r.Name,
+				fmt.Sprintf("%s is already at %s", r.Name, r.To),
+				"no action needed, the upgrade is skipped")
+		}
+	}
+	return report, nil
+}
+
+// preFlightOperator reports the operator pods which are missing
+// or not yet at the to version, and the operator if it is not available
+func preFlightOperator(report *PrecheckReport, componentName string, r *ResourcePatch, client *Client) error {
+	podList, err := client.KubeClientset.CoreV1().Pods(r.OpenebsNamespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "openebs.io/component-name=" + componentName,
+		})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to list %s pods", componentName))
+	}
+	if len(podList.Items) == 0 {
+		report.add(CodeOperatorMissing, SeverityBlocker, "operator/"+componentName,
+			fmt.Sprintf("no %s pod found in %s", componentName, r.OpenebsNamespace),
+			fmt.Sprintf("install %s %s in %s", componentName, r.To, r.OpenebsNamespace))
+		return nil
+	}
+	labelKey := r.operatorVersionLabel()
+	for _, podObj := range podList.Items {
+		v, ok := podObj.Labels[labelKey]
+		if !ok {
+			report.add(CodeOperatorNotUpgraded, SeverityBlocker, "pod/"+podObj.Name,
+				fmt.Sprintf("%s pod has no %s label to read its version from", componentName, labelKey),
+				"set --operator-version-label to the label key of the operator version")
+			continue
+		}
+		if v != r.To {
+			report.add(CodeOperatorNotUpgraded, SeverityBlocker, "pod/"+podObj.Name,
+				fmt.Sprintf("%s is in %s version", componentName, v),
+				fmt.Sprintf("upgrade %s to %s first", componentName, r.To))
+		}
+	}
+	err = isOperatorAvailable(componentName, r.OpenebsNamespace, podList.Items, client.KubeClientset)
+	if errors.Is(err, ErrOperatorUnavailable) {
+		report.add(CodeOperatorUnavailable, SeverityBlocker, "operator/"+componentName,
+			err.Error(), fmt.Sprintf("check the events and the logs of %s", componentName))
+		return nil
+	}
+	return err
+}
+
+func preFlightCSPC(report *PrecheckReport, r *ResourcePatch, client *Client) error {
+	err := preFlightOperator(report, "cspc-operator", r, client)
+	if err != nil {
+		return err
+	}
+	_, err = client.OpenebsClientset.CstorV1().CStorPoolClusters(r.OpenebsNamespace).
+		Get(context.TODO(), r.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		report.add(CodeResourceNotFound, SeverityBlocker, "cstorPoolCluster/"+r.Name,
+			fmt.Sprintf("cspc %s not found in %s", r.Name, r.OpenebsNamespace),
+			"check the name of the cspc and the openebs namespace")
+		return nil
+	}
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to get cspc %s", r.Name))
+	}
+	cspiList, err := client.OpenebsClientset.CstorV1().CStorPoolInstances(r.OpenebsNamespace).
+		List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "openebs.io/cstor-pool-cluster=" + r.Name,
+		})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to list cspis of %s", r.Name))
+	}
+	for i := range cspiList.Items {
+		err = preFlightCSPIObject(report, &cspiList.Items[i], r, client)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func preFlightCSPI(report *PrecheckReport, r *ResourcePatch, client *Client) error {
+	cspiObj, err := client.OpenebsClientset.CstorV1().CStorPoolInstances(r.OpenebsNamespace).
+		Get(context.TODO(), r.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		report.add(CodeResourceNotFound, SeverityBlocker, "cstorPoolInstance/"+r.Name,
+			fmt.Sprintf("cspi %s not found in %s", r.Name, r.OpenebsNamespace),
+			"check the name of the cspi and the openebs namespace")
+		return nil
+	}
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to get cspi %s", r.Name))
+	}
+	return preFlightCSPIObject(report, cspiObj, r, client)
+}
+
+// preFlightCSPIObject reports the capacity of the cspi and the
+// pressure on its node
+func preFlightCSPIObject(report *PrecheckReport, cspiObj *cstor.CStorPoolInstance,
+	r *ResourcePatch, client *Client) error {
+	resource := "cstorPoolInstance/" + cspiObj.Name
+	err := CheckCSPIOverprovisioning(cspiObj, r.MinFreePoolSpace)
+	if err != nil {
+		severity := SeverityBlocker
+		if r.AllowOverprovisioned {
+			severity = SeverityWarning
+		}
+		report.add(CodePoolOverprovisioned, severity, resource, err.Error(),
+			"free up space on the pool, or set --allow-overprovisioned to upgrade anyway")
+	}
+	node := cspiNode(cspiObj)
+	if node == "" {
+		return nil
+	}
+	if client.namespaceScoped {
+		report.add(CodeCheckSkipped, SeverityInfo, resource,
+			fmt.Sprintf("pressure check of node %s skipped in namespace scoped mode", node),
+			"check the conditions of the node before the upgrade")
+		return nil
+	}
+	err = CheckNodePressure(node, client.KubeClientset)
+	if err != nil {
+		severity := SeverityBlocker
+		if r.IgnoreNodePressure {
+			severity = SeverityWarning
+		}
+		report.add(CodeNodePressure, severity, resource, err.Error(),
+			"relieve the pressure on the node, or set --ignore-node-pressure to upgrade anyway")
+	}
+	return nil
+}
+
+func preFlightCStorVolume(report *PrecheckReport, r *ResourcePatch, client *Client) error {
+	err := preFlightOperator(report, "cvc-operator", r, client)
+	if err != nil {
+		return err
+	}
+	if client.namespaceScoped {
+		report.add(CodeCheckSkipped, SeverityInfo, "cstorVolume/"+r.Name,
+			fmt.Sprintf("usage check of volume %s skipped in namespace scoped mode", r.Name),
+			"make sure the application using the volume can tolerate the restart of the target")
+		return nil
+	}
+	pvObj, err := client.KubeClientset.CoreV1().PersistentVolumes().
+		Get(context.TODO(), r.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		report.add(CodeResourceNotFound, SeverityBlocker, "persistentVolume/"+r.Name,
+			fmt.Sprintf("pv %s not found", r.Name),
+			"check the name of the pv of the volume")
+		return nil
+	}
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to get pv %s", r.Name))
+	}
+	ref := pvObj.Spec.ClaimRef
+	if ref == nil {
+		return nil
+	}
+	inUse, err := CheckPVCInUse(ref.Name, ref.Namespace, client.KubeClientset)
+	if err != nil {
+		return newAPIError(err)
+	}
+	if inUse {
+		severity := SeverityBlocker
+		if r.AllowInUseUpgrades {
+			severity = SeverityWarning
+		}
+		report.add(CodeVolumeInUse, severity, "persistentVolumeClaim/"+ref.Namespace+"/"+ref.Name,
+			fmt.Sprintf("pvc %s/%s of volume %s is in use by a running pod", ref.Namespace, ref.Name, r.Name),
+			"scale down the application using the pvc, or set --allow-in-use-upgrades to upgrade anyway")
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testOperatorPod(component, version string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      component + "-0",
+			Namespace: "openebs",
+			Labels: map[string]string{
+				"openebs.io/component-name": component,
+				"openebs.io/version":        version,
+			},
+		},
+	}
+}
+
+func TestPreFlight(t *testing.T) {
+	pv := testPV("pvc-1", cstorCSIProvisioner, "data-1")
+	app := testPod("app-0", "default", "data-1", corev1.PodRunning)
+	notReady := testOperatorPod("cvc-operator", "3.0.0")
+	notReady.Status.Conditions = []corev1.PodCondition{
+		{Type: corev1.PodReady, Status: corev1.ConditionFalse},
+	}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		allow   bool
+		want    map[string]Severity
+	}{
+		{
+			name:    "operator not upgraded and volume in use",
+			objects: []runtime.Object{testOperatorPod("cvc-operator", "2.12.0"), pv, app},
+			want: map[string]Severity{
+				CodeOperatorNotUpgraded: SeverityBlocker,
+				CodeVolumeInUse:         SeverityBlocker,
+			},
+		},
+		{
+			name:    "volume in use allowed",
+			objects: []runtime.Object{testOperatorPod("cvc-operator", "3.0.0"), pv, app},
+			allow:   true,
+			want:    map[string]Severity{CodeVolumeInUse: SeverityWarning},
+		},
+		{
+			name:    "operator not available",
+			objects: []runtime.Object{notReady, pv},
+			want:    map[string]Severity{CodeOperatorUnavailable: SeverityBlocker},
+		},
+		{
+			name:    "operator and pv missing",
+			objects: []runtime.Object{},
+			want: map[string]Severity{
+				CodeOperatorMissing:  SeverityBlocker,
+				CodeResourceNotFound: SeverityBlocker,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objects...)
+			r := NewResourcePatch(
+				WithName("pvc-1"),
+				WithOpenebsNamespace("openebs"),
+				FromVersion("2.12.0"),
+				ToVersion("3.0.0"),
+				WithAllowInUseUpgrades(tt.allow),
+			)
+			report, err := PreFlight("cstorVolume", r, client)
+			if err != nil {
+				t.Fatalf("PreFlight() error = %v", err)
+			}
+			got := map[string]Severity{}
+			for _, f := range report.Findings {
+				got[f.Code] = f.Severity
+				if f.Remediation == "" {
+					t.Errorf("finding %s has no remediation", f.Code)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PreFlight() findings = %v, want %v", got, tt.want)
+			}
+			blocked := false
+			for _, s := range tt.want {
+				blocked = blocked || s == SeverityBlocker
+			}
+			if err := report.Err(); (err != nil) != blocked || (err != nil && !errors.Is(err, ErrValidation)) {
+				t.Errorf("Err() = %v, want blocker error %v", err, blocked)
+			}
+		})
+	}
+}
+
+func TestPrecheckReport_Render(t *testing.T) {
+	report := &PrecheckReport{Kind: "cstorPoolCluster", Name: "cspc-a", From: "2.12.0", To: "3.0.0"}
+	report.add(CodeOperatorNotUpgraded, SeverityBlocker, "pod/cspc-operator-0",
+		"cspc-operator is in 2.12.0 version", "upgrade cspc-operator to 3.0.0 first")
+
+	var b bytes.Buffer
+	err := report.WriteTable(&b)
+	if err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "CODE") ||
+		!strings.Contains(lines[1], "upgrade cspc-operator to 3.0.0 first") {
+		t.Errorf("WriteTable() = %q", b.String())
+	}
+
+	out, err := report.JSON()
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+	got := &PrecheckReport{}
+	err = json.Unmarshal(out, got)
+	if err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", out, err)
+	}
+	if !reflect.DeepEqual(got, report) {
+		t.Errorf("JSON() = %s, want %+v", out, report)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// The phases of the upgrade events
+const (
+	PhaseStarted   = "Started"
+	PhaseProgress  = "Progress"
+	PhaseCompleted = "Completed"
+	PhaseFailed    = "Failed"
+	PhaseSkipped   = "Skipped"
+)
+
+// UpgradeEvent is a lifecycle event of the upgrade of a resource
+type UpgradeEvent struct {
+	Phase string `json:"phase"`
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	// Percent is the completion of the upgrade, for a cspc it is
+	// based on the number of its cspis done
+	Percent int         `json:"percent"`
+	Message string      `json:"message,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Time    metav1.Time `json:"time"`
+}
+
+// EmitProgress sends an event of the upgrade of the given resource to the
+// Progress channel, it is a no-op if the channel is not set. The event
+// is dropped instead of blocking the upgrade if NonBlockingProgress is set.
+func (r *ResourcePatch) EmitProgress(phase, kind, name string, percent int, message string, err error) {
+	if r == nil || r.Progress == nil {
+		return
+	}
+	e := UpgradeEvent{
+		Phase:   phase,
+		Kind:    kind,
+		Name:    name,
+		Percent: percent,
+		Message: message,
+		Time:    metav1.Now(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if !r.NonBlockingProgress {
+		r.Progress <- e
+		return
+	}
+	select {
+	case r.Progress <- e:
+	default:
+		klog.V(4).Infof("dropped progress event %s of %s %s", phase, kind, name)
+	}
+}
+
+// EmitResult sends the completed or failed event of the resource
+// depending on the result of its upgrade
+func (r *ResourcePatch) EmitResult(kind, name string, err error) {
+	if err != nil {
+		r.EmitProgress(PhaseFailed, kind, name, 0, "", err)
+		return
+	}
+	r.EmitProgress(PhaseCompleted, kind, name, 100, "", nil)
+}
+
+// WriteEventStream writes the events as server-sent events to w until the
+// events channel is closed, flushing each event if w is an http.Flusher.
+// It is meant to relay the Progress channel to a http client.
+func WriteEventStream(w io.Writer, events <-chan UpgradeEvent) error {
+	flusher, _ := w.(http.Flusher)
+	for e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Phase, data)
+		if err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestResourcePatch_EmitProgress(t *testing.T) {
+	// a nil channel is a no-op
+	NewResourcePatch().EmitProgress(PhaseStarted, "cstorVolume", "pvc-1", 0, "", nil)
+
+	events := make(chan UpgradeEvent, 1)
+	r := NewResourcePatch(WithProgress(events, true))
+	r.EmitProgress(PhaseStarted, "cstorVolume", "pvc-1", 0, "", nil)
+	// the channel is full, so the event is dropped instead of blocking
+	r.EmitResult("cstorVolume", "pvc-1", errors.New("patch failed"))
+	e := <-events
+	if e.Phase != PhaseStarted || e.Kind != "cstorVolume" || e.Name != "pvc-1" || e.Time.IsZero() {
+		t.Errorf("EmitProgress() sent %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("EmitResult() sent %+v to a full non-blocking channel", e)
+	default:
+	}
+
+	r.EmitResult("cstorVolume", "pvc-1", errors.New("patch failed"))
+	if e := <-events; e.Phase != PhaseFailed || e.Error != "patch failed" {
+		t.Errorf("EmitResult() sent %+v, want the failed event", e)
+	}
+	r.EmitResult("cstorVolume", "pvc-1", nil)
+	if e := <-events; e.Phase != PhaseCompleted || e.Percent != 100 {
+		t.Errorf("EmitResult() sent %+v, want the completed event", e)
+	}
+}
+
+func TestWriteEventStream(t *testing.T) {
+	events := make(chan UpgradeEvent, 2)
+	events <- UpgradeEvent{Phase: PhaseStarted, Kind: "cstorPoolCluster", Name: "cspc-a"}
+	events <- UpgradeEvent{Phase: PhaseProgress, Kind: "cstorPoolCluster", Name: "cspc-a", Percent: 45}
+	close(events)
+
+	var b bytes.Buffer
+	err := WriteEventStream(&b, events)
+	if err != nil {
+		t.Fatalf("WriteEventStream() error = %v", err)
+	}
+	messages := strings.Split(strings.TrimSuffix(b.String(), "\n\n"), "\n\n")
+	if len(messages) != 2 {
+		t.Fatalf("WriteEventStream() wrote %d events, want 2: %q", len(messages), b.String())
+	}
+	lines := strings.Split(messages[1], "\n")
+	if len(lines) != 2 || lines[0] != "event: Progress" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("WriteEventStream() wrote %q", messages[1])
+	}
+	got := UpgradeEvent{}
+	err = json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &got)
+	if err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", lines[1], err)
+	}
+	if got.Percent != 45 || got.Name != "cspc-a" {
+		t.Errorf("WriteEventStream() data = %+v", got)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog"
+)
+
+// RBACPatch is the patch required to upgrade the rbac
+// resources of the operators
+type RBACPatch struct {
+	*ResourcePatch
+	ClusterRoles        []rbacv1.ClusterRole
+	ClusterRoleBindings []rbacv1.ClusterRoleBinding
+	*Client
+}
+
+// RBACPatchOptions ...
+type RBACPatchOptions func(*RBACPatch)
+
+// WithRBACResorcePatch ...
+func WithRBACResorcePatch(r *ResourcePatch) RBACPatchOptions {
+	return func(obj *RBACPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithRBACClient ...
+func WithRBACClient(c *Client) RBACPatchOptions {
+	return func(obj *RBACPatch) {
+		obj.Client = c
+	}
+}
+
+// NewRBACPatch ...
+func NewRBACPatch(opts ...RBACPatchOptions) *RBACPatch {
+	obj := &RBACPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// rbacDiff is the difference between the current and
+// the expected state of a rbac resource
+type rbacDiff struct {
+	added   []string
+	removed []string
+}
+
+// Upgrade execute the steps to upgrade the rbac resources
+func (obj *RBACPatch) Upgrade() error {
+	if obj.namespaceScoped {
+		return newValidationError(
+			errors.Errorf("the rbac upgrade needs cluster scoped permissions, it is not supported in namespace scoped mode"),
+		)
+	}
+	err := obj.Init()
+	if err != nil {
+		return newValidationError(err)
+	}
+	for i := range obj.ClusterRoles {
+		err = obj.upgradeClusterRole(&obj.ClusterRoles[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range obj.ClusterRoleBindings {
+		err = obj.upgradeClusterRoleBinding(&obj.ClusterRoleBindings[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Init reads the expected rbac resources for the target version
+// from the embedded manifests
+func (obj *RBACPatch) Init() error {
+	manifest, ok := rbacManifests[strings.Split(obj.To, "-")[0]]
+	if !ok {
+		return errors.Errorf("no rbac manifest found for version %s", obj.To)
+	}
+	reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(manifest)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read rbac manifest for %s", obj.To)
+		}
+		typeMeta := metav1.TypeMeta{}
+		err = yaml.Unmarshal(doc, &typeMeta)
+		if err != nil {
+			return err
+		}
+		switch typeMeta.Kind {
+		case "ClusterRole":
+			cr := rbacv1.ClusterRole{}
+			err = yaml.Unmarshal(doc, &cr)
+			if err != nil {
+				return err
+			}
+			obj.ClusterRoles = append(obj.ClusterRoles, cr)
+		case "ClusterRoleBinding":
+			crb := rbacv1.ClusterRoleBinding{}
+			err = yaml.Unmarshal(doc, &crb)
+			if err != nil {
+				return err
+			}
+			// the service accounts live in the openebs namespace
+			for i := range crb.Subjects {
+				if crb.Subjects[i].Kind == rbacv1.ServiceAccountKind {
+					crb.Subjects[i].Namespace = obj.OpenebsNamespace
+				}
+			}
+			obj.ClusterRoleBindings = append(obj.ClusterRoleBindings, crb)
+		case "":
+			continue
+		default:
+			return errors.Errorf("unsupported kind %s in rbac manifest", typeMeta.Kind)
+		}
+	}
+	return nil
+}
+
+func (obj *RBACPatch) upgradeClusterRole(expected *rbacv1.ClusterRole) error {
+	client := obj.KubeClientset.RbacV1().ClusterRoles()
+	current, err := client.Get(context.TODO(), expected.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		klog.Infof("clusterrole %s: creating with %d rules", expected.Name, len(expected.Rules))
+		_, err = client.Create(context.TODO(), expected, metav1.CreateOptions{})
+		return newAPIError(newObjectError("create", "clusterrole", "", expected.Name, err))
+	}
+	if err != nil {
+		return newAPIError(newObjectError("get", "clusterrole", "", expected.Name, err))
+	}
+	diff := diffPolicyRules(current.Rules, expected.Rules)
+	if !obj.applyDiff("clusterrole", expected.Name, diff) {
+		return nil
+	}
+	if len(diff.removed) != 0 && !obj.AllowRBACRemoval {
+		return newValidationError(
+			errors.Errorf("clusterrole %s: refusing to remove %d rules without confirmation",
+				expected.Name, len(diff.removed)),
+		)
+	}
+	current.Rules = expected.Rules
+	_, err = client.Update(context.TODO(), current, metav1.UpdateOptions{})
+	return newAPIError(newObjectError("update", "clusterrole", "", expected.Name, err))
+}
+
+func (obj *RBACPatch) upgradeClusterRoleBinding(expected *rbacv1.ClusterRoleBinding) error {
+	client := obj.KubeClientset.RbacV1().ClusterRoleBindings()
+	current, err := client.Get(context.TODO(), expected.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		klog.Infof("clusterrolebinding %s: creating with %d subjects", expected.Name, len(expected.Subjects))
+		_, err = client.Create(context.TODO(), expected, metav1.CreateOptions{})
+		return newAPIError(newObjectError("create", "clusterrolebinding", "", expected.Name, err))
+	}
+	if err != nil {
+		return newAPIError(newObjectError("get", "clusterrolebinding", "", expected.Name, err))
+	}
+	// roleRef of a binding is immutable and can't be patched
+	if current.RoleRef != expected.RoleRef {
+		return newValidationError(
+			errors.Errorf("clusterrolebinding %s: roleRef %s/%s does not match expected %s/%s",
+				expected.Name, current.RoleRef.Kind, current.RoleRef.Name,
+				expected.RoleRef.Kind, expected.RoleRef.Name),
+		)
+	}
+	diff := diffSubjects(current.Subjects, expected.Subjects)
+	if !obj.applyDiff("clusterrolebinding", expected.Name, diff) {
+		return nil
+	}
+	if len(diff.removed) != 0 && !obj.AllowRBACRemoval {
+		return newValidationError(
+			errors.Errorf("clusterrolebinding %s: refusing to remove %d subjects without confirmation",
+				expected.Name, len(diff.removed)),
+		)
+	}
+	current.Subjects = expected.Subjects
+	_, err = client.Update(context.TODO(), current, metav1.UpdateOptions{})
+	return newAPIError(newObjectError("update", "clusterrolebinding", "", expected.Name, err))
+}
+
+// applyDiff logs the changes for the given resource and
+// returns whether there is anything to apply
+func (obj *RBACPatch) applyDiff(kind, name string, diff rbacDiff) bool {
+	if len(diff.added) == 0 && len(diff.removed) == 0 {
+		klog.Infof("%s %s: already up to date", kind, name)
+		return false
+	}
+	for _, a := range diff.added {
+		klog.Infof("%s %s: adding %s", kind, name, a)
+	}
+	for _, r := range diff.removed {
+		klog.Warningf("%s %s: removing %s", kind, name, r)
+	}
+	return true
+}
+
+// diffPolicyRules flattens the rules into individual permissions
+// and returns the permissions added and removed by the expected rules
+func diffPolicyRules(current, expected []rbacv1.PolicyRule) rbacDiff {
+	return diffSets(flattenPolicyRules(current), flattenPolicyRules(expected))
+}
+
+// diffSubjects returns the subjects added and removed
+// by the expected subjects
+func diffSubjects(current, expected []rbacv1.Subject) rbacDiff {
+	return diffSets(flattenSubjects(current), flattenSubjects(expected))
+}
+
+func flattenPolicyRules(rules []rbacv1.PolicyRule) map[string]bool {
+	perms := map[string]bool{}
+	for _, rule := range rules {
+		for _, verb := range rule.Verbs {
+			for _, url := range rule.NonResourceURLs {
+				perms["nonResourceURL "+url+" verb "+verb] = true
+			}
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					perm := "apiGroup " + group + " resource " + resource
+					if len(rule.ResourceNames) == 0 {
+						perms[perm+" verb "+verb] = true
+						continue
+					}
+					for _, name := range rule.ResourceNames {
+						perms[perm+" name "+name+" verb "+verb] = true
+					}
+				}
+			}
+		}
+	}
+	return perms
+}
+
+func flattenSubjects(subjects []rbacv1.Subject) map[string]bool {
+	subs := map[string]bool{}
+	for _, s := range subjects {
+		subs[s.Kind+" "+s.Namespace+"/"+s.Name] = true
+	}
+	return subs
+}
+
+func diffSets(current, expected map[string]bool) rbacDiff {
+	diff := rbacDiff{}
+	for k := range expected {
+		if !current[k] {
+			diff.added = append(diff.added, k)
+		}
+	}
+	for k := range current {
+		if !expected[k] {
+			diff.removed = append(diff.removed, k)
+		}
+	}
+	sort.Strings(diff.added)
+	sort.Strings(diff.removed)
+	return diff
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_diffPolicyRules(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     []rbacv1.PolicyRule
+		expected    []rbacv1.PolicyRule
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name: "no change",
+			current: []rbacv1.PolicyRule{
+				{APIGroups: []string{"*"}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}},
+			},
+			expected: []rbacv1.PolicyRule{
+				{APIGroups: []string{"*"}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+				{APIGroups: []string{"*"}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+			},
+		},
+		{
+			name: "new verb",
+			current: []rbacv1.PolicyRule{
+				{APIGroups: []string{"*"}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+			},
+			expected: []rbacv1.PolicyRule{
+				{APIGroups: []string{"*"}, Resources: []string{"pods"}, Verbs: []string{"get", "watch"}},
+			},
+			wantAdded: []string{"apiGroup * resource pods verb watch"},
+		},
+		{
+			name: "removed api group",
+			current: []rbacv1.PolicyRule{
+				{APIGroups: []string{"openebs.io", "cstor.openebs.io"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+			},
+			expected: []rbacv1.PolicyRule{
+				{APIGroups: []string{"cstor.openebs.io"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+			},
+			wantRemoved: []string{"apiGroup openebs.io resource * verb *"},
+		},
+		{
+			name: "non resource urls",
+			current: []rbacv1.PolicyRule{
+				{NonResourceURLs: []string{"/metrics"}, Verbs: []string{"get"}},
+			},
+			expected: []rbacv1.PolicyRule{
+				{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+			},
+			wantAdded:   []string{"nonResourceURL /healthz verb get"},
+			wantRemoved: []string{"nonResourceURL /metrics verb get"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffPolicyRules(tt.current, tt.expected)
+			if !reflect.DeepEqual(got.added, tt.wantAdded) {
+				t.Errorf("diffPolicyRules() added = %v, want %v", got.added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(got.removed, tt.wantRemoved) {
+				t.Errorf("diffPolicyRules() removed = %v, want %v", got.removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestRBACPatchInit(t *testing.T) {
+	obj := NewRBACPatch(
+		WithRBACResorcePatch(NewResourcePatch(
+			ToVersion("3.0.0-RC1"),
+			WithOpenebsNamespace("storage"),
+		)),
+	)
+	if err := obj.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if len(obj.ClusterRoles) != 1 || len(obj.ClusterRoleBindings) != 1 {
+		t.Fatalf("Init() got %d clusterroles and %d clusterrolebindings, want 1 and 1",
+			len(obj.ClusterRoles), len(obj.ClusterRoleBindings))
+	}
+	if ns := obj.ClusterRoleBindings[0].Subjects[0].Namespace; ns != "storage" {
+		t.Errorf("Init() subject namespace = %s, want storage", ns)
+	}
+}
+
+func TestRBACPatch_Upgrade(t *testing.T) {
+	expected := NewRBACPatch(
+		WithRBACResorcePatch(NewResourcePatch(ToVersion("3.0.0"), WithOpenebsNamespace(upgradetesting.Namespace))),
+	)
+	if err := expected.Init(); err != nil {
+		t.Fatal(err)
+	}
+	role := expected.ClusterRoles[0]
+	binding := expected.ClusterRoleBindings[0]
+	extraRule := rbacv1.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"secrets"}, Verbs: []string{"delete"}}
+	withRules := func(rules ...rbacv1.PolicyRule) *rbacv1.ClusterRole {
+		cr := role.DeepCopy()
+		cr.Rules = rules
+		return cr
+	}
+	withRoleRef := func(name string) *rbacv1.ClusterRoleBinding {
+		crb := binding.DeepCopy()
+		crb.RoleRef.Name = name
+		return crb
+	}
+	tests := []struct {
+		name        string
+		objs        []runtime.Object
+		allowRemove bool
+		wantKind    error
+		wantRules   []rbacv1.PolicyRule
+	}{
+		{name: "created", wantRules: role.Rules},
+		{name: "rules added", objs: []runtime.Object{withRules(), binding.DeepCopy()}, wantRules: role.Rules},
+		{
+			name:     "removal refused",
+			objs:     []runtime.Object{withRules(append(role.Rules, extraRule)...)},
+			wantKind: ErrValidation,
+		},
+		{
+			name:        "removal allowed",
+			objs:        []runtime.Object{withRules(append(role.Rules, extraRule)...)},
+			allowRemove: true,
+			wantRules:   role.Rules,
+		},
+		{name: "roleRef mismatch", objs: []runtime.Object{withRoleRef("other")}, wantKind: ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objs...)
+			obj := NewRBACPatch(
+				WithRBACResorcePatch(NewResourcePatch(
+					ToVersion("3.0.0"),
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					WithAllowRBACRemoval(tt.allowRemove),
+				)),
+				WithRBACClient(client),
+			)
+			err := obj.Upgrade()
+			if tt.wantKind != nil {
+				if !errors.Is(err, tt.wantKind) {
+					t.Fatalf("Upgrade() error = %v, want a %v error", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Upgrade() error = %v", err)
+			}
+			cr, err := client.KubeClientset.RbacV1().ClusterRoles().Get(context.TODO(), role.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cr.Rules, tt.wantRules) {
+				t.Errorf("clusterrole rules = %v, want %v", cr.Rules, tt.wantRules)
+			}
+			crb, err := client.KubeClientset.RbacV1().ClusterRoleBindings().
+				Get(context.TODO(), binding.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(crb.Subjects, binding.Subjects) {
+				t.Errorf("clusterrolebinding subjects = %v, want %v", crb.Subjects, binding.Subjects)
+			}
+		})
+	}
+}
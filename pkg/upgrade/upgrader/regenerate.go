@@ -0,0 +1,212 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultUpgradeJobServiceAccount is the service account
+	// the regenerated upgrade jobs are run with
+	DefaultUpgradeJobServiceAccount = "openebs-maya-operator"
+
+	// defaultUpgradeImagePrefix is the prefix of the upgrade image
+	// if the upgradetask has no image prefix
+	defaultUpgradeImagePrefix = "openebs/"
+
+	// rerunJobPrefix is the prefix of the names of the regenerated jobs
+	rerunJobPrefix = "rerun-"
+
+	// upgradeTaskAnnotation is the upgradetask a job was regenerated from
+	upgradeTaskAnnotation = "openebs.io/upgrade-task"
+
+	// maxJobNameLength keeps the job name usable as the job-name label of its pods
+	maxJobNameLength = 63
+)
+
+// upgradeJobCommands are the commands of the upgrade job
+// which upgrade the resource kinds of the upgradetasks
+var upgradeJobCommands = map[string]string{
+	"cstorPoolCluster":  "cstor-cspc",
+	"cstorPoolInstance": "cstor-cspi",
+	"cstorVolume":       "cstor-volume",
+	"jivaVolume":        "jiva-volume",
+}
+
+// UpgradeJobConfig is the config of the jobs regenerated from the upgradetasks
+type UpgradeJobConfig struct {
+	// ServiceAccount the jobs are run with
+	ServiceAccount string
+	// Image of the jobs, it defaults to the upgrade image of the
+	// image prefix of the upgradetask tagged with its to version
+	Image string
+}
+
+// ListFailedUpgradeTasks returns the upgradetasks in the
+// namespace whose phase is Error, sorted by name
+func ListFailedUpgradeTasks(namespace string, client *Client) ([]v1Alpha1API.UpgradeTask, error) {
+	utaskList, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list upgradetasks"))
+	}
+	failed := []v1Alpha1API.UpgradeTask{}
+	for _, utaskObj := range utaskList.Items {
+		if utaskObj.Status.Phase == v1Alpha1API.UpgradeError {
+			failed = append(failed, utaskObj)
+		}
+	}
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].Name < failed[j].Name
+	})
+	return failed, nil
+}
+
+// rerunJobName returns the name of the job regenerated from the
+// upgradetask, the long names are cut and suffixed with a hash of
+// the name of the upgradetask so that they stay unique
+func rerunJobName(utaskName string) string {
+	name := rerunJobPrefix + utaskName
+	if len(name) <= maxJobNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(utaskName))
+	hash := hex.EncodeToString(sum[:])[:8]
+	return strings.TrimRight(name[:maxJobNameLength-len(hash)-1], ".-") + "-" + hash
+}
+
+// NewUpgradeJob returns the job which re-runs the upgrade of the resource
+// of the upgradetask. The upgrade is configured using the env of the job
+// so that the job does not depend on the defaults of the flags.
+func NewUpgradeJob(utaskObj *v1Alpha1API.UpgradeTask, cfg UpgradeJobConfig) (*batchv1.Job, error) {
+	kind, name := upgradeTaskResource(utaskObj.Spec.ResourceSpec)
+	command, ok := upgradeJobCommands[kind]
+	if !ok {
+		return nil, newValidationError(
+			errors.Errorf("upgradetask %s has no resource to upgrade", utaskObj.Name),
+		)
+	}
+	if utaskObj.Spec.ToVersion == "" {
+		return nil, newValidationError(
+			errors.Errorf("upgradetask %s has no to version", utaskObj.Name),
+		)
+	}
+	image := cfg.Image
+	if image == "" {
+		prefix := utaskObj.Spec.ImagePrefix
+		if prefix == "" {
+			prefix = defaultUpgradeImagePrefix
+		}
+		tag := utaskObj.Spec.ImageTag
+		if tag == "" {
+			tag = utaskObj.Spec.ToVersion
+		}
+		image = prefix + "upgrade:" + tag
+	}
+	serviceAccount := cfg.ServiceAccount
+	if serviceAccount == "" {
+		serviceAccount = DefaultUpgradeJobServiceAccount
+	}
+	env := []corev1.EnvVar{
+		{
+			Name: EnvOpenebsNamespace,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+			},
+		},
+		{Name: EnvResourceName, Value: name},
+		{Name: EnvFromVersion, Value: utaskObj.Spec.FromVersion},
+		{Name: EnvToVersion, Value: utaskObj.Spec.ToVersion},
+	}
+	if utaskObj.Spec.ImageTag != "" {
+		env = append(env, corev1.EnvVar{Name: EnvToVersionImageTag, Value: utaskObj.Spec.ImageTag})
+	}
+	if utaskObj.Spec.ImagePrefix != "" {
+		env = append(env, corev1.EnvVar{Name: EnvToVersionImagePrefix, Value: utaskObj.Spec.ImagePrefix})
+	}
+	backoffLimit := int32(4)
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        rerunJobName(utaskObj.Name),
+			Namespace:   utaskObj.Namespace,
+			Annotations: map[string]string{upgradeTaskAnnotation: utaskObj.Name},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					ServiceAccountName: serviceAccount,
+					RestartPolicy:      corev1.RestartPolicyOnFailure,
+					Containers: []corev1.Container{
+						{
+							Name:            "upgrade",
+							Image:           image,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Args:            []string{command},
+							Env:             env,
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// RegenerateUpgradeJobs returns the jobs which re-run the upgrades
+// of the failed upgradetasks in the namespace
+func RegenerateUpgradeJobs(namespace string, cfg UpgradeJobConfig,
+	client *Client) ([]*batchv1.Job, error) {
+	utasks, err := ListFailedUpgradeTasks(namespace, client)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []*batchv1.Job{}
+	for i := range utasks {
+		jobObj, err := NewUpgradeJob(&utasks[i], cfg)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, jobObj)
+	}
+	return jobs, nil
+}
+
+// CreateUpgradeJob creates the regenerated upgrade job, a job of the
+// same name is not replaced as it may still be re-running the upgrade
+func CreateUpgradeJob(jobObj *batchv1.Job, client *Client) error {
+	_, err := client.KubeClientset.BatchV1().Jobs(jobObj.Namespace).
+		Create(context.TODO(), jobObj, metav1.CreateOptions{})
+	if err != nil {
+		return newObjectError("create", "job", jobObj.Namespace, jobObj.Name, err)
+	}
+	klog.Infof("Created job %s to re-run the upgrade of upgradetask %s",
+		jobObj.Name, jobObj.Annotations[upgradeTaskAnnotation])
+	return nil
+}
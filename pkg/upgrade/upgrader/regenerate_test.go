@@ -0,0 +1,201 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testFailedUpgradeTask(name string, phase v1Alpha1API.UpgradePhase,
+	spec v1Alpha1API.ResourceSpec) *v1Alpha1API.UpgradeTask {
+	return &v1Alpha1API.UpgradeTask{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: upgradetesting.Namespace},
+		Spec: v1Alpha1API.UpgradeTaskSpec{
+			FromVersion:  "2.12.0",
+			ToVersion:    "3.0.0",
+			ResourceSpec: spec,
+		},
+		Status: v1Alpha1API.UpgradeTaskStatus{Phase: phase},
+	}
+}
+
+func TestNewUpgradeJob(t *testing.T) {
+	volumeSpec := v1Alpha1API.ResourceSpec{CStorVolume: &v1Alpha1API.CStorVolume{PVName: "pvc-1"}}
+	tests := []struct {
+		name        string
+		utask       *v1Alpha1API.UpgradeTask
+		cfg         UpgradeJobConfig
+		wantErr     bool
+		wantImage   string
+		wantCommand string
+		wantEnv     map[string]string
+	}{
+		{
+			name:        "cstor volume",
+			utask:       testFailedUpgradeTask("upgrade-cstor-csi-volume-pvc-1", v1Alpha1API.UpgradeError, volumeSpec),
+			wantImage:   "openebs/upgrade:3.0.0",
+			wantCommand: "cstor-volume",
+			wantEnv: map[string]string{
+				EnvResourceName: "pvc-1",
+				EnvFromVersion:  "2.12.0",
+				EnvToVersion:    "3.0.0",
+			},
+		},
+		{
+			name: "custom image prefix and tag",
+			utask: func() *v1Alpha1API.UpgradeTask {
+				utaskObj := testFailedUpgradeTask("upgrade-cstor-cspc-cspc-a", v1Alpha1API.UpgradeError,
+					v1Alpha1API.ResourceSpec{CStorPoolCluster: &v1Alpha1API.CStorPoolCluster{CSPCName: "cspc-a"}})
+				utaskObj.Spec.ImagePrefix = "quay.io/openebs/"
+				utaskObj.Spec.ImageTag = "ci"
+				return utaskObj
+			}(),
+			wantImage:   "quay.io/openebs/upgrade:ci",
+			wantCommand: "cstor-cspc",
+			wantEnv: map[string]string{
+				EnvResourceName:         "cspc-a",
+				EnvFromVersion:          "2.12.0",
+				EnvToVersion:            "3.0.0",
+				EnvToVersionImageTag:    "ci",
+				EnvToVersionImagePrefix: "quay.io/openebs/",
+			},
+		},
+		{
+			name: "image override",
+			utask: testFailedUpgradeTask("upgrade-jiva-csi-volume-pvc-2", v1Alpha1API.UpgradeError,
+				v1Alpha1API.ResourceSpec{JivaVolume: &v1Alpha1API.JivaVolume{PVName: "pvc-2"}}),
+			cfg:         UpgradeJobConfig{Image: "registry.local/upgrade:3.0.0-fix"},
+			wantImage:   "registry.local/upgrade:3.0.0-fix",
+			wantCommand: "jiva-volume",
+			wantEnv: map[string]string{
+				EnvResourceName: "pvc-2",
+				EnvFromVersion:  "2.12.0",
+				EnvToVersion:    "3.0.0",
+			},
+		},
+		{
+			name:    "no resource",
+			utask:   testFailedUpgradeTask("upgrade-unknown", v1Alpha1API.UpgradeError, v1Alpha1API.ResourceSpec{}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobObj, err := NewUpgradeJob(tt.utask, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewUpgradeJob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrValidation) {
+					t.Errorf("NewUpgradeJob() error = %v, want a validation error", err)
+				}
+				return
+			}
+			if jobObj.Namespace != upgradetesting.Namespace || jobObj.Annotations[upgradeTaskAnnotation] != tt.utask.Name {
+				t.Errorf("job %s/%s annotations = %v, want the upgradetask %s",
+					jobObj.Namespace, jobObj.Name, jobObj.Annotations, tt.utask.Name)
+			}
+			podSpec := jobObj.Spec.Template.Spec
+			if podSpec.ServiceAccountName != DefaultUpgradeJobServiceAccount {
+				t.Errorf("service account = %s, want %s", podSpec.ServiceAccountName, DefaultUpgradeJobServiceAccount)
+			}
+			container := podSpec.Containers[0]
+			if container.Image != tt.wantImage {
+				t.Errorf("image = %s, want %s", container.Image, tt.wantImage)
+			}
+			if want := []string{tt.wantCommand}; !reflect.DeepEqual(container.Args, want) {
+				t.Errorf("args = %v, want %v", container.Args, want)
+			}
+			env := map[string]string{}
+			for _, e := range container.Env {
+				if e.Name == EnvOpenebsNamespace {
+					if e.ValueFrom == nil || e.ValueFrom.FieldRef == nil {
+						t.Errorf("env %s = %v, want the namespace of the pod", e.Name, e)
+					}
+					continue
+				}
+				env[e.Name] = e.Value
+			}
+			if !reflect.DeepEqual(env, tt.wantEnv) {
+				t.Errorf("env = %v, want %v", env, tt.wantEnv)
+			}
+		})
+	}
+}
+
+func Test_rerunJobName(t *testing.T) {
+	if got := rerunJobName("upgrade-cstor-cspc-cspc-a"); got != "rerun-upgrade-cstor-cspc-cspc-a" {
+		t.Errorf("rerunJobName() = %s, want rerun-upgrade-cstor-cspc-cspc-a", got)
+	}
+	long := "upgrade-cstor-csi-volume-pvc-5fdce1bf-2cfc-4692-8353-8bc66deace49"
+	got := rerunJobName(long)
+	if len(got) > maxJobNameLength || !strings.HasPrefix(got, "rerun-upgrade-cstor-csi-volume-") {
+		t.Errorf("rerunJobName() = %s, want a name of at most %d characters", got, maxJobNameLength)
+	}
+	if other := rerunJobName(long + "0"); other == got {
+		t.Errorf("rerunJobName() = %s for different upgradetasks", got)
+	}
+}
+
+func TestRegenerateUpgradeJobs(t *testing.T) {
+	client := NewTestClient(
+		testFailedUpgradeTask("upgrade-cstor-csi-volume-pvc-2", v1Alpha1API.UpgradeError,
+			v1Alpha1API.ResourceSpec{CStorVolume: &v1Alpha1API.CStorVolume{PVName: "pvc-2"}}),
+		testFailedUpgradeTask("upgrade-cstor-csi-volume-pvc-1", v1Alpha1API.UpgradeError,
+			v1Alpha1API.ResourceSpec{CStorVolume: &v1Alpha1API.CStorVolume{PVName: "pvc-1"}}),
+		testFailedUpgradeTask("upgrade-cstor-cspc-cspc-a", v1Alpha1API.UpgradeSuccess,
+			v1Alpha1API.ResourceSpec{CStorPoolCluster: &v1Alpha1API.CStorPoolCluster{CSPCName: "cspc-a"}}),
+		testFailedUpgradeTask("upgrade-cstor-cspc-cspc-b", v1Alpha1API.UpgradeStarted,
+			v1Alpha1API.ResourceSpec{CStorPoolCluster: &v1Alpha1API.CStorPoolCluster{CSPCName: "cspc-b"}}),
+	)
+	jobs, err := RegenerateUpgradeJobs(upgradetesting.Namespace, UpgradeJobConfig{}, client)
+	if err != nil {
+		t.Fatalf("RegenerateUpgradeJobs() error = %v", err)
+	}
+	got := []string{}
+	for _, jobObj := range jobs {
+		got = append(got, jobObj.Name)
+	}
+	want := []string{"rerun-upgrade-cstor-csi-volume-pvc-1", "rerun-upgrade-cstor-csi-volume-pvc-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("RegenerateUpgradeJobs() = %v, want %v", got, want)
+	}
+
+	if err := CreateUpgradeJob(jobs[0], client); err != nil {
+		t.Fatalf("CreateUpgradeJob() error = %v", err)
+	}
+	jobObj, err := client.KubeClientset.BatchV1().Jobs(upgradetesting.Namespace).
+		Get(context.TODO(), jobs[0].Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get job: %v", err)
+	}
+	if policy := jobObj.Spec.Template.Spec.RestartPolicy; policy != corev1.RestartPolicyOnFailure {
+		t.Errorf("restart policy = %s, want %s", policy, corev1.RestartPolicyOnFailure)
+	}
+	if err := CreateUpgradeJob(jobs[0], client); err == nil {
+		t.Errorf("CreateUpgradeJob() error = nil for an existing job")
+	}
+}
@@ -27,6 +27,14 @@ func (u *Upgrade) RegisterAll() *Upgrade {
 	u.registerUpgrade("cstorPoolCluster", RegisterCstorPoolCluster)
 	u.registerUpgrade("cstorVolume", RegisterCstorVolume)
 	u.registerUpgrade("jivaVolume", RegisterJivaVolume)
+	u.registerUpgrade("rbac", RegisterRBAC)
+	u.registerUpgrade("snapshotClass", RegisterSnapshotClass)
+	u.registerUpgrade("storageClass", RegisterStorageClass)
+	u.registerUpgrade("etcd", RegisterEtcd)
+	u.registerUpgrade("podSecurity", RegisterPodSecurity)
+	u.registerUpgrade("cstorPoolInstanceCRD", RegisterCStorPoolInstanceCRD)
+	u.registerUpgrade("cstorEngineConfig", RegisterCStorEngineConfig)
+	u.registerUpgrade("cstorVolumeClaimAPI", RegisterCStorVolumeClaimAPI)
 	return u
 }
 
@@ -35,6 +43,7 @@ func RegisterCstorPoolInstance(r *ResourcePatch, c *Client) Upgrader {
 	obj := NewCSPIPatch(
 		WithCSPIResorcePatch(r),
 		WithCSPIClient(c),
+		WithCSPICheckCSPC(true),
 	)
 	return obj
 }
@@ -65,3 +74,76 @@ func RegisterJivaVolume(r *ResourcePatch, c *Client) Upgrader {
 	)
 	return obj
 }
+
+// RegisterRBAC ...
+func RegisterRBAC(r *ResourcePatch, c *Client) Upgrader {
+	obj := NewRBACPatch(
+		WithRBACResorcePatch(r),
+		WithRBACClient(c),
+	)
+	return obj
+}
+
+// RegisterSnapshotClass ...
+func RegisterSnapshotClass(r *ResourcePatch, c *Client) Upgrader {
+	obj := NewSnapshotClassPatch(
+		WithSnapshotClassResorcePatch(r),
+		WithSnapshotClassClient(c),
+	)
+	return obj
+}
+
+// RegisterStorageClass ...
+func RegisterStorageClass(r *ResourcePatch, c *Client) Upgrader {
+	obj := NewStorageClassMigrator(
+		WithStorageClassResorcePatch(r),
+		WithStorageClassClient(c),
+	)
+	return obj
+}
+
+// RegisterEtcd ...
+func RegisterEtcd(r *ResourcePatch, c *Client) Upgrader {
+	obj := NewEtcdPatch(
+		WithEtcdResorcePatch(r),
+		WithEtcdClient(c),
+	)
+	return obj
+}
+
+// RegisterPodSecurity ...
+func RegisterPodSecurity(r *ResourcePatch, c *Client) Upgrader {
+	obj := NewPodSecurityPatch(
+		WithPodSecurityResorcePatch(r),
+		WithPodSecurityClient(c),
+	)
+	return obj
+}
+
+// RegisterCStorPoolInstanceCRD ...
+func RegisterCStorPoolInstanceCRD(r *ResourcePatch, c *Client) Upgrader {
+	obj := NewCRDMigrator(
+		WithCRDMigratorResorcePatch(r),
+		WithCRDMigratorClient(c),
+		WithCRDMigratorResource("cstor.openebs.io", "cstorpoolinstances", "CStorPoolInstance"),
+	)
+	return obj
+}
+
+// RegisterCStorEngineConfig ...
+func RegisterCStorEngineConfig(r *ResourcePatch, c *Client) Upgrader {
+	obj := NewCDEConfigPatch(
+		WithCDEConfigResorcePatch(r),
+		WithCDEConfigClient(c),
+	)
+	return obj
+}
+
+// RegisterCStorVolumeClaimAPI ...
+func RegisterCStorVolumeClaimAPI(r *ResourcePatch, c *Client) Upgrader {
+	obj := NewCVCAPIMigrator(
+		WithCVCAPIMigratorResorcePatch(r),
+		WithCVCAPIMigratorClient(c),
+	)
+	return obj
+}
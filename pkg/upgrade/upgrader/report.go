@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ReportJSON and ReportText are the supported report formats
+	ReportJSON = "json"
+	ReportText = "text"
+
+	// reportKey is the key of the result configmap the report is stored in
+	reportKey = "report.json"
+)
+
+// PostUpgradeReport summarizes the outcome of all the resources
+// upgraded in a run, for the monitoring tools to consume
+type PostUpgradeReport struct {
+	Timestamp metav1.Time `json:"timestamp"`
+	From      string      `json:"fromVersion"`
+	To        string      `json:"toVersion"`
+	// Duration is the time taken by the whole run
+	Duration  metav1.Duration  `json:"duration"`
+	Upgraded  int              `json:"upgraded"`
+	Skipped   int              `json:"skipped"`
+	Failed    int              `json:"failed"`
+	Resources []ResourceResult `json:"resources"`
+}
+
+// ValidateReportFormat returns a validation error
+// if the report format is not supported
+func ValidateReportFormat(format string) error {
+	switch format {
+	case ReportJSON, ReportText:
+		return nil
+	}
+	return newValidationError(
+		errors.Errorf("invalid report format %q, expected one of json or text", format),
+	)
+}
+
+// Report returns the report of the resources recorded so far,
+// it returns nil if the result is nil
+func (r *UpgradeResult) Report() *PostUpgradeReport {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := metav1.Now()
+	report := &PostUpgradeReport{
+		Timestamp: now,
+		From:      r.From,
+		To:        r.To,
+		Duration:  metav1.Duration{Duration: now.Sub(r.StartTime.Time)},
+		Resources: append([]ResourceResult{}, r.Resources...),
+	}
+	for _, res := range r.Resources {
+		switch res.Status {
+		case ResultSuccess:
+			report.Upgraded++
+		case ResultSkipped:
+			report.Skipped++
+		case ResultFailed:
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// SaveReport saves the report of the result in the configmap of
+// the result, it is a no-op if the result is nil or has no name
+func (r *UpgradeResult) SaveReport(client *Client) error {
+	if r == nil || r.Name == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.Report(), "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal upgrade report")
+	}
+	return r.saveData(client, reportKey, data)
+}
+
+// Write writes the report to w in the given report format
+func (p *PostUpgradeReport) Write(w io.Writer, format string) error {
+	err := ValidateReportFormat(format)
+	if err != nil {
+		return err
+	}
+	if format == ReportJSON {
+		out, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal upgrade report")
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	fmt.Fprintf(w, "Upgrade from %s to %s at %s took %s\n",
+		p.From, p.To, p.Timestamp.UTC().Format(time.RFC3339), p.Duration.Round(time.Second))
+	fmt.Fprintf(w, "Upgraded: %d, Skipped: %d, Failed: %d\n\n", p.Upgraded, p.Skipped, p.Failed)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSTATUS\tDURATION\tMESSAGE")
+	for _, res := range p.Resources {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			res.Kind, res.Name, res.Status, res.Duration.Round(time.Second), res.Message)
+	}
+	return tw.Flush()
+}
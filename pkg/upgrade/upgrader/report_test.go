@@ -0,0 +1,123 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestPartialResult(name string) *UpgradeResult {
+	result := NewUpgradeResult(name, "openebs", "2.12.0", "3.0.0")
+	result.Start("cstorPoolInstance", "cspi-1")
+	result.Add("cstorPoolInstance", "cspi-1", nil)
+	result.AddSkipped("cstorPoolInstance", "cspi-2", "already at target version")
+	result.Start("cstorPoolInstance", "cspi-3")
+	result.Add("cstorPoolInstance", "cspi-3", errors.New("failed to patch"))
+	return result
+}
+
+func TestUpgradeResult_Report(t *testing.T) {
+	report := newTestPartialResult("").Report()
+	if report.Upgraded != 1 || report.Skipped != 1 || report.Failed != 1 {
+		t.Errorf("Report() = %d upgraded, %d skipped, %d failed, want 1 of each",
+			report.Upgraded, report.Skipped, report.Failed)
+	}
+	if report.From != "2.12.0" || report.To != "3.0.0" {
+		t.Errorf("Report() versions = %s to %s", report.From, report.To)
+	}
+	if len(report.Resources) != 3 {
+		t.Fatalf("Report() has %d resources, want 3", len(report.Resources))
+	}
+	if report.Resources[1].Duration.Duration != 0 {
+		t.Errorf("skipped resource duration = %v, want 0", report.Resources[1].Duration)
+	}
+	var nilResult *UpgradeResult
+	if nilResult.Report() != nil {
+		t.Errorf("Report() of a nil result is not nil")
+	}
+}
+
+func TestUpgradeResult_SaveReport(t *testing.T) {
+	client := NewTestClient()
+	result := newTestPartialResult("upgrade-result-test")
+	if err := result.Save(client); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := result.SaveReport(client); err != nil {
+		t.Fatalf("SaveReport() error = %v", err)
+	}
+	cmObj, err := client.KubeClientset.CoreV1().ConfigMaps("openebs").
+		Get(context.TODO(), "upgrade-result-test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get configmap: %v", err)
+	}
+	if cmObj.Data[resultKey] == "" {
+		t.Errorf("SaveReport() removed the result from the configmap")
+	}
+	got := PostUpgradeReport{}
+	if err := json.Unmarshal([]byte(cmObj.Data[reportKey]), &got); err != nil {
+		t.Fatalf("failed to unmarshal report: %v", err)
+	}
+	if got.Failed != 1 || got.Upgraded != 1 {
+		t.Errorf("saved report = %d upgraded, %d failed, want 1 of each", got.Upgraded, got.Failed)
+	}
+
+	// the result without a name is not saved
+	if err := newTestPartialResult("").SaveReport(nil); err != nil {
+		t.Errorf("SaveReport() error = %v", err)
+	}
+}
+
+func TestPostUpgradeReport_Write(t *testing.T) {
+	report := newTestPartialResult("").Report()
+	tests := []struct {
+		format  string
+		want    []string
+		wantErr bool
+	}{
+		{format: ReportJSON, want: []string{`"failed": 1`, `"fromVersion": "2.12.0"`, `"name": "cspi-3"`}},
+		{format: ReportText, want: []string{"Upgraded: 1, Skipped: 1, Failed: 1", "cspi-3", "failed to patch"}},
+		{format: "yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			err := report.Write(buf, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrValidation) {
+					t.Errorf("Write() error = %v, want a validation error", err)
+				}
+				return
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("Write() = %s, want it to contain %q", buf.String(), want)
+				}
+			}
+		})
+	}
+}
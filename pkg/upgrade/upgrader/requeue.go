@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// RequeueBaseDelay is the delay before the first retry of a failed
+	// upgradetask, which is doubled on every retry up to RequeueMaxDelay
+	RequeueBaseDelay = 30 * time.Second
+	RequeueMaxDelay  = 30 * time.Minute
+	// RequeueJitter is the fraction by which the delay is randomly
+	// increased or decreased so that the failed upgradetasks are not
+	// all retried at the same time
+	RequeueJitter = 0.2
+)
+
+// jitterRateLimiter adds a random jitter to the delays of a rate limiter
+type jitterRateLimiter struct {
+	workqueue.RateLimiter
+	jitter float64
+	mutex  sync.Mutex
+	rand   *rand.Rand
+}
+
+// NewUpgradeTaskRateLimiter returns the rate limiter used to requeue the
+// failed upgradetasks, with an exponential backoff from RequeueBaseDelay
+// to RequeueMaxDelay and a jitter of RequeueJitter
+func NewUpgradeTaskRateLimiter() workqueue.RateLimiter {
+	return newJitterRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(RequeueBaseDelay, RequeueMaxDelay),
+		RequeueJitter,
+	)
+}
+
+func newJitterRateLimiter(r workqueue.RateLimiter, jitter float64) *jitterRateLimiter {
+	return &jitterRateLimiter{
+		RateLimiter: r,
+		jitter:      jitter,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// When returns the delay of the wrapped rate limiter
+// randomly changed by up to the jitter in either direction
+func (r *jitterRateLimiter) When(item interface{}) time.Duration {
+	delay := r.RateLimiter.When(item)
+	r.mutex.Lock()
+	factor := 1 + r.jitter*(2*r.rand.Float64()-1)
+	r.mutex.Unlock()
+	return time.Duration(float64(delay) * factor)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpgradeTaskRateLimiter(t *testing.T) {
+	r := NewUpgradeTaskRateLimiter()
+	within := func(got, want time.Duration) bool {
+		min := time.Duration(float64(want) * (1 - RequeueJitter))
+		max := time.Duration(float64(want) * (1 + RequeueJitter))
+		return got >= min && got <= max
+	}
+	want := RequeueBaseDelay
+	for i := 0; i < 10; i++ {
+		got := r.When("upgrade-cstor-pvc-1")
+		if !within(got, want) {
+			t.Errorf("retry %d: delay = %s, want %s +/- %.0f%%", i, got, want, RequeueJitter*100)
+		}
+		want *= 2
+		if want > RequeueMaxDelay {
+			want = RequeueMaxDelay
+		}
+	}
+	if got := r.NumRequeues("upgrade-cstor-pvc-1"); got != 10 {
+		t.Errorf("NumRequeues() = %d, want 10", got)
+	}
+	// other items have their own backoff
+	if got := r.When("upgrade-cstor-pvc-2"); !within(got, RequeueBaseDelay) {
+		t.Errorf("delay of new item = %s, want %s", got, RequeueBaseDelay)
+	}
+	r.Forget("upgrade-cstor-pvc-1")
+	if got := r.When("upgrade-cstor-pvc-1"); !within(got, RequeueBaseDelay) {
+		t.Errorf("delay after forget = %s, want %s", got, RequeueBaseDelay)
+	}
+}
+
+func TestJitterRateLimiterSpread(t *testing.T) {
+	r := NewUpgradeTaskRateLimiter()
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		item := i
+		seen[r.When(item)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected the first delays of the items to be jittered, got %v", seen)
+	}
+}
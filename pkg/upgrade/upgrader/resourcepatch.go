@@ -16,12 +16,226 @@ limitations under the License.
 
 package upgrader
 
+import (
+	"io"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
 // ResourcePatch has all the patches required to upgrade a resource
 type ResourcePatch struct {
-	Name              string
-	OpenebsNamespace  string
-	From, To          string
+	Name             string
+	OpenebsNamespace string
+	From, To         string
+	// fromDetected is set when From is read from the resource, so that
+	// the dependants of the resource read their own from version
+	fromDetected      bool
 	ImageTag, BaseURL string
+	// globalFrom is the From set for the upgrade, which the dependants
+	// fall back to when the From of the resource is read from it
+	globalFrom string
+	// FromVersionAnnotation is the annotation of a resource that
+	// overrides its From, openebs.io/upgrade-from-version if empty
+	FromVersionAnnotation string
+	// ImageRegistry replaces the registry of all the images patched by
+	// the upgrade, for the air-gapped clusters using a registry mirror
+	ImageRegistry string
+	// VerifyOnly skips the patching of the resource and only
+	// verifies the reconciliation of the version
+	VerifyOnly bool
+	// MaxUnavailable is the maximum number or percentage of cspis
+	// of a cspc that can be in a non ONLINE state during the upgrade
+	MaxUnavailable *intstr.IntOrString
+	// MaxParallelVolumes is the maximum number of volumes upgraded at
+	// the same time by the callers upgrading volumes in batches, like the
+	// volume commands, separate from the cspis upgraded by MaxUnavailable
+	MaxParallelVolumes int
+	// MinFreePoolSpace is the minimum percentage of free space
+	// required on a cspi to upgrade it
+	MinFreePoolSpace int
+	// AllowOverprovisioned logs a warning instead of failing the
+	// upgrade if a cspi has less than MinFreePoolSpace free space
+	AllowOverprovisioned bool
+	// AllowRBACRemoval allows the rbac upgrade to remove rules
+	// and subjects that are not present in the target version
+	AllowRBACRemoval bool
+	// TransientMessages are the reconcile messages that are retried
+	// without being counted as a failure, matched as case insensitive
+	// substrings. The defaultTransientMessages are used if nil
+	TransientMessages []string
+	// ReconcileFailureThreshold is the number of non transient reconcile
+	// failures after which the verification gives up, 0 retries forever
+	ReconcileFailureThreshold int
+	// ReconcilePredicate decides when a resource is reconciled after it
+	// is patched, VersionReconciled of the To version is used if nil
+	ReconcilePredicate ReconcilePredicate
+	// ReconcileTimeout is the time after which the verification of the
+	// version reconciliation gives up, 0 waits forever
+	ReconcileTimeout time.Duration
+	// VersionStabilityPolls is the number of polls the current version
+	// must stay at the to version once it is reconciled, to catch an older
+	// operator reconciling it back, 0 stops at the first reconciled poll
+	VersionStabilityPolls int
+	// ReconcileStablePeriod is the time the current version must stay at
+	// the to version once it is reconciled, polled at the PollInterval,
+	// for operators that flap between versions. It applies along with
+	// the VersionStabilityPolls, 0 does not wait for a period
+	ReconcileStablePeriod time.Duration
+	// MaxParallelVerify is the maximum number of cspis of a cspc whose
+	// version is verified at the same time once all of them are upgraded,
+	// defaultMaxParallelVerify is used if zero
+	MaxParallelVerify int
+	// PatchReapplyAttempts is the number of times the patch of a cspc is
+	// derived again and reapplied when its reconciliation times out, for
+	// when the operator missed the first patch
+	PatchReapplyAttempts int
+	// PollInterval is the initial interval to poll for the version
+	// reconciliation, defaults to the sync time of the operators
+	PollInterval time.Duration
+	// SmokeTestStorageClass is the storageclass used to provision the
+	// smoke test volume instead of the one of the upgraded resource
+	SmokeTestStorageClass string
+	// SkipUpgradeAnnotation is the annotation key that excludes a resource
+	// from batch upgrades when set to true, openebs.io/skip-upgrade if empty
+	SkipUpgradeAnnotation string
+	// OperatorVersionLabel is the label key of the operator pods with
+	// the version of the operator, openebs.io/version if empty
+	OperatorVersionLabel string
+	// PreUpgradeHook and PostUpgradeHook are the paths to executables
+	// run before and after the upgrade of the resource
+	PreUpgradeHook, PostUpgradeHook string
+	// HookTimeout is the time after which a hook is killed
+	HookTimeout time.Duration
+	// WaitForRebuild waits for the replicas on an upgraded cspi to
+	// be healthy before upgrading the next cspi, until RebuildTimeout
+	WaitForRebuild bool
+	RebuildTimeout time.Duration
+	// Result records the outcome of the upgraded resources, if set
+	Result *UpgradeResult
+	// VerifyImages checks that the images of the To version exist in
+	// the registry before the resource is patched
+	VerifyImages bool
+	// ImagePullSecret is the secret used to authenticate to the registry
+	// in addition to the image pull secrets of the resource
+	ImagePullSecret string
+	// NodeAwareScheduling makes the concurrent cspc upgrades wait for any
+	// cspi being upgraded on the same node using the NodeScheduler, which
+	// is shared by all the upgrades of the cluster if not set
+	NodeAwareScheduling bool
+	NodeScheduler       *NodeScheduler
+	// NUMAAware makes the concurrent cspc upgrades wait only for the cspis
+	// being upgraded on the same NUMA node of a node, and upgrades the cspis
+	// of a cspc in the order of the batches of CheckNUMATopology
+	NUMAAware bool
+	// IgnoreNodePressure upgrades a cspi even if its node is under
+	// memory, disk or pid pressure
+	IgnoreNodePressure bool
+	// PoolManagerImage overrides the image of the cstor-pool-manager
+	// container of the cstor pool deployments instead of the To version
+	PoolManagerImage string
+	// VerifyPoolCount fails the cspc upgrade if the healthy cspis
+	// of the cspc are not equal to the pools in its spec
+	VerifyPoolCount bool
+	// CompletePendingExpansion waits for the pending expansion of the
+	// pools of a cspc to complete instead of failing the upgrade
+	CompletePendingExpansion bool
+	// LargeUpgradeThreshold is the maximum number of cspis of a cspc
+	// upgraded without ConfirmLargeUpgrade or the approval annotation,
+	// the number of cspis is not checked if zero
+	LargeUpgradeThreshold int
+	ConfirmLargeUpgrade   bool
+	// TargetCSPI upgrades only the cspi of the given name of a cspc,
+	// without patching the cspc, all its cspis are upgraded if empty
+	TargetCSPI string
+	// VerifyBlockDevices fails the upgrade of a cspi if its blockdevices
+	// are no longer Active and Claimed after the upgrade
+	VerifyBlockDevices bool
+	// VerifyPodImages fails the upgrade of a cspi if its pool pods are
+	// not running the images of the to version after the upgrade
+	VerifyPodImages bool
+	// Backoff delays the polls of the waits and the retries of the
+	// failed cspis, the polls are delayed by the PollInterval if nil
+	Backoff BackoffStrategy
+	// CVCReadinessTimeout is the maximum time the upgrade of the cstor
+	// volumes waits for the cvcs being provisioned, no wait if zero
+	CVCReadinessTimeout time.Duration
+	// LVMThinPool is the vg/thinpool whose LVMThinPoolSettings, as
+	// key=value, are applied on the node of each cspi before its upgrade
+	LVMThinPool         string
+	LVMThinPoolSettings []string
+	// AllowInUseUpgrades logs a warning instead of failing the upgrade
+	// if the pvc of a cstor volume is used by a running pod
+	AllowInUseUpgrades bool
+	// MinHealthyReplicas is the minimum of healthy replicas a cstor
+	// volume needs before its target is restarted, all the replicas
+	// of its replication factor if 0, and no check if negative
+	MinHealthyReplicas int
+	// ReplicaWaitTimeout is the time to wait for MinHealthyReplicas,
+	// the upgrade of the volume fails right away if 0
+	ReplicaWaitTimeout time.Duration
+	// FailOnPendingResize fails the upgrade of a cstor volume whose cvc
+	// has a pending resize, instead of waiting for the resize to complete
+	FailOnPendingResize bool
+	// SkipKubernetesVersionCheck upgrades even if the kubernetes cluster
+	// is older than the minimum kubernetes version of the to version
+	SkipKubernetesVersionCheck bool
+	// UpdateISCSIPortal moves the iscsi session of a cstor volume on the
+	// node it is mounted on to the portal of its target after the upgrade
+	UpdateISCSIPortal bool
+	// UpgradeClones upgrades the clones of a cstor volume after the
+	// volume, instead of logging a warning for the clones left behind
+	UpgradeClones bool
+	// SnapshotDriver is the driver the snapshotclass and its
+	// volumesnapshotcontents are upgraded to, cstor.csi.openebs.io if empty
+	SnapshotDriver string
+	// SnapshotClassParameters are set on the snapshotclass, the
+	// parameters with an empty value are removed
+	SnapshotClassParameters map[string]string
+	// StorageClassProvisioner is the provisioner a storageclass is
+	// migrated to, under the NewStorageClassName which is the old name
+	// suffixed with the To version if empty
+	StorageClassProvisioner string
+	NewStorageClassName     string
+	// DryRun logs the changes of the storageclass migration instead of
+	// making them, and writes the diffs of the objects a cspi upgrade
+	// would patch to the DiffOutput in the DiffFormat, colored with
+	// DiffColor, or to stdout as text if DiffOutput is nil
+	DryRun     bool
+	DiffOutput io.Writer
+	DiffFormat string
+	DiffColor  bool
+	// FromAPIVersion and ToAPIVersion are the versions of the schema of
+	// a crd its objects are migrated between
+	FromAPIVersion, ToAPIVersion string
+	// EtcdImage is the image the etcd statefulset is upgraded to, the
+	// current image with the ImageTag is used if empty
+	EtcdImage string
+	// EtcdCAFile, EtcdCertFile and EtcdKeyFile are the ca and the client
+	// certificate used to get the health of the etcd members over tls
+	EtcdCAFile   string
+	EtcdCertFile string
+	EtcdKeyFile  string
+	// ConsolidateUpgradeTasks deletes the duplicate upgradetasks of a
+	// resource before its upgradetask is created
+	ConsolidateUpgradeTasks bool
+	// RequireApproval waits after the prechecks for the ApprovalAnnotation,
+	// openebs.io/upgrade-approved if empty, to be set to the To version on the
+	// resource before it is patched, failing after the ApprovalTimeout if set
+	RequireApproval    bool
+	ApprovalAnnotation string
+	ApprovalTimeout    time.Duration
+	// PodSecurityLevel is the pod security admission level enforced in
+	// the openebs namespace, derived from the podsecuritypolicy if empty
+	PodSecurityLevel string
+	// MigratePSP converts the podsecuritypolicy to the pod security
+	// admission label of the namespace before kubernetes 1.25
+	MigratePSP bool
+	// Progress receives the events of the upgrade if set, the caller
+	// must drain it unless NonBlockingProgress is set in which case the
+	// events are dropped when the channel is full
+	Progress            chan<- UpgradeEvent
+	NonBlockingProgress bool
 	// UpgradeTask       *utask.UpgradeTask
 }
 
@@ -63,6 +277,13 @@ func WithImageTag(imagetag string) ResourcePatchOptions {
 	}
 }
 
+// WithImageRegistry ...
+func WithImageRegistry(registry string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ImageRegistry = registry
+	}
+}
+
 // WithBaseURL ...
 func WithBaseURL(url string) ResourcePatchOptions {
 	return func(r *ResourcePatch) {
@@ -70,6 +291,468 @@ func WithBaseURL(url string) ResourcePatchOptions {
 	}
 }
 
+// WithVerifyOnly ...
+func WithVerifyOnly(verifyOnly bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.VerifyOnly = verifyOnly
+	}
+}
+
+// WithMaxParallelVolumes ...
+func WithMaxParallelVolumes(maxParallel int) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.MaxParallelVolumes = maxParallel
+	}
+}
+
+// WithMaxUnavailable ...
+func WithMaxUnavailable(maxUnavailable *intstr.IntOrString) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.MaxUnavailable = maxUnavailable
+	}
+}
+
+// WithMinFreePoolSpace ...
+func WithMinFreePoolSpace(percent int) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.MinFreePoolSpace = percent
+	}
+}
+
+// WithAllowOverprovisioned ...
+func WithAllowOverprovisioned(allow bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.AllowOverprovisioned = allow
+	}
+}
+
+// WithAllowRBACRemoval ...
+func WithAllowRBACRemoval(allow bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.AllowRBACRemoval = allow
+	}
+}
+
+// WithTransientMessages ...
+func WithTransientMessages(messages []string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.TransientMessages = messages
+	}
+}
+
+// WithReconcileFailureThreshold ...
+func WithReconcileFailureThreshold(threshold int) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ReconcileFailureThreshold = threshold
+	}
+}
+
+// WithReconcilePredicate ...
+func WithReconcilePredicate(p ReconcilePredicate) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ReconcilePredicate = p
+	}
+}
+
+// WithReconcileTimeout ...
+func WithReconcileTimeout(timeout time.Duration) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ReconcileTimeout = timeout
+	}
+}
+
+// WithVersionStabilityPolls ...
+func WithVersionStabilityPolls(polls int) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.VersionStabilityPolls = polls
+	}
+}
+
+// WithReconcileStablePeriod ...
+func WithReconcileStablePeriod(period time.Duration) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ReconcileStablePeriod = period
+	}
+}
+
+// WithMaxParallelVerify ...
+func WithMaxParallelVerify(maxParallel int) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.MaxParallelVerify = maxParallel
+	}
+}
+
+// WithPatchReapplyAttempts ...
+func WithPatchReapplyAttempts(attempts int) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.PatchReapplyAttempts = attempts
+	}
+}
+
+// WithPollInterval ...
+func WithPollInterval(interval time.Duration) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.PollInterval = interval
+	}
+}
+
+// WithSmokeTestStorageClass ...
+func WithSmokeTestStorageClass(sc string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.SmokeTestStorageClass = sc
+	}
+}
+
+// WithSkipUpgradeAnnotation ...
+func WithSkipUpgradeAnnotation(key string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.SkipUpgradeAnnotation = key
+	}
+}
+
+// WithOperatorVersionLabel ...
+func WithOperatorVersionLabel(key string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.OperatorVersionLabel = key
+	}
+}
+
+// WithPreUpgradeHook ...
+func WithPreUpgradeHook(path string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.PreUpgradeHook = path
+	}
+}
+
+// WithPostUpgradeHook ...
+func WithPostUpgradeHook(path string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.PostUpgradeHook = path
+	}
+}
+
+// WithHookTimeout ...
+func WithHookTimeout(timeout time.Duration) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.HookTimeout = timeout
+	}
+}
+
+// WithWaitForRebuild ...
+func WithWaitForRebuild(wait bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.WaitForRebuild = wait
+	}
+}
+
+// WithRebuildTimeout ...
+func WithRebuildTimeout(timeout time.Duration) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.RebuildTimeout = timeout
+	}
+}
+
+// WithResult ...
+func WithResult(result *UpgradeResult) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.Result = result
+	}
+}
+
+// WithVerifyImages ...
+func WithVerifyImages(verify bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.VerifyImages = verify
+	}
+}
+
+// WithImagePullSecret ...
+func WithImagePullSecret(name string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ImagePullSecret = name
+	}
+}
+
+// WithNUMAAware ...
+func WithNUMAAware(enabled bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.NUMAAware = enabled
+	}
+}
+
+// WithNodeAwareScheduling ...
+func WithNodeAwareScheduling(enabled bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.NodeAwareScheduling = enabled
+	}
+}
+
+// WithNodeScheduler ...
+func WithNodeScheduler(s *NodeScheduler) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.NodeScheduler = s
+	}
+}
+
+// WithIgnoreNodePressure ...
+func WithIgnoreNodePressure(ignore bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.IgnoreNodePressure = ignore
+	}
+}
+
+// WithPoolManagerImage ...
+func WithPoolManagerImage(image string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.PoolManagerImage = image
+	}
+}
+
+// WithVerifyPoolCount ...
+func WithVerifyPoolCount(verify bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.VerifyPoolCount = verify
+	}
+}
+
+// WithCompletePendingExpansion ...
+func WithCompletePendingExpansion(complete bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.CompletePendingExpansion = complete
+	}
+}
+
+// WithLargeUpgradeThreshold ...
+func WithLargeUpgradeThreshold(threshold int) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.LargeUpgradeThreshold = threshold
+	}
+}
+
+// WithConfirmLargeUpgrade ...
+func WithConfirmLargeUpgrade(confirm bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ConfirmLargeUpgrade = confirm
+	}
+}
+
+// WithTargetCSPI ...
+func WithTargetCSPI(name string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.TargetCSPI = name
+	}
+}
+
+// WithVerifyBlockDevices ...
+func WithVerifyBlockDevices(verify bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.VerifyBlockDevices = verify
+	}
+}
+
+// WithVerifyPodImages ...
+func WithVerifyPodImages(verify bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.VerifyPodImages = verify
+	}
+}
+
+// WithBackoffStrategy ...
+func WithBackoffStrategy(b BackoffStrategy) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.Backoff = b
+	}
+}
+
+// WithCVCReadinessTimeout ...
+func WithCVCReadinessTimeout(timeout time.Duration) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.CVCReadinessTimeout = timeout
+	}
+}
+
+// WithLVMThinPool ...
+func WithLVMThinPool(pool string, settings []string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.LVMThinPool = pool
+		r.LVMThinPoolSettings = settings
+	}
+}
+
+// WithAllowInUseUpgrades ...
+func WithAllowInUseUpgrades(allow bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.AllowInUseUpgrades = allow
+	}
+}
+
+// WithMinHealthyReplicas ...
+func WithMinHealthyReplicas(min int) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.MinHealthyReplicas = min
+	}
+}
+
+// WithReplicaWaitTimeout ...
+func WithReplicaWaitTimeout(timeout time.Duration) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ReplicaWaitTimeout = timeout
+	}
+}
+
+// WithFailOnPendingResize ...
+func WithFailOnPendingResize(fail bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.FailOnPendingResize = fail
+	}
+}
+
+// WithSkipKubernetesVersionCheck ...
+func WithSkipKubernetesVersionCheck(skip bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.SkipKubernetesVersionCheck = skip
+	}
+}
+
+// WithUpdateISCSIPortal ...
+func WithUpdateISCSIPortal(update bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.UpdateISCSIPortal = update
+	}
+}
+
+// WithUpgradeClones ...
+func WithUpgradeClones(upgrade bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.UpgradeClones = upgrade
+	}
+}
+
+// WithSnapshotDriver ...
+func WithSnapshotDriver(driver string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.SnapshotDriver = driver
+	}
+}
+
+// WithSnapshotClassParameters ...
+func WithSnapshotClassParameters(parameters map[string]string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.SnapshotClassParameters = parameters
+	}
+}
+
+// WithStorageClassProvisioner ...
+func WithStorageClassProvisioner(provisioner string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.StorageClassProvisioner = provisioner
+	}
+}
+
+// WithNewStorageClassName ...
+func WithNewStorageClassName(name string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.NewStorageClassName = name
+	}
+}
+
+// WithAPIVersions ...
+func WithAPIVersions(from, to string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.FromAPIVersion = from
+		r.ToAPIVersion = to
+	}
+}
+
+// WithDryRun ...
+func WithDryRun(dryRun bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.DryRun = dryRun
+	}
+}
+
+// WithDiffOutput ...
+func WithDiffOutput(w io.Writer, format string, color bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.DiffOutput = w
+		r.DiffFormat = format
+		r.DiffColor = color
+	}
+}
+
+// WithEtcdImage ...
+func WithEtcdImage(image string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.EtcdImage = image
+	}
+}
+
+// WithEtcdTLS ...
+func WithEtcdTLS(caFile, certFile, keyFile string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.EtcdCAFile = caFile
+		r.EtcdCertFile = certFile
+		r.EtcdKeyFile = keyFile
+	}
+}
+
+// WithConsolidateUpgradeTasks ...
+func WithConsolidateUpgradeTasks(consolidate bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ConsolidateUpgradeTasks = consolidate
+	}
+}
+
+// WithRequireApproval ...
+func WithRequireApproval(require bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.RequireApproval = require
+	}
+}
+
+// WithApprovalAnnotation ...
+func WithApprovalAnnotation(annotation string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ApprovalAnnotation = annotation
+	}
+}
+
+// WithFromVersionAnnotation ...
+func WithFromVersionAnnotation(annotation string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.FromVersionAnnotation = annotation
+	}
+}
+
+// WithApprovalTimeout ...
+func WithApprovalTimeout(timeout time.Duration) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.ApprovalTimeout = timeout
+	}
+}
+
+// WithPodSecurityLevel ...
+func WithPodSecurityLevel(level string) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.PodSecurityLevel = level
+	}
+}
+
+// WithMigratePSP ...
+func WithMigratePSP(migrate bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.MigratePSP = migrate
+	}
+}
+
+// WithProgress ...
+func WithProgress(progress chan<- UpgradeEvent, nonBlocking bool) ResourcePatchOptions {
+	return func(r *ResourcePatch) {
+		r.Progress = progress
+		r.NonBlockingProgress = nonBlocking
+	}
+}
+
 // NewResourcePatch returns a new instance of ResourcePatch
 func NewResourcePatch(opts ...ResourcePatchOptions) *ResourcePatch {
 	r := &ResourcePatch{}
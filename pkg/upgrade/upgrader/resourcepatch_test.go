@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestNewResourcePatch(t *testing.T) {
+	maxUnavailable := intstr.FromInt(2)
+	scheduler := &NodeScheduler{}
+	backoff, err := NewBackoffStrategy("constant")
+	if err != nil {
+		t.Fatalf("NewBackoffStrategy() error = %v", err)
+	}
+	got := NewResourcePatch(
+		WithImageTag("3.0.0-ee"),
+		WithImageRegistry("registry.example.com"),
+		WithBaseURL("quay.io/openebs"),
+		WithMaxParallelVolumes(4),
+		WithMaxUnavailable(&maxUnavailable),
+		WithVersionStabilityPolls(3),
+		WithOperatorVersionLabel("example.com/version"),
+		WithPostUpgradeHook("/hooks/post"),
+		WithWaitForRebuild(true),
+		WithVerifyImages(true),
+		WithImagePullSecret("regcred"),
+		WithNUMAAware(true),
+		WithNodeAwareScheduling(true),
+		WithNodeScheduler(scheduler),
+		WithIgnoreNodePressure(true),
+		WithPoolManagerImage("cstor-pool-manager:3.0.0"),
+		WithVerifyPoolCount(true),
+		WithCompletePendingExpansion(true),
+		WithTargetCSPI("cspc-a-1"),
+		WithVerifyBlockDevices(true),
+		WithVerifyPodImages(true),
+		WithBackoffStrategy(backoff),
+		WithUpgradeClones(true),
+		WithSnapshotDriver("cstor.csi.openebs.io"),
+		WithSnapshotClassParameters(map[string]string{"incremental": "true"}),
+		WithAPIVersions("v1alpha1", "v1"),
+		WithConsolidateUpgradeTasks(true),
+		WithApprovalTimeout(time.Hour),
+	)
+	want := &ResourcePatch{
+		ImageTag:                 "3.0.0-ee",
+		ImageRegistry:            "registry.example.com",
+		BaseURL:                  "quay.io/openebs",
+		MaxParallelVolumes:       4,
+		MaxUnavailable:           &maxUnavailable,
+		VersionStabilityPolls:    3,
+		OperatorVersionLabel:     "example.com/version",
+		PostUpgradeHook:          "/hooks/post",
+		WaitForRebuild:           true,
+		VerifyImages:             true,
+		ImagePullSecret:          "regcred",
+		NUMAAware:                true,
+		NodeAwareScheduling:      true,
+		NodeScheduler:            scheduler,
+		IgnoreNodePressure:       true,
+		PoolManagerImage:         "cstor-pool-manager:3.0.0",
+		VerifyPoolCount:          true,
+		CompletePendingExpansion: true,
+		TargetCSPI:               "cspc-a-1",
+		VerifyBlockDevices:       true,
+		VerifyPodImages:          true,
+		Backoff:                  backoff,
+		UpgradeClones:            true,
+		SnapshotDriver:           "cstor.csi.openebs.io",
+		SnapshotClassParameters:  map[string]string{"incremental": "true"},
+		FromAPIVersion:           "v1alpha1",
+		ToAPIVersion:             "v1",
+		ConsolidateUpgradeTasks:  true,
+		ApprovalTimeout:          time.Hour,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewResourcePatch() = %+v, want %+v", got, want)
+	}
+}
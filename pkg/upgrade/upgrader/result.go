@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ResultSuccess, ResultFailed and ResultSkipped are
+	// the outcomes of the upgrade of a resource
+	ResultSuccess = "Success"
+	ResultFailed  = "Failed"
+	ResultSkipped = "Skipped"
+
+	// resultLabel identifies the configmaps storing upgrade results
+	resultLabel = "openebs.io/upgrade-result"
+	// resultKey is the configmap key the result is stored in
+	resultKey = "result.json"
+)
+
+// ResourceResult is the outcome of the upgrade of a single resource
+type ResourceResult struct {
+	Kind    string      `json:"kind"`
+	Name    string      `json:"name"`
+	Status  string      `json:"status"`
+	Message string      `json:"message,omitempty"`
+	Time    metav1.Time `json:"time"`
+	// Duration is the time taken to upgrade the resource,
+	// if the start of its upgrade was recorded
+	Duration metav1.Duration `json:"duration,omitempty"`
+}
+
+// UpgradeResult is the outcome of all the resources upgraded in a run,
+// which is persisted in a configmap so that it outlives the job
+type UpgradeResult struct {
+	// Name and Namespace of the configmap the result is saved in
+	Name      string           `json:"name"`
+	Namespace string           `json:"namespace"`
+	From      string           `json:"fromVersion"`
+	To        string           `json:"toVersion"`
+	StartTime metav1.Time      `json:"startTime"`
+	Resources []ResourceResult `json:"resources"`
+	// started are the start times of the resources being upgraded
+	started map[string]time.Time
+	mutex   sync.Mutex
+}
+
+// NewUpgradeResult returns a new UpgradeResult to be saved in the
+// configmap with the given name and namespace, the result is only
+// kept in memory if the name is empty
+func NewUpgradeResult(name, namespace, from, to string) *UpgradeResult {
+	return &UpgradeResult{
+		Name:      name,
+		Namespace: namespace,
+		From:      from,
+		To:        to,
+		StartTime: metav1.Now(),
+		Resources: []ResourceResult{},
+		started:   map[string]time.Time{},
+	}
+}
+
+// Start records the start of the upgrade of a resource, which is used
+// to compute the time taken once its outcome is added. It is a no-op
+// if the result is nil.
+func (r *UpgradeResult) Start(kind, name string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.started == nil {
+		r.started = map[string]time.Time{}
+	}
+	r.started[kind+"/"+name] = time.Now()
+}
+
+// Add records the outcome of the upgrade of a resource,
+// it is a no-op if the result is nil
+func (r *UpgradeResult) Add(kind, name string, err error) {
+	if err != nil {
+		r.add(kind, name, ResultFailed, err.Error())
+		return
+	}
+	r.add(kind, name, ResultSuccess, "")
+}
+
+// AddSkipped records a resource that was skipped with the reason
+func (r *UpgradeResult) AddSkipped(kind, name, reason string) {
+	r.add(kind, name, ResultSkipped, reason)
+}
+
+func (r *UpgradeResult) add(kind, name, status, message string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := metav1.Now()
+	res := ResourceResult{
+		Kind:    kind,
+		Name:    name,
+		Status:  status,
+		Message: message,
+		Time:    now,
+	}
+	if start, ok := r.started[kind+"/"+name]; ok {
+		res.Duration = metav1.Duration{Duration: now.Sub(start)}
+		delete(r.started, kind+"/"+name)
+	}
+	r.Resources = append(r.Resources, res)
+}
+
+// Save creates or updates the configmap with the result, it
+// is a no-op if the result is nil or has no configmap name
+func (r *UpgradeResult) Save(client *Client) error {
+	if r == nil || r.Name == "" {
+		return nil
+	}
+	r.mutex.Lock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.mutex.Unlock()
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal upgrade result")
+	}
+	return r.saveData(client, resultKey, data)
+}
+
+// saveData creates or updates the configmap of the result
+// with the data under the given key
+func (r *UpgradeResult) saveData(client *Client, key string, data []byte) error {
+	cmClient := client.KubeClientset.CoreV1().ConfigMaps(r.Namespace)
+	cmObj, err := cmClient.Get(context.TODO(), r.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		cmObj = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      r.Name,
+				Namespace: r.Namespace,
+				Labels:    map[string]string{resultLabel: "true"},
+			},
+			Data: map[string]string{key: string(data)},
+		}
+		_, err = cmClient.Create(context.TODO(), cmObj, metav1.CreateOptions{})
+		return errors.Wrapf(err, "failed to create upgrade result configmap %s", r.Name)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get upgrade result configmap %s", r.Name)
+	}
+	if cmObj.Data == nil {
+		cmObj.Data = map[string]string{}
+	}
+	cmObj.Data[key] = string(data)
+	_, err = cmClient.Update(context.TODO(), cmObj, metav1.UpdateOptions{})
+	return errors.Wrapf(err, "failed to update upgrade result configmap %s", r.Name)
+}
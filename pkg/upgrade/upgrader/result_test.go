@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpgradeResult_Save(t *testing.T) {
+	client := NewTestClient()
+	result := NewUpgradeResult("upgrade-result-test", "openebs", "2.12.0", "3.0.0")
+	result.Add("cstorPoolInstance", "cspi-1", nil)
+	result.AddSkipped("cstorPoolInstance", "cspi-2", "already complete")
+	if err := result.Save(client); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// saving again updates the existing configmap
+	result.Add("cstorPoolCluster", "cspc", errors.New("failed to patch"))
+	if err := result.Save(client); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	cmObj, err := client.KubeClientset.CoreV1().ConfigMaps("openebs").
+		Get(context.TODO(), "upgrade-result-test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get configmap: %v", err)
+	}
+	got := UpgradeResult{}
+	if err := json.Unmarshal([]byte(cmObj.Data[resultKey]), &got); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	wantStatus := []string{ResultSuccess, ResultSkipped, ResultFailed}
+	if len(got.Resources) != len(wantStatus) {
+		t.Fatalf("got %d resources, want %d", len(got.Resources), len(wantStatus))
+	}
+	for i, r := range got.Resources {
+		if r.Status != wantStatus[i] {
+			t.Errorf("resource %s status = %s, want %s", r.Name, r.Status, wantStatus[i])
+		}
+	}
+	if got.Resources[2].Message != "failed to patch" {
+		t.Errorf("resource %s message = %q", got.Resources[2].Name, got.Resources[2].Message)
+	}
+}
+
+func TestUpgradeResult_Nil(t *testing.T) {
+	var result *UpgradeResult
+	result.Add("cstorVolume", "pvc-1", nil)
+	if err := result.Save(nil); err != nil {
+		t.Errorf("Save() error = %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"sync"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+)
+
+// hostNameLabel is the node selector of a cspi pinning it to a node
+const hostNameLabel = "kubernetes.io/hostname"
+
+// NodeScheduler makes sure at most one cspi is upgraded on a node at
+// a time when the cspcs sharing the nodes are upgraded concurrently,
+// so that multiple pools on the same node are not restarted at once
+type NodeScheduler struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	// busy is the cspi being upgraded keyed by its placement
+	busy map[numaPlacement]string
+}
+
+// NewNodeScheduler returns a new instance of NodeScheduler
+func NewNodeScheduler() *NodeScheduler {
+	s := &NodeScheduler{busy: map[numaPlacement]string{}}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// cspiNode returns the node the cspi is scheduled on
+func cspiNode(cspiObj *cstor.CStorPoolInstance) string {
+	if node := cspiObj.Spec.NodeSelector[hostNameLabel]; node != "" {
+		return node
+	}
+	return cspiObj.Spec.HostName
+}
+
+// Acquire blocks until no other cspi is being upgraded on the node and
+// marks the cspi as in-flight on it. The returned function releases the
+// node and is safe to call more than once.
+func (s *NodeScheduler) Acquire(node, cspi string) func() {
+	return s.acquire(numaPlacement{node: node}, cspi)
+}
+
+// AcquireNUMA blocks until no other cspi is being upgraded on the NUMA
+// node of the given node, or on the whole node if numaNode is empty,
+// and marks the cspi as in-flight on it. The returned function releases
+// the NUMA node and is safe to call more than once.
+func (s *NodeScheduler) AcquireNUMA(node, numaNode, cspi string) func() {
+	return s.acquire(numaPlacement{node: node, numaNode: numaNode}, cspi)
+}
+
+func (s *NodeScheduler) acquire(p numaPlacement, cspi string) func() {
+	if p.node == "" {
+		return func() {}
+	}
+	log := &waitLogger{}
+	s.mutex.Lock()
+	for busy := s.conflicting(p); busy != ""; busy = s.conflicting(p) {
+		log.Infof("cspi %s: waiting for the upgrade of cspi %s on node %s", cspi, busy, p)
+		s.cond.Wait()
+	}
+	s.busy[p] = cspi
+	s.mutex.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			delete(s.busy, p)
+			s.mutex.Unlock()
+			s.cond.Broadcast()
+		})
+	}
+}
+
+// conflicting returns the in-flight cspi whose placement conflicts
+// with the given one, if any
+func (s *NodeScheduler) conflicting(p numaPlacement) string {
+	for busyPlacement, busy := range s.busy {
+		if p.conflicts(busyPlacement) {
+			return busy
+		}
+	}
+	return ""
+}
+
+// scheduleCSPI waits for the node of the cspi to be free if
+// NodeAwareScheduling is set, or only its NUMA node if NUMAAware is set,
+// and returns the function to release it
+func (obj *CSPCPatch) scheduleCSPI(cspiObj *cstor.CStorPoolInstance) func() {
+	if !obj.NodeAwareScheduling && !obj.NUMAAware {
+		return func() {}
+	}
+	s := obj.NodeScheduler
+	if s == nil {
+		s = obj.nodeScheduler()
+	}
+	if obj.NUMAAware {
+		return s.acquire(cspiNUMAPlacement(cspiObj, obj.KubeClientset), cspiObj.Name)
+	}
+	return s.Acquire(cspiNode(cspiObj), cspiObj.Name)
+}
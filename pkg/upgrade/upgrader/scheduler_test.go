@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+)
+
+func TestCSPINode(t *testing.T) {
+	tests := []struct {
+		name string
+		spec cstor.CStorPoolInstanceSpec
+		want string
+	}{
+		{
+			name: "node selector",
+			spec: cstor.CStorPoolInstanceSpec{
+				HostName:     "node-a",
+				NodeSelector: map[string]string{hostNameLabel: "node-b"},
+			},
+			want: "node-b",
+		},
+		{
+			name: "host name",
+			spec: cstor.CStorPoolInstanceSpec{HostName: "node-a"},
+			want: "node-a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cspiNode(&cstor.CStorPoolInstance{Spec: tt.spec}); got != tt.want {
+				t.Errorf("cspiNode() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeScheduler(t *testing.T) {
+	s := NewNodeScheduler()
+	var mutex sync.Mutex
+	inFlight := map[string]int{}
+	maxInFlight := map[string]int{}
+	var wg sync.WaitGroup
+	// two cspcs with a cspi on each of the same three nodes
+	for c := 0; c < 2; c++ {
+		for n := 0; n < 3; n++ {
+			wg.Add(1)
+			go func(c, n int) {
+				defer wg.Done()
+				node := fmt.Sprintf("node-%d", n)
+				release := s.Acquire(node, fmt.Sprintf("cspc-%d-%d", c, n))
+				mutex.Lock()
+				inFlight[node]++
+				if inFlight[node] > maxInFlight[node] {
+					maxInFlight[node] = inFlight[node]
+				}
+				mutex.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mutex.Lock()
+				inFlight[node]--
+				mutex.Unlock()
+				release()
+				// releasing again must not free the node for another cspi
+				release()
+			}(c, n)
+		}
+	}
+	wg.Wait()
+	for node, max := range maxInFlight {
+		if max != 1 {
+			t.Errorf("node %s had %d cspis upgraded at once, want 1", node, max)
+		}
+	}
+	if len(s.busy) != 0 {
+		t.Errorf("nodes still busy after all upgrades: %v", s.busy)
+	}
+}
+
+func TestNodeSchedulerDifferentNodes(t *testing.T) {
+	s := NewNodeScheduler()
+	releaseA := s.Acquire("node-a", "cspi-a")
+	done := make(chan struct{})
+	go func() {
+		// a cspi on another node is not blocked
+		s.Acquire("node-b", "cspi-b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cspi on a different node was blocked")
+	}
+	blocked := make(chan struct{})
+	go func() {
+		s.Acquire("node-a", "cspi-c")()
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+		t.Fatalf("cspi on a busy node was not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	releaseA()
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cspi was not scheduled after the node was released")
+	}
+}
+
+func TestNodeSchedulerNUMA(t *testing.T) {
+	s := NewNodeScheduler()
+	releaseA := s.AcquireNUMA("node-a", "0", "cspi-a")
+	done := make(chan struct{})
+	go func() {
+		// a cspi on another NUMA node of the same node is not blocked
+		s.AcquireNUMA("node-a", "1", "cspi-b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cspi on a different NUMA node was blocked")
+	}
+	for _, numaNode := range []string{"0", ""} {
+		blocked := make(chan struct{})
+		go func(numaNode string) {
+			s.AcquireNUMA("node-a", numaNode, "cspi-c")()
+			close(blocked)
+		}(numaNode)
+		select {
+		case <-blocked:
+			t.Fatalf("cspi on numa node %q of a busy NUMA node was not blocked", numaNode)
+		case <-time.After(50 * time.Millisecond):
+		}
+		releaseA()
+		select {
+		case <-blocked:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("cspi was not scheduled after the NUMA node was released")
+		}
+		releaseA = s.AcquireNUMA("node-a", "0", "cspi-a")
+	}
+	releaseA()
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	authv1 "k8s.io/api/authorization/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// crdGroup is an api group version along with the
+// resources expected to be installed in it
+type crdGroup struct {
+	groupVersion string
+	resources    []string
+}
+
+// permission is a verb on a resource required by the upgrade
+type permission struct {
+	group    string
+	resource string
+	verbs    []string
+}
+
+var (
+	upgradeTaskCRDs = crdGroup{"openebs.io/v1alpha1", []string{"upgradetasks"}}
+	cstorCRDs       = crdGroup{"cstor.openebs.io/v1", []string{
+		"cstorpoolclusters", "cstorpoolinstances", "cstorvolumes",
+		"cstorvolumeconfigs", "cstorvolumereplicas",
+	}}
+	jivaCRDs = crdGroup{"openebs.io/v1", []string{"jivavolumes"}}
+
+	upgradeTaskPermissions = []permission{
+		{"openebs.io", "upgradetasks", []string{"get", "create", "update"}},
+	}
+	commonPermissions = []permission{
+		{"apps", "deployments", []string{"get", "list", "patch"}},
+		{"", "pods", []string{"get", "list"}},
+	}
+	cstorPermissions = []permission{
+		{"cstor.openebs.io", "cstorpoolclusters", []string{"get", "patch"}},
+		{"cstor.openebs.io", "cstorpoolinstances", []string{"get", "list", "patch"}},
+		{"cstor.openebs.io", "cstorvolumes", []string{"get", "patch"}},
+		{"cstor.openebs.io", "cstorvolumeconfigs", []string{"get", "patch"}},
+		{"cstor.openebs.io", "cstorvolumereplicas", []string{"get", "list", "patch"}},
+	}
+	jivaPermissions = []permission{
+		{"openebs.io", "jivavolumes", []string{"get", "patch"}},
+		{"apps", "statefulsets", []string{"get", "patch"}},
+	}
+)
+
+// SelfTest verifies that the upgrade can talk to the kubernetes api,
+// the openebs namespace exists, the openebs crds are installed and
+// the service account has the permissions required for the upgrade.
+// The upgradetask crd and its permissions are only checked if the
+// upgradetasks are enabled, and a missing crd is only logged.
+func SelfTest(ctx context.Context, client *Client) error {
+	if client == nil || client.KubeClientset == nil {
+		return newValidationError(errors.Errorf("self test failed: kubernetes client is not configured"))
+	}
+	namespace := client.namespace
+	if namespace == "" {
+		namespace = "openebs"
+	}
+	serverVersion, err := client.KubeClientset.Discovery().ServerVersion()
+	if err != nil {
+		return newAPIError(errors.Wrap(err, "self test failed: kubernetes api is not reachable"))
+	}
+	klog.Infof("self test: kubernetes api is reachable, version %s", serverVersion.GitVersion)
+
+	// the namespaces are cluster scoped, a missing namespace
+	// fails the permission checks below instead
+	if !client.namespaceScoped {
+		_, err = client.KubeClientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			return newValidationError(errors.Wrapf(err, "self test failed: openebs namespace %s not found", namespace))
+		}
+	}
+
+	permissions := commonPermissions
+	// the upgrade runs without the upgradetasks if their crd is missing
+	if !client.upgradeTasksDisabled() {
+		err = checkCRDs(client, upgradeTaskCRDs)
+		if err != nil {
+			klog.Warningf("self test: the upgrade will run without upgradetasks: %v", err)
+		} else {
+			permissions = append(permissions, upgradeTaskPermissions...)
+		}
+	}
+	cstorErr := checkCRDs(client, cstorCRDs)
+	if cstorErr == nil {
+		permissions = append(permissions, cstorPermissions...)
+	}
+	jivaErr := checkCRDs(client, jivaCRDs)
+	if jivaErr == nil {
+		permissions = append(permissions, jivaPermissions...)
+	}
+	if cstorErr != nil && jivaErr != nil {
+		return newValidationError(errors.Errorf(
+			"self test failed: neither cstor nor jiva crds are installed: %v, %v",
+			cstorErr, jivaErr,
+		))
+	}
+
+	for _, p := range permissions {
+		for _, verb := range p.verbs {
+			err = checkPermission(ctx, client, namespace, p.group, p.resource, verb)
+			if err != nil {
+				return newValidationError(errors.Wrap(err, "self test failed"))
+			}
+		}
+	}
+	klog.Info("self test: passed")
+	return nil
+}
+
+// apiResourceLists returns the api resources of the groups, as served
+// by the discovery of an api server with their crds installed
+func apiResourceLists(groups ...crdGroup) []*metav1.APIResourceList {
+	lists := []*metav1.APIResourceList{}
+	for _, g := range groups {
+		list := &metav1.APIResourceList{GroupVersion: g.groupVersion}
+		for _, r := range g.resources {
+			list.APIResources = append(list.APIResources, metav1.APIResource{Name: r})
+		}
+		lists = append(lists, list)
+	}
+	return lists
+}
+
+// checkCRDs returns an error if any of the resources
+// of the group are not served by the api server
+func checkCRDs(client *Client, g crdGroup) error {
+	resourceList, err := client.KubeClientset.Discovery().ServerResourcesForGroupVersion(g.groupVersion)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return errors.Errorf("crds for %s are not installed", g.groupVersion)
+		}
+		return errors.Wrapf(err, "failed to discover %s", g.groupVersion)
+	}
+	served := map[string]bool{}
+	for _, r := range resourceList.APIResources {
+		served[r.Name] = true
+	}
+	for _, r := range g.resources {
+		if !served[r] {
+			return errors.Errorf("crd %s.%s is not installed", r, g.groupVersion)
+		}
+	}
+	return nil
+}
+
+// checkPermission returns an error if the service account
+// is not allowed to perform the verb on the resource
+func checkPermission(ctx context.Context, client *Client, namespace, group, resource, verb string) error {
+	review := &authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authv1.ResourceAttributes{
+				Namespace: namespace,
+				Group:     group,
+				Resource:  resource,
+				Verb:      verb,
+			},
+		},
+	}
+	review, err := client.KubeClientset.AuthorizationV1().SelfSubjectAccessReviews().
+		Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to review permission to %s %s", verb, resource)
+	}
+	if !review.Status.Allowed {
+		return errors.Errorf("service account is not allowed to %s %s in namespace %s",
+			verb, resource, namespace)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+
+	authv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newSelfTestClient(groups []crdGroup, denied string) *Client {
+	client := NewTestClient(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "openebs"},
+	})
+	clientset := fakeKube(client)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = apiResourceLists(groups...)
+	clientset.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authv1.SelfSubjectAccessReview)
+			review.Status.Allowed = review.Spec.ResourceAttributes.Resource != denied
+			return true, review, nil
+		})
+	return client
+}
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		name            string
+		namespace       string
+		namespaceScoped bool
+		noUpgradeTasks  bool
+		groups          []crdGroup
+		denied          string
+		wantErr         bool
+	}{
+		{
+			name:   "cstor installed",
+			groups: []crdGroup{upgradeTaskCRDs, cstorCRDs},
+		},
+		{
+			name:   "jiva installed",
+			groups: []crdGroup{upgradeTaskCRDs, jivaCRDs},
+		},
+		{
+			name:      "missing namespace",
+			namespace: "storage",
+			groups:    []crdGroup{upgradeTaskCRDs, cstorCRDs},
+			wantErr:   true,
+		},
+		{
+			name:            "namespace not read in namespace scoped mode",
+			namespace:       "storage",
+			namespaceScoped: true,
+			groups:          []crdGroup{upgradeTaskCRDs, cstorCRDs},
+		},
+		{
+			name:   "missing upgradetask crd",
+			groups: []crdGroup{cstorCRDs},
+		},
+		{
+			name:   "upgradetasks not checked without their crd",
+			groups: []crdGroup{cstorCRDs},
+			denied: "upgradetasks",
+		},
+		{
+			name:    "missing upgradetask permission",
+			groups:  []crdGroup{upgradeTaskCRDs, cstorCRDs},
+			denied:  "upgradetasks",
+			wantErr: true,
+		},
+		{
+			name:           "upgradetasks not checked when disabled",
+			groups:         []crdGroup{upgradeTaskCRDs, cstorCRDs},
+			denied:         "upgradetasks",
+			noUpgradeTasks: true,
+		},
+		{
+			name:    "no engine installed",
+			groups:  []crdGroup{upgradeTaskCRDs},
+			wantErr: true,
+		},
+		{
+			name:    "missing permission",
+			groups:  []crdGroup{upgradeTaskCRDs, cstorCRDs},
+			denied:  "cstorpoolinstances",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newSelfTestClient(tt.groups, tt.denied)
+			WithNamespace(tt.namespace)(client)
+			WithNamespaceScoped(tt.namespaceScoped)(client)
+			WithoutUpgradeTasks(tt.noUpgradeTasks)(client)
+			err := SelfTest(context.TODO(), client)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SelfTest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
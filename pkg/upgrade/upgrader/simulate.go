@@ -0,0 +1,361 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	snapfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	snapscheme "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/scheme"
+	openebsfake "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	openebsscheme "github.com/openebs/api/v3/pkg/client/clientset/versioned/scheme"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// DefaultSimLatency is the mean latency of the calls to the simulated
+// cluster, each call takes from half to one and a half times of it
+const DefaultSimLatency = 50 * time.Millisecond
+
+// SimulatedCall is an api call made to the simulated cluster
+type SimulatedCall struct {
+	Verb      string        `json:"verb"`
+	Resource  string        `json:"resource"`
+	Namespace string        `json:"namespace,omitempty"`
+	Name      string        `json:"name,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	// Injected is true if the call failed with a simulated failure
+	Injected bool   `json:"injected,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SimulationReport is the report of all the calls made
+// to the simulated cluster and their outcomes
+type SimulationReport struct {
+	Calls    []SimulatedCall `json:"calls"`
+	Failed   int             `json:"failed"`
+	Injected int             `json:"injected"`
+}
+
+// Headers returns the column names of the table output
+func (r *SimulationReport) Headers() []string {
+	return []string{"VERB", "RESOURCE", "NAME", "DURATION", "RESULT"}
+}
+
+// Rows returns the rows of the table output
+func (r *SimulationReport) Rows() [][]string {
+	rows := [][]string{}
+	for _, c := range r.Calls {
+		name := c.Name
+		if c.Namespace != "" && c.Name != "" {
+			name = c.Namespace + "/" + c.Name
+		} else if c.Namespace != "" {
+			name = c.Namespace
+		}
+		result := "ok"
+		if c.Injected {
+			result = "simulated failure"
+		} else if c.Error != "" {
+			result = c.Error
+		}
+		rows = append(rows, []string{c.Verb, c.Resource, name, c.Duration.Round(time.Millisecond).String(), result})
+	}
+	rows = append(rows, []string{"TOTAL", strconv.Itoa(len(r.Calls)), "", "",
+		strconv.Itoa(r.Failed) + " failed, " + strconv.Itoa(r.Injected) + " simulated"})
+	return rows
+}
+
+// Simulator is an in-memory cluster the upgrade can be run against. Each
+// call to it is delayed by a random latency and fails with the failure
+// rate, to exercise the retries of the upgrade, and the openebs operators
+// are simulated by reconciling the version of a resource once it is
+// patched. The calls and their outcomes are recorded for the report.
+type Simulator struct {
+	FailureRate float64
+	Latency     time.Duration
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	calls  []SimulatedCall
+	client *Client
+}
+
+// SimulatorOptions ...
+type SimulatorOptions func(*Simulator)
+
+// WithSimFailureRate ...
+func WithSimFailureRate(rate float64) SimulatorOptions {
+	return func(s *Simulator) {
+		s.FailureRate = rate
+	}
+}
+
+// WithSimLatency ...
+func WithSimLatency(latency time.Duration) SimulatorOptions {
+	return func(s *Simulator) {
+		s.Latency = latency
+	}
+}
+
+// WithSimSeed seeds the random latencies and failures,
+// so that a simulation can be repeated
+func WithSimSeed(seed int64) SimulatorOptions {
+	return func(s *Simulator) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// NewSimulator returns a simulated cluster with the given objects, the
+// objects are added to the clientset whose scheme they belong to
+func NewSimulator(objs []runtime.Object, opts ...SimulatorOptions) (*Simulator, error) {
+	s := &Simulator{Latency: DefaultSimLatency}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.FailureRate < 0 || s.FailureRate > 1 {
+		return nil, newValidationError(errors.Errorf("invalid failure rate %v, expected a value from 0 to 1", s.FailureRate))
+	}
+	if s.rand == nil {
+		s.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	kube := fake.NewSimpleClientset()
+	openebs := openebsfake.NewSimpleClientset()
+	snapshot := snapfake.NewSimpleClientset()
+	for _, obj := range objs {
+		var err error
+		switch {
+		case isInScheme(openebsscheme.Scheme, obj):
+			err = openebs.Tracker().Add(obj)
+		case isInScheme(snapscheme.Scheme, obj):
+			err = snapshot.Tracker().Add(obj)
+		default:
+			err = kube.Tracker().Add(obj)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to add %s to the simulated cluster", obj.GetObjectKind().GroupVersionKind().Kind)
+		}
+	}
+	// the openebs crds are served by the simulated cluster
+	kube.Discovery().(*fakediscovery.FakeDiscovery).Resources = apiResourceLists(upgradeTaskCRDs, cstorCRDs, jivaCRDs)
+	kube.PrependReactor("*", "*", s.reactor(kube.Tracker(), false))
+	openebs.PrependReactor("*", "*", s.reactor(openebs.Tracker(), true))
+	snapshot.PrependReactor("*", "*", s.reactor(snapshot.Tracker(), false))
+	s.client = &Client{
+		KubeClientset:     kube,
+		OpenebsClientset:  openebs,
+		SnapshotClientset: snapshot,
+		state:             newClientState(),
+	}
+	return s, nil
+}
+
+func isInScheme(scheme *runtime.Scheme, obj runtime.Object) bool {
+	_, _, err := scheme.ObjectKinds(obj)
+	return err == nil
+}
+
+// LoadSimulatedCluster reads the objects of the simulated cluster from the
+// yaml documents, like the output of kubectl get -o yaml. A List is read
+// as its items.
+func LoadSimulatedCluster(r io.Reader) ([]runtime.Object, error) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{
+		kubescheme.AddToScheme, openebsscheme.AddToScheme, snapscheme.AddToScheme,
+	} {
+		err := add(scheme)
+		if err != nil {
+			return nil, err
+		}
+	}
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	objs := []runtime.Object{}
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the simulated cluster")
+		}
+		typeMeta := metav1.TypeMeta{}
+		err = yaml.Unmarshal(doc, &typeMeta)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode the simulated cluster")
+		}
+		// the documents with only comments have no kind
+		if typeMeta.Kind == "" {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode the simulated cluster")
+		}
+		if list, ok := obj.(*corev1.List); ok {
+			for _, item := range list.Items {
+				obj, _, err := decoder.Decode(item.Raw, nil, nil)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to decode the simulated cluster")
+				}
+				objs = append(objs, obj)
+			}
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// Client returns the client of the simulated cluster
+func (s *Simulator) Client() *Client {
+	return s.client
+}
+
+// Report returns the calls made to the simulated cluster so far
+func (s *Simulator) Report() *SimulationReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := &SimulationReport{Calls: append([]SimulatedCall{}, s.calls...)}
+	for _, c := range report.Calls {
+		if c.Error != "" {
+			report.Failed++
+		}
+		if c.Injected {
+			report.Injected++
+		}
+	}
+	return report
+}
+
+// next returns the latency of the next call and whether it fails
+func (s *Simulator) next() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latency := time.Duration(float64(s.Latency) * (0.5 + s.rand.Float64()))
+	return latency, s.rand.Float64() < s.FailureRate
+}
+
+func (s *Simulator) record(call SimulatedCall) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+// reactor returns the reaction of the simulated cluster to the calls of
+// a clientset, which are served by its tracker unless a failure is
+// injected. The version of the openebs resources is reconciled.
+func (s *Simulator) reactor(tracker k8stesting.ObjectTracker, reconcile bool) k8stesting.ReactionFunc {
+	serve := k8stesting.ObjectReaction(tracker)
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// the server version of the discovery is not served by the tracker
+		if action.GetResource().Resource == "version" {
+			return false, nil, nil
+		}
+		start := time.Now()
+		latency, fail := s.next()
+		time.Sleep(latency)
+		call := SimulatedCall{
+			Verb:      action.GetVerb(),
+			Resource:  action.GetResource().Resource,
+			Namespace: action.GetNamespace(),
+			Name:      actionName(action),
+		}
+		var obj runtime.Object
+		var err error
+		if fail {
+			call.Injected = true
+			err = k8serrors.NewServiceUnavailable("simulated failure")
+		} else {
+			_, obj, err = serve(action)
+			if err == nil && reconcile && (call.Verb == "patch" || call.Verb == "update") {
+				obj, err = reconcileVersion(tracker, action, obj)
+			}
+		}
+		call.Duration = time.Since(start)
+		if err != nil {
+			call.Error = err.Error()
+		}
+		s.record(call)
+		return true, obj, err
+	}
+}
+
+func actionName(action k8stesting.Action) string {
+	switch a := action.(type) {
+	case k8stesting.GetAction:
+		return a.GetName()
+	case k8stesting.PatchAction:
+		return a.GetName()
+	case k8stesting.DeleteAction:
+		return a.GetName()
+	case k8stesting.CreateAction:
+		if m, err := meta.Accessor(a.GetObject()); err == nil {
+			return m.GetName()
+		}
+	case k8stesting.UpdateAction:
+		if m, err := meta.Accessor(a.GetObject()); err == nil {
+			return m.GetName()
+		}
+	}
+	return ""
+}
+
+// reconcileVersion does what the operator does once the desired version
+// of a resource is patched, which is to set the current version to it
+func reconcileVersion(tracker k8stesting.ObjectTracker, action k8stesting.Action, obj runtime.Object) (runtime.Object, error) {
+	if obj == nil {
+		return obj, nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return obj, nil
+	}
+	u := &unstructured.Unstructured{Object: content}
+	desired, found, _ := unstructured.NestedString(u.Object, "versionDetails", "desired")
+	current, _, _ := unstructured.NestedString(u.Object, "versionDetails", "status", "current")
+	if !found || desired == "" || desired == current {
+		return obj, nil
+	}
+	_ = unstructured.SetNestedField(u.Object, desired, "versionDetails", "status", "current")
+	_ = unstructured.SetNestedField(u.Object, "Reconciled", "versionDetails", "status", "state")
+	reconciled := obj.DeepCopyObject()
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, reconciled)
+	if err != nil {
+		return obj, nil
+	}
+	err = tracker.Update(action.GetResource(), reconciled, action.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	return reconciled, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testSimulatedCluster = `
+# the objects of the simulated cluster
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Node
+  metadata:
+    name: node-1
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: cspc-operator-0
+    namespace: openebs
+---
+apiVersion: cstor.openebs.io/v1
+kind: CStorPoolCluster
+metadata:
+  name: cspc-a
+  namespace: openebs
+versionDetails:
+  desired: 2.12.0
+  status:
+    current: 2.12.0
+`
+
+func TestLoadSimulatedCluster(t *testing.T) {
+	objs, err := LoadSimulatedCluster(strings.NewReader(testSimulatedCluster))
+	if err != nil {
+		t.Fatalf("LoadSimulatedCluster() error = %v", err)
+	}
+	kinds := []string{}
+	for _, obj := range objs {
+		kinds = append(kinds, obj.GetObjectKind().GroupVersionKind().Kind)
+	}
+	if got := strings.Join(kinds, ","); got != "Node,Pod,CStorPoolCluster" {
+		t.Errorf("LoadSimulatedCluster() kinds = %s, want Node,Pod,CStorPoolCluster", got)
+	}
+
+	_, err = LoadSimulatedCluster(strings.NewReader("apiVersion: v1\nkind: Unknown\n"))
+	if err == nil {
+		t.Errorf("LoadSimulatedCluster() of unknown kind, want error")
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	tests := []struct {
+		name        string
+		failureRate float64
+		wantErr     bool
+	}{
+		{name: "no failures", failureRate: 0},
+		{name: "all calls fail", failureRate: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSimulator(
+				[]runtime.Object{upgradetesting.NewTestCSPC("cspc-a", "2.12.0")},
+				WithSimFailureRate(tt.failureRate),
+				WithSimLatency(0),
+				WithSimSeed(1),
+			)
+			if err != nil {
+				t.Fatalf("NewSimulator() error = %v", err)
+			}
+			client := NewUpgrade(WithSimulator(s)).Client
+			cspcs := client.OpenebsClientset.CstorV1().CStorPoolClusters(upgradetesting.Namespace)
+			_, err = cspcs.Patch(context.TODO(), "cspc-a", k8stypes.MergePatchType,
+				[]byte(`{"versionDetails":{"desired":"3.0.0"}}`), metav1.PatchOptions{})
+			if tt.wantErr {
+				if !k8serrors.IsServiceUnavailable(err) {
+					t.Errorf("Patch() error = %v, want simulated failure", err)
+				}
+				report := s.Report()
+				if report.Injected != 1 || report.Failed != 1 {
+					t.Errorf("Report() = %+v, want 1 simulated failure", report)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Patch() error = %v", err)
+			}
+			// the simulated operator reconciles the patched version
+			cspc, err := cspcs.Get(context.TODO(), "cspc-a", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if cspc.VersionDetails.Status.Current != "3.0.0" {
+				t.Errorf("current version = %s, want 3.0.0", cspc.VersionDetails.Status.Current)
+			}
+			report := s.Report()
+			if len(report.Calls) != 2 || report.Calls[0].Verb != "patch" || report.Calls[0].Name != "cspc-a" {
+				t.Errorf("Report() calls = %+v, want the patch and get of cspc-a", report.Calls)
+			}
+			var b bytes.Buffer
+			err = PrintOutput(&b, OutputTable, report)
+			if err != nil || !strings.Contains(b.String(), "openebs/cspc-a") {
+				t.Errorf("PrintOutput() = %q, %v", b.String(), err)
+			}
+		})
+	}
+}
+
+func TestNewSimulator_invalidFailureRate(t *testing.T) {
+	_, err := NewSimulator(nil, WithSimFailureRate(1.5))
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("NewSimulator() error = %v, want validation error", err)
+	}
+}
+
+func TestActionName(t *testing.T) {
+	cspc := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+	resource := cstor.SchemeGroupVersion.WithResource("cstorpoolclusters")
+	tests := []struct {
+		name   string
+		action k8stesting.Action
+		want   string
+	}{
+		{name: "get", action: k8stesting.NewGetAction(resource, upgradetesting.Namespace, "cspc-a"), want: "cspc-a"},
+		{name: "create", action: k8stesting.NewCreateAction(resource, upgradetesting.Namespace, cspc), want: "cspc-a"},
+		{name: "update", action: k8stesting.NewUpdateAction(resource, upgradetesting.Namespace, cspc), want: "cspc-a"},
+		{name: "delete", action: k8stesting.NewDeleteAction(resource, upgradetesting.Namespace, "cspc-a"), want: "cspc-a"},
+		{name: "list", action: k8stesting.NewListAction(resource, cstor.SchemeGroupVersion.WithKind("CStorPoolCluster"),
+			upgradetesting.Namespace, metav1.ListOptions{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := actionName(tt.action); got != tt.want {
+				t.Errorf("actionName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	smokeTestImage   = "busybox:1.33"
+	smokeTestSize    = "1Gi"
+	smokeTestTimeout = 10 * time.Minute
+	smokeTestMount   = "/data"
+	// cstorCSIProvisioner is the provisioner of the cstor csi storageclasses
+	cstorCSIProvisioner = "cstor.csi.openebs.io"
+)
+
+// SmokeTest provisions a test volume, writes data to it and reads
+// it back to verify the upgraded storage stack is functional
+type SmokeTest struct {
+	*ResourcePatch
+	StorageClass string
+	*Client
+}
+
+// SmokeTestOptions ...
+type SmokeTestOptions func(*SmokeTest)
+
+// WithSmokeTestResorcePatch ...
+func WithSmokeTestResorcePatch(r *ResourcePatch) SmokeTestOptions {
+	return func(obj *SmokeTest) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithSmokeTestClient ...
+func WithSmokeTestClient(c *Client) SmokeTestOptions {
+	return func(obj *SmokeTest) {
+		obj.Client = c
+	}
+}
+
+// NewSmokeTest ...
+func NewSmokeTest(opts ...SmokeTestOptions) *SmokeTest {
+	obj := &SmokeTest{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// Init finds the storageclass to provision the test volume, using
+// the SmokeTestStorageClass if set or the storageclass of the
+// upgraded resource of the given kind
+func (obj *SmokeTest) Init(kind string) error {
+	if obj.SmokeTestStorageClass != "" {
+		obj.StorageClass = obj.SmokeTestStorageClass
+		return nil
+	}
+	// the storageclasses and pvs are cluster scoped
+	if obj.namespaceScoped {
+		return newValidationError(
+			errors.Errorf("smoke test storageclass is required in namespace scoped mode"),
+		)
+	}
+	switch kind {
+	case "cstorPoolCluster":
+		scList, err := obj.KubeClientset.StorageV1().StorageClasses().
+			List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to list storageclasses")
+		}
+		for _, sc := range scList.Items {
+			if sc.Provisioner == cstorCSIProvisioner &&
+				sc.Parameters["cstorPoolCluster"] == obj.Name {
+				obj.StorageClass = sc.Name
+				return nil
+			}
+		}
+		return errors.Errorf("no storageclass found for cspc %s", obj.Name)
+	case "cstorVolume", "jivaVolume":
+		pvObj, err := obj.KubeClientset.CoreV1().PersistentVolumes().
+			Get(context.TODO(), obj.Name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get pv %s", obj.Name)
+		}
+		if pvObj.Spec.StorageClassName == "" {
+			return errors.Errorf("no storageclass found for pv %s", obj.Name)
+		}
+		obj.StorageClass = pvObj.Spec.StorageClassName
+		return nil
+	}
+	return errors.Errorf("smoke test is not supported for %s", kind)
+}
+
+// Run executes the smoke test and cleans up the test
+// resources irrespective of the result
+func (obj *SmokeTest) Run() error {
+	klog.Infof("Running smoke test using storageclass %s", obj.StorageClass)
+	pvcObj, err := obj.KubeClientset.CoreV1().PersistentVolumeClaims(obj.OpenebsNamespace).
+		Create(context.TODO(), obj.buildPVC(), metav1.CreateOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to create smoke test pvc"))
+	}
+	defer obj.deletePVC(pvcObj.Name)
+	podObj, err := obj.KubeClientset.CoreV1().Pods(obj.OpenebsNamespace).
+		Create(context.TODO(), obj.buildPod(pvcObj.Name), metav1.CreateOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to create smoke test pod"))
+	}
+	defer obj.deletePod(podObj.Name)
+	err = obj.waitForPod(podObj.Name)
+	if err != nil {
+		klog.Errorf("Smoke test failed using storageclass %s: %v", obj.StorageClass, err)
+		return err
+	}
+	klog.Infof("Smoke test passed using storageclass %s", obj.StorageClass)
+	return nil
+}
+
+func (obj *SmokeTest) buildPVC() *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "upgrade-smoke-test-",
+			Namespace:    obj.OpenebsNamespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &obj.StorageClass,
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse(smokeTestSize),
+				},
+			},
+		},
+	}
+}
+
+func (obj *SmokeTest) buildPod(pvcName string) *corev1.Pod {
+	token := strconv.FormatInt(time.Now().UnixNano(), 10)
+	file := smokeTestMount + "/smoke-test"
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pvcName,
+			Namespace: obj.OpenebsNamespace,
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:  "smoke-test",
+					Image: smokeTestImage,
+					Command: []string{
+						"sh", "-c",
+						"echo " + token + " > " + file + " && sync && " +
+							"[ \"$(cat " + file + ")\" = \"" + token + "\" ]",
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:      "data",
+							MountPath: smokeTestMount,
+						},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: pvcName,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (obj *SmokeTest) waitForPod(name string) error {
+	log := &waitLogger{}
+	deadline := time.Now().Add(smokeTestTimeout)
+	for time.Now().Before(deadline) {
+		podObj, err := obj.KubeClientset.CoreV1().Pods(obj.OpenebsNamespace).
+			Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to get smoke test pod %s", name))
+		}
+		switch podObj.Status.Phase {
+		case corev1.PodSucceeded:
+			return nil
+		case corev1.PodFailed:
+			return errors.Errorf("smoke test pod %s failed to write and read data", name)
+		}
+		log.Infof("Waiting for smoke test pod %s to complete, phase=%s", name, podObj.Status.Phase)
+		time.Sleep(10 * time.Second)
+	}
+	return newTimeoutError(
+		errors.Errorf("timed out waiting for smoke test pod %s to complete", name),
+	)
+}
+
+func (obj *SmokeTest) deletePod(name string) {
+	err := obj.KubeClientset.CoreV1().Pods(obj.OpenebsNamespace).
+		Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil {
+		klog.Errorf("failed to delete smoke test pod %s: %v", name, err)
+	}
+}
+
+func (obj *SmokeTest) deletePVC(name string) {
+	err := obj.KubeClientset.CoreV1().PersistentVolumeClaims(obj.OpenebsNamespace).
+		Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil {
+		klog.Errorf("failed to delete smoke test pvc %s: %v", name, err)
+	}
+}
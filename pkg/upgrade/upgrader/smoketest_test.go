@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSmokeTest_Init(t *testing.T) {
+	client := NewTestClient(
+		&storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: "cstor-mirror"},
+			Provisioner: cstorCSIProvisioner,
+			Parameters:  map[string]string{"cstorPoolCluster": "cspc-mirror"},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+			Spec:       corev1.PersistentVolumeSpec{StorageClassName: "jiva-sc"},
+		},
+	)
+	tests := []struct {
+		name     string
+		kind     string
+		resource string
+		override string
+		want     string
+		wantErr  bool
+	}{
+		{name: "cspc", kind: "cstorPoolCluster", resource: "cspc-mirror", want: "cstor-mirror"},
+		{name: "cspc without storageclass", kind: "cstorPoolCluster", resource: "cspc-stripe", wantErr: true},
+		{name: "volume", kind: "jivaVolume", resource: "pvc-1", want: "jiva-sc"},
+		{name: "missing volume", kind: "cstorVolume", resource: "pvc-2", wantErr: true},
+		{name: "override", kind: "cstorVolume", resource: "pvc-2", override: "custom-sc", want: "custom-sc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := NewSmokeTest(
+				WithSmokeTestResorcePatch(NewResourcePatch(
+					WithName(tt.resource),
+					WithSmokeTestStorageClass(tt.override),
+				)),
+				WithSmokeTestClient(client),
+			)
+			err := obj.Init(tt.kind)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && obj.StorageClass != tt.want {
+				t.Errorf("Init() storageclass = %s, want %s", obj.StorageClass, tt.want)
+			}
+		})
+	}
+}
+
+func TestSmokeTest_Run(t *testing.T) {
+	tests := []struct {
+		name    string
+		phase   corev1.PodPhase
+		wantErr bool
+	}{
+		{name: "passed", phase: corev1.PodSucceeded},
+		{name: "failed", phase: corev1.PodFailed, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient()
+			clientset := fakeKube(client)
+			// the fake clientset neither generates names nor runs pods
+			clientset.PrependReactor("create", "persistentvolumeclaims",
+				func(action k8stesting.Action) (bool, runtime.Object, error) {
+					pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+					pvc.Name = pvc.GenerateName + "1"
+					return false, nil, nil
+				})
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).Status.Phase = tt.phase
+				return false, nil, nil
+			})
+			obj := NewSmokeTest(
+				WithSmokeTestResorcePatch(&ResourcePatch{OpenebsNamespace: upgradetesting.Namespace}),
+				WithSmokeTestClient(client),
+			)
+			obj.StorageClass = "cstor-mirror"
+			err := obj.Run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			pvcs, _ := clientset.CoreV1().PersistentVolumeClaims(upgradetesting.Namespace).
+				List(context.TODO(), metav1.ListOptions{})
+			pods, _ := clientset.CoreV1().Pods(upgradetesting.Namespace).List(context.TODO(), metav1.ListOptions{})
+			if len(pvcs.Items) != 0 || len(pods.Items) != 0 {
+				t.Errorf("Run() left %d pvcs and %d pods", len(pvcs.Items), len(pods.Items))
+			}
+		})
+	}
+}
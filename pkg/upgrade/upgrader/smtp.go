@@ -0,0 +1,236 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// EnvSMTPUsername and EnvSMTPPassword are the environment variables
+	// of the smtp credentials, so that they need not be passed as flags
+	EnvSMTPUsername = "SMTP_USERNAME"
+	EnvSMTPPassword = "SMTP_PASSWORD"
+
+	// SMTPAuthPlain and SMTPAuthLogin are the supported smtp auth mechanisms
+	SMTPAuthPlain = "PLAIN"
+	SMTPAuthLogin = "LOGIN"
+
+	// DefaultSMTPPort is the submission port, which uses STARTTLS
+	DefaultSMTPPort = 587
+
+	// smtpTimeout is the time after which connecting to the server is given up
+	smtpTimeout = 30 * time.Second
+)
+
+// SMTPNotifier sends an email with the report of an upgrade run
+type SMTPNotifier struct {
+	Host string
+	Port int
+	From string
+	To   []string
+	// SubjectPrefix is prepended to the subject of the emails
+	SubjectPrefix string
+	// Username and Password authenticate with the server using the
+	// Auth mechanism, PLAIN if not set, if the username is set
+	Username string
+	Password string
+	Auth     string
+	// TLS connects to the server using TLS, as on port 465. Otherwise
+	// the connection is upgraded using STARTTLS if the server supports it.
+	TLS bool
+}
+
+// Enabled returns true if a server is set to send the emails to
+func (n *SMTPNotifier) Enabled() bool {
+	return n != nil && n.Host != ""
+}
+
+// Validate returns a validation error if the notifier is enabled
+// but missing the addresses or the credentials to send the emails
+func (n *SMTPNotifier) Validate() error {
+	if !n.Enabled() {
+		return nil
+	}
+	switch {
+	case n.Port <= 0 || n.Port > 65535:
+		return newValidationError(errors.Errorf("invalid smtp port %d", n.Port))
+	case n.From == "":
+		return newValidationError(errors.Errorf("missing the smtp from address"))
+	case len(n.To) == 0:
+		return newValidationError(errors.Errorf("missing the smtp to addresses"))
+	case n.Username == "" && n.Password != "":
+		return newValidationError(errors.Errorf("missing the smtp username of the smtp password"))
+	}
+	switch strings.ToUpper(n.Auth) {
+	case "", SMTPAuthPlain, SMTPAuthLogin:
+		return nil
+	}
+	return newValidationError(
+		errors.Errorf("invalid smtp auth %q, expected one of PLAIN or LOGIN", n.Auth),
+	)
+}
+
+// subject returns the subject of the email of the report
+func (n *SMTPNotifier) subject(report *PostUpgradeReport) string {
+	outcome := "succeeded"
+	if report.Failed > 0 {
+		outcome = "failed"
+	}
+	subject := fmt.Sprintf("Upgrade from %s to %s %s: %d upgraded, %d skipped, %d failed",
+		report.From, report.To, outcome, report.Upgraded, report.Skipped, report.Failed)
+	if n.SubjectPrefix != "" {
+		subject = n.SubjectPrefix + " " + subject
+	}
+	return subject
+}
+
+// Message returns the plain text email of the report, with the
+// text report followed by the errors of the failed resources
+func (n *SMTPNotifier) Message(report *PostUpgradeReport) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", n.subject(report))
+	fmt.Fprintf(&b, "Date: %s\r\n", report.Timestamp.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	err := report.Write(&b, ReportText)
+	if err != nil {
+		return nil, err
+	}
+	if report.Failed > 0 {
+		b.WriteString("\nErrors:\n")
+		for _, res := range report.Resources {
+			if res.Status == ResultFailed {
+				fmt.Fprintf(&b, "- %s %s: %s\n", res.Kind, res.Name, res.Message)
+			}
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// Notify sends the email of the report to the to addresses
+func (n *SMTPNotifier) Notify(report *PostUpgradeReport) error {
+	msg, err := n.Message(report)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	if n.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: n.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to connect to smtp server %s", addr))
+	}
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return newAPIError(errors.Wrapf(err, "failed to connect to smtp server %s", addr))
+	}
+	defer c.Close()
+	if !n.TLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			err = c.StartTLS(&tls.Config{ServerName: n.Host})
+			if err != nil {
+				return newAPIError(errors.Wrapf(err, "failed to start tls with smtp server %s", addr))
+			}
+		}
+	}
+	if n.Username != "" {
+		err = c.Auth(n.auth())
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to authenticate with smtp server %s", addr))
+		}
+	}
+	err = c.Mail(n.From)
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to send email from %s", n.From))
+	}
+	for _, to := range n.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to send email to %s", to))
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to send email"))
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to send email"))
+	}
+	err = w.Close()
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to send email"))
+	}
+	return c.Quit()
+}
+
+func (n *SMTPNotifier) auth() smtp.Auth {
+	if strings.ToUpper(n.Auth) == SMTPAuthLogin {
+		return &loginAuth{username: n.Username, password: n.Password, host: n.Host}
+	}
+	return smtp.PlainAuth("", n.Username, n.Password, n.Host)
+}
+
+// loginAuth implements the LOGIN auth mechanism, which net/smtp does not
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// as for PLAIN, the credentials are only sent in the clear to localhost
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return SMTPAuthLogin, nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, errors.Errorf("unexpected smtp server challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// fakeSMTPServer accepts a single session, asks the LOGIN credentials
+// or accepts PLAIN ones, and records the commands and the message
+type fakeSMTPServer struct {
+	listener net.Listener
+	commands []string
+	auth     string
+	message  string
+	done     chan struct{}
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &fakeSMTPServer{listener: l, done: make(chan struct{})}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	read := func() string {
+		line, _ := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n")
+	}
+	decode := func(s string) string {
+		out, _ := base64.StdEncoding.DecodeString(s)
+		return string(out)
+	}
+	reply("220 localhost ESMTP")
+	for {
+		cmd := read()
+		if cmd == "" {
+			return
+		}
+		s.commands = append(s.commands, strings.Fields(cmd)[0])
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN LOGIN")
+		case strings.HasPrefix(cmd, "AUTH PLAIN"):
+			s.auth = strings.Replace(decode(strings.Fields(cmd)[2]), "\x00", ":", -1)
+			reply("235 ok")
+		case strings.HasPrefix(cmd, "AUTH LOGIN"):
+			reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+			user := decode(read())
+			reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+			s.auth = ":" + user + ":" + decode(read())
+			reply("235 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for line := read(); line != "."; line = read() {
+				msg.WriteString(line + "\n")
+			}
+			s.message = msg.String()
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func newTestSMTPNotifier(port int) *SMTPNotifier {
+	return &SMTPNotifier{
+		Host:          "127.0.0.1",
+		Port:          port,
+		From:          "upgrade@example.com",
+		To:            []string{"ops@example.com", "storage@example.com"},
+		SubjectPrefix: "[OpenEBS upgrade]",
+		Username:      "user",
+		Password:      "secret",
+	}
+}
+
+func TestSMTPNotifier_Validate(t *testing.T) {
+	tests := map[string]struct {
+		modify  func(n *SMTPNotifier)
+		wantErr bool
+	}{
+		"valid":            {modify: func(n *SMTPNotifier) {}},
+		"disabled":         {modify: func(n *SMTPNotifier) { *n = SMTPNotifier{} }},
+		"login auth":       {modify: func(n *SMTPNotifier) { n.Auth = "login" }},
+		"invalid auth":     {modify: func(n *SMTPNotifier) { n.Auth = "CRAM-MD5" }, wantErr: true},
+		"missing from":     {modify: func(n *SMTPNotifier) { n.From = "" }, wantErr: true},
+		"missing to":       {modify: func(n *SMTPNotifier) { n.To = nil }, wantErr: true},
+		"invalid port":     {modify: func(n *SMTPNotifier) { n.Port = 0 }, wantErr: true},
+		"missing username": {modify: func(n *SMTPNotifier) { n.Username = "" }, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			n := newTestSMTPNotifier(DefaultSMTPPort)
+			tt.modify(n)
+			err := n.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrValidation) {
+				t.Errorf("Validate() error = %v, want a validation error", err)
+			}
+		})
+	}
+}
+
+func TestSMTPNotifier_Message(t *testing.T) {
+	n := newTestSMTPNotifier(DefaultSMTPPort)
+	msg, err := n.Message(newTestPartialResult("").Report())
+	if err != nil {
+		t.Fatalf("Message() error = %v", err)
+	}
+	got := string(msg)
+	for _, want := range []string{
+		"From: upgrade@example.com\r\n",
+		"To: ops@example.com, storage@example.com\r\n",
+		"Subject: [OpenEBS upgrade] Upgrade from 2.12.0 to 3.0.0 failed: 1 upgraded, 1 skipped, 1 failed\r\n",
+		"Upgraded: 1, Skipped: 1, Failed: 1",
+		"- cstorPoolInstance cspi-3: failed to patch\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Message() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestSMTPNotifier_Notify(t *testing.T) {
+	for _, auth := range []string{SMTPAuthPlain, SMTPAuthLogin} {
+		t.Run(auth, func(t *testing.T) {
+			s := newFakeSMTPServer(t)
+			n := newTestSMTPNotifier(s.port())
+			n.Auth = auth
+			if err := n.Notify(newTestPartialResult("").Report()); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+			<-s.done
+			if s.auth != ":user:secret" {
+				t.Errorf("server got credentials %q, want %q", s.auth, ":user:secret")
+			}
+			want := "EHLO AUTH MAIL RCPT RCPT DATA QUIT"
+			if got := strings.Join(s.commands, " "); got != want {
+				t.Errorf("server got commands %q, want %q", got, want)
+			}
+			if !strings.Contains(s.message, "Subject: [OpenEBS upgrade] Upgrade from 2.12.0 to 3.0.0") {
+				t.Errorf("server got message %q without the subject", s.message)
+			}
+		})
+	}
+}
+
+func TestSMTPNotifier_NotifyUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	err = newTestSMTPNotifier(port).Notify(newTestPartialResult("").Report())
+	if !errors.Is(err, ErrAPI) {
+		t.Errorf("Notify() error = %v, want an api error", err)
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	a := &loginAuth{username: "user", password: "secret", host: "smtp.example.com"}
+	_, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com"})
+	if err == nil {
+		t.Errorf("Start() over an unencrypted connection did not fail")
+	}
+	mech, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true})
+	if err != nil || mech != SMTPAuthLogin {
+		t.Fatalf("Start() = %q, %v, want LOGIN", mech, err)
+	}
+	for challenge, want := range map[string]string{"Username:": "user", "Password:": "secret"} {
+		got, err := a.Next([]byte(challenge), true)
+		if err != nil || string(got) != want {
+			t.Errorf("Next(%q) = %q, %v, want %q", challenge, got, err, want)
+		}
+	}
+	if _, err := a.Next([]byte("Other:"), true); err == nil {
+		t.Errorf("Next() of an unexpected challenge did not fail")
+	}
+}
@@ -0,0 +1,385 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	snapv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+const (
+	// originalDeletionPolicyAnnotation records the deletion policy of a
+	// volumesnapshotcontent while it is retained during the upgrade
+	originalDeletionPolicyAnnotation = "openebs.io/original-deletion-policy"
+	// snapshotBindTimeout is the time to wait for a recreated
+	// volumesnapshot to be bound and ready to use
+	snapshotBindTimeout = 5 * time.Minute
+)
+
+// SnapshotClassPatch is the patch required to upgrade a volumesnapshotclass
+// and the volumesnapshotcontents which reference it
+type SnapshotClassPatch struct {
+	*ResourcePatch
+	*Client
+}
+
+// SnapshotClassPatchOptions ...
+type SnapshotClassPatchOptions func(*SnapshotClassPatch)
+
+// WithSnapshotClassResorcePatch ...
+func WithSnapshotClassResorcePatch(r *ResourcePatch) SnapshotClassPatchOptions {
+	return func(obj *SnapshotClassPatch) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithSnapshotClassClient ...
+func WithSnapshotClassClient(c *Client) SnapshotClassPatchOptions {
+	return func(obj *SnapshotClassPatch) {
+		obj.Client = c
+	}
+}
+
+// NewSnapshotClassPatch ...
+func NewSnapshotClassPatch(opts ...SnapshotClassPatchOptions) *SnapshotClassPatch {
+	obj := &SnapshotClassPatch{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+func (obj *SnapshotClassPatch) driver() string {
+	if obj.SnapshotDriver != "" {
+		return obj.SnapshotDriver
+	}
+	return cstorCSIProvisioner
+}
+
+// Upgrade updates the driver and parameters of the volumesnapshotclass and
+// moves the volumesnapshotcontents of the class with another driver to the
+// new driver. The steps are idempotent, so a failed upgrade can be rerun to
+// continue from where it stopped.
+func (obj *SnapshotClassPatch) Upgrade() error {
+	if obj.namespaceScoped {
+		return newValidationError(
+			errors.Errorf("the snapshotclasses are cluster scoped, they cannot be upgraded in namespace scoped mode"),
+		)
+	}
+	if obj.SnapshotClientset == nil {
+		return newValidationError(errors.Errorf("snapshot client is not configured"))
+	}
+	classClient := obj.SnapshotClientset.SnapshotV1().VolumeSnapshotClasses()
+	classObj, err := classClient.Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to get snapshotclass %s", obj.Name))
+	}
+	newClass := transformSnapshotClass(classObj, obj.driver(), obj.SnapshotClassParameters)
+	if newClass == nil {
+		klog.Infof("snapshotclass %s is already up to date", obj.Name)
+	} else if obj.VerifyOnly {
+		klog.Infof("skipping update of snapshotclass %s in verify only mode", obj.Name)
+	} else {
+		err = obj.recreateSnapshotClass(newClass)
+		if err != nil {
+			return err
+		}
+	}
+	contentList, err := obj.SnapshotClientset.SnapshotV1().VolumeSnapshotContents().
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrap(err, "failed to list volumesnapshotcontents"))
+	}
+	for i := range contentList.Items {
+		contentObj := &contentList.Items[i]
+		if !obj.needsContentUpgrade(contentObj) {
+			continue
+		}
+		if obj.VerifyOnly {
+			klog.Infof("skipping upgrade of volumesnapshotcontent %s in verify only mode", contentObj.Name)
+			continue
+		}
+		err = obj.upgradeSnapshotContent(contentObj)
+		if err != nil {
+			return newPartialFailureError(
+				errors.Wrapf(err, "failed to upgrade volumesnapshotcontent %s", contentObj.Name),
+			)
+		}
+	}
+	// the contents of a previous upgrade which failed
+	// before their deletion policy was restored
+	for i := range contentList.Items {
+		contentObj := &contentList.Items[i]
+		if obj.VerifyOnly || !obj.isRetainedForUpgrade(contentObj) {
+			continue
+		}
+		err = obj.restoreDeletionPolicy(contentObj.Name)
+		if err != nil {
+			return newPartialFailureError(err)
+		}
+	}
+	return nil
+}
+
+// transformSnapshotClass returns the class with the driver and parameters
+// set, or nil if the class already has them
+func transformSnapshotClass(c *snapv1.VolumeSnapshotClass, driver string,
+	parameters map[string]string) *snapv1.VolumeSnapshotClass {
+	newClass := c.DeepCopy()
+	newClass.Driver = driver
+	for k, v := range parameters {
+		if v == "" {
+			delete(newClass.Parameters, k)
+			continue
+		}
+		if newClass.Parameters == nil {
+			newClass.Parameters = map[string]string{}
+		}
+		newClass.Parameters[k] = v
+	}
+	if newClass.Driver == c.Driver && reflect.DeepEqual(newClass.Parameters, c.Parameters) {
+		return nil
+	}
+	return newClass
+}
+
+// recreateSnapshotClass replaces the class with the new one of the same
+// name, as the driver and parameters of a class cannot be updated
+func (obj *SnapshotClassPatch) recreateSnapshotClass(newClass *snapv1.VolumeSnapshotClass) error {
+	classClient := obj.SnapshotClientset.SnapshotV1().VolumeSnapshotClasses()
+	klog.Infof("Recreating snapshotclass %s with driver %s", newClass.Name, newClass.Driver)
+	err := classClient.Delete(context.TODO(), newClass.Name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return newAPIError(errors.Wrapf(err, "failed to delete snapshotclass %s", newClass.Name))
+	}
+	newClass.ResourceVersion = ""
+	newClass.UID = ""
+	_, err = classClient.Create(context.TODO(), newClass, metav1.CreateOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to create snapshotclass %s", newClass.Name))
+	}
+	return nil
+}
+
+// needsContentUpgrade returns true if the content is of the
+// snapshotclass and has a driver other than the new driver
+func (obj *SnapshotClassPatch) needsContentUpgrade(c *snapv1.VolumeSnapshotContent) bool {
+	return c.Spec.VolumeSnapshotClassName != nil &&
+		*c.Spec.VolumeSnapshotClassName == obj.Name &&
+		c.Spec.Driver != obj.driver()
+}
+
+// isRetainedForUpgrade returns true if the content of the snapshotclass
+// has the new driver but its deletion policy is not yet restored
+func (obj *SnapshotClassPatch) isRetainedForUpgrade(c *snapv1.VolumeSnapshotContent) bool {
+	return c.Spec.VolumeSnapshotClassName != nil &&
+		*c.Spec.VolumeSnapshotClassName == obj.Name &&
+		c.Spec.Driver == obj.driver() &&
+		c.Annotations[originalDeletionPolicyAnnotation] != ""
+}
+
+// upgradedContentName returns the name of the content
+// recreated for the given content with the new driver
+func upgradedContentName(name, to string) string {
+	return name + "-" + strings.ReplaceAll(to, ".", "-")
+}
+
+// newSnapshotContent returns the pre-provisioned content with the new
+// driver for the snapshot of the old content. It is created with the
+// Retain policy, so that deleting it on a failure keeps the snapshot.
+func newSnapshotContent(old *snapv1.VolumeSnapshotContent, driver, to string) (*snapv1.VolumeSnapshotContent, error) {
+	handle := old.Spec.Source.SnapshotHandle
+	if old.Status != nil && old.Status.SnapshotHandle != nil {
+		handle = old.Status.SnapshotHandle
+	}
+	if handle == nil || *handle == "" {
+		return nil, errors.Errorf("volumesnapshotcontent %s has no snapshot handle", old.Name)
+	}
+	return &snapv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        upgradedContentName(old.Name, to),
+			Labels:      old.Labels,
+			Annotations: map[string]string{originalDeletionPolicyAnnotation: string(originalDeletionPolicy(old))},
+		},
+		Spec: snapv1.VolumeSnapshotContentSpec{
+			DeletionPolicy:          snapv1.VolumeSnapshotContentRetain,
+			Driver:                  driver,
+			VolumeSnapshotClassName: old.Spec.VolumeSnapshotClassName,
+			Source: snapv1.VolumeSnapshotContentSource{
+				SnapshotHandle: handle,
+			},
+			VolumeSnapshotRef: corev1.ObjectReference{
+				APIVersion: "snapshot.storage.k8s.io/v1",
+				Kind:       "VolumeSnapshot",
+				Name:       old.Spec.VolumeSnapshotRef.Name,
+				Namespace:  old.Spec.VolumeSnapshotRef.Namespace,
+			},
+		},
+	}, nil
+}
+
+// originalDeletionPolicy returns the deletion policy of the content
+// before it was retained for the upgrade
+func originalDeletionPolicy(c *snapv1.VolumeSnapshotContent) snapv1.DeletionPolicy {
+	if p := c.Annotations[originalDeletionPolicyAnnotation]; p != "" {
+		return snapv1.DeletionPolicy(p)
+	}
+	return c.Spec.DeletionPolicy
+}
+
+// upgradeSnapshotContent moves the snapshot of the old content to a new
+// content with the new driver, as the driver and source of a content are
+// immutable. The old content is retained so that the snapshot is not
+// deleted, the volumesnapshot is recreated bound to the new content, and
+// then the old content is deleted and the original policy is restored.
+func (obj *SnapshotClassPatch) upgradeSnapshotContent(old *snapv1.VolumeSnapshotContent) error {
+	contentClient := obj.SnapshotClientset.SnapshotV1().VolumeSnapshotContents()
+	newContent, err := newSnapshotContent(old, obj.driver(), obj.To)
+	if err != nil {
+		return err
+	}
+	if old.Spec.DeletionPolicy != snapv1.VolumeSnapshotContentRetain {
+		klog.Infof("Retaining volumesnapshotcontent %s during the upgrade", old.Name)
+		retained := old.DeepCopy()
+		if retained.Annotations == nil {
+			retained.Annotations = map[string]string{}
+		}
+		retained.Annotations[originalDeletionPolicyAnnotation] = string(originalDeletionPolicy(old))
+		retained.Spec.DeletionPolicy = snapv1.VolumeSnapshotContentRetain
+		_, err = contentClient.Update(context.TODO(), retained, metav1.UpdateOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to retain volumesnapshotcontent %s", old.Name)
+		}
+	}
+	klog.Infof("Creating volumesnapshotcontent %s with driver %s", newContent.Name, newContent.Spec.Driver)
+	_, err = contentClient.Create(context.TODO(), newContent, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create volumesnapshotcontent %s", newContent.Name)
+	}
+	err = obj.rebindVolumeSnapshot(old.Spec.VolumeSnapshotRef, newContent.Name)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Deleting the retained volumesnapshotcontent %s", old.Name)
+	err = contentClient.Delete(context.TODO(), old.Name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete volumesnapshotcontent %s", old.Name)
+	}
+	return obj.restoreDeletionPolicy(newContent.Name)
+}
+
+// restoreDeletionPolicy sets the deletion policy of the content
+// back to the one recorded before it was retained
+func (obj *SnapshotClassPatch) restoreDeletionPolicy(name string) error {
+	contentClient := obj.SnapshotClientset.SnapshotV1().VolumeSnapshotContents()
+	contentObj, err := contentClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get volumesnapshotcontent %s", name)
+	}
+	contentObj.Spec.DeletionPolicy = originalDeletionPolicy(contentObj)
+	delete(contentObj.Annotations, originalDeletionPolicyAnnotation)
+	_, err = contentClient.Update(context.TODO(), contentObj, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to restore the deletion policy of volumesnapshotcontent %s", name)
+	}
+	return nil
+}
+
+// rebindVolumeSnapshot recreates the volumesnapshot, as its source is
+// immutable, as a pre-provisioned snapshot of the given content and
+// waits for it to be bound and ready to use
+func (obj *SnapshotClassPatch) rebindVolumeSnapshot(ref corev1.ObjectReference, contentName string) error {
+	snapClient := obj.SnapshotClientset.SnapshotV1().VolumeSnapshots(ref.Namespace)
+	snapObj, err := snapClient.Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to get volumesnapshot %s/%s", ref.Namespace, ref.Name)
+	}
+	if err == nil && !isSnapshotOfContent(snapObj, contentName) {
+		klog.Infof("Recreating volumesnapshot %s/%s for volumesnapshotcontent %s", ref.Namespace, ref.Name, contentName)
+		err = snapClient.Delete(context.TODO(), ref.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete volumesnapshot %s/%s", ref.Namespace, ref.Name)
+		}
+		err = wait.PollImmediate(time.Second, snapshotBindTimeout, func() (bool, error) {
+			_, err := snapClient.Get(context.TODO(), ref.Name, metav1.GetOptions{})
+			if k8serrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+		if err != nil {
+			return newTimeoutError(errors.Wrapf(err, "failed to wait for the deletion of volumesnapshot %s/%s",
+				ref.Namespace, ref.Name))
+		}
+		snapObj = nil
+	}
+	if snapObj == nil {
+		_, err = snapClient.Create(context.TODO(), newVolumeSnapshot(ref, contentName, obj.Name), metav1.CreateOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to create volumesnapshot %s/%s", ref.Namespace, ref.Name)
+		}
+	}
+	err = wait.PollImmediate(time.Second, snapshotBindTimeout, func() (bool, error) {
+		snapObj, err := snapClient.Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return isSnapshotReady(snapObj, contentName), nil
+	})
+	if err != nil {
+		return newTimeoutError(errors.Wrapf(err, "failed to wait for volumesnapshot %s/%s to be bound to %s",
+			ref.Namespace, ref.Name, contentName))
+	}
+	return nil
+}
+
+func newVolumeSnapshot(ref corev1.ObjectReference, contentName, className string) *snapv1.VolumeSnapshot {
+	return &snapv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ref.Name,
+			Namespace: ref.Namespace,
+		},
+		Spec: snapv1.VolumeSnapshotSpec{
+			Source: snapv1.VolumeSnapshotSource{
+				VolumeSnapshotContentName: &contentName,
+			},
+			VolumeSnapshotClassName: &className,
+		},
+	}
+}
+
+func isSnapshotOfContent(s *snapv1.VolumeSnapshot, contentName string) bool {
+	return s.Spec.Source.VolumeSnapshotContentName != nil &&
+		*s.Spec.Source.VolumeSnapshotContentName == contentName
+}
+
+func isSnapshotReady(s *snapv1.VolumeSnapshot, contentName string) bool {
+	return isSnapshotOfContent(s, contentName) && s.Status != nil &&
+		s.Status.BoundVolumeSnapshotContentName != nil &&
+		*s.Status.BoundVolumeSnapshotContentName == contentName &&
+		s.Status.ReadyToUse != nil && *s.Status.ReadyToUse
+}
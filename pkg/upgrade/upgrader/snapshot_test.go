@@ -0,0 +1,237 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	snapv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTransformSnapshotClass(t *testing.T) {
+	class := &snapv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-cstor-snapshotclass"},
+		Driver:     "cstor.csi.openebs.io",
+		Parameters: map[string]string{"old": "1"},
+	}
+	tests := []struct {
+		name       string
+		driver     string
+		parameters map[string]string
+		want       *snapv1.VolumeSnapshotClass
+	}{
+		{
+			name:   "up to date",
+			driver: "cstor.csi.openebs.io",
+		},
+		{
+			name:   "new driver",
+			driver: "new.csi.openebs.io",
+			want: &snapv1.VolumeSnapshotClass{
+				ObjectMeta: class.ObjectMeta,
+				Driver:     "new.csi.openebs.io",
+				Parameters: map[string]string{"old": "1"},
+			},
+		},
+		{
+			name:       "parameter added and removed",
+			driver:     "cstor.csi.openebs.io",
+			parameters: map[string]string{"old": "", "new": "2"},
+			want: &snapv1.VolumeSnapshotClass{
+				ObjectMeta: class.ObjectMeta,
+				Driver:     "cstor.csi.openebs.io",
+				Parameters: map[string]string{"new": "2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transformSnapshotClass(class, tt.driver, tt.parameters)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transformSnapshotClass() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if class.Parameters["old"] != "1" {
+		t.Errorf("transformSnapshotClass() modified the class")
+	}
+}
+
+func TestNewSnapshotContent(t *testing.T) {
+	className := "csi-cstor-snapshotclass"
+	handle := "pvc-1@snapshot-1"
+	old := &snapv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-1"},
+		Spec: snapv1.VolumeSnapshotContentSpec{
+			DeletionPolicy:          snapv1.VolumeSnapshotContentDelete,
+			Driver:                  "old.csi.openebs.io",
+			VolumeSnapshotClassName: &className,
+		},
+		Status: &snapv1.VolumeSnapshotContentStatus{SnapshotHandle: &handle},
+	}
+	old.Spec.VolumeSnapshotRef.Name = "snapshot-1"
+	old.Spec.VolumeSnapshotRef.Namespace = "default"
+
+	got, err := newSnapshotContent(old, cstorCSIProvisioner, "3.0.0")
+	if err != nil {
+		t.Fatalf("newSnapshotContent() error = %v", err)
+	}
+	if got.Name != "snapcontent-1-3-0-0" || got.Spec.Driver != cstorCSIProvisioner ||
+		*got.Spec.Source.SnapshotHandle != handle || got.Spec.VolumeSnapshotRef.Name != "snapshot-1" {
+		t.Errorf("newSnapshotContent() = %+v", got)
+	}
+	if got.Spec.DeletionPolicy != snapv1.VolumeSnapshotContentRetain ||
+		originalDeletionPolicy(got) != snapv1.VolumeSnapshotContentDelete {
+		t.Errorf("newSnapshotContent() policy = %s, original %s", got.Spec.DeletionPolicy, originalDeletionPolicy(got))
+	}
+
+	old.Status = nil
+	_, err = newSnapshotContent(old, cstorCSIProvisioner, "3.0.0")
+	if err == nil {
+		t.Errorf("newSnapshotContent() without a snapshot handle succeeded")
+	}
+}
+
+func TestIsSnapshotReady(t *testing.T) {
+	content := "snapcontent-1-3-0-0"
+	other := "snapcontent-1"
+	ready := true
+	snap := func(source, bound *string, readyToUse *bool) *snapv1.VolumeSnapshot {
+		s := &snapv1.VolumeSnapshot{}
+		s.Spec.Source.VolumeSnapshotContentName = source
+		s.Status = &snapv1.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: bound, ReadyToUse: readyToUse}
+		return s
+	}
+	tests := []struct {
+		name     string
+		snapshot *snapv1.VolumeSnapshot
+		want     bool
+	}{
+		{name: "ready", snapshot: snap(&content, &content, &ready), want: true},
+		{name: "not ready", snapshot: snap(&content, &content, nil)},
+		{name: "not bound", snapshot: snap(&content, nil, nil)},
+		{name: "old source", snapshot: snap(&other, &other, &ready)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSnapshotReady(tt.snapshot, content); got != tt.want {
+				t.Errorf("isSnapshotReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestSnapshotClient returns the client of a snapshotclass with the
+// given driver and its snapshot, whose contents are bound and ready to
+// use as soon as the snapshots are created
+func newTestSnapshotClient(driver string) *Client {
+	className := "csi-cstor-snapshotclass"
+	handle := "snapshot-1"
+	contentName := "snapcontent-1"
+	client := NewTestClient(
+		&snapv1.VolumeSnapshotClass{
+			ObjectMeta:     metav1.ObjectMeta{Name: className},
+			Driver:         driver,
+			DeletionPolicy: snapv1.VolumeSnapshotContentDelete,
+		},
+		&snapv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: contentName},
+			Spec: snapv1.VolumeSnapshotContentSpec{
+				DeletionPolicy:          snapv1.VolumeSnapshotContentDelete,
+				Driver:                  driver,
+				VolumeSnapshotClassName: &className,
+				Source:                  snapv1.VolumeSnapshotContentSource{SnapshotHandle: &handle},
+				VolumeSnapshotRef:       corev1.ObjectReference{Namespace: "default", Name: "snap-1"},
+			},
+		},
+		&snapv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "snap-1", Namespace: "default"},
+			Spec: snapv1.VolumeSnapshotSpec{
+				Source:                  snapv1.VolumeSnapshotSource{VolumeSnapshotContentName: &contentName},
+				VolumeSnapshotClassName: &className,
+			},
+		},
+	)
+	fakeSnapshot(client).PrependReactor("create", "volumesnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		snapObj := action.(k8stesting.CreateAction).GetObject().(*snapv1.VolumeSnapshot)
+		ready := true
+		snapObj.Status = &snapv1.VolumeSnapshotStatus{
+			BoundVolumeSnapshotContentName: snapObj.Spec.Source.VolumeSnapshotContentName,
+			ReadyToUse:                     &ready,
+		}
+		return false, nil, nil
+	})
+	return client
+}
+
+func TestSnapshotClassPatch_Upgrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		verifyOnly  bool
+		wantDriver  string
+		wantContent string
+	}{
+		{name: "upgraded", wantDriver: cstorCSIProvisioner, wantContent: "snapcontent-1-3-0-0"},
+		{name: "verify only", verifyOnly: true, wantDriver: "old.csi.openebs.io", wantContent: "snapcontent-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestSnapshotClient("old.csi.openebs.io")
+			obj := NewSnapshotClassPatch(
+				WithSnapshotClassResorcePatch(NewResourcePatch(
+					WithName("csi-cstor-snapshotclass"),
+					ToVersion("3.0.0"),
+					WithVerifyOnly(tt.verifyOnly),
+				)),
+				WithSnapshotClassClient(client),
+			)
+			if err := obj.Upgrade(); err != nil {
+				t.Fatalf("Upgrade() error = %v", err)
+			}
+			snapshots := client.SnapshotClientset.SnapshotV1()
+			classObj, err := snapshots.VolumeSnapshotClasses().
+				Get(context.TODO(), "csi-cstor-snapshotclass", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if classObj.Driver != tt.wantDriver {
+				t.Errorf("snapshotclass driver = %s, want %s", classObj.Driver, tt.wantDriver)
+			}
+			contentObj, err := snapshots.VolumeSnapshotContents().Get(context.TODO(), tt.wantContent, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentObj.Spec.Driver != tt.wantDriver || contentObj.Spec.DeletionPolicy != snapv1.VolumeSnapshotContentDelete {
+				t.Errorf("volumesnapshotcontent %s has driver %s and policy %s, want %s and Delete",
+					contentObj.Name, contentObj.Spec.Driver, contentObj.Spec.DeletionPolicy, tt.wantDriver)
+			}
+			snapObj, err := snapshots.VolumeSnapshots("default").Get(context.TODO(), "snap-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isSnapshotOfContent(snapObj, tt.wantContent) {
+				t.Errorf("volumesnapshot snap-1 is not of volumesnapshotcontent %s", tt.wantContent)
+			}
+		})
+	}
+}
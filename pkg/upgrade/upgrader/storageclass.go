@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// storageClassBackupPrefix is the prefix of the configmap
+	// which keeps the storageclass before it is migrated
+	storageClassBackupPrefix = "storageclass-backup-"
+	// storageClassBackupKey is the key of the storageclass in the backup
+	storageClassBackupKey = "storageclass.yaml"
+)
+
+// StorageClassMigrator moves a cstor storageclass to a new provisioner.
+// The provisioner of a storageclass is immutable, so the storageclass is
+// created again under a new name and the pvs are moved to it.
+type StorageClassMigrator struct {
+	*ResourcePatch
+	*Client
+}
+
+// StorageClassMigratorOptions ...
+type StorageClassMigratorOptions func(*StorageClassMigrator)
+
+// WithStorageClassResorcePatch ...
+func WithStorageClassResorcePatch(r *ResourcePatch) StorageClassMigratorOptions {
+	return func(obj *StorageClassMigrator) {
+		obj.ResourcePatch = r
+	}
+}
+
+// WithStorageClassClient ...
+func WithStorageClassClient(c *Client) StorageClassMigratorOptions {
+	return func(obj *StorageClassMigrator) {
+		obj.Client = c
+	}
+}
+
+// NewStorageClassMigrator ...
+func NewStorageClassMigrator(opts ...StorageClassMigratorOptions) *StorageClassMigrator {
+	obj := &StorageClassMigrator{}
+	for _, o := range opts {
+		o(obj)
+	}
+	return obj
+}
+
+// newName returns the name of the migrated storageclass, which
+// is the old name suffixed with the To version if not set
+func (obj *StorageClassMigrator) newName() string {
+	if obj.NewStorageClassName != "" {
+		return obj.NewStorageClassName
+	}
+	return obj.Name + "-" + strings.ReplaceAll(obj.To, ".", "-")
+}
+
+// Upgrade migrates the storageclass in steps: the storageclass is backed
+// up in a configmap, the new storageclass is created with the new
+// provisioner, the pvs are moved to the new storageclass and the old
+// storageclass is deleted. The steps are idempotent, so a failed
+// migration can be rerun to continue from where it stopped.
+func (obj *StorageClassMigrator) Upgrade() error {
+	if obj.namespaceScoped {
+		return newValidationError(
+			errors.Errorf("the storageclasses are cluster scoped, they cannot be migrated in namespace scoped mode"),
+		)
+	}
+	if obj.StorageClassProvisioner == "" {
+		return newValidationError(errors.Errorf("no provisioner given for storageclass %s", obj.Name))
+	}
+	newName := obj.newName()
+	if newName == obj.Name {
+		return newValidationError(
+			errors.Errorf("the new name of storageclass %s must differ from its name", obj.Name),
+		)
+	}
+	scClient := obj.KubeClientset.StorageV1().StorageClasses()
+	oldSC, err := scClient.Get(context.TODO(), obj.Name, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return newAPIError(errors.Wrapf(err, "failed to get storageclass %s", obj.Name))
+	}
+	if err == nil && oldSC.Provisioner != cstorCSIProvisioner {
+		return newValidationError(errors.Errorf("storageclass %s has provisioner %s, expected %s",
+			obj.Name, oldSC.Provisioner, cstorCSIProvisioner))
+	}
+	// the old storageclass is deleted once the migration is complete
+	if k8serrors.IsNotFound(err) {
+		oldSC = nil
+		_, err = scClient.Get(context.TODO(), newName, metav1.GetOptions{})
+		if err != nil {
+			return newAPIError(errors.Wrapf(err, "failed to get storageclasses %s and %s", obj.Name, newName))
+		}
+	}
+	pvs, err := obj.storageClassPVs()
+	if err != nil {
+		return newAPIError(err)
+	}
+	if obj.DryRun {
+		klog.Infof("storageclass %s would be migrated to %s with provisioner %s, affecting %d pvs",
+			obj.Name, newName, obj.StorageClassProvisioner, len(pvs))
+		for _, pv := range pvs {
+			klog.Infof("pv %s would be moved to storageclass %s", pv.Name, newName)
+		}
+		return nil
+	}
+	if oldSC != nil {
+		err = obj.backupStorageClass(oldSC)
+		if err != nil {
+			return newAPIError(err)
+		}
+		err = obj.createStorageClass(oldSC, newName)
+		if err != nil {
+			return newAPIError(err)
+		}
+	}
+	for i, pv := range pvs {
+		klog.Infof("Moving pv %s to storageclass %s", pv.Name, newName)
+		pv.Spec.StorageClassName = newName
+		_, err = obj.KubeClientset.CoreV1().PersistentVolumes().Update(context.TODO(), pv, metav1.UpdateOptions{})
+		if err != nil {
+			err = errors.Wrapf(err, "failed to update the storageclass of pv %s", pv.Name)
+			if i > 0 {
+				return newPartialFailureError(err)
+			}
+			return newAPIError(err)
+		}
+	}
+	if oldSC != nil {
+		klog.Infof("Deleting storageclass %s", obj.Name)
+		err = scClient.Delete(context.TODO(), obj.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return newPartialFailureError(errors.Wrapf(err, "failed to delete storageclass %s", obj.Name))
+		}
+	}
+	return nil
+}
+
+// storageClassPVs returns the pvs which reference the old storageclass
+func (obj *StorageClassMigrator) storageClassPVs() ([]*corev1.PersistentVolume, error) {
+	pvList, err := obj.KubeClientset.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list pvs")
+	}
+	pvs := []*corev1.PersistentVolume{}
+	for i := range pvList.Items {
+		if pvList.Items[i].Spec.StorageClassName == obj.Name {
+			pvs = append(pvs, &pvList.Items[i])
+		}
+	}
+	return pvs, nil
+}
+
+// backupStorageClass saves the storageclass in a configmap in the openebs
+// namespace, an existing backup of a previous run is left as it is
+func (obj *StorageClassMigrator) backupStorageClass(sc *storagev1.StorageClass) error {
+	data, err := yaml.Marshal(sc)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal storageclass %s", sc.Name)
+	}
+	cmObj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      storageClassBackupPrefix + sc.Name,
+			Namespace: obj.OpenebsNamespace,
+		},
+		Data: map[string]string{storageClassBackupKey: string(data)},
+	}
+	klog.Infof("Backing up storageclass %s in configmap %s", sc.Name, cmObj.Name)
+	_, err = obj.KubeClientset.CoreV1().ConfigMaps(obj.OpenebsNamespace).
+		Create(context.TODO(), cmObj, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to back up storageclass %s", sc.Name)
+	}
+	return nil
+}
+
+// createStorageClass creates the copy of the old storageclass
+// with the new name and provisioner, if it does not exist
+func (obj *StorageClassMigrator) createStorageClass(old *storagev1.StorageClass, name string) error {
+	newSC := old.DeepCopy()
+	newSC.ObjectMeta = metav1.ObjectMeta{
+		Name:        name,
+		Labels:      old.Labels,
+		Annotations: old.Annotations,
+	}
+	newSC.Provisioner = obj.StorageClassProvisioner
+	klog.Infof("Creating storageclass %s with provisioner %s", name, newSC.Provisioner)
+	_, err := obj.KubeClientset.StorageV1().StorageClasses().
+		Create(context.TODO(), newSC, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create storageclass %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"testing"
+
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const testProvisioner = "cstor.csi.new.openebs.io"
+
+func testStorageClass(name, provisioner string) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: name},
+		Provisioner: provisioner,
+		Parameters:  map[string]string{"cstorPoolCluster": "cspc-a"},
+	}
+}
+
+func testStorageClassPV(name, sc string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.PersistentVolumeSpec{StorageClassName: sc},
+	}
+}
+
+func TestStorageClassMigrator_Upgrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		objs        []runtime.Object
+		provisioner string
+		dryRun      bool
+		wantKind    error
+		// wantMigrated is true if the old storageclass is
+		// replaced by the new one with all its pvs
+		wantMigrated bool
+	}{
+		{
+			name: "migrated",
+			objs: []runtime.Object{
+				testStorageClass("cstor-csi", cstorCSIProvisioner),
+				testStorageClassPV("pvc-1", "cstor-csi"),
+				testStorageClassPV("pvc-2", "cstor-csi"),
+				testStorageClassPV("pvc-3", "other"),
+			},
+			provisioner:  testProvisioner,
+			wantMigrated: true,
+		},
+		{
+			name: "resumed after the pvs were moved",
+			objs: []runtime.Object{
+				testStorageClass("cstor-csi-3-0-0", testProvisioner),
+				testStorageClassPV("pvc-1", "cstor-csi-3-0-0"),
+				testStorageClassPV("pvc-2", "cstor-csi"),
+			},
+			provisioner:  testProvisioner,
+			wantMigrated: true,
+		},
+		{
+			name: "dry run",
+			objs: []runtime.Object{
+				testStorageClass("cstor-csi", cstorCSIProvisioner),
+				testStorageClassPV("pvc-1", "cstor-csi"),
+			},
+			provisioner: testProvisioner,
+			dryRun:      true,
+		},
+		{
+			name:     "no provisioner",
+			objs:     []runtime.Object{testStorageClass("cstor-csi", cstorCSIProvisioner)},
+			wantKind: ErrValidation,
+		},
+		{
+			name:        "not a cstor storageclass",
+			objs:        []runtime.Object{testStorageClass("cstor-csi", "jiva.csi.openebs.io")},
+			provisioner: testProvisioner,
+			wantKind:    ErrValidation,
+		},
+		{
+			name:        "missing storageclass",
+			provisioner: testProvisioner,
+			wantKind:    ErrAPI,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(tt.objs...)
+			obj := NewStorageClassMigrator(
+				WithStorageClassResorcePatch(NewResourcePatch(
+					WithName("cstor-csi"),
+					WithOpenebsNamespace(upgradetesting.Namespace),
+					ToVersion("3.0.0"),
+					WithStorageClassProvisioner(tt.provisioner),
+					WithDryRun(tt.dryRun),
+				)),
+				WithStorageClassClient(client),
+			)
+			err := obj.Upgrade()
+			if tt.wantKind != nil {
+				if !errors.Is(err, tt.wantKind) {
+					t.Fatalf("Upgrade() error = %v, want kind %v", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Upgrade() error = %v", err)
+			}
+			scClient := client.KubeClientset.StorageV1().StorageClasses()
+			_, err = scClient.Get(context.TODO(), "cstor-csi", metav1.GetOptions{})
+			if tt.wantMigrated != k8serrors.IsNotFound(err) {
+				t.Errorf("Upgrade() old storageclass error = %v, want migrated %v", err, tt.wantMigrated)
+			}
+			newSC, err := scClient.Get(context.TODO(), "cstor-csi-3-0-0", metav1.GetOptions{})
+			if !tt.wantMigrated {
+				if !k8serrors.IsNotFound(err) {
+					t.Errorf("Upgrade() created the new storageclass in dry run mode")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to get the new storageclass: %v", err)
+			}
+			if newSC.Provisioner != testProvisioner || newSC.Parameters["cstorPoolCluster"] != "cspc-a" {
+				t.Errorf("Upgrade() new storageclass = %+v", newSC)
+			}
+			pvList, err := client.KubeClientset.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, pv := range pvList.Items {
+				if pv.Spec.StorageClassName == "cstor-csi" {
+					t.Errorf("Upgrade() left pv %s in the old storageclass", pv.Name)
+				}
+				if pv.Name == "pvc-3" && pv.Spec.StorageClassName != "other" {
+					t.Errorf("Upgrade() moved pv %s of another storageclass", pv.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestStorageClassMigrator_backup(t *testing.T) {
+	client := NewTestClient(testStorageClass("cstor-csi", cstorCSIProvisioner))
+	obj := NewStorageClassMigrator(
+		WithStorageClassResorcePatch(NewResourcePatch(
+			WithName("cstor-csi"),
+			WithOpenebsNamespace(upgradetesting.Namespace),
+			ToVersion("3.0.0"),
+			WithStorageClassProvisioner(testProvisioner),
+			WithNewStorageClassName("cstor-csi-new"),
+		)),
+		WithStorageClassClient(client),
+	)
+	err := obj.Upgrade()
+	if err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	cm, err := client.KubeClientset.CoreV1().ConfigMaps(upgradetesting.Namespace).
+		Get(context.TODO(), "storageclass-backup-cstor-csi", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the backup: %v", err)
+	}
+	if cm.Data[storageClassBackupKey] == "" {
+		t.Errorf("Upgrade() backup = %v", cm.Data)
+	}
+	_, err = client.KubeClientset.StorageV1().StorageClasses().
+		Get(context.TODO(), "cstor-csi-new", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("failed to get the storageclass with the new name: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	snapfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	snapscheme "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/scheme"
+	openebsFakeClientset "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// NewTestClient returns a Client with the fake clientsets of the given
+// objects, for the tests of the upgraders. The openebs crds are served
+// by its discovery. It is not in the testing package as that package
+// is imported by the tests of this package.
+func NewTestClient(objs ...runtime.Object) *Client {
+	snapObjs := []runtime.Object{}
+	otherObjs := []runtime.Object{}
+	for _, obj := range objs {
+		if _, _, err := snapscheme.Scheme.ObjectKinds(obj); err == nil {
+			snapObjs = append(snapObjs, obj)
+			continue
+		}
+		otherObjs = append(otherObjs, obj)
+	}
+	kube, openebs := upgradetesting.NewTestClientsets(otherObjs...)
+	kube.Discovery().(*fakediscovery.FakeDiscovery).Resources = apiResourceLists(upgradeTaskCRDs, cstorCRDs, jivaCRDs)
+	return &Client{
+		KubeClientset:     kube,
+		OpenebsClientset:  openebs,
+		SnapshotClientset: snapfake.NewSimpleClientset(snapObjs...),
+		state:             newClientState(),
+	}
+}
+
+// fakeKube, fakeOpenebs and fakeSnapshot return the fake clientsets
+// of a test client, for the tests that add reactors or check the actions
+func fakeKube(c *Client) *fake.Clientset {
+	return c.KubeClientset.(*fake.Clientset)
+}
+
+func fakeOpenebs(c *Client) *openebsFakeClientset.Clientset {
+	return c.OpenebsClientset.(*openebsFakeClientset.Clientset)
+}
+
+func fakeSnapshot(c *Client) *snapfake.Clientset {
+	return c.SnapshotClientset.(*snapfake.Clientset)
+}
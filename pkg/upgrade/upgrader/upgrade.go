@@ -18,15 +18,126 @@ package upgrader
 
 import (
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	snapclientset "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	openebsclientset "github.com/openebs/api/v3/pkg/client/clientset/versioned"
 	"github.com/pkg/errors"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog"
 )
 
+// clientState is what is learnt about the cluster of a client during
+// the upgrades, which is kept per cluster rather than per process so that
+// the upgrades of different clusters can run concurrently. It is shared
+// by the clients of the same cluster.
+type clientState struct {
+	// upgradeTaskCRDMissing is 1 once the upgradetask crd is found to not
+	// be installed, after which the upgrade runs without the upgradetasks
+	upgradeTaskCRDMissing     int32
+	upgradeTaskCRDMissingOnce sync.Once
+	// upgradeTaskCRDDiscovered is done once the upgradetask
+	// crd is looked up using the discovery of the api server
+	upgradeTaskCRDDiscovered sync.Once
+	// nodeScheduler is shared by all the cspc upgrades of the
+	// cluster which don't set a NodeScheduler
+	nodeScheduler *NodeScheduler
+}
+
+func newClientState() *clientState {
+	return &clientState{nodeScheduler: NewNodeScheduler()}
+}
+
+// clientKey identifies the cluster of a client by the config it is built from
+type clientKey struct {
+	kubeConfigPath string
+	kubeContext    string
+	masterURL      string
+	simulator      *Simulator
+}
+
+// clientStates are the states of the clusters the clients are built for,
+// so that the upgrades of the same cluster share what is learnt about it
+// while the upgrades of the other clusters do not
 var (
-	isUpgradeTaskJob = false
+	clientStates      = map[clientKey]*clientState{}
+	clientStatesMutex sync.Mutex
+)
+
+// sharedClientState returns the state of the cluster of the client
+func (c *Client) sharedClientState() *clientState {
+	key := clientKey{c.kubeConfigPath, c.kubeContext, c.masterURL, c.simulator}
+	clientStatesMutex.Lock()
+	defer clientStatesMutex.Unlock()
+	state, ok := clientStates[key]
+	if !ok {
+		state = newClientState()
+		clientStates[key] = state
+	}
+	return state
+}
+
+// IsUpgradeTaskJob returns true if the upgrade is run by an upgradetask
+// job, in which case the upgradetask is updated with the progress, and
+// the upgradetasks are not disabled
+func (c *Client) IsUpgradeTaskJob() bool {
+	return c.upgradeTaskJob && !c.upgradeTasksDisabled()
+}
+
+// upgradeTasksDisabled returns true if the upgradetasks are not read,
+// created or updated, as they are disabled by WithoutUpgradeTasks or
+// their crd is known to be missing
+func (c *Client) upgradeTasksDisabled() bool {
+	return c.noUpgradeTasks || c.isUpgradeTaskCRDMissing()
+}
+
+// isUpgradeTaskCRDMissing returns true if the upgradetask crd is not
+// served by the api server. The crd is looked up once per cluster, the
+// clients without a state are assumed to have the crd.
+func (c *Client) isUpgradeTaskCRDMissing() bool {
+	if c.state == nil {
+		return false
+	}
+	c.state.upgradeTaskCRDDiscovered.Do(func() {
+		c.discoverUpgradeTaskCRD()
+	})
+	return atomic.LoadInt32(&c.state.upgradeTaskCRDMissing) == 1
+}
+
+// setUpgradeTaskCRDMissing disables the upgradetasks for the rest of
+// the upgrades of the client, with a warning the first time
+func (c *Client) setUpgradeTaskCRDMissing(err error) {
+	if c.state == nil {
+		klog.Warningf("upgradetask crd is not installed, upgrading without upgradetasks: %v", err)
+		return
+	}
+	atomic.StoreInt32(&c.state.upgradeTaskCRDMissing, 1)
+	c.state.upgradeTaskCRDMissingOnce.Do(func() {
+		klog.Warningf("upgradetask crd is not installed, upgrading without upgradetasks: %v", err)
+	})
+}
+
+// nodeScheduler returns the NodeScheduler shared by the
+// cspc upgrades of the client
+func (c *Client) nodeScheduler() *NodeScheduler {
+	if c.state == nil {
+		return NewNodeScheduler()
+	}
+	return c.state.nodeScheduler
+}
+
+const (
+	// DefaultQPS and DefaultBurst are the client rate limits used when
+	// none are set, higher than the client-go defaults of 5 and 10 as
+	// an upgrade of many resources makes many short bursts of requests
+	DefaultQPS   = 20
+	DefaultBurst = 40
 )
 
 // UpgradeOptions ...
@@ -38,6 +149,123 @@ type Client struct {
 	KubeClientset kubernetes.Interface
 	// openebsclientset is a openebs custom resource package generated for custom API group.
 	OpenebsClientset openebsclientset.Interface
+	// SnapshotClientset is the clientset of the csi volume snapshots
+	SnapshotClientset snapclientset.Interface
+	// DynamicClientset reads the custom resources at the versions which
+	// have no generated clientset, like the older versions of the crds
+	DynamicClientset dynamic.Interface
+	// Config is the rest config used to build the clientsets
+	Config *rest.Config
+	// kubeConfigPath and masterURL are used to build the rest config,
+	// if both are empty the in-cluster config is used
+	kubeConfigPath string
+	masterURL      string
+	// namespace is where the openebs components are installed
+	namespace string
+	// qps and burst are the rate limits of the clientsets,
+	// DefaultQPS and DefaultBurst are used if not set
+	qps   float32
+	burst int
+	// namespaceScoped restricts the upgrade to the resources in the
+	// namespace, so that only a Role and RoleBinding are needed
+	namespaceScoped bool
+	// simulator is the simulated cluster the clientsets are
+	// taken from instead of the config if set
+	simulator *Simulator
+	// lockTimeout is the time after which the lock of a resource
+	// being upgraded expires if not renewed
+	lockTimeout time.Duration
+	// kubeContext is the context of the kubeconfig used,
+	// the current context of the kubeconfig if empty
+	kubeContext string
+	// upgradeTaskJob is true if the upgrade is run by an upgradetask job
+	upgradeTaskJob bool
+	// noUpgradeTasks disables the upgradetasks, running
+	// only the patch and verification of the resources
+	noUpgradeTasks bool
+	// state is shared by the clients of the cluster, it is nil for the
+	// clients not built by NewUpgrade, which then learn nothing
+	state *clientState
+}
+
+// ClientOptions ...
+type ClientOptions func(*Client)
+
+// WithKubeConfigPath ...
+func WithKubeConfigPath(path string) ClientOptions {
+	return func(c *Client) {
+		c.kubeConfigPath = path
+	}
+}
+
+// WithMasterURL ...
+func WithMasterURL(url string) ClientOptions {
+	return func(c *Client) {
+		c.masterURL = url
+	}
+}
+
+// WithNamespace ...
+func WithNamespace(namespace string) ClientOptions {
+	return func(c *Client) {
+		c.namespace = namespace
+	}
+}
+
+// WithKubeContext ...
+func WithKubeContext(kubeContext string) ClientOptions {
+	return func(c *Client) {
+		c.kubeContext = kubeContext
+	}
+}
+
+// WithUpgradeTaskJob ...
+func WithUpgradeTaskJob(isJob bool) ClientOptions {
+	return func(c *Client) {
+		c.upgradeTaskJob = isJob
+	}
+}
+
+// WithoutUpgradeTasks ...
+func WithoutUpgradeTasks(disabled bool) ClientOptions {
+	return func(c *Client) {
+		c.noUpgradeTasks = disabled
+	}
+}
+
+// WithQPS ...
+func WithQPS(qps float32) ClientOptions {
+	return func(c *Client) {
+		c.qps = qps
+	}
+}
+
+// WithBurst ...
+func WithBurst(burst int) ClientOptions {
+	return func(c *Client) {
+		c.burst = burst
+	}
+}
+
+// WithNamespaceScoped ...
+func WithNamespaceScoped(namespaceScoped bool) ClientOptions {
+	return func(c *Client) {
+		c.namespaceScoped = namespaceScoped
+	}
+}
+
+// WithSimulator ...
+func WithSimulator(s *Simulator) ClientOptions {
+	return func(c *Client) {
+		c.simulator = s
+	}
+}
+
+// WithLockTimeout ...
+func WithLockTimeout(timeout time.Duration) ClientOptions {
+	return func(c *Client) {
+		c.lockTimeout = timeout
+	}
 }
 
 // Upgrade ...
@@ -46,32 +274,101 @@ type Upgrade struct {
 	*Client
 }
 
-func (u *Upgrade) initClient() error {
-	cfg, err := rest.InClusterConfig()
+// BuildConfig returns the rest config for the given kubeconfig path and
+// master url. It falls back to the in-cluster config if neither is provided.
+func BuildConfig(kubeConfigPath, masterURL string) (*rest.Config, error) {
+	if kubeConfigPath == "" && masterURL == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags(masterURL, kubeConfigPath)
+}
+
+// BuildConfigForContext returns the rest config for the given context of
+// the kubeconfig, as BuildConfig does for the current context if the
+// context is empty. The default kubeconfig is used if the path is empty.
+func BuildConfigForContext(kubeConfigPath, kubeContext, masterURL string) (*rest.Config, error) {
+	if kubeContext == "" {
+		return BuildConfig(kubeConfigPath, masterURL)
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeConfigPath != "" {
+		rules.ExplicitPath = kubeConfigPath
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	if masterURL != "" {
+		overrides.ClusterInfo = clientcmdapi.Cluster{Server: masterURL}
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// SetRateLimits sets the qps and burst of the rest config, using
+// DefaultQPS and DefaultBurst for the values which are not positive
+func SetRateLimits(cfg *rest.Config, qps float32, burst int) {
+	if qps <= 0 {
+		qps = DefaultQPS
+	}
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	cfg.QPS = qps
+	cfg.Burst = burst
+}
+
+func (c *Client) initClient() error {
+	if c.simulator != nil {
+		c.KubeClientset = c.simulator.client.KubeClientset
+		c.OpenebsClientset = c.simulator.client.OpenebsClientset
+		c.SnapshotClientset = c.simulator.client.SnapshotClientset
+		return nil
+	}
+	cfg, err := BuildConfigForContext(c.kubeConfigPath, c.kubeContext, c.masterURL)
 	if err != nil {
 		return errors.Wrap(err, "error building kubeconfig")
 	}
-	u.KubeClientset, err = kubernetes.NewForConfig(cfg)
+	SetRateLimits(cfg, c.qps, c.burst)
+	c.Config = cfg
+	c.KubeClientset, err = kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return errors.Wrap(err, "error building kubernetes clientset")
 	}
-	u.OpenebsClientset, err = openebsclientset.NewForConfig(cfg)
+	c.OpenebsClientset, err = openebsclientset.NewForConfig(cfg)
 	if err != nil {
 		return errors.Wrap(err, "error building openebs clientset")
 	}
+	c.SnapshotClientset, err = snapclientset.NewForConfig(cfg)
+	if err != nil {
+		return errors.Wrap(err, "error building snapshot clientset")
+	}
+	c.DynamicClientset, err = dynamic.NewForConfig(cfg)
+	if err != nil {
+		return errors.Wrap(err, "error building dynamic clientset")
+	}
 	return nil
 }
 
 // NewUpgrade ...
-func NewUpgrade() *Upgrade {
+func NewUpgrade(opts ...ClientOptions) *Upgrade {
+	u, err := NewClusterUpgrade(opts...)
+	if err != nil {
+		klog.Error(err)
+	}
+	return u
+}
+
+// NewClusterUpgrade returns the Upgrade of the cluster of the client
+// options, along with the error if its clients can not be built
+func NewClusterUpgrade(opts ...ClientOptions) (*Upgrade, error) {
 	u := &Upgrade{
 		UpgradeMap: map[string]UpgradeOptions{},
-		Client:     &Client{},
+		Client: &Client{
+			upgradeTaskJob: os.Getenv("UPGRADE_TASK_LABEL") != "",
+		},
 	}
-	u.initClient()
-	u.RegisterAll()
-	if os.Getenv("UPGRADE_TASK_LABEL") != "" {
-		isUpgradeTaskJob = true
+	for _, o := range opts {
+		o(u.Client)
 	}
-	return u
+	u.state = u.sharedClientState()
+	err := u.initClient()
+	u.RegisterAll()
+	return u, err
 }
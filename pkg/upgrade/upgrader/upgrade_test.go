@@ -0,0 +1,234 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestSetRateLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		qps       float32
+		burst     int
+		wantQPS   float32
+		wantBurst int
+	}{
+		{
+			name:      "defaults",
+			wantQPS:   DefaultQPS,
+			wantBurst: DefaultBurst,
+		},
+		{
+			name:      "configured",
+			qps:       100,
+			burst:     200,
+			wantQPS:   100,
+			wantBurst: 200,
+		},
+		{
+			name:      "negative values use the defaults",
+			qps:       -1,
+			burst:     -1,
+			wantQPS:   DefaultQPS,
+			wantBurst: DefaultBurst,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &rest.Config{}
+			SetRateLimits(cfg, tt.qps, tt.burst)
+			if cfg.QPS != tt.wantQPS || cfg.Burst != tt.wantBurst {
+				t.Errorf("SetRateLimits() qps = %v burst = %v, want %v and %v",
+					cfg.QPS, cfg.Burst, tt.wantQPS, tt.wantBurst)
+			}
+		})
+	}
+}
+
+func TestWithUpgradeTaskJob(t *testing.T) {
+	for _, isJob := range []bool{true, false} {
+		client := &Client{state: newClientState()}
+		WithUpgradeTaskJob(isJob)(client)
+		if got := client.IsUpgradeTaskJob(); got != isJob {
+			t.Errorf("IsUpgradeTaskJob() = %v, want %v", got, isJob)
+		}
+	}
+}
+
+func TestSharedClientState(t *testing.T) {
+	client := func(opts ...ClientOptions) *Client {
+		c := &Client{}
+		for _, o := range opts {
+			o(c)
+		}
+		return c
+	}
+	a := client(WithKubeConfigPath("/kubeconfig"), WithKubeContext("cluster-a"))
+	b := client(WithKubeConfigPath("/kubeconfig"), WithKubeContext("cluster-b"))
+	if a.sharedClientState() != client(WithKubeConfigPath("/kubeconfig"), WithKubeContext("cluster-a")).sharedClientState() {
+		t.Errorf("sharedClientState() differs for the clients of the same cluster")
+	}
+	if a.sharedClientState() == b.sharedClientState() {
+		t.Errorf("sharedClientState() is shared by the clients of different clusters")
+	}
+	// the clusters are upgraded concurrently
+	var wg sync.WaitGroup
+	for _, c := range []*Client{a, b} {
+		c.upgradeTaskJob = true
+		c.state = c.sharedClientState()
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			a.setUpgradeTaskCRDMissing(nil)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = b.IsUpgradeTaskJob()
+		}()
+	}
+	wg.Wait()
+	if a.IsUpgradeTaskJob() || !b.IsUpgradeTaskJob() {
+		t.Errorf("IsUpgradeTaskJob() = %v, %v, want the upgradetask crd missing only for the first cluster",
+			a.IsUpgradeTaskJob(), b.IsUpgradeTaskJob())
+	}
+}
+
+func TestBuildConfigForContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	err = ioutil.WriteFile(path, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: a
+  cluster:
+    server: https://cluster-a:6443
+- name: b
+  cluster:
+    server: https://cluster-b:6443
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: a
+  context:
+    cluster: a
+    user: admin
+- name: b
+  context:
+    cluster: b
+    user: admin
+current-context: a
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		context   string
+		masterURL string
+		wantHost  string
+		wantErr   bool
+	}{
+		{name: "current context", wantHost: "https://cluster-a:6443"},
+		{name: "other context", context: "b", wantHost: "https://cluster-b:6443"},
+		{name: "master url", context: "b", masterURL: "https://lb:6443", wantHost: "https://lb:6443"},
+		{name: "missing context", context: "c", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := BuildConfigForContext(path, tt.context, tt.masterURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildConfigForContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Host != tt.wantHost {
+				t.Errorf("BuildConfigForContext() host = %s, want %s", cfg.Host, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestNewClusterUpgrade(t *testing.T) {
+	_, err := NewClusterUpgrade(WithKubeConfigPath("/nonexistent/kubeconfig"), WithKubeContext("a"))
+	if err == nil {
+		t.Errorf("NewClusterUpgrade() error = nil for a missing kubeconfig")
+	}
+}
+
+func TestUpgrade_RegisterAll(t *testing.T) {
+	want := map[string]string{
+		"cstorPoolInstance":    "*upgrader.CSPIPatch",
+		"cstorPoolCluster":     "*upgrader.CSPCPatch",
+		"cstorVolume":          "*upgrader.CStorVolumePatch",
+		"jivaVolume":           "*upgrader.JivaVolumePatch",
+		"rbac":                 "*upgrader.RBACPatch",
+		"snapshotClass":        "*upgrader.SnapshotClassPatch",
+		"storageClass":         "*upgrader.StorageClassMigrator",
+		"etcd":                 "*upgrader.EtcdPatch",
+		"podSecurity":          "*upgrader.PodSecurityPatch",
+		"cstorPoolInstanceCRD": "*upgrader.CRDMigrator",
+		"cstorEngineConfig":    "*upgrader.CDEConfigPatch",
+		"cstorVolumeClaimAPI":  "*upgrader.CVCAPIMigrator",
+	}
+	u := (&Upgrade{UpgradeMap: map[string]UpgradeOptions{}}).RegisterAll()
+	if len(u.UpgradeMap) != len(want) {
+		t.Errorf("RegisterAll() registered %d kinds, want %d", len(u.UpgradeMap), len(want))
+	}
+	r := NewResourcePatch(WithName("name"))
+	client := &Client{}
+	for kind, typ := range want {
+		register, ok := u.UpgradeMap[kind]
+		if !ok {
+			t.Errorf("RegisterAll() did not register %s", kind)
+			continue
+		}
+		if got := fmt.Sprintf("%T", register(r, client)); got != typ {
+			t.Errorf("upgrader of %s = %s, want %s", kind, got, typ)
+		}
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	client := &Client{}
+	for _, o := range []ClientOptions{
+		WithMasterURL("https://10.0.0.1:6443"),
+		WithQPS(50),
+		WithBurst(100),
+		WithLockTimeout(time.Minute),
+	} {
+		o(client)
+	}
+	if client.masterURL != "https://10.0.0.1:6443" || client.qps != 50 ||
+		client.burst != 100 || client.lockTimeout != time.Minute {
+		t.Errorf("client options = %+v, want the configured values", client)
+	}
+}
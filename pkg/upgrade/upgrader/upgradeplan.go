@@ -0,0 +1,460 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/upgrade/pkg/version"
+	"github.com/pkg/errors"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// UpgradePlanGVR is the resource of the upgradeplans, which have no
+// generated clientset and are read using the dynamic clientset
+var UpgradePlanGVR = schema.GroupVersionResource{
+	Group:    "openebs.io",
+	Version:  "v1alpha1",
+	Resource: "upgradeplans",
+}
+
+const (
+	// UpgradeStrategyRolling upgrades the resources of a plan one at a
+	// time, while UpgradeStrategyParallel creates all their upgradetasks
+	// at once
+	UpgradeStrategyRolling  = "Rolling"
+	UpgradeStrategyParallel = "Parallel"
+
+	// upgradePlanLabel is the label of the upgradetasks
+	// created for a plan with the name of the plan
+	upgradePlanLabel = "openebs.io/upgrade-plan"
+	// upgradePlanRetriesAnnotation is the number of times the
+	// failed upgradetask of a plan was retried by the plan
+	upgradePlanRetriesAnnotation = "openebs.io/upgrade-plan-retries"
+
+	// webhookTimeout is the time after which a notification is given up
+	webhookTimeout = 10 * time.Second
+)
+
+// UpgradePlanPhase is the phase of an upgradeplan
+type UpgradePlanPhase string
+
+// The phases of an upgradeplan
+const (
+	UpgradePlanPending   UpgradePlanPhase = "Pending"
+	UpgradePlanRunning   UpgradePlanPhase = "Running"
+	UpgradePlanSucceeded UpgradePlanPhase = "Succeeded"
+	UpgradePlanFailed    UpgradePlanPhase = "Failed"
+)
+
+// upgradePlanKinds are the kinds of resources an upgradeplan upgrades,
+// in the order they are upgraded
+var upgradePlanKinds = []string{"cstorPoolCluster", "cstorVolume", "jivaVolume"}
+
+// UpgradePlan describes the upgrade of all the resources of an openebs
+// installation to a version. The controller creates the upgradetasks of
+// the resources of the plan and aggregates their phases in its status.
+type UpgradePlan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UpgradePlanSpec   `json:"spec"`
+	Status UpgradePlanStatus `json:"status,omitempty"`
+}
+
+// UpgradePlanSpec is the desired upgrade of an upgradeplan
+type UpgradePlanSpec struct {
+	// FromVersion is read from each resource if not set
+	FromVersion string `json:"fromVersion,omitempty"`
+	ToVersion   string `json:"toVersion"`
+	ImagePrefix string `json:"imagePrefix,omitempty"`
+	ImageTag    string `json:"imageTag,omitempty"`
+	// Include are the kinds of resources upgraded, all the
+	// kinds are upgraded if empty, except those in Exclude
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Strategy is Rolling if not set
+	Strategy    string                 `json:"strategy,omitempty"`
+	RetryPolicy UpgradePlanRetryPolicy `json:"retryPolicy,omitempty"`
+	// NotificationWebhook is sent a POST request with
+	// the plan once it succeeds or fails
+	NotificationWebhook string `json:"notificationWebhook,omitempty"`
+}
+
+// UpgradePlanRetryPolicy is the number of times the failed
+// upgradetasks of a plan are retried before the plan fails
+type UpgradePlanRetryPolicy struct {
+	MaxRetries int `json:"maxRetries,omitempty"`
+}
+
+// UpgradePlanStatus is the aggregated status of the upgradetasks of a plan
+type UpgradePlanStatus struct {
+	Phase   UpgradePlanPhase `json:"phase,omitempty"`
+	Message string           `json:"message,omitempty"`
+	// Resources are the resources of the plan, found pending
+	// upgrade when the plan is first reconciled
+	Resources []UpgradePlanResource `json:"resources,omitempty"`
+	// Notified is set once the webhook is notified of the final phase
+	Notified bool `json:"notified,omitempty"`
+}
+
+// UpgradePlanResource is a resource of a plan with the phase of its
+// upgradetask, which is kept once the upgradetask is cleaned up
+type UpgradePlanResource struct {
+	Kind        string                   `json:"kind"`
+	Name        string                   `json:"name"`
+	UpgradeTask string                   `json:"upgradeTask"`
+	Phase       v1Alpha1API.UpgradePhase `json:"phase,omitempty"`
+}
+
+// ReconcileUpgradePlans reconciles all the upgradeplans of the namespace.
+// The failure of a plan is logged and does not stop the other plans.
+func ReconcileUpgradePlans(namespace string, client *Client) error {
+	if client.DynamicClientset == nil {
+		return newValidationError(errors.Errorf("no dynamic clientset to read the upgradeplans"))
+	}
+	list, err := client.DynamicClientset.Resource(UpgradePlanGVR).Namespace(namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to list upgradeplans"))
+	}
+	for i := range list.Items {
+		plan := &UpgradePlan{}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, plan)
+		if err != nil {
+			klog.Errorf("upgradeplan %s: invalid upgradeplan: %v", list.Items[i].GetName(), err)
+			continue
+		}
+		err = ReconcileUpgradePlan(plan, client)
+		if err != nil {
+			klog.Errorf("upgradeplan %s: %v", plan.Name, err)
+		}
+	}
+	return nil
+}
+
+// ReconcileUpgradePlan creates the upgradetasks of the plan as per its
+// strategy, retries the failed ones as per its retry policy and updates
+// its status with their phases
+func ReconcileUpgradePlan(plan *UpgradePlan, client *Client) error {
+	status := plan.Status.DeepCopy()
+	switch {
+	case status.Phase == UpgradePlanSucceeded || status.Phase == UpgradePlanFailed:
+		// only the notification is left
+	case status.Phase == "":
+		err := plan.validate()
+		if err != nil {
+			status.Phase = UpgradePlanFailed
+			status.Message = err.Error()
+			break
+		}
+		status.Resources, err = plan.pendingResources(client)
+		if err != nil {
+			return err
+		}
+		status.Phase = UpgradePlanPending
+		klog.Infof("upgradeplan %s: upgrading %d resources to %s", plan.Name, len(status.Resources), plan.Spec.ToVersion)
+		fallthrough
+	default:
+		err := plan.syncUpgradeTasks(status, client)
+		if err != nil {
+			return err
+		}
+		status.aggregate()
+	}
+	if (status.Phase == UpgradePlanSucceeded || status.Phase == UpgradePlanFailed) && !status.Notified {
+		status.Notified = plan.notify(status)
+	}
+	if status.Phase != plan.Status.Phase {
+		klog.Infof("upgradeplan %s: phase %s", plan.Name, status.Phase)
+	}
+	return plan.updateStatus(status, client)
+}
+
+// validate returns a validation error if the spec of the plan is invalid
+func (plan *UpgradePlan) validate() error {
+	if plan.Spec.ToVersion == "" {
+		return newValidationError(errors.Errorf("toVersion is missing"))
+	}
+	switch plan.Spec.Strategy {
+	case "", UpgradeStrategyRolling, UpgradeStrategyParallel:
+	default:
+		return newValidationError(errors.Errorf("invalid strategy %q, expected one of %s or %s",
+			plan.Spec.Strategy, UpgradeStrategyRolling, UpgradeStrategyParallel))
+	}
+	for _, kind := range append(append([]string{}, plan.Spec.Include...), plan.Spec.Exclude...) {
+		if !containsString(upgradePlanKinds, kind) {
+			return newValidationError(errors.Errorf("invalid kind %q, expected one of %s",
+				kind, strings.Join(upgradePlanKinds, ", ")))
+		}
+	}
+	return nil
+}
+
+// includes returns true if the resources of the kind are upgraded by the plan
+func (plan *UpgradePlan) includes(kind string) bool {
+	if len(plan.Spec.Include) > 0 && !containsString(plan.Spec.Include, kind) {
+		return false
+	}
+	return !containsString(plan.Spec.Exclude, kind)
+}
+
+// pendingResources returns the resources of the kinds of the plan whose
+// version is older than the to version, the pools before the volumes
+func (plan *UpgradePlan) pendingResources(client *Client) ([]UpgradePlanResource, error) {
+	toVersion, err := version.ParseVersionFlexible(plan.Spec.ToVersion)
+	if err != nil {
+		return nil, newValidationError(err)
+	}
+	c := *client
+	c.namespace = plan.Namespace
+	g, err := BuildDependencyGraph(context.TODO(), &c)
+	if err != nil {
+		return nil, err
+	}
+	resources := []UpgradePlanResource{}
+	for _, kind := range upgradePlanKinds {
+		if !plan.includes(kind) {
+			continue
+		}
+		for _, n := range g.Nodes {
+			if n.Kind != kind || !isVersionPending(n.Version, toVersion) {
+				continue
+			}
+			resources = append(resources, UpgradePlanResource{
+				Kind:        kind,
+				Name:        n.Name,
+				UpgradeTask: plan.buildUpgradeTask(kind, n.Name).Name,
+			})
+		}
+	}
+	return resources, nil
+}
+
+func (plan *UpgradePlan) buildUpgradeTask(kind, name string) *v1Alpha1API.UpgradeTask {
+	utaskObj := buildUpgradeTask(kind, &ResourcePatch{
+		Name:             name,
+		OpenebsNamespace: plan.Namespace,
+		From:             plan.Spec.FromVersion,
+		To:               plan.Spec.ToVersion,
+		ImageTag:         plan.Spec.ImageTag,
+		BaseURL:          plan.Spec.ImagePrefix,
+	})
+	utaskObj.Labels[upgradePlanLabel] = plan.Name
+	utaskObj.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: UpgradePlanGVR.GroupVersion().String(),
+		Kind:       "UpgradePlan",
+		Name:       plan.Name,
+		UID:        plan.UID,
+	}}
+	return utaskObj
+}
+
+// syncUpgradeTasks reads the phases of the upgradetasks of the plan into
+// the status, retries the failed ones and creates the next ones, all of
+// them with the Parallel strategy and one at a time with Rolling
+func (plan *UpgradePlan) syncUpgradeTasks(status *UpgradePlanStatus, client *Client) error {
+	utaskClient := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(plan.Namespace)
+	running, failed := 0, 0
+	for i := range status.Resources {
+		res := &status.Resources[i]
+		if res.Phase == v1Alpha1API.UpgradeSuccess {
+			// the upgradetask may have been cleaned up
+			continue
+		}
+		utaskObj, err := utaskClient.Get(context.TODO(), res.UpgradeTask, metav1.GetOptions{})
+		if err != nil && !k8serror.IsNotFound(err) {
+			return newAPIError(errors.Wrapf(err, "failed to get upgradetask %s", res.UpgradeTask))
+		}
+		if err == nil {
+			res.Phase = utaskObj.Status.Phase
+			if res.Phase == v1Alpha1API.UpgradeError {
+				err = plan.retry(utaskObj, res, client)
+				if err != nil {
+					return err
+				}
+			}
+			switch res.Phase {
+			case v1Alpha1API.UpgradeSuccess:
+			case v1Alpha1API.UpgradeError:
+				failed++
+			default:
+				running++
+			}
+			continue
+		}
+		if res.Phase == v1Alpha1API.UpgradeError {
+			failed++
+			continue
+		}
+		// the rolling upgrade stops at the first failure
+		if plan.Spec.Strategy != UpgradeStrategyParallel && running+failed > 0 {
+			continue
+		}
+		klog.Infof("upgradeplan %s: creating upgradetask %s", plan.Name, res.UpgradeTask)
+		utaskObj = plan.buildUpgradeTask(res.Kind, res.Name)
+		_, err = utaskClient.Create(context.TODO(), utaskObj, metav1.CreateOptions{})
+		// an upgradetask created since it was read is synced next time
+		if err != nil && !k8serror.IsAlreadyExists(err) {
+			return newAPIError(errors.Wrapf(err, "failed to create upgradetask %s", res.UpgradeTask))
+		}
+		res.Phase = utaskObj.Status.Phase
+		running++
+	}
+	return nil
+}
+
+// retry sets the failed upgradetask back to started for the controller to
+// upgrade it again, until the max retries of the retry policy of the plan
+func (plan *UpgradePlan) retry(utaskObj *v1Alpha1API.UpgradeTask, res *UpgradePlanResource, client *Client) error {
+	retries, _ := strconv.Atoi(utaskObj.Annotations[upgradePlanRetriesAnnotation])
+	if retries >= plan.Spec.RetryPolicy.MaxRetries {
+		return nil
+	}
+	klog.Infof("upgradeplan %s: retrying upgradetask %s, retry %d of %d",
+		plan.Name, utaskObj.Name, retries+1, plan.Spec.RetryPolicy.MaxRetries)
+	utaskObj = utaskObj.DeepCopy()
+	if utaskObj.Annotations == nil {
+		utaskObj.Annotations = map[string]string{}
+	}
+	utaskObj.Annotations[upgradePlanRetriesAnnotation] = strconv.Itoa(retries + 1)
+	utaskObj.Status.Phase = v1Alpha1API.UpgradeStarted
+	utaskObj.Status.CompletedTime = metav1.Time{}
+	_, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(plan.Namespace).
+		Update(context.TODO(), utaskObj, metav1.UpdateOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to retry upgradetask %s", utaskObj.Name))
+	}
+	res.Phase = v1Alpha1API.UpgradeStarted
+	return nil
+}
+
+// aggregate sets the phase of the plan from the phases of its
+// upgradetasks, it fails as soon as an upgradetask has failed
+func (status *UpgradePlanStatus) aggregate() {
+	succeeded, failed, started := 0, 0, 0
+	for _, res := range status.Resources {
+		switch res.Phase {
+		case v1Alpha1API.UpgradeSuccess:
+			succeeded++
+		case v1Alpha1API.UpgradeError:
+			failed++
+		case "":
+		default:
+			started++
+		}
+	}
+	switch {
+	case failed > 0:
+		status.Phase = UpgradePlanFailed
+	case succeeded == len(status.Resources):
+		status.Phase = UpgradePlanSucceeded
+	case succeeded > 0 || started > 0:
+		status.Phase = UpgradePlanRunning
+	default:
+		status.Phase = UpgradePlanPending
+	}
+	status.Message = strconv.Itoa(succeeded) + " of " + strconv.Itoa(len(status.Resources)) +
+		" resources upgraded, " + strconv.Itoa(failed) + " failed"
+}
+
+// notify sends the plan with the given status to the webhook of the plan,
+// and returns false if it is to be notified again on the next reconcile
+func (plan *UpgradePlan) notify(status *UpgradePlanStatus) bool {
+	if plan.Spec.NotificationWebhook == "" {
+		return true
+	}
+	p := plan.DeepCopy()
+	p.Status = *status
+	p.Status.Notified = true
+	data, err := json.Marshal(p)
+	if err != nil {
+		klog.Errorf("upgradeplan %s: failed to marshal the notification: %v", plan.Name, err)
+		return true
+	}
+	httpClient := &http.Client{Timeout: webhookTimeout}
+	resp, err := httpClient.Post(plan.Spec.NotificationWebhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		klog.Warningf("upgradeplan %s: failed to notify the webhook: %v", plan.Name, err)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		klog.Warningf("upgradeplan %s: webhook responded with %s", plan.Name, resp.Status)
+		return false
+	}
+	return true
+}
+
+// updateStatus updates the status of the plan if it changed
+func (plan *UpgradePlan) updateStatus(status *UpgradePlanStatus, client *Client) error {
+	if reflect.DeepEqual(*status, plan.Status) {
+		return nil
+	}
+	p := plan.DeepCopy()
+	p.Status = *status
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(p)
+	if err != nil {
+		return errors.Wrapf(err, "failed to convert upgradeplan %s", plan.Name)
+	}
+	_, err = client.DynamicClientset.Resource(UpgradePlanGVR).Namespace(plan.Namespace).
+		UpdateStatus(context.TODO(), &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to update the status of upgradeplan %s", plan.Name))
+	}
+	plan.Status = *status
+	return nil
+}
+
+// DeepCopy returns a copy of the plan
+func (plan *UpgradePlan) DeepCopy() *UpgradePlan {
+	p := *plan
+	plan.ObjectMeta.DeepCopyInto(&p.ObjectMeta)
+	p.Spec.Include = append([]string(nil), plan.Spec.Include...)
+	p.Spec.Exclude = append([]string(nil), plan.Spec.Exclude...)
+	p.Status = *plan.Status.DeepCopy()
+	return &p
+}
+
+// DeepCopy returns a copy of the status
+func (status *UpgradePlanStatus) DeepCopy() *UpgradePlanStatus {
+	s := *status
+	s.Resources = append([]UpgradePlanResource(nil), status.Resources...)
+	return &s
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
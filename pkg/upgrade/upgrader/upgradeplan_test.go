@@ -0,0 +1,202 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newTestPlanClient(t *testing.T, spec UpgradePlanSpec) *Client {
+	t.Helper()
+	atVersion := func(v string) cstor.VersionDetails {
+		return cstor.VersionDetails{Status: cstor.VersionStatus{Current: v}}
+	}
+	cspcA := upgradetesting.NewTestCSPC("cspc-a", "")
+	cspcA.VersionDetails = atVersion("2.12.0")
+	cspcB := upgradetesting.NewTestCSPC("cspc-b", "")
+	cspcB.VersionDetails = atVersion("2.12.0")
+	cspcC := upgradetesting.NewTestCSPC("cspc-c", "")
+	cspcC.VersionDetails = atVersion("3.0.0")
+	client := NewTestClient(cspcA, cspcB, cspcC,
+		&cstor.CStorVolume{
+			ObjectMeta:     metav1.ObjectMeta{Name: "pvc-1", Namespace: upgradetesting.Namespace},
+			VersionDetails: atVersion("2.12.0"),
+		},
+	)
+	plan := &UpgradePlan{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "openebs.io/v1alpha1", Kind: "UpgradePlan"},
+		ObjectMeta: metav1.ObjectMeta{Name: "plan", Namespace: upgradetesting.Namespace},
+		Spec:       spec,
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.DynamicClientset = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{UpgradePlanGVR: "UpgradePlanList"},
+		&unstructured.Unstructured{Object: obj},
+	)
+	return client
+}
+
+// reconcileTestPlan reconciles the plans and returns the plan
+func reconcileTestPlan(t *testing.T, client *Client) *UpgradePlan {
+	t.Helper()
+	if err := ReconcileUpgradePlans(upgradetesting.Namespace, client); err != nil {
+		t.Fatalf("ReconcileUpgradePlans() error = %v", err)
+	}
+	obj, err := client.DynamicClientset.Resource(UpgradePlanGVR).Namespace(upgradetesting.Namespace).
+		Get(context.TODO(), "plan", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &UpgradePlan{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, plan); err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+// setTestTaskPhase sets the phase of the upgradetask as the controller would
+func setTestTaskPhase(t *testing.T, client *Client, name string, phase v1Alpha1API.UpgradePhase) {
+	t.Helper()
+	utaskClient := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace)
+	utaskObj, err := utaskClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utaskObj.Status.Phase = phase
+	if _, err := utaskClient.Update(context.TODO(), utaskObj, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countTestTasks(t *testing.T, client *Client) int {
+	t.Helper()
+	list, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: upgradePlanLabel + "=plan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(list.Items)
+}
+
+func TestReconcileUpgradePlanRolling(t *testing.T) {
+	notified := make(chan UpgradePlan, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plan := UpgradePlan{}
+		_ = json.NewDecoder(r.Body).Decode(&plan)
+		notified <- plan
+	}))
+	defer server.Close()
+	client := newTestPlanClient(t, UpgradePlanSpec{
+		ToVersion:           "3.0.0",
+		Include:             []string{"cstorPoolCluster", "cstorVolume"},
+		NotificationWebhook: server.URL,
+	})
+
+	plan := reconcileTestPlan(t, client)
+	// cspc-c is already at the to version
+	wantTasks := []string{"upgrade-cstor-cspc-cspc-a", "upgrade-cstor-cspc-cspc-b", "upgrade-cstor-csi-volume-pvc-1"}
+	if len(plan.Status.Resources) != len(wantTasks) {
+		t.Fatalf("plan resources = %+v, want %v", plan.Status.Resources, wantTasks)
+	}
+	for i, res := range plan.Status.Resources {
+		if res.UpgradeTask != wantTasks[i] {
+			t.Errorf("plan resource %d upgradetask = %s, want %s", i, res.UpgradeTask, wantTasks[i])
+		}
+	}
+	if plan.Status.Phase != UpgradePlanRunning || countTestTasks(t, client) != 1 {
+		t.Fatalf("plan phase = %s with %d upgradetasks, want Running with 1",
+			plan.Status.Phase, countTestTasks(t, client))
+	}
+
+	// the next upgradetask is created once the previous one succeeds
+	for i, name := range wantTasks {
+		setTestTaskPhase(t, client, name, v1Alpha1API.UpgradeSuccess)
+		plan = reconcileTestPlan(t, client)
+		if i < len(wantTasks)-1 && countTestTasks(t, client) != i+2 {
+			t.Errorf("got %d upgradetasks after %s succeeded, want %d", countTestTasks(t, client), name, i+2)
+		}
+	}
+	if plan.Status.Phase != UpgradePlanSucceeded || !plan.Status.Notified {
+		t.Errorf("plan status = %+v, want Succeeded and notified", plan.Status)
+	}
+	got := <-notified
+	if got.Name != "plan" || got.Status.Phase != UpgradePlanSucceeded {
+		t.Errorf("notified plan %s with phase %s", got.Name, got.Status.Phase)
+	}
+}
+
+func TestReconcileUpgradePlanParallelRetry(t *testing.T) {
+	client := newTestPlanClient(t, UpgradePlanSpec{
+		ToVersion:   "3.0.0",
+		Exclude:     []string{"cstorVolume"},
+		Strategy:    UpgradeStrategyParallel,
+		RetryPolicy: UpgradePlanRetryPolicy{MaxRetries: 1},
+	})
+	plan := reconcileTestPlan(t, client)
+	if countTestTasks(t, client) != 2 {
+		t.Fatalf("got %d upgradetasks, want all 2 created at once", countTestTasks(t, client))
+	}
+
+	setTestTaskPhase(t, client, "upgrade-cstor-cspc-cspc-a", v1Alpha1API.UpgradeError)
+	plan = reconcileTestPlan(t, client)
+	if plan.Status.Phase != UpgradePlanRunning {
+		t.Errorf("plan phase = %s, want Running while the failed upgradetask is retried", plan.Status.Phase)
+	}
+	utaskObj, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+		Get(context.TODO(), "upgrade-cstor-cspc-cspc-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utaskObj.Status.Phase != v1Alpha1API.UpgradeStarted || utaskObj.Annotations[upgradePlanRetriesAnnotation] != "1" {
+		t.Errorf("retried upgradetask phase = %s with retries %q", utaskObj.Status.Phase,
+			utaskObj.Annotations[upgradePlanRetriesAnnotation])
+	}
+
+	// the plan fails once the retries are exhausted
+	setTestTaskPhase(t, client, "upgrade-cstor-cspc-cspc-a", v1Alpha1API.UpgradeError)
+	plan = reconcileTestPlan(t, client)
+	if plan.Status.Phase != UpgradePlanFailed {
+		t.Errorf("plan phase = %s, want Failed", plan.Status.Phase)
+	}
+}
+
+func TestReconcileUpgradePlanInvalid(t *testing.T) {
+	client := newTestPlanClient(t, UpgradePlanSpec{ToVersion: "3.0.0", Strategy: "BlueGreen"})
+	plan := reconcileTestPlan(t, client)
+	if plan.Status.Phase != UpgradePlanFailed || plan.Status.Message == "" {
+		t.Errorf("plan status = %+v, want Failed with a message", plan.Status)
+	}
+	if countTestTasks(t, client) != 0 {
+		t.Errorf("got %d upgradetasks for an invalid plan", countTestTasks(t, client))
+	}
+}
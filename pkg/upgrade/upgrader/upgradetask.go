@@ -19,18 +19,81 @@ package upgrader
 import (
 	"context"
 	"os"
+	"sort"
+	"strconv"
+	"time"
 
 	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
 	"github.com/pkg/errors"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog"
 )
 
+const (
+	// upgradeTaskKindLabel and upgradeTaskResourceLabel are set on the
+	// upgradetasks when they are created, to identify the resource
+	// upgradetasks upgrade without parsing their names
+	upgradeTaskKindLabel     = "openebs.io/upgrade-resource-kind"
+	upgradeTaskResourceLabel = "openebs.io/upgrade-resource-name"
+)
+
+// discoverUpgradeTaskCRD looks up the upgradetask crd using the discovery
+// of the api server, and disables the upgradetasks for the rest of the
+// upgrades of the client if it is not served. It returns true if the crd
+// is missing. A failure of the discovery is only logged, and the crd is
+// then assumed to be installed.
+func (c *Client) discoverUpgradeTaskCRD() bool {
+	if c.KubeClientset == nil {
+		return false
+	}
+	resourceList, err := c.KubeClientset.Discovery().
+		ServerResourcesForGroupVersion(upgradeTaskCRDs.groupVersion)
+	if err != nil && !k8serror.IsNotFound(err) {
+		klog.Warningf("failed to discover the upgradetask crd: %v", err)
+		return false
+	}
+	if err == nil {
+		for _, r := range resourceList.APIResources {
+			if r.Name == "upgradetasks" {
+				return false
+			}
+		}
+	}
+	c.setUpgradeTaskCRDMissing(
+		errors.Errorf("upgradetasks are not served in %s", upgradeTaskCRDs.groupVersion),
+	)
+	return true
+}
+
+// isNoUpgradeTaskCRDError returns true if the request failed as the
+// upgradetask crd is not installed. The errors which a missing crd
+// fails the requests with, a not found error or a failure to map the
+// kind, are confirmed using the discovery of the api server, as they
+// are also returned for a missing upgradetask or a stale rest mapper.
+func (c *Client) isNoUpgradeTaskCRDError(err error) bool {
+	if !meta.IsNoMatchError(errors.Cause(err)) && !k8serror.IsNotFound(err) {
+		return false
+	}
+	return c.discoverUpgradeTaskCRD()
+}
+
 func updateUpgradeDetailedStatus(utaskObj *v1Alpha1API.UpgradeTask,
 	uStatusObj v1Alpha1API.UpgradeDetailedStatuses,
 	openebsNamespace string, client *Client,
 ) (*v1Alpha1API.UpgradeTask, error) {
 	var err error
+	// the upgradetasks are not used when disabled or without their crd
+	if utaskObj == nil && client.upgradeTasksDisabled() {
+		return nil, nil
+	}
+	// the upgradetask is nil if it failed to be created outside
+	// of an upgradetask job, where its status is best effort
+	if utaskObj == nil {
+		return nil, errors.Errorf("failed to update upgradetask status: no upgradetask")
+	}
 	if !isValidStatus(uStatusObj) {
 		return nil, errors.Errorf(
 			"failed to update upgradetask status: invalid status %v",
@@ -88,7 +151,19 @@ func getOrCreateUpgradeTask(kind string, r *ResourcePatch, client *Client) (*v1A
 	if r.Name == "" {
 		return nil, errors.Errorf("missing name for upgradeTask")
 	}
+	if client.upgradeTasksDisabled() {
+		return nil, nil
+	}
 	utaskObj = buildUpgradeTask(kind, r)
+	if r.ConsolidateUpgradeTasks {
+		_, err = ConsolidateUpgradeTasks(kind, r, client)
+		if client.isNoUpgradeTaskCRDError(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 	// the below logic first tries to fetch the CR if not found
 	// then creates a new CR
 	utaskObj1, err1 := client.OpenebsClientset.OpenebsV1alpha1().
@@ -99,11 +174,14 @@ func getOrCreateUpgradeTask(kind string, r *ResourcePatch, client *Client) (*v1A
 			utaskObj, err = client.OpenebsClientset.OpenebsV1alpha1().
 				UpgradeTasks(r.OpenebsNamespace).Create(context.TODO(),
 				utaskObj, metav1.CreateOptions{})
+			if client.isNoUpgradeTaskCRDError(err) {
+				return nil, nil
+			}
 			if err != nil {
-				return nil, err
+				return nil, newObjectError("create", "upgradetask", r.OpenebsNamespace, utaskObj.Name, err)
 			}
 		} else {
-			return nil, err1
+			return nil, newObjectError("get", "upgradetask", r.OpenebsNamespace, utaskObj.Name, err1)
 		}
 	} else {
 		utaskObj = utaskObj1
@@ -124,11 +202,64 @@ func getOrCreateUpgradeTask(kind string, r *ResourcePatch, client *Client) (*v1A
 	return utaskObj, nil
 }
 
+// SyncUpgradeTaskFromResource sets the phase of the upgradetask of the
+// resource from the version of the resource, for the upgradetasks left
+// stale by a job that crashed between patching the resource and updating
+// its upgradetask. An upgradetask to the to version is marked successful
+// if the resource is already at the to version, and a successful one is
+// started again if the resource is not. It is a no-op if the upgradetask
+// does not exist or is in sync, so it is safe to run before every upgrade.
+func SyncUpgradeTaskFromResource(kind string, r *ResourcePatch, client *Client) error {
+	if r.DryRun || client.upgradeTasksDisabled() {
+		return nil
+	}
+	name := buildUpgradeTask(kind, r).Name
+	if name == "" {
+		return nil
+	}
+	utaskClient := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(r.OpenebsNamespace)
+	utaskObj, err := utaskClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get upgradetask %s", name)
+	}
+	// an upgradetask of an other upgrade is left as is
+	if utaskObj.Spec.ToVersion != r.To {
+		return nil
+	}
+	done, err := IsAtTargetVersion(kind, r, client)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check if %s %s is at %s", kind, r.Name, r.To)
+	}
+	switch {
+	case done && utaskObj.Status.Phase != v1Alpha1API.UpgradeSuccess:
+		klog.Infof("Marking upgradetask %s as %s, %s %s is already at %s",
+			name, v1Alpha1API.UpgradeSuccess, kind, r.Name, r.To)
+		utaskObj.Status.Phase = v1Alpha1API.UpgradeSuccess
+		utaskObj.Status.CompletedTime = metav1.Now()
+	case !done && utaskObj.Status.Phase == v1Alpha1API.UpgradeSuccess:
+		klog.Infof("Marking upgradetask %s as %s, %s %s is not at %s",
+			name, v1Alpha1API.UpgradeStarted, kind, r.Name, r.To)
+		utaskObj.Status.Phase = v1Alpha1API.UpgradeStarted
+		utaskObj.Status.CompletedTime = metav1.Time{}
+	default:
+		return nil
+	}
+	_, err = utaskClient.Update(context.TODO(), utaskObj, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update upgradetask %s", name)
+	}
+	return nil
+}
+
 func buildUpgradeTask(kind string, r *ResourcePatch) *v1Alpha1API.UpgradeTask {
 	// TODO builder
 	utaskObj := &v1Alpha1API.UpgradeTask{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: r.OpenebsNamespace,
+			Labels:    map[string]string{upgradeTaskKindLabel: kind},
 		},
 		Spec: v1Alpha1API.UpgradeTaskSpec{
 			FromVersion: r.From,
@@ -149,6 +280,13 @@ func buildUpgradeTask(kind string, r *ResourcePatch) *v1Alpha1API.UpgradeTask {
 				CSPIName: r.Name,
 			},
 		}
+	case "cstorPoolCluster":
+		utaskObj.Name = "upgrade-cstor-cspc-" + r.Name
+		utaskObj.Spec.ResourceSpec = v1Alpha1API.ResourceSpec{
+			CStorPoolCluster: &v1Alpha1API.CStorPoolCluster{
+				CSPCName: r.Name,
+			},
+		}
 	case "cstorVolume":
 		utaskObj.Name = "upgrade-cstor-csi-volume-" + r.Name
 		utaskObj.Spec.ResourceSpec = v1Alpha1API.ResourceSpec{
@@ -164,9 +302,166 @@ func buildUpgradeTask(kind string, r *ResourcePatch) *v1Alpha1API.UpgradeTask {
 			},
 		}
 	}
+	// the names longer than a label value are matched by the spec
+	if len(validation.IsValidLabelValue(r.Name)) == 0 {
+		utaskObj.Labels[upgradeTaskResourceLabel] = r.Name
+	}
 	return utaskObj
 }
 
+// isUpgradeTaskOf returns true if the upgradetask upgrades the given
+// resource, as per its labels or for the upgradetasks created without
+// them, as per its spec
+func isUpgradeTaskOf(utaskObj *v1Alpha1API.UpgradeTask, kind, name string) bool {
+	if utaskObj.Labels[upgradeTaskResourceLabel] != "" {
+		return utaskObj.Labels[upgradeTaskKindLabel] == kind &&
+			utaskObj.Labels[upgradeTaskResourceLabel] == name
+	}
+	k, n := upgradeTaskResource(utaskObj.Spec.ResourceSpec)
+	return k == kind && n == name
+}
+
+// ConsolidateUpgradeTasks deletes the duplicate upgradetasks of the given
+// resource left by the earlier runs of the upgrade, and returns their
+// number. The upgradetask with the name used by this version of the
+// upgrade is kept, else the most recent one is, and it is updated with
+// the highest retries of the duplicates if it has not completed.
+func ConsolidateUpgradeTasks(kind string, r *ResourcePatch, client *Client) (int, error) {
+	utaskClient := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(r.OpenebsNamespace)
+	utaskList, err := utaskClient.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list upgradetasks")
+	}
+	canonicalName := buildUpgradeTask(kind, r).Name
+	owned := []*v1Alpha1API.UpgradeTask{}
+	var canonical *v1Alpha1API.UpgradeTask
+	for i := range utaskList.Items {
+		utaskObj := &utaskList.Items[i]
+		if !isUpgradeTaskOf(utaskObj, kind, r.Name) {
+			continue
+		}
+		owned = append(owned, utaskObj)
+		switch {
+		case utaskObj.Name == canonicalName:
+			canonical = utaskObj
+		case canonical == nil || canonical.Name != canonicalName &&
+			canonical.CreationTimestamp.Before(&utaskObj.CreationTimestamp):
+			canonical = utaskObj
+		}
+	}
+	if len(owned) <= 1 {
+		return 0, nil
+	}
+	retries := canonical.Status.Retries
+	deleted := 0
+	for _, utaskObj := range owned {
+		if utaskObj == canonical {
+			continue
+		}
+		if utaskObj.Status.Retries > retries {
+			retries = utaskObj.Status.Retries
+		}
+		err = utaskClient.Delete(context.TODO(), utaskObj.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serror.IsNotFound(err) {
+			return deleted, errors.Wrapf(err, "failed to delete duplicate upgradetask %s", utaskObj.Name)
+		}
+		klog.Infof("Deleted upgradetask %s, a duplicate of %s for %s %s",
+			utaskObj.Name, canonical.Name, kind, r.Name)
+		deleted++
+	}
+	if retries != canonical.Status.Retries && !isUpgradeTaskDone(canonical.Status.Phase) {
+		canonical.Status.Retries = retries
+		_, err = utaskClient.Update(context.TODO(), canonical, metav1.UpdateOptions{})
+		if err != nil {
+			return deleted, errors.Wrapf(err, "failed to update the retries of upgradetask %s", canonical.Name)
+		}
+	}
+	return deleted, nil
+}
+
+// GenerateUpgradeTasks returns the upgradetasks that would be created
+// to upgrade the given resource, without creating them
+func GenerateUpgradeTasks(kind string, r *ResourcePatch, client *Client) ([]*v1Alpha1API.UpgradeTask, error) {
+	if r.OpenebsNamespace == "" {
+		return nil, errors.Errorf("missing openebsNamespace")
+	}
+	if r.Name == "" {
+		return nil, errors.Errorf("missing name for upgradeTask")
+	}
+	names := []string{r.Name}
+	switch kind {
+	case "cstorPoolCluster":
+		// the cspc is upgraded by upgrading each of its cspis
+		cspiList, err := client.OpenebsClientset.CstorV1().
+			CStorPoolInstances(r.OpenebsNamespace).List(context.TODO(),
+			metav1.ListOptions{
+				LabelSelector: "openebs.io/cstor-pool-cluster=" + r.Name,
+			},
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list cspis for cspc %s", r.Name)
+		}
+		names = []string{}
+		for _, cspiObj := range cspiList.Items {
+			names = append(names, cspiObj.Name)
+		}
+		kind = "cstorPoolInstance"
+	case "cstorPoolInstance", "cstorVolume", "jivaVolume":
+	default:
+		return nil, errors.Errorf("upgradetasks are not supported for %s", kind)
+	}
+	utasks := []*v1Alpha1API.UpgradeTask{}
+	for _, name := range names {
+		res := *r
+		res.Name = name
+		utasks = append(utasks, buildGeneratedUpgradeTask(kind, &res))
+	}
+	return utasks, nil
+}
+
+// buildGeneratedUpgradeTask returns the upgradetask of the resource as
+// it is generated for it to be created later, without a status
+func buildGeneratedUpgradeTask(kind string, r *ResourcePatch) *v1Alpha1API.UpgradeTask {
+	utaskObj := buildUpgradeTask(kind, r)
+	utaskObj.TypeMeta = metav1.TypeMeta{
+		APIVersion: v1Alpha1API.SchemeGroupVersion.String(),
+		Kind:       "UpgradeTask",
+	}
+	// the status is set by the upgrade job
+	utaskObj.Status = v1Alpha1API.UpgradeTaskStatus{}
+	return utaskObj
+}
+
+// CleanupUpgradeTasks deletes the successful upgradetasks in the namespace
+// which completed more than olderThan ago, and returns the number of
+// upgradetasks deleted. The deletion is best effort, failures to delete
+// an upgradetask are logged and the remaining upgradetasks are deleted.
+func CleanupUpgradeTasks(namespace string, olderThan time.Duration, client *Client) (int, error) {
+	utaskList, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list upgradetasks")
+	}
+	deleted := 0
+	for _, utaskObj := range utaskList.Items {
+		if utaskObj.Status.Phase != v1Alpha1API.UpgradeSuccess {
+			continue
+		}
+		if olderThan > 0 && time.Since(utaskObj.Status.CompletedTime.Time) < olderThan {
+			continue
+		}
+		err = client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(namespace).
+			Delete(context.TODO(), utaskObj.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serror.IsNotFound(err) {
+			klog.Warningf("failed to delete upgradetask %s: %v", utaskObj.Name, err)
+			continue
+		}
+		klog.Infof("Deleted upgradetask %s", utaskObj.Name)
+		deleted++
+	}
+	return deleted, nil
+}
+
 func getBackoffLimit(openebsNamespace string, client *Client) (int, error) {
 	podName := os.Getenv("POD_NAME")
 	podObj, err := client.KubeClientset.CoreV1().Pods(openebsNamespace).
@@ -186,3 +481,91 @@ func getBackoffLimit(openebsNamespace string, client *Client) (int, error) {
 	backoffLimit := int(*jobObj.Spec.BackoffLimit)
 	return backoffLimit, nil
 }
+
+// UpgradeTaskInfo is the status of an upgradetask
+type UpgradeTaskInfo struct {
+	Name          string `json:"name"`
+	Kind          string `json:"kind"`
+	Resource      string `json:"resource"`
+	From          string `json:"fromVersion"`
+	To            string `json:"toVersion"`
+	Phase         string `json:"phase"`
+	Retries       int    `json:"retries"`
+	StartTime     string `json:"startTime,omitempty"`
+	CompletedTime string `json:"completedTime,omitempty"`
+}
+
+// UpgradeTaskInfoList is the list of the status of upgradetasks
+type UpgradeTaskInfoList []UpgradeTaskInfo
+
+// Headers returns the column names of the table output
+func (l UpgradeTaskInfoList) Headers() []string {
+	return []string{"NAME", "KIND", "RESOURCE", "FROM", "TO", "PHASE", "RETRIES", "COMPLETED"}
+}
+
+// Rows returns the rows of the table output
+func (l UpgradeTaskInfoList) Rows() [][]string {
+	rows := [][]string{}
+	for _, info := range l {
+		completed := info.CompletedTime
+		if completed == "" {
+			completed = "-"
+		}
+		rows = append(rows, []string{
+			info.Name, info.Kind, info.Resource, info.From, info.To,
+			info.Phase, strconv.Itoa(info.Retries), completed,
+		})
+	}
+	return rows
+}
+
+// upgradeTaskResource returns the kind and name of the
+// resource upgraded by the upgradetask
+func upgradeTaskResource(spec v1Alpha1API.ResourceSpec) (string, string) {
+	switch {
+	case spec.CStorPoolInstance != nil:
+		return "cstorPoolInstance", spec.CStorPoolInstance.CSPIName
+	case spec.CStorPoolCluster != nil:
+		return "cstorPoolCluster", spec.CStorPoolCluster.CSPCName
+	case spec.CStorVolume != nil:
+		return "cstorVolume", spec.CStorVolume.PVName
+	case spec.JivaVolume != nil:
+		return "jivaVolume", spec.JivaVolume.PVName
+	}
+	return "", ""
+}
+
+func formatTime(t metav1.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ListUpgradeTasks returns the status of the upgradetasks in the namespace
+func ListUpgradeTasks(namespace string, client *Client) (UpgradeTaskInfoList, error) {
+	utaskList, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list upgradetasks"))
+	}
+	list := UpgradeTaskInfoList{}
+	for _, utaskObj := range utaskList.Items {
+		kind, name := upgradeTaskResource(utaskObj.Spec.ResourceSpec)
+		list = append(list, UpgradeTaskInfo{
+			Name:          utaskObj.Name,
+			Kind:          kind,
+			Resource:      name,
+			From:          utaskObj.Spec.FromVersion,
+			To:            utaskObj.Spec.ToVersion,
+			Phase:         string(utaskObj.Status.Phase),
+			Retries:       utaskObj.Status.Retries,
+			StartTime:     formatTime(utaskObj.Status.StartTime),
+			CompletedTime: formatTime(utaskObj.Status.CompletedTime),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/upgrade/pkg/version"
+	"github.com/pkg/errors"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// PlanUpgradeTasks returns the upgradetasks to upgrade all the cspis,
+// cStor volumes and jiva volumes older than the to version, without
+// creating them. The cspis are those of the cspcs matching the label
+// selector, the cStor volumes are matched by the labels of their cv and
+// the jiva volumes by the labels of their pv. The from version of each
+// upgradetask is read from its resource if it is not set. The names of
+// the upgradetasks are the ones used by the upgrade job, so the same
+// plan is generated for the same resources.
+func PlanUpgradeTasks(r *ResourcePatch, selector string, client *Client) ([]*v1Alpha1API.UpgradeTask, error) {
+	if r.OpenebsNamespace == "" {
+		return nil, errors.Errorf("missing openebsNamespace")
+	}
+	toVersion, err := version.ParseVersionFlexible(r.To)
+	if err != nil {
+		return nil, newValidationError(err)
+	}
+	ctx := context.TODO()
+	opts := metav1.ListOptions{LabelSelector: selector}
+	utasks := []*v1Alpha1API.UpgradeTask{}
+	add := func(kind, name, current string) {
+		if !isVersionPending(current, toVersion) {
+			return
+		}
+		res := *r
+		res.Name = name
+		if res.From == "" {
+			res.From = current
+		}
+		utasks = append(utasks, buildGeneratedUpgradeTask(kind, &res))
+	}
+
+	c := client.OpenebsClientset.CstorV1()
+	cspcList, err := c.CStorPoolClusters(r.OpenebsNamespace).List(ctx, opts)
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list cspcs"))
+	}
+	for _, cspcObj := range cspcList.Items {
+		// the cspc is upgraded by upgrading each of its cspis
+		cspiList, err := c.CStorPoolInstances(r.OpenebsNamespace).List(ctx,
+			metav1.ListOptions{
+				LabelSelector: "openebs.io/cstor-pool-cluster=" + cspcObj.Name,
+			},
+		)
+		if err != nil {
+			return nil, newAPIError(errors.Wrapf(err, "failed to list cspis for cspc %s", cspcObj.Name))
+		}
+		for _, cspiObj := range cspiList.Items {
+			add("cstorPoolInstance", cspiObj.Name, cspiObj.VersionDetails.Status.Current)
+		}
+	}
+
+	cvList, err := c.CStorVolumes(r.OpenebsNamespace).List(ctx, opts)
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list cvs"))
+	}
+	for _, cvObj := range cvList.Items {
+		add("cstorVolume", cvObj.Name, cvObj.VersionDetails.Status.Current)
+	}
+
+	// the pvs are cluster scoped, so the jiva
+	// volumes are not planned in namespace scoped mode
+	if client.namespaceScoped {
+		klog.Warningf("skipping the jiva volumes in namespace scoped mode")
+		return utasks, nil
+	}
+	pvList, err := client.KubeClientset.CoreV1().PersistentVolumes().List(ctx, opts)
+	if err != nil {
+		return nil, newAPIError(errors.Wrapf(err, "failed to list pvs"))
+	}
+	for _, pvObj := range pvList.Items {
+		if pvObj.Spec.CSI == nil || pvObj.Spec.CSI.Driver != jivaCSIProvisioner {
+			continue
+		}
+		add("jivaVolume", pvObj.Name, pvObj.Labels["openebs.io/version"])
+	}
+	return utasks, nil
+}
+
+// ApplyUpgradeTasks creates the given upgradetasks which do not exist
+// yet and returns the number of upgradetasks created. The existing
+// upgradetasks are left as they are, as they may already be in progress,
+// so applying the same plan again creates no duplicates.
+func ApplyUpgradeTasks(utasks []*v1Alpha1API.UpgradeTask, client *Client) (int, error) {
+	created := 0
+	for _, utaskObj := range utasks {
+		_, err := client.OpenebsClientset.OpenebsV1alpha1().
+			UpgradeTasks(utaskObj.Namespace).
+			Create(context.TODO(), utaskObj, metav1.CreateOptions{})
+		if k8serror.IsAlreadyExists(err) {
+			klog.Infof("upgradetask %s already exists", utaskObj.Name)
+			continue
+		}
+		if client.isNoUpgradeTaskCRDError(err) {
+			return created, newValidationError(
+				errors.Wrapf(err, "failed to create upgradetask %s, the upgradetask crd is not installed", utaskObj.Name),
+			)
+		}
+		if err != nil {
+			return created, newAPIError(errors.Wrapf(err, "failed to create upgradetask %s", utaskObj.Name))
+		}
+		klog.Infof("Created upgradetask %s", utaskObj.Name)
+		created++
+	}
+	return created, nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testPlanObjects() []runtime.Object {
+	batch := map[string]string{"openebs.io/upgrade-batch": "a"}
+	cspcA := upgradetesting.NewTestCSPC("cspc-a", "2.12.0")
+	cspcA.Labels = batch
+	jivaPV := testPV("pvc-3", jivaCSIProvisioner, "data-3")
+	jivaPV.Labels = map[string]string{
+		"openebs.io/upgrade-batch": "a",
+		"openebs.io/version":       "2.12.0",
+	}
+	return []runtime.Object{
+		cspcA,
+		upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", "2.12.0"),
+		// already at the to version
+		upgradetesting.NewTestCSPI("cspc-a-2", "cspc-a", "3.0.0"),
+		upgradetesting.NewTestCSPC("cspc-b", "2.12.0"),
+		upgradetesting.NewTestCSPI("cspc-b-1", "cspc-b", "2.12.0"),
+		&cstor.CStorVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "pvc-1",
+				Namespace: upgradetesting.Namespace,
+				Labels:    batch,
+			},
+			VersionDetails: upgradetesting.NewTestVersionDetails("2.11.0"),
+		},
+		&cstor.CStorVolume{
+			ObjectMeta:     metav1.ObjectMeta{Name: "pvc-2", Namespace: upgradetesting.Namespace},
+			VersionDetails: upgradetesting.NewTestVersionDetails("2.12.0"),
+		},
+		jivaPV,
+		testPV("pvc-4", cstorCSIProvisioner, "data-4"),
+	}
+}
+
+func TestPlanUpgradeTasks(t *testing.T) {
+	tests := []struct {
+		name      string
+		selector  string
+		wantNames []string
+	}{
+		{
+			name:     "all the pending resources",
+			selector: "",
+			wantNames: []string{
+				"upgrade-cstor-cspi-cspc-a-1",
+				"upgrade-cstor-cspi-cspc-b-1",
+				"upgrade-cstor-csi-volume-pvc-1",
+				"upgrade-cstor-csi-volume-pvc-2",
+				"upgrade-jiva-csi-volume-pvc-3",
+			},
+		},
+		{
+			name:     "the pending resources matching the selector",
+			selector: "openebs.io/upgrade-batch=a",
+			wantNames: []string{
+				"upgrade-cstor-cspi-cspc-a-1",
+				"upgrade-cstor-csi-volume-pvc-1",
+				"upgrade-jiva-csi-volume-pvc-3",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(testPlanObjects()...)
+			r := NewResourcePatch(
+				WithOpenebsNamespace(upgradetesting.Namespace),
+				ToVersion("3.0.0"),
+			)
+			got, err := PlanUpgradeTasks(r, tt.selector, client)
+			if err != nil {
+				t.Fatalf("PlanUpgradeTasks() error = %v", err)
+			}
+			names := []string{}
+			for _, utaskObj := range got {
+				names = append(names, utaskObj.Name)
+				if utaskObj.Kind != "UpgradeTask" || utaskObj.Spec.ToVersion != "3.0.0" {
+					t.Errorf("PlanUpgradeTasks() task %s has kind %s and to version %s",
+						utaskObj.Name, utaskObj.Kind, utaskObj.Spec.ToVersion)
+				}
+				if utaskObj.Name == "upgrade-cstor-csi-volume-pvc-1" && utaskObj.Spec.FromVersion != "2.11.0" {
+					t.Errorf("PlanUpgradeTasks() from version of %s = %s, want 2.11.0",
+						utaskObj.Name, utaskObj.Spec.FromVersion)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("PlanUpgradeTasks() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestPlanUpgradeTasksInvalidVersion(t *testing.T) {
+	client := NewTestClient()
+	r := NewResourcePatch(
+		WithOpenebsNamespace(upgradetesting.Namespace),
+		ToVersion("not-a-version"),
+	)
+	_, err := PlanUpgradeTasks(r, "", client)
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("PlanUpgradeTasks() error = %v, want a validation error", err)
+	}
+}
+
+func TestApplyUpgradeTasks(t *testing.T) {
+	client := NewTestClient(testPlanObjects()...)
+	r := NewResourcePatch(
+		WithOpenebsNamespace(upgradetesting.Namespace),
+		FromVersion("2.12.0"),
+		ToVersion("3.0.0"),
+	)
+	utasks, err := PlanUpgradeTasks(r, "", client)
+	if err != nil {
+		t.Fatalf("PlanUpgradeTasks() error = %v", err)
+	}
+	created, err := ApplyUpgradeTasks(utasks, client)
+	if err != nil || created != len(utasks) {
+		t.Fatalf("ApplyUpgradeTasks() = %d, %v, want %d", created, err, len(utasks))
+	}
+	// applying the plan again creates no duplicates
+	created, err = ApplyUpgradeTasks(utasks, client)
+	if err != nil || created != 0 {
+		t.Fatalf("ApplyUpgradeTasks() again = %d, %v, want 0", created, err)
+	}
+	list, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list upgradetasks: %v", err)
+	}
+	if len(list.Items) != len(utasks) {
+		t.Errorf("got %d upgradetasks, want %d", len(list.Items), len(utasks))
+	}
+}
@@ -0,0 +1,458 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestGenerateUpgradeTasks(t *testing.T) {
+	client := NewTestClient(
+		upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", ""),
+		upgradetesting.NewTestCSPI("cspc-a-2", "cspc-a", ""),
+		upgradetesting.NewTestCSPI("cspc-b-1", "cspc-b", ""),
+	)
+	tests := []struct {
+		name      string
+		kind      string
+		resource  string
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name:      "cspc creates a task per cspi",
+			kind:      "cstorPoolCluster",
+			resource:  "cspc-a",
+			wantNames: []string{"upgrade-cstor-cspi-cspc-a-1", "upgrade-cstor-cspi-cspc-a-2"},
+		},
+		{
+			name:      "cstor volume",
+			kind:      "cstorVolume",
+			resource:  "pvc-1",
+			wantNames: []string{"upgrade-cstor-csi-volume-pvc-1"},
+		},
+		{
+			name:      "jiva volume",
+			kind:      "jivaVolume",
+			resource:  "pvc-1",
+			wantNames: []string{"upgrade-jiva-csi-volume-pvc-1"},
+		},
+		{
+			name:     "unsupported kind",
+			kind:     "rbac",
+			resource: "pvc-1",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResourcePatch(
+				WithName(tt.resource),
+				WithOpenebsNamespace("openebs"),
+				FromVersion("2.12.0"),
+				ToVersion("3.0.0"),
+			)
+			got, err := GenerateUpgradeTasks(tt.kind, r, client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateUpgradeTasks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.wantNames) {
+				t.Fatalf("GenerateUpgradeTasks() got %d tasks, want %d", len(got), len(tt.wantNames))
+			}
+			for i, utask := range got {
+				if utask.Name != tt.wantNames[i] {
+					t.Errorf("GenerateUpgradeTasks() task %d = %s, want %s", i, utask.Name, tt.wantNames[i])
+				}
+				if utask.Kind != "UpgradeTask" || utask.Spec.ToVersion != "3.0.0" {
+					t.Errorf("GenerateUpgradeTasks() task %s has kind %s and to version %s",
+						utask.Name, utask.Kind, utask.Spec.ToVersion)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanupUpgradeTasks(t *testing.T) {
+	utask := func(name string, phase v1Alpha1API.UpgradePhase, age time.Duration) *v1Alpha1API.UpgradeTask {
+		return &v1Alpha1API.UpgradeTask{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "openebs",
+			},
+			Status: v1Alpha1API.UpgradeTaskStatus{
+				Phase:         phase,
+				CompletedTime: metav1.NewTime(time.Now().Add(-age)),
+			},
+		}
+	}
+	tests := []struct {
+		name        string
+		olderThan   time.Duration
+		wantDeleted int
+		wantLeft    []string
+	}{
+		{
+			name:        "all successful tasks",
+			olderThan:   0,
+			wantDeleted: 2,
+			wantLeft:    []string{"failed-old"},
+		},
+		{
+			name:        "successful tasks older than 7 days",
+			olderThan:   7 * 24 * time.Hour,
+			wantDeleted: 1,
+			wantLeft:    []string{"failed-old", "success-new"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(
+				utask("success-old", v1Alpha1API.UpgradeSuccess, 10*24*time.Hour),
+				utask("success-new", v1Alpha1API.UpgradeSuccess, time.Hour),
+				utask("failed-old", v1Alpha1API.UpgradeError, 10*24*time.Hour),
+			)
+			deleted, err := CleanupUpgradeTasks("openebs", tt.olderThan, client)
+			if err != nil {
+				t.Fatalf("CleanupUpgradeTasks() error = %v", err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("CleanupUpgradeTasks() deleted = %d, want %d", deleted, tt.wantDeleted)
+			}
+			utaskList, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks("openebs").
+				List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("failed to list upgradetasks: %v", err)
+			}
+			left := []string{}
+			for _, u := range utaskList.Items {
+				left = append(left, u.Name)
+			}
+			sort.Strings(left)
+			if len(left) != len(tt.wantLeft) {
+				t.Fatalf("left upgradetasks = %v, want %v", left, tt.wantLeft)
+			}
+			for i := range left {
+				if left[i] != tt.wantLeft[i] {
+					t.Errorf("left upgradetasks = %v, want %v", left, tt.wantLeft)
+				}
+			}
+		})
+	}
+}
+
+func TestConsolidateUpgradeTasks(t *testing.T) {
+	utask := func(name, cspi string, labeled bool, retries int, age time.Duration) *v1Alpha1API.UpgradeTask {
+		u := &v1Alpha1API.UpgradeTask{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "openebs",
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			},
+			Spec: v1Alpha1API.UpgradeTaskSpec{
+				ResourceSpec: v1Alpha1API.ResourceSpec{
+					CStorPoolInstance: &v1Alpha1API.CStorPoolInstance{CSPIName: cspi},
+				},
+			},
+			Status: v1Alpha1API.UpgradeTaskStatus{
+				Phase:   v1Alpha1API.UpgradeStarted,
+				Retries: retries,
+			},
+		}
+		if labeled {
+			u.Labels = map[string]string{
+				upgradeTaskKindLabel:     "cstorPoolInstance",
+				upgradeTaskResourceLabel: cspi,
+			}
+		}
+		return u
+	}
+	tests := []struct {
+		name        string
+		objs        []*v1Alpha1API.UpgradeTask
+		wantDeleted int
+		wantLeft    []string
+		wantRetries int
+	}{
+		{
+			name: "canonical task kept",
+			objs: []*v1Alpha1API.UpgradeTask{
+				utask("upgrade-cstor-cspi-cspc-a-1", "cspc-a-1", true, 1, time.Hour),
+				utask("upgrade-cspi-cspc-a-1-old", "cspc-a-1", false, 3, 2*time.Hour),
+				utask("upgrade-cspi-cspc-a-1-new", "cspc-a-1", true, 0, time.Minute),
+				utask("upgrade-cstor-cspi-cspc-a-2", "cspc-a-2", true, 0, time.Hour),
+			},
+			wantDeleted: 2,
+			wantLeft:    []string{"upgrade-cstor-cspi-cspc-a-1", "upgrade-cstor-cspi-cspc-a-2"},
+			wantRetries: 3,
+		},
+		{
+			name: "newest task kept",
+			objs: []*v1Alpha1API.UpgradeTask{
+				utask("upgrade-cspi-cspc-a-1-old", "cspc-a-1", false, 0, 2*time.Hour),
+				utask("upgrade-cspi-cspc-a-1-new", "cspc-a-1", true, 0, time.Minute),
+			},
+			wantDeleted: 1,
+			wantLeft:    []string{"upgrade-cspi-cspc-a-1-new"},
+		},
+		{
+			name: "no duplicates",
+			objs: []*v1Alpha1API.UpgradeTask{
+				utask("upgrade-cstor-cspi-cspc-a-1", "cspc-a-1", true, 1, time.Hour),
+				utask("upgrade-cstor-cspi-cspc-a-2", "cspc-a-2", true, 0, time.Hour),
+			},
+			wantLeft:    []string{"upgrade-cstor-cspi-cspc-a-1", "upgrade-cstor-cspi-cspc-a-2"},
+			wantRetries: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := []runtime.Object{}
+			for _, u := range tt.objs {
+				objs = append(objs, u)
+			}
+			client := NewTestClient(objs...)
+			r := NewResourcePatch(WithName("cspc-a-1"), WithOpenebsNamespace("openebs"))
+			deleted, err := ConsolidateUpgradeTasks("cstorPoolInstance", r, client)
+			if err != nil {
+				t.Fatalf("ConsolidateUpgradeTasks() error = %v", err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("ConsolidateUpgradeTasks() deleted = %d, want %d", deleted, tt.wantDeleted)
+			}
+			utaskList, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks("openebs").
+				List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("failed to list upgradetasks: %v", err)
+			}
+			left := []string{}
+			for _, u := range utaskList.Items {
+				left = append(left, u.Name)
+				if u.Spec.CStorPoolInstance.CSPIName == "cspc-a-1" && u.Status.Retries != tt.wantRetries {
+					t.Errorf("upgradetask %s retries = %d, want %d", u.Name, u.Status.Retries, tt.wantRetries)
+				}
+			}
+			sort.Strings(left)
+			if !reflect.DeepEqual(left, tt.wantLeft) {
+				t.Errorf("left upgradetasks = %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+// removeUpgradeTaskCRD makes the discovery of the test client serve no
+// upgradetasks, as if the upgradetask crd was not installed. The group
+// is still served as the fake discovery fails for the unknown groups
+// with an error other than the not found error of the api server.
+func removeUpgradeTaskCRD(client *Client) {
+	fakeKube(client).Discovery().(*fakediscovery.FakeDiscovery).Resources = apiResourceLists(
+		cstorCRDs, crdGroup{groupVersion: upgradeTaskCRDs.groupVersion},
+	)
+}
+
+func TestClient_isNoUpgradeTaskCRDError(t *testing.T) {
+	gr := schema.GroupResource{Group: "openebs.io", Resource: "upgradetasks"}
+	noMatch := &meta.NoKindMatchError{GroupKind: schema.GroupKind{Group: "openebs.io", Kind: "UpgradeTask"}}
+	tests := []struct {
+		name       string
+		err        error
+		crdMissing bool
+		want       bool
+	}{
+		{name: "no kind match", err: noMatch, crdMissing: true, want: true},
+		{name: "resource not served", err: k8serror.NewNotFound(schema.GroupResource{}, ""), crdMissing: true, want: true},
+		{
+			name:       "wrapped resource not served",
+			err:        errors.Wrap(k8serror.NewNotFound(schema.GroupResource{}, ""), "failed"),
+			crdMissing: true,
+			want:       true,
+		},
+		{
+			// the name is filled from the request for a plain text 404
+			name:       "resource not served with the name",
+			err:        k8serror.NewNotFound(gr, "upgrade-cstor-cspi-cspc-a-1"),
+			crdMissing: true,
+			want:       true,
+		},
+		{name: "upgradetask not found", err: k8serror.NewNotFound(gr, "upgrade-cstor-cspi-cspc-a-1")},
+		{name: "stale rest mapper", err: noMatch},
+		{name: "other error", err: k8serror.NewForbidden(gr, "", errors.New("denied")), crdMissing: true},
+		{name: "no error", crdMissing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient()
+			if tt.crdMissing {
+				removeUpgradeTaskCRD(client)
+			}
+			if got := client.isNoUpgradeTaskCRDError(tt.err); got != tt.want {
+				t.Errorf("isNoUpgradeTaskCRDError() = %v, want %v", got, tt.want)
+			}
+			if got := client.upgradeTasksDisabled(); got != tt.crdMissing {
+				t.Errorf("upgradeTasksDisabled() = %v, want %v", got, tt.crdMissing)
+			}
+		})
+	}
+}
+
+func TestGetOrCreateUpgradeTaskWithoutCRD(t *testing.T) {
+	client := NewTestClient()
+	client.upgradeTaskJob = true
+	removeUpgradeTaskCRD(client)
+	r := NewResourcePatch(WithName("cspc-a-1"), WithOpenebsNamespace("openebs"))
+	utaskObj, err := getOrCreateUpgradeTask("cstorPoolInstance", r, client)
+	if err != nil || utaskObj != nil {
+		t.Fatalf("getOrCreateUpgradeTask() = %v, %v, want no upgradetask and no error", utaskObj, err)
+	}
+	if client.IsUpgradeTaskJob() {
+		t.Errorf("IsUpgradeTaskJob() = true without the upgradetask crd")
+	}
+	if n := len(fakeOpenebs(client).Actions()); n != 0 {
+		t.Errorf("got %d upgradetask calls without the upgradetask crd", n)
+	}
+	statusObj := v1Alpha1API.UpgradeDetailedStatuses{Step: v1Alpha1API.PreUpgrade}
+	statusObj.Phase = v1Alpha1API.StepWaiting
+	_, err = updateUpgradeDetailedStatus(nil, statusObj, "openebs", client)
+	if err != nil {
+		t.Errorf("updateUpgradeDetailedStatus() error = %v without the upgradetask crd", err)
+	}
+}
+
+func TestGetOrCreateUpgradeTaskCreateWithoutCRD(t *testing.T) {
+	client := NewTestClient()
+	client.upgradeTaskJob = true
+	// the crd is removed after it was discovered
+	if client.upgradeTasksDisabled() {
+		t.Fatalf("upgradeTasksDisabled() = true with the upgradetask crd")
+	}
+	removeUpgradeTaskCRD(client)
+	fakeOpenebs(client).PrependReactor("create", "upgradetasks",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			name := action.(clienttesting.CreateAction).GetObject().(*v1Alpha1API.UpgradeTask).Name
+			return true, nil, k8serror.NewNotFound(action.GetResource().GroupResource(), name)
+		})
+	r := NewResourcePatch(WithName("cspc-a-1"), WithOpenebsNamespace("openebs"))
+	utaskObj, err := getOrCreateUpgradeTask("cstorPoolInstance", r, client)
+	if err != nil || utaskObj != nil {
+		t.Fatalf("getOrCreateUpgradeTask() = %v, %v, want no upgradetask and no error", utaskObj, err)
+	}
+	if client.IsUpgradeTaskJob() {
+		t.Errorf("IsUpgradeTaskJob() = true once the create found no upgradetask crd")
+	}
+}
+
+func TestGetOrCreateUpgradeTaskDisabled(t *testing.T) {
+	client := NewTestClient()
+	client.upgradeTaskJob = true
+	WithoutUpgradeTasks(true)(client)
+	r := NewResourcePatch(WithName("cspc-a-1"), WithOpenebsNamespace("openebs"))
+	utaskObj, err := getOrCreateUpgradeTask("cstorPoolInstance", r, client)
+	if err != nil || utaskObj != nil {
+		t.Fatalf("getOrCreateUpgradeTask() = %v, %v, want no upgradetask and no error", utaskObj, err)
+	}
+	if client.IsUpgradeTaskJob() {
+		t.Errorf("IsUpgradeTaskJob() = true with the upgradetasks disabled")
+	}
+	if err := SyncUpgradeTaskFromResource("cstorPoolInstance", r, client); err != nil {
+		t.Errorf("SyncUpgradeTaskFromResource() error = %v with the upgradetasks disabled", err)
+	}
+	if len(fakeOpenebs(client).Actions()) != 0 {
+		t.Errorf("got upgradetask calls %v with the upgradetasks disabled", fakeOpenebs(client).Actions())
+	}
+}
+
+func TestSyncUpgradeTaskFromResource(t *testing.T) {
+	utask := func(to string, phase v1Alpha1API.UpgradePhase) *v1Alpha1API.UpgradeTask {
+		return &v1Alpha1API.UpgradeTask{
+			ObjectMeta: metav1.ObjectMeta{Name: "upgrade-cstor-cspi-cspc-a-1", Namespace: "openebs"},
+			Spec:       v1Alpha1API.UpgradeTaskSpec{FromVersion: "2.12.0", ToVersion: to},
+			Status:     v1Alpha1API.UpgradeTaskStatus{Phase: phase},
+		}
+	}
+	deploy := func(v string) *appsv1.Deployment {
+		return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Name:      "cspc-a-1",
+			Namespace: "openebs",
+			Labels: map[string]string{
+				"openebs.io/cstor-pool-instance": "cspc-a-1",
+				"openebs.io/version":             v,
+			},
+		}}
+	}
+	tests := []struct {
+		name      string
+		utask     *v1Alpha1API.UpgradeTask
+		current   string
+		wantPhase v1Alpha1API.UpgradePhase
+	}{
+		{"stale started task", utask("3.0.0", v1Alpha1API.UpgradeStarted), "3.0.0", v1Alpha1API.UpgradeSuccess},
+		{"stale failed task", utask("3.0.0", v1Alpha1API.UpgradeError), "3.0.0", v1Alpha1API.UpgradeSuccess},
+		{"stale successful task", utask("3.0.0", v1Alpha1API.UpgradeSuccess), "2.12.0", v1Alpha1API.UpgradeStarted},
+		{"task in sync", utask("3.0.0", v1Alpha1API.UpgradeStarted), "2.12.0", v1Alpha1API.UpgradeStarted},
+		{"task of an other upgrade", utask("2.12.0", v1Alpha1API.UpgradeSuccess), "2.11.0", v1Alpha1API.UpgradeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(
+				tt.utask,
+				upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", tt.current),
+				deploy(tt.current),
+			)
+			r := &ResourcePatch{Name: "cspc-a-1", To: "3.0.0", OpenebsNamespace: "openebs"}
+			// the sync is idempotent
+			for i := 0; i < 2; i++ {
+				if err := SyncUpgradeTaskFromResource("cstorPoolInstance", r, client); err != nil {
+					t.Fatalf("SyncUpgradeTaskFromResource() error = %v", err)
+				}
+			}
+			got, err := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks("openebs").
+				Get(context.TODO(), tt.utask.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get upgradetask: %v", err)
+			}
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", got.Status.Phase, tt.wantPhase)
+			}
+			if tt.wantPhase == v1Alpha1API.UpgradeSuccess && tt.utask.Spec.ToVersion == r.To &&
+				got.Status.CompletedTime.IsZero() {
+				t.Errorf("completedTime is not set")
+			}
+		})
+	}
+
+	// no upgradetask is not an error
+	client := NewTestClient(upgradetesting.NewTestCSPI("cspc-a-1", "cspc-a", "3.0.0"))
+	r := &ResourcePatch{Name: "cspc-a-1", To: "3.0.0", OpenebsNamespace: "openebs"}
+	if err := SyncUpgradeTaskFromResource("cstorPoolInstance", r, client); err != nil {
+		t.Errorf("SyncUpgradeTaskFromResource() without an upgradetask error = %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openebs/upgrade/pkg/version"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+)
+
+// ErrVersionRegression is the cause of the verification failure when the
+// current version of the resource moves back to an older version, which
+// happens when an operator of an older version is still running
+var ErrVersionRegression = errors.New("version regression detected")
+
+// defaultTransientMessages are the reconcile messages seen while
+// the operator or the api server is briefly unavailable, for
+// example during a rollout of the operator
+var defaultTransientMessages = []string{
+	"connection refused",
+	"connection reset by peer",
+	"no endpoints available",
+	"i/o timeout",
+	"tls handshake timeout",
+	"context deadline exceeded",
+	"the object has been modified",
+}
+
+const (
+	// defaultPollInterval is the default sync time of the operators
+	defaultPollInterval = 10 * time.Second
+	// maxPollInterval caps the backoff of the poll interval
+	maxPollInterval = 5 * time.Minute
+)
+
+// ReconcileStatus is the version status of a resource as reported
+// by its operator, along with the resource it was read from
+type ReconcileStatus struct {
+	Current string
+	Message string
+	Reason  string
+	Object  runtime.Object
+}
+
+// versionStatusFunc returns the latest version status of a resource
+type versionStatusFunc func() (ReconcileStatus, error)
+
+// ReconcilePredicate returns true once the resource is reconciled,
+// or false along with a message of what it is still waiting for
+type ReconcilePredicate func(status ReconcileStatus) (done bool, msg string)
+
+// VersionReconciled returns the predicate which is done once the
+// current version of the resource is the given version
+func VersionReconciled(to string) ReconcilePredicate {
+	return func(status ReconcileStatus) (bool, string) {
+		if status.Current == to {
+			return true, ""
+		}
+		return false, fmt.Sprintf("current version is %q", status.Current)
+	}
+}
+
+// reconcilePredicate returns the ReconcilePredicate if set,
+// else the predicate of the To version
+func (r *ResourcePatch) reconcilePredicate() ReconcilePredicate {
+	if r.ReconcilePredicate != nil {
+		return r.ReconcilePredicate
+	}
+	return VersionReconciled(r.To)
+}
+
+// isTransientMessage returns true if the reconcile message or reason
+// contains any of the configured transient messages
+func (r *ResourcePatch) isTransientMessage(status ReconcileStatus) bool {
+	transient := r.TransientMessages
+	if transient == nil {
+		transient = defaultTransientMessages
+	}
+	message := strings.ToLower(status.Message + " " + status.Reason)
+	for _, t := range transient {
+		if t != "" && strings.Contains(message, strings.ToLower(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionHistory records the transitions of the current version
+// of a resource while its reconciliation is verified
+type versionHistory struct {
+	transitions []string
+}
+
+// observe records the current version and returns an ErrVersionRegression
+// if it went back to a version seen before, or to an older version than the
+// previous one
+func (h *versionHistory) observe(current string) error {
+	if current == "" {
+		return nil
+	}
+	n := len(h.transitions)
+	if n > 0 && h.transitions[n-1] == current {
+		return nil
+	}
+	h.transitions = append(h.transitions, current)
+	if n == 0 {
+		return nil
+	}
+	regressed := false
+	for _, v := range h.transitions[:n-1] {
+		if v == current {
+			regressed = true
+		}
+	}
+	prev, perr := version.ParseVersionFlexible(h.transitions[n-1])
+	cur, cerr := version.ParseVersionFlexible(current)
+	if perr == nil && cerr == nil {
+		if c, err := cur.Compare(prev); err == nil && c < 0 {
+			regressed = true
+		}
+	}
+	if regressed {
+		return errors.Wrapf(ErrVersionRegression,
+			"current version changed %s, check for operators of mixed versions",
+			strings.Join(h.transitions, " -> "),
+		)
+	}
+	return nil
+}
+
+// verifyVersionReconcile waits for the current version of the resource
+// to be equal to the desired version. The polls are delayed by the
+// Backoff strategy, by default the poll interval starts at the
+// PollInterval and is doubled every time the version is not yet
+// reconciled, to reduce the load on the api server when many resources
+// are verified. Reconcile failures with transient messages are only
+// logged, while other failures are counted towards the
+// ReconcileFailureThreshold if one is set. The verification fails
+// right away if the current version regresses to an older version,
+// and with a timeout error once the ReconcileTimeout is exceeded.
+// The resource is reconciled once the ReconcilePredicate is done, which
+// by default is once its current version is the To version. Once
+// reconciled, the version must stay at the to version for the
+// VersionStabilityPolls and the ReconcileStablePeriod, polled at
+// the PollInterval.
+func (r *ResourcePatch) verifyVersionReconcile(name string, get versionStatusFunc) error {
+	// get the latest version status
+	status, err := get()
+	if err != nil {
+		return err
+	}
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	failures := 0
+	history := &versionHistory{}
+	start := time.Now()
+	reconciled := r.reconcilePredicate()
+	backoff := r.reconcileBackoff()
+	log := &waitLogger{}
+	// waiting for the resource to be reconciled, by default
+	// for the current version to be equal to desired version
+	for done, msg := reconciled(status); !done; done, msg = reconciled(status) {
+		err = history.observe(status.Current)
+		if err != nil {
+			return errors.Wrapf(err, "failed to reconcile version of %s", name)
+		}
+		if r.ReconcileTimeout > 0 && time.Since(start) >= r.ReconcileTimeout {
+			return newTimeoutError(errors.Errorf(
+				"version of %s did not reconcile to %s within %s, %s",
+				name, r.To, r.ReconcileTimeout, msg,
+			))
+		}
+		delay := backoff.Delay(interval, log.polls+1)
+		log.Infof("Verifying the reconciliation of version for %s, %s, interval=%s", name, msg, delay)
+		time.Sleep(delay)
+		status, err = get()
+		if err != nil {
+			return err
+		}
+		if status.Message == "" {
+			continue
+		}
+		if r.isTransientMessage(status) {
+			klog.Warningf("transient failure to reconcile %s, retrying: %s", name, status.Reason)
+			continue
+		}
+		failures++
+		klog.Errorf("failed to reconcile %s, attempt %d: %s", name, failures, status.Reason)
+		if r.ReconcileFailureThreshold > 0 && failures >= r.ReconcileFailureThreshold {
+			return errors.Errorf(
+				"failed to reconcile version of %s after %d attempts: %s",
+				name, failures, status.Reason,
+			)
+		}
+	}
+	return r.verifyVersionStable(name, get, history)
+}
+
+// verifyVersionStable polls the reconciled version for the
+// VersionStabilityPolls, and until it has been reconciled for the
+// ReconcileStablePeriod, and returns an ErrVersionRegression if it
+// changes from the to version, as an operator of an older version
+// may reconcile it back after it was reconciled
+func (r *ResourcePatch) verifyVersionStable(name string, get versionStatusFunc, history *versionHistory) error {
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	_ = history.observe(r.To)
+	log := &waitLogger{}
+	reconciledAt := time.Now()
+	for i := 1; i <= r.VersionStabilityPolls || time.Since(reconciledAt) < r.ReconcileStablePeriod; i++ {
+		delay := r.backoff().Delay(interval, i)
+		if i <= r.VersionStabilityPolls {
+			log.Infof("Verifying the version of %s stays at %s, %d of %d, interval=%s",
+				name, r.To, i, r.VersionStabilityPolls, delay)
+		} else {
+			// the last poll is at the end of the period
+			if remaining := r.ReconcileStablePeriod - time.Since(reconciledAt); delay > remaining {
+				delay = remaining
+			}
+			log.Infof("Verifying the version of %s stays at %s for %s, stable for %s, interval=%s",
+				name, r.To, r.ReconcileStablePeriod, time.Since(reconciledAt).Round(time.Second), delay)
+		}
+		time.Sleep(delay)
+		status, err := get()
+		if err != nil {
+			return err
+		}
+		if status.Current == "" || status.Current == r.To {
+			continue
+		}
+		_ = history.observe(status.Current)
+		return errors.Wrapf(ErrVersionRegression,
+			"failed to reconcile version of %s: current version changed %s after it was reconciled, check for operators of mixed versions",
+			name, strings.Join(history.transitions, " -> "),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	openebsFakeClientset "github.com/openebs/api/v3/pkg/client/clientset/versioned/fake"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// reconcileOnGet makes the clientset return the cstor resources with
+// their current version set to the desired one, as if the operators
+// reconciled them as soon as they are patched
+func reconcileOnGet(clientset *openebsFakeClientset.Clientset) {
+	clientset.PrependReactor("get", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		obj, err := clientset.Tracker().Get(get.GetResource(), get.GetNamespace(), get.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		obj = obj.DeepCopyObject()
+		var details *cstor.VersionDetails
+		switch o := obj.(type) {
+		case *cstor.CStorPoolCluster:
+			details = &o.VersionDetails
+		case *cstor.CStorPoolInstance:
+			details = &o.VersionDetails
+		case *cstor.CStorVolume:
+			details = &o.VersionDetails
+		case *cstor.CStorVolumeConfig:
+			details = &o.VersionDetails
+		case *cstor.CStorVolumeReplica:
+			details = &o.VersionDetails
+		default:
+			return true, obj, nil
+		}
+		if details.Desired != "" {
+			details.Status.Current = details.Desired
+		}
+		return true, obj, nil
+	})
+}
+
+func TestResourcePatch_isTransientMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		transient []string
+		status    ReconcileStatus
+		want      bool
+	}{
+		{
+			name: "default connection refused",
+			status: ReconcileStatus{
+				Message: "failed to reconcile",
+				Reason:  "dial tcp 10.0.0.1:443: connect: Connection refused",
+			},
+			want: true,
+		},
+		{
+			name: "default genuine failure",
+			status: ReconcileStatus{
+				Message: "failed to reconcile",
+				Reason:  "pool is not healthy",
+			},
+			want: false,
+		},
+		{
+			name:      "configured message",
+			transient: []string{"pool is not healthy"},
+			status: ReconcileStatus{
+				Message: "failed to reconcile",
+				Reason:  "pool is not healthy",
+			},
+			want: true,
+		},
+		{
+			name:      "configured messages replace the defaults",
+			transient: []string{},
+			status: ReconcileStatus{
+				Message: "failed to reconcile",
+				Reason:  "connection refused",
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResourcePatch(WithTransientMessages(tt.transient))
+			if got := r.isTransientMessage(tt.status); got != tt.want {
+				t.Errorf("isTransientMessage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_versionHistory_observe(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		wantErr  string
+	}{
+		{name: "no change", versions: []string{"2.12.0", "2.12.0", ""}},
+		{name: "approaching", versions: []string{"2.12.0", "3.0.0-RC1", "3.0.0"}},
+		{
+			name:     "older version",
+			versions: []string{"2.12.0", "3.0.0-RC1", "2.12.0"},
+			wantErr:  "2.12.0 -> 3.0.0-RC1 -> 2.12.0",
+		},
+		{
+			name:     "back to a custom version",
+			versions: []string{"abc1234", "def5678", "abc1234"},
+			wantErr:  "abc1234 -> def5678 -> abc1234",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &versionHistory{}
+			var err error
+			for _, v := range tt.versions {
+				if err = h.observe(v); err != nil {
+					break
+				}
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("observe() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrVersionRegression) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("observe() error = %v, want regression %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResourcePatch_verifyVersionReconcileRegression(t *testing.T) {
+	versions := []string{"2.12.0", "3.0.0-RC1", "2.12.0", "3.0.0"}
+	r := &ResourcePatch{To: "3.0.0", PollInterval: time.Millisecond}
+	calls := 0
+	err := r.verifyVersionReconcile("cspi-1", func() (ReconcileStatus, error) {
+		status := ReconcileStatus{Current: versions[calls]}
+		calls++
+		return status, nil
+	})
+	if !errors.Is(err, ErrVersionRegression) {
+		t.Fatalf("verifyVersionReconcile() error = %v, want %v", err, ErrVersionRegression)
+	}
+	if calls != 3 {
+		t.Errorf("verifyVersionReconcile() polled %d times, want 3", calls)
+	}
+}
+
+func TestResourcePatch_verifyVersionStable(t *testing.T) {
+	tests := []struct {
+		name      string
+		versions  []string
+		polls     int
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "reconciled back to the from version",
+			versions:  []string{"2.12.0", "3.0.0", "2.12.0"},
+			polls:     2,
+			wantErr:   ErrVersionRegression,
+			wantCalls: 3,
+		},
+		{
+			name:      "reconciled to another version",
+			versions:  []string{"3.0.0", "3.0.0", "3.1.0"},
+			polls:     3,
+			wantErr:   ErrVersionRegression,
+			wantCalls: 3,
+		},
+		{
+			name:      "stable",
+			versions:  []string{"2.12.0", "3.0.0", "3.0.0", "3.0.0"},
+			polls:     2,
+			wantCalls: 4,
+		},
+		{
+			name:      "no stability window",
+			versions:  []string{"2.12.0", "3.0.0", "2.12.0"},
+			wantCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourcePatch{To: "3.0.0", PollInterval: time.Millisecond, VersionStabilityPolls: tt.polls}
+			calls := 0
+			err := r.verifyVersionReconcile("cspi-1", func() (ReconcileStatus, error) {
+				status := ReconcileStatus{Current: tt.versions[calls]}
+				calls++
+				return status, nil
+			})
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("verifyVersionReconcile() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verifyVersionReconcile() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("verifyVersionReconcile() polled %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResourcePatch_verifyVersionStablePeriod(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		period   time.Duration
+		wantErr  error
+	}{
+		{
+			name:     "stable for the period",
+			versions: []string{"2.12.0", "3.0.0"},
+			period:   20 * time.Millisecond,
+		},
+		{
+			// the version flaps back after more polls than the stability polls
+			name:     "flapping within the period",
+			versions: []string{"3.0.0", "3.0.0", "3.0.0", "3.0.0", "2.12.0"},
+			period:   time.Hour,
+			wantErr:  ErrVersionRegression,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourcePatch{
+				To:                    "3.0.0",
+				PollInterval:          time.Millisecond,
+				VersionStabilityPolls: 2,
+				ReconcileStablePeriod: tt.period,
+			}
+			calls := 0
+			start := time.Now()
+			err := r.verifyVersionReconcile("cspi-1", func() (ReconcileStatus, error) {
+				i := calls
+				if i >= len(tt.versions) {
+					i = len(tt.versions) - 1
+				}
+				calls++
+				return ReconcileStatus{Current: tt.versions[i]}, nil
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("verifyVersionReconcile() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyVersionReconcile() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed < tt.period {
+				t.Errorf("verifyVersionReconcile() returned after %s, want at least %s", elapsed, tt.period)
+			}
+		})
+	}
+}
+
+func TestResourcePatch_verifyVersionReconcilePredicate(t *testing.T) {
+	cspc := func(current string, healthy int32) *cstor.CStorPoolCluster {
+		c := &cstor.CStorPoolCluster{}
+		c.VersionDetails.Status.Current = current
+		c.Status.HealthyInstances = healthy
+		return c
+	}
+	statuses := []*cstor.CStorPoolCluster{
+		cspc("2.12.0", 0),
+		cspc("3.0.0", 0),
+		cspc("3.0.0", 1),
+	}
+	// the cspc is reconciled once it is at the to version with a healthy pool
+	healthy := func(status ReconcileStatus) (bool, string) {
+		if done, msg := VersionReconciled("3.0.0")(status); !done {
+			return done, msg
+		}
+		if status.Object.(*cstor.CStorPoolCluster).Status.HealthyInstances == 0 {
+			return false, "no healthy pools"
+		}
+		return true, ""
+	}
+	tests := []struct {
+		name      string
+		predicate ReconcilePredicate
+		wantCalls int
+	}{
+		{name: "version reconciled by default", wantCalls: 2},
+		{name: "custom predicate", predicate: healthy, wantCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResourcePatch(
+				ToVersion("3.0.0"),
+				WithPollInterval(time.Millisecond),
+				WithReconcilePredicate(tt.predicate),
+			)
+			calls := 0
+			err := r.verifyVersionReconcile("cspc-a", func() (ReconcileStatus, error) {
+				obj := statuses[calls]
+				calls++
+				return ReconcileStatus{Current: obj.VersionDetails.Status.Current, Object: obj}, nil
+			})
+			if err != nil {
+				t.Fatalf("verifyVersionReconcile() error = %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("verifyVersionReconcile() polled %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestVersionReconciled(t *testing.T) {
+	done, msg := VersionReconciled("3.0.0")(ReconcileStatus{Current: "2.12.0"})
+	if done || msg != `current version is "2.12.0"` {
+		t.Errorf("VersionReconciled() = %v, %q", done, msg)
+	}
+	done, _ = VersionReconciled("3.0.0")(ReconcileStatus{Current: "3.0.0"})
+	if !done {
+		t.Errorf("VersionReconciled() = false, want true")
+	}
+}
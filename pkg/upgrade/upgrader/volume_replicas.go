@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// replicaModeHealthy is the mode of a healthy replica in the
+// replica statuses of a cstor volume
+const replicaModeHealthy = "Healthy"
+
+// healthyReplicas returns the number of healthy replicas of the cstor
+// volume, and the minimum of healthy replicas needed to upgrade it
+func (obj *CStorVolumePatch) healthyReplicas(cvObj *cstor.CStorVolume) (int, int) {
+	healthy := 0
+	for _, status := range cvObj.Status.ReplicaStatuses {
+		if status.Mode == replicaModeHealthy {
+			healthy++
+		}
+	}
+	if obj.MinHealthyReplicas > 0 {
+		return healthy, obj.MinHealthyReplicas
+	}
+	return healthy, cvObj.Spec.ReplicationFactor
+}
+
+// checkHealthyReplicas returns an error if the healthy replicas of the
+// volume are fewer than the MinHealthyReplicas, all the replicas of its
+// replication factor if not set, as the restart of the target interrupts
+// the i/o which would then be served by even fewer replicas. With a
+// ReplicaWaitTimeout the replicas are waited for instead of failing
+// right away. The check is disabled by a negative MinHealthyReplicas.
+func (obj *CStorVolumePatch) checkHealthyReplicas() error {
+	if obj.MinHealthyReplicas < 0 {
+		return nil
+	}
+	interval := obj.pollInterval()
+	log := &waitLogger{}
+	deadline := time.Now().Add(obj.ReplicaWaitTimeout)
+	for {
+		cvObj, err := obj.OpenebsClientset.CstorV1().CStorVolumes(obj.Namespace).
+			Get(context.TODO(), obj.Name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get cv %s", obj.Name)
+		}
+		healthy, min := obj.healthyReplicas(cvObj)
+		if healthy >= min {
+			return nil
+		}
+		if obj.ReplicaWaitTimeout <= 0 {
+			return errors.Errorf("volume %s has %d healthy replicas, %d are needed to restart its target",
+				obj.Name, healthy, min)
+		}
+		if time.Now().After(deadline) {
+			return newTimeoutError(errors.Errorf(
+				"volume %s has %d healthy replicas after %s, %d are needed to restart its target",
+				obj.Name, healthy, obj.ReplicaWaitTimeout, min,
+			))
+		}
+		log.Infof("volume %s: waiting for the replicas to be healthy, %d out of %d healthy",
+			obj.Name, healthy, min)
+		time.Sleep(obj.backoff().Delay(interval, log.polls))
+	}
+}
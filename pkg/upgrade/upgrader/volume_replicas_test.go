@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testCVWithReplicas(name string, replicationFactor int, modes ...string) *cstor.CStorVolume {
+	cvObj := &cstor.CStorVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: upgradetesting.Namespace},
+		Spec:       cstor.CStorVolumeSpec{ReplicationFactor: replicationFactor},
+	}
+	for _, mode := range modes {
+		cvObj.Status.ReplicaStatuses = append(cvObj.Status.ReplicaStatuses, cstor.ReplicaStatus{Mode: mode})
+	}
+	return cvObj
+}
+
+func testReplicaCheck(client *Client, opts ...ResourcePatchOptions) *CStorVolumePatch {
+	opts = append([]ResourcePatchOptions{
+		WithName("pvc-1"),
+		WithOpenebsNamespace(upgradetesting.Namespace),
+		WithPollInterval(time.Millisecond),
+	}, opts...)
+	return &CStorVolumePatch{
+		ResourcePatch: NewResourcePatch(opts...),
+		Namespace:     upgradetesting.Namespace,
+		Client:        client,
+	}
+}
+
+func TestCStorVolumePatch_checkHealthyReplicas(t *testing.T) {
+	tests := []struct {
+		name    string
+		cv      *cstor.CStorVolume
+		opts    []ResourcePatchOptions
+		wantErr bool
+	}{
+		{
+			name: "all replicas healthy",
+			cv:   testCVWithReplicas("pvc-1", 3, "Healthy", "Healthy", "Healthy"),
+		},
+		{
+			name:    "a replica degraded",
+			cv:      testCVWithReplicas("pvc-1", 3, "Healthy", "Healthy", "Degraded"),
+			wantErr: true,
+		},
+		{
+			name:    "a replica missing",
+			cv:      testCVWithReplicas("pvc-1", 3, "Healthy", "Healthy"),
+			wantErr: true,
+		},
+		{
+			name: "enough replicas healthy for the minimum",
+			cv:   testCVWithReplicas("pvc-1", 3, "Healthy", "Healthy", "Degraded"),
+			opts: []ResourcePatchOptions{WithMinHealthyReplicas(2)},
+		},
+		{
+			name:    "fewer replicas healthy than the minimum",
+			cv:      testCVWithReplicas("pvc-1", 3, "Healthy", "Degraded", "Degraded"),
+			opts:    []ResourcePatchOptions{WithMinHealthyReplicas(2)},
+			wantErr: true,
+		},
+		{
+			name: "check disabled",
+			cv:   testCVWithReplicas("pvc-1", 3, "Degraded"),
+			opts: []ResourcePatchOptions{WithMinHealthyReplicas(-1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testReplicaCheck(NewTestClient(tt.cv), tt.opts...).checkHealthyReplicas()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkHealthyReplicas() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCStorVolumePatch_checkHealthyReplicasWait(t *testing.T) {
+	cvObj := testCVWithReplicas("pvc-1", 3, "Healthy", "Healthy", "Degraded")
+	client := NewTestClient(cvObj)
+
+	err := testReplicaCheck(client, WithReplicaWaitTimeout(20*time.Millisecond)).checkHealthyReplicas()
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("checkHealthyReplicas() error = %v, want a timeout error", err)
+	}
+
+	healthy := testCVWithReplicas("pvc-1", 3, "Healthy", "Healthy", "Healthy")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.OpenebsClientset.CstorV1().CStorVolumes(healthy.Namespace).
+			Update(context.TODO(), healthy, metav1.UpdateOptions{})
+	}()
+	err = testReplicaCheck(client, WithReplicaWaitTimeout(time.Second)).checkHealthyReplicas()
+	if err != nil {
+		t.Errorf("checkHealthyReplicas() error = %v, want the replicas to be healthy", err)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+
+	"github.com/openebs/upgrade/pkg/upgrade/patch"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// WaitForVersion waits for the resource of the given kind and its
+// dependents to be reconciled to the To version without patching
+// anything. Unlike VerifyOnly the resources are not initialized for
+// an upgrade, so the From version is not checked, which suits upgrades
+// where the desired version is set outside of the upgrade job.
+func WaitForVersion(kind string, r *ResourcePatch, client *Client) error {
+	klog.Infof("Waiting for %s %s to reconcile to %s", kind, r.Name, r.To)
+	var err error
+	switch kind {
+	case "cstorPoolCluster":
+		err = waitForCSPCVersion(r, client)
+	case "cstorPoolInstance":
+		err = waitForCSPIVersion(r, client)
+	case "cstorVolume":
+		err = waitForCStorVolumeVersion(r, client)
+	case "jivaVolume":
+		err = waitForJivaVolumeVersion(r, client)
+	default:
+		return newValidationError(errors.Errorf("wait for version is not supported for %s", kind))
+	}
+	if err != nil {
+		return err
+	}
+	klog.Infof("%s %s is reconciled to %s", kind, r.Name, r.To)
+	return nil
+}
+
+func waitForCSPCVersion(r *ResourcePatch, client *Client) error {
+	cspiList, err := client.OpenebsClientset.CstorV1().
+		CStorPoolInstances(r.OpenebsNamespace).List(context.TODO(),
+		metav1.ListOptions{
+			LabelSelector: "openebs.io/cstor-pool-cluster=" + r.Name,
+		},
+	)
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to list cspis of %s", r.Name))
+	}
+	for _, cspiObj := range cspiList.Items {
+		res := *r
+		res.Name = cspiObj.Name
+		err = waitForCSPIVersion(&res, client)
+		if err != nil {
+			return err
+		}
+	}
+	obj := NewCSPCPatch(
+		WithCSPCResorcePatch(r),
+		WithCSPCClient(client),
+	)
+	obj.Namespace = r.OpenebsNamespace
+	obj.CSPC = patch.NewCSPC(patch.WithCSPCClient(client.OpenebsClientset))
+	return newAPIError(obj.verifyCSPCVersionReconcile())
+}
+
+func waitForCSPIVersion(r *ResourcePatch, client *Client) error {
+	obj := NewCSPIPatch(
+		WithCSPIResorcePatch(r),
+		WithCSPIClient(client),
+	)
+	obj.Namespace = r.OpenebsNamespace
+	obj.CSPI = patch.NewCSPI(patch.WithCSPIClient(client.OpenebsClientset))
+	msg, err := obj.verifyCSPIVersionReconcile()
+	if err != nil {
+		return newAPIError(errors.Wrap(err, msg))
+	}
+	return nil
+}
+
+func waitForCStorVolumeVersion(r *ResourcePatch, client *Client) error {
+	cvrList, err := client.OpenebsClientset.CstorV1().
+		CStorVolumeReplicas(r.OpenebsNamespace).List(context.TODO(),
+		metav1.ListOptions{
+			LabelSelector: "openebs.io/persistent-volume=" + r.Name,
+		},
+	)
+	if err != nil {
+		return newAPIError(errors.Wrapf(err, "failed to list cvrs of %s", r.Name))
+	}
+	for _, cvrObj := range cvrList.Items {
+		res := *r
+		res.Name = cvrObj.Name
+		cvr := NewCVRPatch(
+			WithCVRResorcePatch(&res),
+			WithCVRClient(client),
+		)
+		cvr.Namespace = r.OpenebsNamespace
+		cvr.CVR = patch.NewCVR(patch.WithCVRClient(client.OpenebsClientset))
+		err = cvr.verifyCVRVersionReconcile()
+		if err != nil {
+			return newAPIError(err)
+		}
+	}
+	obj := NewCStorVolumePatch(
+		WithCStorVolumeResorcePatch(r),
+		WithCStorVolumeClient(client),
+	)
+	obj.Namespace = r.OpenebsNamespace
+	obj.CV = patch.NewCV(patch.WithCVClient(client.OpenebsClientset))
+	obj.CVC = patch.NewCVC(patch.WithCVCClient(client.OpenebsClientset))
+	err = obj.verifyCVVersionReconcile()
+	if err != nil {
+		return newAPIError(err)
+	}
+	return newAPIError(obj.verifyCVCVersionReconcile())
+}
+
+func waitForJivaVolumeVersion(r *ResourcePatch, client *Client) error {
+	cl, err := newJivaClient(client.Config)
+	if err != nil {
+		return newAPIError(errors.Wrap(err, "failed to create runtime client"))
+	}
+	obj := NewJivaVolumePatch(
+		WithJivaVolumeResorcePatch(r),
+		WithJivaVolumeClient(client),
+	)
+	obj.Namespace = r.OpenebsNamespace
+	obj.JivaVolumeCR = patch.NewJV(patch.WithJVClient(cl))
+	return newAPIError(obj.verifyJivaVolumeCRversionReconcile())
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"testing"
+	"time"
+
+	cstor "github.com/openebs/api/v3/pkg/apis/cstor/v1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+)
+
+func TestWaitForVersion(t *testing.T) {
+	versionDetails := func(current, reason string) cstor.VersionDetails {
+		return cstor.VersionDetails{
+			Desired: "3.0.0",
+			Status: cstor.VersionStatus{
+				Current: current,
+				Message: reason,
+				Reason:  reason,
+			},
+		}
+	}
+	cspi := func(name, current, reason string) *cstor.CStorPoolInstance {
+		cspi := upgradetesting.NewTestCSPI(name, "cspc-a", "")
+		cspi.VersionDetails = versionDetails(current, reason)
+		return cspi
+	}
+	cspc := upgradetesting.NewTestCSPC("cspc-a", "3.0.0")
+	tests := []struct {
+		name    string
+		kind    string
+		cspi    *cstor.CStorPoolInstance
+		wantErr bool
+	}{
+		{
+			name: "cspc and cspis reconciled",
+			kind: "cstorPoolCluster",
+			cspi: cspi("cspc-a-1", "3.0.0", ""),
+		},
+		{
+			name:    "cspi fails to reconcile",
+			kind:    "cstorPoolCluster",
+			cspi:    cspi("cspc-a-1", "2.12.0", "pool is not healthy"),
+			wantErr: true,
+		},
+		{
+			name: "cspi reconciled",
+			kind: "cstorPoolInstance",
+			cspi: cspi("cspc-a-1", "3.0.0", ""),
+		},
+		{
+			name:    "unsupported kind",
+			kind:    "rbac",
+			cspi:    cspi("cspc-a-1", "3.0.0", ""),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewTestClient(cspc, tt.cspi)
+			clientset := fakeOpenebs(client)
+			name := "cspc-a"
+			if tt.kind == "cstorPoolInstance" {
+				name = tt.cspi.Name
+			}
+			// the from version is not needed to wait for the version
+			r := NewResourcePatch(
+				WithName(name),
+				ToVersion("3.0.0"),
+				WithOpenebsNamespace("openebs"),
+				WithPollInterval(time.Millisecond),
+				WithReconcileFailureThreshold(1),
+			)
+			err := WaitForVersion(tt.kind, r, client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WaitForVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, action := range clientset.Actions() {
+				if action.GetVerb() != "get" && action.GetVerb() != "list" {
+					t.Errorf("WaitForVersion() made a %s request, want only reads", action.GetVerb())
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForVersion_cstorVolume(t *testing.T) {
+	tests := []struct {
+		name     string
+		cvrState string
+		wantErr  bool
+	}{
+		{
+			name:     "volume reconciled",
+			cvrState: "3.0.0",
+		},
+		{
+			name:     "cvr not reconciled",
+			cvrState: "2.12.0",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cvr := upgradetesting.NewTestCVR("pvc-1-cspi-a", "pvc-1", "cspi-a", "3.0.0")
+			cvr.VersionDetails.Status.Current = tt.cvrState
+			client := NewTestClient(
+				upgradetesting.NewTestCV("pvc-1", "3.0.0"),
+				upgradetesting.NewTestCVC("pvc-1", "3.0.0"),
+				cvr,
+			)
+			r := NewResourcePatch(
+				WithName("pvc-1"),
+				ToVersion("3.0.0"),
+				WithOpenebsNamespace(upgradetesting.Namespace),
+				WithPollInterval(time.Millisecond),
+				WithReconcileTimeout(50*time.Millisecond),
+			)
+			err := WaitForVersion("cstorVolume", r, client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WaitForVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
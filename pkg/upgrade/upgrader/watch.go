@@ -0,0 +1,139 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog"
+)
+
+// isUpgradeTaskDone returns true if the upgradetask
+// reached a phase it does not move out of
+func isUpgradeTaskDone(phase v1Alpha1API.UpgradePhase) bool {
+	return phase == v1Alpha1API.UpgradeSuccess || phase == v1Alpha1API.UpgradeError
+}
+
+// WatchUpgradeTask streams the status of the upgradetask each time its
+// phase changes, starting with its current status. The channel is closed
+// once the upgradetask succeeds, fails or is deleted, or the context is
+// done. The watch is started again if it is closed by the server.
+func WatchUpgradeTask(ctx context.Context, name, namespace string,
+	client *Client) (<-chan v1Alpha1API.UpgradeTaskStatus, error) {
+	utaskClient := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(namespace)
+	utaskObj, err := utaskClient.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get upgradetask %s", name)
+	}
+	watchFrom := func(resourceVersion string) (watch.Interface, error) {
+		return utaskClient.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: resourceVersion,
+		})
+	}
+	w, err := watchFrom(utaskObj.ResourceVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to watch upgradetask %s", name)
+	}
+	statuses := make(chan v1Alpha1API.UpgradeTaskStatus, 1)
+	go func() {
+		defer close(statuses)
+		defer func() { w.Stop() }()
+		send := func(status v1Alpha1API.UpgradeTaskStatus) bool {
+			select {
+			case statuses <- status:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		phase := utaskObj.Status.Phase
+		resourceVersion := utaskObj.ResourceVersion
+		if !send(utaskObj.Status) {
+			return
+		}
+		for !isUpgradeTaskDone(phase) {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-w.ResultChan():
+				if !ok || event.Type == watch.Error {
+					w.Stop()
+					klog.V(2).Infof("watch of upgradetask %s closed, watching again", name)
+					w, err = watchFrom(resourceVersion)
+					if err != nil {
+						klog.Errorf("failed to watch upgradetask %s: %v", name, err)
+						return
+					}
+					continue
+				}
+				obj, ok := event.Object.(*v1Alpha1API.UpgradeTask)
+				if !ok || obj.Name != name {
+					continue
+				}
+				if event.Type == watch.Deleted {
+					return
+				}
+				resourceVersion = obj.ResourceVersion
+				if obj.Status.Phase == phase {
+					continue
+				}
+				phase = obj.Status.Phase
+				if !send(obj.Status) {
+					return
+				}
+			}
+		}
+	}()
+	return statuses, nil
+}
+
+// PrintWatchOutput writes a timestamped line for each status of the
+// upgradetask received from the stream until it is closed, and returns
+// the last status received
+func PrintWatchOutput(w io.Writer, name string,
+	statuses <-chan v1Alpha1API.UpgradeTaskStatus) (v1Alpha1API.UpgradeTaskStatus, error) {
+	last := v1Alpha1API.UpgradeTaskStatus{}
+	for status := range statuses {
+		last = status
+		phase := string(status.Phase)
+		if phase == "" {
+			phase = "Pending"
+		}
+		line := fmt.Sprintf("%s  %s  %s", time.Now().Format(time.RFC3339), name, phase)
+		if l := len(status.UpgradeDetailedStatuses); l != 0 {
+			step := status.UpgradeDetailedStatuses[l-1]
+			line += fmt.Sprintf("  %s %s", step.Step, step.Phase)
+			if step.Message != "" {
+				line += ": " + step.Message
+			}
+		}
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return last, errors.Wrapf(err, "failed to write the status of upgradetask %s", name)
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrader
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1Alpha1API "github.com/openebs/api/v3/pkg/apis/openebs.io/v1alpha1"
+	upgradetesting "github.com/openebs/upgrade/pkg/upgrade/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWatchUpgradeTask(t *testing.T) {
+	utask := &v1Alpha1API.UpgradeTask{
+		ObjectMeta: metav1.ObjectMeta{Name: "upgrade-cstor-cspc-cspc-a", Namespace: upgradetesting.Namespace},
+	}
+	client := NewTestClient(utask)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	statuses, err := WatchUpgradeTask(ctx, utask.Name, upgradetesting.Namespace, client)
+	if err != nil {
+		t.Fatalf("WatchUpgradeTask() error = %v", err)
+	}
+	utaskClient := client.OpenebsClientset.OpenebsV1alpha1().UpgradeTasks(upgradetesting.Namespace)
+	go func() {
+		for _, phase := range []v1Alpha1API.UpgradePhase{
+			v1Alpha1API.UpgradeStarted,
+			// the same phase is not streamed again
+			v1Alpha1API.UpgradeStarted,
+			v1Alpha1API.UpgradeSuccess,
+		} {
+			obj, err := utaskClient.Get(ctx, utask.Name, metav1.GetOptions{})
+			if err != nil {
+				return
+			}
+			obj.Status.Phase = phase
+			obj.Status.UpgradeDetailedStatuses = []v1Alpha1API.UpgradeDetailedStatuses{{
+				Step:   v1Alpha1API.PreUpgrade,
+				Status: v1Alpha1API.Status{Phase: v1Alpha1API.StepCompleted, Message: "done"},
+			}}
+			_, err = utaskClient.Update(ctx, obj, metav1.UpdateOptions{})
+			if err != nil {
+				return
+			}
+		}
+	}()
+	buf := &bytes.Buffer{}
+	last, err := PrintWatchOutput(buf, utask.Name, statuses)
+	if err != nil {
+		t.Fatalf("PrintWatchOutput() error = %v", err)
+	}
+	if last.Phase != v1Alpha1API.UpgradeSuccess {
+		t.Fatalf("PrintWatchOutput() last phase = %q, want Success\n%s", last.Phase, buf.String())
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	wantPhases := []string{"Pending", "Started", "Success"}
+	if len(lines) != len(wantPhases) {
+		t.Fatalf("PrintWatchOutput() = %d lines, want %d\n%s", len(lines), len(wantPhases), buf.String())
+	}
+	for i, phase := range wantPhases {
+		if !strings.Contains(lines[i], utask.Name+"  "+phase) {
+			t.Errorf("PrintWatchOutput() line %d = %q, want phase %s", i, lines[i], phase)
+		}
+	}
+	if !strings.Contains(lines[2], "PRE_UPGRADE Completed: done") {
+		t.Errorf("PrintWatchOutput() line = %q, want the last step", lines[2])
+	}
+}
+
+func TestWatchUpgradeTask_done(t *testing.T) {
+	utask := &v1Alpha1API.UpgradeTask{
+		ObjectMeta: metav1.ObjectMeta{Name: "upgrade-cstor-pvc-1", Namespace: upgradetesting.Namespace},
+		Status:     v1Alpha1API.UpgradeTaskStatus{Phase: v1Alpha1API.UpgradeError},
+	}
+	statuses, err := WatchUpgradeTask(context.Background(), utask.Name,
+		upgradetesting.Namespace, NewTestClient(utask))
+	if err != nil {
+		t.Fatalf("WatchUpgradeTask() error = %v", err)
+	}
+	got := []v1Alpha1API.UpgradeTaskStatus{}
+	for s := range statuses {
+		got = append(got, s)
+	}
+	if len(got) != 1 || got[0].Phase != v1Alpha1API.UpgradeError {
+		t.Errorf("WatchUpgradeTask() = %v, want the failed status only", got)
+	}
+	_, err = WatchUpgradeTask(context.Background(), "missing", upgradetesting.Namespace, NewTestClient())
+	if err == nil {
+		t.Errorf("WatchUpgradeTask() of a missing upgradetask error = nil")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is the kind of a version string
+type Kind string
+
+const (
+	// KindSemver is a release version like 2.12.0 or 3.0.0-RC1
+	KindSemver Kind = "semver"
+	// KindCommit is a custom build identified by a commit, like
+	// abc1234 or dev-main-abc1234
+	KindCommit Kind = "commit"
+	// KindCI is a custom build identified by a ci tag, like ci-1234
+	KindCI Kind = "ci"
+)
+
+var (
+	semverRegex = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?$`)
+	commitRegex = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+	ciRegex     = regexp.MustCompile(`^ci-([0-9A-Za-z._-]+)$`)
+)
+
+// FlexibleVersion is a parsed version string of a release or a custom build
+type FlexibleVersion struct {
+	Kind Kind
+	// Major, Minor, Patch and Pre are set for semver versions
+	Major, Minor, Patch int
+	Pre                 string
+	// Ref is the commit or the ci tag of a custom build, and for
+	// commits with a prefix like dev-main-abc1234 the Prefix is dev-main
+	Ref    string
+	Prefix string
+}
+
+// ParseVersionFlexible parses the standard semver versions as well as the
+// versions of custom builds, which are short or full commit hashes with an
+// optional prefix like dev-main-abc1234, or ci-<tag> strings
+func ParseVersionFlexible(v string) (FlexibleVersion, error) {
+	v = strings.TrimSpace(v)
+	if m := semverRegex.FindStringSubmatch(v); m != nil {
+		ver := FlexibleVersion{Kind: KindSemver, Pre: m[4]}
+		// the groups only match digits, overflow is the only error
+		var err error
+		for i, p := range []*int{&ver.Major, &ver.Minor, &ver.Patch} {
+			*p, err = strconv.Atoi(m[i+1])
+			if err != nil {
+				return FlexibleVersion{}, errors.Wrapf(err, "invalid version %q", v)
+			}
+		}
+		return ver, nil
+	}
+	if m := ciRegex.FindStringSubmatch(v); m != nil {
+		return FlexibleVersion{Kind: KindCI, Ref: m[1]}, nil
+	}
+	lower := strings.ToLower(v)
+	if commitRegex.MatchString(lower) {
+		return FlexibleVersion{Kind: KindCommit, Ref: lower}, nil
+	}
+	if i := strings.LastIndex(lower, "-"); i > 0 && commitRegex.MatchString(lower[i+1:]) {
+		return FlexibleVersion{Kind: KindCommit, Prefix: lower[:i], Ref: lower[i+1:]}, nil
+	}
+	return FlexibleVersion{}, errors.Errorf(
+		"invalid version %q, expected a semver, a commit hash or a ci-<tag> version", v,
+	)
+}
+
+// IsCustom returns true if the version is not a release version
+func (v FlexibleVersion) IsCustom() bool {
+	return v.Kind != KindSemver
+}
+
+// String returns the normalized version, which is the same
+// for the different spellings of a version like v3.0.0 and 3.0.0
+func (v FlexibleVersion) String() string {
+	switch v.Kind {
+	case KindSemver:
+		s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+		if v.Pre != "" {
+			s += "-" + v.Pre
+		}
+		return s
+	case KindCI:
+		return "ci-" + v.Ref
+	}
+	if v.Prefix != "" {
+		return v.Prefix + "-" + v.Ref
+	}
+	return v.Ref
+}
+
+// Equal returns true if both the versions are the same build. Commits
+// are compared by their hash, where a short hash matches the full hash.
+func (v FlexibleVersion) Equal(o FlexibleVersion) bool {
+	if v.Kind != o.Kind {
+		return false
+	}
+	if v.Kind == KindCommit {
+		return strings.HasPrefix(v.Ref, o.Ref) || strings.HasPrefix(o.Ref, v.Ref)
+	}
+	return v.String() == o.String()
+}
+
+// Compare returns -1, 0 or 1 if the semver version v is older, the same
+// or newer than o. Custom builds have no order, so comparing them returns
+// an error unless they are equal.
+func (v FlexibleVersion) Compare(o FlexibleVersion) (int, error) {
+	if v.Equal(o) {
+		return 0, nil
+	}
+	if v.IsCustom() || o.IsCustom() {
+		return 0, errors.Errorf("versions %s and %s of custom builds cannot be ordered", v, o)
+	}
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1, nil
+		}
+		if d > 0 {
+			return 1, nil
+		}
+	}
+	// a pre-release is older than the release
+	switch {
+	case v.Pre == "":
+		return 1, nil
+	case o.Pre == "":
+		return -1, nil
+	case v.Pre < o.Pre:
+		return -1, nil
+	}
+	return 1, nil
+}
+
+// IsCustomUpgradePathValid verifies the upgrade from and to versions when
+// either of them is a custom build. The compatibility of custom builds
+// is not known, so only the versions are parsed and they must differ.
+func IsCustomUpgradePathValid(from, to string) error {
+	fromVersion, err := ParseVersionFlexible(from)
+	if err != nil {
+		return errors.Wrapf(err, "invalid from version")
+	}
+	toVersion, err := ParseVersionFlexible(to)
+	if err != nil {
+		return errors.Wrapf(err, "invalid to version")
+	}
+	if !fromVersion.IsCustom() && !toVersion.IsCustom() {
+		return errors.Errorf("from version %s and to version %s are not custom versions", from, to)
+	}
+	if fromVersion.Equal(toVersion) {
+		return errors.Errorf("from version %s is the same as to version %s", from, to)
+	}
+	return nil
+}
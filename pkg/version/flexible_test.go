@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import "testing"
+
+func TestParseVersionFlexible(t *testing.T) {
+	tests := []struct {
+		version  string
+		wantKind Kind
+		want     string
+		wantErr  bool
+	}{
+		{version: "3.0.0", wantKind: KindSemver, want: "3.0.0"},
+		{version: "v2.12.0", wantKind: KindSemver, want: "2.12.0"},
+		{version: "3.0.0-RC1", wantKind: KindSemver, want: "3.0.0-RC1"},
+		{version: "abc1234", wantKind: KindCommit, want: "abc1234"},
+		{version: "ABC1234DEF", wantKind: KindCommit, want: "abc1234def"},
+		{version: "dev-main-abc1234", wantKind: KindCommit, want: "dev-main-abc1234"},
+		{version: "ci-1234", wantKind: KindCI, want: "ci-1234"},
+		{version: "ci-", wantErr: true},
+		{version: "abc12", wantErr: true},
+		{version: "dev-main", wantErr: true},
+		{version: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := ParseVersionFlexible(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVersionFlexible() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Kind != tt.wantKind || got.String() != tt.want {
+				t.Errorf("ParseVersionFlexible() = %s %s, want %s %s", got.Kind, got, tt.wantKind, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlexibleVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		want    int
+		wantErr bool
+	}{
+		{a: "2.12.0", b: "3.0.0", want: -1},
+		{a: "3.0.1", b: "3.0.0", want: 1},
+		{a: "v3.0.0", b: "3.0.0", want: 0},
+		{a: "3.0.0-RC1", b: "3.0.0", want: -1},
+		{a: "abc1234", b: "dev-main-abc1234def", want: 0},
+		{a: "abc1234", b: "3.0.0", wantErr: true},
+		{a: "ci-1", b: "ci-2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			a, _ := ParseVersionFlexible(tt.a)
+			b, _ := ParseVersionFlexible(tt.b)
+			got, err := a.Compare(b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Compare() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCustomUpgradePathValid(t *testing.T) {
+	tests := []struct {
+		from, to string
+		wantErr  bool
+	}{
+		{from: "2.12.0", to: "dev-main-abc1234"},
+		{from: "ci-1234", to: "3.0.0"},
+		{from: "abc1234", to: "def5678"},
+		{from: "2.12.0", to: "3.0.0", wantErr: true},
+		{from: "abc1234", to: "abc1234def", wantErr: true},
+		{from: "2.12.0", to: "custom", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" "+tt.to, func(t *testing.T) {
+			err := IsCustomUpgradePathValid(tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IsCustomUpgradePathValid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
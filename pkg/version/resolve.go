@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var minorRegex = regexp.MustCompile(`^v?(\d+)\.(\d+)$`)
+
+// IsMinorVersion returns true if the version only has
+// the major and minor parts, like 3.1 or v3.1
+func IsMinorVersion(v string) bool {
+	return minorRegex.MatchString(strings.TrimSpace(v))
+}
+
+// KnownReleases returns the release versions known to this build,
+// which are the supported current versions and the desired version
+func KnownReleases() []string {
+	releases := []string{}
+	for v := range validCurrentVersions {
+		releases = append(releases, v)
+	}
+	if validDesiredVersion != "" && !validCurrentVersions[validDesiredVersion] {
+		releases = append(releases, validDesiredVersion)
+	}
+	sort.Strings(releases)
+	return releases
+}
+
+// ResolveMinorVersion resolves a minor-only version like 3.1 to the latest
+// patch of that minor line in the given releases, like 3.1.2. Any other
+// version is returned unchanged. The pre-releases are only resolved to if
+// the minor line has no final release, in which case there must be exactly
+// one, as it is ambiguous which pre-release is meant otherwise.
+func ResolveMinorVersion(v string, releases []string) (string, error) {
+	m := minorRegex.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return v, nil
+	}
+	if len(releases) == 0 {
+		return "", errors.Errorf("failed to resolve version %s: the list of known releases is unavailable", v)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	var latest *FlexibleVersion
+	pre := map[string]bool{}
+	for _, r := range releases {
+		rv, err := ParseVersionFlexible(r)
+		if err != nil || rv.IsCustom() || rv.Major != major || rv.Minor != minor {
+			continue
+		}
+		if rv.Pre != "" {
+			pre[rv.String()] = true
+			continue
+		}
+		if latest == nil || rv.Patch > latest.Patch {
+			parsed := rv
+			latest = &parsed
+		}
+	}
+	if latest != nil {
+		return latest.String(), nil
+	}
+	switch len(pre) {
+	case 0:
+		return "", errors.Errorf("failed to resolve version %s: no known release of %d.%d", v, major, minor)
+	case 1:
+		for p := range pre {
+			return p, nil
+		}
+	}
+	candidates := []string{}
+	for p := range pre {
+		candidates = append(candidates, p)
+	}
+	sort.Strings(candidates)
+	return "", errors.Errorf("failed to resolve version %s: ambiguous between pre-releases %s, specify the full version",
+		v, strings.Join(candidates, ", "))
+}
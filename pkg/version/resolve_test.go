@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import "testing"
+
+func TestResolveMinorVersion(t *testing.T) {
+	releases := []string{"3.0.0", "3.1.0", "3.1.2", "3.1.1", "v3.2.0-RC1", "3.3.0-RC1", "3.3.0-RC2", "dev-main-abc1234"}
+	tests := []struct {
+		version  string
+		releases []string
+		want     string
+		wantErr  bool
+	}{
+		{version: "3.1.0", releases: releases, want: "3.1.0"},
+		{version: "3.1", releases: releases, want: "3.1.2"},
+		{version: "v3.0", releases: releases, want: "3.0.0"},
+		{version: "3.2", releases: releases, want: "3.2.0-RC1"},
+		{version: "3.3", releases: releases, wantErr: true},
+		{version: "3.4", releases: releases, wantErr: true},
+		{version: "3.1", wantErr: true},
+		{version: "3.1.0", want: "3.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := ResolveMinorVersion(tt.version, tt.releases)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveMinorVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveMinorVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMinorVersion(t *testing.T) {
+	tests := map[string]bool{
+		"3.1":       true,
+		"v3.1":      true,
+		" 3.1 ":     true,
+		"3.1.0":     false,
+		"3":         false,
+		"3.1-RC1":   false,
+		"dev-3.1.0": false,
+	}
+	for version, want := range tests {
+		if got := IsMinorVersion(version); got != want {
+			t.Errorf("IsMinorVersion(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestKnownReleases(t *testing.T) {
+	releases := KnownReleases()
+	for i := 1; i < len(releases); i++ {
+		if releases[i-1] > releases[i] {
+			t.Fatalf("KnownReleases() = %v, want sorted releases", releases)
+		}
+	}
+	for v := range validCurrentVersions {
+		found := false
+		for _, r := range releases {
+			found = found || r == v
+		}
+		if !found {
+			t.Errorf("KnownReleases() = %v, missing the current version %s", releases, v)
+		}
+	}
+}
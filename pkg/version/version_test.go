@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsVersioned(t *testing.T) {
+	tests := map[string]bool{
+		"cstor-pool-2.12.0": true,
+		"2.12.0":            true,
+		"cstor-pool":        false,
+		"cstor-pool-2.12":   false,
+		"cstor-pool-2.12.x": false,
+	}
+	for given, want := range tests {
+		if got := IsVersioned(given); got != want {
+			t.Errorf("IsVersioned(%q) = %v, want %v", given, got, want)
+		}
+		if got := IsNotVersioned(given); got == want {
+			t.Errorf("IsNotVersioned(%q) = %v, want %v", given, got, !want)
+		}
+	}
+}
+
+func TestWithSuffix(t *testing.T) {
+	defer func(v string) { Version = v }(Version)
+	Version = "3.0.0"
+
+	if got := WithSuffix("cstor-pool"); got != "cstor-pool-3.0.0" {
+		t.Errorf("WithSuffix() = %s, want cstor-pool-3.0.0", got)
+	}
+	if got := WithSuffixIf("cstor-pool-2.12.0", IsNotVersioned); got != "cstor-pool-2.12.0" {
+		t.Errorf("WithSuffixIf() of a versioned name = %s, want it unchanged", got)
+	}
+	if got := WithSuffixIf("cstor-pool", IsNotVersioned); got != "cstor-pool-3.0.0" {
+		t.Errorf("WithSuffixIf() = %s, want cstor-pool-3.0.0", got)
+	}
+	got := WithSuffixesIf([]string{"cstor-pool", "cstor-pool-2.12.0"}, IsNotVersioned)
+	want := []string{"cstor-pool-3.0.0", "cstor-pool-2.12.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WithSuffixesIf() = %v, want %v", got, want)
+	}
+	if got := Current(); got != "3.0.0" {
+		t.Errorf("Current() = %s, want 3.0.0", got)
+	}
+}
+
+func TestGetVersionDetails(t *testing.T) {
+	defer func(v, commit string) { Version, GitCommit = v, commit }(Version, GitCommit)
+	Version, GitCommit = "3.0.0", "0123456789abcdef"
+
+	if got := GetGitCommit(); got != GitCommit {
+		t.Errorf("GetGitCommit() = %s, want %s", got, GitCommit)
+	}
+	if got := GetVersionDetails(); got != "3.0.0-0123456" {
+		t.Errorf("GetVersionDetails() = %s, want 3.0.0-0123456", got)
+	}
+	// without the version set by the compiler, it is read from the VERSION file
+	Version = ""
+	if got := GetVersion(); strings.Contains(got, "\n") {
+		t.Errorf("GetVersion() = %q, want the trimmed version", got)
+	}
+}
+
+func TestIsCurrentVersionValid(t *testing.T) {
+	tests := map[string]bool{
+		"2.12.0":     true,
+		"2.12.0-RC1": true,
+		"3.0.0":      true,
+		"1.9.0":      false,
+		"":           false,
+	}
+	for v, want := range tests {
+		if got := IsCurrentVersionValid(v); got != want {
+			t.Errorf("IsCurrentVersionValid(%q) = %v, want %v", v, got, want)
+		}
+	}
+	if !IsDesiredVersionValid(validDesiredVersion + "-RC1") {
+		t.Errorf("IsDesiredVersionValid(%q) = false, want true", validDesiredVersion+"-RC1")
+	}
+	if IsDesiredVersionValid("0.1.0") {
+		t.Errorf("IsDesiredVersionValid(0.1.0) = true, want false")
+	}
+}